@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/appnexus/ankh/artifact"
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/debug"
+	"github.com/appnexus/ankh/helm"
+	"github.com/appnexus/ankh/util"
+)
+
+// The variables of `artifacts.path` for a chart, besides those of notification formats.
+func artifactVariables(ctx *ankh.ExecutionContext, chart *ankh.Chart, namespace string, now time.Time) map[string]string {
+	envOrContext := util.GetEnvironmentOrContext(ctx.Environment, ctx.Context)
+	variables := util.NotificationVariables(chart, envOrContext, ctx.EffectiveRelease(), ctx.EffectiveDeployer())
+	variables["%ENVIRONMENT%"] = ctx.Environment
+	variables["%CONTEXT%"] = ctx.AnkhConfig.CurrentContextName
+	variables["%CLUSTER%"] = ctx.AnkhConfig.CurrentClusterName
+	variables["%NAMESPACE%"] = namespace
+	variables["%TIMESTAMP%"] = now.UTC().Format("20060102T150405Z")
+	return variables
+}
+
+// The summary of the run stored in each bundle, followed by where the
+// chart was applied.
+func artifactSummary(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile, chart *ankh.Chart, namespace string, now time.Time) string {
+	summary := formatConfirmationSummary(ctx, ankhFile)
+	summary += fmt.Sprintf("\nChart:      %v\n", chart.InstanceName())
+	summary += fmt.Sprintf("Context:    %v\n", ctx.AnkhConfig.CurrentContextName)
+	if ctx.AnkhConfig.CurrentClusterName != "" {
+		summary += fmt.Sprintf("Cluster:    %v\n", ctx.AnkhConfig.CurrentClusterName)
+	}
+	summary += fmt.Sprintf("Namespace:  %v\n", namespace)
+	summary += fmt.Sprintf("Applied by: %v\n", ctx.EffectiveDeployer())
+	summary += fmt.Sprintf("Applied at: %v\n", now.UTC().Format(time.RFC3339))
+	summary += fmt.Sprintf("Ankh:       %v\n", ctx.AnkhVersion)
+	return summary
+}
+
+// Uploads a bundle of the manifest each chart was just applied with, and a
+// summary of the run, to the configured artifact store. Failures are logged,
+// since the charts have already been applied.
+func publishArtifacts(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile, charts []ankh.Chart, namespace string) {
+	config := ctx.AnkhConfig.Artifacts
+	if config.URL == "" || ctx.AppliedManifest == "" {
+		return
+	}
+
+	// Bundles are kept outside of the cluster, so they get the same redaction
+	// as the stages recorded to the data dir.
+	manifests, err := helm.ManifestsByChart(charts, debug.Redact(ctx.AppliedManifest, ctx.SecretValues))
+	if err != nil {
+		ctx.Logger.Errorf("Unable to bundle the applied manifests: %v", err)
+		return
+	}
+	now := time.Now()
+	for i := range charts {
+		chart := &charts[i]
+		manifest, ok := manifests[chart.InstanceName()]
+		if !ok {
+			continue
+		}
+		destination, err := artifact.Destination(config, artifactVariables(ctx, chart, namespace, now))
+		if err == nil {
+			var bundle []byte
+			bundle, err = artifact.Bundle(manifest, artifactSummary(ctx, ankhFile, chart, namespace, now), now)
+			if err == nil {
+				err = artifact.Upload(config, destination, bundle)
+			}
+		}
+		if err != nil {
+			ctx.Logger.Errorf("Unable to upload the manifest of chart \"%v\": %v", chart.InstanceName(), err)
+			continue
+		}
+		ctx.Logger.Infof("Uploaded the manifest of chart \"%v\" to %v", chart.InstanceName(), destination)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/artifact"
+	"github.com/appnexus/ankh/context"
+)
+
+func TestPublishArtifactsRedactsSecrets(t *testing.T) {
+	var uploaded []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploaded, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	ctx := ankhtest.NewContext(t)
+	ctx.Mode = ankh.Apply
+	ctx.AnkhConfig.Artifacts = ankh.ArtifactsConfig{URL: server.URL, Path: "/%CHART_NAME%.tar.gz"}
+	ctx.SecretValues = []string{"s3cr3t"}
+	ctx.AppliedManifest = `apiVersion: v1
+kind: Secret
+metadata:
+  name: api
+stringData:
+  password: hunter2
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: api
+data:
+  DATABASE_URL: postgres://api:s3cr3t@db/api
+`
+	charts := []ankh.Chart{{Name: "api", Version: "1.0.0"}}
+	publishArtifacts(ctx, &ankh.AnkhFile{Charts: charts}, charts, "web")
+
+	gz, err := gzip.NewReader(bytes.NewReader(uploaded))
+	if err != nil {
+		t.Fatalf("expected a bundle to be uploaded but got %v", err)
+	}
+	manifest := ""
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		if header.Name == artifact.ManifestFile {
+			body, _ := ioutil.ReadAll(tr)
+			manifest = string(body)
+		}
+	}
+	if !strings.Contains(manifest, "kind: ConfigMap") {
+		t.Fatalf("expected the manifest in the bundle but got %q", manifest)
+	}
+	if strings.Contains(manifest, "hunter2") || strings.Contains(manifest, "s3cr3t") {
+		t.Logf("expected secret values to be redacted from the bundle but got %v", manifest)
+		t.Fail()
+	}
+}
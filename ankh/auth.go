@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/kubectl"
+	ankhlib "github.com/appnexus/ankh/pkg/ankh"
+)
+
+// Returns the contexts to check the credentials of: those of the environment,
+// if one was given, or else the current context.
+func authContexts(ctx *ankh.ExecutionContext) ([]string, error) {
+	if ctx.Environment != "" {
+		environment, ok := ctx.AnkhConfig.Environments[ctx.Environment]
+		if !ok {
+			return nil, fmt.Errorf("Environment '%v' not found in `environments`", ctx.Environment)
+		}
+		return environment.Contexts, nil
+	}
+	if ctx.AnkhConfig.CurrentContextName == "" {
+		return nil, fmt.Errorf("No context or environment provided. Provide one using -c/--context or -e/--environment")
+	}
+	return []string{ctx.AnkhConfig.CurrentContextName}, nil
+}
+
+// Checks that kubectl can authenticate to the current context, or cluster,
+// with `kubectl auth can-i`. kubectl's verbose log is shown when it fails,
+// or with --verbose. Returns whether kubectl could answer.
+func checkKubeAuth(ctx *ankh.ExecutionContext, target string, verb string, resource string) bool {
+	namespace := ""
+	if ctx.Namespace != nil {
+		namespace = *ctx.Namespace
+	}
+
+	answer, kubectlLog, err := kubectl.CanI(ctx, namespace, verb, resource)
+	if err != nil || ctx.Verbose {
+		for _, line := range strings.Split(strings.TrimRight(kubectlLog, "\n"), "\n") {
+			fmt.Fprintf(os.Stderr, "  | %v\n", line)
+		}
+	}
+	switch {
+	case err != nil:
+		ctx.Logger.Errorf("%v: kubectl could not authenticate. See its log above", target)
+		return false
+	case answer == "yes":
+		ctx.Logger.Infof("%v: authenticated, and allowed to %v %v", target, verb, resource)
+	default:
+		ctx.Logger.Warnf("%v: authenticated, but not allowed to %v %v", target, verb, resource)
+	}
+	return true
+}
+
+// Checks the credentials of each context, and each cluster of a context with
+// `clusters`. Returns the number that kubectl could not authenticate to.
+func checkAuth(ctx *ankh.ExecutionContext, contexts []string, verb string, resource string) int {
+	failures := 0
+	for _, name := range contexts {
+		target := fmt.Sprintf("Context \"%v\"", name)
+		if err := ankhlib.UseContext(ctx, &ctx.AnkhConfig, name); err != nil {
+			ctx.Logger.Errorf("%v: %v", target, err)
+			failures++
+			continue
+		}
+
+		clusters := ctx.AnkhConfig.CurrentContext.Clusters
+		if len(clusters) == 0 {
+			if !checkKubeAuth(ctx, target, verb, resource) {
+				failures++
+			}
+			continue
+		}
+		for _, cluster := range clusters {
+			clusterTarget := fmt.Sprintf("%v, cluster \"%v\"", target, cluster.Name)
+			if err := ctx.AnkhConfig.UseCluster(ctx, cluster); err != nil {
+				ctx.Logger.Errorf("%v: %v", clusterTarget, err)
+				failures++
+			} else if !checkKubeAuth(ctx, clusterTarget, verb, resource) {
+				failures++
+			}
+		}
+	}
+	return failures
+}
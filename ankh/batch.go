@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/util"
+)
+
+// An entry read by `ankh batch`: a chart, with an optional version, tag and namespace.
+type batchEntry struct {
+	Line      int
+	Chart     string
+	Tag       string
+	Namespace string
+}
+
+type batchOpts struct {
+	DryRun    bool
+	SkipCrds  bool
+	Wait      bool
+	Timeout   string
+	Filters   []string
+	KeepGoing bool
+}
+
+// Parses newline-delimited `chart[@version] [tag] [namespace]` entries.
+// Columns are separated by whitespace, and `-` leaves a column unset, eg: to
+// give a namespace without a tag. Blank lines and lines starting with `#` are
+// skipped.
+func parseBatch(r io.Reader) ([]batchEntry, error) {
+	entries := []batchEntry{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("Line %v: expected `chart[@version] [tag] [namespace]` but got %v columns", line, len(fields))
+		}
+		for len(fields) < 3 {
+			fields = append(fields, "-")
+		}
+		if fields[0] == "-" {
+			return nil, fmt.Errorf("Line %v: missing a chart", line)
+		}
+
+		entry := batchEntry{Line: line, Chart: fields[0]}
+		if fields[1] != "-" {
+			entry.Tag = fields[1]
+		}
+		if fields[2] != "-" {
+			entry.Namespace = fields[2]
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Returns the arguments to apply a batch entry with a separate ankh process,
+// with the same global options as this one. The tag and namespace of the
+// entry take precedence over global ones.
+func batchArgs(ctx *ankh.ExecutionContext, entry batchEntry, opts batchOpts) []string {
+	args := []string{}
+	global := devGlobalArgs(ctx)
+	for i := 0; i < len(global); i++ {
+		switch {
+		case global[i] == "--tag" && entry.Tag != "":
+			i++
+		case global[i] == "--namespace" && entry.Namespace != "" && !strings.Contains(global[i+1], "="):
+			i++
+		case global[i] == "--no-prompt":
+		default:
+			args = append(args, global[i])
+		}
+	}
+
+	// Stdin holds the batch, so there is no one to answer prompts.
+	args = append(args, "--no-prompt")
+	if entry.Tag != "" {
+		args = append(args, "--tag", entry.Tag)
+	}
+	if entry.Namespace != "" {
+		args = append(args, "--namespace", entry.Namespace)
+	}
+
+	args = append(args, "apply", "--chart", entry.Chart)
+	if opts.DryRun {
+		args = append(args, "--dry-run")
+	}
+	if opts.SkipCrds {
+		args = append(args, "--skip-crds")
+	}
+	if opts.Wait {
+		args = append(args, "--wait")
+	}
+	if opts.Timeout != "" {
+		args = append(args, "--timeout", opts.Timeout)
+	}
+	for _, filter := range opts.Filters {
+		args = append(args, "--filter", filter)
+	}
+	return args
+}
+
+// Applies each entry in turn, writing a tab separated status line for each
+// to `status`: the line number, the chart, `ok`, `failed` or `skipped`, and
+// how long the apply took or why it failed. The output of each apply goes to
+// stderr. Unless `KeepGoing` is set, entries after the first failure are
+// skipped. Returns the number of entries that did not succeed.
+func runBatch(ctx *ankh.ExecutionContext, entries []batchEntry, opts batchOpts, status io.Writer) int {
+	failures := 0
+	for _, entry := range entries {
+		if failures > 0 && !opts.KeepGoing {
+			fmt.Fprintf(status, "%v\t%v\tskipped\t\n", entry.Line, entry.Chart)
+			failures++
+			continue
+		}
+
+		cmd := exec.Command(util.AnkhExecutable(), batchArgs(ctx, entry, opts)...)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+
+		start := time.Now()
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(status, "%v\t%v\tfailed\t%v\n", entry.Line, entry.Chart, err)
+			failures++
+			continue
+		}
+		fmt.Fprintf(status, "%v\t%v\tok\t%v\n", entry.Line, entry.Chart, time.Since(start).Round(time.Second))
+	}
+	return failures
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/context"
+)
+
+func TestParseBatch(t *testing.T) {
+	entries, err := parseBatch(strings.NewReader("# base image rebuild\nredis@1.2.3\n\nweb@2.0.0 abc123\napi - team-api\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []batchEntry{
+		{Line: 2, Chart: "redis@1.2.3"},
+		{Line: 4, Chart: "web@2.0.0", Tag: "abc123"},
+		{Line: 5, Chart: "api", Namespace: "team-api"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %v entries but got %+v", len(expected), entries)
+	}
+	for i := range expected {
+		if entries[i] != expected[i] {
+			t.Logf("expected %+v but got %+v", expected[i], entries[i])
+			t.Fail()
+		}
+	}
+
+	if _, err := parseBatch(strings.NewReader("web 1.0 team extra\n")); err == nil {
+		t.Logf("expected an error for too many columns")
+		t.Fail()
+	}
+}
+
+func TestBatchArgs(t *testing.T) {
+	tag := "global-tag"
+	namespace := "global"
+	ctx := &ankh.ExecutionContext{
+		AnkhConfigPath:  "/config",
+		KubeConfigPath:  "/kubeconfig",
+		DataDir:         "/data/run",
+		Context:         "minikube",
+		Tag:             &tag,
+		Namespace:       &namespace,
+		ChartNamespaces: map[string]string{"db": "data"},
+	}
+
+	args := batchArgs(ctx, batchEntry{Chart: "web@2.0.0", Tag: "abc123"}, batchOpts{DryRun: true, Filters: []string{"Deployment"}})
+	expected := "--ankhconfig /config --kubeconfig /kubeconfig --datadir /data --context minikube " +
+		"--namespace global --namespace db=data --no-prompt --tag abc123 " +
+		"apply --chart web@2.0.0 --dry-run --filter Deployment"
+	if strings.Join(args, " ") != expected {
+		t.Logf("expected `%v` but got `%v`", expected, strings.Join(args, " "))
+		t.Fail()
+	}
+
+	args = batchArgs(ctx, batchEntry{Chart: "api", Namespace: "team-api"}, batchOpts{})
+	expected = "--ankhconfig /config --kubeconfig /kubeconfig --datadir /data --context minikube " +
+		"--namespace db=data --tag global-tag --no-prompt --namespace team-api apply --chart api"
+	if strings.Join(args, " ") != expected {
+		t.Logf("expected `%v` but got `%v`", expected, strings.Join(args, " "))
+		t.Fail()
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/helm"
+	"github.com/appnexus/ankh/kubectl"
+	ankhlib "github.com/appnexus/ankh/pkg/ankh"
+	"github.com/appnexus/ankh/plan"
+	"github.com/appnexus/ankh/util"
+)
+
+const defaultCanaryHealthCheckTimeout = time.Minute
+
+// How often a canary's health check is retried until it passes.
+var canaryHealthCheckInterval = 5 * time.Second
+
+// Returns the charts with a canary phase configured under `deploy.canary`.
+func canaryCharts(charts []ankh.Chart) []ankh.Chart {
+	canaries := []ankh.Chart{}
+	for _, chart := range charts {
+		if chart.ChartMeta.Deploy.Canary != nil {
+			canaries = append(canaries, chart)
+		}
+	}
+	return canaries
+}
+
+func canaryHealthCheckTimeout(chart ankh.Chart) (time.Duration, error) {
+	canary := chart.ChartMeta.Deploy.Canary
+	if canary.HealthCheckTimeout == "" {
+		return defaultCanaryHealthCheckTimeout, nil
+	}
+	timeout, err := time.ParseDuration(canary.HealthCheckTimeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("Invalid `deploy.canary.healthCheckTimeout` \"%v\" for chart \"%v\", expected a duration, eg: 2m",
+			canary.HealthCheckTimeout, chart.InstanceName())
+	}
+	return timeout, nil
+}
+
+func validateCanary(chart ankh.Chart) error {
+	canary := chart.ChartMeta.Deploy.Canary
+	if canary.Replicas == nil && canary.Values == "" {
+		return fmt.Errorf("The canary of chart \"%v\" must set `deploy.canary.replicas` or `deploy.canary.values`", chart.InstanceName())
+	}
+	if canary.Replicas != nil && *canary.Replicas < 0 {
+		return fmt.Errorf("Invalid `deploy.canary.replicas` %v for chart \"%v\", expected zero or more", *canary.Replicas, chart.InstanceName())
+	}
+	_, err := canaryHealthCheckTimeout(chart)
+	return err
+}
+
+// Polls the canary's health check until it responds with a 2xx status, or
+// until its timeout passes.
+func checkCanaryHealth(ctx *ankh.ExecutionContext, chart ankh.Chart, namespace string) error {
+	canary := chart.ChartMeta.Deploy.Canary
+	if canary.HealthCheckURL == "" {
+		return nil
+	}
+	timeout, err := canaryHealthCheckTimeout(chart)
+	if err != nil {
+		return err
+	}
+	url := strings.NewReplacer("${ANKH_NAMESPACE}", namespace,
+		"${ANKH_CONTEXT}", ctx.AnkhConfig.CurrentContextName).Replace(canary.HealthCheckURL)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	deadline := time.Now().Add(timeout)
+	ctx.Logger.Infof("Checking the health of the canary of chart \"%v\" at %v...", chart.InstanceName(), url)
+	for {
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("received HTTP status '%v'", resp.Status)
+		}
+		if time.Now().Add(canaryHealthCheckInterval).After(deadline) {
+			return fmt.Errorf("The canary of chart \"%v\" did not pass its health check at %v within %v: %v",
+				chart.InstanceName(), url, timeout, err)
+		}
+		ctx.Logger.Debugf("Health check of the canary of chart \"%v\" failed, retrying: %v", chart.InstanceName(), err)
+		time.Sleep(canaryHealthCheckInterval)
+	}
+}
+
+// Applies the canary of each chart that has one, then watches pods, checks
+// health and prompts before the full rollout. Returns false if the rollout
+// should not proceed.
+func deployCanary(ctx *ankh.ExecutionContext, charts []ankh.Chart, namespace string, wildCardLabels []string) (bool, error) {
+	canaries := canaryCharts(charts)
+	for _, chart := range canaries {
+		if err := validateCanary(chart); err != nil {
+			return false, err
+		}
+	}
+
+	ctx.Canary = true
+	_, err := ankhlib.ExecutePlan(ctx, namespace, wildCardLabels, &plan.Plan{
+		PlanStages: []plan.PlanStage{
+			plan.PlanStage{Stage: helm.NewTemplateStage(canaries)},
+			plan.PlanStage{Stage: helm.NewCanaryStage(canaries)},
+			plan.PlanStage{Stage: helm.NewAnnotateStage(canaries)},
+			plan.PlanStage{Stage: kubectl.NewApplyStage(), Opts: plan.StageOpts{
+				PreExecute: func() bool {
+					ctx.Logger.Infof("Applying canary...")
+					return true
+				},
+				PassThroughInput: true,
+			}},
+			plan.PlanStage{Stage: kubectl.NewPodStage(), Opts: plan.StageOpts{
+				PreExecute: func() bool {
+					if ctx.DryRun {
+						return false
+					}
+					ctx.Logger.Infof("Watching canary pods... (press control-C to stop watching and continue)")
+					ctx.ExtraArgs = append(ctx.ExtraArgs, "-w")
+					ctx.ShouldCatchSignals = true
+					return true
+				},
+				PassThroughInput: true,
+			}},
+		},
+	})
+	ctx.Canary = false
+	ctx.ShouldCatchSignals = false
+	ctx.ExtraArgs = []string{}
+	if err != nil {
+		return false, err
+	}
+
+	if ctx.DryRun {
+		ctx.Logger.Infof("Not checking the health of canaries, since nothing is applied on a dry run")
+		return true, nil
+	}
+
+	healthy := true
+	for _, chart := range canaries {
+		if err := checkCanaryHealth(ctx, chart, namespace); err != nil {
+			ctx.Logger.Warnf("%v", err)
+			healthy = false
+		}
+	}
+
+	if ctx.NoPrompt {
+		if !healthy {
+			ctx.Logger.Errorf("Not proceeding with the full rollout, since a canary is unhealthy")
+		}
+		return healthy, nil
+	}
+	selection, err := util.PromptForConfirmation([]string{"Abort", "Proceed"},
+		"Canary applied. Select Proceed to continue with the full rollout.")
+	check(err)
+	return selection == "Proceed", nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+func TestValidateCanary(t *testing.T) {
+	negative := -1
+	for _, canary := range []ankh.CanaryConfig{
+		{},
+		{Replicas: &negative},
+		{Values: "values-canary.yaml", HealthCheckTimeout: "soon"},
+	} {
+		c := canary
+		if err := validateCanary(ankh.Chart{Name: "api", ChartMeta: ankh.ChartMeta{Deploy: ankh.DeployMeta{Canary: &c}}}); err == nil {
+			t.Logf("expected canary %+v to be invalid", canary)
+			t.Fail()
+		}
+	}
+}
+
+func TestCheckCanaryHealth(t *testing.T) {
+	canaryHealthCheckInterval = time.Millisecond
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/web/health" || requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	ctx := ankhtest.NewContext(t)
+	chart := ankh.Chart{Name: "api", ChartMeta: ankh.ChartMeta{Deploy: ankh.DeployMeta{Canary: &ankh.CanaryConfig{
+		HealthCheckURL: server.URL + "/${ANKH_NAMESPACE}/health",
+	}}}}
+	if err := checkCanaryHealth(ctx, chart, "web"); err != nil || requests != 3 {
+		t.Logf("expected the health check to be retried until it passed but got %v after %v requests", err, requests)
+		t.Fail()
+	}
+
+	chart.ChartMeta.Deploy.Canary.HealthCheckTimeout = "20ms"
+	if err := checkCanaryHealth(ctx, chart, "data"); err == nil {
+		t.Logf("expected a failing health check to time out")
+		t.Fail()
+	}
+}
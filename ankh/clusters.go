@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/appnexus/ankh/context"
+)
+
+const (
+	clusterSucceeded    = "succeeded"
+	clusterFailed       = "failed"
+	clusterNotAttempted = "not attempted"
+)
+
+// How each cluster of a multi-cluster context fared.
+type clusterReport struct {
+	context  string
+	clusters []string
+	statuses map[string]string
+}
+
+func newClusterReport(context string, clusters []ankh.Cluster) *clusterReport {
+	report := &clusterReport{context: context, statuses: make(map[string]string)}
+	for _, cluster := range clusters {
+		report.clusters = append(report.clusters, cluster.Name)
+		report.statuses[cluster.Name] = clusterNotAttempted
+	}
+	return report
+}
+
+func (report *clusterReport) String() string {
+	buf := bytes.NewBufferString("")
+	fmt.Fprintf(buf, "Clusters of context \"%v\":\n", report.context)
+	w := tabwriter.NewWriter(buf, 0, 8, 4, ' ', 0)
+	for _, name := range report.clusters {
+		fmt.Fprintf(w, "  %v\t%v\n", name, report.statuses[name])
+	}
+	w.Flush()
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+// Repeats the operation on every cluster of the current context, if it has
+// clusters, so that they all receive identical manifests. The status of each
+// cluster is reported at the end, or as soon as one of them fails.
+func executeContextOnClusters(ctx *ankh.ExecutionContext, rootAnkhFile *ankh.AnkhFile) {
+	clusters := ctx.AnkhConfig.CurrentContext.Clusters
+	if len(clusters) == 0 {
+		executeContext(ctx, rootAnkhFile)
+		return
+	}
+
+	contextName := ctx.AnkhConfig.CurrentContextName
+	report := newClusterReport(contextName, clusters)
+
+	// Fatal errors exit the process directly, so report on the way out.
+	finished := false
+	onErrorExit("clusters", func() {
+		if !finished {
+			log.Errorf("%v", report)
+		}
+	})
+
+	kubeConfigPath := ctx.KubeConfigPath
+	for _, cluster := range clusters {
+		log.Infof("Beginning to operate on cluster \"%v\" of context \"%v\"", cluster.Name, contextName)
+
+		// Stays failed if the operation exits with a fatal error.
+		report.statuses[cluster.Name] = clusterFailed
+
+		ctx.KubeConfigPath = kubeConfigPath
+		check(ctx.AnkhConfig.UseCluster(ctx, cluster))
+		executeContext(ctx, rootAnkhFile)
+
+		report.statuses[cluster.Name] = clusterSucceeded
+		log.Infof("Finished with cluster \"%v\" of context \"%v\"", cluster.Name, contextName)
+	}
+
+	finished = true
+	ctx.AnkhConfig.CurrentClusterName = ""
+	ctx.KubeConfigPath = kubeConfigPath
+	log.Infof("%v", report)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/helm"
+	ankhlib "github.com/appnexus/ankh/pkg/ankh"
+)
+
+// Completion scripts run `ankh __complete <kind>` for the values to complete.
+// It is handled before the command line is parsed, so that it is left out of
+// the help.
+const completeCommand = "__complete"
+
+// The commands that complete after `ankh`, leaving out internal ones.
+var completionCommands = []string{
+	"apply", "batch", "chart", "completion", "config", "debug", "delete", "deploy", "dev", "diff", "exec",
+	"explain", "get", "graph", "history", "image", "init", "lint", "local", "logs", "pipeline", "plan",
+	"pods", "port-forward", "promote", "releases", "replay", "report", "rollback", "scale", "secrets",
+	"self-update", "serve", "stats", "template", "version",
+}
+
+const bashCompletion = `# bash completion for ankh. Load it with: source <(ankh completion bash)
+_ankh() {
+    local cur prev kind word i commands
+    COMPREPLY=()
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+    case "$prev" in
+        -c|--context) kind=contexts ;;
+        -e|--environment) kind=environments ;;
+        -n|--namespace) kind=namespaces ;;
+        --chart) kind=charts ;;
+    esac
+    if [ -z "$kind" ] && [[ "$cur" != -* ]]; then
+        kind=commands
+        commands=" $(ankh __complete commands 2>/dev/null | tr '\n' ' ') "
+        for ((i=1; i < COMP_CWORD; i++)); do
+            word="${COMP_WORDS[i]}"
+            case "$word" in
+                -c|--context|-e|--environment|-n|--namespace|--chart) i=$((i+1)); continue ;;
+            esac
+            if [[ "$word" != -* ]] && [[ "$commands" == *" $word "* ]]; then
+                kind=
+                break
+            fi
+        done
+    fi
+    if [ -n "$kind" ]; then
+        local IFS=$'\n'
+        COMPREPLY=($(compgen -W "$(ankh __complete "$kind" 2>/dev/null)" -- "$cur"))
+    fi
+}
+complete -o default -F _ankh ankh
+`
+
+const zshCompletion = `#compdef ankh
+# zsh completion for ankh. Load it with: source <(ankh completion zsh)
+_ankh() {
+    local kind word skip
+    case "${words[CURRENT-1]}" in
+        -c|--context) kind=contexts ;;
+        -e|--environment) kind=environments ;;
+        -n|--namespace) kind=namespaces ;;
+        --chart) kind=charts ;;
+    esac
+    if [[ -z "$kind" && "${words[CURRENT]}" != -* ]]; then
+        local -a commands
+        commands=(${(f)"$(ankh __complete commands 2>/dev/null)"})
+        kind=commands
+        for word in ${words[2,CURRENT-1]}; do
+            if [[ -n "$skip" ]]; then
+                skip=
+                continue
+            fi
+            case "$word" in
+                -c|--context|-e|--environment|-n|--namespace|--chart) skip=1; continue ;;
+            esac
+            if (( ${commands[(Ie)$word]} )); then
+                kind=
+                break
+            fi
+        done
+    fi
+    if [[ -n "$kind" ]]; then
+        local -a values
+        values=(${(f)"$(ankh __complete $kind 2>/dev/null)"})
+        compadd -a values
+    else
+        _files
+    fi
+}
+if [[ "$funcstack[1]" == "_ankh" ]]; then
+    _ankh "$@"
+else
+    compdef _ankh ankh
+fi
+`
+
+const fishCompletion = `# fish completion for ankh. Load it with: ankh completion fish | source
+complete -c ankh -n '__fish_use_subcommand' -f -a '(ankh __complete commands 2>/dev/null)'
+complete -c ankh -s c -l context -x -a '(ankh __complete contexts 2>/dev/null)'
+complete -c ankh -s e -l environment -x -a '(ankh __complete environments 2>/dev/null)'
+complete -c ankh -s n -l namespace -x -a '(ankh __complete namespaces 2>/dev/null)'
+complete -c ankh -l chart -x -a '(ankh __complete charts 2>/dev/null)'
+`
+
+func completionScript(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return bashCompletion, nil
+	case "zsh":
+		return zshCompletion, nil
+	case "fish":
+		return fishCompletion, nil
+	default:
+		return "", fmt.Errorf("Unknown shell \"%v\", expected one of `bash`, `zsh` or `fish`", shell)
+	}
+}
+
+// Chart names complete from the Ankh file in the current directory, if there
+// is one, or else from the Helm repository's index.
+func completeCharts(ctx *ankh.ExecutionContext) ([]string, error) {
+	if ankhFile, err := ankh.ParseAnkhFile("ankh.yaml"); err == nil && len(ankhFile.Charts) > 0 {
+		names := []string{}
+		for _, chart := range ankhFile.Charts {
+			names = append(names, chart.InstanceName())
+		}
+		return names, nil
+	}
+
+	repository, err := ctx.DetermineHelmRepository(nil)
+	if err != nil {
+		return []string{}, nil
+	}
+	return helm.GetChartNames(ctx, repository)
+}
+
+// Returns the values that complete a kind of argument, eg: `contexts`.
+func completionValues(ctx *ankh.ExecutionContext, kind string) ([]string, error) {
+	switch kind {
+	case "commands":
+		return completionCommands, nil
+	case "contexts":
+		contexts := []string{}
+		for name := range ctx.AnkhConfig.Contexts {
+			contexts = append(contexts, name)
+		}
+		sort.Strings(contexts)
+		return contexts, nil
+	case "environments":
+		environments := []string{}
+		for name := range ctx.AnkhConfig.Environments {
+			environments = append(environments, name)
+		}
+		sort.Strings(environments)
+		return environments, nil
+	case "namespaces":
+		namespaces := append([]string{}, ctx.AnkhConfig.Namespaces...)
+		sort.Strings(namespaces)
+		return namespaces, nil
+	case "charts":
+		return completeCharts(ctx)
+	default:
+		return nil, fmt.Errorf("Unknown kind of completion \"%v\"", kind)
+	}
+}
+
+// Prints the values that complete a kind of argument, one per line, and
+// returns the exit code. Completion must be quick and quiet, so nothing is
+// logged, and errors only change the exit code.
+func runComplete(args []string) int {
+	if len(args) != 1 {
+		return 1
+	}
+
+	log.SetLevel(logrus.PanicLevel)
+	configPath := os.Getenv("ANKHCONFIG")
+	if configPath == "" {
+		configPath = path.Join(os.Getenv("HOME"), ".ankh", "config")
+	}
+	dataDir := os.Getenv("ANKH_DATADIR")
+	if dataDir == "" {
+		dataDir = path.Join("/tmp", ".ankh", "data")
+	}
+	ctx := &ankh.ExecutionContext{
+		Logger:         log,
+		AnkhConfigPath: configPath,
+		// Every completion shares a data dir, rather than leaving one behind each time.
+		DataDir:             path.Join(dataDir, "completion"),
+		IgnoreContextAndEnv: true,
+		IgnoreConfigErrors:  true,
+		NoPrompt:            true,
+	}
+
+	if args[0] != "commands" {
+		ankhConfig, err := ankhlib.LoadConfig(ctx, ctx.AnkhConfigPath)
+		if err != nil {
+			return 1
+		}
+		ctx.AnkhConfig = ankhConfig
+	}
+
+	values, err := completionValues(ctx, args[0])
+	if err != nil {
+		return 1
+	}
+	if len(values) > 0 {
+		fmt.Println(strings.Join(values, "\n"))
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+)
+
+func TestCompletionCommands(t *testing.T) {
+	source, err := ioutil.ReadFile("main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{}
+	for _, match := range regexp.MustCompile(`\bapp\.Command\("([^"]+)"`).FindAllStringSubmatch(string(source), -1) {
+		if match[1] != "diff-filter" {
+			expected = append(expected, match[1])
+		}
+	}
+	sort.Strings(expected)
+	if fmt.Sprint(completionCommands) != fmt.Sprint(expected) {
+		t.Logf("expected completion of the commands %v but got %v", expected, completionCommands)
+		t.Fail()
+	}
+}
+
+func TestCompletionValues(t *testing.T) {
+	ctx := ankhtest.NewContext(t)
+	ctx.AnkhConfig.Namespaces = []string{"web", "api"}
+
+	contexts, err := completionValues(ctx, "contexts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contexts) != len(ctx.AnkhConfig.Contexts) {
+		t.Logf("expected a context for each in ankh config but got %v", contexts)
+		t.Fail()
+	}
+	if !sort.StringsAreSorted(contexts) {
+		t.Logf("expected contexts to be sorted but got %v", contexts)
+		t.Fail()
+	}
+
+	namespaces, err := completionValues(ctx, "namespaces")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(namespaces) != "[api web]" {
+		t.Logf("expected sorted namespaces but got %v", namespaces)
+		t.Fail()
+	}
+
+	if _, err := completionValues(ctx, "widgets"); err == nil {
+		t.Logf("expected an error for an unknown kind of completion")
+		t.Fail()
+	}
+	if _, err := completionScript("tcsh"); err == nil {
+		t.Logf("expected an error for an unknown shell")
+		t.Fail()
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/util"
+	"github.com/mattn/go-isatty"
+)
+
+// Ankh files whose summary was confirmed already. The root Ankh file is only
+// confirmed once, before operating on the first context of an environment.
+var confirmedAnkhFiles = map[string]bool{}
+
+func confirmationKey(ankhFile *ankh.AnkhFile) string {
+	names := []string{}
+	for _, chart := range ankhFile.Charts {
+		names = append(names, chart.InstanceName())
+	}
+	return ankhFile.Path + "\x00" + strings.Join(names, "\x00")
+}
+
+// The contexts an operation will target, including the clusters behind each.
+func confirmationTargets(ctx *ankh.ExecutionContext) []string {
+	contexts := ctx.TargetContexts()
+	if len(contexts) == 0 {
+		contexts = []string{ctx.AnkhConfig.CurrentContextName}
+	}
+
+	targets := []string{}
+	for _, context := range contexts {
+		clusters := []string{}
+		for _, cluster := range ctx.AnkhConfig.Contexts[context].Clusters {
+			clusters = append(clusters, cluster.Name)
+		}
+		if len(clusters) > 0 {
+			context += fmt.Sprintf(" (clusters %v)", strings.Join(clusters, ", "))
+		}
+		targets = append(targets, context)
+	}
+	return targets
+}
+
+// Summarizes everything an apply, deploy or rollback is about to do, now that
+// versions, tags and namespaces are resolved.
+func formatConfirmationSummary(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) string {
+	action := string(ctx.Mode)
+	if ctx.Mode == ankh.Rollback {
+		action += " (`kubectl rollout undo`)"
+		if ctx.RollbackRevision > 0 {
+			action += fmt.Sprintf(" to revision %v", ctx.RollbackRevision)
+		}
+	}
+	if ctx.DryRun {
+		action += " (dry run)"
+	}
+
+	buf := bytes.NewBufferString("")
+	w := tabwriter.NewWriter(buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Action:\t%v\n", action)
+	if ctx.Environment != "" {
+		fmt.Fprintf(w, "Environment:\t%v\n", ctx.Environment)
+	}
+	if ctx.ContextSelector != nil {
+		fmt.Fprintf(w, "Context selector:\t%v\n", ctx.ContextSelector)
+	}
+	fmt.Fprintf(w, "Contexts:\t%v\n", strings.Join(confirmationTargets(ctx), ", "))
+	if release := ctx.EffectiveRelease(); release != "" {
+		fmt.Fprintf(w, "Release:\t%v\n", release)
+	}
+	filters := "none"
+	if len(ctx.Filters) > 0 {
+		filters = strings.Join(ctx.Filters, ", ")
+	}
+	fmt.Fprintf(w, "Filters:\t%v\n", filters)
+	if ctx.FilterName != "" {
+		fmt.Fprintf(w, "Name filter:\t%v\n", ctx.FilterName)
+	}
+	if ctx.FilterLabels != nil {
+		fmt.Fprintf(w, "Label filter:\t%v\n", ctx.FilterLabels)
+	}
+	if ctx.ChartSelector != nil {
+		fmt.Fprintf(w, "Chart selector:\t%v\n", ctx.ChartSelector)
+	}
+	if len(ctx.HelmSetValues)+len(ctx.HelmSetStringValues)+len(ctx.HelmSetFiles) > 0 {
+		keys := []string{}
+		for key := range ctx.HelmSetValues {
+			keys = append(keys, key)
+		}
+		for key := range ctx.HelmSetStringValues {
+			keys = append(keys, key)
+		}
+		for key := range ctx.HelmSetFiles {
+			keys = append(keys, key+" (file)")
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(w, "Values set:\t%v\n", strings.Join(keys, ", "))
+	}
+	w.Flush()
+
+	fmt.Fprintf(buf, "\n")
+	w = tabwriter.NewWriter(buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "NAMESPACE\tCHART\tVERSION\tTAG\n")
+	for _, chart := range ankhFile.Charts {
+		namespace := ""
+		if override := ctx.ChartNamespaceOverride(chart); override != nil {
+			namespace = *override
+		} else if chart.ChartMeta.Namespace != nil {
+			namespace = *chart.ChartMeta.Namespace
+		}
+		version := chart.Version
+		if chart.Path != "" {
+			version = chart.Path + " (local)"
+		}
+		tag := "-"
+		if chart.Tag != nil {
+			tag = chart.TagValue()
+		}
+		name := chart.Name
+		if chart.Alias != "" {
+			name = fmt.Sprintf("%v (as %v)", chart.Name, chart.Alias)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", namespace, name, version, tag)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// Whether the confirmation can be asked for: only when run interactively, and
+// not when stdin was already consumed by an Ankh file read from it.
+func canConfirm(ctx *ankh.ExecutionContext, stdinIsTerminal bool) bool {
+	return !ctx.NoPrompt && stdinIsTerminal && ctx.AnkhFilePath != ankh.StdinAnkhFilePath
+}
+
+// Shows a single summary of an apply, deploy or rollback, and asks for
+// confirmation before anything is changed, when run interactively.
+func confirmAnkhFile(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) {
+	switch ctx.Mode {
+	case ankh.Apply, ankh.Deploy, ankh.Rollback:
+	default:
+		return
+	}
+	if !canConfirm(ctx, isatty.IsTerminal(os.Stdin.Fd())) {
+		return
+	}
+
+	key := confirmationKey(ankhFile)
+	if confirmedAnkhFiles[key] {
+		return
+	}
+
+	fmt.Printf("\n%v\n", formatConfirmationSummary(ctx, ankhFile))
+	selection, err := util.PromptForConfirmation([]string{"Abort", "OK"},
+		"Review the summary above. Select OK to proceed.")
+	check(err)
+
+	if selection != "OK" {
+		ctx.Logger.Fatalf("Aborting")
+	}
+	confirmedAnkhFiles[key] = true
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+func TestCanConfirm(t *testing.T) {
+	ctx := ankhtest.NewContext(t)
+	ctx.NoPrompt = false
+	if !canConfirm(ctx, true) {
+		t.Logf("expected to confirm when run interactively")
+		t.Fail()
+	}
+	if canConfirm(ctx, false) {
+		t.Logf("expected not to confirm when stdin is not a terminal")
+		t.Fail()
+	}
+
+	ctx.AnkhFilePath = ankh.StdinAnkhFilePath
+	if canConfirm(ctx, true) {
+		t.Logf("expected not to confirm when the Ankh file was read from stdin")
+		t.Fail()
+	}
+
+	ctx.AnkhFilePath = "ankh.yaml"
+	ctx.NoPrompt = true
+	if canConfirm(ctx, true) {
+		t.Logf("expected not to confirm with --no-prompt")
+		t.Fail()
+	}
+}
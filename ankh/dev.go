@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/util"
+)
+
+type devOpts struct {
+	ChartPath    string
+	WatchPaths   []string
+	BuildCommand string
+	Container    string
+	Debounce     time.Duration
+	PollInterval time.Duration
+}
+
+// Global options for the ankh processes that `ankh dev` runs, so that they
+// operate on the same config, context and values as the dev loop itself.
+func devGlobalArgs(ctx *ankh.ExecutionContext) []string {
+	args := []string{
+		"--ankhconfig", ctx.AnkhConfigPath,
+		"--kubeconfig", ctx.KubeConfigPath,
+		"--datadir", path.Dir(ctx.DataDir),
+	}
+	if ctx.Context != "" {
+		args = append(args, "--context", ctx.Context)
+	}
+	if ctx.Environment != "" {
+		args = append(args, "--environment", ctx.Environment)
+	}
+	if ctx.ContextSelector != nil {
+		args = append(args, "--context-selector", ctx.ContextSelector.String())
+	}
+	if ctx.ChartSelector != nil {
+		args = append(args, "--selector", ctx.ChartSelector.String())
+	}
+	if ctx.Release != "" {
+		args = append(args, "--release", ctx.Release)
+	}
+	if ctx.Namespace != nil {
+		args = append(args, "--namespace", *ctx.Namespace)
+	}
+	for chart, namespace := range ctx.ChartNamespaces {
+		args = append(args, "--namespace", chart+"="+namespace)
+	}
+	if ctx.Tag != nil {
+		args = append(args, "--tag", *ctx.Tag)
+	}
+	if ctx.Deployer != "" {
+		args = append(args, "--deployer", ctx.Deployer)
+	}
+	if ctx.ReadOnly {
+		args = append(args, "--read-only")
+	}
+	if ctx.Verbose {
+		args = append(args, "--verbose")
+	}
+	if ctx.Quiet {
+		args = append(args, "--quiet")
+	}
+	if ctx.NoPrompt {
+		args = append(args, "--no-prompt")
+	}
+
+	keys := []string{}
+	for key := range ctx.HelmSetValues {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "--set", key+"="+ctx.HelmSetValues[key])
+	}
+	for _, key := range util.SortedKeys(ctx.HelmSetStringValues) {
+		args = append(args, "--set-string", key+"="+ctx.HelmSetStringValues[key])
+	}
+	for _, key := range util.SortedKeys(ctx.HelmSetFiles) {
+		args = append(args, "--set-file", key+"="+ctx.HelmSetFiles[key])
+	}
+	return args
+}
+
+// The environment class of the contexts that `ankh dev` applies to, since it
+// applies without confirmation on every change.
+const devEnvironmentClass = "dev"
+
+// Returns an error unless every context that `ankh dev` would apply to is a
+// dev context, ie: has `environment-class: dev`, or is minikube.
+func checkDevContexts(ctx *ankh.ExecutionContext) error {
+	contexts := ctx.TargetContexts()
+	if len(contexts) == 0 {
+		contexts = []string{ctx.AnkhConfig.CurrentContextName}
+	}
+
+	refused := []string{}
+	for _, name := range contexts {
+		context := ctx.AnkhConfig.Contexts[name]
+		class := context.EnvironmentClass
+		if class == "" {
+			class = context.Environment
+		}
+		if class != devEnvironmentClass && context.KubeContext != "minikube" {
+			refused = append(refused, fmt.Sprintf("\"%v\" (environment-class \"%v\")", name, class))
+		}
+	}
+	if len(refused) > 0 {
+		return fmt.Errorf("`ankh dev` applies on every change without confirmation, so it only operates on "+
+			"contexts with `environment-class: %v` or minikube, not %v. Pass --any-context to use them anyway",
+			devEnvironmentClass, strings.Join(refused, ", "))
+	}
+	return nil
+}
+
+// Records the modification time and size of every file under paths, skipping
+// hidden files and directories (eg: .git).
+func snapshotFiles(paths []string) map[string]string {
+	snapshot := map[string]string{}
+	for _, root := range paths {
+		filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if p != root && strings.HasPrefix(info.Name(), ".") {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.IsDir() {
+				snapshot[p] = fmt.Sprintf("%v-%v", info.ModTime().UnixNano(), info.Size())
+			}
+			return nil
+		})
+	}
+	return snapshot
+}
+
+func changedFiles(before, after map[string]string) []string {
+	changed := []string{}
+	for p, stamp := range after {
+		if before[p] != stamp {
+			changed = append(changed, p)
+		}
+	}
+	for p := range before {
+		if _, ok := after[p]; !ok {
+			changed = append(changed, p)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Blocks until files under paths change, and then stay unchanged for the
+// debounce period. Returns the files that changed.
+func waitForChanges(paths []string, since map[string]string, opts devOpts) (map[string]string, []string) {
+	current := since
+	changed := []string{}
+	lastChange := time.Time{}
+	for {
+		time.Sleep(opts.PollInterval)
+
+		next := snapshotFiles(paths)
+		if diff := changedFiles(current, next); len(diff) > 0 {
+			changed = append(changed, diff...)
+			lastChange = time.Now()
+			current = next
+			continue
+		}
+
+		if !lastChange.IsZero() && time.Since(lastChange) >= opts.Debounce {
+			return current, changed
+		}
+	}
+}
+
+func newAnkhCommand(ctx *ankh.ExecutionContext, args ...string) *exec.Cmd {
+	cmd := exec.Command(util.AnkhExecutable(), append(devGlobalArgs(ctx), args...)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// Builds, applies, and streams logs for a local chart, then does it all again
+// whenever the watched files change.
+func runDev(ctx *ankh.ExecutionContext, opts devOpts) {
+	watchPaths := append([]string{opts.ChartPath}, opts.WatchPaths...)
+	snapshot := snapshotFiles(watchPaths)
+
+	var logs *exec.Cmd
+	stopLogs := func() {
+		if logs != nil && logs.Process != nil {
+			logs.Process.Kill()
+			logs.Wait()
+		}
+		logs = nil
+	}
+	defer stopLogs()
+
+	for iteration := 1; ; iteration++ {
+		stopLogs()
+		log.Infof("Starting dev iteration %v", iteration)
+
+		ok := true
+		if opts.BuildCommand != "" {
+			log.Infof("Building with `%v`", opts.BuildCommand)
+			build := exec.Command("sh", "-c", opts.BuildCommand)
+			build.Stdout = os.Stdout
+			build.Stderr = os.Stderr
+			if err := build.Run(); err != nil {
+				log.Errorf("Build failed, not applying: %v", err)
+				ok = false
+			}
+		}
+
+		if ok {
+			if err := newAnkhCommand(ctx, "apply", "--chart-path", opts.ChartPath).Run(); err != nil {
+				log.Errorf("Apply failed: %v", err)
+				ok = false
+			}
+		}
+
+		if ok {
+			logsArgs := []string{"logs", "--chart-path", opts.ChartPath, "-f", "--tail", "20"}
+			if opts.Container != "" {
+				logsArgs = append(logsArgs, "-c", opts.Container)
+			}
+			// Never prompt for a pod in the background. The newest pod is chosen instead.
+			logs = newAnkhCommand(ctx, logsArgs...)
+			if !ctx.NoPrompt {
+				logs.Args = append([]string{logs.Args[0], "--no-prompt"}, logs.Args[1:]...)
+			}
+			logs.Stdin = nil
+			if err := logs.Start(); err != nil {
+				log.Warnf("Unable to stream logs: %v", err)
+				logs = nil
+			}
+		}
+
+		log.Infof("Watching [ %v ] for changes", strings.Join(watchPaths, ", "))
+		var changed []string
+		snapshot, changed = waitForChanges(watchPaths, snapshot, opts)
+		changed = util.ArrayDedup(changed)
+		sort.Strings(changed)
+		if len(changed) > 5 {
+			changed = append(changed[:5], fmt.Sprintf("and %v more", len(changed)-5))
+		}
+		log.Infof("Detected changes in [ %v ]", strings.Join(changed, ", "))
+	}
+}
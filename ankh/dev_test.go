@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+func TestCheckDevContexts(t *testing.T) {
+	ctx := ankhtest.NewContext(t)
+	ctx.AnkhConfig.Contexts = map[string]ankh.Context{
+		"dev":        {EnvironmentClass: "dev"},
+		"minikube":   {EnvironmentClass: "local", KubeContext: "minikube"},
+		"production": {EnvironmentClass: "production"},
+	}
+
+	for _, name := range []string{"dev", "minikube"} {
+		ctx.AnkhConfig.CurrentContextName = name
+		if err := checkDevContexts(ctx); err != nil {
+			t.Logf("expected context %v to be allowed but got %v", name, err)
+			t.Fail()
+		}
+	}
+
+	ctx.AnkhConfig.CurrentContextName = "production"
+	if err := checkDevContexts(ctx); err == nil {
+		t.Logf("expected a production context to be refused")
+		t.Fail()
+	}
+
+	ctx.AnkhConfig.CurrentContextName = "dev"
+	ctx.AnkhConfig.Environments = map[string]ankh.Environment{"all": {Contexts: []string{"dev", "production"}}}
+	ctx.Environment = "all"
+	if err := checkDevContexts(ctx); err == nil {
+		t.Logf("expected an environment with a production context to be refused")
+		t.Fail()
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/docker"
+)
+
+// Replaced in tests.
+var tagDigest = docker.TagDigest
+
+// Whether to pin each chart's tag to the digest it points to, with
+// `--resolve-digests` or `docker.resolveDigests`. Only operations that render
+// charts to apply or show them resolve digests.
+func shouldResolveDigests(ctx *ankh.ExecutionContext) bool {
+	if !ctx.ResolveDigests && !ctx.AnkhConfig.Docker.ResolveDigests {
+		return false
+	}
+	if ctx.PlanOnly {
+		return false
+	}
+	switch ctx.Mode {
+	case ankh.Apply, ankh.Deploy, ankh.Diff, ankh.Template:
+		return true
+	}
+	return false
+}
+
+// Resolves the tag of each chart to the digest of the image it currently
+// points to, so that what is applied can't change if the tag is pushed again.
+// Helm then gets `tagKey=tag@sha256:...`, which refers to the same image.
+func resolveDigests(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) error {
+	for i := range ankhFile.Charts {
+		chart := &ankhFile.Charts[i]
+		if chart.ChartMeta.TagKey == "" || chart.Tag == nil || chart.ImageDigest != "" {
+			continue
+		}
+		if strings.Contains(*chart.Tag, "@") {
+			ctx.Logger.Debugf("Not resolving tag \"%v\" of chart \"%v\", which is already pinned to a digest", *chart.Tag, chart.InstanceName())
+			continue
+		}
+
+		registryDomain, image := ctx.AnkhConfig.Docker.Registry, chart.Name
+		if chart.ChartMeta.TagImage != "" {
+			var err error
+			registryDomain, image, err = docker.ParseImage(ctx, chart.ChartMeta.TagImage)
+			if err != nil {
+				return err
+			}
+		}
+		if registryDomain == "" {
+			return fmt.Errorf("Unable to resolve tag \"%v\" of chart \"%v\" to a digest, since neither `tagImage` nor `docker.registry` is configured",
+				*chart.Tag, chart.InstanceName())
+		}
+
+		digest, err := tagDigest(ctx, registryDomain, image, *chart.Tag)
+		if err != nil {
+			return fmt.Errorf("Unable to resolve tag \"%v\" of image \"%v\" in registry \"%v\" to a digest: %v", *chart.Tag, image, registryDomain, err)
+		}
+		ctx.Logger.Infof("Pinning tag \"%v\" of chart \"%v\" to digest %v", *chart.Tag, chart.InstanceName(), digest)
+		chart.ImageDigest = digest
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/docker"
+)
+
+func TestResolveDigests(t *testing.T) {
+	lookups := []string{}
+	tagDigest = func(ctx *ankh.ExecutionContext, registryDomain string, image string, tag string) (string, error) {
+		lookups = append(lookups, fmt.Sprintf("%v/%v:%v", registryDomain, image, tag))
+		if tag == "missing" {
+			return "", fmt.Errorf("manifest unknown")
+		}
+		return "sha256:" + tag, nil
+	}
+	defer func() { tagDigest = docker.TagDigest }()
+
+	ctx := ankhtest.NewContext(t)
+	ctx.AnkhConfig.Docker.Registry = "registry.example.com"
+	tag, pinned := "1.2.3", "1.2.3@sha256:abc"
+	ankhFile := &ankh.AnkhFile{Charts: []ankh.Chart{
+		{Name: "api", Tag: &tag, ChartMeta: ankh.ChartMeta{TagKey: "image.tag"}},
+		{Name: "worker", Tag: &tag, ChartMeta: ankh.ChartMeta{TagKey: "image.tag", TagImage: "other.example.com/jobs/worker"}},
+		{Name: "pinned", Tag: &pinned, ChartMeta: ankh.ChartMeta{TagKey: "image.tag"}},
+		{Name: "untagged", ChartMeta: ankh.ChartMeta{TagKey: "image.tag"}},
+	}}
+
+	if err := resolveDigests(ctx, ankhFile); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"registry.example.com/api:1.2.3", "other.example.com/jobs/worker:1.2.3"}
+	if fmt.Sprint(lookups) != fmt.Sprint(expected) {
+		t.Logf("expected lookups %v but got %v", expected, lookups)
+		t.Fail()
+	}
+	if value := ankhFile.Charts[0].TagValue(); value != "1.2.3@sha256:1.2.3" {
+		t.Logf("expected the tag to be pinned to its digest but got %v", value)
+		t.Fail()
+	}
+	if value := ankhFile.Charts[2].TagValue(); value != pinned {
+		t.Logf("expected a pinned tag to be left alone but got %v", value)
+		t.Fail()
+	}
+
+	missing := "missing"
+	ankhFile = &ankh.AnkhFile{Charts: []ankh.Chart{{Name: "api", Tag: &missing, ChartMeta: ankh.ChartMeta{TagKey: "image.tag"}}}}
+	if err := resolveDigests(ctx, ankhFile); err == nil {
+		t.Logf("expected an error for a tag that does not exist")
+		t.Fail()
+	}
+}
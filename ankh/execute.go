@@ -3,21 +3,27 @@ package main
 import (
 	"bytes"
 	"fmt"
-	"os"
+	"net/http"
 	"path"
 	"sort"
 	"strings"
 	"text/tabwriter"
+	"time"
 
+	"github.com/appnexus/ankh/catalog"
 	"github.com/appnexus/ankh/context"
-	"github.com/appnexus/ankh/docker"
+	"github.com/appnexus/ankh/graph"
 	"github.com/appnexus/ankh/helm"
 	"github.com/appnexus/ankh/jira"
 	"github.com/appnexus/ankh/kubectl"
+	"github.com/appnexus/ankh/notify"
+	ankhlib "github.com/appnexus/ankh/pkg/ankh"
 	"github.com/appnexus/ankh/plan"
+	"github.com/appnexus/ankh/rollout"
 	"github.com/appnexus/ankh/slack"
+	"github.com/appnexus/ankh/stats"
+	"github.com/appnexus/ankh/trace"
 	"github.com/appnexus/ankh/util"
-	"github.com/imdario/mergo"
 )
 
 func printEnvironments(ankhConfig *ankh.AnkhConfig) {
@@ -31,6 +37,67 @@ func printEnvironments(ankhConfig *ankh.AnkhConfig) {
 	}
 }
 
+type environmentListing struct {
+	Name     string   `json:"name" yaml:"name"`
+	Contexts []string `json:"contexts" yaml:"contexts"`
+	Source   string   `json:"source,omitempty" yaml:"source,omitempty"`
+}
+
+type contextListing struct {
+	Name             string            `json:"name" yaml:"name"`
+	Release          string            `json:"release,omitempty" yaml:"release,omitempty"`
+	EnvironmentClass string            `json:"environmentClass" yaml:"environmentClass"`
+	ResourceProfile  string            `json:"resourceProfile" yaml:"resourceProfile"`
+	KubeContext      string            `json:"kubeContext,omitempty" yaml:"kubeContext,omitempty"`
+	KubeServer       string            `json:"kubeServer,omitempty" yaml:"kubeServer,omitempty"`
+	Clusters         []string          `json:"clusters,omitempty" yaml:"clusters,omitempty"`
+	Source           string            `json:"source,omitempty" yaml:"source,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+func getEnvironmentListings(ankhConfig *ankh.AnkhConfig) []environmentListing {
+	keys := []string{}
+	for k, _ := range ankhConfig.Environments {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	listings := []environmentListing{}
+	for _, name := range keys {
+		env := ankhConfig.Environments[name]
+		listings = append(listings, environmentListing{Name: name, Contexts: append([]string{}, env.Contexts...), Source: env.Source})
+	}
+	return listings
+}
+
+func getContextListings(ankhConfig *ankh.AnkhConfig) []contextListing {
+	keys := []string{}
+	for k, _ := range ankhConfig.Contexts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	listings := []contextListing{}
+	for _, name := range keys {
+		ctx := ankhConfig.Contexts[name]
+		listing := contextListing{
+			Name:             name,
+			Release:          ctx.Release,
+			EnvironmentClass: ctx.EnvironmentClass,
+			ResourceProfile:  ctx.ResourceProfile,
+			KubeContext:      ctx.KubeContext,
+			KubeServer:       ctx.KubeServer,
+			Source:           ctx.Source,
+			Labels:           ctx.Labels,
+		}
+		for _, cluster := range ctx.Clusters {
+			listing.Clusters = append(listing.Clusters, cluster.Name)
+		}
+		listings = append(listings, listing)
+	}
+	return listings
+}
+
 func getEnvironmentTable(ankhConfig *ankh.AnkhConfig) []string {
 	buf := bytes.NewBufferString("")
 	w := tabwriter.NewWriter(buf, 0, 8, 8, ' ', 0)
@@ -51,7 +118,7 @@ func getEnvironmentTable(ankhConfig *ankh.AnkhConfig) []string {
 func getContextTable(ankhConfig *ankh.AnkhConfig) []string {
 	buf := bytes.NewBufferString("")
 	w := tabwriter.NewWriter(buf, 0, 8, 8, ' ', 0)
-	fmt.Fprintf(w, "NAME\tRELEASE\tENVIRONMENT-CLASS\tRESOURCE-PROFILE\tKUBE-CONTEXT/SERVER\tSOURCE\n")
+	fmt.Fprintf(w, "NAME\tRELEASE\tENVIRONMENT-CLASS\tRESOURCE-PROFILE\tKUBE-CONTEXT/SERVER\tLABELS\tSOURCE\n")
 	keys := []string{}
 	for k, _ := range ankhConfig.Contexts {
 		keys = append(keys, k)
@@ -63,7 +130,19 @@ func getContextTable(ankhConfig *ankh.AnkhConfig) []string {
 		if target == "" {
 			target = ctx.KubeServer
 		}
-		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", name, ctx.Release, ctx.EnvironmentClass, ctx.ResourceProfile, target, ctx.Source)
+		if len(ctx.Clusters) > 0 {
+			names := []string{}
+			for _, cluster := range ctx.Clusters {
+				names = append(names, cluster.Name)
+			}
+			target = fmt.Sprintf("clusters: %v", strings.Join(names, ","))
+		}
+		labels := []string{}
+		for key, value := range ctx.Labels {
+			labels = append(labels, key+"="+value)
+		}
+		sort.Strings(labels)
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", name, ctx.Release, ctx.EnvironmentClass, ctx.ResourceProfile, target, strings.Join(labels, ","), ctx.Source)
 	}
 	w.Flush()
 	return strings.Split(buf.String(), "\n")
@@ -80,254 +159,6 @@ func printContexts(ankhConfig *ankh.AnkhConfig) {
 	}
 }
 
-func reconcileMissingConfigs(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) error {
-	// Make sure that we don't use the tag argument for more than one Chart.
-	// When this happens, it is almost always an error, because a tag value
-	// is typically only valid/intended for a single chart.
-	tagArgumentUsedForChart := ""
-
-	// Prompt for chart versions if any are missing
-	for i := 0; i < len(ankhFile.Charts); i++ {
-		chart := &ankhFile.Charts[i]
-
-		if chart.Path == "" && chart.Version == "" {
-			ctx.Logger.Infof("Found chart \"%v\" without a version", chart.Name)
-			if ctx.NoPrompt {
-				ctx.Logger.Fatalf("Chart \"%v\" missing version (and no 'path' set either, not prompting due to --no-prompt)",
-					chart.Name)
-			}
-
-			repository := ctx.DetermineHelmRepository(&chart.HelmRepository)
-			versions, err := helm.ListVersions(ctx, repository, chart.Name, true)
-			if err != nil {
-				return err
-			}
-
-			versionsList := util.FilterStringsContaining(strings.Split(strings.Trim(versions, "\n "), "\n"), ctx.ChartVersionFilter)
-
-			selectedVersion, err := util.PromptForSelection(versionsList,
-				fmt.Sprintf("Select a version for chart \"%v\"", chart.Name), false)
-			if err != nil {
-				return err
-			}
-
-			chart.Version = selectedVersion
-			ctx.Logger.Infof("Using chart \"%v\" at version \"%v\" based on prompt selection", chart.Name, chart.Version)
-		} else if chart.Path != "" {
-			ctx.Logger.Infof("Using chart \"%v\" from local path \"%v\"", chart.Name, chart.Path)
-		}
-
-		// Now that we have either a version or a local path, fetch the chart metadata and merge it.
-		repository := ctx.DetermineHelmRepository(&chart.HelmRepository)
-		meta, err := helm.FetchChartMeta(ctx, repository, chart)
-		if err != nil {
-			return fmt.Errorf("Error fetching chart \"%v\": %v", chart.Name, err)
-		}
-		mergo.Merge(&chart.ChartMeta, meta)
-
-		// If namespace is set on the command line, we'll use that as an
-		// override later during executeChartsOnNamespace, so don't check
-		// for anything here.
-		// - command line override, ankh file, chart meta.
-		if ctx.Namespace == nil {
-			if ankhFile.Namespace != nil {
-				extraLog := ""
-				if chart.ChartMeta.Namespace != nil && *ankhFile.Namespace != *chart.ChartMeta.Namespace {
-					extraLog = fmt.Sprintf(" (overriding namespace \"%v\" from ankh.yaml present in the chart)",
-						*chart.ChartMeta.Namespace)
-				}
-				ctx.Logger.Warnf("Using namespace \"%v\" from Ankh file for chart \"%v\"%v. This feature will be removed in Ankh 2.0",
-					*ankhFile.Namespace, chart.Name, extraLog)
-				chart.ChartMeta.Namespace = ankhFile.Namespace
-			} else if chart.ChartMeta.Namespace == nil {
-				ctx.Logger.Infof("Found chart \"%v\" without a namespace", chart.Name)
-				if ctx.NoPrompt {
-					ctx.Logger.Fatalf("Chart \"%v\" missing namespace (not prompting due to --no-prompt)", chart.Name)
-				}
-				if len(ctx.AnkhConfig.Namespaces) > 0 {
-					selectedNamespace, err := util.PromptForSelection(ctx.AnkhConfig.Namespaces,
-						fmt.Sprintf("Select a namespace for chart '%v' (or re-run with -n/--namespace to provide your own)",
-							chart.Name), false)
-					if err != nil {
-						return err
-					}
-					chart.ChartMeta.Namespace = &selectedNamespace
-				} else {
-					providedNamespace, err := util.PromptForInput("",
-						fmt.Sprintf("Provide a namespace for chart '%v' (or enter nothing to denote no explicit namespace) > ",
-							chart.Name))
-					if err != nil {
-						return err
-					}
-					chart.ChartMeta.Namespace = &providedNamespace
-
-				}
-				ctx.Logger.Infof("Using namespace \"%v\" for chart \"%v\" based on prompt selection",
-					*chart.ChartMeta.Namespace, chart.Name)
-			} else {
-				ctx.Logger.Infof("Using namespace \"%v\" for chart \"%v\" based on ankh.yaml present in the chart",
-					*chart.ChartMeta.Namespace, chart.Name)
-			}
-		}
-
-		// tagKey comes directly from the chart's metadata
-		tagKey := chart.ChartMeta.TagKey
-
-		// Do nothing if tagKey is not configured - the user does not want this behavior.
-		if tagKey == "" {
-			if ctx.Tag != nil {
-				ctx.Logger.Fatalf("Tag has been provided but `tagKey` is not configured on either the `chart` in an AnkhFile, nor in an `ankh.yaml` inside the helm chart. " +
-					"This means you passed a tag value, but have not told Ankh which helm value corresponds " +
-					"to the tag value/variable in your helm chart. Tag is shorthand for `--set $tagKey=$tag`, " +
-					"so you can use that instead, or you can ensure that `chart.tagKey` is configured")
-			}
-			continue
-		} else {
-			ctx.Logger.Infof("Using tagKey \"%v\" for chart \"%v\" based on ankh.yaml present in the chart", chart.ChartMeta.TagKey, chart.Name)
-		}
-
-		if ctx.Tag != nil {
-			if tagArgumentUsedForChart != "" {
-				complaint := fmt.Sprintf("Cannot use tag value for chart \"%v\" because it was already used for chart \"%v\". "+
-					"A tag value is almost always intended for use with a single chart. To ignore this error and "+
-					"use tag value \"%v\" for _all_ charts, re-un using `ankh --ignore-config-errors ...` ",
-					chart.Name, tagArgumentUsedForChart, *ctx.Tag)
-				if ctx.IgnoreConfigErrors {
-					ctx.Logger.Warnf(complaint)
-				} else {
-					ctx.Logger.Fatalf(complaint)
-				}
-			}
-
-			ctx.Logger.Infof("Using tag value \"%v=%s\" based on --tag argument", tagKey, *ctx.Tag)
-			chart.Tag = ctx.Tag
-			tagArgumentUsedForChart = chart.Name
-			continue
-		}
-
-		// Treat any existing --set tagKey=$tag argument as authoritative
-		for k, v := range ctx.HelmSetValues {
-			if k == tagKey {
-				ctx.Logger.Infof("Using tag value \"%v=%s\" based on --set argument", tagKey, v)
-				t := v
-				chart.Tag = &t
-				break
-			}
-		}
-
-		// Treat any existing `tag` in `default-values` for this chart as the next-most authoritative
-		for k, v := range chart.DefaultValues {
-			if k == tagKey {
-				ctx.Logger.Infof("Using tag value \"%v=%s\" based on default-values present in the Ankh file", tagKey, v)
-				t, ok := v.(string)
-				if !ok {
-					ctx.Logger.Fatalf("Could not use value '%+v' from default-values in chart %v "+
-						"as a string value for tagKey '%v'", v, chart.Name, tagKey)
-				}
-				chart.Tag = &t
-				break
-			}
-		}
-
-		// For certain operations, we can assume a safe `unset` value for tagKey
-		// for the sole purpose of templating the Helm chart. The value won't be used
-		// meaningfully (like it would be with apply), so we choose this method instead
-		// of prompting the user for a value that isn't meaningful.
-		switch ctx.Mode {
-		case ankh.Explain:
-			fallthrough
-		case ankh.Rollback:
-			fallthrough
-		case ankh.Get:
-			fallthrough
-		case ankh.Pods:
-			fallthrough
-		case ankh.Exec:
-			fallthrough
-		case ankh.Logs:
-			if chart.Tag != nil {
-				break
-			}
-
-			_, ok := ctx.HelmSetValues[tagKey]
-			if !ok {
-				// It's unset, so set it for the purpose of this execution
-				tag := "__ankh_tag_value_unset___"
-				ctx.Logger.Debugf("Setting configured tagKey %v=%v for a safe operation",
-					tagKey, tag)
-				chart.Tag = &tag
-			}
-		}
-
-		// If we stil don't have a chart.Tag value, prompt.
-		if chart.Tag == nil {
-			if ctx.NoPrompt {
-				ctx.Logger.Fatalf("Chart \"%v\" missing value for `tagKey` (configured to be '%v',  not prompting due to --no-prompt)",
-					tagKey, chart.Name)
-			}
-
-			registryDomain := ctx.AnkhConfig.Docker.Registry
-			image := ""
-			if chart.ChartMeta.TagImage != "" {
-				// No need to prompt for an image name if we already have one in the chart metdata
-				registryDomain, image, err = docker.ParseImage(ctx, chart.ChartMeta.TagImage)
-				check(err)
-
-				ctx.Logger.Infof("Using tagImage \"%v\" for chart \"%v\" based on ankh.yaml present in the chart", chart.ChartMeta.TagImage, chart.Name)
-				ctx.Logger.Debugf("Parsed tagImage into registryDomain '%v' and image '%v'", registryDomain, image)
-			} else {
-				ctx.Logger.Infof("Found chart \"%v\" without a value for \"%v\" ", chart.Name, tagKey)
-				if ctx.AnkhConfig.Docker.Registry == "" {
-					ctx.Logger.Fatalf("Cannot prompt for an image tag, no Docker registry configured.")
-				}
-				defaultValue := chart.Name
-				image, err = util.PromptForInput(defaultValue,
-					fmt.Sprintf("No tag specified for chart '%v'. Provide the name of an image in registry '%v' to select a tag for, "+
-						"or nothing to skip this step > ", ctx.AnkhConfig.Docker.Registry, chart.Name))
-				check(err)
-			}
-
-			if image == "" {
-				ctx.Logger.Infof("Skipping tag prompt since no image name was provided")
-				continue
-			}
-
-			if ctx.AnkhConfig.Docker.Registry == "" {
-				ctx.Logger.Fatalf("Cannot prompt for an image tag, no Docker registry configured.")
-			}
-
-			output, err := docker.ListTags(ctx, registryDomain, image, true)
-			check(err)
-
-			trimmedOutput := strings.Trim(output, "\n ")
-			if trimmedOutput != "" {
-				tags := strings.Split(trimmedOutput, "\n")
-				tag, err := util.PromptForSelection(tags, fmt.Sprintf("Select a value for \"%v\"", tagKey), false)
-				check(err)
-
-				ctx.Logger.Infof("Using implicit \"--set tag %v=%s\" based on prompt selection", tagKey, tag)
-				chart.Tag = &tag
-			} else if image != "" {
-				complaint := fmt.Sprintf("Chart \"%v\" missing value for `tagKey` (configured to be `%v`). "+
-					"You may want to try passing a tag value explicitly using `ankh --set %v=... `, or simply ignore "+
-					"this error entirely using `ankh --ignore-config-errors ...` (not recommended)",
-					chart.Name, tagKey, tagKey)
-				if ctx.IgnoreConfigErrors {
-					ctx.Logger.Warnf("%v", complaint)
-				} else {
-					ctx.Logger.Fatalf("%v", complaint)
-				}
-			}
-		}
-
-		// we should finally have a tag value
-		ctx.DeploymentTag = *chart.Tag
-
-	}
-
-	return nil
-}
-
 func logExecuteAnkhFile(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) {
 	action := ""
 	switch ctx.Mode {
@@ -353,6 +184,16 @@ func logExecuteAnkhFile(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) {
 		action = "Linting"
 	case ankh.Logs:
 		action = "Getting logs for pods from chart"
+	case ankh.Report:
+		action = "Reporting images for chart"
+	case ankh.History:
+		action = "Getting revision history for chart"
+	case ankh.Delete:
+		action = "Deleting objects from chart"
+	case ankh.Scale:
+		action = "Scaling objects from chart"
+	case ankh.PortForward:
+		action = "Forwarding a port to chart"
 	}
 
 	releaseLog := ""
@@ -361,7 +202,9 @@ func logExecuteAnkhFile(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) {
 	}
 
 	dryLog := ""
-	if ctx.DryRun {
+	if ctx.AdmissionPreview {
+		dryLog = " (admission preview)"
+	} else if ctx.DryRun {
 		dryLog = " (dry run)"
 	} else if ctx.Mode == ankh.Explain {
 		dryLog = " (explaining)"
@@ -371,6 +214,9 @@ func logExecuteAnkhFile(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) {
 	if ctx.AnkhConfig.CurrentContext.KubeServer != "" {
 		contextLog = fmt.Sprintf(" to kube-server \"%v\"", ctx.AnkhConfig.CurrentContext.KubeServer)
 	}
+	if ctx.AnkhConfig.CurrentClusterName != "" {
+		contextLog += fmt.Sprintf(" (cluster \"%v\")", ctx.AnkhConfig.CurrentClusterName)
+	}
 
 	ctx.Logger.Infof("%v%v%v%v with environment class \"%v\" and resource profile \"%v\"", action,
 		releaseLog, dryLog, contextLog,
@@ -378,34 +224,190 @@ func logExecuteAnkhFile(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) {
 		ctx.AnkhConfig.CurrentContext.ResourceProfile)
 }
 
+// Starts tracing the run if a trace endpoint or file is configured. The returned
+// function ends the run's span and exports all recorded spans.
+func startTracing(ctx *ankh.ExecutionContext) func(err error) {
+	if ctx.TraceEndpoint == "" {
+		ctx.TraceEndpoint = ctx.AnkhConfig.Tracing.Endpoint
+	}
+	if ctx.TraceFile == "" {
+		ctx.TraceFile = ctx.AnkhConfig.Tracing.File
+	}
+	if ctx.TraceEndpoint == "" && ctx.TraceFile == "" {
+		return func(err error) {}
+	}
+
+	ctx.Tracer = trace.NewTracer("ankh")
+	http.DefaultTransport = ctx.Tracer.Transport(http.DefaultTransport)
+
+	span := ctx.Tracer.Start(fmt.Sprintf("ankh %v", ctx.Mode), map[string]string{
+		"ankh.context":     ctx.Context,
+		"ankh.environment": ctx.Environment,
+		"ankh.release":     ctx.EffectiveRelease(),
+		"ankh.deployer":    ctx.EffectiveDeployer(),
+		"ankh.chart":       strings.Join(ctx.Charts, ","),
+		"ankh.version":     AnkhBuildVersion,
+	})
+
+	finished := false
+	finish := func(err error) {
+		if finished {
+			return
+		}
+		finished = true
+
+		span.End(err)
+		if err := ctx.Tracer.Export(ctx.TraceEndpoint, ctx.TraceFile); err != nil {
+			log.Warnf("Failed to export traces: %v", err)
+		} else {
+			log.Debugf("Exported traces (endpoint '%v', file '%v')", ctx.TraceEndpoint, ctx.TraceFile)
+		}
+	}
+
+	// Fatal errors exit the process directly, so export on the way out.
+	onErrorExit("tracing", func() {
+		finish(fmt.Errorf("ankh exited with an error"))
+	})
+
+	return finish
+}
+
+// Records a summary of the run in the data dir, for `ankh stats`. The returned
+// function writes the summary with the run's result.
+func startRunSummary(ctx *ankh.ExecutionContext) (*stats.RunSummary, func(err error)) {
+	summary := &stats.RunSummary{
+		Command:     string(ctx.Mode),
+		Start:       time.Now(),
+		Environment: ctx.Environment,
+		Deployer:    ctx.EffectiveDeployer(),
+		DryRun:      ctx.DryRun,
+	}
+
+	finished := false
+	finish := func(err error) {
+		// Plans change nothing, so they are not worth counting.
+		if finished || ctx.PlanOnly {
+			return
+		}
+		finished = true
+
+		summary.DurationSeconds = time.Since(summary.Start).Seconds()
+		summary.Result = stats.ResultSuccess
+		if err != nil {
+			summary.Result = stats.ResultFailure
+			summary.Error = err.Error()
+		}
+		if err := stats.WriteRunSummary(ctx.DataDir, *summary); err != nil {
+			log.Debugf("Unable to write run summary: %v", err)
+		}
+	}
+
+	onErrorExit("summary", func() {
+		finish(fmt.Errorf("ankh exited with an error"))
+	})
+
+	return summary, finish
+}
+
 func execute(ctx *ankh.ExecutionContext) {
+	ctx.ApplyCommandDefaults()
+	ctx.ApplyProjectChart()
+	check(ctx.CheckProjectEnvironments())
+	if ctx.WaitTimeout != "" {
+		if _, err := time.ParseDuration(ctx.WaitTimeout); err != nil {
+			ctx.Logger.Fatalf("Invalid rollout timeout \"%v\", expected a duration like 5m: %v", ctx.WaitTimeout, err)
+		}
+	}
+	check(helm.ValidateOverrides(ctx.ForceReplicas, ctx.ForceMaxUnavailable))
+	check(kubectl.ValidateDiffIgnoreRules(ctx.AnkhConfig.Diff.Ignore))
+	startRunManifest(ctx)
+
+	// `ankh plan` only describes what the mode would do.
+	switch {
+	case ctx.PlanOnly:
+	case ctx.Mode == ankh.Apply, ctx.Mode == ankh.Deploy, ctx.Mode == ankh.Rollback, ctx.Mode == ankh.Delete, ctx.Mode == ankh.Scale:
+		if !ctx.DryRun {
+			check(ankhlib.CheckWritable(ctx, fmt.Sprintf("%v", ctx.Mode)))
+		}
+	case ctx.Mode == ankh.Exec:
+		check(ctx.CheckWritable("exec on a pod"))
+	}
+
+	finishTracing := startTracing(ctx)
+	summary, finishRunSummary := startRunSummary(ctx)
+	defer func() {
+		// A panic fails the run too, and carries on once the run is finished.
+		var err error
+		r := recover()
+		if r != nil {
+			err = fmt.Errorf("ankh panicked: %v", r)
+		}
+		finishRunSummary(err)
+		finishTracing(err)
+		if r != nil {
+			panic(r)
+		}
+	}()
+
 	rootAnkhFile, err := ankh.GetAnkhFile(ctx)
 	check(err)
+	for _, chart := range rootAnkhFile.Charts {
+		summary.Charts = append(summary.Charts, chart.InstanceName())
+	}
 
-	contexts := []string{}
 	if ctx.Environment != "" {
-		environment, ok := ctx.AnkhConfig.Environments[ctx.Environment]
-		if !ok {
+		if _, ok := ctx.AnkhConfig.Environments[ctx.Environment]; !ok {
 			log.Errorf("Environment '%v' not found in `environments`", ctx.Environment)
 			log.Info("The following environments are available:")
 			printEnvironments(&ctx.AnkhConfig)
-			os.Exit(1)
+			exitWithError()
 		}
+	}
+	contexts := ctx.TargetContexts()
+	if ctx.ContextSelector != nil && len(contexts) == 0 {
+		ctx.Logger.Fatalf("No contexts match the context selector \"%v\". Add `labels` to contexts in the Ankh config to select them", ctx.ContextSelector)
+	}
 
-		contexts = environment.Contexts
+	summary.Contexts = contexts
+	if len(contexts) == 0 {
+		summary.Contexts = []string{ctx.AnkhConfig.CurrentContextName}
 	}
+	summary.Release = ctx.EffectiveRelease()
 
 	if len(contexts) > 0 {
-		log.Infof("Executing over environment \"%v\" with contexts [ %v ]", ctx.Environment, strings.Join(contexts, ", "))
+		target := fmt.Sprintf("environment \"%v\"", ctx.Environment)
+		if ctx.ContextSelector != nil && ctx.Environment != "" {
+			target = fmt.Sprintf("environment \"%v\" and context selector \"%v\"", ctx.Environment, ctx.ContextSelector)
+		} else if ctx.ContextSelector != nil {
+			target = fmt.Sprintf("context selector \"%v\"", ctx.ContextSelector)
+		}
+		log.Infof("Executing over %v with contexts [ %v ]", target, strings.Join(contexts, ", "))
 
+		rolloutState := startRollout(ctx, contexts)
 		for _, context := range contexts {
-			log.Infof("Beginning to operate on context \"%v\" in environment \"%v\"", context, ctx.Environment)
+			if skipRolloutContext(ctx, rolloutState, context) {
+				continue
+			}
+			log.Infof("Beginning to operate on context \"%v\" in %v", context, target)
+			setRolloutStatus(ctx, rolloutState, context, rollout.Started)
 			switchContext(ctx, &ctx.AnkhConfig, context)
-			executeContext(ctx, &rootAnkhFile)
-			log.Infof("Finished with context \"%v\" in environment \"%v\"", context, ctx.Environment)
+			executeContextOnClusters(ctx, &rootAnkhFile)
+			setRolloutStatus(ctx, rolloutState, context, rollout.Succeeded)
+			log.Infof("Finished with context \"%v\" in %v", context, target)
 		}
+		finishRollout(ctx, rolloutState)
 	} else {
-		executeContext(ctx, &rootAnkhFile)
+		startRollout(ctx, contexts)
+		executeContextOnClusters(ctx, &rootAnkhFile)
+	}
+
+	if ctx.Mode == ankh.Report {
+		printImageReport(ctx, contexts)
+	}
+
+	if ctx.PlanOnly {
+		printPlannedRuns(ctx)
+		return
 	}
 
 	if ctx.SlackChannel != "" {
@@ -419,6 +421,46 @@ func execute(ctx *ankh.ExecutionContext) {
 			ctx.Logger.Errorf("Unable to create JIRA ticket. %v", err)
 		}
 	}
+
+	switch ctx.Mode {
+	case ankh.Apply, ankh.Deploy, ankh.Rollback:
+		notify.Notify(ctx, &rootAnkhFile)
+	}
+}
+
+// Fetches the version of kubectl once, and checks it against `kubectl.version`.
+func checkKubectlVersion(ctx *ankh.ExecutionContext) {
+	if ctx.KubectlVersion == "" {
+		ver, err := kubectl.Version(ctx)
+		if err != nil {
+			ctx.Logger.Fatalf("Failed to get kubectl version info: %v", err)
+		}
+		ctx.KubectlVersion = ver
+		ctx.Logger.Debug("Using kubectl version: ", strings.TrimSpace(ver))
+	}
+	check(util.CheckToolVersion("kubectl", ctx.AnkhConfig.Kubectl.Command, ctx.AnkhConfig.Kubectl.Version, ctx.KubectlVersion))
+}
+
+// Fetches the version of helm once, and checks it against `helm.version`.
+func checkHelmVersion(ctx *ankh.ExecutionContext) {
+	if ctx.HelmVersion == "" {
+		ver, err := helm.Version(ctx)
+		if err != nil {
+			ctx.Logger.Fatalf("Failed to get helm version info: %v", err)
+		}
+		ctx.HelmVersion = ver
+		trimmed := strings.TrimSpace(ver)
+		ctx.Logger.Debug("Using Helm version: ", trimmed)
+
+		// Helm's version command is, itself, not written in a backwads compatible
+		// way. We choose the 'Client: ' magic sting to prove that Helm is version 2,
+		// because Tiller and the "client" distinction was removed in Helm 3+.
+		if strings.HasPrefix(trimmed, "Client: ") {
+			ctx.HelmV2 = true
+			ctx.Logger.Warnf("Helm v2 is no longer maintained as of November 2020, please migrate to Helm v3.\n Info here: https://helm.sh/docs/intro/install/")
+		}
+	}
+	check(util.CheckToolVersion("helm", ctx.AnkhConfig.Helm.Command, ctx.AnkhConfig.Helm.Version, ctx.HelmVersion))
 }
 
 func executeChartsOnNamespace(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile, charts []ankh.Chart, namespace string) {
@@ -433,19 +475,12 @@ func executeChartsOnNamespace(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFil
 		fallthrough
 	case ankh.Exec:
 		fallthrough
+	case ankh.PortForward:
+		fallthrough
 	case ankh.Logs:
 		useWildCardLabels = true
 	}
 
-	if ctx.KubectlVersion == "" {
-		ver, err := kubectl.Version(ctx)
-		if err != nil {
-			ctx.Logger.Fatalf("Failed to get kubectl version info: %v", err)
-		}
-		ctx.KubectlVersion = ver
-		ctx.Logger.Debug("Using kubectl version: ", strings.TrimSpace(ver))
-	}
-
 	// Override wild card labels at the chart level. Choose the first chart arbitrarily.
 	// Warn on this condition - we should eventually deprecate `get/logs/exec` calls
 	// that involve a multi-chart Ankh file.
@@ -461,42 +496,99 @@ func executeChartsOnNamespace(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFil
 		}
 	}
 
-	out, err := planAndExecute(ctx, charts, namespace, wildCardLabels)
-	if err != nil && ctx.Mode == ankh.Diff {
-		ctx.Logger.Warnf("The `diff` feature entered alpha in kubectl v1.9.0, and seems to work best at version v1.12.1. "+
-			"Your results may vary. Current kubectl version string is `%s`", ctx.KubectlVersion)
+	if ctx.PlanOnly {
+		plannedRuns = append(plannedRuns, describePlan(ctx, ankhFile, charts, namespace, wildCardLabels))
+		return
+	}
+	checkKubectlVersion(ctx)
+
+	if ctx.Mode == ankh.Report {
+		reportImagesOnNamespace(ctx, charts, namespace)
+		return
 	}
+
+	if ctx.SlackChannel != "" && (ctx.Mode == ankh.Apply || ctx.Mode == ankh.Deploy) {
+		recordPreviousReleases(ctx, charts, namespace)
+	}
+
+	ctx.AppliedManifest = ""
+	out, err := planAndExecute(ctx, charts, namespace, wildCardLabels)
 	check(err)
 
+	if !ctx.DryRun && (ctx.Mode == ankh.Apply || ctx.Mode == ankh.Deploy || ctx.Mode == ankh.Rollback) {
+		recordReleases(ctx, charts, namespace)
+	}
+	if !ctx.DryRun && (ctx.Mode == ankh.Apply || ctx.Mode == ankh.Deploy) {
+		publishArtifacts(ctx, ankhFile, charts, namespace)
+	}
+
 	if out != "" {
 		fmt.Println(out)
 	}
 }
 
-func executeAnkhFile(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) {
-	err := reconcileMissingConfigs(ctx, ankhFile)
-	check(err)
+// Chart namespace overrides that matched a chart in some Ankh file of the run,
+// so that overrides for unknown charts can be reported.
+var usedChartNamespaces = map[string]bool{}
 
-	logExecuteAnkhFile(ctx, ankhFile)
+func markChartNamespacesUsed(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) {
+	for _, chart := range ankhFile.Charts {
+		for _, name := range []string{chart.InstanceName(), chart.Name} {
+			if _, ok := ctx.ChartNamespaces[name]; ok {
+				usedChartNamespaces[name] = true
+			}
+		}
+	}
+}
 
-	if ctx.HelmVersion == "" {
-		ver, err := helm.Version(ctx)
-		if err != nil {
-			ctx.Logger.Fatalf("Failed to get helm version info: %v", err)
+func warnUnusedChartNamespaces(ctx *ankh.ExecutionContext) {
+	unused := []string{}
+	for name := range ctx.ChartNamespaces {
+		if !usedChartNamespaces[name] {
+			unused = append(unused, name)
 		}
-		ctx.HelmVersion = ver
-		trimmed := strings.TrimSpace(ver)
-		ctx.Logger.Debug("Using Helm version: ", trimmed)
+	}
+	sort.Strings(unused)
+	for _, name := range unused {
+		ctx.Logger.Warnf("Namespace override for chart \"%v\" did not match any chart", name)
+		// Only warn once, even when operating over several contexts.
+		usedChartNamespaces[name] = true
+	}
+}
 
-		// Helm's version command is, itself, not written in a backwads compatible
-		// way. We choose the 'Client: ' magic sting to prove that Helm is version 2,
-		// because Tiller and the "client" distinction was removed in Helm 3+.
-		if strings.HasPrefix(trimmed, "Client: ") {
-			ctx.HelmV2 = true
-			ctx.Logger.Warnf("Helm v2 is no longer maintained as of November 2020, please migrate to Helm v3.\n Info here: https://helm.sh/docs/intro/install/")
+func executeAnkhFile(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile, dependency string) {
+	markChartNamespacesUsed(ctx, ankhFile)
+	check(ankhlib.ResolveAnkhFile(ctx, ankhFile))
+	if shouldResolveDigests(ctx) {
+		check(resolveDigests(ctx, ankhFile))
+	}
+
+	if !ctx.PlanOnly {
+		confirmAnkhFile(ctx, ankhFile)
+		recordRunManifest(ctx, ankhFile, dependency)
+	}
+
+	logExecuteAnkhFile(ctx, ankhFile)
+
+	if !ctx.PlanOnly {
+		checkHelmVersion(ctx)
+	}
+
+	// Charts are operated on in waves, each after the charts it depends on.
+	// Before later waves, Apply waits for every rollout of a wave to finish.
+	waves, err := graph.ChartWaves(ankhFile.Charts)
+	check(err)
+	for i, wave := range waves {
+		if len(waves) > 1 {
+			ctx.Logger.Infof("Operating on charts [ %v ] (%v of %v by `dependsOn`)", strings.Join(chartNames(wave), ", "), i+1, len(waves))
 		}
+		withReadiness(ctx, i < len(waves)-1, func() {
+			executeChartsByNamespace(ctx, ankhFile, wave)
+		})
 	}
+}
 
+func executeChartsByNamespace(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile, charts []ankh.Chart) {
 	logChartsExecute := func(charts []ankh.Chart, namespace string, extra string) {
 		plural := "s"
 		n := len(charts)
@@ -505,37 +597,51 @@ func executeAnkhFile(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) {
 		}
 		names := []string{}
 		for _, chart := range charts {
-			names = append(names, chart.Name)
+			names = append(names, chart.InstanceName())
 		}
 		ctx.Logger.Infof("Using %vnamespace \"%v\" for %v chart%v [ %v ]",
 			extra, namespace, n, plural, strings.Join(names, ", "))
 	}
 
-	if ctx.Namespace != nil {
-		// Namespace overridden on the command line, so use that one for everything.
-		namespace := *ctx.Namespace
-		logChartsExecute(ankhFile.Charts, namespace, "command-line override ")
-		executeChartsOnNamespace(ctx, ankhFile, ankhFile.Charts, namespace)
-	} else {
-		// Gather charts by namespace, and execute them in sets.
-		chartSets := make(map[string][]ankh.Chart)
-		for _, chart := range ankhFile.Charts {
-			namespace := *chart.ChartMeta.Namespace
-			chartSets[namespace] = append(chartSets[namespace], chart)
+	// Gather charts by namespace, and execute them in sets. A namespace
+	// overridden on the command line takes precedence, either for every
+	// chart, or for single charts. Overridden charts are kept in sets of
+	// their own, so that the logs say where each namespace came from.
+	type chartSet struct {
+		namespace  string
+		overridden bool
+	}
+	chartSets := make(map[chartSet][]ankh.Chart)
+	for _, chart := range charts {
+		set := chartSet{}
+		if override := ctx.ChartNamespaceOverride(chart); override != nil {
+			set = chartSet{namespace: *override, overridden: true}
+		} else {
+			set = chartSet{namespace: *chart.ChartMeta.Namespace}
 		}
+		chartSets[set] = append(chartSets[set], chart)
+	}
 
-		// Sort the namespaces. We don't guarantee this behavior, but it's more sane than
-		// letting the namespace ordering depend on unorderd golang maps.
-		allNamespaces := []string{}
-		for namespace, _ := range chartSets {
-			allNamespaces = append(allNamespaces, namespace)
+	// Sort the namespaces. We don't guarantee this behavior, but it's more sane than
+	// letting the namespace ordering depend on unorderd golang maps.
+	allSets := []chartSet{}
+	for set, _ := range chartSets {
+		allSets = append(allSets, set)
+	}
+	sort.Slice(allSets, func(i, j int) bool {
+		if allSets[i].namespace != allSets[j].namespace {
+			return allSets[i].namespace < allSets[j].namespace
 		}
-		sort.Strings(allNamespaces)
-		for _, namespace := range allNamespaces {
-			charts := chartSets[namespace]
-			logChartsExecute(charts, namespace, "")
-			executeChartsOnNamespace(ctx, ankhFile, charts, namespace)
+		return !allSets[i].overridden
+	})
+	for _, set := range allSets {
+		charts := chartSets[set]
+		extra := ""
+		if set.overridden {
+			extra = "command-line override "
 		}
+		logChartsExecute(charts, set.namespace, extra)
+		executeChartsOnNamespace(ctx, ankhFile, charts, set.namespace)
 	}
 }
 
@@ -547,28 +653,76 @@ func executeContext(ctx *ankh.ExecutionContext, rootAnkhFile *ankh.AnkhFile) {
 		log.Debugf("Skipping dependencies since we are operating only on chart %v", ctx.Chart)
 	}
 
+	ankhFiles := map[string]ankh.AnkhFile{}
+	parsed := []ankh.AnkhFile{}
 	for _, dep := range dependencies {
-		log.Infof("Satisfying dependency: %v", dep)
-
-		ankhFilePath := dep
-		ankhFile, err := ankh.ParseAnkhFile(ankhFilePath)
+		ankhFile, err := ankh.ParseAnkhFile(dep)
 		if err == nil {
-			ctx.Logger.Debugf("- OK: %v", ankhFilePath)
+			ctx.Logger.Debugf("- OK: %v", dep)
 		}
 		check(err)
+		ankh.SelectCharts(ctx, &ankhFile)
+		ankhFiles[dep] = ankhFile
+		parsed = append(parsed, ankhFile)
+	}
+	waves, err := graph.DependencyOrder(dependencies, parsed)
+	check(err)
 
-		ctx.WorkingPath = path.Dir(ankhFilePath)
-		executeAnkhFile(ctx, &ankhFile)
-		ctx.WorkingPath = ""
+	if ctx.ChartSelector != nil {
+		selected := len(rootAnkhFile.Charts)
+		for _, ankhFile := range parsed {
+			selected += len(ankhFile.Charts)
+		}
+		if selected == 0 {
+			ctx.Logger.Fatalf("No charts match selector %v", ctx.ChartSelector)
+		}
+	}
 
-		log.Infof("Finished satisfying dependency: %v", dep)
+	for i, wave := range waves {
+		for _, dep := range wave {
+			ankhFile := ankhFiles[dep]
+			if ctx.ChartSelector != nil && len(ankhFile.Charts) == 0 {
+				log.Infof("Skipping dependency %v, which has no charts matching selector %v", dep, ctx.ChartSelector)
+				continue
+			}
+			log.Infof("Satisfying dependency: %v", dep)
+
+			ctx.WorkingPath = path.Dir(dep)
+			// Later dependencies may need this one to be ready, not just applied.
+			withReadiness(ctx, i < len(waves)-1 && hasDependents(graph.DependencyName(dep, ankhFile), parsed), func() {
+				executeAnkhFile(ctx, &ankhFile, dep)
+			})
+			ctx.WorkingPath = ""
+
+			log.Infof("Finished satisfying dependency: %v", dep)
+		}
 	}
 
 	if len(rootAnkhFile.Charts) > 0 {
-		executeAnkhFile(ctx, rootAnkhFile)
+		executeAnkhFile(ctx, rootAnkhFile, "")
 	} else if len(dependencies) == 0 {
-		if ctx.AnkhConfig.Helm.Repository == "" || ctx.NoPrompt {
+		if (ctx.AnkhConfig.Helm.Repository == "" && ctx.AnkhConfig.Catalog == "") || ctx.NoPrompt {
 			ctx.Logger.Fatalf("No charts nor dependencies provided, nothing to do")
+		} else if ctx.AnkhConfig.Catalog != "" {
+			// Prompt for a service from the catalog
+			ctx.Logger.Infof("No chart specified as an argument, and no `charts` found in an Ankh file")
+			entries, err := catalog.Fetch(ctx)
+			check(err)
+			if len(entries) == 0 {
+				ctx.Logger.Fatalf("The service catalog at %v lists no services", ctx.AnkhConfig.Catalog)
+			}
+
+			selection, err := util.PromptForSelection(catalog.Table(entries), "Select a service", true)
+			check(err)
+			entry := catalog.FindByName(entries, strings.Fields(selection)[0])
+			if entry == nil {
+				ctx.Logger.Fatalf("Unable to find \"%v\" in the service catalog", selection)
+			}
+
+			rootAnkhFile.Charts = []ankh.Chart{ankh.Chart{Name: entry.Chart, HelmRepository: entry.Repository, CatalogEntry: entry}}
+			ctx.Logger.Infof("Using chart \"%v\" owned by \"%v\" based on prompt selection", entry.Chart, entry.Owner)
+
+			executeAnkhFile(ctx, rootAnkhFile, "")
 		} else {
 			// Prompt for a chart
 			ctx.Logger.Infof("No chart specified as an argument, and no `charts` found in an Ankh file")
@@ -581,18 +735,198 @@ func executeContext(ctx *ankh.ExecutionContext, rootAnkhFile *ankh.AnkhFile) {
 			rootAnkhFile.Charts = []ankh.Chart{ankh.Chart{Name: selectedChart}}
 			ctx.Logger.Infof("Using chart \"%v\" based on prompt selection", selectedChart)
 
-			executeAnkhFile(ctx, rootAnkhFile)
+			executeAnkhFile(ctx, rootAnkhFile, "")
+		}
+	}
+
+	warnUnusedChartNamespaces(ctx)
+}
+
+// Templates each chart individually so that images can be attributed to a chart,
+// but gets the live workloads of every chart with a single kubectl call.
+func reportImagesOnNamespace(ctx *ankh.ExecutionContext, charts []ankh.Chart, namespace string) {
+	items := []kubectl.BatchItem{}
+	for _, chart := range charts {
+		manifest, err := ankhlib.ExecutePlan(ctx, namespace, []string{}, &plan.Plan{
+			PlanStages: []plan.PlanStage{
+				plan.PlanStage{Stage: helm.NewTemplateStage([]ankh.Chart{chart})},
+			},
+		})
+		if err != nil {
+			ctx.Logger.Warnf("Could not template chart \"%v\": %v", chart.InstanceName(), err)
+			recordImageReport(ctx, chart.InstanceName(), "<error>")
+			continue
+		}
+		items = append(items, kubectl.BatchItem{Name: chart.InstanceName(), Manifest: manifest})
+	}
+
+	images, err := kubectl.GetImages(ctx, namespace, items)
+	for _, item := range items {
+		if err != nil {
+			ctx.Logger.Warnf("Could not get images for chart \"%v\": %v", item.Name, err)
+			recordImageReport(ctx, item.Name, "<error>")
+			continue
+		}
+		recordImageReport(ctx, item.Name, images[item.Name])
+	}
+}
+
+// Records the chart version and tag that each chart's live workloads are
+// running, before they are changed, so that the default slack message can
+// show what changed. Only the first context of an environment is recorded.
+func recordPreviousReleases(ctx *ankh.ExecutionContext, charts []ankh.Chart, namespace string) {
+	items := []kubectl.BatchItem{}
+	for _, chart := range charts {
+		if _, ok := ctx.PreviousReleases[chart.InstanceName()]; ok {
+			continue
+		}
+		manifest, err := ankhlib.ExecutePlan(ctx, namespace, []string{}, &plan.Plan{
+			PlanStages: []plan.PlanStage{
+				plan.PlanStage{Stage: helm.NewTemplateStage([]ankh.Chart{chart})},
+			},
+		})
+		if err != nil {
+			ctx.Logger.Debugf("Could not template chart \"%v\" to find what it is running: %v", chart.InstanceName(), err)
+			continue
+		}
+		items = append(items, kubectl.BatchItem{Name: chart.InstanceName(), Manifest: manifest})
+	}
+	if len(items) == 0 {
+		return
+	}
+
+	releases, err := kubectl.GetLiveReleases(ctx, namespace, items)
+	if err != nil {
+		ctx.Logger.Warnf("Could not get what charts are running for the slack message: %v", err)
+		return
+	}
+	if ctx.PreviousReleases == nil {
+		ctx.PreviousReleases = make(map[string]ankh.PreviousRelease)
+	}
+	for _, chart := range charts {
+		if release, ok := releases[chart.InstanceName()]; ok {
+			ctx.PreviousReleases[chart.InstanceName()] = previousRelease(chart, release)
 		}
 	}
 }
 
+// Finds the chart version from the chart label of a chart's live workloads,
+// and the tag from the image named by the chart's `tagImage`, or else the
+// only tag that its images have.
+func previousRelease(chart ankh.Chart, release kubectl.LiveRelease) ankh.PreviousRelease {
+	previous := ankh.PreviousRelease{}
+	if strings.HasPrefix(release.ChartLabel, chart.Name+"-") {
+		previous.ChartVersion = strings.TrimPrefix(release.ChartLabel, chart.Name+"-")
+	}
+
+	tags := map[string]bool{}
+	for _, image := range release.Images {
+		repository, tag := splitImageTag(image)
+		if tag == "" {
+			continue
+		}
+		tagImage := chart.ChartMeta.TagImage
+		if tagImage != "" && (repository == tagImage || strings.HasSuffix(repository, "/"+tagImage)) {
+			previous.Tag = tag
+			return previous
+		}
+		tags[tag] = true
+	}
+	if len(tags) == 1 {
+		for tag := range tags {
+			previous.Tag = tag
+		}
+	}
+	return previous
+}
+
+// Splits an image into its repository and tag, eg: `registry:5000/api:1.2`
+// into `registry:5000/api` and `1.2`. Digests are ignored.
+func splitImageTag(image string) (string, string) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return image, ""
+	}
+	return image[:i], image[i+1:]
+}
+
+func recordImageReport(ctx *ankh.ExecutionContext, chart string, images string) {
+	if ctx.ImageReport == nil {
+		ctx.ImageReport = make(map[string]map[string]string)
+	}
+	if ctx.ImageReport[chart] == nil {
+		ctx.ImageReport[chart] = make(map[string]string)
+	}
+	if images == "" {
+		images = "-"
+	}
+	target := ctx.AnkhConfig.CurrentContextName
+	if ctx.AnkhConfig.CurrentClusterName != "" {
+		target += "/" + ctx.AnkhConfig.CurrentClusterName
+	}
+	ctx.ImageReport[chart][target] = images
+}
+
+func printImageReport(ctx *ankh.ExecutionContext, contexts []string) {
+	if len(contexts) == 0 {
+		contexts = []string{ctx.AnkhConfig.CurrentContextName}
+	}
+
+	// Each cluster of a multi-cluster context gets its own column, so that
+	// drift between them stands out.
+	targets := []string{}
+	for _, context := range contexts {
+		clusters := ctx.AnkhConfig.Contexts[context].Clusters
+		if len(clusters) == 0 {
+			targets = append(targets, context)
+		}
+		for _, cluster := range clusters {
+			targets = append(targets, context+"/"+cluster.Name)
+		}
+	}
+	contexts = targets
+
+	charts := []string{}
+	for k, _ := range ctx.ImageReport {
+		charts = append(charts, k)
+	}
+	sort.Strings(charts)
+
+	buf := bytes.NewBufferString("")
+	w := tabwriter.NewWriter(buf, 0, 8, 8, ' ', 0)
+	fmt.Fprintf(w, "CHART\t%v\tDRIFT\n", strings.Join(contexts, "\t"))
+	for _, chart := range charts {
+		row := []string{}
+		distinct := make(map[string]bool)
+		for _, context := range contexts {
+			images, ok := ctx.ImageReport[chart][context]
+			if !ok {
+				images = "-"
+			}
+			row = append(row, images)
+			distinct[images] = true
+		}
+		// Highlight any chart whose images are not the same everywhere.
+		drift := ""
+		if len(distinct) > 1 {
+			drift = "*"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\n", chart, strings.Join(row, "\t"), drift)
+	}
+	w.Flush()
+	fmt.Print(buf.String())
+}
+
 func checkContext(ankhConfig *ankh.AnkhConfig, context string) {
 	_, ok := ankhConfig.Contexts[context]
 	if !ok {
 		log.Errorf("Context '%v' not found in `contexts`", context)
 		log.Info("The following contexts are available:")
 		printContexts(ankhConfig)
-		os.Exit(1)
+		exitWithError()
 	}
 }
 
@@ -603,146 +937,227 @@ func switchContext(ctx *ankh.ExecutionContext, ankhConfig *ankh.AnkhConfig, cont
 		printContexts(ankhConfig)
 		log.Info("The following environments are available:")
 		printEnvironments(ankhConfig)
-		os.Exit(1)
+		exitWithError()
 	}
 
 	checkContext(ankhConfig, context)
-	errs := ankhConfig.ValidateAndInit(ctx, context)
-	if len(errs) > 0 && !ctx.IgnoreContextAndEnv {
+	if err := ankhlib.UseContext(ctx, ankhConfig, context); err != nil {
 		// The config validation errors are not recoverable.
-		log.Fatalf("%v", util.MultiErrorFormat(errs))
+		log.Fatalf("%v", err)
 	}
 }
 
 func planAndExecute(ctx *ankh.ExecutionContext, charts []ankh.Chart, namespace string, wildCardLabels []string) (string, error) {
+	switch ctx.Mode {
+	case ankh.Explain, ankh.Apply, ankh.Deploy:
+		crdOut, err := ankhlib.InstallCrds(ctx, charts)
+		if err != nil {
+			return "", err
+		}
+		if crdOut != "" {
+			out, err := planAndExecuteCharts(ctx, charts, namespace, wildCardLabels)
+			return crdOut + " && \\\n" + out, err
+		}
+	}
+	return planAndExecuteCharts(ctx, charts, namespace, wildCardLabels)
+}
+
+func planAndExecuteCharts(ctx *ankh.ExecutionContext, charts []ankh.Chart, namespace string, wildCardLabels []string) (string, error) {
+	if ctx.Mode == ankh.Deploy && len(canaryCharts(charts)) > 0 {
+		proceed, err := deployCanary(ctx, charts, namespace, wildCardLabels)
+		if err != nil {
+			return "", err
+		}
+		if !proceed {
+			return "", fmt.Errorf("Aborted the rollout after the canary. The canary remains applied until the chart is deployed or applied again.")
+		}
+	}
+	return ankhlib.ExecutePlan(ctx, namespace, wildCardLabels, newPlan(ctx, charts))
+}
+
+// Returns the stages that the current mode runs over a set of charts.
+func newPlan(ctx *ankh.ExecutionContext, charts []ankh.Chart) *plan.Plan {
 	switch ctx.Mode {
 	case ankh.Template:
-		return plan.Execute(ctx, namespace, wildCardLabels, &plan.Plan{
-			PlanStages: []plan.PlanStage{
-				plan.PlanStage{Stage: helm.NewTemplateStage(charts)},
-			},
-		})
+		return ankhlib.TemplatePlan(charts)
 	case ankh.Lint:
-		return plan.Execute(ctx, namespace, wildCardLabels, &plan.Plan{
+		return &plan.Plan{
 			PlanStages: []plan.PlanStage{
 				plan.PlanStage{Stage: helm.NewTemplateStage(charts)},
-				plan.PlanStage{Stage: helm.NewLintStage()},
+				plan.PlanStage{Stage: helm.NewLintStage(charts)},
 			},
-		})
+		}
 	case ankh.Logs:
-		return plan.Execute(ctx, namespace, wildCardLabels, &plan.Plan{
+		logStage := kubectl.NewLogStage()
+		if ctx.AllPods {
+			logStage = kubectl.NewMultiPodLogStage()
+		}
+		return &plan.Plan{
 			PlanStages: []plan.PlanStage{
 				plan.PlanStage{Stage: helm.NewTemplateStage(charts)},
 				plan.PlanStage{Stage: kubectl.NewPodSelectionStage()},
-				plan.PlanStage{Stage: kubectl.NewLogStage()},
+				plan.PlanStage{Stage: logStage},
 			},
-		})
+		}
 	case ankh.Exec:
-		return plan.Execute(ctx, namespace, wildCardLabels, &plan.Plan{
+		execStage := kubectl.NewExecStage()
+		if ctx.AllPods {
+			execStage = kubectl.NewMultiPodExecStage()
+		}
+		return &plan.Plan{
 			PlanStages: []plan.PlanStage{
 				plan.PlanStage{Stage: helm.NewTemplateStage(charts)},
 				plan.PlanStage{Stage: kubectl.NewPodSelectionStage()},
-				plan.PlanStage{Stage: kubectl.NewExecStage()},
+				plan.PlanStage{Stage: execStage},
 			},
-		})
+		}
 	case ankh.Pods:
-		return plan.Execute(ctx, namespace, wildCardLabels, &plan.Plan{
+		podStage := kubectl.NewPodStage()
+		if ctx.PodDashboard {
+			podStage = kubectl.NewPodDashboardStage()
+		}
+		return &plan.Plan{
 			PlanStages: []plan.PlanStage{
 				plan.PlanStage{Stage: helm.NewTemplateStage(charts)},
-				plan.PlanStage{Stage: kubectl.NewPodStage()},
+				plan.PlanStage{Stage: podStage},
 			},
-		})
+		}
+	case ankh.PortForward:
+		return &plan.Plan{
+			PlanStages: []plan.PlanStage{
+				plan.PlanStage{Stage: helm.NewTemplateStage(charts)},
+				plan.PlanStage{Stage: kubectl.NewPortForwardStage()},
+			},
+		}
 	case ankh.Get:
-		return plan.Execute(ctx, namespace, wildCardLabels, &plan.Plan{
+		return &plan.Plan{
 			PlanStages: []plan.PlanStage{
 				plan.PlanStage{Stage: helm.NewTemplateStage(charts)},
 				plan.PlanStage{Stage: kubectl.NewGetStage()},
 			},
-		})
+		}
 	case ankh.Rollback:
-		return plan.Execute(ctx, namespace, wildCardLabels, &plan.Plan{
+		return ankhlib.RollbackPlan(ctx, charts)
+	case ankh.History:
+		return &plan.Plan{
 			PlanStages: []plan.PlanStage{
 				plan.PlanStage{Stage: helm.NewTemplateStage(charts)},
-				plan.PlanStage{Stage: kubectl.NewRollbackStage()},
+				plan.PlanStage{Stage: kubectl.NewHistoryStage()},
 			},
-		})
+		}
 	case ankh.Diff:
-		return plan.Execute(ctx, namespace, wildCardLabels, &plan.Plan{
+		return ankhlib.DiffPlan(ctx, charts)
+	case ankh.Delete:
+		return &plan.Plan{
 			PlanStages: []plan.PlanStage{
 				plan.PlanStage{Stage: helm.NewTemplateStage(charts)},
-				plan.PlanStage{Stage: kubectl.NewDiffStage()},
+				plan.PlanStage{Stage: kubectl.NewDeleteStage()},
 			},
-		})
-	case ankh.Explain:
-		fallthrough
-	case ankh.Apply:
-		return plan.Execute(ctx, namespace, wildCardLabels, &plan.Plan{
+		}
+	case ankh.Scale:
+		return &plan.Plan{
 			PlanStages: []plan.PlanStage{
 				plan.PlanStage{Stage: helm.NewTemplateStage(charts)},
-				plan.PlanStage{Stage: kubectl.NewApplyStage()},
+				plan.PlanStage{Stage: kubectl.NewScaleStage()},
 			},
-		})
+		}
+	case ankh.Explain:
+		fallthrough
+	case ankh.Apply:
+		return ankhlib.ApplyPlan(ctx, charts)
 	case ankh.Deploy:
-		return plan.Execute(ctx, namespace, wildCardLabels, &plan.Plan{
-			PlanStages: []plan.PlanStage{
-				plan.PlanStage{Stage: helm.NewTemplateStage(charts)},
-				plan.PlanStage{Stage: kubectl.NewCheckStage(), Opts: plan.StageOpts{
-					PreExecute: func() bool {
-						// TODO better messaging
-						ctx.Logger.Infof("Checking to see that objects exist before applying...")
-						return true
-					},
-					OnFailure: func() bool {
-						// TODO better messaging
-						ctx.Logger.Warnf("Some objects do not yet exist. Apply will create the objects listed above.")
-						selection, err := util.PromptForSelection([]string{"Abort", "OK"},
-							"Are you certain that you want to continue to create new objects? Select OK to proceed.", false)
-						check(err)
-
-						if selection != "OK" {
-							ctx.Logger.Fatalf("Aborted.")
-						}
-						return true
-					},
-					PassThroughInput: true,
-				}},
-				plan.PlanStage{Stage: kubectl.NewApplyStage(), Opts: plan.StageOpts{
-					PreExecute: func() bool {
-						ctx.Logger.Infof("Applying...")
-						return true
-					},
-					PassThroughInput: true,
-				}},
-				plan.PlanStage{Stage: kubectl.NewPodStage(), Opts: plan.StageOpts{
-					PreExecute: func() bool {
-						// Evil hack
-						ctx.Logger.Infof("Watching pods... (press control-C to stop watching and continue)")
-						ctx.ExtraArgs = append(ctx.ExtraArgs, "-w")
-						ctx.ShouldCatchSignals = true
-						return true
-					},
-					PassThroughInput: true,
-				}},
-				plan.PlanStage{Stage: kubectl.NewRollbackStage(), Opts: plan.StageOpts{
-					PreExecute: func() bool {
-						// Evil hack
-						ctx.ShouldCatchSignals = false
-						ctx.ExtraArgs = []string{}
-
-						selection, err := util.PromptForSelection([]string{"OK", "Rollback"},
-							"Finished. Select OK to continue, or Rollback to rollback.", false)
-						check(err)
-
-						if selection == "OK" {
-							return false
-						}
-
-						ctx.Logger.Warnf("Rolling back... (kubectl output below may be terse)")
-						return true
-					},
-				}},
-			},
-		})
+		stages := []plan.PlanStage{
+			plan.PlanStage{Stage: helm.NewTemplateStage(charts)},
+			plan.PlanStage{Stage: helm.NewAnnotateStage(charts)},
+		}
+		if kubectl.CreatesNamespaces(ctx) {
+			stages = append(stages, ankhlib.NamespacePlanStage())
+		}
+		stages = append(stages,
+			plan.PlanStage{Stage: kubectl.NewCheckStage(), Opts: plan.StageOpts{
+				PreExecute: func() bool {
+					// TODO better messaging
+					ctx.Logger.Infof("Checking to see that objects exist before applying...")
+					return true
+				},
+				OnFailure: func() bool {
+					// TODO better messaging
+					ctx.Logger.Warnf("Some objects do not yet exist. Apply will create the objects listed above.")
+					selection, err := util.PromptForConfirmation([]string{"Abort", "OK"},
+						"Are you certain that you want to continue to create new objects? Select OK to proceed.")
+					check(err)
+
+					if selection != "OK" {
+						ctx.Logger.Fatalf("Aborted.")
+					}
+					return true
+				},
+				PassThroughInput: true,
+				Description:      "checks that the objects exist, and prompts before creating any that do not",
+			}})
+		if helm.HasHooks(charts, helm.HookPreApply) {
+			stages = append(stages, ankhlib.HookPlanStage(charts, helm.HookPreApply))
+		}
+		stages = append(stages,
+			plan.PlanStage{Stage: kubectl.NewApplyStage(), Opts: plan.StageOpts{
+				PreExecute: func() bool {
+					ctx.Logger.Infof("Applying...")
+					return true
+				},
+				PassThroughInput: true,
+			}})
+		if helm.HasHooks(charts, helm.HookPostApply) {
+			stages = append(stages, ankhlib.HookPlanStage(charts, helm.HookPostApply))
+		}
+		stages = append(stages,
+			plan.PlanStage{Stage: kubectl.NewPodStage(), Opts: plan.StageOpts{
+				PreExecute: func() bool {
+					// Evil hack
+					ctx.Logger.Infof("Watching pods... (press control-C to stop watching and continue)")
+					ctx.ExtraArgs = append(ctx.ExtraArgs, "-w")
+					ctx.ShouldCatchSignals = true
+					return true
+				},
+				PassThroughInput: true,
+				Description:      "watches pods until control-C",
+			}},
+			plan.PlanStage{Stage: kubectl.NewFailedPodStage(), Opts: plan.StageOpts{
+				PreExecute: func() bool {
+					// Evil hack
+					ctx.ShouldCatchSignals = false
+					ctx.ExtraArgs = []string{}
+					ctx.Logger.Infof("Checking for failing containers...")
+					return true
+				},
+				OnFailure: func() bool {
+					// Don't let a failed check get in the way of the rollback prompt
+					ctx.Logger.Warnf("Unable to check for failing containers")
+					return true
+				},
+				PassThroughInput: true,
+				Description:      "shows why containers are failing, if any are",
+			}},
+			plan.PlanStage{Stage: kubectl.NewRollbackStage(0), Opts: plan.StageOpts{
+				PreExecute: func() bool {
+					selection, err := util.PromptForConfirmation([]string{"OK", "Rollback"},
+						"Finished. Select OK to continue, or Rollback to rollback.")
+					check(err)
+
+					if selection == "OK" {
+						return false
+					}
+
+					ctx.Logger.Warnf("Rolling back... (kubectl output below may be terse)")
+					return true
+				},
+				Description: "prompts to continue, or to roll back",
+			}})
+		if helm.HasHooks(charts, helm.HookPostRollback) {
+			stages = append(stages, ankhlib.HookPlanStage(charts, helm.HookPostRollback))
+		}
+		return &plan.Plan{
+			PlanStages: stages,
+		}
 	default:
 		panic(fmt.Sprintf("Missing plan handler for mode %v!", ctx.Mode))
 	}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/kubectl"
+)
+
+func TestPreviousRelease(t *testing.T) {
+	release := kubectl.LiveRelease{
+		ChartLabel: "api-server-1.4.2-rc1",
+		Images:     []string{"registry:5000/api:20240105", "registry:5000/proxy:2.1@sha256:abcd"},
+	}
+
+	chart := ankh.Chart{Name: "api-server"}
+	previous := previousRelease(chart, release)
+	if previous.ChartVersion != "1.4.2-rc1" || previous.Tag != "" {
+		t.Logf("expected the version and no tag among several but got %+v", previous)
+		t.Fail()
+	}
+
+	chart.ChartMeta.TagImage = "api"
+	if previous := previousRelease(chart, release); previous.Tag != "20240105" {
+		t.Logf("expected the tag of the tagImage but got %+v", previous)
+		t.Fail()
+	}
+
+	release.Images = []string{"registry:5000/api"}
+	if previous := previousRelease(ankh.Chart{Name: "other"}, release); previous != (ankh.PreviousRelease{}) {
+		t.Logf("expected nothing for another chart's label and untagged images but got %+v", previous)
+		t.Fail()
+	}
+}
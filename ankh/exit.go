@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Fatal errors exit the process directly, skipping deferred calls, so each
+// part of a run that must finish on the way out sets a handler here. logrus
+// cannot unregister exit handlers, so a single one, registered once, runs the
+// handlers of the current run, in the order they were first set.
+var (
+	exitHandlers        = map[string]func(){}
+	exitHandlerOrder    []string
+	registerExitHandler sync.Once
+)
+
+// Sets what the current run does when it exits with an error, replacing what
+// an earlier run in the same process set under the same name.
+func onErrorExit(name string, handler func()) {
+	registerExitHandler.Do(func() {
+		logrus.RegisterExitHandler(runExitHandlers)
+	})
+	if _, ok := exitHandlers[name]; !ok {
+		exitHandlerOrder = append(exitHandlerOrder, name)
+	}
+	exitHandlers[name] = handler
+}
+
+func runExitHandlers() {
+	for _, name := range exitHandlerOrder {
+		exitHandlers[name]()
+	}
+}
+
+// Exits after an error that has already been logged, finishing the current
+// run as a fatal error would.
+func exitWithError() {
+	logrus.Exit(1)
+}
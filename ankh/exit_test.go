@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOnErrorExit(t *testing.T) {
+	defer func(handlers map[string]func(), order []string) {
+		exitHandlers, exitHandlerOrder = handlers, order
+	}(exitHandlers, exitHandlerOrder)
+	exitHandlers, exitHandlerOrder = map[string]func(){}, nil
+
+	// A second run in the same process replaces the handlers of the first.
+	calls := []string{}
+	onErrorExit("summary", func() { calls = append(calls, "first summary") })
+	onErrorExit("tracing", func() { calls = append(calls, "tracing") })
+	onErrorExit("summary", func() { calls = append(calls, "second summary") })
+
+	runExitHandlers()
+	if strings.Join(calls, ", ") != "second summary, tracing" {
+		t.Logf("expected only the handlers of the current run, in order, but got %v", calls)
+		t.Fail()
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/graph"
+)
+
+func chartNames(charts []ankh.Chart) []string {
+	names := []string{}
+	for _, chart := range charts {
+		names = append(names, chart.InstanceName())
+	}
+	return names
+}
+
+// Returns whether any of the Ankh files depends on the one with this name.
+func hasDependents(name string, ankhFiles []ankh.AnkhFile) bool {
+	for _, ankhFile := range ankhFiles {
+		for _, dep := range ankhFile.DependsOn {
+			if dep == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Runs fn, waiting for rollouts when applying if others depend on what fn
+// applies, so that they are ready, not just applied, before going on.
+func withReadiness(ctx *ankh.ExecutionContext, needed bool, fn func()) {
+	if !needed || ctx.Mode != ankh.Apply || ctx.DryRun || ctx.Wait {
+		fn()
+		return
+	}
+	ctx.Wait = true
+	defer func() { ctx.Wait = false }()
+	fn()
+}
+
+// Formats the order that an Ankh file's dependencies and charts are operated
+// on in. Entries with the same number are operated on together.
+func formatGraph(ankhFile ankh.AnkhFile, dependencies []ankh.AnkhFile) (string, error) {
+	out := strings.Builder{}
+	if len(ankhFile.Dependencies) > 0 {
+		waves, err := graph.DependencyOrder(ankhFile.Dependencies, dependencies)
+		if err != nil {
+			return "", err
+		}
+		dependsOn := map[string][]string{}
+		for i, path := range ankhFile.Dependencies {
+			dependsOn[path] = dependencies[i].DependsOn
+		}
+		fmt.Fprintf(&out, "Dependencies:\n")
+		for i, wave := range waves {
+			for _, path := range wave {
+				fmt.Fprintf(&out, "  %v. %v%v\n", i+1, path, formatDependsOn(dependsOn[path]))
+			}
+		}
+	}
+
+	if len(ankhFile.Charts) > 0 {
+		if err := graph.ValidateChartDependencies(ankhFile.Charts); err != nil {
+			return "", err
+		}
+		waves, err := graph.ChartWaves(ankhFile.Charts)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&out, "Charts:\n")
+		for i, wave := range waves {
+			for _, chart := range wave {
+				fmt.Fprintf(&out, "  %v. %v%v\n", i+1, chart.InstanceName(), formatDependsOn(chart.DependsOn))
+			}
+		}
+	}
+	return out.String(), nil
+}
+
+func formatDependsOn(dependsOn []string) string {
+	if len(dependsOn) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (after %v)", strings.Join(dependsOn, ", "))
+}
+
+// Prints the order of the dependencies and charts of an Ankh file.
+func printGraph(ankhFilePath string) {
+	ankhFile, err := ankh.ParseAnkhFile(ankhFilePath)
+	check(err)
+	dependencies := []ankh.AnkhFile{}
+	for _, dep := range ankhFile.Dependencies {
+		dependency, err := ankh.ParseAnkhFile(dep)
+		check(err)
+		dependencies = append(dependencies, dependency)
+	}
+
+	out, err := formatGraph(ankhFile, dependencies)
+	check(err)
+	if out == "" {
+		log.Infof("Ankh file %v has no dependencies nor charts", ankhFilePath)
+		return
+	}
+	fmt.Print(out)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/appnexus/ankh/context"
+)
+
+func TestFormatGraph(t *testing.T) {
+	ankhFile := ankh.AnkhFile{
+		Dependencies: []string{"api.yaml", "crds.yaml"},
+		Charts: []ankh.Chart{
+			{Name: "web", DependsOn: []string{"postgres"}},
+			{Name: "postgres"},
+			{Name: "redis"},
+		},
+	}
+	out, err := formatGraph(ankhFile, []ankh.AnkhFile{{DependsOn: []string{"crds.yaml"}}, {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `Dependencies:
+  1. crds.yaml
+  2. api.yaml (after crds.yaml)
+Charts:
+  1. postgres
+  1. redis
+  2. web (after postgres)
+`
+	if out != expected {
+		t.Logf("expected %v but got %v", expected, out)
+		t.Fail()
+	}
+
+	ankhFile.Charts[1].DependsOn = []string{"web"}
+	if _, err := formatGraph(ankhFile, []ankh.AnkhFile{{}, {}}); err == nil {
+		t.Logf("expected a dependency cycle to be reported")
+		t.Fail()
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/appnexus/ankh/config"
+	"github.com/appnexus/ankh/context"
+)
+
+const (
+	localRegistryContainer  = "ankh-registry"
+	localRegistryImage      = "registry:2"
+	localRegistryAddress    = "localhost:5000"
+	localChartRepoContainer = "ankh-chartmuseum"
+	localChartRepoImage     = "ghcr.io/helm/chartmuseum:v0.16.0"
+	localChartRepoURL       = "http://localhost:8080"
+)
+
+type localOpts struct {
+	Provider  string
+	Name      string
+	Registry  bool
+	ChartRepo bool
+}
+
+// Lets pods in a kind cluster pull images pushed to the local registry using
+// the same `localhost:5000` name as the host.
+const kindRegistryConfig = `kind: Cluster
+apiVersion: kind.x-k8s.io/v1alpha4
+containerdConfigPatches:
+- |-
+  [plugins."io.containerd.grpc.v1.cri".registry.mirrors."` + localRegistryAddress + `"]
+    endpoint = ["http://` + localRegistryContainer + `:5000"]
+`
+
+func detectLocalProvider() (string, error) {
+	for _, provider := range []string{"kind", "minikube"} {
+		if _, err := exec.LookPath(provider); err == nil {
+			return provider, nil
+		}
+	}
+	return "", fmt.Errorf("Neither `kind` nor `minikube` was found on your PATH. Install one of them, or pass `--provider`")
+}
+
+func newLocalCommand(ctx *ankh.ExecutionContext, name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	// kind and minikube write credentials for the new cluster to $KUBECONFIG
+	cmd.Env = append(os.Environ(), "KUBECONFIG="+ctx.KubeConfigPath)
+	return cmd
+}
+
+func runLocalCommand(ctx *ankh.ExecutionContext, name string, args ...string) error {
+	cmd := newLocalCommand(ctx, name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	ctx.Logger.Infof("Running `%v`", strings.Join(cmd.Args, " "))
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error running `%v`: %v", strings.Join(cmd.Args, " "), err)
+	}
+	return nil
+}
+
+func localCommandOutput(ctx *ankh.ExecutionContext, name string, args ...string) (string, error) {
+	out, err := newLocalCommand(ctx, name, args...).Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// Creates the cluster, or starts it if it already exists. Returns the
+// kube-context for the cluster.
+func startLocalCluster(ctx *ankh.ExecutionContext, opts localOpts) (string, error) {
+	switch opts.Provider {
+	case "kind":
+		clusters, err := localCommandOutput(ctx, "kind", "get", "clusters")
+		if err != nil {
+			return "", fmt.Errorf("Error listing kind clusters: %v", err)
+		}
+		for _, cluster := range strings.Fields(clusters) {
+			if cluster == opts.Name {
+				ctx.Logger.Infof("kind cluster \"%v\" already exists", opts.Name)
+				return "kind-" + opts.Name, nil
+			}
+		}
+
+		args := []string{"create", "cluster", "--name", opts.Name}
+		if opts.Registry {
+			configFile, err := ioutil.TempFile("", "ankh-kind-config")
+			if err != nil {
+				return "", err
+			}
+			defer os.Remove(configFile.Name())
+			if _, err := configFile.WriteString(kindRegistryConfig); err != nil {
+				configFile.Close()
+				return "", err
+			}
+			configFile.Close()
+			args = append(args, "--config", configFile.Name())
+		}
+		return "kind-" + opts.Name, runLocalCommand(ctx, "kind", args...)
+	case "minikube":
+		// `minikube start` is idempotent, and starts a stopped cluster.
+		return opts.Name, runLocalCommand(ctx, "minikube", "start", "--profile", opts.Name)
+	default:
+		return "", fmt.Errorf("Unsupported provider \"%v\", must be one of `kind` or `minikube`", opts.Provider)
+	}
+}
+
+// Runs a container in the background, unless one with the same name is already running.
+func ensureLocalContainer(ctx *ankh.ExecutionContext, name string, args ...string) error {
+	running, err := localCommandOutput(ctx, "docker", "inspect", "--format", "{{.State.Running}}", name)
+	if err == nil {
+		if running == "true" {
+			ctx.Logger.Infof("Container \"%v\" is already running", name)
+			return nil
+		}
+		return runLocalCommand(ctx, "docker", "start", name)
+	}
+
+	runArgs := append([]string{"run", "--detach", "--restart", "always", "--name", name}, args...)
+	return runLocalCommand(ctx, "docker", runArgs...)
+}
+
+func startLocalRegistry(ctx *ankh.ExecutionContext, opts localOpts) error {
+	if opts.Provider == "minikube" {
+		// The registry addon exposes the registry on port 5000 of every node, so
+		// pods can pull `localhost:5000` images without any extra configuration.
+		if err := runLocalCommand(ctx, "minikube", "addons", "enable", "registry", "--profile", opts.Name); err != nil {
+			return err
+		}
+		ctx.Logger.Infof("To push images from this host, forward the registry with "+
+			"`kubectl --context %v --namespace kube-system port-forward service/registry 5000:80`", opts.Name)
+		return nil
+	}
+
+	if err := ensureLocalContainer(ctx, localRegistryContainer,
+		"--publish", "127.0.0.1:5000:5000", localRegistryImage); err != nil {
+		return err
+	}
+
+	// kind nodes run on the `kind` docker network, and reach the registry by its container name.
+	out, err := newLocalCommand(ctx, "docker", "network", "connect", "kind", localRegistryContainer).CombinedOutput()
+	if err != nil && !strings.Contains(string(out), "already exists") {
+		return fmt.Errorf("Error connecting the registry to the kind network: %v: %v", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func startLocalChartRepo(ctx *ankh.ExecutionContext) error {
+	err := ensureLocalContainer(ctx, localChartRepoContainer,
+		"--publish", "127.0.0.1:8080:8080",
+		"--env", "STORAGE=local",
+		"--env", "STORAGE_LOCAL_ROOTDIR=/charts",
+		localChartRepoImage)
+	if err != nil {
+		return err
+	}
+	ctx.Logger.Infof("ChartMuseum accepts charts with `curl --data-binary @CHART.tgz %v/api/charts`", localChartRepoURL)
+	return nil
+}
+
+// Adds a context and environment for the local cluster to the Ankh config, and
+// points the docker and helm config at anything installed alongside it.
+func addLocalContext(ctx *ankh.ExecutionContext, opts localOpts, kubeContext string) {
+	// Use the original, unmerged config. We want to explicitly avoid
+	// serializing the contents of any remote configs.
+	newAnkhConfig, err := config.GetAnkhConfig(ctx, ctx.AnkhConfigPath)
+	if err != nil {
+		newAnkhConfig = ankh.AnkhConfig{}
+	}
+
+	if newAnkhConfig.Contexts == nil {
+		newAnkhConfig.Contexts = map[string]ankh.Context{}
+	}
+	newAnkhConfig.Contexts[kubeContext] = ankh.Context{
+		KubeContext:      kubeContext,
+		EnvironmentClass: "dev",
+		ResourceProfile:  "constrained",
+		Release:          opts.Name,
+	}
+
+	if newAnkhConfig.Environments == nil {
+		newAnkhConfig.Environments = map[string]ankh.Environment{}
+	}
+	newAnkhConfig.Environments[kubeContext] = ankh.Environment{
+		Contexts: []string{kubeContext},
+	}
+
+	if opts.Registry {
+		if r := newAnkhConfig.Docker.Registry; r != "" && r != localRegistryAddress {
+			ctx.Logger.Warnf("Replacing `docker.registry` \"%v\" with the local registry", r)
+		}
+		newAnkhConfig.Docker.Registry = localRegistryAddress
+	}
+	if opts.ChartRepo {
+		if r := newAnkhConfig.Helm.Repository; r != "" && r != localChartRepoURL {
+			ctx.Logger.Warnf("Replacing `helm.repository` \"%v\" with the local chart repository", r)
+		}
+		newAnkhConfig.Helm.Repository = localChartRepoURL
+	}
+
+	writeAnkhConfig(ctx, newAnkhConfig)
+}
+
+// Brings up a local cluster, along with an optional registry and chart
+// repository, and an Ankh context to deploy to it.
+func runLocalUp(ctx *ankh.ExecutionContext, opts localOpts) {
+	var err error
+	if opts.Provider == "" {
+		opts.Provider, err = detectLocalProvider()
+		check(err)
+	}
+	if opts.Name == "" {
+		opts.Name = "ankh"
+		if opts.Provider == "minikube" {
+			// The default minikube profile, which matches the sample context from `ankh config init`
+			opts.Name = "minikube"
+		}
+	}
+
+	if opts.ChartRepo || (opts.Registry && opts.Provider == "kind") {
+		_, err = exec.LookPath("docker")
+		check(err)
+	}
+
+	// The kind network is created along with the first cluster, so the registry
+	// is started afterwards.
+	kubeContext, err := startLocalCluster(ctx, opts)
+	check(err)
+
+	if opts.Registry {
+		check(startLocalRegistry(ctx, opts))
+	}
+	if opts.ChartRepo {
+		check(startLocalChartRepo(ctx))
+	}
+
+	addLocalContext(ctx, opts, kubeContext)
+	ctx.Logger.Infof("Local cluster is ready. Deploy to it with `ankh --context %v apply`", kubeContext)
+}
@@ -7,12 +7,13 @@ import (
 	"os"
 	"os/signal"
 	"path"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/imdario/mergo"
 	"github.com/jawher/mow.cli"
 	"github.com/mattn/go-isatty"
 	"github.com/sirupsen/logrus"
@@ -21,9 +22,15 @@ import (
 
 	"github.com/appnexus/ankh/config"
 	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/debug"
 	"github.com/appnexus/ankh/docker"
 	"github.com/appnexus/ankh/helm"
 	"github.com/appnexus/ankh/kubectl"
+	"github.com/appnexus/ankh/ledger"
+	ankhlib "github.com/appnexus/ankh/pkg/ankh"
+	"github.com/appnexus/ankh/server"
+	"github.com/appnexus/ankh/stats"
+	"github.com/appnexus/ankh/update"
 	"github.com/appnexus/ankh/util"
 )
 
@@ -37,6 +44,12 @@ func check(err error) {
 	}
 }
 
+// Whether an option was set from its environment variable. Like mow.cli, an
+// empty variable counts as unset, so that it does not override any default.
+func setFromEnv(name string) bool {
+	return os.Getenv(name) != ""
+}
+
 func setLogLevel(ctx *ankh.ExecutionContext, level logrus.Level) {
 	if ctx.Quiet {
 		log.Level = logrus.ErrorLevel
@@ -60,16 +73,175 @@ func signalHandler(ctx *ankh.ExecutionContext, sigs chan os.Signal) {
 	}
 }
 
+const promptJournalFileName = "prompt-journal.yaml"
+
+// Records answers to prompts, so that a run interrupted at a prompt can be
+// resumed by running the same command again, and offers to resume one.
+func startPromptJournal(ctx *ankh.ExecutionContext, journalPath string, wd string) {
+	journal := util.NewPromptJournal(journalPath, os.Args[1:], wd)
+	interrupted, err := journal.FindInterrupted()
+	if err != nil {
+		ctx.Logger.Debugf("Unable to read the prompt journal %v: %v", journalPath, err)
+	}
+	if interrupted != nil && !ctx.NoPrompt {
+		selection, err := util.PromptForConfirmation([]string{"Resume", "Start over"},
+			fmt.Sprintf("This command was interrupted at a prompt %v ago, after %v answers. Resume with those answers?",
+				time.Since(interrupted.Interrupted).Round(time.Second), len(interrupted.Answers)))
+		check(err)
+		if selection == "Resume" {
+			journal.Resume(interrupted)
+		}
+	}
+	if interrupted != nil {
+		if err := journal.Discard(); err != nil {
+			ctx.Logger.Debugf("Unable to remove the prompt journal %v: %v", journalPath, err)
+		}
+	}
+	util.SetPromptJournal(journal)
+}
+
+// Chart arguments may be repeated, or passed as comma separated lists.
+func setChartArgs(ctx *ankh.ExecutionContext, chartArgs []string) {
+	charts := []string{}
+	for _, arg := range chartArgs {
+		for _, chart := range strings.Split(arg, ",") {
+			chart = strings.TrimSpace(chart)
+			if chart != "" {
+				charts = append(charts, chart)
+			}
+		}
+	}
+
+	ctx.Charts = charts
+	if len(charts) > 0 {
+		ctx.Chart = charts[0]
+	}
+}
+
+// Sets the name and label filters of `--filter-name` and `--filter-label`,
+// which narrow objects down along with the kinds of `--filter`.
+func setObjectFilters(ctx *ankh.ExecutionContext, filterName string, filterLabels []string) {
+	if filterName != "" {
+		if _, err := regexp.Compile(filterName); err != nil {
+			log.Fatalf("Invalid `--filter-name` \"%v\": %v", filterName, err)
+		}
+	}
+	ctx.FilterName = filterName
+	ctx.FilterLabels = nil
+	if len(filterLabels) > 0 {
+		selector, err := ankh.ParseLabelSelector(strings.Join(filterLabels, ","))
+		if err != nil {
+			log.Fatalf("Invalid `--filter-label`: %v", err)
+		}
+		ctx.FilterLabels = selector
+	}
+}
+
+// Initializes the Ankh config at ctx.AnkhConfigPath. When prompting is allowed,
+// this runs an interactive wizard and also writes a sample Ankh file to the
+// current directory.
+func writeAnkhConfig(ctx *ankh.ExecutionContext, newAnkhConfig ankh.AnkhConfig) {
+	out, err := yaml.Marshal(newAnkhConfig)
+	check(err)
+
+	err = os.MkdirAll(path.Dir(ctx.AnkhConfigPath), 0755)
+	check(err)
+
+	err = util.WriteFileAtomic(ctx.AnkhConfigPath, out, 0644)
+	check(err)
+	ctx.Logger.Infof("Wrote Ankh config to %v", ctx.AnkhConfigPath)
+}
+
+func initAnkhConfig(ctx *ankh.ExecutionContext) {
+	// Use the original, unmerged config. We want to explicitly avoid
+	// serializing the contents of any remote configs.
+	newAnkhConfig, err := config.GetAnkhConfig(ctx, ctx.AnkhConfigPath)
+	if err != nil {
+		newAnkhConfig = ankh.AnkhConfig{}
+	}
+
+	if ctx.NoPrompt {
+		if len(newAnkhConfig.Contexts) == 0 {
+			newAnkhConfig.Contexts = map[string]ankh.Context{
+				"minikube": {
+					KubeContext:       "minikube",
+					EnvironmentClass:  "dev",
+					ResourceProfile:   "constrained",
+					Release:           "minikube",
+					HelmRepositoryURL: "https://kubernetes-charts.storage.googleapis.com",
+				},
+			}
+			ctx.Logger.Infof("Initializing `contexts` to a single sample context for kube-context `minikube`")
+		}
+
+		if len(newAnkhConfig.Environments) == 0 {
+			newAnkhConfig.Environments = map[string]ankh.Environment{
+				"minikube": {
+					Contexts: []string{"minikube"},
+				},
+			}
+			ctx.Logger.Infof("Initializing `environments` to a single sample envionment with context `minikube`'")
+		}
+	} else {
+		err = config.Wizard(ctx, &newAnkhConfig)
+		check(err)
+	}
+
+	writeAnkhConfig(ctx, newAnkhConfig)
+
+	if !ctx.NoPrompt {
+		err = config.WriteSampleAnkhFile(ctx, "ankh.yaml")
+		check(err)
+	}
+}
+
 func main() {
+	ankhlib.Version = AnkhBuildVersion
+	if len(os.Args) > 1 && os.Args[1] == completeCommand {
+		os.Exit(runComplete(os.Args[2:]))
+	}
+
 	app := cli.App("ankh", "Another Kubernetes Helper")
-	app.Spec = "[--verbose] [--quiet] [--no-prompt] [--ignore-config-errors] [--ankhconfig] [--kubeconfig] [--datadir] [--helmdir] [--release] [--context] [--environment] [--namespace] [--tag] [--set...]"
+	app.Spec = "[--verbose] [--quiet] [--no-prompt] [--ignore-config-errors] [--fix] [--ankhconfig] [--kubeconfig] [--datadir] [--helmdir] [--release] [--context] [--environment] [--context-selector] [--selector] [--namespace...] [--tag] [--tag-from-git] [--set...] [--set-string...] [--set-file...] [--set-from-file...] [--deployer] [--read-only] [--post-renderer] [--trace-endpoint] [--trace-file] [-o]"
 
 	var (
-		verbose            = app.BoolOpt("v verbose", false, "Verbose debug mode")
-		quiet              = app.BoolOpt("q quiet", false, "Quiet mode. Critical logging only. The quiet option overrides the verbose option.")
-		noPrompt           = app.BoolOpt("no-prompt", false, "Do not prompt for missing required configuration. Exit with non-zero status and a fatal log message instead.")
-		ignoreConfigErrors = app.BoolOpt("ignore-config-errors", false, "Ignore certain configuration errors that have defined, but potentially dangerous behavior.")
-		ankhconfig         = app.String(cli.StringOpt{
+		verbose = app.Bool(cli.BoolOpt{
+			Name:   "v verbose",
+			Value:  false,
+			Desc:   "Verbose debug mode",
+			EnvVar: "ANKH_VERBOSE",
+		})
+		quiet = app.Bool(cli.BoolOpt{
+			Name:   "q quiet",
+			Value:  false,
+			Desc:   "Quiet mode. Critical logging only. The quiet option overrides the verbose option.",
+			EnvVar: "ANKH_QUIET",
+		})
+		noPrompt = app.Bool(cli.BoolOpt{
+			Name:   "no-prompt",
+			Value:  false,
+			Desc:   "Do not prompt for missing required configuration. Exit with non-zero status and a fatal log message instead.",
+			EnvVar: "ANKH_NO_PROMPT",
+		})
+		ignoreConfigErrors = app.Bool(cli.BoolOpt{
+			Name:   "ignore-config-errors",
+			Value:  false,
+			Desc:   "Ignore certain configuration errors that have defined, but potentially dangerous behavior.",
+			EnvVar: "ANKH_IGNORE_CONFIG_ERRORS",
+		})
+		output = app.String(cli.StringOpt{
+			Name:   "o output",
+			Value:  "",
+			Desc:   "Output format for listings, eg: `chart ls`, `image tags` and `config get-contexts`. One of `json` or `yaml`. Tables are shown by default.",
+			EnvVar: "ANKH_OUTPUT",
+		})
+		fixConfig = app.Bool(cli.BoolOpt{
+			Name:   "fix",
+			Value:  false,
+			Desc:   "Fix deprecated and unused keys in local Ankh configs, keeping a `.bak` of each original",
+			EnvVar: "ANKH_FIX",
+		})
+		ankhconfig = app.String(cli.StringOpt{
 			Name:   "ankhconfig",
 			Value:  path.Join(os.Getenv("HOME"), ".ankh", "config"),
 			Desc:   "The ankh config to use. ANKHCONFIG may be set to include a list of ankh configs to merge. Similar behavior to kubectl's KUBECONFIG.",
@@ -85,25 +257,38 @@ func main() {
 			Name:   "r release",
 			Value:  "",
 			Desc:   "The release to use. Must provide this, or have a release already present in the target context",
-			EnvVar: "ANKHRELEASE",
+			EnvVar: "ANKHRELEASE ANKH_RELEASE",
 		})
 		context = app.String(cli.StringOpt{
 			Name:   "c context",
 			Value:  "",
 			Desc:   "The context to use. Must provide this, or an environment via --environment",
-			EnvVar: "ANKHCONTEXT",
+			EnvVar: "ANKHCONTEXT ANKH_CONTEXT",
 		})
 		environment = app.String(cli.StringOpt{
 			Name:   "e environment",
 			Value:  "",
 			Desc:   "The environment to use. Must provide this, or an individual context via `--context`",
-			EnvVar: "ANKHENVIRONMENT",
+			EnvVar: "ANKHENVIRONMENT ANKH_ENVIRONMENT",
+		})
+		contextSelector = app.String(cli.StringOpt{
+			Name:   "context-selector",
+			Value:  "",
+			Desc:   "Operate on the contexts whose `labels` match, eg: `region=us-east-1,tier!=canary`. Selects from every context, or from the contexts of `--environment`",
+			EnvVar: "ANKH_CONTEXT_SELECTOR",
+		})
+		chartSelector = app.String(cli.StringOpt{
+			Name:   "selector",
+			Value:  "",
+			Desc:   "Operate on the charts of the Ankh file whose `labels` match, eg: `tier=backend,team!=data`. Applies to the charts of its dependencies, too",
+			EnvVar: "ANKH_SELECTOR",
 		})
 		namespaceSet = false
-		namespace    = app.String(cli.StringOpt{
+		namespace    = app.Strings(cli.StringsOpt{
 			Name:      "n namespace",
-			Value:     "",
-			Desc:      "The namespace to use with kubectl. Optional. Overrides any other ways to set a namespace.",
+			Value:     []string{},
+			Desc:      "The namespace to use with kubectl. Optional. Overrides any other ways to set a namespace. May also be given as `chart=namespace`, repeatedly, to override the namespace of a single chart, by name or alias.",
+			EnvVar:    "ANKH_NAMESPACE",
 			SetByUser: &namespaceSet,
 		})
 		tagSet = false
@@ -111,18 +296,44 @@ func main() {
 			Name:      "t tag",
 			Value:     "",
 			Desc:      "The tag value to use. This value is passed to helm as `--set $tagKey=$tag`. Requires a `tagKey` to be configured, either on the `chart` in an Ankh file, or in an `ankh.yaml` inside the Helm chart. Only valid when Ankh has a single chart to operate over, eg: with `--chart` or when an Ankh file has one chart entry.",
+			EnvVar:    "ANKH_TAG",
 			SetByUser: &tagSet,
 		})
+		tagFromGit = app.Bool(cli.BoolOpt{
+			Name:   "tag-from-git",
+			Value:  false,
+			Desc:   "Use the short sha of the current git commit as the tag value, for charts with a `tagKey`. Before applying, checks that the tag exists in the registry. `--tag` takes precedence.",
+			EnvVar: "ANKH_TAG_FROM_GIT",
+		})
 		datadir = app.String(cli.StringOpt{
 			Name:   "datadir",
 			Value:  path.Join("/tmp", ".ankh", "data"),
 			Desc:   "The data directory for Ankh template history",
-			EnvVar: "ANKHDATADIR",
+			EnvVar: "ANKHDATADIR ANKH_DATADIR",
 		})
 		helmSet = app.Strings(cli.StringsOpt{
-			Name:  "set",
-			Desc:  "Variables passed through to helm via --set",
-			Value: []string{},
+			Name:   "set",
+			Desc:   "Variables passed through to helm via --set",
+			Value:  []string{},
+			EnvVar: "ANKH_SET",
+		})
+		helmSetString = app.Strings(cli.StringsOpt{
+			Name:   "set-string",
+			Desc:   "Variables passed through to helm via --set-string, which keeps values that look like numbers or booleans as strings, eg: `version=1.10`",
+			Value:  []string{},
+			EnvVar: "ANKH_SET_STRING",
+		})
+		helmSetFile = app.Strings(cli.StringsOpt{
+			Name:   "set-file",
+			Desc:   "Variables passed through to helm via --set-file, as `key=path`, to use the contents of a file as the value for key, eg: a certificate",
+			Value:  []string{},
+			EnvVar: "ANKH_SET_FILE",
+		})
+		helmSetFromFile = app.Strings(cli.StringsOpt{
+			Name:   "set-from-file",
+			Desc:   "Files of variables passed through to helm via --set, eg: from a previous CI step. Either a YAML map or `key=value` lines, or `key=path` to use a file's contents as the value for key. Values from --set take precedence.",
+			Value:  []string{},
+			EnvVar: "ANKH_SET_FROM_FILE",
 		})
 		helmdir = app.String(cli.StringOpt{
 			Name:   "helmdir",
@@ -130,6 +341,36 @@ func main() {
 			Desc:   "The local home directory for helm",
 			EnvVar: "HELM_HOME",
 		})
+		deployer = app.String(cli.StringOpt{
+			Name:   "deployer",
+			Value:  "",
+			Desc:   "Who is operating, as shown in notifications and release history, eg: when running as a shared CI account. Defaults to the user that triggered the GitHub Actions, GitLab CI or Jenkins job, or else the current user.",
+			EnvVar: "ANKH_DEPLOYER",
+		})
+		readOnly = app.Bool(cli.BoolOpt{
+			Name:   "read-only",
+			Value:  false,
+			Desc:   "Refuse to run any command that changes a cluster or a repository, eg: apply, deploy, rollback, delete, exec and chart publish. Dry runs are still allowed. Same as `readOnly` in ankh config.",
+			EnvVar: "ANKH_READ_ONLY",
+		})
+		postRenderer = app.String(cli.StringOpt{
+			Name:   "post-renderer",
+			Value:  "",
+			Desc:   "An executable that templated output is piped through before it is used, eg: a script running `kustomize build`. Overrides `helm.postRenderer` in ankh config.",
+			EnvVar: "ANKH_POST_RENDERER",
+		})
+		traceEndpoint = app.String(cli.StringOpt{
+			Name:   "trace-endpoint",
+			Value:  "",
+			Desc:   "An OTLP/HTTP endpoint to export OpenTelemetry traces of the run to, eg: http://localhost:4318",
+			EnvVar: "ANKH_TRACE_ENDPOINT OTEL_EXPORTER_OTLP_ENDPOINT",
+		})
+		traceFile = app.String(cli.StringOpt{
+			Name:   "trace-file",
+			Value:  "",
+			Desc:   "A local file to write OpenTelemetry traces of the run to, as OTLP JSON",
+			EnvVar: "ANKH_TRACE_FILE",
+		})
 	)
 
 	log.Out = os.Stdout
@@ -143,6 +384,15 @@ func main() {
 		setLogLevel(ctx, logrus.InfoLevel)
 
 		helmVars := map[string]string{}
+		for _, setFile := range *helmSetFromFile {
+			fileVars, err := util.ReadSetValuesFile(setFile)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			for k, v := range fileVars {
+				helmVars[k] = v
+			}
+		}
 		for _, helmkvPair := range *helmSet {
 			k := strings.SplitN(helmkvPair, "=", 2)
 			if len(k) != 2 {
@@ -151,14 +401,80 @@ func main() {
 				helmVars[k[0]] = k[1]
 			}
 		}
+		helmStringVars := map[string]string{}
+		for _, helmkvPair := range *helmSetString {
+			k := strings.SplitN(helmkvPair, "=", 2)
+			if len(k) != 2 {
+				log.Fatalf("Malformed --set-string argument '%v' (could not split on '='). Set arguments must be passed as 'key=value'", helmkvPair)
+			}
+			helmStringVars[k[0]] = k[1]
+		}
+		helmFileVars := map[string]string{}
+		for _, helmkvPair := range *helmSetFile {
+			k := strings.SplitN(helmkvPair, "=", 2)
+			if len(k) != 2 {
+				log.Fatalf("Malformed --set-file argument '%v' (could not split on '='). Set arguments must be passed as 'key=path'", helmkvPair)
+			}
+			// Paths are made absolute, since charts are templated from other directories.
+			filePath, err := filepath.Abs(k[1])
+			check(err)
+			if _, err := os.Stat(filePath); err != nil {
+				log.Fatalf("Unable to use file for --set-file argument '%v': %v", helmkvPair, err)
+			}
+			helmFileVars[k[0]] = filePath
+		}
 
 		if *context != "" && *environment != "" {
 			log.Fatalf("Must not provide both `--context` and `--environment`, because an environment maps to one or more contexts.")
 		}
+		if *context != "" && *contextSelector != "" {
+			log.Fatalf("Must not provide both `--context` and `--context-selector`, because a context selector selects one or more contexts.")
+		}
+		var selectorOpt ankh.LabelSelector
+		if *contextSelector != "" {
+			selector, err := ankh.ParseLabelSelector(*contextSelector)
+			if err != nil {
+				log.Fatalf("Invalid `--context-selector`: %v", err)
+			}
+			selectorOpt = selector
+		}
+		var chartSelectorOpt ankh.LabelSelector
+		if *chartSelector != "" {
+			selector, err := ankh.ParseLabelSelector(*chartSelector)
+			if err != nil {
+				log.Fatalf("Invalid `--selector`: %v", err)
+			}
+			chartSelectorOpt = selector
+		}
+
+		// Options set from the environment count as explicitly set, too.
+		if setFromEnv("ANKH_NAMESPACE") {
+			namespaceSet = true
+		}
+		if setFromEnv("ANKH_TAG") {
+			tagSet = true
+		}
 
 		var namespaceOpt *string
-		if namespaceSet {
-			namespaceOpt = namespace
+		chartNamespaces := map[string]string{}
+		for _, arg := range *namespace {
+			if k := strings.SplitN(arg, "=", 2); len(k) == 2 {
+				if k[0] == "" {
+					log.Fatalf("Malformed --namespace argument '%v'. Chart namespaces must be passed as 'chart=namespace'", arg)
+				}
+				chartNamespaces[k[0]] = k[1]
+				continue
+			}
+			if namespaceOpt != nil && *namespaceOpt != arg {
+				log.Fatalf("Must not provide more than one namespace for all charts, got both \"%v\" and \"%v\"", *namespaceOpt, arg)
+			}
+			ns := arg
+			namespaceOpt = &ns
+		}
+		if namespaceSet && len(*namespace) == 0 {
+			// An empty namespace denotes no explicit namespace.
+			ns := ""
+			namespaceOpt = &ns
 		}
 
 		var tagOpt *string
@@ -174,22 +490,47 @@ func main() {
 			Context:             *context,
 			Release:             *release,
 			Environment:         *environment,
+			ContextSelector:     selectorOpt,
+			ChartSelector:       chartSelectorOpt,
 			Namespace:           namespaceOpt,
+			ChartNamespaces:     chartNamespaces,
 			Tag:                 tagOpt,
+			TagFromGit:          *tagFromGit,
 			DataDir:             path.Join(*datadir, fmt.Sprintf("%v-%v", time.Now().Unix(), rand.Intn(100000))),
 			Logger:              log,
 			HelmSetValues:       helmVars,
+			HelmSetStringValues: helmStringVars,
+			HelmSetFiles:        helmFileVars,
 			HelmDir:             *helmdir,
+			PostRenderer:        *postRenderer,
+			Deployer:            *deployer,
+			ReadOnly:            *readOnly,
+			AnkhVersion:         AnkhBuildVersion,
 			IgnoreContextAndEnv: ctx.IgnoreContextAndEnv,
 			IgnoreConfigErrors:  ctx.IgnoreConfigErrors || *ignoreConfigErrors,
+			FixConfig:           *fixConfig,
+			Output:              *output,
 			SkipConfig:          ctx.SkipConfig,
 			NoPrompt:            *noPrompt,
+			TraceEndpoint:       *traceEndpoint,
+			TraceFile:           *traceFile,
 		}
 
+		ankh.IncludeCacheDir = path.Join(*datadir, "include-cache")
+
 		sigs := make(chan os.Signal, 1)
 		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 		go signalHandler(ctx, sigs)
 
+		if wd, err := os.Getwd(); err == nil {
+			project, err := ankh.FindAnkhProject(wd)
+			check(err)
+			ctx.Project = project
+			ctx.ApplyProjectDefaults()
+
+			startPromptJournal(ctx, path.Join(*datadir, promptJournalFileName), wd)
+		}
+
 		if ctx.Verbose && ctx.Quiet {
 			// Quiet overrides verbose, since it's more likely that the user
 			// requires certain invocations to be quiet, and may be composing
@@ -201,6 +542,8 @@ func main() {
 		// Default to info level logging
 		setLogLevel(ctx, logrus.InfoLevel)
 
+		check(util.ValidateOutputFormat(ctx.Output))
+
 		if ctx.SkipConfig {
 			log.Debugf("ctx.SkipConfig set, not parsing config before running commands")
 			return
@@ -209,70 +552,15 @@ func main() {
 		log.Debugf("Using KubeConfigPath %v (KUBECONFIG = '%v')", ctx.KubeConfigPath, os.Getenv("KUBECONFIG"))
 		log.Debugf("Using AnkhConfigPath %v (ANKHCONFIG = '%v')", ctx.AnkhConfigPath, os.Getenv("ANKHCONFIG"))
 
-		mergedAnkhConfig := ankh.AnkhConfig{}
-		parsedConfigs := make(map[string]bool)
-		configPaths := strings.Split(ctx.AnkhConfigPath, ",")
-		for len(configPaths) > 0 {
-			configPath := configPaths[0]
-			configPaths = configPaths[1:]
-
-			if parsedConfigs[configPath] {
-				log.Debugf("Already parsed %v", configPath)
-				continue
-			}
-
-			log.Debugf("Using config from path %v", configPath)
-
-			ankhConfig, err := config.GetAnkhConfigWithDefaults(ctx, configPath)
-			if err != nil {
-				// TODO: this is a mess
-				if !ctx.IgnoreContextAndEnv && !ctx.IgnoreConfigErrors {
-					// The config validation errors are not recoverable.
-					log.Fatalf("%s: Rerun with `ankh --ignore-config-errors ...` to ignore this error and use the merged configuration anyway.", err)
-				} else {
-					log.Warnf("%v", err)
-				}
-			}
-
-			// Warn on context and environment conflict, since this case is almost certainly unintentional.
-			for name, _ := range ankhConfig.Contexts {
-				if context, ok := mergedAnkhConfig.Contexts[name]; ok {
-					complaint := fmt.Sprintf("Context `%v` already defined from config source `%v`, would have been overriden by config source `%v`.",
-						name, context.Source, configPath)
-					if !ctx.IgnoreConfigErrors {
-						log.Fatalf(complaint + " Rerun with `ankh --ignore-config-errors ...` to ignore this error and use the merged configuration anyway.")
-					} else {
-						log.Warnf(complaint)
-					}
-				}
-			}
-			for name, _ := range ankhConfig.Environments {
-				if environment, ok := mergedAnkhConfig.Environments[name]; ok {
-					complaint := fmt.Sprintf("Environment `%v` already defined from config source `%v`, would have been overriden by config source `%v`.",
-						name, environment.Source, configPath)
-					if !ctx.IgnoreConfigErrors {
-						log.Fatalf(complaint + " Rerun with `ankh --ignore-config-errors ...` to ignore this error and use the merged configuration anyway.")
-					} else {
-						log.Warnf(complaint)
-					}
-				}
-			}
-
-			// Merge it in. We'll need to dedup arrays later.
-			mergo.Merge(&mergedAnkhConfig, ankhConfig)
-
-			// Follow includes, mark this one as visited.
-			configPaths = append(configPaths, ankhConfig.Include...)
-			parsedConfigs[configPath] = true
+		mergedAnkhConfig, err := ankhlib.LoadConfig(ctx, ctx.AnkhConfigPath)
+		if err != nil {
+			log.Fatalf("%s\nRerun with `ankh --ignore-config-errors ...` to ignore this error and use the merged configuration anyway.", err)
 		}
 
-		// Don't accidentally wind up in an include cycle.
-		mergedAnkhConfig.Include = util.ArrayDedup(mergedAnkhConfig.Include)
-
 		if ctx.Context != "" {
 			mergedAnkhConfig.CurrentContextName = ctx.Context
 		}
-		if ctx.Environment == "" && !ctx.IgnoreContextAndEnv {
+		if ctx.Environment == "" && ctx.ContextSelector == nil && !ctx.IgnoreContextAndEnv {
 			if ctx.Context == "" && !ctx.NoPrompt {
 				// No environment/context and we can prompt, so do that now.
 				if len(mergedAnkhConfig.Environments) > 0 {
@@ -305,23 +593,184 @@ func main() {
 	}
 
 	app.Command("apply", "Apply one or more charts to Kubernetes", func(cmd *cli.Cmd) {
-		cmd.Spec = "[--ankhfile] [--dry-run] [--chart] [--chart-path] [--slack] [--slack-message] [--jira-ticket] [--filter...] [--image-tag-filter] [--chart-version-filter]"
-
-		ankhFilePath := cmd.StringOpt("ankhfile", "", "Path to an Ankh file for managing multiple charts")
-		dryRun := cmd.BoolOpt("dry-run", false, "Perform a dry-run and don't actually apply anything")
-		chart := cmd.StringOpt("chart", "", "The chart to use")
-		chartPath := cmd.StringOpt("chart-path", "", "Use a local chart directory instead of a remote, versioned chart")
-		slackChannel := cmd.StringOpt("s slack", "", "Send slack message to specified slack channel about application update")
-		slackMessageOverride := cmd.StringOpt("m slack-message", "", "Override the default slack message being sent")
-		createJiraTicket := cmd.BoolOpt("j jira-ticket", false, "Create a JIRA ticket to track update")
-		filter := cmd.StringsOpt("filter", []string{}, "Kubernetes object kinds to include for the action. The entries in this list are case insensitive. Any object whose `kind:` does not match this filter will be excluded from the action")
-		imageTagFilter := cmd.StringOpt("image-tag-filter", "", "Filters out any image tags that include the specified substring. Matching tags will not appear in the prompt.")
-		chartVersionFilter := cmd.StringOpt("chart-version-filter", "", "Filters out any chart versions that include the specified substring. Matching versions will not appear in the prompt.")
+		cmd.Spec = "[--ankhfile] [--dry-run] [--admission-preview] [--skip-crds] [--create-namespace] [--wait] [--timeout] [--chart...] [--chart-path] [--slack] [--slack-message] [--jira-ticket] [--filter...] [--filter-name] [--filter-label...] [--image-tag-filter] [--chart-version-filter] [--force-replicas] [--force-max-unavailable] [--server-side] [--field-manager] [--force-conflicts] [--retries] [--retry-backoff] [--resume] [--resolve-digests]"
+
+		ankhFilePath := cmd.String(cli.StringOpt{
+			Name:   "ankhfile",
+			Value:  "",
+			Desc:   "Path to an Ankh file for managing multiple charts, or \"-\" to read it from stdin",
+			EnvVar: "ANKH_ANKHFILE",
+		})
+		dryRun := cmd.Bool(cli.BoolOpt{
+			Name:   "dry-run",
+			Value:  false,
+			Desc:   "Perform a dry-run and don't actually apply anything",
+			EnvVar: "ANKH_DRY_RUN",
+		})
+		admissionPreview := cmd.Bool(cli.BoolOpt{
+			Name:   "admission-preview",
+			Value:  false,
+			Desc:   "Submit each object with a server-side dry-run, and report every object that admission webhooks would reject. Nothing is applied.",
+			EnvVar: "ANKH_ADMISSION_PREVIEW",
+		})
+		skipCrds := cmd.Bool(cli.BoolOpt{
+			Name:   "skip-crds",
+			Value:  false,
+			Desc:   "Do not install the CRDs in each chart's crds/ directory before applying the chart",
+			EnvVar: "ANKH_SKIP_CRDS",
+		})
+		createNamespace := cmd.Bool(cli.BoolOpt{
+			Name:   "create-namespace",
+			Value:  false,
+			Desc:   "Create the namespace before applying, if it does not exist, with the labels and annotations of the context's namespaceMetadata",
+			EnvVar: "ANKH_CREATE_NAMESPACE",
+		})
+		wait := cmd.Bool(cli.BoolOpt{
+			Name:   "wait",
+			Value:  false,
+			Desc:   "After applying, wait for every Deployment, StatefulSet and DaemonSet to finish rolling out, and exit non-zero if any rollout fails",
+			EnvVar: "ANKH_WAIT",
+		})
+		timeout := cmd.String(cli.StringOpt{
+			Name:   "timeout",
+			Value:  "",
+			Desc:   "How long to wait for each rollout with --wait, eg: 10m. Defaults to 5m",
+			EnvVar: "ANKH_TIMEOUT",
+		})
+		chart := cmd.Strings(cli.StringsOpt{
+			Name:   "chart",
+			Value:  []string{},
+			Desc:   "The chart to use. May be repeated, or given as a comma separated list, to operate over a subset of the charts in an Ankh file",
+			EnvVar: "ANKH_CHART",
+		})
+		chartPath := cmd.String(cli.StringOpt{
+			Name:   "chart-path",
+			Value:  "",
+			Desc:   "Use a local chart directory instead of a remote, versioned chart",
+			EnvVar: "ANKH_CHART_PATH",
+		})
+		slackChannel := cmd.String(cli.StringOpt{
+			Name:   "s slack",
+			Value:  "",
+			Desc:   "Send slack message to specified slack channel about application update",
+			EnvVar: "ANKH_SLACK",
+		})
+		slackMessageOverride := cmd.String(cli.StringOpt{
+			Name:   "m slack-message",
+			Value:  "",
+			Desc:   "Override the default slack message being sent",
+			EnvVar: "ANKH_SLACK_MESSAGE",
+		})
+		createJiraTicket := cmd.Bool(cli.BoolOpt{
+			Name:   "j jira-ticket",
+			Value:  false,
+			Desc:   "Create a JIRA ticket to track update",
+			EnvVar: "ANKH_JIRA_TICKET",
+		})
+		filter := cmd.Strings(cli.StringsOpt{
+			Name:   "filter",
+			Value:  []string{},
+			Desc:   "Kubernetes object kinds to include for the action. The entries in this list are case insensitive. Any object whose `kind:` does not match this filter will be excluded from the action",
+			EnvVar: "ANKH_FILTER",
+		})
+		filterName := cmd.String(cli.StringOpt{
+			Name:   "filter-name",
+			Value:  "",
+			Desc:   "A regular expression on object names to include for the action, eg: `^api$`. Any object whose name does not match will be excluded from the action",
+			EnvVar: "ANKH_FILTER_NAME",
+		})
+		filterLabel := cmd.Strings(cli.StringsOpt{
+			Name:   "filter-label",
+			Value:  []string{},
+			Desc:   "Object labels to include for the action, as `key=value` or `key!=value`. Any object whose labels do not match every entry will be excluded from the action",
+			EnvVar: "ANKH_FILTER_LABEL",
+		})
+		imageTagFilter := cmd.String(cli.StringOpt{
+			Name:   "image-tag-filter",
+			Value:  "",
+			Desc:   "Filters out any image tags that include the specified substring. Matching tags will not appear in the prompt.",
+			EnvVar: "ANKH_IMAGE_TAG_FILTER",
+		})
+		chartVersionFilter := cmd.String(cli.StringOpt{
+			Name:   "chart-version-filter",
+			Value:  "",
+			Desc:   "Filters out any chart versions that include the specified substring. Matching versions will not appear in the prompt.",
+			EnvVar: "ANKH_CHART_VERSION_FILTER",
+		})
+
+		forceReplicas := cmd.String(cli.StringOpt{
+			Name:   "force-replicas",
+			Value:  "",
+			Desc:   "Set the replicas of every Deployment to this count, eg: 0 for an emergency scale-down, without changing the chart. Overridden Deployments are annotated with `ankh/overridden`",
+			EnvVar: "ANKH_FORCE_REPLICAS",
+		})
+		forceMaxUnavailable := cmd.String(cli.StringOpt{
+			Name:   "force-max-unavailable",
+			Value:  "",
+			Desc:   "Set the rolling update maxUnavailable of every Deployment, as a number of pods or a percentage, eg: 1 or 10%, without changing the chart",
+			EnvVar: "ANKH_FORCE_MAX_UNAVAILABLE",
+		})
+
+		serverSide := cmd.Bool(cli.BoolOpt{
+			Name:   "server-side",
+			Value:  false,
+			Desc:   "Apply with `kubectl apply --server-side`, for clusters that enforce server-side apply and managed fields. Overrides `kubectl.serverSide` in ankh config.",
+			EnvVar: "ANKH_SERVER_SIDE",
+		})
+		fieldManager := cmd.String(cli.StringOpt{
+			Name:   "field-manager",
+			Value:  "",
+			Desc:   "The field manager to apply as. Defaults to `kubectl.fieldManager` in ankh config, or `ankh` with --server-side",
+			EnvVar: "ANKH_FIELD_MANAGER",
+		})
+		forceConflicts := cmd.Bool(cli.BoolOpt{
+			Name:   "force-conflicts",
+			Value:  false,
+			Desc:   "With --server-side, take ownership of fields that other field managers own, instead of failing",
+			EnvVar: "ANKH_FORCE_CONFLICTS",
+		})
+		retriesSet := false
+		retries := cmd.Int(cli.IntOpt{
+			Name:      "retries",
+			Value:     0,
+			Desc:      "How many times to retry kubectl when it fails in a way that is likely transient, eg: an etcd leader change or a timeout. Overrides `kubectl.retry.maxAttempts` in ankh config",
+			EnvVar:    "ANKH_RETRIES",
+			SetByUser: &retriesSet,
+		})
+		retryBackoff := cmd.String(cli.StringOpt{
+			Name:   "retry-backoff",
+			Value:  "",
+			Desc:   "How long to wait before the first retry, eg: 2s, doubling after every retry. Overrides `kubectl.retry.initialBackoff` in ankh config",
+			EnvVar: "ANKH_RETRY_BACKOFF",
+		})
+		resume := cmd.Bool(cli.BoolOpt{
+			Name:   "resume",
+			Value:  false,
+			Desc:   "Continue a failed apply over several contexts, eg: of an environment, from the first context that it did not succeed on. Run the same command as the one that failed, in the same directory",
+			EnvVar: "ANKH_RESUME",
+		})
+
+		resolveDigests := cmd.Bool(cli.BoolOpt{
+			Name:   "resolve-digests",
+			Value:  false,
+			Desc:   "Pin each chart's tag to the digest it points to in the registry, passing `$tagKey=$tag@sha256:...` to helm, so that pushing the tag again can't change what is applied. Overrides `docker.resolveDigests` in ankh config",
+			EnvVar: "ANKH_RESOLVE_DIGESTS",
+		})
 
 		cmd.Action = func() {
 			ctx.AnkhFilePath = *ankhFilePath
-			ctx.DryRun = *dryRun
-			ctx.Chart = *chart
+			ctx.ServerSide = *serverSide
+			ctx.FieldManager = *fieldManager
+			ctx.ForceConflicts = *forceConflicts
+			ctx.ForceReplicas = *forceReplicas
+			ctx.ForceMaxUnavailable = *forceMaxUnavailable
+			ctx.DryRun = *dryRun || *admissionPreview
+			ctx.AdmissionPreview = *admissionPreview
+			ctx.SkipCrds = *skipCrds
+			ctx.CreateNamespace = *createNamespace
+			ctx.Wait = *wait
+			ctx.WaitTimeout = *timeout
+			setChartArgs(ctx, *chart)
 			if *chartPath != "" {
 				ctx.Chart = *chartPath
 				ctx.LocalChart = true
@@ -335,24 +784,134 @@ func main() {
 				filters = append(filters, string(filter))
 			}
 			ctx.Filters = filters
+			setObjectFilters(ctx, *filterName, *filterLabel)
 			ctx.ImageTagFilter = *imageTagFilter
 			ctx.ChartVersionFilter = *chartVersionFilter
+			ctx.Resume = *resume
+			if setFromEnv("ANKH_RETRIES") || retriesSet {
+				if *retries < 0 {
+					ctx.Logger.Fatalf("Invalid --retries %v, expected zero or more", *retries)
+				}
+				ctx.AnkhConfig.Kubectl.Retry.MaxAttempts = *retries + 1
+			}
+			if *retryBackoff != "" {
+				if _, err := time.ParseDuration(*retryBackoff); err != nil {
+					ctx.Logger.Fatalf("Invalid --retry-backoff \"%v\", expected a duration like 2s: %v", *retryBackoff, err)
+				}
+				ctx.AnkhConfig.Kubectl.Retry.InitialBackoff = *retryBackoff
+			}
 
+			ctx.ResolveDigests = *resolveDigests
 			execute(ctx)
 			os.Exit(0)
 		}
 	})
 
+	app.Command("batch", "Apply each chart listed on stdin, one per line, eg: for scripted mass-updates", func(cmd *cli.Cmd) {
+		cmd.Spec = "[--dry-run] [--skip-crds] [--wait] [--timeout] [--filter...] [--keep-going]"
+
+		dryRun := cmd.Bool(cli.BoolOpt{
+			Name:   "dry-run",
+			Value:  false,
+			Desc:   "Perform a dry-run of each apply and don't actually apply anything",
+			EnvVar: "ANKH_DRY_RUN",
+		})
+		skipCrds := cmd.Bool(cli.BoolOpt{
+			Name:   "skip-crds",
+			Value:  false,
+			Desc:   "Do not install the CRDs in each chart's crds/ directory before applying the chart",
+			EnvVar: "ANKH_SKIP_CRDS",
+		})
+		wait := cmd.Bool(cli.BoolOpt{
+			Name:   "wait",
+			Value:  false,
+			Desc:   "After each apply, wait for every Deployment, StatefulSet and DaemonSet to finish rolling out, and count the entry as failed if any rollout fails",
+			EnvVar: "ANKH_WAIT",
+		})
+		timeout := cmd.String(cli.StringOpt{
+			Name:   "timeout",
+			Value:  "",
+			Desc:   "How long to wait for each rollout with --wait, eg: 10m. Defaults to 5m",
+			EnvVar: "ANKH_TIMEOUT",
+		})
+		filter := cmd.Strings(cli.StringsOpt{
+			Name:   "filter",
+			Value:  []string{},
+			Desc:   "Kubernetes object kinds to include for each apply. The entries in this list are case insensitive.",
+			EnvVar: "ANKH_FILTER",
+		})
+		keepGoing := cmd.Bool(cli.BoolOpt{
+			Name:   "keep-going",
+			Value:  false,
+			Desc:   "Keep applying the remaining entries after one fails, instead of skipping them",
+			EnvVar: "ANKH_KEEP_GOING",
+		})
+
+		cmd.Action = func() {
+			if !*dryRun {
+				check(ctx.CheckWritable("apply a batch"))
+			}
+			entries, err := parseBatch(os.Stdin)
+			check(err)
+			if len(entries) == 0 {
+				log.Fatalf("No charts to apply. Expected `chart[@version] [tag] [namespace]` lines on stdin")
+			}
+
+			failures := runBatch(ctx, entries, batchOpts{
+				DryRun:    *dryRun,
+				SkipCrds:  *skipCrds,
+				Wait:      *wait,
+				Timeout:   *timeout,
+				Filters:   *filter,
+				KeepGoing: *keepGoing,
+			}, os.Stdout)
+			if failures > 0 {
+				log.Errorf("%v of %v entries were not applied", failures, len(entries))
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+	})
+
 	app.Command("explain", "Explain how one or more charts would be applied to Kubernetes", func(cmd *cli.Cmd) {
-		cmd.Spec = "[--ankhfile] [--chart] [--chart-path]"
+		cmd.Spec = "[--ankhfile] [--skip-crds] [--create-namespace] [--chart...] [--chart-path]"
 
-		ankhFilePath := cmd.StringOpt("ankhfile", "", "Path to an Ankh file for managing multiple charts")
-		chart := cmd.StringOpt("chart", "", "The chart to use")
-		chartPath := cmd.StringOpt("chart-path", "", "Use a local chart directory instead of a remote, versioned chart")
+		ankhFilePath := cmd.String(cli.StringOpt{
+			Name:   "ankhfile",
+			Value:  "",
+			Desc:   "Path to an Ankh file for managing multiple charts, or \"-\" to read it from stdin",
+			EnvVar: "ANKH_ANKHFILE",
+		})
+		skipCrds := cmd.Bool(cli.BoolOpt{
+			Name:   "skip-crds",
+			Value:  false,
+			Desc:   "Do not install the CRDs in each chart's crds/ directory before applying the chart",
+			EnvVar: "ANKH_SKIP_CRDS",
+		})
+		createNamespace := cmd.Bool(cli.BoolOpt{
+			Name:   "create-namespace",
+			Value:  false,
+			Desc:   "Create the namespace before applying, if it does not exist, with the labels and annotations of the context's namespaceMetadata",
+			EnvVar: "ANKH_CREATE_NAMESPACE",
+		})
+		chart := cmd.Strings(cli.StringsOpt{
+			Name:   "chart",
+			Value:  []string{},
+			Desc:   "The chart to use. May be repeated, or given as a comma separated list, to operate over a subset of the charts in an Ankh file",
+			EnvVar: "ANKH_CHART",
+		})
+		chartPath := cmd.String(cli.StringOpt{
+			Name:   "chart-path",
+			Value:  "",
+			Desc:   "Use a local chart directory instead of a remote, versioned chart",
+			EnvVar: "ANKH_CHART_PATH",
+		})
 
 		cmd.Action = func() {
 			ctx.AnkhFilePath = *ankhFilePath
-			ctx.Chart = *chart
+			ctx.SkipCrds = *skipCrds
+			ctx.CreateNamespace = *createNamespace
+			setChartArgs(ctx, *chart)
 			if *chartPath != "" {
 				ctx.Chart = *chartPath
 				ctx.LocalChart = true
@@ -364,18 +923,202 @@ func main() {
 		}
 	})
 
+	app.Command("plan", "Show the stages that a command would run, and what they would run on, without running anything", func(cmd *cli.Cmd) {
+		cmd.Spec = "[--mode] [--ankhfile] [--dry-run] [--skip-crds] [--create-namespace] [--wait] [--chart...] [--chart-path] [--filter...] [--filter-name] [--filter-label...]"
+
+		mode := cmd.String(cli.StringOpt{
+			Name:   "mode",
+			Value:  string(ankh.Apply),
+			Desc:   "The command to plan. One of apply, deploy, diff, rollback, delete, template, lint, get or pods",
+			EnvVar: "ANKH_PLAN_MODE",
+		})
+		ankhFilePath := cmd.String(cli.StringOpt{
+			Name:   "ankhfile",
+			Value:  "",
+			Desc:   "Path to an Ankh file for managing multiple charts, or \"-\" to read it from stdin",
+			EnvVar: "ANKH_ANKHFILE",
+		})
+		dryRun := cmd.Bool(cli.BoolOpt{
+			Name:   "dry-run",
+			Value:  false,
+			Desc:   "Plan a dry run of the command",
+			EnvVar: "ANKH_DRY_RUN",
+		})
+		skipCrds := cmd.Bool(cli.BoolOpt{
+			Name:   "skip-crds",
+			Value:  false,
+			Desc:   "Plan without installing the CRDs in each chart's crds/ directory",
+			EnvVar: "ANKH_SKIP_CRDS",
+		})
+		createNamespace := cmd.Bool(cli.BoolOpt{
+			Name:   "create-namespace",
+			Value:  false,
+			Desc:   "Create the namespace before applying, if it does not exist, with the labels and annotations of the context's namespaceMetadata",
+			EnvVar: "ANKH_CREATE_NAMESPACE",
+		})
+		wait := cmd.Bool(cli.BoolOpt{
+			Name:   "wait",
+			Value:  false,
+			Desc:   "Plan an apply that waits for every rollout to finish",
+			EnvVar: "ANKH_WAIT",
+		})
+		chart := cmd.Strings(cli.StringsOpt{
+			Name:   "chart",
+			Value:  []string{},
+			Desc:   "The chart to use. May be repeated, or given as a comma separated list, to operate over a subset of the charts in an Ankh file",
+			EnvVar: "ANKH_CHART",
+		})
+		chartPath := cmd.String(cli.StringOpt{
+			Name:   "chart-path",
+			Value:  "",
+			Desc:   "Use a local chart directory instead of a remote, versioned chart",
+			EnvVar: "ANKH_CHART_PATH",
+		})
+		filter := cmd.Strings(cli.StringsOpt{
+			Name:   "filter",
+			Value:  []string{},
+			Desc:   "Kubernetes object kinds to include for the action. The entries in this list are case insensitive. Any object whose `kind:` does not match this filter will be excluded from the action",
+			EnvVar: "ANKH_FILTER",
+		})
+		filterName := cmd.String(cli.StringOpt{
+			Name:   "filter-name",
+			Value:  "",
+			Desc:   "A regular expression on object names to include for the action, eg: `^api$`. Any object whose name does not match will be excluded from the action",
+			EnvVar: "ANKH_FILTER_NAME",
+		})
+		filterLabel := cmd.Strings(cli.StringsOpt{
+			Name:   "filter-label",
+			Value:  []string{},
+			Desc:   "Object labels to include for the action, as `key=value` or `key!=value`. Any object whose labels do not match every entry will be excluded from the action",
+			EnvVar: "ANKH_FILTER_LABEL",
+		})
+
+		cmd.Action = func() {
+			ctx.Mode = ankh.Mode(*mode)
+			check(checkPlannableMode(ctx.Mode))
+			ctx.PlanOnly = true
+			ctx.AnkhFilePath = *ankhFilePath
+			ctx.DryRun = *dryRun
+			ctx.SkipCrds = *skipCrds
+			ctx.CreateNamespace = *createNamespace
+			ctx.Wait = *wait
+			setChartArgs(ctx, *chart)
+			if *chartPath != "" {
+				ctx.Chart = *chartPath
+				ctx.LocalChart = true
+			}
+			ctx.Filters = append([]string{}, *filter...)
+			setObjectFilters(ctx, *filterName, *filterLabel)
+
+			execute(ctx)
+			os.Exit(0)
+		}
+	})
+
 	app.Command("deploy", "(experimental) Run a multi-stage deployment of a chart to Kubernetes", func(cmd *cli.Cmd) {
-		cmd.Spec = "[--chart] [--chart-path] [--slack] [--slack-message] [--jira-ticket] [--filter...]"
+		cmd.Spec = "[--skip-crds] [--create-namespace] [--chart...] [--chart-path] [--slack] [--slack-message] [--jira-ticket] [--filter...] [--filter-name] [--filter-label...] [--tail] [--server-side] [--field-manager] [--force-conflicts] [--resolve-digests]"
+
+		skipCrds := cmd.Bool(cli.BoolOpt{
+			Name:   "skip-crds",
+			Value:  false,
+			Desc:   "Do not install the CRDs in each chart's crds/ directory before applying the chart",
+			EnvVar: "ANKH_SKIP_CRDS",
+		})
+		createNamespace := cmd.Bool(cli.BoolOpt{
+			Name:   "create-namespace",
+			Value:  false,
+			Desc:   "Create the namespace before applying, if it does not exist, with the labels and annotations of the context's namespaceMetadata",
+			EnvVar: "ANKH_CREATE_NAMESPACE",
+		})
+		chart := cmd.Strings(cli.StringsOpt{
+			Name:   "chart",
+			Value:  []string{},
+			Desc:   "The chart to use. May be repeated, or given as a comma separated list, to operate over a subset of the charts in an Ankh file",
+			EnvVar: "ANKH_CHART",
+		})
+		chartPath := cmd.String(cli.StringOpt{
+			Name:   "chart-path",
+			Value:  "",
+			Desc:   "Use a local chart directory instead of a remote, versioned chart",
+			EnvVar: "ANKH_CHART_PATH",
+		})
+		slackChannel := cmd.String(cli.StringOpt{
+			Name:   "s slack",
+			Value:  "",
+			Desc:   "Send slack message to specified slack channel about application update",
+			EnvVar: "ANKH_SLACK",
+		})
+		slackMessageOverride := cmd.String(cli.StringOpt{
+			Name:   "m slack-message",
+			Value:  "",
+			Desc:   "Override the default slack message being sent",
+			EnvVar: "ANKH_SLACK_MESSAGE",
+		})
+		createJiraTicket := cmd.Bool(cli.BoolOpt{
+			Name:   "j jira-ticket",
+			Value:  false,
+			Desc:   "Create a JIRA ticket to track update",
+			EnvVar: "ANKH_JIRA_TICKET",
+		})
+		filter := cmd.Strings(cli.StringsOpt{
+			Name:   "filter",
+			Value:  []string{},
+			Desc:   "Kubernetes object kinds to include for the action. The entries in this list are case insensitive. Any object whose `kind:` does not match this filter will be excluded from the action",
+			EnvVar: "ANKH_FILTER",
+		})
+		filterName := cmd.String(cli.StringOpt{
+			Name:   "filter-name",
+			Value:  "",
+			Desc:   "A regular expression on object names to include for the action, eg: `^api$`. Any object whose name does not match will be excluded from the action",
+			EnvVar: "ANKH_FILTER_NAME",
+		})
+		filterLabel := cmd.Strings(cli.StringsOpt{
+			Name:   "filter-label",
+			Value:  []string{},
+			Desc:   "Object labels to include for the action, as `key=value` or `key!=value`. Any object whose labels do not match every entry will be excluded from the action",
+			EnvVar: "ANKH_FILTER_LABEL",
+		})
+		numTailLines := cmd.Int(cli.IntOpt{
+			Name:   "t tail",
+			Value:  20,
+			Desc:   "The number of log lines to show for each failing container before the final prompt. Set to 0 to only show why containers are failing.",
+			EnvVar: "ANKH_TAIL",
+		})
+
+		serverSide := cmd.Bool(cli.BoolOpt{
+			Name:   "server-side",
+			Value:  false,
+			Desc:   "Apply with `kubectl apply --server-side`, for clusters that enforce server-side apply and managed fields. Overrides `kubectl.serverSide` in ankh config.",
+			EnvVar: "ANKH_SERVER_SIDE",
+		})
+		fieldManager := cmd.String(cli.StringOpt{
+			Name:   "field-manager",
+			Value:  "",
+			Desc:   "The field manager to apply as. Defaults to `kubectl.fieldManager` in ankh config, or `ankh` with --server-side",
+			EnvVar: "ANKH_FIELD_MANAGER",
+		})
+		forceConflicts := cmd.Bool(cli.BoolOpt{
+			Name:   "force-conflicts",
+			Value:  false,
+			Desc:   "With --server-side, take ownership of fields that other field managers own, instead of failing",
+			EnvVar: "ANKH_FORCE_CONFLICTS",
+		})
 
-		chart := cmd.StringOpt("chart", "", "The chart to use")
-		chartPath := cmd.StringOpt("chart-path", "", "Use a local chart directory instead of a remote, versioned chart")
-		slackChannel := cmd.StringOpt("s slack", "", "Send slack message to specified slack channel about application update")
-		slackMessageOverride := cmd.StringOpt("m slack-message", "", "Override the default slack message being sent")
-		createJiraTicket := cmd.BoolOpt("j jira-ticket", false, "Create a JIRA ticket to track update")
-		filter := cmd.StringsOpt("filter", []string{}, "Kubernetes object kinds to include for the action. The entries in this list are case insensitive. Any object whose `kind:` does not match this filter will be excluded from the action")
+		resolveDigests := cmd.Bool(cli.BoolOpt{
+			Name:   "resolve-digests",
+			Value:  false,
+			Desc:   "Pin each chart's tag to the digest it points to in the registry, passing `$tagKey=$tag@sha256:...` to helm, so that pushing the tag again can't change what is applied. Overrides `docker.resolveDigests` in ankh config",
+			EnvVar: "ANKH_RESOLVE_DIGESTS",
+		})
 
 		cmd.Action = func() {
-			ctx.Chart = *chart
+			setChartArgs(ctx, *chart)
+			ctx.SkipCrds = *skipCrds
+			ctx.CreateNamespace = *createNamespace
+			ctx.ServerSide = *serverSide
+			ctx.FieldManager = *fieldManager
+			ctx.ForceConflicts = *forceConflicts
+			ctx.FailedPodLogLines = *numTailLines
 			if *chartPath != "" {
 				ctx.Chart = *chartPath
 				ctx.LocalChart = true
@@ -389,33 +1132,80 @@ func main() {
 				filters = append(filters, string(filter))
 			}
 			ctx.Filters = filters
+			setObjectFilters(ctx, *filterName, *filterLabel)
 
 			ctx.Logger.Warnf("\"deploy\" is an experimental command.")
+			ctx.ResolveDigests = *resolveDigests
 			execute(ctx)
 			os.Exit(0)
 		}
 	})
 
 	app.Command("rollback", "Rollback deployments associated with one or more charts from Kubernetes", func(cmd *cli.Cmd) {
-		cmd.Spec = "[--ankhfile] [--dry-run] [--chart] [--chart-path] [--slack] [--slack-message] [--jira-ticket] "
+		cmd.Spec = "[--ankhfile] [--dry-run] [--chart...] [--chart-path] [--to-revision] [--slack] [--slack-message] [--jira-ticket] "
 
-		ankhFilePath := cmd.StringOpt("ankhfile", "", "Path to an Ankh file for managing multiple charts")
-		dryRun := cmd.BoolOpt("dry-run", false, "Perform a dry-run and don't actually rollback anything")
-		chart := cmd.StringOpt("chart", "", "The chart to use")
-		chartPath := cmd.StringOpt("chart-path", "", "Use a local chart directory instead of a remote, versioned chart")
-		slackChannel := cmd.StringOpt("s slack", "", "Send slack message to specified slack channel about application update")
-		slackMessageOverride := cmd.StringOpt("m slack-message", "", "Override the default slack message being sent")
-		createJiraTicket := cmd.BoolOpt("j jira-ticket", false, "Create a JIRA ticket to track update")
-
-		cmd.Action = func() {
-			ctx.AnkhFilePath = *ankhFilePath
-			ctx.DryRun = *dryRun
-			ctx.Chart = *chart
-			if *chartPath != "" {
-				ctx.Chart = *chartPath
-				ctx.LocalChart = true
-			}
+		ankhFilePath := cmd.String(cli.StringOpt{
+			Name:   "ankhfile",
+			Value:  "",
+			Desc:   "Path to an Ankh file for managing multiple charts, or \"-\" to read it from stdin",
+			EnvVar: "ANKH_ANKHFILE",
+		})
+		dryRun := cmd.Bool(cli.BoolOpt{
+			Name:   "dry-run",
+			Value:  false,
+			Desc:   "Perform a dry-run and don't actually rollback anything",
+			EnvVar: "ANKH_DRY_RUN",
+		})
+		chart := cmd.Strings(cli.StringsOpt{
+			Name:   "chart",
+			Value:  []string{},
+			Desc:   "The chart to use. May be repeated, or given as a comma separated list, to operate over a subset of the charts in an Ankh file",
+			EnvVar: "ANKH_CHART",
+		})
+		chartPath := cmd.String(cli.StringOpt{
+			Name:   "chart-path",
+			Value:  "",
+			Desc:   "Use a local chart directory instead of a remote, versioned chart",
+			EnvVar: "ANKH_CHART_PATH",
+		})
+		toRevision := cmd.Int(cli.IntOpt{
+			Name:   "to-revision",
+			Value:  0,
+			Desc:   "The revision to roll back to, as shown by `ankh history`. Defaults to the previous revision",
+			EnvVar: "ANKH_TO_REVISION",
+		})
+		slackChannel := cmd.String(cli.StringOpt{
+			Name:   "s slack",
+			Value:  "",
+			Desc:   "Send slack message to specified slack channel about application update",
+			EnvVar: "ANKH_SLACK",
+		})
+		slackMessageOverride := cmd.String(cli.StringOpt{
+			Name:   "m slack-message",
+			Value:  "",
+			Desc:   "Override the default slack message being sent",
+			EnvVar: "ANKH_SLACK_MESSAGE",
+		})
+		createJiraTicket := cmd.Bool(cli.BoolOpt{
+			Name:   "j jira-ticket",
+			Value:  false,
+			Desc:   "Create a JIRA ticket to track update",
+			EnvVar: "ANKH_JIRA_TICKET",
+		})
+
+		cmd.Action = func() {
+			ctx.AnkhFilePath = *ankhFilePath
+			ctx.DryRun = *dryRun
+			setChartArgs(ctx, *chart)
+			if *chartPath != "" {
+				ctx.Chart = *chartPath
+				ctx.LocalChart = true
+			}
 			ctx.Mode = ankh.Rollback
+			if *toRevision < 0 {
+				ctx.Logger.Fatalf("Invalid revision %v. Use a revision shown by `ankh history`", *toRevision)
+			}
+			ctx.RollbackRevision = *toRevision
 			ctx.SlackChannel = *slackChannel
 			ctx.SlackMessageOverride = *slackMessageOverride
 			ctx.CreateJiraTicket = *createJiraTicket
@@ -432,12 +1222,197 @@ func main() {
 				"do the right thing in this case. You MUST `ankh ... apply` using the co-dependent chart and tag value in order to converge back to a correct state.\n" +
 				"\n" +
 				"If you already know the chart version and associated tag values (eg: `--set ...`) that you want to converge to, use `ankh --set $... apply --chart $chartName@$prevVersion` instead.\n")
-			selection, err := util.PromptForSelection([]string{"Abort", "OK"},
-				"Are you certain that you want to run `kubectl rollout undo` to rollback to a previous ReplicaSet spec? Select OK to proceed.", false)
-			check(err)
 
-			if selection != "OK" {
-				ctx.Logger.Fatalf("Aborting")
+			execute(ctx)
+			os.Exit(0)
+		}
+	})
+
+	app.Command("delete", "Delete the objects of one or more charts from Kubernetes", func(cmd *cli.Cmd) {
+		cmd.Spec = "[--ankhfile] [--dry-run] [--chart...] [--chart-path] [--filter...] [--filter-name] [--filter-label...] [--wait] [--timeout]"
+
+		ankhFilePath := cmd.String(cli.StringOpt{
+			Name:   "ankhfile",
+			Value:  "",
+			Desc:   "Path to an Ankh file for managing multiple charts, or \"-\" to read it from stdin",
+			EnvVar: "ANKH_ANKHFILE",
+		})
+		dryRun := cmd.Bool(cli.BoolOpt{
+			Name:   "dry-run",
+			Value:  false,
+			Desc:   "Perform a dry-run and don't actually delete anything",
+			EnvVar: "ANKH_DRY_RUN",
+		})
+		chart := cmd.Strings(cli.StringsOpt{
+			Name:   "chart",
+			Value:  []string{},
+			Desc:   "The chart to use. May be repeated, or given as a comma separated list, to operate over a subset of the charts in an Ankh file",
+			EnvVar: "ANKH_CHART",
+		})
+		chartPath := cmd.String(cli.StringOpt{
+			Name:   "chart-path",
+			Value:  "",
+			Desc:   "Use a local chart directory instead of a remote, versioned chart",
+			EnvVar: "ANKH_CHART_PATH",
+		})
+		filter := cmd.Strings(cli.StringsOpt{
+			Name:   "filter",
+			Value:  []string{},
+			Desc:   "Kubernetes object kinds to delete. The entries in this list are case insensitive. Any object whose `kind:` does not match this filter is left alone",
+			EnvVar: "ANKH_FILTER",
+		})
+		filterName := cmd.String(cli.StringOpt{
+			Name:   "filter-name",
+			Value:  "",
+			Desc:   "A regular expression on object names to include for the action, eg: `^api$`. Any object whose name does not match will be excluded from the action",
+			EnvVar: "ANKH_FILTER_NAME",
+		})
+		filterLabel := cmd.Strings(cli.StringsOpt{
+			Name:   "filter-label",
+			Value:  []string{},
+			Desc:   "Object labels to include for the action, as `key=value` or `key!=value`. Any object whose labels do not match every entry will be excluded from the action",
+			EnvVar: "ANKH_FILTER_LABEL",
+		})
+		wait := cmd.Bool(cli.BoolOpt{
+			Name:   "wait",
+			Value:  false,
+			Desc:   "Wait for the objects to be removed, including their finalizers, eg: for load balancers and volumes to be released",
+			EnvVar: "ANKH_WAIT",
+		})
+		timeout := cmd.String(cli.StringOpt{
+			Name:   "timeout",
+			Value:  "",
+			Desc:   "How long to wait for the objects to be removed with --wait, eg: 10m. Defaults to 5m",
+			EnvVar: "ANKH_TIMEOUT",
+		})
+
+		cmd.Action = func() {
+			ctx.AnkhFilePath = *ankhFilePath
+			ctx.DryRun = *dryRun
+			ctx.Wait = *wait
+			ctx.WaitTimeout = *timeout
+			setChartArgs(ctx, *chart)
+			if *chartPath != "" {
+				ctx.Chart = *chartPath
+				ctx.LocalChart = true
+			}
+			ctx.Mode = ankh.Delete
+			filters := []string{}
+			for _, filter := range *filter {
+				filters = append(filters, string(filter))
+			}
+			ctx.Filters = filters
+			setObjectFilters(ctx, *filterName, *filterLabel)
+
+			execute(ctx)
+			os.Exit(0)
+		}
+	})
+
+	app.Command("scale", "Scale the Deployments, StatefulSets and HorizontalPodAutoscalers of one or more charts", func(cmd *cli.Cmd) {
+		cmd.Spec = "[--ankhfile] [--dry-run] [--chart...] [--chart-path] [--filter...] [--filter-name] [--filter-label...] [--replicas] [--min] [--max]"
+
+		ankhFilePath := cmd.String(cli.StringOpt{
+			Name:   "ankhfile",
+			Value:  "",
+			Desc:   "Path to an Ankh file for managing multiple charts, or \"-\" to read it from stdin",
+			EnvVar: "ANKH_ANKHFILE",
+		})
+		dryRun := cmd.Bool(cli.BoolOpt{
+			Name:   "dry-run",
+			Value:  false,
+			Desc:   "Perform a dry-run and don't actually scale anything",
+			EnvVar: "ANKH_DRY_RUN",
+		})
+		chart := cmd.Strings(cli.StringsOpt{
+			Name:   "chart",
+			Value:  []string{},
+			Desc:   "The chart to use. May be repeated, or given as a comma separated list, to operate over a subset of the charts in an Ankh file",
+			EnvVar: "ANKH_CHART",
+		})
+		chartPath := cmd.String(cli.StringOpt{
+			Name:   "chart-path",
+			Value:  "",
+			Desc:   "Use a local chart directory instead of a remote, versioned chart",
+			EnvVar: "ANKH_CHART_PATH",
+		})
+		filter := cmd.Strings(cli.StringsOpt{
+			Name:   "filter",
+			Value:  []string{},
+			Desc:   "Kubernetes object kinds to scale. The entries in this list are case insensitive. Any object whose `kind:` does not match this filter is left alone",
+			EnvVar: "ANKH_FILTER",
+		})
+		filterName := cmd.String(cli.StringOpt{
+			Name:   "filter-name",
+			Value:  "",
+			Desc:   "A regular expression on object names to include for the action, eg: `^api$`. Any object whose name does not match will be excluded from the action",
+			EnvVar: "ANKH_FILTER_NAME",
+		})
+		filterLabel := cmd.Strings(cli.StringsOpt{
+			Name:   "filter-label",
+			Value:  []string{},
+			Desc:   "Object labels to include for the action, as `key=value` or `key!=value`. Any object whose labels do not match every entry will be excluded from the action",
+			EnvVar: "ANKH_FILTER_LABEL",
+		})
+		replicasSet, minSet, maxSet := false, false, false
+		replicas := cmd.Int(cli.IntOpt{
+			Name:      "replicas",
+			Value:     0,
+			Desc:      "The number of replicas to scale Deployments and StatefulSets to",
+			EnvVar:    "ANKH_REPLICAS",
+			SetByUser: &replicasSet,
+		})
+		minReplicas := cmd.Int(cli.IntOpt{
+			Name:      "min",
+			Value:     0,
+			Desc:      "The minimum number of replicas to set on HorizontalPodAutoscalers",
+			EnvVar:    "ANKH_MIN",
+			SetByUser: &minSet,
+		})
+		maxReplicas := cmd.Int(cli.IntOpt{
+			Name:      "max",
+			Value:     0,
+			Desc:      "The maximum number of replicas to set on HorizontalPodAutoscalers",
+			EnvVar:    "ANKH_MAX",
+			SetByUser: &maxSet,
+		})
+
+		cmd.Action = func() {
+			ctx.AnkhFilePath = *ankhFilePath
+			ctx.DryRun = *dryRun
+			setChartArgs(ctx, *chart)
+			if *chartPath != "" {
+				ctx.Chart = *chartPath
+				ctx.LocalChart = true
+			}
+			ctx.Mode = ankh.Scale
+			filters := []string{}
+			for _, filter := range *filter {
+				filters = append(filters, string(filter))
+			}
+			ctx.Filters = filters
+			setObjectFilters(ctx, *filterName, *filterLabel)
+
+			if !replicasSet && !minSet && !maxSet {
+				ctx.Logger.Fatalf("Must provide at least one of `--replicas`, `--min` or `--max`")
+			}
+			if replicasSet {
+				if *replicas < 0 {
+					ctx.Logger.Fatalf("Invalid `--replicas` %v, must not be negative", *replicas)
+				}
+				ctx.ScaleReplicas = replicas
+			}
+			if minSet {
+				if *minReplicas < 1 {
+					ctx.Logger.Fatalf("Invalid `--min` %v, must be at least 1", *minReplicas)
+				}
+				ctx.ScaleMinReplicas = minReplicas
+			}
+			if maxSet {
+				if *maxReplicas < 1 || (minSet && *maxReplicas < *minReplicas) {
+					ctx.Logger.Fatalf("Invalid `--max` %v, must be at least 1 and at least `--min`", *maxReplicas)
+				}
+				ctx.ScaleMaxReplicas = maxReplicas
 			}
 
 			execute(ctx)
@@ -446,18 +1421,86 @@ func main() {
 	})
 
 	app.Command("diff", "Diff against live objects associated with one or more charts from Kubernetes", func(cmd *cli.Cmd) {
-		cmd.Spec = "[--ankhfile] [--chart] [--chart-path] [--filter...]"
+		cmd.Spec = "[--ankhfile] [--chart...] [--chart-path] [--filter...] [--filter-name] [--filter-label...] [--diff-tool] [--summary] [--force-replicas] [--force-max-unavailable] [--resolve-digests]"
+
+		ankhFilePath := cmd.String(cli.StringOpt{
+			Name:   "ankhfile",
+			Value:  "",
+			Desc:   "Path to an Ankh file for managing multiple charts, or \"-\" to read it from stdin",
+			EnvVar: "ANKH_ANKHFILE",
+		})
+		chart := cmd.Strings(cli.StringsOpt{
+			Name:   "chart",
+			Value:  []string{},
+			Desc:   "The chart to use. May be repeated, or given as a comma separated list, to operate over a subset of the charts in an Ankh file",
+			EnvVar: "ANKH_CHART",
+		})
+		chartPath := cmd.String(cli.StringOpt{
+			Name:   "chart-path",
+			Value:  "",
+			Desc:   "Use a local chart directory instead of a remote, versioned chart",
+			EnvVar: "ANKH_CHART_PATH",
+		})
+		filter := cmd.Strings(cli.StringsOpt{
+			Name:   "filter",
+			Value:  []string{},
+			Desc:   "Kubernetes object kinds to include for the action. The entries in this list are case insensitive. Any object whose `kind:` does not match this filter will be excluded from the action",
+			EnvVar: "ANKH_FILTER",
+		})
+		filterName := cmd.String(cli.StringOpt{
+			Name:   "filter-name",
+			Value:  "",
+			Desc:   "A regular expression on object names to include for the action, eg: `^api$`. Any object whose name does not match will be excluded from the action",
+			EnvVar: "ANKH_FILTER_NAME",
+		})
+		filterLabel := cmd.Strings(cli.StringsOpt{
+			Name:   "filter-label",
+			Value:  []string{},
+			Desc:   "Object labels to include for the action, as `key=value` or `key!=value`. Any object whose labels do not match every entry will be excluded from the action",
+			EnvVar: "ANKH_FILTER_LABEL",
+		})
+		diffTool := cmd.String(cli.StringOpt{
+			Name:   "diff-tool",
+			Value:  "",
+			Desc:   "External diff program to use, eg: `dyff between`, `icdiff -r`, or `meld`. Overrides `diff.tool` in ankh config and KUBECTL_EXTERNAL_DIFF.",
+			EnvVar: "ANKH_DIFF_TOOL",
+		})
+		summary := cmd.Bool(cli.BoolOpt{
+			Name:   "summary",
+			Value:  false,
+			Desc:   "Only list the objects that would change, without the changes to each",
+			EnvVar: "ANKH_DIFF_SUMMARY",
+		})
+
+		forceReplicas := cmd.String(cli.StringOpt{
+			Name:   "force-replicas",
+			Value:  "",
+			Desc:   "Set the replicas of every Deployment to this count, eg: 0 for an emergency scale-down, without changing the chart. Overridden Deployments are annotated with `ankh/overridden`",
+			EnvVar: "ANKH_FORCE_REPLICAS",
+		})
+		forceMaxUnavailable := cmd.String(cli.StringOpt{
+			Name:   "force-max-unavailable",
+			Value:  "",
+			Desc:   "Set the rolling update maxUnavailable of every Deployment, as a number of pods or a percentage, eg: 1 or 10%, without changing the chart",
+			EnvVar: "ANKH_FORCE_MAX_UNAVAILABLE",
+		})
 
-		ankhFilePath := cmd.StringOpt("ankhfile", "", "Path to an Ankh file for managing multiple charts")
-		chart := cmd.StringOpt("chart", "", "The chart to use")
-		chartPath := cmd.StringOpt("chart-path", "", "Use a local chart directory instead of a remote, versioned chart")
-		filter := cmd.StringsOpt("filter", []string{}, "Kubernetes object kinds to include for the action. The entries in this list are case insensitive. Any object whose `kind:` does not match this filter will be excluded from the action")
+		resolveDigests := cmd.Bool(cli.BoolOpt{
+			Name:   "resolve-digests",
+			Value:  false,
+			Desc:   "Pin each chart's tag to the digest it points to in the registry, passing `$tagKey=$tag@sha256:...` to helm, so that pushing the tag again can't change what is applied. Overrides `docker.resolveDigests` in ankh config",
+			EnvVar: "ANKH_RESOLVE_DIGESTS",
+		})
 
 		cmd.Action = func() {
 			setLogLevel(ctx, logrus.InfoLevel)
 			ctx.AnkhFilePath = *ankhFilePath
+			ctx.ForceReplicas = *forceReplicas
+			ctx.ForceMaxUnavailable = *forceMaxUnavailable
 			ctx.DryRun = false
-			ctx.Chart = *chart
+			ctx.DiffTool = *diffTool
+			ctx.DiffSummary = *summary
+			setChartArgs(ctx, *chart)
 			if *chartPath != "" {
 				ctx.Chart = *chartPath
 				ctx.LocalChart = true
@@ -468,24 +1511,57 @@ func main() {
 				filters = append(filters, string(filter))
 			}
 			ctx.Filters = filters
+			setObjectFilters(ctx, *filterName, *filterLabel)
 
+			ctx.ResolveDigests = *resolveDigests
 			execute(ctx)
 			os.Exit(0)
 		}
 	})
 
+	app.Command("diff-filter", "(internal) Diff two directories of manifests, leaving out the fields ignored by `diff.ignore`. Run by `kubectl diff` for `ankh diff`", func(cmd *cli.Cmd) {
+		ctx.IgnoreContextAndEnv = true
+		ctx.IgnoreConfigErrors = true
+		ctx.SkipConfig = true
+
+		cmd.Spec = "FROM TO"
+		from := cmd.StringArg("FROM", "", "The directory of live manifests")
+		to := cmd.StringArg("TO", "", "The directory of merged manifests")
+
+		cmd.Action = func() {
+			exitCode, err := kubectl.DiffFilter(*from, *to)
+			check(err)
+			os.Exit(exitCode)
+		}
+	})
+
 	app.Command("get", "Get objects associated with a chart from Kubernetes", func(cmd *cli.Cmd) {
-		cmd.Spec = "[--chart] [--chart-path] [--filter...] [EXTRA...]"
+		cmd.Spec = "[--chart...] [--chart-path] [--filter...] [EXTRA...]"
 
-		chart := cmd.StringOpt("chart", "", "The chart to use")
-		chartPath := cmd.StringOpt("chart-path", "", "Use a local chart directory instead of a remote, versioned chart")
-		filter := cmd.StringsOpt("filter", []string{}, "Kubernetes object kinds to include for the action. The entries in this list are case insensitive. Any object whose `kind:` does not match this filter will be excluded from the action")
+		chart := cmd.Strings(cli.StringsOpt{
+			Name:   "chart",
+			Value:  []string{},
+			Desc:   "The chart to use. May be repeated, or given as a comma separated list, to operate over a subset of the charts in an Ankh file",
+			EnvVar: "ANKH_CHART",
+		})
+		chartPath := cmd.String(cli.StringOpt{
+			Name:   "chart-path",
+			Value:  "",
+			Desc:   "Use a local chart directory instead of a remote, versioned chart",
+			EnvVar: "ANKH_CHART_PATH",
+		})
+		filter := cmd.Strings(cli.StringsOpt{
+			Name:   "filter",
+			Value:  []string{},
+			Desc:   "Kubernetes object kinds to include for the action. The entries in this list are case insensitive. Any object whose `kind:` does not match this filter will be excluded from the action",
+			EnvVar: "ANKH_FILTER",
+		})
 		extra := cmd.StringsArg("EXTRA", []string{}, "Extra arguments to pass to `kubectl`, which can be specified after `--` eg: `ankh ... get -- -o json`")
 
 		cmd.Action = func() {
 			setLogLevel(ctx, logrus.InfoLevel)
 			ctx.DryRun = false
-			ctx.Chart = *chart
+			setChartArgs(ctx, *chart)
 			if *chartPath != "" {
 				ctx.Chart = *chartPath
 				ctx.LocalChart = true
@@ -507,19 +1583,45 @@ func main() {
 	})
 
 	app.Command("pods", "Get pods associated with a chart from Kubernetes", func(cmd *cli.Cmd) {
-		cmd.Spec = "[-w] [-d] [--chart] [--chart-path] [EXTRA...]"
+		cmd.Spec = "[-w [--ui]] [-d] [--chart...] [--chart-path] [EXTRA...]"
 
-		chart := cmd.StringOpt("chart", "", "The chart to use")
-		chartPath := cmd.StringOpt("chart-path", "", "Use a local chart directory instead of a remote, versioned chart")
-		watch := cmd.BoolOpt("w watch", false, "Watch for updates (ie: pass -w to kubectl)")
-		describe := cmd.BoolOpt("d describe", false, "Use `kubectl describe ...` instead of `kubectl get -o wide ...` for pods")
+		chart := cmd.Strings(cli.StringsOpt{
+			Name:   "chart",
+			Value:  []string{},
+			Desc:   "The chart to use. May be repeated, or given as a comma separated list, to operate over a subset of the charts in an Ankh file",
+			EnvVar: "ANKH_CHART",
+		})
+		chartPath := cmd.String(cli.StringOpt{
+			Name:   "chart-path",
+			Value:  "",
+			Desc:   "Use a local chart directory instead of a remote, versioned chart",
+			EnvVar: "ANKH_CHART_PATH",
+		})
+		watch := cmd.Bool(cli.BoolOpt{
+			Name:   "w watch",
+			Value:  false,
+			Desc:   "Watch for updates (ie: pass -w to kubectl)",
+			EnvVar: "ANKH_WATCH",
+		})
+		describe := cmd.Bool(cli.BoolOpt{
+			Name:   "d describe",
+			Value:  false,
+			Desc:   "Use `kubectl describe ...` instead of `kubectl get -o wide ...` for pods",
+			EnvVar: "ANKH_DESCRIBE",
+		})
+		ui := cmd.Bool(cli.BoolOpt{
+			Name:   "ui",
+			Value:  false,
+			Desc:   "With --watch, show a live dashboard of the pods, grouped by Deployment, with their recent events, instead of kubectl's output",
+			EnvVar: "ANKH_PODS_UI",
+		})
 		extra := cmd.StringsArg("EXTRA", []string{}, "Extra arguments to pass to `kubectl`, which can be specified after `--` eg: `ankh ... get -- -o json`")
 
 		cmd.Action = func() {
 			setLogLevel(ctx, logrus.InfoLevel)
 			ctx.DryRun = false
 			ctx.Describe = *describe
-			ctx.Chart = *chart
+			setChartArgs(ctx, *chart)
 			if *chartPath != "" {
 				ctx.Chart = *chartPath
 				ctx.LocalChart = true
@@ -529,7 +1631,12 @@ func main() {
 				ctx.Logger.Debugf("Appending extra arg: %+v", e)
 				ctx.ExtraArgs = append(ctx.ExtraArgs, e)
 			}
-			if *watch {
+			if *watch && *ui {
+				if *describe || len(ctx.ExtraArgs) > 0 {
+					ctx.Logger.Fatalf("The pod dashboard (--ui) cannot be combined with --describe or extra kubectl arguments")
+				}
+				ctx.PodDashboard = true
+			} else if *watch {
 				ctx.Logger.Debug("Appending watch args as extra args")
 				ctx.ExtraArgs = append(ctx.ExtraArgs, "-w")
 				ctx.ShouldCatchSignals = true
@@ -541,25 +1648,62 @@ func main() {
 	})
 
 	app.Command("logs", "Get logs for a pod associated with a chart from Kubernetes", func(cmd *cli.Cmd) {
-		cmd.Spec = "[-c] [-f] [--previous] [--tail] [--chart] [--chart-path] [CONTAINER]"
-
-		numTailLines := cmd.IntOpt("t tail", 10, "The number of most recent log lines to see. Pass 0 to receive all log lines available from Kubernetes, which is subject to its own retential policy.")
-		follow := cmd.BoolOpt("f", false, "Follow logs")
-		previous := cmd.BoolOpt("p previous", false, "Get logs for the previously terminated container, if any")
-		chart := cmd.StringOpt("chart", "", "The chart to use")
-		chartPath := cmd.StringOpt("chart-path", "", "Use a local chart directory instead of a remote, versioned chart")
-		container := cmd.StringOpt("c container", "", "The container to exec on.")
+		cmd.Spec = "[-c] [-f] [--previous] [--tail] [--all] [--chart...] [--chart-path] [CONTAINER]"
+
+		numTailLines := cmd.Int(cli.IntOpt{
+			Name:   "t tail",
+			Value:  10,
+			Desc:   "The number of most recent log lines to see. Pass 0 to receive all log lines available from Kubernetes, which is subject to its own retential policy.",
+			EnvVar: "ANKH_TAIL",
+		})
+		follow := cmd.Bool(cli.BoolOpt{
+			Name:   "f",
+			Value:  false,
+			Desc:   "Follow logs",
+			EnvVar: "ANKH_FOLLOW",
+		})
+		previous := cmd.Bool(cli.BoolOpt{
+			Name:   "p previous",
+			Value:  false,
+			Desc:   "Get logs for the previously terminated container, if any",
+			EnvVar: "ANKH_PREVIOUS",
+		})
+		all := cmd.Bool(cli.BoolOpt{
+			Name:   "all",
+			Value:  false,
+			Desc:   "Stream logs from all of the chart's pods at once, prefixing each line with its pod's name, instead of selecting one pod",
+			EnvVar: "ANKH_ALL",
+		})
+		chart := cmd.Strings(cli.StringsOpt{
+			Name:   "chart",
+			Value:  []string{},
+			Desc:   "The chart to use. May be repeated, or given as a comma separated list, to operate over a subset of the charts in an Ankh file",
+			EnvVar: "ANKH_CHART",
+		})
+		chartPath := cmd.String(cli.StringOpt{
+			Name:   "chart-path",
+			Value:  "",
+			Desc:   "Use a local chart directory instead of a remote, versioned chart",
+			EnvVar: "ANKH_CHART_PATH",
+		})
+		container := cmd.String(cli.StringOpt{
+			Name:   "c container",
+			Value:  "",
+			Desc:   "The container to exec on.",
+			EnvVar: "ANKH_CONTAINER",
+		})
 		containerArg := cmd.StringArg("CONTAINER", "", "The container to get logs for.")
 
 		cmd.Action = func() {
 			setLogLevel(ctx, logrus.InfoLevel)
 			ctx.DryRun = false
-			ctx.Chart = *chart
+			setChartArgs(ctx, *chart)
 			if *chartPath != "" {
 				ctx.Chart = *chartPath
 				ctx.LocalChart = true
 			}
 			ctx.Mode = ankh.Logs
+			ctx.AllPods = *all
 			if *follow {
 				ctx.ExtraArgs = append(ctx.ExtraArgs, "-f")
 				ctx.ShouldCatchSignals = true
@@ -588,25 +1732,50 @@ func main() {
 	})
 
 	app.Command("exec", "Exec a command on a pod associated with a chart in Kubernetes", func(cmd *cli.Cmd) {
-		cmd.Spec = "[-c] [--chart] [--chart-path] [PASSTHROUGH...]"
+		cmd.Spec = "[-c] [--all] [--chart...] [--chart-path] [PASSTHROUGH...]"
 
-		chart := cmd.StringOpt("chart", "", "The chart to use")
-		chartPath := cmd.StringOpt("chart-path", "", "Use a local chart directory instead of a remote, versioned chart")
-		container := cmd.StringOpt("c container", "", "The container to exec the command on")
+		chart := cmd.Strings(cli.StringsOpt{
+			Name:   "chart",
+			Value:  []string{},
+			Desc:   "The chart to use. May be repeated, or given as a comma separated list, to operate over a subset of the charts in an Ankh file",
+			EnvVar: "ANKH_CHART",
+		})
+		chartPath := cmd.String(cli.StringOpt{
+			Name:   "chart-path",
+			Value:  "",
+			Desc:   "Use a local chart directory instead of a remote, versioned chart",
+			EnvVar: "ANKH_CHART_PATH",
+		})
+		container := cmd.String(cli.StringOpt{
+			Name:   "c container",
+			Value:  "",
+			Desc:   "The container to exec the command on",
+			EnvVar: "ANKH_CONTAINER",
+		})
+		all := cmd.Bool(cli.BoolOpt{
+			Name:   "all",
+			Value:  false,
+			Desc:   "Run the command on each of the chart's pods in turn, reporting each pod's exit code, instead of selecting one pod",
+			EnvVar: "ANKH_ALL",
+		})
 		extra := cmd.StringsArg("PASSTHROUGH", []string{}, "Pass-through arguments to provide to `kubectl` after `exec`, which can be specified after `--` eg: `ankh ... get -- -o json`")
 
 		cmd.Action = func() {
 			setLogLevel(ctx, logrus.InfoLevel)
 			ctx.DryRun = false
-			ctx.Chart = *chart
+			setChartArgs(ctx, *chart)
 			if *chartPath != "" {
 				ctx.Chart = *chartPath
 				ctx.LocalChart = true
 			}
 			ctx.Mode = ankh.Exec
+			ctx.AllPods = *all
 			if *container != "" {
 				ctx.ExtraArgs = append(ctx.ExtraArgs, []string{"-c", *container}...)
 			}
+			if *all && len(*extra) == 0 {
+				ctx.Logger.Fatalf("`--all` runs a command on each pod without a terminal, so it needs a command, eg: `ankh exec --all -- env`")
+			}
 			if len(*extra) == 0 {
 				*extra = []string{"/bin/sh"}
 			}
@@ -620,17 +1789,94 @@ func main() {
 		}
 	})
 
+	app.Command("port-forward", "Forward a local port to a service or pod associated with a chart in Kubernetes", func(cmd *cli.Cmd) {
+		cmd.Spec = "[--local-port] [--remote-port] [--no-reconnect] [--chart...] [--chart-path] [PASSTHROUGH...]"
+
+		chart := cmd.Strings(cli.StringsOpt{
+			Name:   "chart",
+			Value:  []string{},
+			Desc:   "The chart to use. May be repeated, or given as a comma separated list, to operate over a subset of the charts in an Ankh file",
+			EnvVar: "ANKH_CHART",
+		})
+		chartPath := cmd.String(cli.StringOpt{
+			Name:   "chart-path",
+			Value:  "",
+			Desc:   "Use a local chart directory instead of a remote, versioned chart",
+			EnvVar: "ANKH_CHART_PATH",
+		})
+		localPort := cmd.Int(cli.IntOpt{
+			Name:   "local-port",
+			Value:  0,
+			Desc:   "The local port to listen on. Defaults to the remote port",
+			EnvVar: "ANKH_LOCAL_PORT",
+		})
+		remotePort := cmd.Int(cli.IntOpt{
+			Name:   "remote-port",
+			Value:  0,
+			Desc:   "The port of the service or pod to forward to. Prompts for one of its ports by default",
+			EnvVar: "ANKH_REMOTE_PORT",
+		})
+		noReconnect := cmd.Bool(cli.BoolOpt{
+			Name:   "no-reconnect",
+			Value:  false,
+			Desc:   "Stop when kubectl exits, eg: when the pod is replaced, instead of reconnecting",
+			EnvVar: "ANKH_NO_RECONNECT",
+		})
+		extra := cmd.StringsArg("PASSTHROUGH", []string{}, "Pass-through arguments to provide to `kubectl` after `port-forward`, which can be specified after `--` eg: `ankh ... port-forward -- --address 0.0.0.0`")
+
+		cmd.Action = func() {
+			setLogLevel(ctx, logrus.InfoLevel)
+			ctx.DryRun = false
+			setChartArgs(ctx, *chart)
+			if *chartPath != "" {
+				ctx.Chart = *chartPath
+				ctx.LocalChart = true
+			}
+			ctx.Mode = ankh.PortForward
+			ctx.LocalPort = *localPort
+			ctx.RemotePort = *remotePort
+			ctx.NoReconnect = *noReconnect
+			for _, e := range *extra {
+				ctx.Logger.Debugf("Appending extra arg: %+v", e)
+				ctx.ExtraArgs = append(ctx.ExtraArgs, e)
+			}
+
+			execute(ctx)
+			os.Exit(0)
+		}
+	})
+
 	app.Command("lint", "Lint one or more charts, checking for possible errors or mistakes", func(cmd *cli.Cmd) {
-		cmd.Spec = "[--ankhfile] [--chart] [--chart-path] [--filter...]"
+		cmd.Spec = "[--ankhfile] [--chart...] [--chart-path] [--filter...]"
 
-		ankhFilePath := cmd.StringOpt("ankhfile", "", "Path to an Ankh file for managing multiple charts")
-		chart := cmd.StringOpt("chart", "", "The chart to use")
-		chartPath := cmd.StringOpt("chart-path", "", "Use a local chart directory instead of a remote, versioned chart")
-		filter := cmd.StringsOpt("filter", []string{}, "Kubernetes object kinds to include for the action. The entries in this list are case insensitive. Any object whose `kind:` does not match this filter will be excluded from the action")
+		ankhFilePath := cmd.String(cli.StringOpt{
+			Name:   "ankhfile",
+			Value:  "",
+			Desc:   "Path to an Ankh file for managing multiple charts, or \"-\" to read it from stdin",
+			EnvVar: "ANKH_ANKHFILE",
+		})
+		chart := cmd.Strings(cli.StringsOpt{
+			Name:   "chart",
+			Value:  []string{},
+			Desc:   "The chart to use. May be repeated, or given as a comma separated list, to operate over a subset of the charts in an Ankh file",
+			EnvVar: "ANKH_CHART",
+		})
+		chartPath := cmd.String(cli.StringOpt{
+			Name:   "chart-path",
+			Value:  "",
+			Desc:   "Use a local chart directory instead of a remote, versioned chart",
+			EnvVar: "ANKH_CHART_PATH",
+		})
+		filter := cmd.Strings(cli.StringsOpt{
+			Name:   "filter",
+			Value:  []string{},
+			Desc:   "Kubernetes object kinds to include for the action. The entries in this list are case insensitive. Any object whose `kind:` does not match this filter will be excluded from the action",
+			EnvVar: "ANKH_FILTER",
+		})
 
 		cmd.Action = func() {
 			ctx.AnkhFilePath = *ankhFilePath
-			ctx.Chart = *chart
+			setChartArgs(ctx, *chart)
 			if *chartPath != "" {
 				ctx.Chart = *chartPath
 				ctx.LocalChart = true
@@ -643,37 +1889,238 @@ func main() {
 			ctx.Filters = filters
 
 			execute(ctx)
+			// Errors exit with 1 as soon as they are found. Warnings alone
+			// exit with 2, so that CI can tell them apart.
+			if ctx.LintWarnings > 0 {
+				os.Exit(2)
+			}
+			os.Exit(0)
+		}
+	})
+
+	app.Command("graph", "Show the order that the dependencies and charts of an Ankh file are operated on in, by their `dependsOn`", func(cmd *cli.Cmd) {
+		ctx.IgnoreContextAndEnv = true
+		ctx.IgnoreConfigErrors = true
+		ctx.SkipConfig = true
+
+		cmd.Spec = "[--ankhfile]"
+		ankhFilePath := cmd.String(cli.StringOpt{
+			Name:   "ankhfile",
+			Value:  "ankh.yaml",
+			Desc:   "Path to an Ankh file, or \"-\" to read it from stdin",
+			EnvVar: "ANKH_ANKHFILE",
+		})
+
+		cmd.Action = func() {
+			printGraph(*ankhFilePath)
 			os.Exit(0)
 		}
 	})
 
 	app.Command("template", "Output the results of templating one or more charts.", func(cmd *cli.Cmd) {
-		cmd.Spec = "[--ankhfile] [--chart] [--chart-path] [--filter...]"
+		cmd.Spec = "[--ankhfile] [--chart...] [--chart-path] [--filter...] [--filter-name] [--filter-label...] [--export-dir] [--force-replicas] [--force-max-unavailable] [--resolve-digests]"
+
+		ankhFilePath := cmd.String(cli.StringOpt{
+			Name:   "ankhfile",
+			Value:  "",
+			Desc:   "Path to an Ankh file for managing multiple charts, or \"-\" to read it from stdin",
+			EnvVar: "ANKH_ANKHFILE",
+		})
+		chart := cmd.Strings(cli.StringsOpt{
+			Name:   "chart",
+			Value:  []string{},
+			Desc:   "The chart to use. May be repeated, or given as a comma separated list, to operate over a subset of the charts in an Ankh file",
+			EnvVar: "ANKH_CHART",
+		})
+		chartPath := cmd.String(cli.StringOpt{
+			Name:   "chart-path",
+			Value:  "",
+			Desc:   "Use a local chart directory instead of a remote, versioned chart",
+			EnvVar: "ANKH_CHART_PATH",
+		})
+		filter := cmd.Strings(cli.StringsOpt{
+			Name:   "filter",
+			Value:  []string{},
+			Desc:   "Kubernetes object kinds to include for the action. The entries in this list are case insensitive. Any object whose `kind:` does not match this filter will be excluded from the action",
+			EnvVar: "ANKH_FILTER",
+		})
+		filterName := cmd.String(cli.StringOpt{
+			Name:   "filter-name",
+			Value:  "",
+			Desc:   "A regular expression on object names to include for the action, eg: `^api$`. Any object whose name does not match will be excluded from the action",
+			EnvVar: "ANKH_FILTER_NAME",
+		})
+		filterLabel := cmd.Strings(cli.StringsOpt{
+			Name:   "filter-label",
+			Value:  []string{},
+			Desc:   "Object labels to include for the action, as `key=value` or `key!=value`. Any object whose labels do not match every entry will be excluded from the action",
+			EnvVar: "ANKH_FILTER_LABEL",
+		})
+		exportDir := cmd.String(cli.StringOpt{
+			Name:   "export-dir",
+			Value:  "",
+			Desc:   "Write each object to a file of its own, as <namespace>/<kind>-<name>.yaml under this directory, with a kustomization.yaml index, instead of to stdout. eg: for GitOps repositories",
+			EnvVar: "ANKH_EXPORT_DIR",
+		})
+
+		forceReplicas := cmd.String(cli.StringOpt{
+			Name:   "force-replicas",
+			Value:  "",
+			Desc:   "Set the replicas of every Deployment to this count, eg: 0 for an emergency scale-down, without changing the chart. Overridden Deployments are annotated with `ankh/overridden`",
+			EnvVar: "ANKH_FORCE_REPLICAS",
+		})
+		forceMaxUnavailable := cmd.String(cli.StringOpt{
+			Name:   "force-max-unavailable",
+			Value:  "",
+			Desc:   "Set the rolling update maxUnavailable of every Deployment, as a number of pods or a percentage, eg: 1 or 10%, without changing the chart",
+			EnvVar: "ANKH_FORCE_MAX_UNAVAILABLE",
+		})
 
-		ankhFilePath := cmd.StringOpt("ankhfile", "", "Path to an Ankh file for managing multiple charts")
-		chart := cmd.StringOpt("chart", "", "The chart to use")
-		chartPath := cmd.StringOpt("chart-path", "", "Use a local chart directory instead of a remote, versioned chart")
-		filter := cmd.StringsOpt("filter", []string{}, "Kubernetes object kinds to include for the action. The entries in this list are case insensitive. Any object whose `kind:` does not match this filter will be excluded from the action")
+		resolveDigests := cmd.Bool(cli.BoolOpt{
+			Name:   "resolve-digests",
+			Value:  false,
+			Desc:   "Pin each chart's tag to the digest it points to in the registry, passing `$tagKey=$tag@sha256:...` to helm, so that pushing the tag again can't change what is applied. Overrides `docker.resolveDigests` in ankh config",
+			EnvVar: "ANKH_RESOLVE_DIGESTS",
+		})
 
 		cmd.Action = func() {
 			ctx.AnkhFilePath = *ankhFilePath
-			ctx.Chart = *chart
+			ctx.ForceReplicas = *forceReplicas
+			ctx.ForceMaxUnavailable = *forceMaxUnavailable
+			setChartArgs(ctx, *chart)
 			if *chartPath != "" {
 				ctx.Chart = *chartPath
 				ctx.LocalChart = true
 			}
 			ctx.Mode = ankh.Template
+			ctx.ExportDir = *exportDir
 			filters := []string{}
 			for _, filter := range *filter {
 				filters = append(filters, string(filter))
 			}
 			ctx.Filters = filters
+			setObjectFilters(ctx, *filterName, *filterLabel)
+
+			ctx.ResolveDigests = *resolveDigests
+			execute(ctx)
+			os.Exit(0)
+		}
+	})
+
+	app.Command("history", "Show the revisions of the Deployments and StatefulSets of one or more charts, for `rollback --to-revision`", func(cmd *cli.Cmd) {
+		cmd.Spec = "[--ankhfile] [--chart...] [--chart-path]"
+
+		ankhFilePath := cmd.String(cli.StringOpt{
+			Name:   "ankhfile",
+			Value:  "",
+			Desc:   "Path to an Ankh file for managing multiple charts, or \"-\" to read it from stdin",
+			EnvVar: "ANKH_ANKHFILE",
+		})
+		chart := cmd.Strings(cli.StringsOpt{
+			Name:   "chart",
+			Value:  []string{},
+			Desc:   "The chart to use. May be repeated, or given as a comma separated list, to operate over a subset of the charts in an Ankh file",
+			EnvVar: "ANKH_CHART",
+		})
+		chartPath := cmd.String(cli.StringOpt{
+			Name:   "chart-path",
+			Value:  "",
+			Desc:   "Use a local chart directory instead of a remote, versioned chart",
+			EnvVar: "ANKH_CHART_PATH",
+		})
+
+		cmd.Action = func() {
+			setLogLevel(ctx, logrus.InfoLevel)
+			ctx.AnkhFilePath = *ankhFilePath
+			ctx.DryRun = false
+			setChartArgs(ctx, *chart)
+			if *chartPath != "" {
+				ctx.Chart = *chartPath
+				ctx.LocalChart = true
+			}
+			ctx.Mode = ankh.History
 
 			execute(ctx)
 			os.Exit(0)
 		}
 	})
 
+	app.Command("report", "Report on the state of one or more charts across contexts", func(cmd *cli.Cmd) {
+		cmd.Command("images", "Show the live container images for each chart, per context, highlighting drift across contexts", func(cmd *cli.Cmd) {
+			cmd.Spec = "[--ankhfile] [--chart...] [--chart-path]"
+
+			ankhFilePath := cmd.String(cli.StringOpt{
+				Name:   "ankhfile",
+				Value:  "",
+				Desc:   "Path to an Ankh file for managing multiple charts, or \"-\" to read it from stdin",
+				EnvVar: "ANKH_ANKHFILE",
+			})
+			chart := cmd.Strings(cli.StringsOpt{
+				Name:   "chart",
+				Value:  []string{},
+				Desc:   "The chart to use. May be repeated, or given as a comma separated list, to operate over a subset of the charts in an Ankh file",
+				EnvVar: "ANKH_CHART",
+			})
+			chartPath := cmd.String(cli.StringOpt{
+				Name:   "chart-path",
+				Value:  "",
+				Desc:   "Use a local chart directory instead of a remote, versioned chart",
+				EnvVar: "ANKH_CHART_PATH",
+			})
+
+			cmd.Action = func() {
+				ctx.AnkhFilePath = *ankhFilePath
+				setChartArgs(ctx, *chart)
+				if *chartPath != "" {
+					ctx.Chart = *chartPath
+					ctx.LocalChart = true
+				}
+				ctx.Mode = ankh.Report
+
+				execute(ctx)
+				os.Exit(0)
+			}
+		})
+	})
+
+	app.Command("secrets", "View and edit the encrypted values of a chart", func(cmd *cli.Cmd) {
+		cmd.Command("view", "Decrypt and show the secret values of a chart from an Ankh file", func(cmd *cli.Cmd) {
+			cmd.Spec = "[--ankhfile] CHART"
+
+			ankhFilePath := cmd.String(cli.StringOpt{
+				Name:   "ankhfile",
+				Value:  "ankh.yaml",
+				Desc:   "Path to the Ankh file that declares the chart's secrets",
+				EnvVar: "ANKH_ANKHFILE",
+			})
+			chart := cmd.StringArg("CHART", "", "The chart whose secrets to show, by name or alias")
+
+			cmd.Action = func() {
+				ctx.AnkhFilePath = *ankhFilePath
+				viewSecrets(ctx, *chart)
+				os.Exit(0)
+			}
+		})
+
+		cmd.Command("edit", "Edit the sops-encrypted secrets of a chart from an Ankh file, or a sops-encrypted file", func(cmd *cli.Cmd) {
+			cmd.Spec = "[--ankhfile] CHART_OR_FILE"
+
+			ankhFilePath := cmd.String(cli.StringOpt{
+				Name:   "ankhfile",
+				Value:  "ankh.yaml",
+				Desc:   "Path to the Ankh file that declares the chart's secrets",
+				EnvVar: "ANKH_ANKHFILE",
+			})
+			arg := cmd.StringArg("CHART_OR_FILE", "", "The chart whose secrets to edit, by name or alias, or a sops-encrypted file")
+
+			cmd.Action = func() {
+				ctx.AnkhFilePath = *ankhFilePath
+				editSecrets(ctx, *arg)
+				os.Exit(0)
+			}
+		})
+	})
+
 	app.Command("image", "Manage Docker images", func(cmd *cli.Cmd) {
 		ctx.IgnoreContextAndEnv = true
 		ctx.IgnoreConfigErrors = true
@@ -688,7 +2135,9 @@ func main() {
 
 				output, err := docker.ListTags(ctx, registryDomain, image, false)
 				check(err)
-				if output != "" {
+				if ctx.Output != "" {
+					fmt.Print(output)
+				} else if output != "" {
 					fmt.Println(output)
 				}
 				os.Exit(0)
@@ -697,8 +2146,18 @@ func main() {
 
 		cmd.Command("ls", "List images for a Docker repository", func(cmd *cli.Cmd) {
 			cmd.Spec = "[-n] [-r]"
-			numToShow := cmd.IntOpt("n num", 5, "Number of tags to show, fuzzy-sorted descending by semantic version. Pass zero to see all versions.")
-			registryArg := cmd.StringOpt("r registry", "", "The docker registry to use")
+			numToShow := cmd.Int(cli.IntOpt{
+				Name:   "n num",
+				Value:  5,
+				Desc:   "Number of tags to show, fuzzy-sorted descending by semantic version. Pass zero to see all versions.",
+				EnvVar: "ANKH_NUM",
+			})
+			registryArg := cmd.String(cli.StringOpt{
+				Name:   "r registry",
+				Value:  "",
+				Desc:   "The docker registry to use",
+				EnvVar: "ANKH_REGISTRY",
+			})
 
 			cmd.Action = func() {
 				registryDomain := ctx.AnkhConfig.Docker.Registry
@@ -709,12 +2168,95 @@ func main() {
 				output, err := docker.ListImages(ctx, registryDomain, *numToShow)
 				check(err)
 				if output != "" {
-					fmt.Printf(output)
+					util.Page(ctx, output)
 				}
 				os.Exit(0)
 			}
 		})
-	})
+
+		cmd.Command("promote", "Copy a Docker image tag from one registry to another", func(cmd *cli.Cmd) {
+			cmd.Spec = "[--from] [--to] [--dry-run] IMAGE TAG"
+			imageArg := cmd.StringArg("IMAGE", "", "The docker image to promote, without a registry")
+			tagArg := cmd.StringArg("TAG", "", "The tag to promote")
+			fromArg := cmd.String(cli.StringOpt{
+				Name:   "from",
+				Value:  "",
+				Desc:   "The registry to copy the tag from. Defaults to `docker.promote.sourceRegistry`.",
+				EnvVar: "ANKH_FROM",
+			})
+			toArg := cmd.String(cli.StringOpt{
+				Name:   "to",
+				Value:  "",
+				Desc:   "The registry to copy the tag to. Defaults to `docker.promote.targetRegistry`, or else `docker.registry`.",
+				EnvVar: "ANKH_TO",
+			})
+			dryRun := cmd.Bool(cli.BoolOpt{
+				Name:   "dry-run",
+				Value:  false,
+				Desc:   "Only show what would be promoted",
+				EnvVar: "ANKH_DRY_RUN",
+			})
+
+			cmd.Action = func() {
+				ctx.DryRun = *dryRun
+				if !*dryRun {
+					check(ankhlib.CheckWritable(ctx, "promote an image tag"))
+				}
+				sourceRegistry, targetRegistry, err := docker.PromoteRegistries(ctx, *fromArg, *toArg)
+				check(err)
+
+				err = docker.PromoteTag(ctx, sourceRegistry, targetRegistry, *imageArg, *tagArg)
+				check(err)
+				os.Exit(0)
+			}
+		})
+
+		cmd.Command("prune", "Delete stale tags for a Docker image", func(cmd *cli.Cmd) {
+			cmd.Spec = "[--older-than] [--keep] [--dry-run] [--yes] IMAGE"
+			imageArg := cmd.StringArg("IMAGE", "", "The docker image to prune tags for")
+			olderThan := cmd.Int(cli.IntOpt{
+				Name:   "older-than",
+				Value:  0,
+				Desc:   "Prune tags created more than this many days ago",
+				EnvVar: "ANKH_OLDER_THAN",
+			})
+			keep := cmd.Int(cli.IntOpt{
+				Name:   "keep",
+				Value:  0,
+				Desc:   "Prune tags beyond the newest this many, fuzzy-sorted by semantic version. When used with --older-than, a tag must exceed both to be pruned.",
+				EnvVar: "ANKH_KEEP",
+			})
+			dryRun := cmd.Bool(cli.BoolOpt{
+				Name:   "dry-run",
+				Value:  false,
+				Desc:   "Only list the tags that would be pruned",
+				EnvVar: "ANKH_DRY_RUN",
+			})
+			yes := cmd.Bool(cli.BoolOpt{
+				Name:   "yes",
+				Value:  false,
+				Desc:   "Delete tags without asking for confirmation",
+				EnvVar: "ANKH_YES",
+			})
+
+			cmd.Action = func() {
+				if !*dryRun {
+					check(ankhlib.CheckWritable(ctx, "prune image tags"))
+				}
+				registryDomain, image, err := docker.ParseImage(ctx, *imageArg)
+				check(err)
+
+				err = docker.PruneTags(ctx, registryDomain, image, docker.PruneOpts{
+					OlderThanDays: *olderThan,
+					Keep:          *keep,
+					DryRun:        *dryRun,
+					Yes:           *yes,
+				})
+				check(err)
+				os.Exit(0)
+			}
+		})
+	})
 
 	app.Command("chart", "Manage Helm charts", func(cmd *cli.Cmd) {
 		ctx.IgnoreContextAndEnv = true
@@ -722,11 +2264,36 @@ func main() {
 
 		cmd.Command("create", "Creates a chart directory along with the common files and directories used in a Helm chart", func(cmd *cli.Cmd) {
 			cmd.Spec = "[--chart-path] [--starter-chart][--tag-image] [--app-name] [-r]"
-			chartPath := cmd.StringOpt("chart-path", "", "The location to create the helm chart, defaults to helm/<app-name> based on directory")
-			appName := cmd.StringOpt("app-name", "", "The name to be used for the chart, chart-path overrides this value if both are set")
-			starterChart := cmd.StringOpt("starter-chart", "", "The name of the chart in $HELM_HOME/starters/, if not available locally will attempt to pull from remote helm repository")
-			tagImage := cmd.StringOpt("tag-image", "", "The name of the docker image, defaults to app-name")
-			repositoryArg := cmd.StringOpt("r repository", "", "The chart repository to use")
+			chartPath := cmd.String(cli.StringOpt{
+				Name:   "chart-path",
+				Value:  "",
+				Desc:   "The location to create the helm chart, defaults to helm/<app-name> based on directory",
+				EnvVar: "ANKH_CHART_PATH",
+			})
+			appName := cmd.String(cli.StringOpt{
+				Name:   "app-name",
+				Value:  "",
+				Desc:   "The name to be used for the chart, chart-path overrides this value if both are set",
+				EnvVar: "ANKH_APP_NAME",
+			})
+			starterChart := cmd.String(cli.StringOpt{
+				Name:   "starter-chart",
+				Value:  "",
+				Desc:   "The name of the chart in $HELM_HOME/starters/, if not available locally will attempt to pull from remote helm repository",
+				EnvVar: "ANKH_STARTER_CHART",
+			})
+			tagImage := cmd.String(cli.StringOpt{
+				Name:   "tag-image",
+				Value:  "",
+				Desc:   "The name of the docker image, defaults to app-name",
+				EnvVar: "ANKH_TAG_IMAGE",
+			})
+			repositoryArg := cmd.String(cli.StringOpt{
+				Name:   "r repository",
+				Value:  "",
+				Desc:   "The chart repository to use",
+				EnvVar: "ANKH_REPOSITORY",
+			})
 
 			cmd.Action = func() {
 				ctx.Chart = *starterChart
@@ -737,16 +2304,40 @@ func main() {
 		})
 
 		cmd.Command("ls", "List Helm charts and their versions", func(cmd *cli.Cmd) {
-			cmd.Spec = "[-n] [-r]"
-			numToShow := cmd.IntOpt("n num", 5, "Number of versions to show, sorted descending by creation date. Pass zero to see all versions.")
-			repositoryArg := cmd.StringOpt("r repository", "", "The chart repository to use")
+			cmd.Spec = "[-n] [-r | --all]"
+			numToShow := cmd.Int(cli.IntOpt{
+				Name:   "n num",
+				Value:  5,
+				Desc:   "Number of versions to show, sorted descending by creation date. Pass zero to see all versions.",
+				EnvVar: "ANKH_NUM",
+			})
+			repositoryArg := cmd.String(cli.StringOpt{
+				Name:   "r repository",
+				Value:  "",
+				Desc:   "The chart repository to use, by URL or by name from `helm.repositories`",
+				EnvVar: "ANKH_REPOSITORY",
+			})
+			all := cmd.Bool(cli.BoolOpt{
+				Name:   "all",
+				Value:  false,
+				Desc:   "List charts across every repository in `helm.repositories`",
+				EnvVar: "ANKH_ALL",
+			})
 
 			cmd.Action = func() {
-				repository := ctx.DetermineHelmRepository(repositoryArg)
-				helmOutput, err := helm.ListCharts(ctx, repository, *numToShow)
+				var helmOutput string
+				var err error
+				if *all {
+					helmOutput, err = helm.ListAllCharts(ctx, *numToShow)
+				} else {
+					var repository string
+					repository, err = ctx.DetermineHelmRepository(repositoryArg)
+					check(err)
+					helmOutput, err = helm.ListCharts(ctx, repository, *numToShow)
+				}
 				check(err)
 				if helmOutput != "" {
-					fmt.Printf(helmOutput)
+					util.Page(ctx, helmOutput)
 				}
 				os.Exit(0)
 			}
@@ -755,15 +2346,91 @@ func main() {
 		cmd.Command("versions", "List versions for a Helm chart", func(cmd *cli.Cmd) {
 			cmd.Spec = "[-r] CHART"
 			chart := cmd.StringArg("CHART", "", "The Helm chart to fetch versions for")
-			repositoryArg := cmd.StringOpt("r repository", "", "The chart repository to use")
+			repositoryArg := cmd.String(cli.StringOpt{
+				Name:   "r repository",
+				Value:  "",
+				Desc:   "The chart repository to use",
+				EnvVar: "ANKH_REPOSITORY",
+			})
 
 			cmd.Action = func() {
-				repository := ctx.DetermineHelmRepository(repositoryArg)
+				repository, err := ctx.DetermineHelmRepository(repositoryArg)
+				check(err)
+				if ctx.Output != "" {
+					out, err := helm.ListVersionsOutput(ctx, repository, *chart, false)
+					check(err)
+					fmt.Print(out)
+					os.Exit(0)
+				}
 				helmOutput, err := helm.ListVersions(ctx, repository, *chart, false)
 				check(err)
 				if helmOutput != "" {
-					fmt.Println(helmOutput)
+					versions := helm.FlagDeprecatedVersions(ctx, repository, *chart, strings.Split(helmOutput, "\n"))
+					fmt.Println(strings.Join(versions, "\n"))
+				}
+				os.Exit(0)
+			}
+		})
+
+		cmd.Command("deprecate", "Mark a Helm chart version as deprecated in the chart repository", func(cmd *cli.Cmd) {
+			cmd.Spec = "[-r] [--message] [--undo] CHART"
+			chart := cmd.StringArg("CHART", "", "The Helm chart version to deprecate, passed in the `CHART@VERSION` format.")
+			repositoryArg := cmd.String(cli.StringOpt{
+				Name:   "r repository",
+				Value:  "",
+				Desc:   "The chart repository to use",
+				EnvVar: "ANKH_REPOSITORY",
+			})
+			message := cmd.String(cli.StringOpt{
+				Name:   "m message",
+				Value:  "",
+				Desc:   "Why the version is deprecated, eg: which version to use instead",
+				EnvVar: "ANKH_MESSAGE",
+			})
+			undo := cmd.Bool(cli.BoolOpt{
+				Name:   "undo",
+				Value:  false,
+				Desc:   "Remove the deprecation instead",
+				EnvVar: "ANKH_UNDO",
+			})
+
+			cmd.Action = func() {
+				tokens := strings.Split(*chart, "@")
+				if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" {
+					log.Fatalf("Invalid chart '%v'. Must be in the `name@version` format", *chart)
 				}
+
+				check(ankhlib.CheckWritable(ctx, "change a chart's deprecation"))
+				repository, err := ctx.DetermineHelmRepository(repositoryArg)
+				check(err)
+				err = helm.Deprecate(ctx, repository, tokens[0], tokens[1], *message, *undo)
+				check(err)
+				os.Exit(0)
+			}
+		})
+
+		cmd.Command("docs", "Show the README and documented values of a Helm chart", func(cmd *cli.Cmd) {
+			cmd.Spec = "[-r] [--markdown] CHART"
+			chart := cmd.StringArg("CHART", "", "The Helm chart to show docs for, passed in the `CHART[@VERSION]` format.")
+			repositoryArg := cmd.String(cli.StringOpt{
+				Name:   "r repository",
+				Value:  "",
+				Desc:   "The chart repository to use",
+				EnvVar: "ANKH_REPOSITORY",
+			})
+			markdown := cmd.Bool(cli.BoolOpt{
+				Name:   "markdown",
+				Value:  false,
+				Desc:   "Format the README's markdown for the terminal",
+				EnvVar: "ANKH_MARKDOWN",
+			})
+
+			cmd.Action = func() {
+				repository, err := ctx.DetermineHelmRepository(repositoryArg)
+				check(err)
+				helmOutput, err := helm.Docs(ctx, repository, *chart, *markdown)
+				check(err)
+				fmt.Print(helmOutput)
 				os.Exit(0)
 			}
 		})
@@ -771,10 +2438,16 @@ func main() {
 		cmd.Command("inspect", "Inspect a Helm chart", func(cmd *cli.Cmd) {
 			cmd.Spec = "[-r] CHART"
 			chart := cmd.StringArg("CHART", "", "The Helm chart to inspect, passed in the `CHART[@VERSION]` format.")
-			repositoryArg := cmd.StringOpt("r repository", "", "The chart repository to use")
+			repositoryArg := cmd.String(cli.StringOpt{
+				Name:   "r repository",
+				Value:  "",
+				Desc:   "The chart repository to use",
+				EnvVar: "ANKH_REPOSITORY",
+			})
 
 			cmd.Action = func() {
-				repository := ctx.DetermineHelmRepository(repositoryArg)
+				repository, err := ctx.DetermineHelmRepository(repositoryArg)
+				check(err)
 				helmOutput, err := helm.Inspect(ctx, repository, *chart)
 				check(err)
 				if helmOutput != "" {
@@ -785,14 +2458,68 @@ func main() {
 		})
 
 		cmd.Command("publish", "Publish a Helm chart using files from the current directory", func(cmd *cli.Cmd) {
-			cmd.Spec = "[-r] [--version]"
-			repositoryArg := cmd.StringOpt("r repository", "", "The chart repository to use")
-			versionArg := cmd.StringOpt("version", "", "The chart version to publish. Overrides any version present in Chart.yaml")
+			cmd.Spec = "[-r] [--version] [--dry-run]"
+			repositoryArg := cmd.String(cli.StringOpt{
+				Name:   "r repository",
+				Value:  "",
+				Desc:   "The chart repository to use",
+				EnvVar: "ANKH_REPOSITORY",
+			})
+			versionArg := cmd.String(cli.StringOpt{
+				Name:   "version",
+				Value:  "",
+				Desc:   "The chart version to publish. Overrides any version present in Chart.yaml",
+				EnvVar: "ANKH_VERSION",
+			})
+			dryRun := cmd.Bool(cli.BoolOpt{
+				Name:   "dry-run",
+				Value:  false,
+				Desc:   "Package the chart and show where it would be published, along with its size and digest, without publishing it",
+				EnvVar: "ANKH_DRY_RUN",
+			})
+
+			cmd.Action = func() {
+				if !*dryRun {
+					check(ankhlib.CheckWritable(ctx, "publish a chart"))
+				}
+				repository, err := ctx.DetermineHelmRepository(repositoryArg)
+				check(err)
+				err = helm.Publish(ctx, repository, *versionArg, *dryRun)
+				check(err)
+				os.Exit(0)
+			}
+		})
+
+		cmd.Command("test", "Render a local chart for each case in its tests/ directory, and compare the output against golden files and assertions", func(cmd *cli.Cmd) {
+			cmd.Spec = "[-r] [--chart-path] [--update]"
+			repositoryArg := cmd.String(cli.StringOpt{
+				Name:   "r repository",
+				Value:  "",
+				Desc:   "The chart repository to use for the chart's dependencies",
+				EnvVar: "ANKH_REPOSITORY",
+			})
+			chartPath := cmd.String(cli.StringOpt{
+				Name:   "chart-path",
+				Value:  ".",
+				Desc:   "The local chart directory to test",
+				EnvVar: "ANKH_CHART_PATH",
+			})
+			update := cmd.Bool(cli.BoolOpt{
+				Name:   "update",
+				Value:  false,
+				Desc:   "Write the output of each case to its golden file, instead of comparing against it",
+				EnvVar: "ANKH_UPDATE",
+			})
 
 			cmd.Action = func() {
-				repository := ctx.DetermineHelmRepository(repositoryArg)
-				err := helm.Publish(ctx, repository, *versionArg)
+				repository, err := ctx.DetermineHelmRepository(repositoryArg)
 				check(err)
+				report, failed, err := helm.RunChartTests(ctx, repository, *chartPath, *update)
+				check(err)
+				fmt.Print(report)
+				if failed > 0 {
+					os.Exit(1)
+				}
 				os.Exit(0)
 			}
 		})
@@ -809,49 +2536,70 @@ func main() {
 		})
 	})
 
-	app.Command("config", "Manage Ankh configuration", func(cmd *cli.Cmd) {
+	app.Command("init", "Interactively create a starter Ankh config and a sample Ankh file", func(cmd *cli.Cmd) {
 		ctx.IgnoreContextAndEnv = true
 		ctx.IgnoreConfigErrors = true
+		ctx.SkipConfig = true
 
-		cmd.Command("init", "Initialize Ankh configuration", func(cmd *cli.Cmd) {
-			ctx.SkipConfig = true
+		cmd.Action = func() {
+			initAnkhConfig(ctx)
+			os.Exit(0)
+		}
+	})
 
-			cmd.Action = func() {
-				// Use the original, unmerged config. We want to explicitly avoid
-				// serializing the contents of any remote configs.
-				newAnkhConfig, err := config.GetAnkhConfig(ctx, ctx.AnkhConfigPath)
-				if err != nil {
-					newAnkhConfig = ankh.AnkhConfig{}
-				}
-
-				if len(newAnkhConfig.Contexts) == 0 {
-					newAnkhConfig.Contexts = map[string]ankh.Context{
-						"minikube": {
-							KubeContext:       "minikube",
-							EnvironmentClass:  "dev",
-							ResourceProfile:   "constrained",
-							Release:           "minikube",
-							HelmRepositoryURL: "https://kubernetes-charts.storage.googleapis.com",
-						},
-					}
-					ctx.Logger.Infof("Initializing `contexts` to a single sample context for kube-context `minikube`")
-				}
+	app.Command("local", "Manage a local Kubernetes cluster for development", func(cmd *cli.Cmd) {
+		ctx.IgnoreContextAndEnv = true
+		ctx.IgnoreConfigErrors = true
+		ctx.SkipConfig = true
+
+		cmd.Command("up", "Create or start a kind or minikube cluster, and add an Ankh context for it", func(cmd *cli.Cmd) {
+			cmd.Spec = "[--provider] [--name] [--registry] [--chart-repo]"
+			provider := cmd.String(cli.StringOpt{
+				Name:   "provider",
+				Value:  "",
+				Desc:   "The local cluster provider, one of `kind` or `minikube`. Defaults to whichever is installed, preferring kind.",
+				EnvVar: "ANKH_LOCAL_PROVIDER",
+			})
+			name := cmd.String(cli.StringOpt{
+				Name:   "name",
+				Value:  "",
+				Desc:   "The cluster name (minikube profile). Defaults to `ankh` for kind and `minikube` for minikube.",
+				EnvVar: "ANKH_LOCAL_NAME",
+			})
+			registry := cmd.Bool(cli.BoolOpt{
+				Name:   "registry",
+				Value:  false,
+				Desc:   "Also run a local docker registry at " + localRegistryAddress + ", and use it as `docker.registry`",
+				EnvVar: "ANKH_LOCAL_REGISTRY",
+			})
+			chartRepo := cmd.Bool(cli.BoolOpt{
+				Name:   "chart-repo",
+				Value:  false,
+				Desc:   "Also run a local ChartMuseum chart repository at " + localChartRepoURL + ", and use it as `helm.repository`",
+				EnvVar: "ANKH_LOCAL_CHART_REPO",
+			})
 
-				if len(newAnkhConfig.Environments) == 0 {
-					newAnkhConfig.Environments = map[string]ankh.Environment{
-						"minikube": {
-							Contexts: []string{"minikube"},
-						},
-					}
-					ctx.Logger.Infof("Initializing `environments` to a single sample envionment with context `minikube`'")
-				}
+			cmd.Action = func() {
+				runLocalUp(ctx, localOpts{
+					Provider:  *provider,
+					Name:      *name,
+					Registry:  *registry,
+					ChartRepo: *chartRepo,
+				})
+				os.Exit(0)
+			}
+		})
+	})
 
-				out, err := yaml.Marshal(newAnkhConfig)
-				check(err)
+	app.Command("config", "Manage Ankh configuration", func(cmd *cli.Cmd) {
+		ctx.IgnoreContextAndEnv = true
+		ctx.IgnoreConfigErrors = true
 
-				err = ioutil.WriteFile(ctx.AnkhConfigPath, out, 0644)
-				check(err)
+		cmd.Command("init", "Initialize Ankh configuration", func(cmd *cli.Cmd) {
+			ctx.SkipConfig = true
 
+			cmd.Action = func() {
+				initAnkhConfig(ctx)
 				os.Exit(0)
 			}
 		})
@@ -947,6 +2695,31 @@ func main() {
 			}
 		})
 
+		cmd.Command("refresh", "Re-fetch the remote includes of an Ankh file, ignoring their cache", func(cmd *cli.Cmd) {
+			ctx.SkipConfig = true
+
+			cmd.Spec = "[--ankhfile]"
+			ankhFilePath := cmd.String(cli.StringOpt{
+				Name:   "ankhfile",
+				Value:  "ankh.yaml",
+				Desc:   "Path to an Ankh file whose includes are fetched again",
+				EnvVar: "ANKH_ANKHFILE",
+			})
+
+			cmd.Action = func() {
+				check(ankh.ClearIncludeCache())
+				ctx.Logger.Infof("Cleared the include cache in %v", ankh.IncludeCacheDir)
+
+				if _, err := os.Stat(*ankhFilePath); os.IsNotExist(err) && *ankhFilePath == "ankh.yaml" {
+					os.Exit(0)
+				}
+				ankhFile, err := ankh.ParseAnkhFile(*ankhFilePath)
+				check(err)
+				ctx.Logger.Infof("Fetched %v include(s) of %v", len(ankhFile.Include), *ankhFilePath)
+				os.Exit(0)
+			}
+		})
+
 		cmd.Command("view", "View merged Ankh configuration", func(cmd *cli.Cmd) {
 			cmd.Action = func() {
 				out, err := yaml.Marshal(ctx.AnkhConfig)
@@ -959,39 +2732,521 @@ func main() {
 
 		cmd.Command("get-contexts", "Get available contexts", func(cmd *cli.Cmd) {
 			cmd.Action = func() {
+				if ctx.Output != "" {
+					out, err := util.FormatOutput(ctx.Output, getContextListings(&ctx.AnkhConfig))
+					check(err)
+					fmt.Print(out)
+					os.Exit(0)
+				}
 				s := getContextTable(&ctx.AnkhConfig)
-				fmt.Printf(strings.Join(s, "\n"))
+				fmt.Print(strings.Join(s, "\n"))
+				os.Exit(0)
+			}
+		})
+
+		cmd.Command("check-auth", "Check that kubectl can authenticate to each cluster of a context or environment, by running `kubectl auth can-i` in verbose mode", func(cmd *cli.Cmd) {
+			cmd.Spec = "[--verb] [--resource]"
+			verb := cmd.String(cli.StringOpt{
+				Name:   "verb",
+				Value:  "get",
+				Desc:   "The verb to ask kubectl about",
+				EnvVar: "ANKH_VERB",
+			})
+			resource := cmd.String(cli.StringOpt{
+				Name:   "resource",
+				Value:  "pods",
+				Desc:   "The resource to ask kubectl about, eg: `deployments.apps`",
+				EnvVar: "ANKH_RESOURCE",
+			})
+
+			cmd.Action = func() {
+				contexts, err := authContexts(ctx)
+				check(err)
+
+				// Invalid `kube-ca-data` or `kube-exec` fail the check.
+				ctx.IgnoreContextAndEnv = false
+				if failures := checkAuth(ctx, contexts, *verb, *resource); failures > 0 {
+					log.Errorf("kubectl could not authenticate to %v contexts or clusters", failures)
+					os.Exit(1)
+				}
 				os.Exit(0)
 			}
 		})
 
 		cmd.Command("get-environments", "Get available environments", func(cmd *cli.Cmd) {
 			cmd.Action = func() {
+				if ctx.Output != "" {
+					out, err := util.FormatOutput(ctx.Output, getEnvironmentListings(&ctx.AnkhConfig))
+					check(err)
+					fmt.Print(out)
+					os.Exit(0)
+				}
 				s := getEnvironmentTable(&ctx.AnkhConfig)
-				fmt.Printf(strings.Join(s, "\n"))
+				fmt.Print(strings.Join(s, "\n"))
 				os.Exit(0)
 			}
 		})
 	})
 
-	app.Command("version", "Show version info", func(cmd *cli.Cmd) {
+	app.Command("dev", "Apply a local chart and stream its logs, then do it again whenever files change", func(cmd *cli.Cmd) {
+		cmd.Spec = "--chart-path [--watch...] [--build] [-c] [--debounce] [--any-context]"
+		chartPath := cmd.String(cli.StringOpt{
+			Name:   "chart-path",
+			Value:  "",
+			Desc:   "The local chart directory to develop. Always watched for changes.",
+			EnvVar: "ANKH_CHART_PATH",
+		})
+		watch := cmd.Strings(cli.StringsOpt{
+			Name:   "watch",
+			Value:  []string{},
+			Desc:   "Additional files or directories to watch for changes, eg: application source",
+			EnvVar: "ANKH_WATCH",
+		})
+		build := cmd.String(cli.StringOpt{
+			Name:   "build",
+			Value:  "",
+			Desc:   "A shell command to run before each apply, eg: to build and push an image. The apply is skipped if it fails.",
+			EnvVar: "ANKH_BUILD",
+		})
+		container := cmd.String(cli.StringOpt{
+			Name:   "c container",
+			Value:  "",
+			Desc:   "The container to stream logs for",
+			EnvVar: "ANKH_CONTAINER",
+		})
+		debounce := cmd.String(cli.StringOpt{
+			Name:   "debounce",
+			Value:  "1s",
+			Desc:   "How long files must stay unchanged before redeploying",
+			EnvVar: "ANKH_DEBOUNCE",
+		})
+		anyContext := cmd.Bool(cli.BoolOpt{
+			Name:   "any-context",
+			Value:  false,
+			Desc:   "Apply to contexts that are not dev contexts, ie: without `environment-class: dev`",
+			EnvVar: "ANKH_ANY_CONTEXT",
+		})
+
+		cmd.Action = func() {
+			check(ctx.CheckWritable("apply charts with `ankh dev`"))
+			if !*anyContext {
+				check(checkDevContexts(ctx))
+			}
+			debounceDuration, err := time.ParseDuration(*debounce)
+			check(err)
+
+			if ctx.Environment != "" {
+				log.Warnf("Developing against every context in environment \"%v\". Consider a single dev context with `--context` instead.", ctx.Environment)
+			}
+
+			runDev(ctx, devOpts{
+				ChartPath:    *chartPath,
+				WatchPaths:   *watch,
+				BuildCommand: *build,
+				Container:    *container,
+				Debounce:     debounceDuration,
+				PollInterval: 500 * time.Millisecond,
+			})
+		}
+	})
+
+	app.Command("replay", "Repeat a recorded apply, deploy or rollback with the same chart versions and tags", func(cmd *cli.Cmd) {
+		// The context or environment comes from the recorded run.
+		ctx.IgnoreContextAndEnv = true
+
+		cmd.Spec = "[--dry-run] RUN_ID"
+		runID := cmd.StringArg("RUN_ID", "", "The ID of the run to repeat, as logged by the run. This is the name of its directory under the data dir.")
+		dryRun := cmd.Bool(cli.BoolOpt{
+			Name:   "dry-run",
+			Value:  false,
+			Desc:   "Perform a dry-run and don't actually change anything",
+			EnvVar: "ANKH_DRY_RUN",
+		})
+
+		cmd.Action = func() {
+			setupReplay(ctx, *runID, *dryRun)
+			execute(ctx)
+			os.Exit(0)
+		}
+	})
+
+	app.Command("promote", "Apply the chart versions and tags running in one context or environment to another", func(cmd *cli.Cmd) {
+		// The context or environment come from --from and --to.
+		ctx.IgnoreContextAndEnv = true
+
+		cmd.Spec = "--from --to [--ankhfile] [--chart...] [--dry-run] [--wait] [--timeout]"
+		from := cmd.String(cli.StringOpt{
+			Name:   "from",
+			Value:  "",
+			Desc:   "The context or environment to read chart versions and tags from. Environments are read from their first context.",
+			EnvVar: "ANKH_FROM",
+		})
+		to := cmd.String(cli.StringOpt{
+			Name:   "to",
+			Value:  "",
+			Desc:   "The context or environment to apply the charts to",
+			EnvVar: "ANKH_TO",
+		})
+		ankhFilePath := cmd.String(cli.StringOpt{
+			Name:   "ankhfile",
+			Value:  "",
+			Desc:   "Path to an Ankh file for promoting multiple charts, or \"-\" to read it from stdin",
+			EnvVar: "ANKH_ANKHFILE",
+		})
+		chart := cmd.Strings(cli.StringsOpt{
+			Name:   "chart",
+			Value:  []string{},
+			Desc:   "The chart to promote. May be repeated, or given as a comma separated list, to promote a subset of the charts in an Ankh file",
+			EnvVar: "ANKH_CHART",
+		})
+		dryRun := cmd.Bool(cli.BoolOpt{
+			Name:   "dry-run",
+			Value:  false,
+			Desc:   "Perform a dry-run and don't actually apply anything",
+			EnvVar: "ANKH_DRY_RUN",
+		})
+		wait := cmd.Bool(cli.BoolOpt{
+			Name:   "wait",
+			Value:  false,
+			Desc:   "After applying, wait for every Deployment, StatefulSet and DaemonSet to finish rolling out, and exit non-zero if any rollout fails",
+			EnvVar: "ANKH_WAIT",
+		})
+		timeout := cmd.String(cli.StringOpt{
+			Name:   "timeout",
+			Value:  "",
+			Desc:   "How long to wait for each rollout with --wait, eg: 10m. Defaults to 5m",
+			EnvVar: "ANKH_TIMEOUT",
+		})
+
+		cmd.Action = func() {
+			ctx.AnkhFilePath = *ankhFilePath
+			setChartArgs(ctx, *chart)
+			ctx.DryRun = *dryRun
+			ctx.Wait = *wait
+			ctx.WaitTimeout = *timeout
+			setupPromote(ctx, *from, *to)
+			execute(ctx)
+			os.Exit(0)
+		}
+	})
+
+	app.Command("pipeline", "Promote a chart through the environments of the `pipeline` in an Ankh file", func(cmd *cli.Cmd) {
+		// Each stage of the pipeline names the environment it operates on.
+		ctx.IgnoreContextAndEnv = true
+
+		cmd.Command("run", "Apply a chart to each stage of the pipeline in order, soaking and checking its health after each, and asking for approval where needed", func(cmd *cli.Cmd) {
+			cmd.Spec = "--chart [--ankhfile] [--tag] [--dry-run] [--timeout] [--slack]"
+			chart := cmd.String(cli.StringOpt{
+				Name:   "chart",
+				Value:  "",
+				Desc:   "The chart to promote, eg: `api` or `api@1.2.3`",
+				EnvVar: "ANKH_CHART",
+			})
+			ankhFilePath := cmd.String(cli.StringOpt{
+				Name:   "ankhfile",
+				Value:  "ankh.yaml",
+				Desc:   "Path to the Ankh file with the `pipeline`",
+				EnvVar: "ANKH_ANKHFILE",
+			})
+			tag := cmd.String(cli.StringOpt{
+				Name:   "t tag",
+				Value:  "",
+				Desc:   "The tag value to use in every stage. Takes precedence over the global `--tag`",
+				EnvVar: "ANKH_TAG",
+			})
+			dryRun := cmd.Bool(cli.BoolOpt{
+				Name:   "dry-run",
+				Value:  false,
+				Desc:   "Perform a dry-run of each stage, without approvals or soaking",
+				EnvVar: "ANKH_DRY_RUN",
+			})
+			timeout := cmd.String(cli.StringOpt{
+				Name:   "timeout",
+				Value:  "",
+				Desc:   "How long to wait for each rollout, eg: 10m. Defaults to 5m",
+				EnvVar: "ANKH_TIMEOUT",
+			})
+			slackChannel := cmd.String(cli.StringOpt{
+				Name:   "s slack",
+				Value:  "",
+				Desc:   "The slack channel to ask for approvals in. Overrides the `slack` of the pipeline",
+				EnvVar: "ANKH_SLACK",
+			})
+
+			cmd.Action = func() {
+				if !*dryRun {
+					check(ctx.CheckWritable("run a pipeline"))
+				}
+				ankhFile, err := ankh.ParseAnkhFile(*ankhFilePath)
+				check(err)
+				if ankhFile.Pipeline == nil {
+					log.Fatalf("Ankh file %v has no `pipeline`", *ankhFilePath)
+				}
+				if *tag != "" {
+					ctx.Tag = tag
+				}
+
+				opts := pipelineOpts{
+					AnkhFilePath: *ankhFilePath,
+					Chart:        *chart,
+					DryRun:       *dryRun,
+					Timeout:      *timeout,
+					Slack:        *slackChannel,
+				}
+				name := strings.Split(*chart, "@")[0]
+				for i := range ankhFile.Charts {
+					if ankhFile.Charts[i].InstanceName() == name {
+						opts.Entry = &ankhFile.Charts[i]
+						break
+					}
+				}
+
+				if err := runPipeline(ctx, ankhFile.Pipeline, opts); err != nil {
+					log.Errorf("%v", err)
+					os.Exit(1)
+				}
+				log.Infof("Pipeline finished: %v is running in every stage", pipelineTarget(ctx, opts))
+				os.Exit(0)
+			}
+		})
+	})
+
+	app.Command("releases", "List the charts applied, deployed and rolled back from this machine, newest first", func(cmd *cli.Cmd) {
+		// Releases of every context are listed, and undone in the context they were made in.
+		ctx.IgnoreContextAndEnv = true
+
+		cmd.Spec = "[--chart]"
+		chart := cmd.String(cli.StringOpt{
+			Name:   "chart",
+			Value:  "",
+			Desc:   "Only list releases of this chart, by name or alias",
+			EnvVar: "ANKH_CHART",
+		})
+
+		cmd.Action = func() {
+			entries, err := ledger.Load(path.Dir(ctx.DataDir))
+			check(err)
+			if *chart != "" {
+				entries = ledger.ForChart(entries, *chart)
+			}
+			if ctx.Output != "" {
+				out, err := util.FormatOutput(ctx.Output, entries)
+				check(err)
+				fmt.Print(out)
+				os.Exit(0)
+			}
+			fmt.Print(ledger.Format(entries))
+			os.Exit(0)
+		}
+
+		cmd.Command("undo", "Apply the chart version and tag that a release replaced, in the same context and namespace", func(cmd *cli.Cmd) {
+			cmd.Spec = "[--dry-run] ID"
+			id := cmd.StringArg("ID", "", "The ID of the release to undo, as listed by `ankh releases`")
+			dryRun := cmd.Bool(cli.BoolOpt{
+				Name:   "dry-run",
+				Value:  false,
+				Desc:   "Perform a dry-run and don't actually apply anything",
+				EnvVar: "ANKH_DRY_RUN",
+			})
+
+			cmd.Action = func() {
+				ctx.DryRun = *dryRun
+				setupUndo(ctx, *id)
+				execute(ctx)
+				os.Exit(0)
+			}
+		})
+	})
+
+	app.Command("stats", "Summarize the history of runs recorded in the local data dir", func(cmd *cli.Cmd) {
+		ctx.IgnoreContextAndEnv = true
+		ctx.IgnoreConfigErrors = true
+		ctx.SkipConfig = true
+
+		cmd.Action = func() {
+			summaries, err := stats.LoadRunSummaries(path.Dir(ctx.DataDir))
+			check(err)
+			fmt.Print(stats.Report(summaries))
+			os.Exit(0)
+		}
+	})
+
+	app.Command("debug", "Inspect what previous runs did, from the local data dir", func(cmd *cli.Cmd) {
+		ctx.IgnoreContextAndEnv = true
+		ctx.IgnoreConfigErrors = true
+		ctx.SkipConfig = true
+
+		cmd.Command("last", "Show each stage of the last run, with the input and output saved for it", func(cmd *cli.Cmd) {
+			cmd.Spec = "[--full]"
+			full := cmd.Bool(cli.BoolOpt{
+				Name:   "full",
+				Value:  false,
+				Desc:   "Show the contents of each stage's input and output, not only their paths",
+				EnvVar: "ANKH_FULL",
+			})
+
+			cmd.Action = func() {
+				runDir, err := debug.LastRun(path.Dir(ctx.DataDir), ctx.DataDir)
+				check(err)
+				records, err := debug.LoadStages(runDir)
+				check(err)
+				fmt.Print(debug.Report(runDir, records, *full))
+				os.Exit(0)
+			}
+		})
+	})
+
+	app.Command("self-update", "Update Ankh to the latest release", func(cmd *cli.Cmd) {
 		ctx.IgnoreContextAndEnv = true
 		ctx.IgnoreConfigErrors = true
 
+		cmd.Spec = "[--channel] [--check]"
+		channel := cmd.String(cli.StringOpt{
+			Name:   "channel",
+			Value:  "",
+			Desc:   "The release channel to update from: \"stable\" or \"beta\". Defaults to `update.channel` from the Ankh config, then \"stable\".",
+			EnvVar: "ANKH_CHANNEL",
+		})
+		checkOnly := cmd.Bool(cli.BoolOpt{
+			Name:   "check",
+			Value:  false,
+			Desc:   "Only check whether a newer release is available",
+			EnvVar: "ANKH_CHECK",
+		})
+
 		cmd.Action = func() {
-			ctx.Logger.Infof("Ankh version info:")
-			fmt.Println(AnkhBuildVersion)
+			err := update.SelfUpdate(ctx, AnkhBuildVersion, *channel, *checkOnly)
+			check(err)
+			os.Exit(0)
+		}
+	})
 
-			ctx.Logger.Infof("`%v version --client --short` output:", ctx.AnkhConfig.Helm.Command)
-			ver, err := helm.Version(ctx)
+	app.Command("serve", "Serve template, diff, apply, rollback and releases over an HTTP API, eg: for CI systems", func(cmd *cli.Cmd) {
+		// Each request names the context it operates on.
+		ctx.IgnoreContextAndEnv = true
+
+		cmd.Spec = "[--listen] [--tls-cert --tls-key] [--tokens-file] [--audit-log]"
+		listen := cmd.String(cli.StringOpt{
+			Name:   "listen",
+			Value:  "127.0.0.1:8080",
+			Desc:   "The address to listen on, eg: `:8443` for every interface, with `--tls-cert` and `--tls-key`",
+			EnvVar: "ANKH_LISTEN",
+		})
+		tlsCert := cmd.String(cli.StringOpt{
+			Name:   "tls-cert",
+			Value:  "",
+			Desc:   "A PEM file with the certificate to serve HTTPS with, followed by any intermediate certificates",
+			EnvVar: "ANKH_TLS_CERT",
+		})
+		tlsKey := cmd.String(cli.StringOpt{
+			Name:   "tls-key",
+			Value:  "",
+			Desc:   "A PEM file with the private key of `--tls-cert`",
+			EnvVar: "ANKH_TLS_KEY",
+		})
+		tokensFile := cmd.String(cli.StringOpt{
+			Name:   "tokens-file",
+			Value:  "",
+			Desc:   "A YAML file mapping the name of each user of the API to their bearer token, eg: `ci: <token>`. Required",
+			EnvVar: "ANKH_TOKENS_FILE",
+		})
+		auditLog := cmd.String(cli.StringOpt{
+			Name:   "audit-log",
+			Value:  "",
+			Desc:   "The file that every request is recorded in, one JSON object per line. Defaults to `serve-audit.jsonl` in the data dir",
+			EnvVar: "ANKH_AUDIT_LOG",
+		})
+
+		cmd.Action = func() {
+			if *tokensFile == "" {
+				ctx.Logger.Fatalf("Missing `--tokens-file`. Every request to the API needs a bearer token")
+			}
+			if (*tlsCert == "") != (*tlsKey == "") {
+				ctx.Logger.Fatalf("`--tls-cert` and `--tls-key` must be given together")
+			}
+			tokens, err := server.LoadTokens(*tokensFile)
+			check(err)
+
+			baseDataDir := path.Dir(ctx.DataDir)
+			if *auditLog == "" {
+				*auditLog = path.Join(baseDataDir, "serve-audit.jsonl")
+			}
+			check(os.MkdirAll(path.Dir(*auditLog), 0755))
+			audit, err := os.OpenFile(*auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 			check(err)
-			fmt.Print(ver)
 
-			ctx.Logger.Infof("`%v version --client` output:", ctx.AnkhConfig.Kubectl.Command)
-			ver, err = kubectl.Version(ctx)
+			srv := server.New(server.Options{
+				ConfigPath:         ctx.AnkhConfigPath,
+				KubeConfigPath:     ctx.KubeConfigPath,
+				BaseDataDir:        baseDataDir,
+				Tokens:             tokens,
+				AuditLog:           audit,
+				Logger:             log,
+				ReadOnly:           ctx.ReadOnly,
+				IgnoreConfigErrors: ctx.IgnoreConfigErrors,
+			})
+
+			// Requests in flight finish before exiting.
+			ctx.CatchSignals = true
+			stop := make(chan os.Signal, 1)
+			signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+			if *tlsCert != "" {
+				ctx.Logger.Infof("Serving the Ankh API over HTTPS on %v, recording requests in %v", *listen, *auditLog)
+				err = srv.ListenAndServeTLS(*listen, *tlsCert, *tlsKey, stop)
+			} else {
+				if !server.IsLoopback(*listen) {
+					ctx.Logger.Warnf("Serving the Ankh API without TLS on %v, so bearer tokens are sent in plain text. "+
+						"Use `--tls-cert` and `--tls-key`, or listen on 127.0.0.1 behind a proxy that terminates TLS", *listen)
+				}
+				ctx.Logger.Infof("Serving the Ankh API on %v, recording requests in %v", *listen, *auditLog)
+				err = srv.ListenAndServe(*listen, stop)
+			}
+
+			// Deferred calls don't run on exit, so the audit log is closed first.
+			audit.Close()
 			check(err)
-			fmt.Print(ver)
+			os.Exit(0)
+		}
+	})
 
+	app.Command("completion", "Output a completion script for bash, zsh or fish, eg: `source <(ankh completion bash)`", func(cmd *cli.Cmd) {
+		ctx.IgnoreContextAndEnv = true
+		ctx.IgnoreConfigErrors = true
+		ctx.SkipConfig = true
+
+		cmd.Spec = "SHELL"
+		shell := cmd.StringArg("SHELL", "", "The shell to complete in: `bash`, `zsh` or `fish`")
+
+		cmd.Action = func() {
+			script, err := completionScript(*shell)
+			check(err)
+			fmt.Print(script)
+			os.Exit(0)
+		}
+	})
+
+	app.Command("version", "Show version info for Ankh and the tools it uses", func(cmd *cli.Cmd) {
+		ctx.IgnoreContextAndEnv = true
+		ctx.IgnoreConfigErrors = true
+		ctx.SkipConfig = true
+
+		cmd.Spec = "[-o]"
+		output := cmd.String(cli.StringOpt{
+			Name:   "o output",
+			Value:  "",
+			Desc:   "Output format. Use `json` or `yaml` for machine readable output.",
+			EnvVar: "ANKH_OUTPUT",
+		})
+
+		cmd.Action = func() {
+			format := *output
+			if format == "" {
+				format = ctx.Output
+			}
+			out, err := formatVersionInfo(getVersionInfo(ctx), format)
+			check(err)
+			fmt.Print(out)
 			os.Exit(0)
 		}
 	})
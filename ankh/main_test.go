@@ -1,8 +1,24 @@
 package main
 
 import (
+	"os"
 	"testing"
 )
 
 // TODO: write tests
 func TestStub(t *testing.T) {}
+
+func TestSetFromEnv(t *testing.T) {
+	defer os.Unsetenv("ANKH_TEST_OPTION")
+
+	os.Setenv("ANKH_TEST_OPTION", "")
+	if setFromEnv("ANKH_TEST_OPTION") {
+		t.Logf("expected an empty variable to count as unset")
+		t.Fail()
+	}
+	os.Setenv("ANKH_TEST_OPTION", "myteam")
+	if !setFromEnv("ANKH_TEST_OPTION") {
+		t.Logf("expected a non-empty variable to count as set")
+		t.Fail()
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/kubectl"
+	"github.com/appnexus/ankh/slack"
+	"github.com/appnexus/ankh/util"
+)
+
+// How often the charts are checked for failing containers while a stage soaks.
+var pipelineCheckInterval = time.Minute
+
+type pipelineOpts struct {
+	AnkhFilePath string
+	// The chart to promote, as given with `--chart`
+	Chart string
+	// The chart's entry in the Ankh file, if it has one
+	Entry   *ankh.Chart
+	DryRun  bool
+	Timeout string
+	// Overrides the Slack channel of the pipeline
+	Slack string
+}
+
+// Returns the global arguments for a separate ankh process that operates on
+// `environment`, with the same global options as this one, and no prompts.
+func pipelineGlobalArgs(ctx *ankh.ExecutionContext, environment string) []string {
+	args := []string{}
+	global := devGlobalArgs(ctx)
+	for i := 0; i < len(global); i++ {
+		switch global[i] {
+		case "--context", "--environment", "--context-selector":
+			i++
+		case "--no-prompt":
+		default:
+			args = append(args, global[i])
+		}
+	}
+
+	// Approvals are asked for by the pipeline itself, before each stage.
+	return append(args, "--environment", environment, "--no-prompt")
+}
+
+// Returns the arguments to apply the chart of a pipeline to `environment`,
+// waiting for its rollouts to finish.
+func pipelineApplyArgs(ctx *ankh.ExecutionContext, environment string, opts pipelineOpts) []string {
+	args := pipelineGlobalArgs(ctx, environment)
+	if opts.AnkhFilePath != "" {
+		args = append(args, "apply", "--ankhfile", opts.AnkhFilePath, "--chart", opts.Chart)
+	} else {
+		args = append(args, "apply", "--chart", opts.Chart)
+	}
+	if opts.DryRun {
+		return append(args, "--dry-run")
+	}
+	args = append(args, "--wait")
+	if opts.Timeout != "" {
+		args = append(args, "--timeout", opts.Timeout)
+	}
+	return args
+}
+
+// Returns the arguments to get the pods of the chart of a pipeline in
+// `environment` as JSON, using the version or path from the Ankh file.
+func pipelineHealthArgs(ctx *ankh.ExecutionContext, environment string, opts pipelineOpts) []string {
+	args := append(pipelineGlobalArgs(ctx, environment), "pods")
+	switch {
+	case opts.Entry != nil && opts.Entry.Path != "":
+		chartPath := opts.Entry.Path
+		if !filepath.IsAbs(chartPath) && opts.AnkhFilePath != "" {
+			chartPath = filepath.Join(filepath.Dir(opts.AnkhFilePath), chartPath)
+		}
+		args = append(args, "--chart-path", chartPath)
+	case opts.Entry != nil && opts.Entry.Version != "" && !strings.Contains(opts.Chart, "@"):
+		args = append(args, "--chart", opts.Chart+"@"+opts.Entry.Version)
+	default:
+		args = append(args, "--chart", opts.Chart)
+	}
+	return append(args, "--", "-o", "json")
+}
+
+// Checks the pods of the chart in `environment` for failing containers.
+func checkPipelineHealth(ctx *ankh.ExecutionContext, environment string, opts pipelineOpts) error {
+	cmd := exec.Command(util.AnkhExecutable(), pipelineHealthArgs(ctx, environment, opts)...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("Unable to get pods: %v", err)
+	}
+
+	failing, err := kubectl.FailingContainers(string(out))
+	if err != nil {
+		return err
+	}
+	if len(failing) > 0 {
+		return fmt.Errorf("%v failing containers:\n  %v", len(failing), strings.Join(failing, "\n  "))
+	}
+	return nil
+}
+
+// Checks the chart in `environment` right away, and then every
+// `pipelineCheckInterval` until the stage has soaked for `SoakMinutes`.
+func soakPipelineStage(ctx *ankh.ExecutionContext, stage ankh.PipelineStage, opts pipelineOpts) error {
+	deadline := time.Now().Add(time.Duration(stage.SoakMinutes) * time.Minute)
+	if stage.SoakMinutes > 0 {
+		ctx.Logger.Infof("Soaking in environment \"%v\" for %v minutes...", stage.Environment, stage.SoakMinutes)
+	}
+	for {
+		if err := checkPipelineHealth(ctx, stage.Environment, opts); err != nil {
+			return err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil
+		}
+		if remaining > pipelineCheckInterval {
+			remaining = pipelineCheckInterval
+		}
+		time.Sleep(remaining)
+	}
+}
+
+// Asks for approval to run the stage at `index`, in Slack if the pipeline has
+// a channel, and then with a prompt.
+func approvePipelineStage(ctx *ankh.ExecutionContext, pipeline *ankh.Pipeline, index int, opts pipelineOpts) error {
+	stage := pipeline.Stages[index]
+	message := fmt.Sprintf("%v is ready to be promoted to environment \"%v\"", pipelineTarget(ctx, opts), stage.Environment)
+	if index > 0 {
+		message = fmt.Sprintf("%v passed environment \"%v\", and is ready to be promoted to environment \"%v\"",
+			pipelineTarget(ctx, opts), pipeline.Stages[index-1].Environment, stage.Environment)
+	}
+
+	channel := pipeline.Slack
+	if opts.Slack != "" {
+		channel = opts.Slack
+	}
+	if channel != "" {
+		text := fmt.Sprintf("%v is waiting for approval: %v", ctx.EffectiveDeployer(), message)
+		if err := slack.PostMessage(ctx, channel, "Pipeline approval needed", text); err != nil {
+			ctx.Logger.Warnf("Unable to ask for approval in slack channel %v: %v", channel, err)
+		}
+	}
+
+	if ctx.NoPrompt {
+		return fmt.Errorf("Stage \"%v\" needs approval, which cannot be given with --no-prompt", stage.Environment)
+	}
+	selection, err := util.PromptForConfirmation([]string{"Promote", "Stop"},
+		fmt.Sprintf("%v. Select Promote to continue, or Stop to end the pipeline.", message))
+	if err != nil {
+		return err
+	}
+	if selection != "Promote" {
+		return fmt.Errorf("Stopped before stage \"%v\"", stage.Environment)
+	}
+	return nil
+}
+
+// Describes the chart a pipeline promotes, eg: `api (tag 20240112)`.
+func pipelineTarget(ctx *ankh.ExecutionContext, opts pipelineOpts) string {
+	if ctx.Tag != nil {
+		return fmt.Sprintf("%v (tag %v)", opts.Chart, *ctx.Tag)
+	}
+	return opts.Chart
+}
+
+// Runs each stage of `pipeline` in order: asks for approval if the stage needs
+// it, applies the chart to the stage's environment with a separate ankh
+// process, and then soaks, checking for failing containers. Stops at the first
+// stage that is not approved, fails to apply, or fails a health check.
+func runPipeline(ctx *ankh.ExecutionContext, pipeline *ankh.Pipeline, opts pipelineOpts) error {
+	for _, stage := range pipeline.Stages {
+		if _, ok := ctx.AnkhConfig.Environments[stage.Environment]; !ok {
+			return fmt.Errorf("Pipeline stage \"%v\" is not an environment in the current config", stage.Environment)
+		}
+	}
+
+	for i, stage := range pipeline.Stages {
+		if pipeline.NeedsApproval(i) && !opts.DryRun {
+			if err := approvePipelineStage(ctx, pipeline, i, opts); err != nil {
+				return err
+			}
+		}
+
+		ctx.Logger.Infof("Stage %v of %v: applying %v to environment \"%v\"", i+1, len(pipeline.Stages), pipelineTarget(ctx, opts), stage.Environment)
+		cmd := exec.Command(util.AnkhExecutable(), pipelineApplyArgs(ctx, stage.Environment, opts)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("Stage \"%v\" failed to apply: %v", stage.Environment, err)
+		}
+		if opts.DryRun {
+			continue
+		}
+
+		if err := soakPipelineStage(ctx, stage, opts); err != nil {
+			return fmt.Errorf("Stage \"%v\" is unhealthy: %v\nRun `ankh -e %v rollback --chart %v` to roll it back",
+				stage.Environment, err, stage.Environment, opts.Chart)
+		}
+		ctx.Logger.Infof("Stage %v of %v: environment \"%v\" is healthy", i+1, len(pipeline.Stages), stage.Environment)
+	}
+	return nil
+}
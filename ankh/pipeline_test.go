@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/context"
+)
+
+func TestPipelineArgs(t *testing.T) {
+	tag := "abc123"
+	ctx := &ankh.ExecutionContext{
+		AnkhConfigPath: "/config",
+		KubeConfigPath: "/kubeconfig",
+		DataDir:        "/data/run",
+		Context:        "minikube",
+		Tag:            &tag,
+		NoPrompt:       true,
+	}
+
+	opts := pipelineOpts{AnkhFilePath: "/src/ankh.yaml", Chart: "api", Timeout: "10m"}
+	args := pipelineApplyArgs(ctx, "staging", opts)
+	expected := "--ankhconfig /config --kubeconfig /kubeconfig --datadir /data --tag abc123 " +
+		"--environment staging --no-prompt apply --ankhfile /src/ankh.yaml --chart api --wait --timeout 10m"
+	if strings.Join(args, " ") != expected {
+		t.Logf("expected `%v` but got `%v`", expected, strings.Join(args, " "))
+		t.Fail()
+	}
+
+	opts.DryRun = true
+	args = pipelineApplyArgs(ctx, "staging", opts)
+	if !strings.HasSuffix(strings.Join(args, " "), "apply --ankhfile /src/ankh.yaml --chart api --dry-run") {
+		t.Logf("expected a dry-run apply but got `%v`", strings.Join(args, " "))
+		t.Fail()
+	}
+
+	for _, test := range []struct {
+		entry    *ankh.Chart
+		expected string
+	}{
+		{nil, "pods --chart api -- -o json"},
+		{&ankh.Chart{Name: "api", Version: "1.2.3"}, "pods --chart api@1.2.3 -- -o json"},
+		{&ankh.Chart{Name: "api", Path: "charts/api"}, "pods --chart-path /src/charts/api -- -o json"},
+	} {
+		opts.Entry = test.entry
+		args := strings.Join(pipelineHealthArgs(ctx, "production", opts), " ")
+		if !strings.HasSuffix(args, "--environment production --no-prompt "+test.expected) {
+			t.Logf("expected `%v` but got `%v`", test.expected, args)
+			t.Fail()
+		}
+	}
+}
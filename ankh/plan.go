@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/helm"
+	ankhlib "github.com/appnexus/ankh/pkg/ankh"
+	"github.com/appnexus/ankh/plan"
+	"github.com/appnexus/ankh/util"
+)
+
+// The modes that `ankh plan` can describe.
+var plannableModes = []ankh.Mode{ankh.Apply, ankh.Deploy, ankh.Diff, ankh.Rollback, ankh.Delete, ankh.Template, ankh.Lint, ankh.Get, ankh.Pods}
+
+// What a mode would do to the charts of one namespace, for `ankh plan`.
+type plannedRun struct {
+	Mode           string         `json:"mode" yaml:"mode"`
+	Context        string         `json:"context" yaml:"context"`
+	Cluster        string         `json:"cluster,omitempty" yaml:"cluster,omitempty"`
+	KubeContext    string         `json:"kubeContext,omitempty" yaml:"kubeContext,omitempty"`
+	KubeServer     string         `json:"kubeServer,omitempty" yaml:"kubeServer,omitempty"`
+	Namespace      string         `json:"namespace" yaml:"namespace"`
+	AnkhFile       string         `json:"ankhFile,omitempty" yaml:"ankhFile,omitempty"`
+	DryRun         bool           `json:"dryRun" yaml:"dryRun"`
+	Filters        []string       `json:"filters,omitempty" yaml:"filters,omitempty"`
+	FilterName     string         `json:"filterName,omitempty" yaml:"filterName,omitempty"`
+	FilterLabels   string         `json:"filterLabels,omitempty" yaml:"filterLabels,omitempty"`
+	WildCardLabels []string       `json:"wildCardLabels,omitempty" yaml:"wildCardLabels,omitempty"`
+	Charts         []plannedChart `json:"charts" yaml:"charts"`
+	Stages         []plannedStage `json:"stages" yaml:"stages"`
+}
+
+type plannedChart struct {
+	Name    string   `json:"name" yaml:"name"`
+	Chart   string   `json:"chart,omitempty" yaml:"chart,omitempty"`
+	Version string   `json:"version,omitempty" yaml:"version,omitempty"`
+	Path    string   `json:"path,omitempty" yaml:"path,omitempty"`
+	Tag     string   `json:"tag,omitempty" yaml:"tag,omitempty"`
+	Values  []string `json:"values" yaml:"values"`
+}
+
+type plannedStage struct {
+	Stage       string `json:"stage" yaml:"stage"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// The runs described so far, in the order they would happen.
+var plannedRuns = []plannedRun{}
+
+func checkPlannableMode(mode ankh.Mode) error {
+	names := []string{}
+	for _, plannable := range plannableModes {
+		if mode == plannable {
+			return nil
+		}
+		names = append(names, string(plannable))
+	}
+	return fmt.Errorf("Cannot plan mode \"%v\", expected one of %v", mode, strings.Join(names, ", "))
+}
+
+// Describes what the current mode would do to a set of charts in a namespace.
+func describePlan(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile, charts []ankh.Chart, namespace string, wildCardLabels []string) plannedRun {
+	currentContext := ctx.AnkhConfig.CurrentContext
+	run := plannedRun{
+		Mode:        string(ctx.Mode),
+		Context:     ctx.AnkhConfig.CurrentContextName,
+		Cluster:     ctx.AnkhConfig.CurrentClusterName,
+		KubeContext: currentContext.KubeContext,
+		KubeServer:  currentContext.KubeServer,
+		Namespace:   namespace,
+		AnkhFile:    ankhFile.Path,
+		DryRun:      ctx.DryRun,
+		Filters:     ctx.Filters,
+		FilterName:  ctx.FilterName,
+		Charts:      []plannedChart{},
+		Stages:      []plannedStage{},
+	}
+	if ctx.FilterLabels != nil {
+		run.FilterLabels = ctx.FilterLabels.String()
+	}
+	switch ctx.Mode {
+	case ankh.Diff, ankh.Get, ankh.Pods:
+		run.WildCardLabels = wildCardLabels
+	}
+
+	for _, chart := range charts {
+		planned := plannedChart{
+			Name:    chart.InstanceName(),
+			Version: chart.Version,
+			Path:    chart.Path,
+			Values:  helm.DescribeValues(ctx, chart),
+		}
+		if chart.Alias != "" {
+			planned.Chart = chart.Name
+		}
+		if chart.Tag != nil {
+			planned.Tag = *chart.Tag
+		}
+		run.Charts = append(run.Charts, planned)
+	}
+
+	// Mirrors planAndExecute, which runs these ahead of the plan itself.
+	switch ctx.Mode {
+	case ankh.Apply, ankh.Deploy:
+		if !ctx.SkipCrds {
+			run.Stages = append(run.Stages, plannedStage{Stage: "crds", Description: "applies the CRDs in each chart's crds/ directory, if any"})
+		}
+	}
+	if ctx.Mode == ankh.Deploy && len(canaryCharts(charts)) > 0 {
+		run.Stages = append(run.Stages, plannedStage{Stage: "canary", Description: "applies the canary of each chart that has one, and prompts to continue"})
+	}
+
+	p := newPlan(ctx, charts)
+	if helm.HasPostRenderer(ctx) {
+		p = ankhlib.WithPostRenderer(p)
+	}
+	if helm.HasKustomizeOverlay(ctx) {
+		p = ankhlib.WithKustomizeOverlay(p)
+	}
+	for _, ps := range p.PlanStages {
+		run.Stages = append(run.Stages, plannedStage{Stage: plan.StageName(ps.Stage), Description: ps.Opts.Description})
+	}
+	return run
+}
+
+func formatPlannedRuns(runs []plannedRun) string {
+	buf := bytes.NewBufferString("")
+	for i, run := range runs {
+		if i > 0 {
+			fmt.Fprintf(buf, "\n")
+		}
+		target := fmt.Sprintf("context \"%v\"", run.Context)
+		if run.Cluster != "" {
+			target += fmt.Sprintf(", cluster \"%v\"", run.Cluster)
+		}
+		if run.KubeContext != "" {
+			target += fmt.Sprintf(" (kube-context \"%v\")", run.KubeContext)
+		} else if run.KubeServer != "" {
+			target += fmt.Sprintf(" (kube-server %v)", run.KubeServer)
+		}
+		dryRun := ""
+		if run.DryRun {
+			dryRun = " (dry run)"
+		}
+		fmt.Fprintf(buf, "%v%v in %v, namespace \"%v\"\n", run.Mode, dryRun, target, run.Namespace)
+		if run.AnkhFile != "" {
+			fmt.Fprintf(buf, "  Ankh file: %v\n", run.AnkhFile)
+		}
+		if len(run.Filters) > 0 {
+			fmt.Fprintf(buf, "  Filters: %v\n", strings.Join(run.Filters, ", "))
+		}
+		if run.FilterName != "" {
+			fmt.Fprintf(buf, "  Name filter: %v\n", run.FilterName)
+		}
+		if run.FilterLabels != "" {
+			fmt.Fprintf(buf, "  Label filter: %v\n", run.FilterLabels)
+		}
+		if len(run.WildCardLabels) > 0 {
+			fmt.Fprintf(buf, "  Wildcard labels: %v\n", strings.Join(run.WildCardLabels, ", "))
+		}
+
+		fmt.Fprintf(buf, "  Charts:\n")
+		for _, chart := range run.Charts {
+			name := chart.Name
+			if chart.Chart != "" {
+				name += fmt.Sprintf(" (chart %v)", chart.Chart)
+			}
+			if chart.Path != "" {
+				name += fmt.Sprintf(" at %v", chart.Path)
+			} else if chart.Version != "" {
+				name += "@" + chart.Version
+			}
+			if chart.Tag != "" {
+				name += fmt.Sprintf(", tag %v", chart.Tag)
+			}
+			fmt.Fprintf(buf, "    %v\n", name)
+			for _, values := range chart.Values {
+				fmt.Fprintf(buf, "      - %v\n", values)
+			}
+		}
+
+		fmt.Fprintf(buf, "  Stages:\n")
+		for j, stage := range run.Stages {
+			if stage.Description != "" {
+				fmt.Fprintf(buf, "    %v. %v: %v\n", j+1, stage.Stage, stage.Description)
+			} else {
+				fmt.Fprintf(buf, "    %v. %v\n", j+1, stage.Stage)
+			}
+		}
+	}
+	return buf.String()
+}
+
+func printPlannedRuns(ctx *ankh.ExecutionContext) {
+	if ctx.Output != "" {
+		out, err := util.FormatOutput(ctx.Output, plannedRuns)
+		check(err)
+		fmt.Print(out)
+		return
+	}
+	fmt.Print(formatPlannedRuns(plannedRuns))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+func TestDescribePlan(t *testing.T) {
+	ctx := ankhtest.NewContext(t)
+	ctx.Mode = ankh.Apply
+	ctx.Wait = true
+	ctx.Filters = []string{"Deployment"}
+	tag := "1.0.0"
+	charts := []ankh.Chart{{Name: "app", Alias: "app-blue", Version: "1.2.3", Tag: &tag}}
+	charts[0].ChartMeta.TagKey = "image.tag"
+
+	run := describePlan(ctx, &ankh.AnkhFile{Path: "ankh.yaml", Charts: charts}, charts, "web", []string{"app"})
+	stages := []string{}
+	for _, stage := range run.Stages {
+		stages = append(stages, stage.Stage)
+	}
+	expected := "crds helm.TemplateStage helm.AnnotateStage kubectl.ApplyStage kubectl.RolloutStatusStage"
+	if strings.Join(stages, " ") != expected {
+		t.Logf("expected stages %v but got %v", expected, stages)
+		t.Fail()
+	}
+	if run.WildCardLabels != nil {
+		t.Logf("expected no wildcard labels for apply but got %v", run.WildCardLabels)
+		t.Fail()
+	}
+
+	out := formatPlannedRuns([]plannedRun{run})
+	for _, line := range []string{
+		"apply in context \"test\" (kube-context \"test\"), namespace \"web\"\n",
+		"  Filters: Deployment\n",
+		"    app-blue (chart app)@1.2.3, tag 1.0.0\n",
+		"      - --set image.tag=1.0.0\n",
+		"    5. kubectl.RolloutStatusStage: waits for every rollout to complete\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Logf("expected %q in:\n%v", line, out)
+			t.Fail()
+		}
+	}
+}
+
+func TestCheckPlannableMode(t *testing.T) {
+	if err := checkPlannableMode(ankh.Deploy); err != nil {
+		t.Logf("expected deploy to be plannable but got %v", err)
+		t.Fail()
+	}
+	if err := checkPlannableMode(ankh.Exec); err == nil {
+		t.Logf("expected exec, which is interactive, not to be plannable")
+		t.Fail()
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/helm"
+	"github.com/appnexus/ankh/kubectl"
+	"github.com/imdario/mergo"
+	"gopkg.in/yaml.v2"
+)
+
+// A chart to promote, with what it is running in the source and the target.
+type promotion struct {
+	Chart     string
+	Namespace string
+	From, To  ankh.PreviousRelease
+}
+
+// Returns the context to read charts from, or to compare them against, for a
+// context or an environment. Environments use their first context.
+func promotionContext(ctx *ankh.ExecutionContext, target string) string {
+	if environment, ok := ctx.AnkhConfig.Environments[target]; ok {
+		if len(environment.Contexts) == 0 {
+			log.Fatalf("Environment \"%v\" has no contexts", target)
+		}
+		if len(environment.Contexts) > 1 {
+			ctx.Logger.Infof("Using context \"%v\", the first of environment \"%v\"", environment.Contexts[0], target)
+		}
+		return environment.Contexts[0]
+	}
+	checkContext(&ctx.AnkhConfig, target)
+	return target
+}
+
+func useContext(ctx *ankh.ExecutionContext, context string) {
+	ctx.AnkhConfig.CurrentContextName = context
+	switchContext(ctx, &ctx.AnkhConfig, context)
+}
+
+// Whether `label` is the chart label of some version of chart `name`, eg:
+// `api-1.4.2` or `api-v1.4.2` for chart `api`.
+func isChartVersionLabel(label string, name string) bool {
+	version := strings.TrimPrefix(strings.TrimPrefix(label, name+"-"), "v")
+	return strings.HasPrefix(label, name+"-") && version != "" && unicode.IsDigit(rune(version[0]))
+}
+
+// Finds the single version of a chart that is running in the current context.
+func findLiveChart(ctx *ankh.ExecutionContext, name string, namespace string) (*kubectl.LiveChart, error) {
+	charts, err := kubectl.GetLiveCharts(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []kubectl.LiveChart{}
+	found := []string{}
+	for _, chart := range charts {
+		if isChartVersionLabel(chart.ChartLabel, name) {
+			matches = append(matches, chart)
+			found = append(found, fmt.Sprintf("%v in namespace \"%v\"", chart.ChartLabel, chart.Namespace))
+		}
+	}
+	if len(matches) > 1 {
+		return nil, fmt.Errorf("Found more than one version of chart \"%v\" in context \"%v\": [ %v ]. "+
+			"Pass a namespace with -n/--namespace, or wait for any rollout in progress to finish",
+			name, ctx.AnkhConfig.CurrentContextName, strings.Join(found, ", "))
+	} else if len(matches) == 0 {
+		return nil, nil
+	}
+	return &matches[0], nil
+}
+
+// The namespace to look for a chart in, if one is set, without prompting.
+func promotionNamespace(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile, chart ankh.Chart) string {
+	if override := ctx.ChartNamespaceOverride(chart); override != nil {
+		return *override
+	} else if ankhFile.Namespace != nil {
+		return *ankhFile.Namespace
+	} else if chart.ChartMeta.Namespace != nil {
+		return *chart.ChartMeta.Namespace
+	}
+	return ""
+}
+
+func formatPromotion(release ankh.PreviousRelease) string {
+	if release.ChartVersion == "" {
+		return "-"
+	}
+	if release.Tag == "" {
+		return release.ChartVersion
+	}
+	return fmt.Sprintf("%v (tag %v)", release.ChartVersion, release.Tag)
+}
+
+// Shows, for each chart, what the source is running, and what the target is
+// running now.
+func formatPromotions(promotions []promotion, from string, to string) string {
+	buf := bytes.NewBufferString("")
+	w := tabwriter.NewWriter(buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "NAMESPACE\tCHART\t%v\t%v (NOW)\n", strings.ToUpper(from), strings.ToUpper(to))
+	for _, p := range promotions {
+		marker := ""
+		if p.From == p.To {
+			marker = " (unchanged)"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v%v\n", p.Namespace, p.Chart, formatPromotion(p.From), formatPromotion(p.To), marker)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// Sets up the context to apply the chart versions and tags running in `from`
+// to `to`, each of which is a context or an environment. The versions and
+// tags are written to an Ankh file in the data dir, so that nothing is
+// prompted for again, and the apply's confirmation follows a table of what
+// changes.
+func setupPromote(ctx *ankh.ExecutionContext, from string, to string) {
+	if from == to {
+		log.Fatalf("Must promote to a context or environment other than \"%v\"", from)
+	}
+
+	ctx.ApplyProjectChart()
+	ankhFile, err := ankh.GetAnkhFile(ctx)
+	check(err)
+	if len(ankhFile.Charts) == 0 {
+		log.Fatalf("No charts to promote. Provide one with --chart, or an Ankh file with --ankhfile")
+	}
+	if len(ankhFile.Dependencies) > 0 {
+		ctx.Logger.Warnf("Only promoting the charts of the Ankh file, not its dependencies")
+		ankhFile.Dependencies = nil
+	}
+
+	fromContext := promotionContext(ctx, from)
+	toContext := promotionContext(ctx, to)
+
+	useContext(ctx, fromContext)
+	promotions := []promotion{}
+	for i := range ankhFile.Charts {
+		chart := &ankhFile.Charts[i]
+		if chart.Path != "" {
+			log.Fatalf("Cannot promote chart \"%v\" from local path \"%v\". Only versioned charts can be promoted", chart.InstanceName(), chart.Path)
+		}
+
+		live, err := findLiveChart(ctx, chart.Name, promotionNamespace(ctx, &ankhFile, *chart))
+		check(err)
+		if live == nil {
+			log.Fatalf("Chart \"%v\" was not found running in context \"%v\"", chart.Name, fromContext)
+		}
+		chart.Version = strings.TrimPrefix(live.ChartLabel, chart.Name+"-")
+
+		repository, err := ctx.DetermineHelmRepository(&chart.HelmRepository)
+		check(err)
+		meta, err := helm.FetchChartMeta(ctx, repository, chart)
+		if err != nil {
+			log.Fatalf("Error fetching chart \"%v\": %v", chart.InstanceName(), err)
+		}
+		mergo.Merge(&chart.ChartMeta, meta)
+
+		p := promotion{Chart: chart.InstanceName(), Namespace: live.Namespace, From: previousRelease(*chart, live.LiveRelease)}
+		if chart.ChartMeta.TagKey != "" {
+			if p.From.Tag == "" {
+				ctx.Logger.Warnf("Could not tell which tag chart \"%v\" is running in context \"%v\"", chart.InstanceName(), fromContext)
+			} else {
+				tag := p.From.Tag
+				chart.Tag = &tag
+			}
+		} else {
+			p.From.Tag = ""
+		}
+		namespace := live.Namespace
+		chart.ChartMeta.Namespace = &namespace
+		promotions = append(promotions, p)
+	}
+
+	useContext(ctx, toContext)
+	for i := range promotions {
+		live, err := findLiveChart(ctx, ankhFile.Charts[i].Name, promotions[i].Namespace)
+		if err != nil {
+			ctx.Logger.Warnf("Could not tell what chart \"%v\" is running in context \"%v\": %v", promotions[i].Chart, toContext, err)
+		} else if live != nil {
+			promotions[i].To = previousRelease(ankhFile.Charts[i], live.LiveRelease)
+			if ankhFile.Charts[i].ChartMeta.TagKey == "" {
+				promotions[i].To.Tag = ""
+			}
+		}
+	}
+	fmt.Printf("\nPromoting from %v to %v:\n\n%v", from, to, formatPromotions(promotions, from, to))
+
+	// Each chart's namespace is the one it was found in.
+	ankhFile.Namespace = nil
+
+	ankhFilePath := filepath.Join(ctx.DataDir, "promote-ankh.yaml")
+	check(os.MkdirAll(ctx.DataDir, 0755))
+	out, err := yaml.Marshal(ankhFile)
+	check(err)
+	check(ioutil.WriteFile(ankhFilePath, out, 0644))
+
+	ctx.Mode = ankh.Apply
+	ctx.AnkhFilePath = ankhFilePath
+	ctx.Chart = ""
+	ctx.Charts = nil
+	ctx.LocalChart = false
+	ctx.Namespace = nil
+	ctx.ChartNamespaces = nil
+	ctx.Tag = nil
+
+	// The context or environment were skipped while loading the config, since
+	// they come from --from and --to.
+	ctx.IgnoreContextAndEnv = false
+	if _, ok := ctx.AnkhConfig.Environments[to]; ok {
+		ctx.Environment = to
+		ctx.Context = ""
+	} else {
+		ctx.Environment = ""
+		ctx.Context = to
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+const promoteWorkloadsJSON = `{
+  "kind": "List",
+  "items": [
+    {
+      "kind": "Deployment",
+      "metadata": {"name": "api", "namespace": "web", "labels": {"helm.sh/chart": "api-1.4.2"}},
+      "spec": {"template": {"spec": {"containers": [{"image": "registry/api:20240105"}]}}}
+    },
+    {
+      "kind": "Deployment",
+      "metadata": {"name": "api-gateway", "namespace": "web", "labels": {"helm.sh/chart": "api-gateway-2.0.0"}},
+      "spec": {"template": {"spec": {"containers": [{"image": "registry/gateway:2.0.0"}]}}}
+    },
+    {
+      "kind": "StatefulSet",
+      "metadata": {"name": "db", "namespace": "data", "labels": {"chart": "db-5.7.0"}},
+      "spec": {"template": {"spec": {"containers": [{"image": "registry/db:5.7"}]}}}
+    },
+    {
+      "kind": "StatefulSet",
+      "metadata": {"name": "db", "namespace": "data-next", "labels": {"chart": "db-8.0.0"}},
+      "spec": {"template": {"spec": {"containers": [{"image": "registry/db:8.0"}]}}}
+    }
+  ]
+}`
+
+func TestFindLiveChart(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl", ankhtest.Rule{Args: "* get deployments,statefulsets,daemonsets *", Stdout: promoteWorkloadsJSON})
+	ctx := ankhtest.NewContext(t)
+
+	live, err := findLiveChart(ctx, "api", "")
+	if err != nil || live == nil {
+		t.Fatalf("expected chart api to be found but got %v", err)
+	}
+	if live.ChartLabel != "api-1.4.2" || live.Namespace != "web" || len(live.Images) != 1 {
+		t.Logf("expected api-1.4.2 and not api-gateway but got %+v", live)
+		t.Fail()
+	}
+	if calls := tools.Calls("kubectl"); !strings.HasSuffix(strings.Join(calls[0].Args, " "), "--all-namespaces") {
+		t.Logf("expected every namespace to be searched without a namespace but got %+v", calls[0].Args)
+		t.Fail()
+	}
+
+	if _, err := findLiveChart(ctx, "db", ""); err == nil || !strings.Contains(err.Error(), "db-8.0.0 in namespace \"data-next\"") {
+		t.Logf("expected an error listing both versions of db but got %v", err)
+		t.Fail()
+	}
+	if live, err := findLiveChart(ctx, "cache", ""); err != nil || live != nil {
+		t.Logf("expected no chart cache but got %+v and %v", live, err)
+		t.Fail()
+	}
+}
+
+func TestFormatPromotions(t *testing.T) {
+	promotions := []promotion{
+		{Chart: "api", Namespace: "web",
+			From: ankh.PreviousRelease{ChartVersion: "1.4.3", Tag: "20240112"},
+			To:   ankh.PreviousRelease{ChartVersion: "1.4.2", Tag: "20240105"}},
+		{Chart: "db", Namespace: "data",
+			From: ankh.PreviousRelease{ChartVersion: "5.7.0"},
+			To:   ankh.PreviousRelease{ChartVersion: "5.7.0"}},
+		{Chart: "cache", Namespace: "web", From: ankh.PreviousRelease{ChartVersion: "1.0.0"}},
+	}
+
+	expected := "NAMESPACE  CHART  STAGING               PRODUCTION (NOW)\n" +
+		"web        api    1.4.3 (tag 20240112)  1.4.2 (tag 20240105)\n" +
+		"data       db     5.7.0                 5.7.0 (unchanged)\n" +
+		"web        cache  1.0.0                 -\n"
+	if formatted := formatPromotions(promotions, "staging", "production"); formatted != expected {
+		t.Logf("expected\n%v\nbut got\n%v", expected, formatted)
+		t.Fail()
+	}
+}
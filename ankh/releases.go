@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/kubectl"
+	"github.com/appnexus/ankh/ledger"
+	"github.com/appnexus/ankh/replay"
+)
+
+// Returns the ledger entries for charts that were just applied, deployed or
+// rolled back in the current context.
+func ledgerEntries(ctx *ankh.ExecutionContext, charts []ankh.Chart, namespace string) []ledger.Entry {
+	deployer := ctx.EffectiveDeployer()
+	entries := []ledger.Entry{}
+	for _, chart := range charts {
+		entry := ledger.Entry{
+			ID:          ledger.NewID(),
+			Time:        time.Now().UTC(),
+			User:        deployer,
+			Action:      string(ctx.Mode),
+			Environment: ctx.Environment,
+			Context:     ctx.AnkhConfig.CurrentContextName,
+			Cluster:     ctx.AnkhConfig.CurrentClusterName,
+			Namespace:   namespace,
+			Chart:       chart.Name,
+			Alias:       chart.Alias,
+			Release:     ctx.Release,
+			RunID:       replay.RunID(ctx.DataDir),
+		}
+		if ctx.Mode == ankh.Rollback {
+			entry.Revision = ctx.RollbackRevision
+		} else {
+			entry.Version = chart.Version
+			entry.Path = chart.Path
+			if chart.Tag != nil {
+				entry.Tag = *chart.Tag
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Records charts that were just applied, deployed or rolled back in the
+// ledger, and in the configured ConfigMap, if any, for `ankh releases`.
+func recordReleases(ctx *ankh.ExecutionContext, charts []ankh.Chart, namespace string) {
+	entries := ledgerEntries(ctx, charts, namespace)
+	if err := ledger.Append(path.Dir(ctx.DataDir), entries); err != nil {
+		ctx.Logger.Warnf("Unable to record releases in the ledger: %v", err)
+	}
+
+	configMap := ctx.AnkhConfig.Ledger.ConfigMap
+	if configMap == "" {
+		return
+	}
+	for _, entry := range entries {
+		release, err := json.Marshal(entry)
+		if err == nil {
+			err = kubectl.RecordRelease(ctx, namespace, configMap, entry.ID, string(release))
+		}
+		if err != nil {
+			ctx.Logger.Warnf("Unable to record the release of chart \"%v\" in ConfigMap \"%v\": %v", entry.InstanceName(), configMap, err)
+		}
+	}
+}
+
+// Returns a chart as it was resolved by a recorded run, with the values of its
+// Ankh file, if the run was recorded.
+func recordedChart(manifest *replay.Manifest, instanceName string) *ankh.Chart {
+	ankhFiles := []ankh.AnkhFile{}
+	if manifest.AnkhFile != nil {
+		ankhFiles = append(ankhFiles, *manifest.AnkhFile)
+	}
+	for _, dep := range manifest.Dependencies {
+		ankhFiles = append(ankhFiles, dep.AnkhFile)
+	}
+	for _, ankhFile := range ankhFiles {
+		for _, chart := range ankhFile.Charts {
+			if chart.InstanceName() == instanceName {
+				return &chart
+			}
+		}
+	}
+	return nil
+}
+
+// Sets up the context to undo a release, by applying the chart version and tag
+// of the release before it, of the same chart, in the same context and
+// namespace. The chart's values come from the run of the earlier release,
+// when it was recorded.
+func setupUndo(ctx *ankh.ExecutionContext, id string) {
+	baseDataDir := path.Dir(ctx.DataDir)
+	entries, err := ledger.Load(baseDataDir)
+	check(err)
+	entry, earlier, err := ledger.Find(entries, id)
+	check(err)
+	if entry.Action == ledger.ActionRollback {
+		log.Fatalf("Release %v is a rollback, which can't be undone. Apply the version to return to instead", id)
+	}
+	if earlier == nil {
+		log.Fatalf("No release of chart \"%v\" in context \"%v\" and namespace \"%v\" came before %v, so there is nothing to return to",
+			entry.InstanceName(), entry.Context, entry.Namespace, id)
+	}
+
+	chart := ankh.Chart{Name: earlier.Chart, Alias: earlier.Alias}
+	manifest, err := replay.Load(baseDataDir, earlier.RunID)
+	if err == nil {
+		if recorded := recordedChart(manifest, earlier.InstanceName()); recorded != nil {
+			chart = *recorded
+		}
+		useRecordedValues(ctx, manifest)
+	} else {
+		ctx.Logger.Warnf("Using only the chart's own values, since the Ankh file of release %v is not available: %v", earlier.ID, err)
+	}
+	chart.Version = earlier.Version
+	chart.Path = earlier.Path
+	chart.Tag = nil
+	if earlier.Tag != "" {
+		tag := earlier.Tag
+		chart.Tag = &tag
+	}
+
+	ankhFilePath := filepath.Join(ctx.DataDir, "undo-ankh.yaml")
+	check(os.MkdirAll(ctx.DataDir, 0755))
+	out, err := yaml.Marshal(ankh.AnkhFile{Charts: []ankh.Chart{chart}})
+	check(err)
+	check(ioutil.WriteFile(ankhFilePath, out, 0644))
+
+	ctx.Logger.Infof("Undoing release %v of chart \"%v\" in context \"%v\" with the version and tag of release %v, from %v",
+		id, entry.InstanceName(), entry.Context, earlier.ID, earlier.Time.Local().Format("2006-01-02 15:04:05"))
+
+	ctx.Mode = ankh.Apply
+	ctx.AnkhFilePath = ankhFilePath
+	namespace := earlier.Namespace
+	ctx.Namespace = &namespace
+	ctx.ChartNamespaces = nil
+	ctx.Tag = nil
+	ctx.Release = earlier.Release
+
+	// The context was skipped while loading the config, since it comes from the release.
+	ctx.IgnoreContextAndEnv = false
+	ctx.Environment = ""
+	ctx.Context = entry.Context
+	ctx.AnkhConfig.CurrentContextName = entry.Context
+	switchContext(ctx, &ctx.AnkhConfig, entry.Context)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/ledger"
+	"github.com/appnexus/ankh/replay"
+)
+
+func TestLedgerEntries(t *testing.T) {
+	ctx := ankhtest.NewContext(t)
+	tag := "abc123"
+	charts := []ankh.Chart{{Name: "api", Version: "1.4.3", Tag: &tag}, {Name: "worker", Alias: "worker-eu", Version: "0.9.0"}}
+
+	ctx.Mode = ankh.Apply
+	entries := ledgerEntries(ctx, charts, "web")
+	if len(entries) != 2 || entries[0].ID == entries[1].ID {
+		t.Fatalf("expected an entry with an ID of its own for each chart but got %+v", entries)
+	}
+	if entries[0].Action != ledger.ActionApply || entries[0].Context != "test" || entries[0].Namespace != "web" ||
+		entries[0].Version != "1.4.3" || entries[0].Tag != "abc123" || entries[0].RunID != replay.RunID(ctx.DataDir) {
+		t.Logf("got unexpected entry %+v", entries[0])
+		t.Fail()
+	}
+	if entries[1].InstanceName() != "worker-eu" || entries[1].Tag != "" {
+		t.Logf("got unexpected entry %+v", entries[1])
+		t.Fail()
+	}
+
+	ctx.Mode = ankh.Rollback
+	ctx.RollbackRevision = 3
+	entries = ledgerEntries(ctx, charts[:1], "web")
+	if entries[0].Action != ledger.ActionRollback || entries[0].Revision != 3 || entries[0].Version != "" {
+		t.Logf("expected a rollback to record its revision and no version but got %+v", entries[0])
+		t.Fail()
+	}
+}
+
+func TestRecordedChart(t *testing.T) {
+	manifest := &replay.Manifest{
+		AnkhFile: &ankh.AnkhFile{Charts: []ankh.Chart{{Name: "api", Version: "1.4.2"}}},
+		Dependencies: []replay.Dependency{{
+			Source:   "deps.yaml",
+			AnkhFile: ankh.AnkhFile{Charts: []ankh.Chart{{Name: "worker", Alias: "worker-eu", Version: "0.9.0"}}},
+		}},
+	}
+
+	if chart := recordedChart(manifest, "worker-eu"); chart == nil || chart.Version != "0.9.0" {
+		t.Logf("expected the chart from the dependency but got %+v", chart)
+		t.Fail()
+	}
+	if chart := recordedChart(manifest, "worker"); chart != nil {
+		t.Logf("expected an aliased chart not to match its name but got %+v", chart)
+		t.Fail()
+	}
+}
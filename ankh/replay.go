@@ -0,0 +1,105 @@
+package main
+
+import (
+	"path"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/replay"
+)
+
+// The manifest of the current run, if it is one that can be replayed.
+var runManifest *replay.Manifest
+
+func startRunManifest(ctx *ankh.ExecutionContext) {
+	if ctx.PlanOnly {
+		return
+	}
+	switch ctx.Mode {
+	case ankh.Apply, ankh.Deploy, ankh.Rollback:
+		runManifest = replay.NewManifest(ctx)
+	}
+}
+
+// Records an Ankh file once it is resolved, and before anything is changed,
+// so that failed runs can be replayed, too.
+func recordRunManifest(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile, dependency string) {
+	if runManifest == nil {
+		return
+	}
+
+	first := runManifest.AnkhFile == nil && len(runManifest.Dependencies) == 0
+	recorded, err := runManifest.Record(ctx, ankhFile, dependency)
+	if err == nil && recorded {
+		err = replay.Write(ctx.DataDir, runManifest)
+	}
+	if err != nil {
+		ctx.Logger.Warnf("Unable to record this run for `ankh replay`: %v", err)
+		return
+	}
+	if first && recorded {
+		ctx.Logger.Infof("This run can be repeated with `ankh replay %v`", replay.RunID(ctx.DataDir))
+	}
+}
+
+// Sets up the context to repeat a recorded run, with the same target, values,
+// and resolved Ankh files, and without prompting.
+func setupReplay(ctx *ankh.ExecutionContext, runID string, dryRun bool) {
+	manifest, err := replay.Load(path.Dir(ctx.DataDir), runID)
+	check(err)
+
+	ankhFilePath, err := manifest.WriteAnkhFiles(ctx.DataDir)
+	check(err)
+
+	ctx.Mode = ankh.Mode(manifest.Command)
+	ctx.AnkhFilePath = ankhFilePath
+	ctx.NoPrompt = true
+	ctx.DryRun = manifest.DryRun || dryRun
+	ctx.SkipCrds = manifest.SkipCrds
+	ctx.Wait = manifest.Wait
+	ctx.WaitTimeout = manifest.WaitTimeout
+	ctx.RollbackRevision = manifest.ToRevision
+	ctx.Filters = manifest.Filters
+	ctx.FilterName = manifest.FilterName
+	ctx.FilterLabels = nil
+	if manifest.FilterLabels != "" {
+		filterLabels, err := ankh.ParseLabelSelector(manifest.FilterLabels)
+		check(err)
+		ctx.FilterLabels = filterLabels
+	}
+	ctx.Release = manifest.Release
+	ctx.Namespace = manifest.Namespace
+	ctx.ChartNamespaces = manifest.ChartNamespaces
+
+	useRecordedValues(ctx, manifest)
+
+	ctx.Logger.Infof("Replaying `%v` from run %v, started %v", manifest.Command, runID, manifest.Start.Format("2006-01-02 15:04:05"))
+
+	// The context or environment were skipped while loading the config, since
+	// they come from the recorded run.
+	ctx.IgnoreContextAndEnv = false
+	ctx.Environment = manifest.Environment
+	ctx.Context = ""
+	if ctx.Environment == "" {
+		ctx.Context = manifest.Context
+		ctx.AnkhConfig.CurrentContextName = manifest.Context
+		switchContext(ctx, &ctx.AnkhConfig, manifest.Context)
+	}
+}
+
+// Uses the values set in a recorded run, along with those set on the command
+// line, which take precedence.
+func useRecordedValues(ctx *ankh.ExecutionContext, manifest *replay.Manifest) {
+	merge := func(recorded map[string]string, current map[string]string) map[string]string {
+		values := map[string]string{}
+		for key, value := range recorded {
+			values[key] = value
+		}
+		for key, value := range current {
+			values[key] = value
+		}
+		return values
+	}
+	ctx.HelmSetValues = merge(manifest.Set, ctx.HelmSetValues)
+	ctx.HelmSetStringValues = merge(manifest.SetString, ctx.HelmSetStringValues)
+	ctx.HelmSetFiles = merge(manifest.SetFile, ctx.HelmSetFiles)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/rollout"
+)
+
+// The rollout in progress, if any, for the hint on how to resume it.
+var unfinishedRollout *rollout.State
+
+// The command line, without `--resume`, so that a resumed rollout is
+// recognized as the same command as the one that failed.
+func rolloutCommand(args []string) []string {
+	command := []string{}
+	for _, arg := range args {
+		if arg != "--resume" && arg != "--resume=true" {
+			command = append(command, arg)
+		}
+	}
+	return command
+}
+
+// Records the progress of an apply over several contexts, so that a failed
+// rollout can be resumed with `--resume`. Returns nil when there is nothing
+// to record.
+func startRollout(ctx *ankh.ExecutionContext, contexts []string) *rollout.State {
+	if ctx.Mode != ankh.Apply || ctx.DryRun || ctx.PlanOnly || len(contexts) < 2 {
+		if ctx.Resume {
+			ctx.Logger.Fatalf("Only an apply over several contexts, eg: with `--environment`, can be resumed")
+		}
+		return nil
+	}
+
+	baseDataDir := path.Dir(ctx.DataDir)
+	command := rolloutCommand(os.Args[1:])
+	wd, _ := os.Getwd()
+
+	var state *rollout.State
+	if ctx.Resume {
+		loaded, err := rollout.Load(baseDataDir, command, wd)
+		check(err)
+		if loaded == nil {
+			ctx.Logger.Fatalf("No failed rollout of this command to resume. Run the same command, in the same directory, with `--resume`")
+		}
+		state = loaded
+	} else {
+		state = rollout.New(baseDataDir, command, wd, contexts)
+		if err := state.Write(); err != nil {
+			ctx.Logger.Warnf("Unable to record the progress of this rollout, so it can't be resumed: %v", err)
+			return nil
+		}
+	}
+
+	unfinishedRollout = state
+	onErrorExit("rollout", func() {
+		if unfinishedRollout != nil {
+			ctx.Logger.Errorf("The rollout did not finish. Run the same command with `--resume` to continue from the first context that did not succeed")
+		}
+	})
+	return state
+}
+
+// Whether a resumed rollout already succeeded on a context.
+func skipRolloutContext(ctx *ankh.ExecutionContext, state *rollout.State, context string) bool {
+	if state == nil || !state.Succeeded(context) {
+		return false
+	}
+	ctx.Logger.Infof("Skipping context \"%v\", which the rollout already succeeded on", context)
+	return true
+}
+
+func setRolloutStatus(ctx *ankh.ExecutionContext, state *rollout.State, context string, status rollout.Status) {
+	if state == nil {
+		return
+	}
+	if err := state.Set(context, status); err != nil {
+		ctx.Logger.Warnf("Unable to record the progress of this rollout: %v", err)
+	}
+}
+
+func finishRollout(ctx *ankh.ExecutionContext, state *rollout.State) {
+	if state == nil {
+		return
+	}
+	unfinishedRollout = nil
+	if err := state.Finish(); err != nil {
+		ctx.Logger.Warnf("Unable to remove the progress of the finished rollout: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/helm"
+	"github.com/appnexus/ankh/util"
+)
+
+// Finds a chart in the Ankh file, and the namespace it would be templated in.
+func findSecretsChart(ctx *ankh.ExecutionContext, chartArg string) (ankh.Chart, string) {
+	setChartArgs(ctx, []string{chartArg})
+	ankhFile, err := ankh.GetAnkhFile(ctx)
+	check(err)
+	if len(ankhFile.Charts) != 1 {
+		ctx.Logger.Fatalf("Chart \"%v\" not found in Ankh file \"%v\"", chartArg, ctx.AnkhFilePath)
+	}
+
+	chart := ankhFile.Charts[0]
+	if len(chart.Secrets) == 0 {
+		ctx.Logger.Fatalf("Chart \"%v\" has no `secrets`", chart.InstanceName())
+	}
+
+	namespace := ""
+	if override := ctx.ChartNamespaceOverride(chart); override != nil {
+		namespace = *override
+	} else if ankhFile.Namespace != nil {
+		namespace = *ankhFile.Namespace
+	} else if chart.ChartMeta.Namespace != nil {
+		namespace = *chart.ChartMeta.Namespace
+	}
+	return chart, namespace
+}
+
+func viewSecrets(ctx *ankh.ExecutionContext, chartArg string) {
+	chart, namespace := findSecretsChart(ctx, chartArg)
+	values, err := helm.DecryptSecrets(ctx, chart, namespace)
+	check(err)
+	out, err := yaml.Marshal(values)
+	check(err)
+	fmt.Print(string(out))
+}
+
+// Edits a sops-encrypted file in $EDITOR, by way of sops, which re-encrypts it
+// on save. The argument is either a chart with sops secrets, or a file.
+func editSecrets(ctx *ankh.ExecutionContext, arg string) {
+	file := arg
+	if _, err := os.Stat(arg); err != nil {
+		chart, _ := findSecretsChart(ctx, arg)
+		files, err := helm.SopsFiles(ctx, chart)
+		check(err)
+
+		switch {
+		case len(files) == 0:
+			ctx.Logger.Fatalf("Chart \"%v\" has no sops `secrets` to edit. Vault secrets are edited with the vault CLI",
+				chart.InstanceName())
+		case len(files) == 1:
+			file = files[0]
+		case ctx.NoPrompt:
+			ctx.Logger.Fatalf("Chart \"%v\" has several sops `secrets`, so one must be passed by file: %v",
+				chart.InstanceName(), files)
+		default:
+			file, err = util.PromptForSelection(files, "Select a secrets file to edit", false)
+			check(err)
+		}
+	}
+
+	ctx.Logger.Infof("Editing %v with sops", file)
+	cmd := exec.Command("sops", file)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	check(cmd.Run())
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/appnexus/ankh/config"
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/helm"
+	"github.com/appnexus/ankh/kubectl"
+	"github.com/appnexus/ankh/util"
+)
+
+type componentVersion struct {
+	Command string `json:"command" yaml:"command"`
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	Error   string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type versionInfo struct {
+	Ankh                string           `json:"ankh" yaml:"ankh"`
+	Helm                componentVersion `json:"helm" yaml:"helm"`
+	Kubectl             componentVersion `json:"kubectl" yaml:"kubectl"`
+	Fzf                 bool             `json:"fzf" yaml:"fzf"`
+	ConfigSchemaVersion string           `json:"configSchemaVersion" yaml:"configSchemaVersion"`
+}
+
+func getComponentVersion(command string, version func() (string, error)) componentVersion {
+	component := componentVersion{Command: command}
+	out, err := version()
+	if err != nil {
+		// Just the first line. The rest is usually the command's stderr.
+		component.Error = strings.SplitN(err.Error(), "\n", 2)[0]
+	} else {
+		component.Version = strings.TrimSpace(out)
+	}
+	return component
+}
+
+// Gathers the versions of Ankh and the tools it depends on. Missing tools are
+// reported rather than being fatal, so that this works as a diagnostic.
+func getVersionInfo(ctx *ankh.ExecutionContext) versionInfo {
+	// The config is skipped, so that problems loading it can't get in the way.
+	config.SetDefaultCommands(&ctx.AnkhConfig)
+
+	return versionInfo{
+		Ankh: AnkhBuildVersion,
+		Helm: getComponentVersion(ctx.AnkhConfig.Helm.Command, func() (string, error) {
+			return helm.Version(ctx)
+		}),
+		Kubectl: getComponentVersion(ctx.AnkhConfig.Kubectl.Command, func() (string, error) {
+			return kubectl.Version(ctx)
+		}),
+		Fzf:                 util.HasFzf(),
+		ConfigSchemaVersion: ankh.ConfigSchemaVersion,
+	}
+}
+
+func formatVersionInfo(info versionInfo, format string) (string, error) {
+	switch format {
+	case util.OutputJSON, util.OutputYAML:
+		return util.FormatOutput(format, info)
+	case "":
+		formatted := bytes.NewBufferString("")
+		w := tabwriter.NewWriter(formatted, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "COMPONENT\tVERSION\n")
+		fmt.Fprintf(w, "ankh\t%v\n", info.Ankh)
+		for _, component := range []componentVersion{info.Helm, info.Kubectl} {
+			version := strings.Replace(component.Version, "\n", "; ", -1)
+			if component.Error != "" {
+				version = "unavailable: " + component.Error
+			}
+			fmt.Fprintf(w, "%v\t%v\n", component.Command, version)
+		}
+		fzf := "not found, using the built-in prompt"
+		if info.Fzf {
+			fzf = "available"
+		}
+		fmt.Fprintf(w, "fzf\t%v\n", fzf)
+		fmt.Fprintf(w, "config schema\t%v\n", info.ConfigSchemaVersion)
+		w.Flush()
+		return formatted.String(), nil
+	default:
+		return "", fmt.Errorf("Unsupported output format \"%v\", must be `json` or `yaml`", format)
+	}
+}
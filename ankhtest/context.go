@@ -0,0 +1,60 @@
+package ankhtest
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/appnexus/ankh/context"
+)
+
+// NewContext returns an ExecutionContext for a `test` context, with its own
+// data dir, that runs helm and kubectl from the PATH, eg: fakes from NewTools.
+func NewContext(t *testing.T) *ankh.ExecutionContext {
+	dataDir, err := ioutil.TempDir("", "ankhtest-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dataDir) })
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	if testing.Verbose() {
+		logger.Out = os.Stderr
+	}
+
+	ctx := &ankh.ExecutionContext{Logger: logger, DataDir: dataDir, NoPrompt: true}
+	ctx.AnkhConfig.Helm.Command = "helm"
+	ctx.AnkhConfig.Kubectl.Command = "kubectl"
+	ctx.AnkhConfig.CurrentContextName = "test"
+	ctx.AnkhConfig.CurrentContext = ankh.Context{
+		KubeContext:      "test",
+		EnvironmentClass: "test",
+		ResourceProfile:  "test",
+	}
+	return ctx
+}
+
+// CaptureStdout runs f and returns everything it printed to stdout, eg: the
+// listings that stages print directly.
+func CaptureStdout(t *testing.T, f func() error) (string, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+
+	captured := make(chan string)
+	go func() {
+		out, _ := ioutil.ReadAll(r)
+		captured <- string(out)
+	}()
+
+	err = f()
+	w.Close()
+	os.Stdout = stdout
+	return <-captured, err
+}
@@ -0,0 +1,127 @@
+package ankhtest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// A Chart served by a fake chart repository.
+type Chart struct {
+	Name    string
+	Version string
+	Created string
+	// Files of the chart by path, eg: `templates/deployment.yaml`. A
+	// Chart.yaml is generated unless given.
+	Files map[string]string
+	// Served as the chart's `.prov` file, if given
+	Provenance string
+}
+
+// ChartTarball packages a chart as helm would, ie: a gzipped tarball with the
+// chart's files under a directory named for the chart.
+func ChartTarball(t *testing.T, chart Chart) []byte {
+	files := map[string]string{
+		"Chart.yaml": fmt.Sprintf("apiVersion: v1\nname: %v\nversion: %v\n", chart.Name, chart.Version),
+	}
+	for path, content := range chart.Files {
+		files[path] = content
+	}
+	paths := []string{}
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, path := range paths {
+		body := []byte(files[path])
+		header := &tar.Header{Name: chart.Name + "/" + path, Mode: 0644, Size: int64(len(body))}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// ChartRepository is a fake helm chart repository, serving an `index.yaml`
+// and a tarball, and any provenance file, for each of its charts.
+type ChartRepository struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []string
+}
+
+type indexEntry struct {
+	Name    string   `yaml:"name"`
+	Version string   `yaml:"version"`
+	Created string   `yaml:"created,omitempty"`
+	URLs    []string `yaml:"urls"`
+}
+
+// NewChartRepository starts a fake chart repository, which is closed when the
+// test finishes.
+func NewChartRepository(t *testing.T, charts ...Chart) *ChartRepository {
+	entries := map[string][]indexEntry{}
+	tarballs := map[string][]byte{}
+	for _, chart := range charts {
+		tarball := fmt.Sprintf("%v-%v.tgz", chart.Name, chart.Version)
+		entries[chart.Name] = append(entries[chart.Name], indexEntry{
+			Name: chart.Name, Version: chart.Version, Created: chart.Created, URLs: []string{tarball},
+		})
+		tarballs["/"+tarball] = ChartTarball(t, chart)
+		if chart.Provenance != "" {
+			tarballs["/"+tarball+".prov"] = []byte(chart.Provenance)
+		}
+	}
+	index, err := yaml.Marshal(map[string]interface{}{"apiVersion": "v1", "entries": entries})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repository := &ChartRepository{}
+	repository.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repository.mu.Lock()
+		repository.requests = append(repository.requests, r.URL.Path)
+		repository.mu.Unlock()
+
+		if r.URL.Path == "/index.yaml" {
+			w.Write(index)
+			return
+		}
+		if tarball, ok := tarballs[r.URL.Path]; ok {
+			w.Write(tarball)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(repository.Close)
+	return repository
+}
+
+// Requests returns the paths requested from the repository, in order.
+func (repository *ChartRepository) Requests() []string {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+	return append([]string{}, repository.requests...)
+}
@@ -0,0 +1,147 @@
+// Package ankhtest provides fakes for the tools and services that Ankh runs
+// against, ie: helm, kubectl, and helm and docker repositories, so that whole
+// operations can be tested without a cluster.
+package ankhtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// A Rule decides how a fake tool responds to a call. Rules are matched in
+// order, against the call's arguments joined by spaces, eg: `template *`.
+type Rule struct {
+	// A shell glob pattern, eg: `get pods *`. Matches every call when empty.
+	Args     string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// A Call is one invocation of a fake tool.
+type Call struct {
+	Args  []string
+	Stdin string
+}
+
+// Tools puts fake executables first on the PATH for the duration of a test.
+type Tools struct {
+	// The directory holding the fakes, and the record of their calls
+	Dir string
+	t   *testing.T
+}
+
+// NewTools creates a directory for fake tools and puts it first on the PATH,
+// until the test finishes.
+func NewTools(t *testing.T) *Tools {
+	dir, err := ioutil.TempDir("", "ankhtest-tools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	t.Cleanup(func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	})
+	return &Tools{Dir: dir, t: t}
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Fake installs an executable `name` that records each call, and responds
+// with the first rule that matches its arguments. Calls that match no rule
+// exit with an error.
+func (tools *Tools) Fake(name string, rules ...Rule) {
+	callsDir := filepath.Join(tools.Dir, name+".calls")
+	if err := os.MkdirAll(callsDir, 0755); err != nil {
+		tools.t.Fatal(err)
+	}
+
+	var script strings.Builder
+	fmt.Fprintf(&script, "#!/bin/sh\n")
+	fmt.Fprintf(&script, "calls=%v\n", shellQuote(callsDir))
+	// Number each call, so that calls sort in the order they were made.
+	fmt.Fprintf(&script, "call=\"$calls/$(printf %%08d $(ls \"$calls\" | grep -c 'args$'))-$$\"\n")
+	fmt.Fprintf(&script, "for arg in \"$@\"; do printf '%%s\\0' \"$arg\"; done > \"$call.args\"\n")
+	fmt.Fprintf(&script, "if [ ! -t 0 ]; then cat > \"$call.stdin\"; fi\n")
+	fmt.Fprintf(&script, "case \"$*\" in\n")
+	for i, rule := range rules {
+		pattern := rule.Args
+		if pattern == "" {
+			pattern = "*"
+		}
+		stdout := filepath.Join(tools.Dir, fmt.Sprintf("%v.%v.stdout", name, i))
+		stderr := filepath.Join(tools.Dir, fmt.Sprintf("%v.%v.stderr", name, i))
+		tools.writeFile(stdout, rule.Stdout, 0644)
+		tools.writeFile(stderr, rule.Stderr, 0644)
+
+		// Quote everything but the glob characters, so that they still match.
+		quoted := shellQuote(pattern)
+		quoted = strings.Replace(quoted, "*", "'*'", -1)
+		quoted = strings.Replace(quoted, "?", "'?'", -1)
+		fmt.Fprintf(&script, "  %v)\n    cat %v\n    cat %v >&2\n    exit %v\n    ;;\n",
+			quoted, shellQuote(stdout), shellQuote(stderr), strconv.Itoa(rule.ExitCode))
+	}
+	fmt.Fprintf(&script, "esac\n")
+	fmt.Fprintf(&script, "echo \"ankhtest: no rule for %v $*\" >&2\nexit 127\n", name)
+
+	tools.writeFile(filepath.Join(tools.Dir, name), script.String(), 0755)
+}
+
+// FakeScript installs an executable `name` that runs a shell script, for
+// fakes that need more than canned responses.
+func (tools *Tools) FakeScript(name string, script string) {
+	tools.writeFile(filepath.Join(tools.Dir, name), "#!/bin/sh\n"+script, 0755)
+}
+
+// Calls returns the calls made to a fake from Fake, in order.
+func (tools *Tools) Calls(name string) []Call {
+	callsDir := filepath.Join(tools.Dir, name+".calls")
+	matches, err := filepath.Glob(filepath.Join(callsDir, "*.args"))
+	if err != nil {
+		tools.t.Fatal(err)
+	}
+	sort.Strings(matches)
+
+	calls := []Call{}
+	for _, match := range matches {
+		args, err := ioutil.ReadFile(match)
+		if err != nil {
+			tools.t.Fatal(err)
+		}
+		stdin, _ := ioutil.ReadFile(strings.TrimSuffix(match, ".args") + ".stdin")
+		call := Call{Args: []string{}, Stdin: string(stdin)}
+		if len(args) > 0 {
+			call.Args = strings.Split(strings.TrimSuffix(string(args), "\x00"), "\x00")
+		}
+		calls = append(calls, call)
+	}
+	return calls
+}
+
+// CallsMatching returns the calls made to a fake whose arguments, joined by
+// spaces, contain `substring`.
+func (tools *Tools) CallsMatching(name string, substring string) []Call {
+	calls := []Call{}
+	for _, call := range tools.Calls(name) {
+		if strings.Contains(strings.Join(call.Args, " "), substring) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (tools *Tools) writeFile(path string, content string, mode os.FileMode) {
+	if err := ioutil.WriteFile(path, []byte(content), mode); err != nil {
+		tools.t.Fatal(err)
+	}
+}
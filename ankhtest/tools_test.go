@@ -0,0 +1,58 @@
+package ankhtest
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestFake(t *testing.T) {
+	tools := NewTools(t)
+	tools.Fake("tool",
+		Rule{Args: "version", Stdout: "v1.0.0\n"},
+		Rule{Args: "get 'quoted' *", Stdout: "quoted\n"},
+		Rule{Args: "fail *", Stderr: "it failed\n", ExitCode: 3},
+	)
+
+	out, err := exec.Command("tool", "version").Output()
+	if err != nil || string(out) != "v1.0.0\n" {
+		t.Logf("got unexpected output %q and error %v", out, err)
+		t.Fail()
+	}
+
+	out, err = exec.Command("tool", "get", "'quoted'", "a b").Output()
+	if err != nil || string(out) != "quoted\n" {
+		t.Logf("got unexpected output %q and error %v", out, err)
+		t.Fail()
+	}
+
+	cmd := exec.Command("tool", "fail", "now")
+	cmd.Stdin = strings.NewReader("kind: Pod\n")
+	out, err = cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 3 || string(out) != "it failed\n" {
+		t.Logf("got unexpected output %q and error %v", out, err)
+		t.Fail()
+	}
+
+	if err := exec.Command("tool", "unknown").Run(); err == nil {
+		t.Logf("expected calls that match no rule to fail")
+		t.Fail()
+	}
+
+	calls := tools.Calls("tool")
+	if len(calls) != 4 {
+		t.Fatalf("expected 4 calls but got %+v", calls)
+	}
+	if len(calls[1].Args) != 3 || calls[1].Args[2] != "a b" {
+		t.Logf("expected arguments to be recorded as given but got %q", calls[1].Args)
+		t.Fail()
+	}
+	if calls[2].Stdin != "kind: Pod\n" {
+		t.Logf("expected stdin to be recorded but got %q", calls[2].Stdin)
+		t.Fail()
+	}
+	if matching := tools.CallsMatching("tool", "fail"); len(matching) != 1 {
+		t.Logf("expected a single matching call but got %+v", matching)
+		t.Fail()
+	}
+}
@@ -0,0 +1,110 @@
+package artifact
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	ankh "github.com/appnexus/ankh/context"
+)
+
+// The files of each bundle.
+const (
+	ManifestFile = "manifest.yaml"
+	SummaryFile  = "summary.txt"
+)
+
+var httpClient = &http.Client{Timeout: 60 * time.Second}
+
+// Bundle returns a gzipped tarball of the rendered manifest, and the summary
+// of the run that applied it.
+func Bundle(manifest string, summary string, modTime time.Time) ([]byte, error) {
+	buf := bytes.Buffer{}
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, file := range []struct{ name, body string }{{ManifestFile, manifest}, {SummaryFile, summary}} {
+		header := &tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.body)), ModTime: modTime}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write([]byte(file.body)); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Destination returns where a bundle is uploaded to: the configured URL and
+// path, with each variable in the path substituted, eg: `%CONTEXT%`.
+func Destination(config ankh.ArtifactsConfig, variables map[string]string) (string, error) {
+	if config.URL == "" || config.Path == "" {
+		return "", fmt.Errorf("both `artifacts.url` and `artifacts.path` must be set")
+	}
+
+	// Longer names first, so that eg: %CHART% does not replace part of %CHART_NAME%.
+	names := []string{}
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	path := config.Path
+	for _, name := range names {
+		path = strings.Replace(path, name, variables[name], -1)
+	}
+	return strings.TrimRight(config.URL, "/") + "/" + strings.TrimLeft(path, "/"), nil
+}
+
+// Upload uploads a bundle to its destination, with `command` if set, or else
+// with an HTTP PUT.
+func Upload(config ankh.ArtifactsConfig, destination string, bundle []byte) error {
+	if len(config.Command) > 0 {
+		args := append(append([]string{}, config.Command[1:]...), destination)
+		cmd := exec.Command(config.Command[0], args...)
+		cmd.Stdin = bytes.NewReader(bundle)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%v failed: %v: %v", strings.Join(config.Command, " "), err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+
+	if !strings.HasPrefix(destination, "http://") && !strings.HasPrefix(destination, "https://") {
+		return fmt.Errorf("unable to upload to %v without an `artifacts.command`", destination)
+	}
+	req, err := http.NewRequest("PUT", destination, bytes.NewReader(bundle))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	for name, value := range config.Headers {
+		req.Header.Set(name, os.ExpandEnv(value))
+	}
+	if config.Username != "" {
+		req.SetBasicAuth(config.Username, os.Getenv(config.PasswordEnv))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("received HTTP status '%v' when trying to PUT %v: %v", resp.Status, destination, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package artifact
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/appnexus/ankh/ankhtest"
+	ankh "github.com/appnexus/ankh/context"
+)
+
+func TestBundle(t *testing.T) {
+	bundle, err := Bundle("kind: Deployment\n", "Action: apply\n", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(bundle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		body, _ := ioutil.ReadAll(tr)
+		files[header.Name] = string(body)
+	}
+	if files[ManifestFile] != "kind: Deployment\n" || files[SummaryFile] != "Action: apply\n" {
+		t.Logf("expected the manifest and summary in the bundle but got %+v", files)
+		t.Fail()
+	}
+}
+
+func TestDestination(t *testing.T) {
+	config := ankh.ArtifactsConfig{URL: "https://artifacts.example.com/releases/", Path: "/%CHART_NAME%/%CHART_VERSION%/%CONTEXT%/%TIMESTAMP%.tar.gz"}
+	destination, err := Destination(config, map[string]string{
+		"%CHART%": "api@1.0.0", "%CHART_NAME%": "api", "%CHART_VERSION%": "1.0.0", "%CONTEXT%": "prod", "%TIMESTAMP%": "20240112T200405Z",
+	})
+	if err != nil || destination != "https://artifacts.example.com/releases/api/1.0.0/prod/20240112T200405Z.tar.gz" {
+		t.Logf("got unexpected destination %v and %v", destination, err)
+		t.Fail()
+	}
+	if _, err := Destination(ankh.ArtifactsConfig{URL: "s3://bucket"}, nil); err == nil {
+		t.Logf("expected an error without a path")
+		t.Fail()
+	}
+}
+
+func TestUpload(t *testing.T) {
+	var uploaded []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, _ := r.BasicAuth(); r.Method != "PUT" || username != "ankh" || password != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		uploaded, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	defer os.Unsetenv("ARTIFACTS_PASSWORD")
+	os.Setenv("ARTIFACTS_PASSWORD", "secret")
+
+	config := ankh.ArtifactsConfig{Username: "ankh", PasswordEnv: "ARTIFACTS_PASSWORD"}
+	if err := Upload(config, server.URL+"/api.tar.gz", []byte("bundle")); err != nil || string(uploaded) != "bundle" {
+		t.Logf("expected the bundle to be uploaded but got %q and %v", uploaded, err)
+		t.Fail()
+	}
+	if err := Upload(ankh.ArtifactsConfig{}, server.URL+"/api.tar.gz", []byte("bundle")); err == nil {
+		t.Logf("expected the HTTP status to be reported")
+		t.Fail()
+	}
+	if err := Upload(ankh.ArtifactsConfig{}, "s3://bucket/api.tar.gz", []byte("bundle")); err == nil {
+		t.Logf("expected an error for a URL that cannot be PUT to")
+		t.Fail()
+	}
+
+	tools := ankhtest.NewTools(t)
+	tools.Fake("aws", ankhtest.Rule{})
+	config = ankh.ArtifactsConfig{Command: []string{"aws", "s3", "cp", "-"}}
+	if err := Upload(config, "s3://bucket/api.tar.gz", []byte("bundle")); err != nil {
+		t.Fatal(err)
+	}
+	calls := tools.Calls("aws")
+	if len(calls) != 1 || calls[0].Stdin != "bundle" || calls[0].Args[len(calls[0].Args)-1] != "s3://bucket/api.tar.gz" {
+		t.Logf("expected the command to get the bundle and destination but got %+v", calls)
+		t.Fail()
+	}
+}
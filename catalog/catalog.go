@@ -0,0 +1,145 @@
+package catalog
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+)
+
+// The catalog is fetched at most once per run.
+var cache = map[string][]ankh.CatalogEntry{}
+
+// Parses a catalog, either a list of entries or an object with a `services`
+// list, in JSON or YAML. Entries without a chart use their name as the chart.
+func parseCatalog(body []byte) ([]ankh.CatalogEntry, error) {
+	entries := []ankh.CatalogEntry{}
+	if err := yaml.Unmarshal(body, &entries); err != nil {
+		wrapped := struct {
+			Services []ankh.CatalogEntry `yaml:"services"`
+		}{}
+		if err := yaml.Unmarshal(body, &wrapped); err != nil {
+			return nil, fmt.Errorf("Unable to parse the service catalog: %v", err)
+		}
+		entries = wrapped.Services
+	}
+
+	valid := []ankh.CatalogEntry{}
+	for _, entry := range entries {
+		if entry.Name == "" {
+			continue
+		}
+		if entry.Chart == "" {
+			entry.Chart = entry.Name
+		}
+		valid = append(valid, entry)
+	}
+	sort.SliceStable(valid, func(i, j int) bool { return valid[i].Name < valid[j].Name })
+	return valid, nil
+}
+
+// Fetch gets the entries of the service catalog configured by `catalog`.
+func Fetch(ctx *ankh.ExecutionContext) ([]ankh.CatalogEntry, error) {
+	url := ctx.AnkhConfig.Catalog
+	if url == "" {
+		return nil, fmt.Errorf("No service catalog configured. Set `catalog` in your Ankh config.")
+	}
+	if entries, ok := cache[url]; ok {
+		return entries, nil
+	}
+
+	ctx.Logger.Debugf("downloading service catalog from %s", url)
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	client := &http.Client{
+		Transport: ctx.Tracer.Transport(tr),
+		Timeout:   time.Duration(5 * time.Second),
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("got an error %v when trying to call %v", err, url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Received HTTP status '%v' (code %v) when trying to call %s", resp.Status, resp.StatusCode, url)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := parseCatalog(body)
+	if err != nil {
+		return nil, err
+	}
+	cache[url] = entries
+	return entries, nil
+}
+
+// Find returns the catalog entry for a chart, if any.
+func Find(entries []ankh.CatalogEntry, chartName string) *ankh.CatalogEntry {
+	for i := range entries {
+		if entries[i].Chart == chartName {
+			return &entries[i]
+		}
+	}
+	return nil
+}
+
+// FindByName returns the catalog entry for a service, if any.
+func FindByName(entries []ankh.CatalogEntry, name string) *ankh.CatalogEntry {
+	for i := range entries {
+		if entries[i].Name == name {
+			return &entries[i]
+		}
+	}
+	return nil
+}
+
+// Table formats catalog entries for a prompt, with a header row.
+func Table(entries []ankh.CatalogEntry) []string {
+	buf := bytes.NewBufferString("")
+	w := tabwriter.NewWriter(buf, 0, 8, 8, ' ', 0)
+	fmt.Fprintf(w, "NAME\tCHART\tOWNER\tNAMESPACE\tDESCRIPTION\n")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", entry.Name, entry.Chart, entry.Owner, entry.Namespace, entry.Description)
+	}
+	w.Flush()
+	return strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+}
+
+// Annotate attaches catalog metadata to each chart that has a catalog entry,
+// so that notifications can refer to it.
+func Annotate(ctx *ankh.ExecutionContext, charts []ankh.Chart) {
+	if ctx.AnkhConfig.Catalog == "" {
+		return
+	}
+
+	entries, err := Fetch(ctx)
+	if err != nil {
+		ctx.Logger.Warnf("Unable to get the service catalog: %v", err)
+		return
+	}
+
+	for i := range charts {
+		if charts[i].CatalogEntry != nil {
+			continue
+		}
+		charts[i].CatalogEntry = Find(entries, charts[i].Name)
+		if charts[i].CatalogEntry != nil && charts[i].HelmRepository == "" {
+			charts[i].HelmRepository = charts[i].CatalogEntry.Repository
+		}
+	}
+}
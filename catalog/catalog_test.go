@@ -0,0 +1,66 @@
+package catalog
+
+import (
+	"testing"
+)
+
+func TestParseCatalog(t *testing.T) {
+	t.Run("json list", func(t *testing.T) {
+		entries, err := parseCatalog([]byte(`[
+			{"name": "zeta", "owner": "team-z", "namespace": "z"},
+			{"name": "alpha", "chart": "alpha-chart", "description": "The first service"},
+			{"owner": "nobody"}
+		]`))
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if len(entries) != 2 {
+			t.Logf("expected 2 entries but got %+v", entries)
+			t.FailNow()
+		}
+		if entries[0].Name != "alpha" || entries[0].Chart != "alpha-chart" {
+			t.Logf("expected alpha first, with its own chart, but got %+v", entries[0])
+			t.Fail()
+		}
+		if entries[1].Chart != "zeta" || entries[1].Owner != "team-z" || entries[1].Namespace != "z" {
+			t.Logf("expected zeta to default its chart to its name, but got %+v", entries[1])
+			t.Fail()
+		}
+	})
+
+	t.Run("yaml services object", func(t *testing.T) {
+		entries, err := parseCatalog([]byte("services:\n- name: foo\n  owner: team-foo\n  repository: https://charts.example.com\n"))
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if len(entries) != 1 || entries[0].Repository != "https://charts.example.com" {
+			t.Logf("unexpected entries %+v", entries)
+			t.Fail()
+		}
+	})
+
+	t.Run("garbage", func(t *testing.T) {
+		if _, err := parseCatalog([]byte("not: [a, catalog")); err == nil {
+			t.Log("expected an error")
+			t.Fail()
+		}
+	})
+}
+
+func TestFind(t *testing.T) {
+	entries, _ := parseCatalog([]byte(`[{"name": "foo", "chart": "foo-chart"}]`))
+	if entry := Find(entries, "foo-chart"); entry == nil || entry.Name != "foo" {
+		t.Logf("expected to find foo by its chart but got %+v", entry)
+		t.Fail()
+	}
+	if entry := Find(entries, "foo"); entry != nil {
+		t.Logf("expected no entry for chart foo but got %+v", entry)
+		t.Fail()
+	}
+	if entry := FindByName(entries, "foo"); entry == nil {
+		t.Log("expected to find foo by name")
+		t.Fail()
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 
@@ -36,10 +37,16 @@ func GetAnkhConfig(ctx *ankh.ExecutionContext, configPath string) (ankh.AnkhConf
 		}
 		body, err = ioutil.ReadAll(resp.Body)
 	} else {
+		fixConfigFile(ctx, configPath)
 		body, err = ioutil.ReadFile(configPath)
 	}
 	if err != nil {
-		return ankhConfig, fmt.Errorf("Unable to read ankh config '%s', consider using `ankh config init`: %v", configPath, err)
+		return ankhConfig, &ankh.ConfigDiagnostic{
+			Message: fmt.Sprintf("Unable to read ankh config '%s': %v", configPath, err),
+			Source:  configPath,
+			Hint:    "Run `ankh init` to create one interactively, or `ankh config init` for a sample",
+			Doc:     "#configuration",
+		}
 	}
 
 	if err := os.MkdirAll(ctx.DataDir, 0755); err != nil {
@@ -48,7 +55,12 @@ func GetAnkhConfig(ctx *ankh.ExecutionContext, configPath string) (ankh.AnkhConf
 
 	err = yaml.Unmarshal(body, &ankhConfig)
 	if err != nil {
-		return ankhConfig, fmt.Errorf("Error loading ankh config '%s': %v", configPath, err)
+		return ankhConfig, &ankh.ConfigDiagnostic{
+			Message: fmt.Sprintf("Error loading ankh config '%s': %v", configPath, err),
+			Source:  configPath,
+			Hint:    "Check the indentation and quoting near the line above. Values containing `:` or starting with `*`, `&` or `{` must be quoted",
+			Doc:     "#ankhconfig",
+		}
 	}
 
 	// Mark each context and environment as sourced from this configPath
@@ -66,13 +78,43 @@ func GetAnkhConfig(ctx *ankh.ExecutionContext, configPath string) (ankh.AnkhConf
 	return ankhConfig, nil
 }
 
-func GetAnkhConfigWithDefaults(ctx *ankh.ExecutionContext, configPath string) (ankh.AnkhConfig, error) {
-	ankhConfig, err := GetAnkhConfig(ctx, configPath)
+// Applies mechanical fixes to a local Ankh config with `--fix`, or otherwise
+// warns about the fixes that could be applied.
+func fixConfigFile(ctx *ankh.ExecutionContext, configPath string) {
+	if ctx.FixConfig {
+		fixes, err := FixConfigFile(configPath)
+		if err != nil {
+			ctx.Logger.Warnf("Unable to fix ankh config '%s': %v", configPath, err)
+			return
+		}
+		for _, fix := range fixes {
+			ctx.Logger.Infof("Fixed ankh config '%s' at %v", configPath, fix)
+		}
+		if len(fixes) > 0 {
+			ctx.Logger.Infof("The original ankh config was saved to '%s.bak'", configPath)
+		}
+		return
+	}
+
+	body, err := ioutil.ReadFile(configPath)
 	if err != nil {
-		return ankh.AnkhConfig{}, err
+		return
 	}
+	fixes := FindFixes(body)
+	if len(fixes) == 0 {
+		return
+	}
+	descriptions := []string{}
+	for _, fix := range fixes {
+		descriptions = append(descriptions, "  "+fix.String())
+	}
+	ctx.Logger.Warnf("Ankh config '%s' has %v deprecated or unused keys. Rerun with `ankh --fix ...` to correct them:\n%v",
+		configPath, len(fixes), strings.Join(descriptions, "\n"))
+}
 
-	// Set default helm and kubectl commands
+// SetDefaultCommands sets the helm and kubectl commands, which may be overridden
+// with ANKH_HELM_COMMAND and ANKH_KUBECTL_COMMAND.
+func SetDefaultCommands(ankhConfig *ankh.AnkhConfig) {
 	ankhConfig.Helm.Command = os.Getenv("ANKH_HELM_COMMAND")
 	if ankhConfig.Helm.Command == "" {
 		ankhConfig.Helm.Command = "helm"
@@ -82,6 +124,15 @@ func GetAnkhConfigWithDefaults(ctx *ankh.ExecutionContext, configPath string) (a
 	if ankhConfig.Kubectl.Command == "" {
 		ankhConfig.Kubectl.Command = "kubectl"
 	}
+}
+
+func GetAnkhConfigWithDefaults(ctx *ankh.ExecutionContext, configPath string) (ankh.AnkhConfig, error) {
+	ankhConfig, err := GetAnkhConfig(ctx, configPath)
+	if err != nil {
+		return ankh.AnkhConfig{}, err
+	}
+
+	SetDefaultCommands(&ankhConfig)
 
 	// Support the deprecated HelmRegistry as a backup alias for HelmRepository
 	if ankhConfig.Helm.Repository == "" && ankhConfig.Helm.RegistryUnused != "" {
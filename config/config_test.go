@@ -4,6 +4,8 @@ import (
 	"io/ioutil"
 	"testing"
 
+	"github.com/sirupsen/logrus"
+
 	"github.com/appnexus/ankh/context"
 )
 
@@ -15,6 +17,7 @@ func TestGetAnkhConfig(t *testing.T) {
 		ctx := &ankh.ExecutionContext{
 			AnkhConfigPath: minimalValidAnkhConfigYAMLPath,
 			DataDir:        tmpDir,
+			Logger:         logrus.New(),
 		}
 
 		_, err := GetAnkhConfig(ctx, ctx.AnkhConfigPath)
@@ -29,6 +32,7 @@ func TestGetAnkhConfig(t *testing.T) {
 		ctx := &ankh.ExecutionContext{
 			AnkhConfigPath: "/does/not/exist",
 			DataDir:        tmpDir,
+			Logger:         logrus.New(),
 		}
 
 		_, err := GetAnkhConfig(ctx, ctx.AnkhConfigPath)
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// A Fix is a mechanical correction to an Ankh config, eg: renaming a deprecated
+// key to its replacement.
+type Fix struct {
+	// The 1-based line of the key being fixed
+	Line int
+	// The dotted path of the key, eg: `contexts.prod.environment`
+	Key         string
+	Description string
+}
+
+func (f Fix) String() string {
+	return fmt.Sprintf("line %v, `%v`: %v", f.Line, f.Key, f.Description)
+}
+
+type fixRule struct {
+	// Path segments of the key, where `*` matches any single key
+	path []string
+	// The key to rename to, or empty to remove the key and everything under it
+	renameTo    string
+	description string
+}
+
+var fixRules = []fixRule{
+	{[]string{"contexts", "*", "environment"}, "environment-class", "`environment` is deprecated in favor of `environment-class`"},
+	{[]string{"contexts", "*", "cluster-admin"}, "", "`cluster-admin` is no longer used"},
+	{[]string{"helm", "registry"}, "repository", "`helm.registry` is deprecated in favor of `helm.repository`"},
+	{[]string{"helm", "tagValueName"}, "", "`helm.tagValueName` is no longer used, see `tagKey` on each chart"},
+	{[]string{"current-context"}, "", "`current-context` is no longer used, see `--context` and `--environment`"},
+	{[]string{"supported-environments"}, "", "`supported-environments` is no longer used"},
+	{[]string{"supported-environment-classes"}, "", "`supported-environment-classes` is no longer used"},
+	{[]string{"supported-resource-profiles"}, "", "`supported-resource-profiles` is no longer used"},
+}
+
+var (
+	keyLineRegexp     = regexp.MustCompile(`^(\s*)([A-Za-z0-9_.-]+):(\s|$)`)
+	blockScalarRegexp = regexp.MustCompile(`:\s*[|>][-+0-9]*\s*(#.*)?$`)
+)
+
+type configKey struct {
+	line   int // 0-based
+	indent int
+	path   []string
+	// The index of the line after the key and everything under it
+	end int
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+func isBlank(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || strings.HasPrefix(trimmed, "#")
+}
+
+// Scans the keys of a YAML document, line by line, so that fixes can be applied
+// without losing comments or formatting. Keys within lists and block scalars are
+// not scanned, since no fix applies to them.
+func scanKeys(lines []string) []configKey {
+	keys := []configKey{}
+	stack := []configKey{}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if isBlank(line) || strings.HasPrefix(strings.TrimSpace(line), "---") {
+			continue
+		}
+		indent := indentOf(line)
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+
+		path := []string{}
+		if len(stack) > 0 {
+			path = append(path, stack[len(stack)-1].path...)
+		}
+
+		// Keys under list items are nested under a `-` that no fix matches.
+		if strings.HasPrefix(strings.TrimSpace(line), "-") {
+			stack = append(stack, configKey{line: i, indent: indent, path: append(path, "-")})
+			continue
+		}
+
+		match := keyLineRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		key := configKey{line: i, indent: indent, path: append(path, match[2])}
+
+		// Everything more indented belongs to the key, as do list items at the
+		// same indent, eg: `key:\n- a\n- b`.
+		key.end = i + 1
+		for j := i + 1; j < len(lines); j++ {
+			if isBlank(lines[j]) {
+				continue
+			}
+			childIndent := indentOf(lines[j])
+			if childIndent > indent || (childIndent == indent && strings.HasPrefix(strings.TrimSpace(lines[j]), "- ")) {
+				key.end = j + 1
+			} else {
+				break
+			}
+		}
+
+		keys = append(keys, key)
+		if blockScalarRegexp.MatchString(line) {
+			i = key.end - 1
+			continue
+		}
+		stack = append(stack, key)
+	}
+	return keys
+}
+
+func matchesPath(pattern []string, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for i := range pattern {
+		if pattern[i] != "*" && pattern[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func hasSibling(keys []configKey, key configKey, name string) bool {
+	path := append(append([]string{}, key.path[:len(key.path)-1]...), name)
+	for _, other := range keys {
+		if strings.Join(other.path, "\x00") == strings.Join(path, "\x00") {
+			return true
+		}
+	}
+	return false
+}
+
+// FixConfig applies every mechanical fix to the body of an Ankh config, and
+// returns the fixed body along with the fixes applied. Renames are skipped
+// where the new key is already present, since both would then be set.
+func FixConfig(body []byte) ([]byte, []Fix) {
+	lines := strings.Split(string(body), "\n")
+	keys := scanKeys(lines)
+
+	fixes := []Fix{}
+	removed := make([]bool, len(lines))
+	for _, key := range keys {
+		for _, rule := range fixRules {
+			if !matchesPath(rule.path, key.path) || removed[key.line] {
+				continue
+			}
+			if rule.renameTo != "" && hasSibling(keys, key, rule.renameTo) {
+				continue
+			}
+
+			fixes = append(fixes, Fix{Line: key.line + 1, Key: strings.Join(key.path, "."), Description: rule.description})
+			if rule.renameTo != "" {
+				name := key.path[len(key.path)-1]
+				lines[key.line] = strings.Replace(lines[key.line], name+":", rule.renameTo+":", 1)
+			} else {
+				for i := key.line; i < key.end; i++ {
+					removed[i] = true
+				}
+			}
+		}
+	}
+
+	fixed := []string{}
+	for i, line := range lines {
+		if !removed[i] {
+			fixed = append(fixed, line)
+		}
+	}
+	return []byte(strings.Join(fixed, "\n")), fixes
+}
+
+// FindFixes returns the fixes that FixConfig would apply, without applying them.
+func FindFixes(body []byte) []Fix {
+	_, fixes := FixConfig(body)
+	return fixes
+}
+
+// FixConfigFile applies every mechanical fix to a local Ankh config, keeping
+// the original alongside it with a `.bak` suffix.
+func FixConfigFile(path string) ([]Fix, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fixed, fixes := FixConfig(body)
+	if len(fixes) == 0 {
+		return fixes, nil
+	}
+	if err := ioutil.WriteFile(path+".bak", body, info.Mode()); err != nil {
+		return nil, fmt.Errorf("Unable to back up %v before fixing it: %v", path, err)
+	}
+	return fixes, ioutil.WriteFile(path, fixed, info.Mode())
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+const deprecatedConfig string = `# My Ankh config
+supported-environments:
+- production
+- staging
+contexts:
+  prod:
+    kube-context: prod # the production cluster
+    environment: production
+    resource-profile: large
+    clusters:
+    - name: east
+      environment: not-a-context-key
+  staging:
+    environment: staging
+    environment-class: staging
+    resource-profile: small
+helm:
+  registry: https://charts.example.com
+  notes: |
+    environment: not a key
+`
+
+func TestFixConfig(t *testing.T) {
+	fixed, fixes := FixConfig([]byte(deprecatedConfig))
+
+	expected := `# My Ankh config
+contexts:
+  prod:
+    kube-context: prod # the production cluster
+    environment-class: production
+    resource-profile: large
+    clusters:
+    - name: east
+      environment: not-a-context-key
+  staging:
+    environment: staging
+    environment-class: staging
+    resource-profile: small
+helm:
+  repository: https://charts.example.com
+  notes: |
+    environment: not a key
+`
+	if string(fixed) != expected {
+		t.Logf("got unexpected fixed config:\n%v", string(fixed))
+		t.Fail()
+	}
+
+	keys := []string{}
+	for _, fix := range fixes {
+		keys = append(keys, fix.Key)
+	}
+	if strings.Join(keys, " ") != "supported-environments contexts.prod.environment helm.registry" {
+		t.Logf("got unexpected fixes %v", fixes)
+		t.Fail()
+	}
+	if fixes[1].Line != 8 {
+		t.Logf("expected the fix for %v on line 8 but got %v", fixes[1].Key, fixes[1].Line)
+		t.Fail()
+	}
+
+	if _, fixes := FixConfig(fixed); len(fixes) != 0 {
+		t.Logf("expected no fixes for a fixed config but got %v", fixes)
+		t.Fail()
+	}
+}
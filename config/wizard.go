@@ -0,0 +1,205 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/util"
+)
+
+const sampleAnkhFileFormat string = `# An Ankh file describes the charts to operate over. See README.md for the full schema.
+charts:
+  - name: %v
+    # Use a local chart directory...
+    path: helm/%v
+    # ...or a chart version from the helm repository instead.
+    # version: 0.0.1
+`
+
+const doneSelection string = "(done)"
+
+func getKubeContextNames(kubeConfigPath string) ([]string, error) {
+	body, err := ioutil.ReadFile(kubeConfigPath)
+	if err != nil {
+		return []string{}, err
+	}
+
+	kubeConfig := ankh.KubeConfig{}
+	if err := yaml.Unmarshal(body, &kubeConfig); err != nil {
+		return []string{}, fmt.Errorf("Could not parse kube config '%v': %v", kubeConfigPath, err)
+	}
+
+	names := []string{}
+	for _, context := range kubeConfig.Contexts {
+		names = append(names, context.Name)
+	}
+	return names, nil
+}
+
+func checkURL(ctx *ankh.ExecutionContext, url string, okStatusCodes ...int) error {
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		Timeout: time.Duration(5 * time.Second),
+	}
+	ctx.Logger.Debugf("Checking connectivity to %v", url)
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	for _, code := range okStatusCodes {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	return fmt.Errorf("Received HTTP status '%v' (code %v) when trying to call %v", resp.Status, resp.StatusCode, url)
+}
+
+func promptForContexts(ctx *ankh.ExecutionContext, ankhConfig *ankh.AnkhConfig) error {
+	kubeContexts, err := getKubeContextNames(ctx.KubeConfigPath)
+	if err != nil {
+		ctx.Logger.Warnf("Could not read kube contexts from %v, skipping context detection: %v", ctx.KubeConfigPath, err)
+		return nil
+	}
+
+	choices := []string{doneSelection}
+	for _, name := range kubeContexts {
+		if _, ok := ankhConfig.Contexts[name]; !ok {
+			choices = append(choices, name)
+		}
+	}
+	ctx.Logger.Infof("Found %d kube context(s) in %v not yet present in Ankh config", len(choices)-1, ctx.KubeConfigPath)
+
+	for len(choices) > 1 {
+		selection, err := util.PromptForSelection(choices, "Select a kube context to add as an Ankh context", false)
+		if err != nil {
+			return err
+		}
+		if selection == doneSelection {
+			break
+		}
+
+		environmentClass, err := util.PromptForInput("dev", fmt.Sprintf("Environment class for context '%v'", selection))
+		if err != nil {
+			return err
+		}
+		resourceProfile, err := util.PromptForInput("constrained", fmt.Sprintf("Resource profile for context '%v'", selection))
+		if err != nil {
+			return err
+		}
+		release, err := util.PromptForInput(selection, fmt.Sprintf("Release for context '%v'", selection))
+		if err != nil {
+			return err
+		}
+
+		if ankhConfig.Contexts == nil {
+			ankhConfig.Contexts = map[string]ankh.Context{}
+		}
+		ankhConfig.Contexts[selection] = ankh.Context{
+			KubeContext:      selection,
+			EnvironmentClass: environmentClass,
+			ResourceProfile:  resourceProfile,
+			Release:          release,
+		}
+		ctx.Logger.Infof("Added context \"%v\"", selection)
+
+		remaining := []string{}
+		for _, choice := range choices {
+			if choice != selection {
+				remaining = append(remaining, choice)
+			}
+		}
+		choices = remaining
+	}
+
+	return nil
+}
+
+// Wizard interactively fills in ankhConfig, starting from whatever it already contains.
+func Wizard(ctx *ankh.ExecutionContext, ankhConfig *ankh.AnkhConfig) error {
+	if err := promptForContexts(ctx, ankhConfig); err != nil {
+		return err
+	}
+
+	repository, err := util.PromptForInput(ankhConfig.Helm.Repository, "Helm chart repository URL (or nothing to skip)")
+	if err != nil {
+		return err
+	}
+	repository = strings.TrimSpace(repository)
+	if repository != "" {
+		indexURL := fmt.Sprintf("%s/index.yaml", strings.TrimRight(repository, "/"))
+		if err := checkURL(ctx, indexURL, http.StatusOK); err != nil {
+			ctx.Logger.Warnf("Could not reach helm repository '%v': %v", repository, err)
+		} else {
+			ctx.Logger.Infof("Helm repository '%v' is reachable", repository)
+		}
+		ankhConfig.Helm.Repository = repository
+	}
+
+	registry, err := util.PromptForInput(ankhConfig.Docker.Registry, "Docker registry (or nothing to skip)")
+	if err != nil {
+		return err
+	}
+	registry = strings.TrimSpace(registry)
+	if registry != "" {
+		registryURL := registry
+		if !strings.HasPrefix(registryURL, "http://") && !strings.HasPrefix(registryURL, "https://") {
+			registryURL = "https://" + registryURL
+		}
+		// An unauthenticated registry answers 200, an authenticated one 401. Either is reachable.
+		if err := checkURL(ctx, strings.TrimRight(registryURL, "/")+"/v2/", http.StatusOK, http.StatusUnauthorized); err != nil {
+			ctx.Logger.Warnf("Could not reach docker registry '%v': %v", registry, err)
+		} else {
+			ctx.Logger.Infof("Docker registry '%v' is reachable", registry)
+		}
+		ankhConfig.Docker.Registry = registry
+	}
+
+	if len(ankhConfig.Contexts) > 0 && len(ankhConfig.Environments) == 0 {
+		contexts := []string{}
+		for name, _ := range ankhConfig.Contexts {
+			contexts = append(contexts, name)
+		}
+		ankhConfig.Environments = map[string]ankh.Environment{}
+		for _, name := range contexts {
+			ankhConfig.Environments[name] = ankh.Environment{Contexts: []string{name}}
+		}
+		ctx.Logger.Infof("Initializing `environments` with one environment per context")
+	}
+
+	if !util.HasFzf() {
+		ctx.Logger.Infof("Tip: install fzf (https://github.com/junegunn/fzf) for fuzzy-searchable prompts")
+	}
+
+	return nil
+}
+
+// WriteSampleAnkhFile writes a starter Ankh file to ankhFilePath, unless one already exists.
+func WriteSampleAnkhFile(ctx *ankh.ExecutionContext, ankhFilePath string) error {
+	if _, err := os.Stat(ankhFilePath); err == nil {
+		ctx.Logger.Infof("Ankh file %v already exists, not writing a sample", ankhFilePath)
+		return nil
+	}
+
+	name, err := util.PromptForInput(util.GenerateName(ctx, ""), "Chart name for the sample Ankh file")
+	if err != nil {
+		return err
+	}
+
+	if err := util.WriteFileAtomic(ankhFilePath, []byte(fmt.Sprintf(sampleAnkhFileFormat, name, name)), 0644); err != nil {
+		return err
+	}
+	ctx.Logger.Infof("Wrote sample Ankh file %v", ankhFilePath)
+	return nil
+}
@@ -0,0 +1,44 @@
+package ankh
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ChartVersionPolicy limits the chart versions used in an environment or a
+// context, eg: to keep release candidates out of production.
+type ChartVersionPolicy struct {
+	// A version constraint, eg: `>=2.0.0, <3.0.0`
+	Constraint string `yaml:"constraint,omitempty"`
+	// When set, prerelease versions, eg: `1.2.0-rc.1`, are not allowed
+	Stable bool `yaml:"stable,omitempty"`
+}
+
+// ChartVersionPolicies returns the chart version policies that apply to the
+// current context: its own, and those of each environment that includes it,
+// by where each is declared, eg: `environment "production"`.
+func (ctx *ExecutionContext) ChartVersionPolicies() map[string]ChartVersionPolicy {
+	policies := map[string]ChartVersionPolicy{}
+	if policy := ctx.AnkhConfig.CurrentContext.ChartVersions; policy != nil {
+		policies[fmt.Sprintf("context \"%v\"", ctx.AnkhConfig.CurrentContextName)] = *policy
+	}
+
+	names := []string{}
+	for name := range ctx.AnkhConfig.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		environment := ctx.AnkhConfig.Environments[name]
+		if environment.ChartVersions == nil {
+			continue
+		}
+		for _, context := range environment.Contexts {
+			if context == ctx.AnkhConfig.CurrentContextName {
+				policies[fmt.Sprintf("environment \"%v\"", name)] = *environment.ChartVersions
+				break
+			}
+		}
+	}
+	return policies
+}
@@ -8,42 +8,105 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/trace"
 )
 
 type Mode string
 
 const (
-	Apply    Mode = "apply"
-	Explain  Mode = "explain"
-	Deploy   Mode = "deploy"
-	Rollback Mode = "rollback"
-	Diff     Mode = "diff"
-	Exec     Mode = "exec"
-	Get      Mode = "get"
-	Pods     Mode = "pods"
-	Lint     Mode = "lint"
-	Logs     Mode = "logs"
-	Template Mode = "template"
+	Apply       Mode = "apply"
+	Explain     Mode = "explain"
+	Deploy      Mode = "deploy"
+	Rollback    Mode = "rollback"
+	Diff        Mode = "diff"
+	Exec        Mode = "exec"
+	Get         Mode = "get"
+	Pods        Mode = "pods"
+	Lint        Mode = "lint"
+	Logs        Mode = "logs"
+	Template    Mode = "template"
+	Report      Mode = "report"
+	History     Mode = "history"
+	Delete      Mode = "delete"
+	Scale       Mode = "scale"
+	PortForward Mode = "port-forward"
 )
 
+var modes = []Mode{Apply, Explain, Deploy, Rollback, Diff, Exec, Get, Pods, Lint, Logs, Template, Report, History, Delete, Scale, PortForward}
+
 // Captures all of the context required to execute a single iteration of Ankh
 type ExecutionContext struct {
 	AnkhConfig AnkhConfig
 
 	AnkhFilePath string
 	Chart        string
+	Charts       []string
 	LocalChart   bool
 	Tag          *string
 	Namespace    *string
 
+	// Namespaces for single charts from `--namespace chart=namespace`, keyed
+	// by chart name or alias. These take precedence over Namespace.
+	ChartNamespaces map[string]string
+
 	Mode Mode
 
-	Verbose, Quiet, ShouldCatchSignals, CatchSignals, DryRun, Describe, WarnOnConfigError,
-	IgnoreContextAndEnv, IgnoreConfigErrors, SkipConfig, NoPrompt bool
+	Verbose, Quiet, ShouldCatchSignals, CatchSignals, DryRun, AdmissionPreview, Describe, WarnOnConfigError,
+	IgnoreContextAndEnv, IgnoreConfigErrors, SkipConfig, NoPrompt, SkipCrds, TagFromGit, Wait bool
+
+	// Whether to apply mechanical fixes to local Ankh configs, eg: renaming deprecated keys
+	FixConfig bool
+
+	// Whether to create the target namespace before applying, if it does not exist
+	CreateNamespace bool
+
+	// Whether an apply over several contexts skips the contexts that a failed
+	// run of the same command already succeeded on
+	Resume bool
+
+	// The machine-readable format for listings, `json` or `yaml`, or empty for tables
+	Output string
+
+	// How long `apply --wait` waits for each rollout, as a kubectl duration, eg: `5m`
+	WaitTimeout string
+
+	// Overrides for every rendered Deployment, from `--force-replicas` and
+	// `--force-max-unavailable`, eg: for an emergency scale-down
+	ForceReplicas, ForceMaxUnavailable string
+
+	// Set while `deploy` applies the canary of each chart that has one
+	Canary bool
+
+	// Set by `ankh plan`, which resolves everything that the mode would
+	// operate on, and describes its stages instead of running them
+	PlanOnly bool
+
+	// The revision for `rollback --to-revision`, or zero for the previous revision
+	RollbackRevision int
+
+	// What `scale` sets, each nil unless given: the replicas of Deployments and
+	// StatefulSets, and the bounds of HorizontalPodAutoscalers
+	ScaleReplicas, ScaleMinReplicas, ScaleMaxReplicas *int
+
+	// Whether `logs` and `exec` operate on every selected pod, instead of prompting for one
+	AllPods bool
+
+	// Whether `pods --watch` shows a live dashboard instead of kubectl's output
+	PodDashboard bool
+
+	// The ports for `port-forward`, each zero unless given. The local port
+	// defaults to the remote port.
+	LocalPort, RemotePort int
+
+	// Whether `port-forward` stops when kubectl exits, instead of reconnecting
+	NoReconnect bool
 
 	WorkingPath    string
 	AnkhConfigPath string
@@ -55,6 +118,20 @@ type ExecutionContext struct {
 	HelmSetValues  map[string]string
 	HelmDir        string
 
+	// Selects the contexts to operate on by their labels, from `--context-selector`
+	ContextSelector LabelSelector
+
+	// Selects the charts of Ankh files to operate on by their labels, from `--selector`
+	ChartSelector LabelSelector
+
+	// Values passed through to helm with `--set-string`, and files whose
+	// contents are passed with `--set-file`, by key
+	HelmSetStringValues map[string]string
+	HelmSetFiles        map[string]string
+
+	// The `.ankhproject` of the repository Ankh was run in, if any
+	Project *AnkhProject
+
 	DeploymentTag string
 
 	SlackChannel         string
@@ -64,13 +141,81 @@ type ExecutionContext struct {
 
 	Filters []string
 
+	// Narrow the rendered objects down further, by a regular expression on their
+	// names from `--filter-name`, and by their labels from `--filter-label`
+	FilterName   string
+	FilterLabels LabelSelector
+
 	ImageTagFilter     string
 	ChartVersionFilter string
 
 	ExtraArgs, PassThroughArgs []string
 
+	// Number of log lines to show for each failing container after watching pods during a deploy
+	FailedPodLogLines int
+
 	HelmVersion, KubectlVersion string
 
+	// The version of this build of Ankh
+	AnkhVersion string
+
+	// The objects last annotated for applying to a namespace, as they were applied
+	AppliedManifest string
+
+	// The values decrypted from charts' `secrets` during this run, which are
+	// redacted from the stages recorded to the data dir
+	SecretValues []string
+
+	DiffTool string
+
+	// Whether `ankh diff` only lists the objects that would change, from `--summary`
+	DiffSummary bool
+
+	// Who is operating, from `--deployer`, eg: the person behind a shared CI account
+	Deployer string
+
+	// Whether mutating commands are disabled, from `--read-only`
+	ReadOnly bool
+
+	// The post-renderer from `--post-renderer`, which takes precedence over `helm.postRenderer`
+	PostRenderer string
+
+	// Server-side apply options from the command line, which take precedence over `kubectl` config
+	ServerSide, ForceConflicts bool
+	FieldManager               string
+
+	// Whether to pin tags to digests, from `--resolve-digests`, which takes precedence over `docker.resolveDigests`
+	ResolveDigests bool
+
+	// Images found per chart, then per context, for `ankh report images`
+	ImageReport map[string]map[string]string
+
+	// What each chart was running before it was applied, by chart instance
+	// name, for the default slack message
+	PreviousReleases map[string]PreviousRelease
+
+	// Rendered helm template output, keyed by chart and values, reused across contexts
+	TemplateCache map[string]string
+
+	// The directory that `ankh template --export-dir` writes objects to,
+	// and the paths of the objects written so far in this run
+	ExportDir       string
+	ExportedObjects map[string]bool
+
+	// Errors found while templating charts for `ankh lint`, eg: values that
+	// violate `valuesConventions`, reported by the lint stage
+	LintErrors []error
+
+	// The warnings that `ankh lint` found so far, which make it exit with a
+	// status of its own once every chart is linted
+	LintWarnings int
+
+	// Values fetched from chart `valueSources`, keyed by source, reused across charts and contexts
+	ValueSourceCache map[string]string
+
+	TraceEndpoint, TraceFile string
+	Tracer                   *trace.Tracer
+
 	HelmV2 bool
 
 	Logger *logrus.Logger
@@ -83,38 +228,304 @@ type Context struct {
 	KubeContext           string                 `yaml:"kube-context,omitempty"`
 	KubeServer            string                 `yaml:"kube-server,omitempty"`
 	KubeConfig            string                 `yaml:"kube-config,omitempty"`
-	Environment           string                 `yaml:"environment,omitempty"` // deprecated in favor of `environment-class`
-	EnvironmentClass      string                 `yaml:"environment-class"`     // omitempty until we remove `environment`
+	KubeCAData            string                 `yaml:"kube-ca-data,omitempty"` // the base64 encoded CA bundle of `kube-server`
+	KubeExec              *KubeExecConfig        `yaml:"kube-exec,omitempty"`    // a credential plugin for `kube-server`, eg: for OIDC
+	Environment           string                 `yaml:"environment,omitempty"`  // deprecated in favor of `environment-class`
+	EnvironmentClass      string                 `yaml:"environment-class"`      // omitempty until we remove `environment`
 	ResourceProfile       string                 `yaml:"resource-profile"`
 	Release               string                 `yaml:"release,omitempty"`
 	HelmRegistryURLUnused string                 `yaml:"helm-registry-url,omitempty"`   // deprecated in favor of top-level config `helm.repository`
 	HelmRepositoryURL     string                 `yaml:"helm-repository-url,omitempty"` // deprecated in favor of top-level config `helm.repository`
 	ClusterAdminUnused    bool                   `yaml:"cluster-admin,omitempty"`       // deprecated
-	Global                map[string]interface{} `yaml:"global",omitempty"`
+	Global                map[string]interface{} `yaml:"global,omitempty"`
+	// When set, each operation on this context is repeated on every cluster.
+	Clusters []Cluster `yaml:"clusters,omitempty"`
+	// When set, kubectl reaches the context's clusters through a proxy or a bastion host.
+	Proxy *ProxyConfig `yaml:"proxy,omitempty"`
+	// Override `kubectl` and `helm` for this context, eg: for an older cluster
+	Kubectl ContextToolConfig `yaml:"kubectl,omitempty"`
+	Helm    ContextToolConfig `yaml:"helm,omitempty"`
+	// Overrides the severity of `ankh lint` rules for this context
+	Lint LintConfig `yaml:"lint,omitempty"`
+
+	// When set, apply and deploy create the target namespace if it does not exist, as with `--create-namespace`
+	AutoCreateNamespaces bool `yaml:"autoCreateNamespaces,omitempty"`
+	// The labels and annotations of the namespaces that Ankh creates
+	NamespaceMetadata NamespaceMetadata `yaml:"namespaceMetadata,omitempty"`
+
+	// Labels for selecting this context with `--context-selector`, eg: `region: us-east-1`
+	Labels map[string]string `yaml:"labels,omitempty"`
+
+	// Limits the chart versions used in this context, eg: to stable versions
+	ChartVersions *ChartVersionPolicy `yaml:"chartVersions,omitempty"`
+
+	// A kustomize component that patches everything rendered for this context, eg: `overlays/gpu`
+	KustomizeOverlay string `yaml:"kustomizeOverlay,omitempty"`
+}
+
+// Labels and annotations for a namespace, eg: `pod-security.kubernetes.io/enforce: restricted`
+// for pod security admission.
+type NamespaceMetadata struct {
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// The binary, and the versions of it, to use for a context.
+type ContextToolConfig struct {
+	Command string `yaml:"command,omitempty"`
+	// A version constraint, eg: `>=1.21.0, <1.22.0`
+	Version string `yaml:"version,omitempty"`
+}
+
+// ProxyConfig defines how kubectl reaches a context's clusters, when they are
+// only reachable through a proxy, or a tunnel through a bastion host.
+type ProxyConfig struct {
+	// Passed to kubectl as HTTPS_PROXY, eg: `http://proxy:3128`, or the
+	// `socks5://localhost:1080` that a tunnel listens on.
+	HTTPSProxy string `yaml:"https-proxy,omitempty"`
+	// A command that opens a tunnel, eg: `ssh -N -D 1080 bastion`. It runs in
+	// the background from the first kubectl call until Ankh exits.
+	Command string `yaml:"command,omitempty"`
+	// The host:port that the tunnel listens on, which must accept connections
+	// before kubectl runs. Defaults to the host and port of `https-proxy`.
+	Address string `yaml:"address,omitempty"`
+	// How long to wait for the tunnel, eg: 30s. Defaults to 10s.
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
+// ReadyAddress returns the host:port that the proxy's tunnel listens on.
+func (proxy *ProxyConfig) ReadyAddress() (string, error) {
+	if proxy.Address != "" {
+		return proxy.Address, nil
+	}
+	u, err := url.Parse(proxy.HTTPSProxy)
+	if err != nil || u.Host == "" || u.Port() == "" {
+		return "", fmt.Errorf("Cannot tell which address the tunnel listens on from `https-proxy` \"%v\"", proxy.HTTPSProxy)
+	}
+	return u.Host, nil
+}
+
+// WaitTimeout returns how long to wait for the proxy's tunnel.
+func (proxy *ProxyConfig) WaitTimeout() (time.Duration, error) {
+	if proxy.Timeout == "" {
+		return 10 * time.Second, nil
+	}
+	return time.ParseDuration(proxy.Timeout)
+}
+
+// A Cluster is one of several kube clusters backing a single context, eg: paired
+// clusters behind one VIP, which must all receive identical manifests.
+type Cluster struct {
+	Name        string          `yaml:"name"`
+	KubeContext string          `yaml:"kube-context,omitempty"`
+	KubeServer  string          `yaml:"kube-server,omitempty"`
+	KubeConfig  string          `yaml:"kube-config,omitempty"`
+	KubeCAData  string          `yaml:"kube-ca-data,omitempty"`
+	KubeExec    *KubeExecConfig `yaml:"kube-exec,omitempty"`
 }
 
 // An Environment is a collection of contexts over which operations should be applied
 type Environment struct {
 	Source   string   `yaml:"-"` // private field. specifies which config file declared this.
 	Contexts []string `yaml:"contexts"`
+	// Command defaults for this environment, which take precedence over the global `defaults`.
+	Defaults map[string]CommandDefaults `yaml:"defaults,omitempty"`
+	// Limits the chart versions used in each of the environment's contexts, eg: to stable versions
+	ChartVersions *ChartVersionPolicy `yaml:"chartVersions,omitempty"`
+}
+
+// CommandDefaults are options for a command, eg: `apply`, used when they are
+// not given on the command line or through `ANKH_*` environment variables.
+type CommandDefaults struct {
+	SlackChannel string            `yaml:"slack,omitempty"`
+	SlackMessage string            `yaml:"slackMessage,omitempty"`
+	JiraTicket   bool              `yaml:"jiraTicket,omitempty"`
+	Filters      []string          `yaml:"filter,omitempty"`
+	SkipCrds     bool              `yaml:"skipCrds,omitempty"`
+	Wait         bool              `yaml:"wait,omitempty"`
+	Timeout      string            `yaml:"timeout,omitempty"`
+	Set          map[string]string `yaml:"set,omitempty"`
 }
 
 type KubectlConfig struct {
-	Command        string   `yaml:"command,omitempty"`
-	WildCardLabels []string `yaml:"wildCardLabels,omitempty"`
+	Command string `yaml:"command,omitempty"`
+	// A version constraint for kubectl, eg: `>=1.21.0, <1.22.0`
+	Version        string             `yaml:"version,omitempty"`
+	WildCardLabels []string           `yaml:"wildCardLabels,omitempty"`
+	Retry          KubectlRetryConfig `yaml:"retry,omitempty"`
+	// Apply with `kubectl apply --server-side`, as FieldManager, or "ankh" by default
+	ServerSide     bool   `yaml:"serverSide,omitempty"`
+	FieldManager   string `yaml:"fieldManager,omitempty"`
+	ForceConflicts bool   `yaml:"forceConflicts,omitempty"`
+}
+
+// How kubectl commands are retried after transient API server errors.
+type KubectlRetryConfig struct {
+	// Including the first attempt. Set to 1 to disable retries.
+	MaxAttempts int `yaml:"maxAttempts,omitempty"`
+	// Durations, eg: `500ms` or `1m`
+	InitialBackoff string `yaml:"initialBackoff,omitempty"`
+	MaxBackoff     string `yaml:"maxBackoff,omitempty"`
 }
 
 type HelmConfig struct {
 	Command string `yaml:"command,omitempty"`
+	// A version constraint for helm, eg: `>=3.8.0`
+	Version string `yaml:"version,omitempty"`
 	// XXX TODO: Deprecate
 	TagValueNameUnused string `yaml:"tagValueName,omitempty"`
 	RegistryUnused     string `yaml:"registry,omitempty"`
 	Repository         string `yaml:"repository,omitempty"`
 	AuthType           string `yaml:"authType,omitempty"`
+	// Apply a ConfigMap alongside each chart recording how it was rendered
+	RecordRenders bool `yaml:"recordRenders,omitempty"`
+	// Named repositories, searched in order of priority when fetching charts
+	Repositories []HelmRepositoryConfig `yaml:"repositories,omitempty"`
+	// An executable that templated output is piped through before it is used, eg: kustomize
+	PostRenderer     string   `yaml:"postRenderer,omitempty"`
+	PostRendererArgs []string `yaml:"postRendererArgs,omitempty"`
+	// Annotate the pod templates of workloads with a checksum of the rendered ConfigMaps and Secrets they use
+	ConfigChecksums bool `yaml:"configChecksums,omitempty"`
+	// Verify the provenance file of every chart fetched from a chart
+	// repository against Keyring, with `helm verify`
+	Verify  bool   `yaml:"verify,omitempty"`
+	Keyring string `yaml:"keyring,omitempty"`
+}
+
+type HelmRepositoryConfig struct {
+	Name     string `yaml:"name"`
+	URL      string `yaml:"url"`
+	AuthType string `yaml:"authType,omitempty"`
+	// For "basic" auth. The password is read from the environment variable
+	// named by PasswordEnv, so that it is never kept in an Ankh config.
+	Username    string `yaml:"username,omitempty"`
+	PasswordEnv string `yaml:"passwordEnv,omitempty"`
+	// Repositories with a higher priority are searched first
+	Priority int `yaml:"priority,omitempty"`
+}
+
+type DiffConfig struct {
+	// An external diff program, passed to kubectl via KUBECTL_EXTERNAL_DIFF
+	Tool string `yaml:"tool,omitempty"`
+	// Fields left out of diffs, eg: those changed by mutating webhooks
+	Ignore []DiffIgnoreRule `yaml:"ignore,omitempty"`
+}
+
+// DiffIgnoreRule leaves fields out of `ankh diff`.
+type DiffIgnoreRule struct {
+	// Paths of fields to ignore, eg: `spec.replicas`,
+	// `metadata.annotations[sidecar.istio.io/status]`, or
+	// `spec.template.spec.containers[name=istio-proxy]`
+	Paths []string `yaml:"paths"`
+	// The kinds of objects the rule applies to, or every kind when empty
+	Kinds []string `yaml:"kinds,omitempty"`
+	// The contexts the rule applies to, or every context when empty
+	Contexts []string `yaml:"contexts,omitempty"`
+}
+
+// ArtifactsConfig is where a bundle of the rendered manifests of each chart,
+// and a summary of the run, is uploaded after each apply and deploy.
+type ArtifactsConfig struct {
+	// The path of each bundle, eg: `%CHART_NAME%/%CHART_VERSION%/%CONTEXT%/%TIMESTAMP%.tar.gz`
+	Path string `yaml:"path,omitempty"`
+	// Where bundles are uploaded to, with the path appended. Bundles are PUT to
+	// http(s) URLs, or else given to `command` along with the destination.
+	URL string `yaml:"url,omitempty"`
+	// HTTP headers to send, with environment variables expanded
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// For basic auth. The password is read from the environment variable named by PasswordEnv.
+	Username    string `yaml:"username,omitempty"`
+	PasswordEnv string `yaml:"passwordEnv,omitempty"`
+	// A command that reads each bundle from stdin and is given its destination
+	// as its last argument, eg: `aws s3 cp -` for an `s3://` URL
+	Command []string `yaml:"command,omitempty"`
+}
+
+type LedgerConfig struct {
+	// When set, each release is also recorded in a ConfigMap of this name, in the namespace of the release
+	ConfigMap string `yaml:"configMap,omitempty"`
+}
+
+// NotificationConfig is a notification sink, sent a release message after
+// each apply, deploy and rollback.
+type NotificationConfig struct {
+	// One of `webhook`, `teams` or `email`
+	Type string `yaml:"type"`
+	// The message for each chart, using the same variables as `slack.format`
+	Format         string `yaml:"format,omitempty"`
+	RollbackFormat string `yaml:"rollbackFormat,omitempty"`
+
+	// The URL to post to, for `webhook` and `teams`
+	URL string `yaml:"url,omitempty"`
+	// HTTP headers to send, for `webhook`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// The JSON body to post for each chart, for `webhook`. Variables, and
+	// %MESSAGE%, are substituted escaped for use in JSON strings.
+	Payload string `yaml:"payload,omitempty"`
+
+	// The SMTP server as host:port, for `email`
+	SMTPServer string   `yaml:"smtpServer,omitempty"`
+	From       string   `yaml:"from,omitempty"`
+	To         []string `yaml:"to,omitempty"`
+	Subject    string   `yaml:"subject,omitempty"`
+	// The SMTP username, and the environment variable holding its password, if the server requires authentication
+	Username    string `yaml:"username,omitempty"`
+	PasswordEnv string `yaml:"passwordEnv,omitempty"`
+}
+
+type TracingConfig struct {
+	// An OTLP/HTTP endpoint, eg: http://localhost:4318
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// A local file to write OTLP JSON encoded traces to
+	File string `yaml:"file,omitempty"`
+}
+
+// How listings are shown on a terminal
+type UIConfig struct {
+	// The command to page through long listings with. Defaults to `less -R`.
+	Pager   string `yaml:"pager,omitempty"`
+	NoPager bool   `yaml:"noPager,omitempty"`
+	NoColor bool   `yaml:"noColor,omitempty"`
+}
+
+type UpdateConfig struct {
+	// A release endpoint returning releases in the shape of the GitHub releases API
+	URL     string `yaml:"url,omitempty"`
+	Channel string `yaml:"channel,omitempty"`
+	// A base64 encoded ed25519 public key. When set, release checksums must be signed.
+	PublicKey string `yaml:"publicKey,omitempty"`
+	// For orgs that distribute their own builds
+	Disabled bool `yaml:"disabled,omitempty"`
 }
 
 type DockerConfig struct {
 	Registry string `yaml:"registry,omitempty"`
+	// Appended to tags taken from git, eg: `-dirty`, when the working tree has uncommitted changes
+	GitDirtySuffix string `yaml:"gitDirtySuffix,omitempty"`
+	// Registries that `ankh image promote` copies tags between
+	Promote DockerPromoteConfig `yaml:"promote,omitempty"`
+	// Pin each chart's tag to the digest it points to when templating, like `--resolve-digests`
+	ResolveDigests bool `yaml:"resolveDigests,omitempty"`
+	// Credentials for private registries, by registry host
+	Auth map[string]DockerRegistryAuth `yaml:"auth,omitempty"`
+}
+
+type DockerRegistryAuth struct {
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// The AWS CLI profile to exchange for an ECR token, when the registry is
+	// an ECR registry
+	AWSProfile string `yaml:"awsProfile,omitempty"`
+}
+
+type DockerPromoteConfig struct {
+	// The registry to copy tags from, eg: a staging registry
+	SourceRegistry string `yaml:"sourceRegistry,omitempty"`
+	// The registry to copy tags to. Defaults to `docker.registry`.
+	TargetRegistry string `yaml:"targetRegistry,omitempty"`
+	// Prompt for tags from the source registry when applying, and promote
+	// the selected tag before applying it
+	BeforeApply bool `yaml:"beforeApply,omitempty"`
 }
 
 type SlackConfig struct {
@@ -138,6 +549,10 @@ type JiraConfig struct {
 
 // AnkhConfig defines the shape of the ~/.ankh/config file used for global
 // configuration options
+// The version of the AnkhConfig schema understood by this build of Ankh. Bump it
+// on changes that older builds would misinterpret.
+const ConfigSchemaVersion = "1"
+
 type AnkhConfig struct {
 	Include                           []string               `yaml:"include,omitempty"`
 	Environments                      map[string]Environment `yaml:"environments"`
@@ -147,21 +562,75 @@ type AnkhConfig struct {
 	CurrentContextNameUnused          string                 `yaml:"current-context,omitempty"`               // deprecated
 	CurrentContextName                string                 `yaml:"-"`                                       // deprecated
 	CurrentContext                    Context                `yaml:"-"`                                       // deprecated TODO: RENAME TO UNUSED
+	CurrentClusterName                string                 `yaml:"-"`                                       // set while operating on one of the current context's clusters
 	Contexts                          map[string]Context     `yaml:"contexts"`
-
-	Kubectl KubectlConfig `yaml:"kubectl,omitempty"`
-	Helm    HelmConfig    `yaml:"helm,omitempty"`
-	Docker  DockerConfig  `yaml:"docker,omitempty"`
-	Slack   SlackConfig   `yaml:"slack,omitempty"`
-	Jira    JiraConfig    `yaml:"jira,omitempty"`
+	globalTools                       *globalTools
+
+	Kubectl   KubectlConfig   `yaml:"kubectl,omitempty"`
+	Helm      HelmConfig      `yaml:"helm,omitempty"`
+	Docker    DockerConfig    `yaml:"docker,omitempty"`
+	Slack     SlackConfig     `yaml:"slack,omitempty"`
+	Jira      JiraConfig      `yaml:"jira,omitempty"`
+	Diff      DiffConfig      `yaml:"diff,omitempty"`
+	Tracing   TracingConfig   `yaml:"tracing,omitempty"`
+	Update    UpdateConfig    `yaml:"update,omitempty"`
+	UI        UIConfig        `yaml:"ui,omitempty"`
+	Ledger    LedgerConfig    `yaml:"ledger,omitempty"`
+	Artifacts ArtifactsConfig `yaml:"artifacts,omitempty"`
+
+	// Notification sinks by name, used alongside, or instead of, Slack and JIRA
+	Notifications map[string]NotificationConfig `yaml:"notifications,omitempty"`
 
 	// List of namespace suggestions to use if the user does not provide one when required.
 	Namespaces []string `yaml:"namespaces,omitempty"`
+
+	// An HTTP endpoint listing the services that may be deployed, as CatalogEntry objects.
+	Catalog string `yaml:"catalog,omitempty"`
+
+	// Older versions of Ankh refuse to run mutating commands. The highest value across all included configs wins.
+	MinimumAnkhVersion string `yaml:"minimumAnkhVersion,omitempty"`
+
+	// Disables mutating commands, eg: for auditors or view-only dashboards. Set in any included config, it cannot be unset.
+	ReadOnly bool `yaml:"readOnly,omitempty"`
+
+	// Options for each command, by command name, used when not given on the command line.
+	Defaults map[string]CommandDefaults `yaml:"defaults,omitempty"`
+
+	// Conventions that `ankh lint` checks the merged values of each chart against.
+	ValuesConventions ValuesConventions `yaml:"valuesConventions,omitempty"`
+	// The severity of `ankh lint` rules, which contexts and charts may override
+	Lint LintConfig `yaml:"lint,omitempty"`
+}
+
+type ValuesConventions struct {
+	// The values key holding each chart's labels. Defaults to `labels`.
+	LabelsKey string `yaml:"labelsKey,omitempty"`
+	// Labels every chart must set, each to a value matching a regular
+	// expression, or to any value when the expression is empty
+	RequiredLabels map[string]string `yaml:"requiredLabels,omitempty"`
+	// Regular expressions that values must match when they are set, by dotted
+	// key, eg: `nameOverride`
+	NamingPatterns map[string]string `yaml:"namingPatterns,omitempty"`
+	// Registries that images in values may come from, eg: `registry.example.com`
+	AllowedRegistries []string `yaml:"allowedRegistries,omitempty"`
+}
+
+// LintConfig sets the severity of `ankh lint` rules by name, eg:
+// `pod-disruption-budget: error`. Each is one of `error`, `warning` or `off`.
+type LintConfig struct {
+	Rules map[string]string `yaml:"rules,omitempty"`
+}
+
+// IsSet returns whether any convention is configured.
+func (conventions ValuesConventions) IsSet() bool {
+	return len(conventions.RequiredLabels) > 0 || len(conventions.NamingPatterns) > 0 ||
+		len(conventions.AllowedRegistries) > 0
 }
 
 type KubeCluster struct {
 	Cluster struct {
-		Server string `yaml:"server"`
+		Server                   string `yaml:"server"`
+		CertificateAuthorityData string `yaml:"certificate-authority-data,omitempty"`
 	}
 	Name string `yaml:"name"`
 }
@@ -169,6 +638,14 @@ type KubeCluster struct {
 type KubeContext struct {
 	Context struct {
 		Cluster string `yaml:"cluster"`
+		User    string `yaml:"user,omitempty"`
+	}
+	Name string `yaml:"name"`
+}
+
+type KubeUser struct {
+	User struct {
+		Exec *KubeExecConfig `yaml:"exec,omitempty"`
 	}
 	Name string `yaml:"name"`
 }
@@ -178,40 +655,180 @@ type KubeConfig struct {
 	Kind                 string        `yaml:"kind"`
 	Clusters             []KubeCluster `yaml:"clusters"`
 	Contexts             []KubeContext `yaml:"contexts"`
+	Users                []KubeUser    `yaml:"users,omitempty"`
 	CurrentContextUnused string        `yaml:"current-context"` // for serialization purposes only
 }
 
-func (ctx *ExecutionContext) DetermineHelmRepository(preferredRepository *string) string {
+// ChartNamespaceOverride returns the namespace given for a chart on the
+// command line, by its alias first and then by its name, or else Namespace.
+// It returns nil when the namespace is not overridden.
+func (ctx *ExecutionContext) ChartNamespaceOverride(chart Chart) *string {
+	if namespace, ok := ctx.ChartNamespaces[chart.InstanceName()]; ok {
+		return &namespace
+	}
+	if namespace, ok := ctx.ChartNamespaces[chart.Name]; ok {
+		return &namespace
+	}
+	return ctx.Namespace
+}
+
+// HasObjectFilters reports whether the rendered objects are filtered at all,
+// by kind, name or labels.
+func (ctx *ExecutionContext) HasObjectFilters() bool {
+	return len(ctx.Filters) > 0 || ctx.FilterName != "" || ctx.FilterLabels != nil
+}
+
+// EffectiveRelease returns the release being operated on: the `--release`
+// argument, or else the release of each context being operated on, comma
+// separated. Empty if there is none.
+func (ctx *ExecutionContext) EffectiveRelease() string {
+	if ctx.Release != "" {
+		return ctx.Release
+	}
+
+	contexts := ctx.TargetContexts()
+	if len(contexts) == 0 {
+		contexts = []string{ctx.AnkhConfig.CurrentContextName}
+	}
+
+	releases := []string{}
+	seen := map[string]bool{}
+	for _, name := range contexts {
+		release := ctx.AnkhConfig.Contexts[name].Release
+		if name == ctx.AnkhConfig.CurrentContextName && ctx.AnkhConfig.CurrentContext.Release != "" {
+			release = ctx.AnkhConfig.CurrentContext.Release
+		}
+		if release != "" && !seen[release] {
+			seen[release] = true
+			releases = append(releases, release)
+		}
+	}
+	return strings.Join(releases, ",")
+}
+
+// Fills in options that were not given from defaults, and reports which.
+func (ctx *ExecutionContext) applyDefaults(defaults CommandDefaults, source string) {
+	if ctx.SlackChannel == "" && defaults.SlackChannel != "" {
+		ctx.Logger.Debugf("Using slack channel %v from %v", defaults.SlackChannel, source)
+		ctx.SlackChannel = defaults.SlackChannel
+	}
+	if ctx.SlackMessageOverride == "" && defaults.SlackMessage != "" {
+		ctx.Logger.Debugf("Using slack message from %v", source)
+		ctx.SlackMessageOverride = defaults.SlackMessage
+	}
+	if !ctx.CreateJiraTicket && defaults.JiraTicket {
+		ctx.Logger.Debugf("Creating a JIRA ticket based on %v", source)
+		ctx.CreateJiraTicket = true
+	}
+	if len(ctx.Filters) == 0 && len(defaults.Filters) > 0 {
+		ctx.Logger.Debugf("Using filters %v from %v", defaults.Filters, source)
+		ctx.Filters = defaults.Filters
+	}
+	if !ctx.SkipCrds && defaults.SkipCrds {
+		ctx.Logger.Debugf("Skipping CRDs based on %v", source)
+		ctx.SkipCrds = true
+	}
+	if !ctx.Wait && defaults.Wait {
+		ctx.Logger.Debugf("Waiting for rollouts based on %v", source)
+		ctx.Wait = true
+	}
+	if ctx.WaitTimeout == "" && defaults.Timeout != "" {
+		ctx.Logger.Debugf("Using rollout timeout %v from %v", defaults.Timeout, source)
+		ctx.WaitTimeout = defaults.Timeout
+	}
+	for key, value := range defaults.Set {
+		if _, ok := ctx.HelmSetValues[key]; !ok {
+			ctx.Logger.Debugf("Setting helm value %v from %v", key, source)
+			if ctx.HelmSetValues == nil {
+				ctx.HelmSetValues = make(map[string]string)
+			}
+			ctx.HelmSetValues[key] = value
+		}
+	}
+}
+
+// ApplyCommandDefaults fills in options of the current command that were not
+// given on the command line, first from the `defaults` of the current
+// environment, and then from the global `defaults`.
+func (ctx *ExecutionContext) ApplyCommandDefaults() {
+	known := map[string]bool{}
+	for _, mode := range modes {
+		known[string(mode)] = true
+	}
+	for command := range ctx.AnkhConfig.Defaults {
+		if !known[command] {
+			ctx.Logger.Warnf("Ignoring `defaults` for unknown command \"%v\"", command)
+		}
+	}
+
+	command := string(ctx.Mode)
+	if environment, ok := ctx.AnkhConfig.Environments[ctx.Environment]; ok && ctx.Environment != "" {
+		if defaults, ok := environment.Defaults[command]; ok {
+			ctx.applyDefaults(defaults, fmt.Sprintf("the `defaults` of environment %v", ctx.Environment))
+		}
+	}
+	if defaults, ok := ctx.AnkhConfig.Defaults[command]; ok {
+		ctx.applyDefaults(defaults, "the `defaults` in the Ankh config")
+	}
+}
+
+// HelmRepositories returns `helm.repositories` in the order they are searched,
+// ie: by descending priority, and in the order they are configured otherwise.
+func (ctx *ExecutionContext) HelmRepositories() []HelmRepositoryConfig {
+	repositories := append([]HelmRepositoryConfig{}, ctx.AnkhConfig.Helm.Repositories...)
+	sort.SliceStable(repositories, func(i, j int) bool {
+		return repositories[i].Priority > repositories[j].Priority
+	})
+	return repositories
+}
+
+// LookupHelmRepository finds a repository in `helm.repositories` by its name or URL.
+func (ctx *ExecutionContext) LookupHelmRepository(nameOrURL string) (HelmRepositoryConfig, bool) {
+	for _, config := range ctx.AnkhConfig.Helm.Repositories {
+		if config.Name == nameOrURL || strings.TrimRight(config.URL, "/") == strings.TrimRight(nameOrURL, "/") {
+			return config, true
+		}
+	}
+	return HelmRepositoryConfig{}, false
+}
+
+func (ctx *ExecutionContext) DetermineHelmRepository(preferredRepository *string) (string, error) {
 	// For commands that take command line arguments, the argument is the
 	// preferred value. For operations over charts, the chart-level override
 	// is the preferred value.
 	// TODO: Checking for empty string is a hack. Don't do that. Change chart.HelmRepository to a string* instead.
 	if preferredRepository != nil && *preferredRepository != "" {
-		return *preferredRepository
+		if config, ok := ctx.LookupHelmRepository(*preferredRepository); ok {
+			return config.URL, nil
+		}
+		return *preferredRepository, nil
 	}
 
 	repository := ctx.AnkhConfig.Helm.Repository
 	if repository != "" {
-		return repository
+		return repository, nil
+	}
+
+	if repositories := ctx.HelmRepositories(); len(repositories) > 0 {
+		return repositories[0].URL, nil
 	}
 
 	repository = ctx.AnkhConfig.CurrentContext.HelmRepositoryURL
 	if repository != "" {
 		ctx.Logger.Infof("Using repository \"%v\" taken from the current context "+
 			"\"%v\"", repository, ctx.AnkhConfig.CurrentContextName)
-		return repository
+		return repository, nil
 	}
 
 	repository = ctx.AnkhConfig.CurrentContext.HelmRegistryURLUnused
 	if repository != "" {
 		ctx.Logger.Infof("Using legacy registry config \"%v\" taken from the current context "+
 			"\"%v\"", repository, ctx.AnkhConfig.CurrentContextName)
-		return repository
+		return repository, nil
 	}
 
-	ctx.Logger.Fatalf("No helm repository configured. " +
+	return "", fmt.Errorf("No helm repository configured. " +
 		"Set `helm.repository` globally, pass it as an argument, or see README.md")
-	return ""
 }
 
 // This function is so bad
@@ -235,6 +852,212 @@ func useKubeConfig(ctx *ExecutionContext, currentContext *Context, name string,
 	return nil
 }
 
+// Points kubectl at the cluster of a context that uses `kube-server` or
+// `kube-config`. Contexts that only use `kube-context` need nothing more.
+func initKubeTarget(ctx *ExecutionContext, currentContext *Context) error {
+	if currentContext.KubeServer != "" {
+		kubeConfigBytes, err := yaml.Marshal(newServerKubeConfig(currentContext))
+		if err != nil {
+			return err
+		}
+
+		useKubeConfig(ctx, currentContext, serverKubeContextName, kubeConfigBytes)
+	} else if currentContext.KubeConfig != "" {
+		u, err := url.Parse(currentContext.KubeConfig)
+		if err != nil {
+			return fmt.Errorf("Could not parse current context kube-config '%v' as a URL: %v", currentContext.KubeConfig, err)
+		}
+
+		if u.Scheme == "http" || u.Scheme == "https" {
+			resp, err := http.Get(currentContext.KubeConfig)
+			if err != nil {
+				return fmt.Errorf("Unable to fetch ankh file from URL '%s': %v", currentContext.KubeConfig, err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != 200 {
+				return fmt.Errorf("Non-200 status code when fetching ankh file from URL '%s': %v", currentContext.KubeConfig, resp.Status)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			useKubeConfig(ctx, currentContext, currentContext.KubeContext, body)
+		} else {
+			ctx.KubeConfigPath = currentContext.KubeConfig
+		}
+	}
+	return nil
+}
+
+func validateProxy(contextName string, context Context) []error {
+	errors := []error{}
+	key := fmt.Sprintf("contexts.%v.proxy", contextName)
+	proxy := context.Proxy
+	if proxy.HTTPSProxy == "" && proxy.Command == "" {
+		errors = append(errors, &ConfigDiagnostic{
+			Message: fmt.Sprintf("The proxy of context '%s' needs an `https-proxy`, a `command`, or both", contextName),
+			Source:  context.Source,
+			Key:     key,
+			Hint:    "Use `https-proxy` for a proxy that is always reachable, and `command` to open a tunnel, eg: through a bastion host",
+			Doc:     "#proxyconfig",
+		})
+	}
+	if proxy.Command != "" {
+		if _, err := proxy.ReadyAddress(); err != nil {
+			errors = append(errors, &ConfigDiagnostic{
+				Message: fmt.Sprintf("The proxy of context '%s' has a `command` but no `address` to wait for", contextName),
+				Source:  context.Source,
+				Key:     key + ".address",
+				Hint:    "Set `address` to the host:port that the tunnel listens on, or an `https-proxy` with a port",
+				Doc:     "#proxyconfig",
+			})
+		}
+	}
+	if _, err := proxy.WaitTimeout(); err != nil {
+		errors = append(errors, &ConfigDiagnostic{
+			Message: fmt.Sprintf("The proxy of context '%s' has an invalid `timeout` \"%v\"", contextName, proxy.Timeout),
+			Source:  context.Source,
+			Key:     key + ".timeout",
+			Hint:    "Use a duration like 30s",
+			Doc:     "#proxyconfig",
+		})
+	}
+	return errors
+}
+
+func validateClusters(contextName string, context Context) []error {
+	errors := []error{}
+	key := fmt.Sprintf("contexts.%v", contextName)
+	if context.KubeContext != "" || context.KubeServer != "" || context.KubeConfig != "" {
+		errors = append(errors, &ConfigDiagnostic{
+			Message: fmt.Sprintf("Context '%s' has `clusters`, so `kube-context`, `kube-server` and `kube-config` belong on each cluster instead", contextName),
+			Source:  context.Source,
+			Key:     key,
+			Suggestion: fmt.Sprintf("contexts:\n  %v:\n    clusters:\n      - name: east\n        kube-context: %v\n",
+				contextName, orDefault(context.KubeContext, "<kube-context>")),
+			Doc: "#cluster",
+		})
+	}
+
+	names := map[string]bool{}
+	for i, cluster := range context.Clusters {
+		clusterKey := fmt.Sprintf("%v.clusters[%v]", key, i)
+		if cluster.Name == "" {
+			errors = append(errors, &ConfigDiagnostic{
+				Message: fmt.Sprintf("Cluster %v of context '%s' has a missing or empty `name`", i, contextName),
+				Source:  context.Source,
+				Key:     clusterKey,
+				Doc:     "#cluster",
+			})
+		} else if names[cluster.Name] {
+			errors = append(errors, &ConfigDiagnostic{
+				Message: fmt.Sprintf("Context '%s' has more than one cluster named '%s'", contextName, cluster.Name),
+				Source:  context.Source,
+				Key:     clusterKey,
+				Hint:    "Cluster names must be unique within a context",
+				Doc:     "#cluster",
+			})
+		}
+		names[cluster.Name] = true
+
+		if cluster.KubeContext == "" && cluster.KubeServer == "" {
+			errors = append(errors, &ConfigDiagnostic{
+				Message: fmt.Sprintf("Cluster '%s' of context '%s' has missing or empty `kube-context` or `kube-server`", cluster.Name, contextName),
+				Source:  context.Source,
+				Key:     clusterKey,
+				Hint:    "Use a context from `kubectl config get-contexts`, or the URL of the cluster's API server",
+				Doc:     "#cluster",
+			})
+		} else if cluster.KubeServer != "" && cluster.KubeConfig != "" {
+			errors = append(errors, &ConfigDiagnostic{
+				Message: fmt.Sprintf("Cluster '%s' of context '%s' cannot specify both `kube-server` and `kube-config`", cluster.Name, contextName),
+				Source:  context.Source,
+				Key:     clusterKey,
+				Hint:    "Remove one of them",
+				Doc:     "#cluster",
+			})
+		}
+		errors = append(errors, validateKubeAuth(clusterKey, context.Source, cluster.KubeServer, cluster.KubeCAData, cluster.KubeExec)...)
+	}
+	return errors
+}
+
+// UseCluster points the current context at one of its clusters.
+func (ankhConfig *AnkhConfig) UseCluster(ctx *ExecutionContext, cluster Cluster) error {
+	ankhConfig.CurrentClusterName = cluster.Name
+	ankhConfig.CurrentContext.KubeContext = cluster.KubeContext
+	ankhConfig.CurrentContext.KubeServer = cluster.KubeServer
+	ankhConfig.CurrentContext.KubeConfig = cluster.KubeConfig
+	ankhConfig.CurrentContext.KubeCAData = cluster.KubeCAData
+	ankhConfig.CurrentContext.KubeExec = cluster.KubeExec
+	return initKubeTarget(ctx, &ankhConfig.CurrentContext)
+}
+
+func validateHelmRepositories(repositories []HelmRepositoryConfig) []error {
+	errors := []error{}
+	names := map[string]bool{}
+	for i, repository := range repositories {
+		key := fmt.Sprintf("helm.repositories[%v]", i)
+		if repository.Name == "" || repository.URL == "" {
+			errors = append(errors, &ConfigDiagnostic{
+				Message:    fmt.Sprintf("Helm repository %v has a missing or empty `name` or `url`", i),
+				Key:        key,
+				Suggestion: "helm:\n  repositories:\n    - name: stable\n      url: https://charts.example.com/stable\n",
+				Doc:        "#helmrepositoryconfig",
+			})
+		} else if names[repository.Name] {
+			errors = append(errors, &ConfigDiagnostic{
+				Message: fmt.Sprintf("There is more than one helm repository named '%v'", repository.Name),
+				Key:     key,
+				Hint:    "Repository names must be unique, since charts refer to repositories by name",
+				Doc:     "#helmrepositoryconfig",
+			})
+		}
+		names[repository.Name] = true
+	}
+	return errors
+}
+
+// The `kubectl` and `helm` configured globally, before any context overrides them.
+type globalTools struct {
+	kubectl, helm ContextToolConfig
+}
+
+func overrideTool(global ContextToolConfig, override ContextToolConfig) ContextToolConfig {
+	if override.Command != "" {
+		global.Command = override.Command
+	}
+	if override.Version != "" {
+		global.Version = override.Version
+	}
+	return global
+}
+
+// useContextTools applies the context's `kubectl` and `helm` on top of the
+// global ones, and forgets the versions found for the previous binaries when
+// they change, so that each is checked again.
+func (ankhConfig *AnkhConfig) useContextTools(ctx *ExecutionContext, context Context) {
+	if ankhConfig.globalTools == nil {
+		ankhConfig.globalTools = &globalTools{
+			kubectl: ContextToolConfig{Command: ankhConfig.Kubectl.Command, Version: ankhConfig.Kubectl.Version},
+			helm:    ContextToolConfig{Command: ankhConfig.Helm.Command, Version: ankhConfig.Helm.Version},
+		}
+	}
+
+	kubectl := overrideTool(ankhConfig.globalTools.kubectl, context.Kubectl)
+	if kubectl.Command != ankhConfig.Kubectl.Command {
+		ctx.KubectlVersion = ""
+	}
+	ankhConfig.Kubectl.Command, ankhConfig.Kubectl.Version = kubectl.Command, kubectl.Version
+
+	helm := overrideTool(ankhConfig.globalTools.helm, context.Helm)
+	if helm.Command != ankhConfig.Helm.Command {
+		ctx.HelmVersion = ""
+		ctx.HelmV2 = false
+	}
+	ankhConfig.Helm.Command, ankhConfig.Helm.Version = helm.Command, helm.Version
+}
+
 // ValidateAndInit ensures the AnkhConfig is internally sane and populates
 // special fields if necessary.
 func (ankhConfig *AnkhConfig) ValidateAndInit(ctx *ExecutionContext, context string) []error {
@@ -243,87 +1066,87 @@ func (ankhConfig *AnkhConfig) ValidateAndInit(ctx *ExecutionContext, context str
 	if context != "" {
 		ankhConfig.CurrentContextName = context
 	}
+	ankhConfig.CurrentClusterName = ""
 
 	if ankhConfig.CurrentContextName == "" {
-		errors = append(errors, fmt.Errorf("Missing or empty `current-context`"))
+		errors = append(errors, &ConfigDiagnostic{
+			Message: "Missing or empty `current-context`",
+			Hint:    "Select a context with `ankh --context <name>`, or an environment with `ankh --environment <name>`",
+			Doc:     "#contexts",
+		})
 	}
 
 	selectedContext, contextExists := ankhConfig.Contexts[ankhConfig.CurrentContextName]
 	if contextExists == false {
-		errors = append(errors, fmt.Errorf("Context '%s' not found in `contexts`", ankhConfig.CurrentContextName))
+		errors = append(errors, contextNotFoundDiagnostic(ankhConfig, ankhConfig.CurrentContextName))
 	} else {
+		name := ankhConfig.CurrentContextName
+		key := fmt.Sprintf("contexts.%v", name)
+
 		// Environment (on the context) is deprecated, but we still use it if EnvironmentClass is missing.
 		if selectedContext.Environment != "" && selectedContext.EnvironmentClass == "" {
-			ctx.Logger.Warnf("Current context '%s' contains field `environment`, which has been deprecated in favor of `environment-class`", ankhConfig.CurrentContextName)
+			ctx.Logger.Warnf("Current context '%s' contains field `environment`, which has been deprecated in favor of `environment-class`. "+
+				"Rerun with `ankh --fix ...` to rename it in %v", name, orDefault(selectedContext.Source, "the Ankh config"))
 			selectedContext.EnvironmentClass = selectedContext.Environment
 		}
 
-		if selectedContext.KubeContext == "" && selectedContext.KubeServer == "" {
-			errors = append(errors, fmt.Errorf("Current context '%s' has missing or empty `kube-context` or `kube-server`", ankhConfig.CurrentContextName))
+		if len(selectedContext.Clusters) > 0 {
+			errors = append(errors, validateClusters(name, selectedContext)...)
+		} else if selectedContext.KubeContext == "" && selectedContext.KubeServer == "" {
+			errors = append(errors, &ConfigDiagnostic{
+				Message:    fmt.Sprintf("Current context '%s' has missing or empty `kube-context` or `kube-server`", name),
+				Source:     selectedContext.Source,
+				Key:        key,
+				Hint:       "Use a context from `kubectl config get-contexts`, or the URL of the cluster's API server with `kube-server`",
+				Suggestion: contextSnippet(name, "kube-context", "<kube-context>"),
+				Doc:        "#contexts",
+			})
 		} else if selectedContext.KubeServer != "" && selectedContext.KubeConfig != "" {
-			errors = append(errors, fmt.Errorf("Cannot specify both `kube-server` and `kube-config`"))
-		} else if selectedContext.KubeServer != "" {
-			kubeCluster := KubeCluster{
-				Cluster: struct {
-					Server string `yaml:"server"`
-				}{Server: selectedContext.KubeServer},
-				Name: "_kcluster",
-			}
-			kubeContext := KubeContext{
-				Context: struct {
-					Cluster string `yaml:"cluster"`
-				}{Cluster: kubeCluster.Name},
-				Name: "_kctx",
-			}
-			kubeConfig := &KubeConfig{
-				ApiVersion:           "v1",
-				Kind:                 "Config",
-				Clusters:             []KubeCluster{kubeCluster},
-				Contexts:             []KubeContext{kubeContext},
-				CurrentContextUnused: kubeContext.Name,
-			}
-
-			kubeConfigBytes, err := yaml.Marshal(kubeConfig)
-			if err != nil {
-				return []error{err}
-			}
-
-			useKubeConfig(ctx, &selectedContext, kubeContext.Name, kubeConfigBytes)
-		} else if selectedContext.KubeConfig != "" {
-			u, err := url.Parse(selectedContext.KubeConfig)
-			if err != nil {
-				return []error{fmt.Errorf("Could not parse current context kube-config '%v' as a URL: %v", selectedContext.KubeConfig, err)}
-			}
+			errors = append(errors, &ConfigDiagnostic{
+				Message: "Cannot specify both `kube-server` and `kube-config`",
+				Source:  selectedContext.Source,
+				Key:     key,
+				Hint:    "Remove one of them. `kube-server` generates a kube config for the server, while `kube-config` uses an existing one",
+				Doc:     "#context",
+			})
+		} else if authErrors := validateKubeAuth(key, selectedContext.Source, selectedContext.KubeServer,
+			selectedContext.KubeCAData, selectedContext.KubeExec); len(authErrors) > 0 {
+			errors = append(errors, authErrors...)
+		} else if err := initKubeTarget(ctx, &selectedContext); err != nil {
+			return []error{err}
+		}
 
-			if u.Scheme == "http" || u.Scheme == "https" {
-				resp, err := http.Get(selectedContext.KubeConfig)
-				if err != nil {
-					return []error{fmt.Errorf("Unable to fetch ankh file from URL '%s': %v", selectedContext.KubeConfig, err)}
-				}
-				defer resp.Body.Close()
-				if resp.StatusCode != 200 {
-					return []error{fmt.Errorf("Non-200 status code when fetching ankh file from URL '%s': %v", selectedContext.KubeConfig, resp.Status)}
-				}
-				body, err := ioutil.ReadAll(resp.Body)
-				if err != nil {
-					return []error{err}
-				}
-				useKubeConfig(ctx, &selectedContext, selectedContext.KubeContext, body)
-			} else {
-				ctx.KubeConfigPath = selectedContext.KubeConfig
-			}
+		if selectedContext.Proxy != nil {
+			errors = append(errors, validateProxy(name, selectedContext)...)
 		}
 
 		if selectedContext.EnvironmentClass == "" {
-			errors = append(errors, fmt.Errorf("Current context '%s' has missing or empty `environment-class`", ankhConfig.CurrentContextName))
+			errors = append(errors, &ConfigDiagnostic{
+				Message:    fmt.Sprintf("Current context '%s' has missing or empty `environment-class`", name),
+				Source:     selectedContext.Source,
+				Key:        key + ".environment-class",
+				Hint:       otherContextValues(ankhConfig, func(c Context) string { return c.EnvironmentClass }),
+				Suggestion: contextSnippet(name, "environment-class", "<environment-class>"),
+				Doc:        "#contexts",
+			})
 		}
 
 		if selectedContext.ResourceProfile == "" {
-			errors = append(errors, fmt.Errorf("Current context '%s' has missing or empty `resource-profile`", ankhConfig.CurrentContextName))
+			errors = append(errors, &ConfigDiagnostic{
+				Message:    fmt.Sprintf("Current context '%s' has missing or empty `resource-profile`", name),
+				Source:     selectedContext.Source,
+				Key:        key + ".resource-profile",
+				Hint:       otherContextValues(ankhConfig, func(c Context) string { return c.ResourceProfile }),
+				Suggestion: contextSnippet(name, "resource-profile", "<resource-profile>"),
+				Doc:        "#contexts",
+			})
 		}
 	}
 
+	errors = append(errors, validateHelmRepositories(ankhConfig.Helm.Repositories)...)
+
 	ankhConfig.CurrentContext = selectedContext
+	ankhConfig.useContextTools(ctx, selectedContext)
 	if ctx.Release != "" {
 		if ankhConfig.CurrentContext.Release != "" {
 			ctx.Logger.Warnf("Overriding existing release \"%v\" to release argument \"%v\" from command line for context \"%v\"", ankhConfig.CurrentContext.Release, ctx.Release, ankhConfig.CurrentContextName)
@@ -340,12 +1163,65 @@ type ConfigMeta struct {
 	Paths map[string]string `yaml:"paths"`
 }
 
+// DeployMeta configures how `deploy` rolls out a chart.
+type DeployMeta struct {
+	Canary *CanaryConfig `yaml:"canary"`
+}
+
+// CanaryConfig configures the canary phase of `deploy`, where the chart is
+// applied with fewer replicas, or with overlay values, and checked, before
+// the full rollout.
+type CanaryConfig struct {
+	// The number of replicas of each Deployment and StatefulSet, if set
+	Replicas *int `yaml:"replicas"`
+
+	// A values file within the chart, eg: `values-canary.yaml`, used on top
+	// of all other values
+	Values string `yaml:"values"`
+
+	// An HTTP endpoint that must respond with a 2xx status before proceeding.
+	// `${ANKH_NAMESPACE}` and `${ANKH_CONTEXT}` are replaced.
+	HealthCheckURL string `yaml:"healthCheckUrl"`
+
+	// How long to wait for the health check to pass, eg: `2m`. Defaults to 1m.
+	HealthCheckTimeout string `yaml:"healthCheckTimeout"`
+}
+
+// HooksMeta lists commands to run before or after a chart is applied or
+// rolled back, eg: to run database migrations. Each is run with `sh -c`, with
+// the chart's context, tag and namespace in `ANKH_*` environment variables.
+type HooksMeta struct {
+	PreApply     []string `yaml:"preApply,omitempty"`
+	PostApply    []string `yaml:"postApply,omitempty"`
+	PostRollback []string `yaml:"postRollback,omitempty"`
+}
+
+// Tag policies, for charts whose tag value should not be prompted for.
+const (
+	TagPolicyGitSha = "git-sha"
+)
+
+// PreviousRelease is the chart version and tag of a chart's live workloads,
+// before they are changed.
+type PreviousRelease struct {
+	ChartVersion string
+	Tag          string
+}
+
 type ChartMeta struct {
 	Namespace      *string    `yaml:"namespace"`
 	TagImage       string     `yaml:"tagImage"`
 	TagKey         string     `yaml:"tagKey"`
+	TagPolicy      string     `yaml:"tagPolicy,omitempty"`
 	WildCardLabels *[]string  `yaml:"wildCardLabels"`
 	ConfigMeta     ConfigMeta `yaml:"config"`
+	Deploy         DeployMeta `yaml:"deploy"`
+	Lint           LintConfig `yaml:"lint,omitempty"`
+	Hooks          HooksMeta  `yaml:"hooks,omitempty"`
+
+	// (private) set for charts of `type: library`, which only provide
+	// templates to other charts and are not rendered on their own.
+	Library bool `yaml:"-"`
 }
 
 type ChartFiles struct {
@@ -357,6 +1233,7 @@ type ChartFiles struct {
 	AnkhValuesPath           string
 	AnkhResourceProfilesPath string
 	AnkhReleasesPath         string
+	CrdsDir                  string
 }
 
 type Chart struct {
@@ -364,6 +1241,14 @@ type Chart struct {
 	Name    string
 	Version string
 	Tag     *string
+	// Deploys the chart under another name, so that one chart can be deployed
+	// several times within an Ankh file, each with its own values.
+	Alias string `yaml:"alias,omitempty"`
+	// Charts of the same Ankh file, by instance name, that are applied and
+	// ready before this one, eg: a database or CRDs
+	DependsOn []string `yaml:"dependsOn,omitempty"`
+	// Labels to select charts of an Ankh file with `--selector`, eg: `tier: backend`
+	Labels map[string]string `yaml:"labels,omitempty"`
 	// Overrides any global Helm registry
 	HelmRegistryUnused string
 	HelmRepository     string
@@ -374,8 +1259,123 @@ type Chart struct {
 	Values           yaml.MapSlice
 	ResourceProfiles yaml.MapSlice `yaml:"resource-profiles"`
 	Releases         yaml.MapSlice
+	// Values fetched at render time, from an HTTP endpoint or a command
+	ValueSources []ValueSource `yaml:"valueSources,omitempty"`
+	// Encrypted values, decrypted at render time
+	Secrets []SecretSource `yaml:"secrets,omitempty"`
+	// Template partials, by path or URL, added to the chart's templates before rendering
+	Partials []string `yaml:"partials,omitempty"`
 
 	Files *ChartFiles `yaml:"-"` // private, filled in by FetchChart
+
+	// The digest that the tag pointed to when it was resolved with `--resolve-digests`
+	ImageDigest string `yaml:"-"`
+
+	CatalogEntry *CatalogEntry `yaml:"-"` // private, filled in from the service catalog
+}
+
+// TagValue returns the value passed to helm for the chart's `tagKey`: its tag,
+// pinned to a digest, eg: `1.2.3@sha256:...`, if one was resolved. Empty
+// without a tag.
+func (chart Chart) TagValue() string {
+	if chart.Tag == nil {
+		return ""
+	}
+	if chart.ImageDigest != "" {
+		return *chart.Tag + "@" + chart.ImageDigest
+	}
+	return *chart.Tag
+}
+
+// InstanceName returns the name a chart is deployed under: its alias, if it has
+// one, or else its name.
+func (chart Chart) InstanceName() string {
+	if chart.Alias != "" {
+		return chart.Alias
+	}
+	return chart.Name
+}
+
+// ReleaseName returns the helm release name for a chart. Aliased charts get a
+// release of their own, so that each instance renders distinct resources.
+func (chart Chart) ReleaseName(release string) string {
+	if chart.Alias == "" {
+		return release
+	}
+	if release == "" {
+		return chart.Alias
+	}
+	return release + "-" + chart.Alias
+}
+
+// Matches a chart argument against the alias of a chart, or its name, which
+// selects every instance of that chart.
+func (chart Chart) matches(name string) bool {
+	return name == chart.InstanceName() || name == chart.Name
+}
+
+// ValidateChartInstances checks that every chart in an Ankh file is deployed
+// under a distinct name, so that chart arguments, tags and releases refer to
+// exactly one instance. A chart may only be listed more than once with aliases.
+func ValidateChartInstances(ankhFile AnkhFile) error {
+	seen := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, chart := range ankhFile.Charts {
+		name := chart.InstanceName()
+		if seen[name] {
+			if chart.Alias != "" {
+				return fmt.Errorf("Alias \"%v\" is used by more than one chart", name)
+			}
+			return fmt.Errorf("Chart \"%v\" is listed more than once. Set `alias` on each chart to deploy it several times", name)
+		}
+		seen[name] = true
+		names[chart.Name] = true
+	}
+
+	// An alias that is also the name of another chart would select both.
+	for _, chart := range ankhFile.Charts {
+		if chart.Alias != "" && chart.Alias != chart.Name && names[chart.Alias] {
+			return fmt.Errorf("Alias \"%v\" of chart \"%v\" is also the name of another chart", chart.Alias, chart.Name)
+		}
+	}
+	return nil
+}
+
+// A ValueSource provides helm values at render time, from exactly one of an
+// HTTP endpoint or a command.
+type ValueSource struct {
+	// The helm value to set, eg: `ingress.certArn`. Required for the `string`
+	// format, and not allowed for `yaml`, whose values are merged at the root.
+	Key    string   `yaml:"key,omitempty"`
+	HTTP   string   `yaml:"http,omitempty"`
+	Exec   []string `yaml:"exec,omitempty"`
+	Format string   `yaml:"format,omitempty"` // `string` (the default) or `yaml`
+	// Skips verifying the TLS certificate of the `http` endpoint
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify,omitempty"`
+}
+
+// A SecretSource provides encrypted helm values, from exactly one of a file
+// encrypted with sops, or a Vault KV path. Decrypted values are never written
+// to the data dir.
+type SecretSource struct {
+	// A YAML file encrypted with sops, relative to the Ankh file
+	Sops string `yaml:"sops,omitempty"`
+	// A Vault KV path, eg: `secret/myapp`, read with the vault CLI
+	Vault string `yaml:"vault,omitempty"`
+	// Optionally, the helm value to set the decrypted values under, eg:
+	// `database`. By default they are merged at the root.
+	Key string `yaml:"key,omitempty"`
+}
+
+// CatalogEntry describes a deployable service, as listed by the service catalog.
+type CatalogEntry struct {
+	Name        string `yaml:"name"`
+	Chart       string `yaml:"chart,omitempty"`
+	Owner       string `yaml:"owner,omitempty"`
+	Namespace   string `yaml:"namespace,omitempty"`
+	Description string `yaml:"description,omitempty"`
+	// Overrides any global Helm repository
+	Repository string `yaml:"repository,omitempty"`
 }
 
 // AnkhFile defines the shape of the `ankh.yaml` file which is used to define
@@ -390,6 +1390,61 @@ type AnkhFile struct {
 	Charts    []Chart
 
 	Dependencies []string `yaml:"dependencies"`
+
+	// How other Ankh files listed under the same `dependencies` refer to this
+	// one in their `dependsOn`. Defaults to its path, as listed.
+	Name string `yaml:"name,omitempty"`
+
+	// Other Ankh files listed under the same `dependencies`, by name, that are
+	// applied and ready before this one
+	DependsOn []string `yaml:"dependsOn,omitempty"`
+
+	// Template partials, by path or URL, added to every chart before
+	// rendering, eg: shared labels and annotations helpers.
+	Partials []string `yaml:"partials,omitempty"`
+
+	// Fragments of Ankh files, by path or URL, whose charts and dependencies
+	// come before this file's own.
+	Include []AnkhFileInclude `yaml:"include,omitempty"`
+
+	// The environments that `ankh pipeline run` promotes the charts through
+	Pipeline *Pipeline `yaml:"pipeline,omitempty"`
+}
+
+// An Ankh file path of "-" reads the Ankh file from stdin.
+const StdinAnkhFilePath = "-"
+
+// Stdin can only be consumed once, but the Ankh file may be parsed more than once per run.
+var stdinAnkhFileBody []byte
+
+func readStdinAnkhFile() ([]byte, error) {
+	if stdinAnkhFileBody != nil {
+		return stdinAnkhFileBody, nil
+	}
+
+	body, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read ankh file from stdin: %v", err)
+	}
+	stdinAnkhFileBody = body
+	return body, nil
+}
+
+// StdinAnkhFileBody returns the Ankh file read from stdin, if it has been read.
+func StdinAnkhFileBody() []byte {
+	return stdinAnkhFileBody
+}
+
+func mergePartials(shared []string, partials []string) []string {
+	var merged []string
+	seen := make(map[string]bool)
+	for _, partial := range append(append([]string{}, shared...), partials...) {
+		if !seen[partial] {
+			seen[partial] = true
+			merged = append(merged, partial)
+		}
+	}
+	return merged
 }
 
 func ParseAnkhFile(ankhFilePath string) (AnkhFile, error) {
@@ -400,7 +1455,9 @@ func ParseAnkhFile(ankhFilePath string) (AnkhFile, error) {
 	}
 
 	body := []byte{}
-	if u.Scheme == "http" || u.Scheme == "https" {
+	if ankhFilePath == StdinAnkhFilePath {
+		body, err = readStdinAnkhFile()
+	} else if u.Scheme == "http" || u.Scheme == "https" {
 		resp, err := http.Get(ankhFilePath)
 		if err != nil {
 			return ankhFile, fmt.Errorf("Unable to fetch ankh file from URL '%s': %v", ankhFilePath, err)
@@ -427,10 +1484,50 @@ func ParseAnkhFile(ankhFilePath string) (AnkhFile, error) {
 		return ankhFile, fmt.Errorf("Error loading Ankh file '%v': %v\nPlease refer to README.md for the correct schema of an Ankh file", ankhFilePath, err)
 	}
 
+	base := "."
+	if ankhFile.Path != "" {
+		base = filepath.Dir(ankhFile.Path)
+	} else if isRemoteURL(ankhFilePath) {
+		base = ankhFilePath
+	}
+	if err := resolveIncludes(&ankhFile, base, map[string]bool{}); err != nil {
+		return ankhFile, fmt.Errorf("Invalid Ankh file '%v': %v", ankhFilePath, err)
+	}
+
+	if err := ValidateChartInstances(ankhFile); err != nil {
+		return ankhFile, fmt.Errorf("Invalid Ankh file '%v': %v", ankhFilePath, err)
+	}
+
+	if ankhFile.Pipeline != nil {
+		if err := ankhFile.Pipeline.Validate(); err != nil {
+			return ankhFile, fmt.Errorf("Invalid Ankh file '%v': %v", ankhFilePath, err)
+		}
+	}
+
+	// Partials of the Ankh file come first, so that charts may override them.
+	for i := range ankhFile.Charts {
+		ankhFile.Charts[i].Partials = mergePartials(ankhFile.Partials, ankhFile.Charts[i].Partials)
+	}
+
 	return ankhFile, nil
 }
 
+// GetAnkhFile returns the Ankh file to operate on, with only the charts that
+// were selected, by `--chart` or `--selector`.
 func GetAnkhFile(ctx *ExecutionContext) (AnkhFile, error) {
+	ankhFile, err := getAnkhFile(ctx)
+	if err != nil {
+		return ankhFile, err
+	}
+	if ctx.AnkhFilePath == StdinAnkhFilePath && StdinAnkhFileBody() != nil {
+		// Stdin was consumed by the Ankh file, so nothing is left to answer prompts.
+		ctx.NoPrompt = true
+	}
+	SelectCharts(ctx, &ankhFile)
+	return ankhFile, nil
+}
+
+func getAnkhFile(ctx *ExecutionContext) (AnkhFile, error) {
 	if ctx.Chart == "" {
 		if ctx.AnkhFilePath == "" {
 			// No ankhfile.
@@ -449,10 +1546,95 @@ func GetAnkhFile(ctx *ExecutionContext) (AnkhFile, error) {
 		}
 	}
 
+	if len(ctx.Charts) > 1 && !ctx.LocalChart {
+		return getAnkhFileForCharts(ctx, ctx.Charts)
+	}
+
 	// We have a chart argument, which makes things more complicated.
 	return getAnkhFileForChart(ctx, ctx.Chart)
 }
 
+// Intersect the charts in the Ankh file with the given chart names, preserving the
+// Ankh file's ordering. Unlike a single chart argument, every name must be present
+// in the Ankh file.
+func getAnkhFileForCharts(ctx *ExecutionContext, names []string) (AnkhFile, error) {
+	if ctx.AnkhFilePath == "" {
+		return AnkhFile{}, fmt.Errorf("Multiple chart arguments %v require an Ankh file", names)
+	}
+
+	ctx.Logger.Infof("Reading Ankh file %v", ctx.AnkhFilePath)
+	ankhFile, err := ParseAnkhFile(ctx.AnkhFilePath)
+	if err != nil {
+		return ankhFile, err
+	}
+	ctx.Logger.Debugf("- OK: %v", ctx.AnkhFilePath)
+
+	versionOverrides := make(map[string]string)
+	for _, name := range names {
+		tokens := strings.Split(name, "@")
+		if len(tokens) > 2 {
+			return AnkhFile{}, fmt.Errorf("Invalid chart '%v'. Too many `@` characters found. Chart must either be a name with no `@`, or in the combined `name@version` format", name)
+		}
+		versionOverride := ""
+		if len(tokens) == 2 {
+			versionOverride = tokens[1]
+		}
+		if previous, ok := versionOverrides[tokens[0]]; ok {
+			if previous != "" && versionOverride != "" && previous != versionOverride {
+				return AnkhFile{}, fmt.Errorf("Chart \"%v\" was given more than once, with versions %v and %v", tokens[0], previous, versionOverride)
+			}
+			ctx.Logger.Warnf("Chart \"%v\" was given more than once, it will only be used once", tokens[0])
+			if versionOverride == "" {
+				continue
+			}
+		}
+		versionOverrides[tokens[0]] = versionOverride
+	}
+
+	charts := []Chart{}
+	found := make(map[string]bool)
+	for _, chart := range ankhFile.Charts {
+		matched := false
+		versionOverride := ""
+		for name, override := range versionOverrides {
+			if chart.matches(name) {
+				matched = true
+				found[name] = true
+				if override != "" && versionOverride != "" && override != versionOverride {
+					return AnkhFile{}, fmt.Errorf("Chart \"%v\" was selected more than once, with versions %v and %v", chart.InstanceName(), versionOverride, override)
+				}
+				if override != "" {
+					versionOverride = override
+				}
+			}
+		}
+		if !matched {
+			continue
+		}
+		if versionOverride != "" {
+			ctx.Logger.Infof("Using chart version %v for chart %v and overriding any existing `path` config", versionOverride, chart.InstanceName())
+			chart.Path = ""
+			chart.Version = versionOverride
+		}
+		charts = append(charts, chart)
+	}
+
+	if len(found) < len(versionOverrides) {
+		unknown := []string{}
+		for name, _ := range versionOverrides {
+			if !found[name] {
+				unknown = append(unknown, name)
+			}
+		}
+		sort.Strings(unknown)
+		return AnkhFile{}, fmt.Errorf("Chart(s) [ %v ] not found in Ankh file %v", strings.Join(unknown, ", "), ctx.AnkhFilePath)
+	}
+
+	ctx.Logger.Debugf("Truncating Charts array to %v", names)
+	ankhFile.Charts = charts
+	return ankhFile, nil
+}
+
 type HelmChart struct {
 	Name string
 }
@@ -493,7 +1675,7 @@ func getAnkhFileForChart(ctx *ExecutionContext, singleChart string) (AnkhFile, e
 		return ankhFile, nil
 	}
 
-	if _, err := os.Stat(ctx.AnkhFilePath); err == nil {
+	if _, err := os.Stat(ctx.AnkhFilePath); err == nil || ctx.AnkhFilePath == StdinAnkhFilePath {
 		ctx.Logger.Infof("Reading Ankh file %v", ctx.AnkhFilePath)
 		ankhFile, err = ParseAnkhFile(ctx.AnkhFilePath)
 		if err != nil {
@@ -507,30 +1689,39 @@ func getAnkhFileForChart(ctx *ExecutionContext, singleChart string) (AnkhFile, e
 	versionOverride := ""
 	tokens := strings.Split(singleChart, "@")
 	if len(tokens) > 2 {
-		ctx.Logger.Fatalf("Invalid chart '%v'. Too many `@` characters found. Chart must either be a name with no `@`, or in the combined `name@version` format", singleChart)
+		return ankhFile, fmt.Errorf("Invalid chart '%v'. Too many `@` characters found. Chart must either be a name with no `@`, or in the combined `name@version` format", singleChart)
 	}
 	if len(tokens) == 2 {
 		singleChart = tokens[0]
 		versionOverride = tokens[1]
 	}
 
-	// If we find that our chart arg matches a chart in the array,
-	// then that's the one and only chart we need to operate on.
-	// Replace the charts array with that singleton, and return.
+	// If we find that our chart arg matches charts in the array, then those are
+	// the only charts we need to operate on. That's a singleton, unless the arg
+	// names a chart that is deployed under several aliases.
+	// Replace the charts array with the matches, and return.
+	charts := []Chart{}
 	for _, chart := range ankhFile.Charts {
-		if singleChart == chart.Name {
-			ctx.Logger.Debugf("Truncating Charts array to the singleton %v", singleChart)
+		if chart.matches(singleChart) {
 			if versionOverride != "" {
 				ctx.Logger.Infof("Using chart version %v and overriding any existing `path` config", versionOverride)
-				newChart := chart
-				newChart.Path = ""
-				newChart.Version = versionOverride
-				ankhFile.Charts = []Chart{newChart}
-			} else {
-				ankhFile.Charts = []Chart{chart}
+				chart.Path = ""
+				chart.Version = versionOverride
 			}
-			return ankhFile, nil
+			charts = append(charts, chart)
+		}
+	}
+	if len(charts) > 1 {
+		instances := []string{}
+		for _, chart := range charts {
+			instances = append(instances, chart.InstanceName())
 		}
+		ctx.Logger.Infof("Chart \"%v\" is deployed under several aliases, using all of them: %v", singleChart, strings.Join(instances, ", "))
+	}
+	if len(charts) > 0 {
+		ctx.Logger.Debugf("Truncating Charts array to %v", singleChart)
+		ankhFile.Charts = charts
+		return ankhFile, nil
 	}
 
 	// The chart argument wasn't found in the charts array, so the user is attempting to operate
@@ -2,6 +2,8 @@ package ankh
 
 import (
 	"io/ioutil"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -189,6 +191,155 @@ func TestAnkhConfigValidateAndInit(t *testing.T) {
 			t.Fail()
 		}
 	})
+
+	t.Run("valid context with clusters", func(t *testing.T) {
+		ankhConfig := newValidAnkhConfig()
+
+		context := ankhConfig.Contexts["test"]
+		context.KubeContext = ""
+		context.Clusters = []Cluster{
+			Cluster{Name: "a", KubeContext: "dev-a"},
+			Cluster{Name: "b", KubeContext: "dev-b"},
+		}
+		ankhConfig.Contexts["test"] = context
+
+		errs := ankhConfig.ValidateAndInit(&ExecutionContext{Logger: log}, "")
+		if len(errs) > 0 {
+			t.Logf("got errors when trying to validate an AnkhConfig: %v", errs)
+			t.Fail()
+		}
+
+		if err := ankhConfig.UseCluster(&ExecutionContext{Logger: log}, context.Clusters[1]); err != nil {
+			t.Logf("got an error when switching clusters: %v", err)
+			t.Fail()
+		}
+		if ankhConfig.CurrentContext.KubeContext != "dev-b" || ankhConfig.CurrentClusterName != "b" {
+			t.Logf("expected to use cluster b but got kube-context '%v' and cluster '%v'",
+				ankhConfig.CurrentContext.KubeContext, ankhConfig.CurrentClusterName)
+			t.Fail()
+		}
+	})
+
+	t.Run("invalid clusters", func(t *testing.T) {
+		ankhConfig := newValidAnkhConfig()
+
+		// kube-context belongs on each cluster, not the context
+		context := ankhConfig.Contexts["test"]
+		context.Clusters = []Cluster{
+			Cluster{Name: "a", KubeContext: "dev-a"},
+			Cluster{Name: "a", KubeServer: "https://b"},
+			Cluster{KubeContext: "dev-c"},
+			Cluster{Name: "d"},
+		}
+		ankhConfig.Contexts["test"] = context
+
+		errs := ankhConfig.ValidateAndInit(&ExecutionContext{Logger: log}, "")
+		expected := []string{
+			"`kube-context`, `kube-server` and `kube-config` belong on each cluster",
+			"more than one cluster named 'a'",
+			"Cluster 2 of context 'test' has a missing or empty `name`",
+			"Cluster 'd' of context 'test' has missing or empty `kube-context`",
+		}
+		for _, e := range expected {
+			found := false
+			for _, err := range errs {
+				if strings.Contains(err.Error(), e) {
+					found = true
+				}
+			}
+			if !found {
+				t.Logf("was expecting to find '%v' in `errs`: %v", e, errs)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("invalid proxies", func(t *testing.T) {
+		for proxy, expected := range map[ProxyConfig]string{
+			ProxyConfig{}: "needs an `https-proxy`, a `command`, or both",
+			ProxyConfig{Command: "ssh -N -D 1080 bastion", HTTPSProxy: "socks5://localhost"}: "has a `command` but no `address`",
+			ProxyConfig{HTTPSProxy: "http://proxy:3128", Timeout: "soon"}:                    "invalid `timeout` \"soon\"",
+		} {
+			ankhConfig := newValidAnkhConfig()
+			context := ankhConfig.Contexts["test"]
+			proxy := proxy
+			context.Proxy = &proxy
+			ankhConfig.Contexts["test"] = context
+
+			errs := ankhConfig.ValidateAndInit(&ExecutionContext{Logger: log}, "")
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), expected) {
+				t.Logf("was expecting to find '%v' in `errs`: %v", expected, errs)
+				t.Fail()
+			}
+		}
+
+		ankhConfig := newValidAnkhConfig()
+		context := ankhConfig.Contexts["test"]
+		context.Proxy = &ProxyConfig{Command: "ssh -N -D 1080 bastion", HTTPSProxy: "socks5://localhost:1080"}
+		ankhConfig.Contexts["test"] = context
+		if errs := ankhConfig.ValidateAndInit(&ExecutionContext{Logger: log}, ""); len(errs) > 0 {
+			t.Logf("got errors when trying to validate a proxy: %v", errs)
+			t.Fail()
+		}
+	})
+
+	t.Run("context tools", func(t *testing.T) {
+		ankhConfig := newValidAnkhConfig()
+		ankhConfig.Kubectl = KubectlConfig{Command: "kubectl", Version: ">=1.27"}
+		ankhConfig.Helm = HelmConfig{Command: "helm"}
+		old := ankhConfig.Contexts["test"]
+		old.Kubectl = ContextToolConfig{Command: "kubectl-1.21", Version: ">=1.21, <1.22"}
+		ankhConfig.Contexts["old"] = old
+
+		ctx := &ExecutionContext{Logger: log, KubectlVersion: "v1.27.3", HelmVersion: "v3.12.0"}
+		ankhConfig.ValidateAndInit(ctx, "old")
+		if ankhConfig.Kubectl.Command != "kubectl-1.21" || ankhConfig.Kubectl.Version != ">=1.21, <1.22" || ctx.KubectlVersion != "" {
+			t.Logf("expected the context's kubectl, and its version to be checked again, but got %+v and %v", ankhConfig.Kubectl, ctx.KubectlVersion)
+			t.Fail()
+		}
+		if ankhConfig.Helm.Command != "helm" || ctx.HelmVersion != "v3.12.0" {
+			t.Logf("expected the global helm but got %+v and %v", ankhConfig.Helm, ctx.HelmVersion)
+			t.Fail()
+		}
+
+		ankhConfig.ValidateAndInit(ctx, "test")
+		if ankhConfig.Kubectl.Command != "kubectl" || ankhConfig.Kubectl.Version != ">=1.27" {
+			t.Logf("expected the global kubectl again for another context but got %+v", ankhConfig.Kubectl)
+			t.Fail()
+		}
+	})
+}
+
+func TestEffectiveRelease(t *testing.T) {
+	ankhConfig := newValidAnkhConfig()
+	blue := ankhConfig.Contexts["test"]
+	blue.Release = "blue"
+	ankhConfig.Contexts["blue"] = blue
+	green := ankhConfig.Contexts["test"]
+	green.Release = "green"
+	ankhConfig.Contexts["green"] = green
+	ankhConfig.Environments = map[string]Environment{
+		"prod": Environment{Contexts: []string{"blue", "green", "test"}},
+	}
+
+	ctx := &ExecutionContext{Logger: log, AnkhConfig: ankhConfig, Environment: "prod"}
+	if release := ctx.EffectiveRelease(); release != "blue,green" {
+		t.Logf("expected the releases of every context but got '%v'", release)
+		t.Fail()
+	}
+
+	ctx.Environment = ""
+	ctx.AnkhConfig.CurrentContextName = "green"
+	if release := ctx.EffectiveRelease(); release != "green" {
+		t.Logf("expected the release of the current context but got '%v'", release)
+		t.Fail()
+	}
+
+	ctx.Release = "canary"
+	if release := ctx.EffectiveRelease(); release != "canary" {
+		t.Logf("expected the release argument but got '%v'", release)
+		t.Fail()
+	}
 }
 
 func TestParseAnkhFile(t *testing.T) {
@@ -218,6 +369,47 @@ func TestParseAnkhFile(t *testing.T) {
 		}
 	})
 
+	t.Run("reads from stdin", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "")
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+		}
+		defer file.Close()
+
+		file.WriteString(minimalValidAnkhFileYAML)
+		file.Seek(0, 0)
+
+		stdin := os.Stdin
+		os.Stdin = file
+		defer func() { os.Stdin = stdin }()
+
+		ankhFile, err := ParseAnkhFile(StdinAnkhFilePath)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+		}
+
+		// Stdin is consumed once, and subsequent parses reuse what was read.
+		again, err := ParseAnkhFile(StdinAnkhFilePath)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+		}
+
+		if len(ankhFile.Charts) == 0 || len(again.Charts) != len(ankhFile.Charts) {
+			t.Logf("expected charts from stdin but got %+v and %+v", ankhFile, again)
+			t.Fail()
+		}
+
+		// Nothing is left on stdin to answer prompts.
+		ctx := &ExecutionContext{Logger: log, AnkhFilePath: StdinAnkhFilePath}
+		if _, err := GetAnkhFile(ctx); err != nil || !ctx.NoPrompt {
+			t.Logf("expected prompts to be disabled after reading stdin, got %v", err)
+			t.Fail()
+		}
+	})
+
 	t.Run("adds Path", func(t *testing.T) {
 		file, err := ioutil.TempFile("", "")
 		if err != nil {
@@ -241,4 +433,306 @@ func TestParseAnkhFile(t *testing.T) {
 
 	})
 
+	t.Run("adds partials to each chart", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "")
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+		}
+		defer file.Close()
+
+		file.WriteString(`
+partials:
+  - partials/_labels.tpl
+charts:
+  - name: foo
+    version: 0.0.1
+  - name: bar
+    version: 0.0.2
+    partials:
+      - partials/_labels.tpl
+      - bar/_helpers.tpl
+`)
+
+		ankhFile, err := ParseAnkhFile(file.Name())
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+		}
+
+		if !reflect.DeepEqual(ankhFile.Charts[0].Partials, []string{"partials/_labels.tpl"}) ||
+			!reflect.DeepEqual(ankhFile.Charts[1].Partials, []string{"partials/_labels.tpl", "bar/_helpers.tpl"}) {
+			t.Logf("expected the shared partials first, once, but got %+v", ankhFile.Charts)
+			t.Fail()
+		}
+	})
+
+}
+
+const multiChartAnkhFileYAML string = `
+charts:
+  - name: foo
+    version: 0.0.1
+  - name: bar
+    version: 0.0.2
+  - name: baz
+    path: ./baz
+`
+
+func TestGetAnkhFileForCharts(t *testing.T) {
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.WriteString(multiChartAnkhFileYAML)
+
+	t.Run("intersects charts in ankh file order", func(t *testing.T) {
+		ctx := &ExecutionContext{Logger: log, AnkhFilePath: file.Name(), Chart: "baz", Charts: []string{"baz", "foo@1.0.0"}}
+		ankhFile, err := GetAnkhFile(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(ankhFile.Charts) != 2 || ankhFile.Charts[0].Name != "foo" || ankhFile.Charts[1].Name != "baz" {
+			t.Logf("expected charts [foo baz] but got %+v", ankhFile.Charts)
+			t.Fail()
+		}
+
+		if ankhFile.Charts[0].Version != "1.0.0" {
+			t.Logf("expected version override '1.0.0' but got '%v'", ankhFile.Charts[0].Version)
+			t.Fail()
+		}
+	})
+
+	t.Run("unknown chart", func(t *testing.T) {
+		ctx := &ExecutionContext{Logger: log, AnkhFilePath: file.Name(), Chart: "foo", Charts: []string{"foo", "nope"}}
+		_, err := GetAnkhFile(ctx)
+		if err == nil || !strings.Contains(err.Error(), "nope") {
+			t.Logf("expected an error about chart 'nope' but got %v", err)
+			t.Fail()
+		}
+	})
+}
+
+const aliasedChartsAnkhFileYAML string = `
+charts:
+  - name: queue-consumer
+    alias: orders-consumer
+    version: 1.0.0
+  - name: queue-consumer
+    alias: billing-consumer
+    version: 1.0.0
+  - name: bar
+    version: 0.0.2
+`
+
+func TestChartAliases(t *testing.T) {
+	t.Run("release names", func(t *testing.T) {
+		chart := Chart{Name: "queue-consumer", Alias: "orders-consumer"}
+		if name := chart.InstanceName(); name != "orders-consumer" {
+			t.Logf("expected instance name 'orders-consumer' but got '%v'", name)
+			t.Fail()
+		}
+		if release := chart.ReleaseName("canary"); release != "canary-orders-consumer" {
+			t.Logf("expected release 'canary-orders-consumer' but got '%v'", release)
+			t.Fail()
+		}
+		if release := chart.ReleaseName(""); release != "orders-consumer" {
+			t.Logf("expected release 'orders-consumer' but got '%v'", release)
+			t.Fail()
+		}
+		if release := (Chart{Name: "bar"}).ReleaseName("canary"); release != "canary" {
+			t.Logf("expected release 'canary' but got '%v'", release)
+			t.Fail()
+		}
+	})
+
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.WriteString(aliasedChartsAnkhFileYAML)
+
+	t.Run("selects a chart by alias", func(t *testing.T) {
+		ctx := &ExecutionContext{Logger: log, AnkhFilePath: file.Name(), Chart: "billing-consumer"}
+		ankhFile, err := GetAnkhFile(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ankhFile.Charts) != 1 || ankhFile.Charts[0].Alias != "billing-consumer" {
+			t.Logf("expected chart billing-consumer but got %+v", ankhFile.Charts)
+			t.Fail()
+		}
+	})
+
+	t.Run("selects every instance by chart name", func(t *testing.T) {
+		ctx := &ExecutionContext{Logger: log, AnkhFilePath: file.Name(), Chart: "queue-consumer", Charts: []string{"queue-consumer", "bar"}}
+		ankhFile, err := GetAnkhFile(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ankhFile.Charts) != 3 {
+			t.Logf("expected all three charts but got %+v", ankhFile.Charts)
+			t.Fail()
+		}
+	})
+
+	t.Run("rejects duplicate instances", func(t *testing.T) {
+		err := ValidateChartInstances(AnkhFile{Charts: []Chart{
+			Chart{Name: "queue-consumer"},
+			Chart{Name: "queue-consumer"},
+		}})
+		if err == nil || !strings.Contains(err.Error(), "alias") {
+			t.Logf("expected an error suggesting an alias but got %v", err)
+			t.Fail()
+		}
+	})
+
+	t.Run("rejects an alias that names another chart", func(t *testing.T) {
+		err := ValidateChartInstances(AnkhFile{Charts: []Chart{
+			Chart{Name: "queue-consumer", Alias: "bar"},
+			Chart{Name: "bar"},
+		}})
+		if err == nil {
+			t.Logf("expected an error for an alias shared with another chart's name")
+			t.Fail()
+		}
+	})
+
+	t.Run("ignores repeated chart arguments", func(t *testing.T) {
+		ctx := &ExecutionContext{Logger: log, AnkhFilePath: file.Name(), Chart: "bar", Charts: []string{"bar", "bar@1.0.0"}}
+		ankhFile, err := GetAnkhFile(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ankhFile.Charts) != 1 || ankhFile.Charts[0].Version != "1.0.0" {
+			t.Logf("expected bar once at version 1.0.0 but got %+v", ankhFile.Charts)
+			t.Fail()
+		}
+	})
+
+	t.Run("rejects conflicting versions for one chart", func(t *testing.T) {
+		ctx := &ExecutionContext{Logger: log, AnkhFilePath: file.Name(), Chart: "billing-consumer@1.0.0", Charts: []string{"billing-consumer@1.0.0", "queue-consumer@2.0.0"}}
+		if _, err := GetAnkhFile(ctx); err == nil {
+			t.Logf("expected an error for conflicting versions of billing-consumer")
+			t.Fail()
+		}
+	})
+}
+
+func TestApplyCommandDefaults(t *testing.T) {
+	ctx := &ExecutionContext{
+		Logger:        log,
+		Mode:          Apply,
+		Environment:   "production",
+		SlackChannel:  "#from-the-command-line",
+		HelmSetValues: map[string]string{"replicas": "3"},
+	}
+	ctx.AnkhConfig.Defaults = map[string]CommandDefaults{
+		"apply": CommandDefaults{
+			SlackChannel: "#deploys",
+			JiraTicket:   true,
+			Filters:      []string{"deployment"},
+			Set:          map[string]string{"replicas": "1", "debug": "false"},
+		},
+		"deploy": CommandDefaults{SkipCrds: true},
+	}
+	ctx.AnkhConfig.Environments = map[string]Environment{
+		"production": Environment{Defaults: map[string]CommandDefaults{
+			"apply": CommandDefaults{Filters: []string{"configmap"}},
+		}},
+	}
+
+	ctx.ApplyCommandDefaults()
+
+	if ctx.SlackChannel != "#from-the-command-line" {
+		t.Logf("expected the command line to take precedence but got slack channel %v", ctx.SlackChannel)
+		t.Fail()
+	}
+	if !ctx.CreateJiraTicket {
+		t.Logf("expected a JIRA ticket from the global defaults")
+		t.Fail()
+	}
+	if len(ctx.Filters) != 1 || ctx.Filters[0] != "configmap" {
+		t.Logf("expected the environment defaults to take precedence but got filters %v", ctx.Filters)
+		t.Fail()
+	}
+	if ctx.HelmSetValues["replicas"] != "3" || ctx.HelmSetValues["debug"] != "false" {
+		t.Logf("expected defaults only for values not set, but got %v", ctx.HelmSetValues)
+		t.Fail()
+	}
+	if ctx.SkipCrds {
+		t.Logf("expected no defaults from other commands")
+		t.Fail()
+	}
+}
+
+func TestChartNamespaceOverride(t *testing.T) {
+	global := "everything"
+	ctx := &ExecutionContext{
+		Namespace:       &global,
+		ChartNamespaces: map[string]string{"queue-consumer": "queues", "billing-consumer": "billing"},
+	}
+
+	cases := []struct {
+		chart    Chart
+		expected string
+	}{
+		{Chart{Name: "queue-consumer", Alias: "orders-consumer"}, "queues"},
+		{Chart{Name: "queue-consumer", Alias: "billing-consumer"}, "billing"},
+		{Chart{Name: "bar"}, "everything"},
+	}
+	for _, c := range cases {
+		if namespace := ctx.ChartNamespaceOverride(c.chart); namespace == nil || *namespace != c.expected {
+			t.Logf("expected namespace %v for %v but got %v", c.expected, c.chart.InstanceName(), namespace)
+			t.Fail()
+		}
+	}
+
+	ctx.Namespace = nil
+	if namespace := ctx.ChartNamespaceOverride(Chart{Name: "bar"}); namespace != nil {
+		t.Logf("expected no override for bar but got %v", *namespace)
+		t.Fail()
+	}
+}
+
+func TestHelmRepositories(t *testing.T) {
+	ctx := &ExecutionContext{Logger: log}
+	ctx.AnkhConfig.Helm.Repositories = []HelmRepositoryConfig{
+		{Name: "incubator", URL: "https://charts.example.com/incubator"},
+		{Name: "stable", URL: "https://charts.example.com/stable/", Priority: 10},
+		{Name: "internal", URL: "https://charts.internal"},
+	}
+
+	names := []string{}
+	for _, repository := range ctx.HelmRepositories() {
+		names = append(names, repository.Name)
+	}
+	if strings.Join(names, " ") != "stable incubator internal" {
+		t.Logf("expected repositories in priority order, but got %v", names)
+		t.Fail()
+	}
+
+	if repository, _ := ctx.DetermineHelmRepository(nil); repository != "https://charts.example.com/stable/" {
+		t.Logf("expected the highest priority repository but got %v", repository)
+		t.Fail()
+	}
+	name := "internal"
+	if repository, _ := ctx.DetermineHelmRepository(&name); repository != "https://charts.internal" {
+		t.Logf("expected the repository named internal but got %v", repository)
+		t.Fail()
+	}
+	if config, ok := ctx.LookupHelmRepository("https://charts.example.com/stable"); !ok || config.Name != "stable" {
+		t.Logf("expected to look up a repository by URL but got %+v", config)
+		t.Fail()
+	}
+
+	ankhConfig := newValidAnkhConfig()
+	ankhConfig.Helm.Repositories = append(ctx.AnkhConfig.Helm.Repositories, HelmRepositoryConfig{Name: "stable"})
+	if errs := ankhConfig.ValidateAndInit(ctx, ""); len(errs) != 1 {
+		t.Logf("expected an error for a repository without a url but got %v", errs)
+		t.Fail()
+	}
 }
@@ -0,0 +1,44 @@
+package ankh
+
+import (
+	"os"
+	"os/user"
+)
+
+// CI systems, and the environment variables naming the person who triggered
+// the job, in order of preference. Jobs usually run as a shared account, so
+// the current user says nothing about who deployed.
+var ciDeployerVars = []struct {
+	detect string
+	vars   []string
+}{
+	{"GITHUB_ACTIONS", []string{"GITHUB_TRIGGERING_ACTOR", "GITHUB_ACTOR"}},
+	{"GITLAB_CI", []string{"GITLAB_USER_LOGIN"}},
+	// BUILD_USER_ID is set by the build user vars plugin, and CHANGE_AUTHOR for pull requests.
+	{"JENKINS_URL", []string{"BUILD_USER_ID", "CHANGE_AUTHOR"}},
+}
+
+// EffectiveDeployer returns who is operating, for notifications and
+// release history: the `--deployer` argument, or else the person who
+// triggered the CI job, if known, or else the current user.
+func (ctx *ExecutionContext) EffectiveDeployer() string {
+	if ctx.Deployer != "" {
+		return ctx.Deployer
+	}
+
+	for _, ci := range ciDeployerVars {
+		if os.Getenv(ci.detect) == "" {
+			continue
+		}
+		for _, name := range ci.vars {
+			if deployer := os.Getenv(name); deployer != "" {
+				return deployer
+			}
+		}
+	}
+
+	if currentUser, err := user.Current(); err == nil {
+		return currentUser.Username
+	}
+	return ""
+}
@@ -0,0 +1,52 @@
+package ankh
+
+import (
+	"os"
+	"os/user"
+	"testing"
+)
+
+func TestEffectiveDeployer(t *testing.T) {
+	// Restore the variables of the CI system the tests may be running in.
+	vars := []string{}
+	for _, ci := range ciDeployerVars {
+		vars = append(append(vars, ci.detect), ci.vars...)
+	}
+	for _, name := range vars {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+
+	for _, ci := range ciDeployerVars {
+		os.Setenv(ci.detect, "")
+	}
+	ctx := &ExecutionContext{}
+
+	if currentUser, err := user.Current(); err == nil && ctx.EffectiveDeployer() != currentUser.Username {
+		t.Logf("expected the current user outside of CI but got %v", ctx.EffectiveDeployer())
+		t.Fail()
+	}
+
+	os.Setenv("GITLAB_CI", "true")
+	os.Setenv("GITLAB_USER_LOGIN", "jdoe")
+	if deployer := ctx.EffectiveDeployer(); deployer != "jdoe" {
+		t.Logf("expected the user that triggered the GitLab job but got %v", deployer)
+		t.Fail()
+	}
+
+	// Variables of other CI systems are not used, eg: a GITHUB_ACTOR left in a GitLab job.
+	os.Setenv("GITLAB_CI", "")
+	os.Setenv("GITHUB_ACTOR", "octocat")
+	os.Setenv("JENKINS_URL", "https://jenkins.example.com")
+	os.Setenv("BUILD_USER_ID", "")
+	os.Setenv("CHANGE_AUTHOR", "asmith")
+	if deployer := ctx.EffectiveDeployer(); deployer != "asmith" {
+		t.Logf("expected the author of the Jenkins change but got %v", deployer)
+		t.Fail()
+	}
+
+	ctx.Deployer = "release-bot"
+	if deployer := ctx.EffectiveDeployer(); deployer != "release-bot" {
+		t.Logf("expected --deployer to take precedence but got %v", deployer)
+		t.Fail()
+	}
+}
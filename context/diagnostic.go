@@ -0,0 +1,143 @@
+package ankh
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const DocsURL = "https://github.com/appnexus/ankh"
+
+// A ConfigDiagnostic is a config error that explains itself: which file and key
+// are at fault, and what to do about it, eg: a YAML snippet that would fix it,
+// and where to read more.
+type ConfigDiagnostic struct {
+	Message string
+	// The config file at fault, if known
+	Source string
+	// The dotted path of the offending key, eg: `contexts.prod.environment-class`
+	Key string
+	// A YAML snippet that would fix the problem
+	Suggestion string
+	// A one line hint, eg: `Did you mean 'prod'?`
+	Hint string
+	// The README section that documents the key, eg: `#contexts`
+	Doc string
+}
+
+func (d *ConfigDiagnostic) Error() string {
+	lines := []string{d.Message}
+	switch {
+	case d.Source != "" && d.Key != "":
+		lines = append(lines, fmt.Sprintf("  in %v, at `%v`", d.Source, d.Key))
+	case d.Source != "":
+		lines = append(lines, fmt.Sprintf("  in %v", d.Source))
+	case d.Key != "":
+		lines = append(lines, fmt.Sprintf("  at `%v`", d.Key))
+	}
+	if d.Hint != "" {
+		lines = append(lines, "  "+d.Hint)
+	}
+	if d.Suggestion != "" {
+		lines = append(lines, "  For example:")
+		for _, line := range strings.Split(strings.TrimRight(d.Suggestion, "\n"), "\n") {
+			lines = append(lines, "    "+line)
+		}
+	}
+	if d.Doc != "" {
+		lines = append(lines, fmt.Sprintf("  See %v%v", DocsURL, d.Doc))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// A YAML snippet that sets a key on a context.
+func contextSnippet(name string, key string, value string) string {
+	return fmt.Sprintf("contexts:\n  %v:\n    %v: %v\n", name, key, value)
+}
+
+// Levenshtein distance, for suggesting names that are close to a typo.
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j] + 1
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+			if previous[j-1]+cost < current[j] {
+				current[j] = previous[j-1] + cost
+			}
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+// Returns the choice closest to name, if it is close enough to be a likely typo.
+func closestMatch(name string, choices []string) string {
+	best, bestDistance := "", 0
+	for _, choice := range choices {
+		distance := editDistance(strings.ToLower(name), strings.ToLower(choice))
+		if best == "" || distance < bestDistance {
+			best, bestDistance = choice, distance
+		}
+	}
+	if best == "" || bestDistance > len(name)/3+1 {
+		return ""
+	}
+	return best
+}
+
+// Hints at the values used by other contexts, eg: for `environment-class`.
+func otherContextValues(ankhConfig *AnkhConfig, value func(Context) string) string {
+	seen := map[string]bool{}
+	values := []string{}
+	for _, context := range ankhConfig.Contexts {
+		if v := value(context); v != "" && !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	sort.Strings(values)
+	return fmt.Sprintf("Other contexts use: %v", strings.Join(values, ", "))
+}
+
+func contextNotFoundDiagnostic(ankhConfig *AnkhConfig, name string) *ConfigDiagnostic {
+	names := []string{}
+	for contextName := range ankhConfig.Contexts {
+		names = append(names, contextName)
+	}
+	sort.Strings(names)
+
+	hint := "No contexts are defined. Run `ankh config init` or `ankh init` to create one"
+	if match := closestMatch(name, names); match != "" {
+		hint = fmt.Sprintf("Did you mean '%v'?", match)
+	} else if len(names) > 0 {
+		hint = fmt.Sprintf("Available contexts: %v", strings.Join(names, ", "))
+	}
+	return &ConfigDiagnostic{
+		Message: fmt.Sprintf("Context '%s' not found in `contexts`", name),
+		Key:     "contexts",
+		Hint:    hint,
+		Doc:     "#contexts",
+	}
+}
+
+func orDefault(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
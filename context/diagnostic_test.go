@@ -0,0 +1,63 @@
+package ankh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigDiagnostics(t *testing.T) {
+	t.Run("missing environment-class", func(t *testing.T) {
+		ankhConfig := newValidAnkhConfig()
+		context := ankhConfig.Contexts["test"]
+		context.Source = "/home/me/.ankh/config"
+		context.EnvironmentClass = ""
+		ankhConfig.Contexts["test"] = context
+
+		errs := ankhConfig.ValidateAndInit(&ExecutionContext{Logger: log}, "")
+		if len(errs) != 1 {
+			t.Fatalf("expected a single error but got %v", errs)
+		}
+		diagnostic, ok := errs[0].(*ConfigDiagnostic)
+		if !ok {
+			t.Fatalf("expected a ConfigDiagnostic but got %T", errs[0])
+		}
+		if diagnostic.Source != context.Source || diagnostic.Key != "contexts.test.environment-class" {
+			t.Logf("got unexpected diagnostic %+v", diagnostic)
+			t.Fail()
+		}
+
+		message := diagnostic.Error()
+		for _, expected := range []string{
+			"Current context 'test' has missing or empty `environment-class`",
+			"in /home/me/.ankh/config, at `contexts.test.environment-class`",
+			"    contexts:\n      test:\n        environment-class: <environment-class>",
+			DocsURL + "#contexts",
+		} {
+			if !strings.Contains(message, expected) {
+				t.Logf("expected %q in diagnostic:\n%v", expected, message)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("context typo", func(t *testing.T) {
+		ankhConfig := newValidAnkhConfig()
+		errs := ankhConfig.ValidateAndInit(&ExecutionContext{Logger: log}, "tset")
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), "Did you mean 'test'?") {
+			t.Logf("expected a suggestion for the context but got %v", errs)
+			t.Fail()
+		}
+	})
+}
+
+func TestClosestMatch(t *testing.T) {
+	choices := []string{"production", "staging", "dev"}
+	if match := closestMatch("prodution", choices); match != "production" {
+		t.Logf("expected production but got %v", match)
+		t.Fail()
+	}
+	if match := closestMatch("minikube", choices); match != "" {
+		t.Logf("expected no match but got %v", match)
+		t.Fail()
+	}
+}
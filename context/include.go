@@ -0,0 +1,235 @@
+package ankh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// An AnkhFileInclude is a fragment of an Ankh file, eg: a team's shared chart
+// list, whose charts and dependencies are added to the including Ankh file.
+type AnkhFileInclude struct {
+	// An HTTP URL to GET, or a path relative to where the including Ankh file
+	// came from: its directory, or its URL when it was fetched.
+	URL string `yaml:"url"`
+
+	// Optional. The expected sha256 of the fragment, as a hex string.
+	SHA256 string `yaml:"sha256,omitempty"`
+
+	// Optional. How long a fetched fragment is cached before it is fetched
+	// again, eg: "30m". Defaults to DefaultIncludeTTL.
+	TTL string `yaml:"ttl,omitempty"`
+}
+
+// How long a remote include is cached, unless it sets a `ttl`.
+const DefaultIncludeTTL = time.Hour
+
+// Where remote includes are cached. Set by the caller, since the Ankh file is
+// parsed without an execution context. Nothing is cached if empty.
+var IncludeCacheDir string
+
+func isRemoteURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+func (include AnkhFileInclude) isRemote() bool {
+	return isRemoteURL(include.URL)
+}
+
+// Resolves a relative include against where the including Ankh file came
+// from: a local directory, or the URL of an Ankh file or fragment that was
+// fetched, so that a remote fragment's own includes are fetched from next to
+// it.
+func (include AnkhFileInclude) resolve(base string) (AnkhFileInclude, error) {
+	if include.isRemote() {
+		return include, nil
+	}
+	if isRemoteURL(base) {
+		baseURL, _ := url.Parse(base)
+		ref, err := url.Parse(include.URL)
+		if err != nil {
+			return include, fmt.Errorf("Invalid include \"%v\": %v", include.URL, err)
+		}
+		include.URL = baseURL.ResolveReference(ref).String()
+		return include, nil
+	}
+	include.URL = include.localPath(base)
+	return include, nil
+}
+
+func (include AnkhFileInclude) localPath(dir string) string {
+	if filepath.IsAbs(include.URL) {
+		return include.URL
+	}
+	return filepath.Join(dir, include.URL)
+}
+
+func (include AnkhFileInclude) ttl() (time.Duration, error) {
+	if include.TTL == "" {
+		return DefaultIncludeTTL, nil
+	}
+	ttl, err := time.ParseDuration(include.TTL)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid `ttl` \"%v\" for include \"%v\": %v", include.TTL, include.URL, err)
+	}
+	return ttl, nil
+}
+
+func includeCachePath(includeURL string) string {
+	sum := sha256.Sum256([]byte(includeURL))
+	return filepath.Join(IncludeCacheDir, hex.EncodeToString(sum[:])+".yaml")
+}
+
+func readCachedInclude(include AnkhFileInclude, ttl time.Duration) ([]byte, bool) {
+	if IncludeCacheDir == "" {
+		return nil, false
+	}
+	cachePath := includeCachePath(include.URL)
+	info, err := os.Stat(cachePath)
+	if err != nil || time.Since(info.ModTime()) > ttl {
+		return nil, false
+	}
+	body, err := ioutil.ReadFile(cachePath)
+	if err != nil {
+		return nil, false
+	}
+	// A cached fragment that no longer matches its pin, eg: because the pin
+	// was updated, is fetched again.
+	if include.SHA256 != "" && verifyInclude(include, body) != nil {
+		return nil, false
+	}
+	return body, true
+}
+
+func writeCachedInclude(include AnkhFileInclude, body []byte) error {
+	if IncludeCacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(IncludeCacheDir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(includeCachePath(include.URL), body, 0644)
+}
+
+func verifyInclude(include AnkhFileInclude, body []byte) error {
+	sum := sha256.Sum256(body)
+	actual := hex.EncodeToString(sum[:])
+	if !strings.EqualFold(actual, include.SHA256) {
+		return fmt.Errorf("Checksum mismatch for include \"%v\": expected sha256 %v but got %v",
+			include.URL, include.SHA256, actual)
+	}
+	return nil
+}
+
+func fetchInclude(include AnkhFileInclude, dir string) ([]byte, error) {
+	if !include.isRemote() {
+		body, err := ioutil.ReadFile(include.localPath(dir))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read include \"%v\": %v", include.URL, err)
+		}
+		if include.SHA256 != "" {
+			if err := verifyInclude(include, body); err != nil {
+				return nil, err
+			}
+		}
+		return body, nil
+	}
+
+	ttl, err := include.ttl()
+	if err != nil {
+		return nil, err
+	}
+	if body, ok := readCachedInclude(include, ttl); ok {
+		return body, nil
+	}
+
+	resp, err := http.Get(include.URL)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to fetch include from URL '%s': %v", include.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Non-200 status code when fetching include from URL '%s': %v", include.URL, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if include.SHA256 != "" {
+		if err := verifyInclude(include, body); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeCachedInclude(include, body); err != nil {
+		return nil, fmt.Errorf("Unable to cache include \"%v\": %v", include.URL, err)
+	}
+	return body, nil
+}
+
+// resolveIncludes adds the charts and dependencies of each include, and of
+// the includes of those, ahead of the Ankh file's own. Relative includes are
+// resolved against `base`, the directory or URL the Ankh file came from.
+// `seen` guards against fragments that include one another.
+func resolveIncludes(ankhFile *AnkhFile, base string, seen map[string]bool) error {
+	if len(ankhFile.Include) == 0 {
+		return nil
+	}
+	charts := []Chart{}
+	dependencies := []string{}
+	for _, include := range ankhFile.Include {
+		if include.URL == "" {
+			return fmt.Errorf("Each include must have a `url`")
+		}
+		include, err := include.resolve(base)
+		if err != nil {
+			return err
+		}
+		if seen[include.URL] {
+			return fmt.Errorf("Include \"%v\" includes itself", include.URL)
+		}
+
+		body, err := fetchInclude(include, base)
+		if err != nil {
+			return err
+		}
+		fragment := AnkhFile{}
+		if err := yaml.Unmarshal(body, &fragment); err != nil {
+			return fmt.Errorf("Error loading include \"%v\": %v", include.URL, err)
+		}
+
+		fragmentBase := include.URL
+		if !include.isRemote() {
+			fragmentBase = filepath.Dir(include.URL)
+		}
+		seen[include.URL] = true
+		if err := resolveIncludes(&fragment, fragmentBase, seen); err != nil {
+			return err
+		}
+		delete(seen, include.URL)
+
+		charts = append(charts, fragment.Charts...)
+		dependencies = append(dependencies, fragment.Dependencies...)
+	}
+	ankhFile.Charts = append(charts, ankhFile.Charts...)
+	ankhFile.Dependencies = append(dependencies, ankhFile.Dependencies...)
+	return nil
+}
+
+// ClearIncludeCache removes every cached include, so that each is fetched
+// again the next time an Ankh file that includes it is parsed.
+func ClearIncludeCache() error {
+	if IncludeCacheDir == "" {
+		return nil
+	}
+	return os.RemoveAll(IncludeCacheDir)
+}
@@ -0,0 +1,147 @@
+package ankh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const includedAnkhFileYAML string = `
+charts:
+  - name: shared
+    version: 1.0.0
+`
+
+func TestParseAnkhFileIncludes(t *testing.T) {
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		fmt.Fprint(w, includedAnkhFileYAML)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	IncludeCacheDir = filepath.Join(dir, "include-cache")
+	defer func() { IncludeCacheDir = "" }()
+
+	sum := sha256.Sum256([]byte(includedAnkhFileYAML))
+	writeAnkhFile := func(pin string) string {
+		ankhFilePath := filepath.Join(dir, "ankh.yaml")
+		err := ioutil.WriteFile(ankhFilePath, []byte(fmt.Sprintf(`
+include:
+  - url: %v/shared.yaml
+    sha256: %v
+charts:
+  - name: own
+    version: 0.0.1
+`, server.URL, pin)), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ankhFilePath
+	}
+
+	t.Run("adds the included charts first, and caches them", func(t *testing.T) {
+		ankhFilePath := writeAnkhFile(hex.EncodeToString(sum[:]))
+		for i := 0; i < 2; i++ {
+			ankhFile, err := ParseAnkhFile(ankhFilePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ankhFile.Charts) != 2 || ankhFile.Charts[0].Name != "shared" || ankhFile.Charts[1].Name != "own" {
+				t.Logf("expected the included chart before the file's own but got %+v", ankhFile.Charts)
+				t.Fail()
+			}
+		}
+		if fetches != 1 {
+			t.Logf("expected the include to be fetched once but it was fetched %v times", fetches)
+			t.Fail()
+		}
+
+		if err := ClearIncludeCache(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseAnkhFile(ankhFilePath); err != nil || fetches != 2 {
+			t.Logf("expected the include to be fetched again after clearing the cache but got %v fetches and %v", fetches, err)
+			t.Fail()
+		}
+	})
+
+	t.Run("fails on a checksum mismatch", func(t *testing.T) {
+		ankhFilePath := writeAnkhFile(strings.Repeat("0", 64))
+		_, err := ParseAnkhFile(ankhFilePath)
+		if err == nil || !strings.Contains(err.Error(), "Checksum mismatch") {
+			t.Logf("expected a checksum mismatch but got %v", err)
+			t.Fail()
+		}
+	})
+
+	t.Run("fails on an include cycle", func(t *testing.T) {
+		ankhFilePath := filepath.Join(dir, "cycle.yaml")
+		if err := ioutil.WriteFile(ankhFilePath, []byte("include:\n  - url: cycle.yaml\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseAnkhFile(ankhFilePath); err == nil {
+			t.Logf("expected an error for an Ankh file that includes itself")
+			t.Fail()
+		}
+	})
+}
+
+func TestParseAnkhFileRemoteRelativeIncludes(t *testing.T) {
+	fragments := map[string]string{
+		"/teams/web.yaml":    "include:\n  - url: common.yaml\ncharts:\n  - name: web\n    version: 1.0.0\n",
+		"/teams/common.yaml": "charts:\n  - name: common\n    version: 1.0.0\n",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fragment, ok := fragments[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, fragment)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The remote fragment's own include is fetched from next to it, rather
+	// than read from the directory of the local Ankh file.
+	ankhFilePath := filepath.Join(dir, "ankh.yaml")
+	if err := ioutil.WriteFile(ankhFilePath, []byte(fmt.Sprintf("include:\n  - url: %v/teams/web.yaml\n", server.URL)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ankhFile, err := ParseAnkhFile(ankhFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ankhFile.Charts) != 2 || ankhFile.Charts[0].Name != "common" || ankhFile.Charts[1].Name != "web" {
+		t.Logf("expected the charts of both remote fragments but got %+v", ankhFile.Charts)
+		t.Fail()
+	}
+
+	// So are the relative includes of an Ankh file fetched by URL.
+	ankhFile, err = ParseAnkhFile(server.URL + "/teams/web.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ankhFile.Charts) != 2 || ankhFile.Charts[0].Name != "common" {
+		t.Logf("expected the include of the remote Ankh file but got %+v", ankhFile.Charts)
+		t.Fail()
+	}
+}
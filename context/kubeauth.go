@@ -0,0 +1,118 @@
+package ankh
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// The names of the cluster, user and context in the kube config that Ankh
+// generates for a `kube-server`.
+const (
+	serverKubeClusterName = "_kcluster"
+	serverKubeUserName    = "_kuser"
+	serverKubeContextName = "_kctx"
+)
+
+// The credential plugin API version used when `kube-exec` does not give one.
+const DefaultKubeExecAPIVersion = "client.authentication.k8s.io/v1beta1"
+
+// KubeExecConfig is a client-go credential plugin, with the same fields as the
+// `exec` of a user in a kube config, eg: `kubectl oidc-login get-token ...` or
+// `aws eks get-token ...`.
+type KubeExecConfig struct {
+	APIVersion         string           `yaml:"apiVersion,omitempty"`
+	Command            string           `yaml:"command"`
+	Args               []string         `yaml:"args,omitempty"`
+	Env                []KubeExecEnvVar `yaml:"env,omitempty"`
+	InstallHint        string           `yaml:"installHint,omitempty"`
+	ProvideClusterInfo bool             `yaml:"provideClusterInfo,omitempty"`
+	// One of `Never`, `IfAvailable` or `Always`. Required by kubectl for the
+	// `client.authentication.k8s.io/v1` API version.
+	InteractiveMode string `yaml:"interactiveMode,omitempty"`
+}
+
+type KubeExecEnvVar struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+// Returns a kube config for the `kube-server` of a context, with its CA data
+// and credential plugin, if any.
+func newServerKubeConfig(context *Context) *KubeConfig {
+	kubeCluster := KubeCluster{Name: serverKubeClusterName}
+	kubeCluster.Cluster.Server = context.KubeServer
+	kubeCluster.Cluster.CertificateAuthorityData = context.KubeCAData
+
+	kubeContext := KubeContext{Name: serverKubeContextName}
+	kubeContext.Context.Cluster = kubeCluster.Name
+
+	kubeConfig := &KubeConfig{
+		ApiVersion:           "v1",
+		Kind:                 "Config",
+		Clusters:             []KubeCluster{kubeCluster},
+		CurrentContextUnused: kubeContext.Name,
+	}
+
+	if context.KubeExec != nil {
+		exec := *context.KubeExec
+		if exec.APIVersion == "" {
+			exec.APIVersion = DefaultKubeExecAPIVersion
+		}
+		kubeUser := KubeUser{Name: serverKubeUserName}
+		kubeUser.User.Exec = &exec
+		kubeConfig.Users = []KubeUser{kubeUser}
+		kubeContext.Context.User = kubeUser.Name
+	}
+
+	kubeConfig.Contexts = []KubeContext{kubeContext}
+	return kubeConfig
+}
+
+// Checks the `kube-ca-data` and `kube-exec` of a context or cluster at `key`,
+// which only apply to a `kube-server`.
+func validateKubeAuth(key string, source string, kubeServer string, caData string, exec *KubeExecConfig) []error {
+	errors := []error{}
+	if kubeServer == "" && (caData != "" || exec != nil) {
+		errors = append(errors, &ConfigDiagnostic{
+			Message: fmt.Sprintf("`kube-ca-data` and `kube-exec` of '%s' need a `kube-server`", key),
+			Source:  source,
+			Key:     key,
+			Hint:    "Configure CA data and credential plugins for `kube-context` and `kube-config` in the kube config itself",
+			Doc:     "#context",
+		})
+	}
+	if caData != "" {
+		if _, err := base64.StdEncoding.DecodeString(caData); err != nil {
+			errors = append(errors, &ConfigDiagnostic{
+				Message: fmt.Sprintf("`kube-ca-data` of '%s' is not valid base64: %v", key, err),
+				Source:  source,
+				Key:     key + ".kube-ca-data",
+				Hint:    "Use the output of `base64 < ca.crt`, as in kubectl's `certificate-authority-data`",
+				Doc:     "#context",
+			})
+		}
+	}
+	if exec != nil {
+		if exec.Command == "" {
+			errors = append(errors, &ConfigDiagnostic{
+				Message: fmt.Sprintf("`kube-exec` of '%s' has a missing or empty `command`", key),
+				Source:  source,
+				Key:     key + ".kube-exec.command",
+				Hint:    "Use the command of the credential plugin, eg: `kubectl` for `kubectl oidc-login get-token ...`",
+				Doc:     "#kubeexecconfig",
+			})
+		}
+		switch exec.InteractiveMode {
+		case "", "Never", "IfAvailable", "Always":
+		default:
+			errors = append(errors, &ConfigDiagnostic{
+				Message: fmt.Sprintf("`kube-exec` of '%s' has unknown `interactiveMode` \"%s\"", key, exec.InteractiveMode),
+				Source:  source,
+				Key:     key + ".kube-exec.interactiveMode",
+				Hint:    "Use one of `Never`, `IfAvailable` or `Always`",
+				Doc:     "#kubeexecconfig",
+			})
+		}
+	}
+	return errors
+}
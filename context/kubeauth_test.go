@@ -0,0 +1,65 @@
+package ankh
+
+import (
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestNewServerKubeConfig(t *testing.T) {
+	context := &Context{KubeServer: "https://kube.example.com"}
+	out, err := yaml.Marshal(newServerKubeConfig(context))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "users:") || strings.Contains(string(out), "certificate-authority-data") {
+		t.Logf("expected no users nor CA data for a bare kube-server but got:\n%v", string(out))
+		t.Fail()
+	}
+
+	context.KubeCAData = "aGVsbG8="
+	context.KubeExec = &KubeExecConfig{
+		Command: "kubectl",
+		Args:    []string{"oidc-login", "get-token"},
+		Env:     []KubeExecEnvVar{{Name: "AWS_PROFILE", Value: "prod"}},
+	}
+	kubeConfig := newServerKubeConfig(context)
+	if kubeConfig.Clusters[0].Cluster.CertificateAuthorityData != "aGVsbG8=" {
+		t.Logf("expected the CA data on the cluster but got %+v", kubeConfig.Clusters[0])
+		t.Fail()
+	}
+	if len(kubeConfig.Users) != 1 || kubeConfig.Contexts[0].Context.User != kubeConfig.Users[0].Name {
+		t.Fatalf("expected the context to use the exec user but got %+v", kubeConfig)
+	}
+	if exec := kubeConfig.Users[0].User.Exec; exec.APIVersion != DefaultKubeExecAPIVersion || exec.Command != "kubectl" {
+		t.Logf("expected the exec config with the default API version but got %+v", exec)
+		t.Fail()
+	}
+	if context.KubeExec.APIVersion != "" {
+		t.Logf("expected the context's exec config to be left as is")
+		t.Fail()
+	}
+}
+
+func TestValidateKubeAuth(t *testing.T) {
+	for _, test := range []struct {
+		kubeServer string
+		caData     string
+		exec       *KubeExecConfig
+		errors     int
+	}{
+		{"https://kube.example.com", "aGVsbG8=", &KubeExecConfig{Command: "aws", InteractiveMode: "Never"}, 0},
+		{"", "", nil, 0},
+		{"", "aGVsbG8=", nil, 1},
+		{"https://kube.example.com", "not base64!", nil, 1},
+		{"https://kube.example.com", "", &KubeExecConfig{}, 1},
+		{"https://kube.example.com", "", &KubeExecConfig{Command: "aws", InteractiveMode: "Sometimes"}, 1},
+	} {
+		errors := validateKubeAuth("contexts.test", "", test.kubeServer, test.caData, test.exec)
+		if len(errors) != test.errors {
+			t.Logf("expected %v errors for %+v but got %v", test.errors, test, errors)
+			t.Fail()
+		}
+	}
+}
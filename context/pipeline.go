@@ -0,0 +1,74 @@
+package ankh
+
+import (
+	"fmt"
+)
+
+// Pipeline promotes the charts of an Ankh file through environments, one
+// stage at a time, eg: staging and then production. See `ankh pipeline run`.
+type Pipeline struct {
+	// The Slack channel to request approvals in, if any
+	Slack  string          `yaml:"slack,omitempty"`
+	Stages []PipelineStage `yaml:"stages"`
+}
+
+// PipelineStage applies the charts to an environment, and then watches them
+// for `SoakMinutes` before moving on to the next stage.
+type PipelineStage struct {
+	Environment string `yaml:"environment"`
+	// When set, the next stage runs once this one has soaked, without asking
+	AutoPromote bool `yaml:"autoPromote,omitempty"`
+	SoakMinutes int  `yaml:"soakMinutes,omitempty"`
+	// Either `manual`, to ask for approval before this stage runs, or `auto`
+	Approval string `yaml:"approval,omitempty"`
+}
+
+const (
+	PipelineApprovalAuto   = "auto"
+	PipelineApprovalManual = "manual"
+)
+
+// Validate checks that every stage names an environment, at most once, and
+// has a valid soak time and approval.
+func (pipeline *Pipeline) Validate() error {
+	if len(pipeline.Stages) == 0 {
+		return fmt.Errorf("pipeline has no stages")
+	}
+
+	seen := map[string]bool{}
+	for i, stage := range pipeline.Stages {
+		if stage.Environment == "" {
+			return fmt.Errorf("pipeline stage %v has no environment", i+1)
+		}
+		if seen[stage.Environment] {
+			return fmt.Errorf("pipeline stage %v repeats environment \"%v\"", i+1, stage.Environment)
+		}
+		seen[stage.Environment] = true
+
+		if stage.SoakMinutes < 0 {
+			return fmt.Errorf("pipeline stage \"%v\" has a negative soakMinutes", stage.Environment)
+		}
+		switch stage.Approval {
+		case "", PipelineApprovalAuto, PipelineApprovalManual:
+		default:
+			return fmt.Errorf("pipeline stage \"%v\" has unknown approval \"%v\". Expected `%v` or `%v`",
+				stage.Environment, stage.Approval, PipelineApprovalAuto, PipelineApprovalManual)
+		}
+	}
+	return nil
+}
+
+// NeedsApproval returns whether the stage at `index` waits for someone to
+// approve it: either it asks for `manual` approval, or the stage before it
+// does not `autoPromote`. The first stage only needs approval if it asks.
+func (pipeline *Pipeline) NeedsApproval(index int) bool {
+	stage := pipeline.Stages[index]
+	switch {
+	case stage.Approval == PipelineApprovalManual:
+		return true
+	case index == 0 || stage.Approval == PipelineApprovalAuto:
+		return false
+	default:
+		return !pipeline.Stages[index-1].AutoPromote
+	}
+}
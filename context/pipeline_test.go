@@ -0,0 +1,42 @@
+package ankh
+
+import (
+	"testing"
+)
+
+func TestPipelineValidate(t *testing.T) {
+	for _, test := range []struct {
+		stages []PipelineStage
+		valid  bool
+	}{
+		{[]PipelineStage{{Environment: "staging", AutoPromote: true, SoakMinutes: 30}, {Environment: "production", Approval: "manual"}}, true},
+		{[]PipelineStage{}, false},
+		{[]PipelineStage{{SoakMinutes: 5}}, false},
+		{[]PipelineStage{{Environment: "staging"}, {Environment: "staging"}}, false},
+		{[]PipelineStage{{Environment: "staging", SoakMinutes: -1}}, false},
+		{[]PipelineStage{{Environment: "staging", Approval: "later"}}, false},
+	} {
+		pipeline := Pipeline{Stages: test.stages}
+		if err := pipeline.Validate(); (err == nil) != test.valid {
+			t.Logf("expected valid to be %v for %+v, but got error %v", test.valid, test.stages, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestPipelineNeedsApproval(t *testing.T) {
+	pipeline := Pipeline{Stages: []PipelineStage{
+		{Environment: "dev"},
+		{Environment: "staging", Approval: "auto"},
+		{Environment: "canary", AutoPromote: true},
+		{Environment: "production", Approval: "manual"},
+		{Environment: "dr"},
+	}}
+	expected := []bool{false, false, true, true, true}
+	for i, needsApproval := range expected {
+		if pipeline.NeedsApproval(i) != needsApproval {
+			t.Logf("expected stage %v to need approval: %v", pipeline.Stages[i].Environment, needsApproval)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package ankh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The name of the file, at the root of a repository, that declares its project defaults.
+const AnkhProjectFileName = ".ankhproject"
+
+// AnkhProject defines the shape of the `.ankhproject` file, which gives
+// commands run anywhere inside a repository the same defaults for everyone
+// working on it.
+type AnkhProject struct {
+	// (private) an absolute path to the .ankhproject file
+	Path string `yaml:"-"`
+
+	// The chart to use when neither a chart nor an Ankh file is given: a
+	// chart directory, relative to the project root, or a chart name.
+	Chart string `yaml:"chart,omitempty"`
+	// The namespace to use when none is given on the command line.
+	Namespace string `yaml:"namespace,omitempty"`
+	// The context or environment to use when neither is given. At most one may be set.
+	Context     string `yaml:"context,omitempty"`
+	Environment string `yaml:"environment,omitempty"`
+	// The only environments the project may operate on. Contexts are allowed
+	// if they belong to one of these environments.
+	Environments []string `yaml:"environments,omitempty"`
+}
+
+// FindAnkhProject looks for a `.ankhproject` file in `dir` and each of its
+// parents, stopping at the root of the git repository that contains `dir`,
+// if any. It returns nil if there is none.
+func FindAnkhProject(dir string) (*AnkhProject, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		projectPath := filepath.Join(dir, AnkhProjectFileName)
+		body, err := ioutil.ReadFile(projectPath)
+		if err == nil {
+			project := AnkhProject{Path: projectPath}
+			if err := yaml.UnmarshalStrict(body, &project); err != nil {
+				return nil, fmt.Errorf("Error loading project file %v: %v", projectPath, err)
+			}
+			if project.Context != "" && project.Environment != "" {
+				return nil, fmt.Errorf("Invalid project file %v: must not set both `context` and `environment`", projectPath)
+			}
+			return &project, nil
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return nil, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// Root returns the directory of the project file.
+func (project *AnkhProject) Root() string {
+	return filepath.Dir(project.Path)
+}
+
+// ApplyProjectDefaults uses the namespace, and the context or environment, of
+// the project, unless they were given on the command line.
+func (ctx *ExecutionContext) ApplyProjectDefaults() {
+	project := ctx.Project
+	if project == nil {
+		return
+	}
+	if ctx.Namespace == nil && project.Namespace != "" {
+		ctx.Logger.Infof("Using namespace \"%v\" from %v", project.Namespace, project.Path)
+		namespace := project.Namespace
+		ctx.Namespace = &namespace
+	}
+	if ctx.Context == "" && ctx.Environment == "" && ctx.ContextSelector == nil && !ctx.IgnoreContextAndEnv {
+		if project.Environment != "" {
+			ctx.Logger.Infof("Using environment \"%v\" from %v", project.Environment, project.Path)
+			ctx.Environment = project.Environment
+		} else if project.Context != "" {
+			ctx.Logger.Infof("Using context \"%v\" from %v", project.Context, project.Path)
+			ctx.Context = project.Context
+		}
+	}
+}
+
+// ApplyProjectChart uses the chart of the project when the command was given
+// neither a chart nor an Ankh file.
+func (ctx *ExecutionContext) ApplyProjectChart() {
+	project := ctx.Project
+	if project == nil || project.Chart == "" || ctx.Chart != "" || ctx.AnkhFilePath != "" {
+		return
+	}
+
+	chartPath := project.Chart
+	if !filepath.IsAbs(chartPath) {
+		chartPath = filepath.Join(project.Root(), chartPath)
+	}
+	if info, err := os.Stat(chartPath); err == nil && info.IsDir() {
+		ctx.Logger.Infof("Using chart path \"%v\" from %v", chartPath, project.Path)
+		ctx.Chart = chartPath
+		ctx.LocalChart = true
+		return
+	}
+	ctx.Logger.Infof("Using chart \"%v\" from %v", project.Chart, project.Path)
+	ctx.Chart = project.Chart
+	ctx.Charts = []string{project.Chart}
+}
+
+// CheckProjectEnvironments returns an error if the project restricts its
+// environments and the current environment, or context, is not among them.
+func (ctx *ExecutionContext) CheckProjectEnvironments() error {
+	project := ctx.Project
+	if project == nil || len(project.Environments) == 0 || ctx.IgnoreContextAndEnv {
+		return nil
+	}
+
+	for _, name := range project.Environments {
+		if ctx.Environment != "" {
+			if ctx.Environment == name {
+				return nil
+			}
+			continue
+		}
+		for _, context := range ctx.AnkhConfig.Environments[name].Contexts {
+			if context == ctx.AnkhConfig.CurrentContextName {
+				return nil
+			}
+		}
+	}
+
+	target := fmt.Sprintf("context \"%v\"", ctx.AnkhConfig.CurrentContextName)
+	if ctx.Environment != "" {
+		target = fmt.Sprintf("environment \"%v\"", ctx.Environment)
+	}
+	return fmt.Errorf("The project in %v only allows the environments [ %v ], which do not include %v",
+		project.Path, strings.Join(project.Environments, ", "), target)
+}
@@ -0,0 +1,139 @@
+package ankh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFindAnkhProject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "repo")
+	nested := filepath.Join(repo, "src", "app")
+	os.MkdirAll(nested, 0755)
+	os.MkdirAll(filepath.Join(repo, ".git"), 0755)
+
+	// Project files outside of the repository are not used.
+	ioutil.WriteFile(filepath.Join(dir, AnkhProjectFileName), []byte("namespace: outside\n"), 0644)
+	project, err := FindAnkhProject(nested)
+	if err != nil || project != nil {
+		t.Logf("expected no project inside the repository but got %+v and %v", project, err)
+		t.Fail()
+	}
+
+	ioutil.WriteFile(filepath.Join(repo, AnkhProjectFileName), []byte("chart: helm/app\nnamespace: app\nenvironments: [staging]\n"), 0644)
+	project, err = FindAnkhProject(nested)
+	if err != nil || project == nil {
+		t.Fatalf("expected the project at the repository root but got %v", err)
+	}
+	if project.Root() != repo || project.Namespace != "app" || project.Chart != "helm/app" {
+		t.Logf("got unexpected project %+v", project)
+		t.Fail()
+	}
+
+	ioutil.WriteFile(filepath.Join(repo, AnkhProjectFileName), []byte("context: a\nenvironment: b\n"), 0644)
+	if _, err := FindAnkhProject(nested); err == nil {
+		t.Logf("expected a project with both a context and an environment to be invalid")
+		t.Fail()
+	}
+	ioutil.WriteFile(filepath.Join(repo, AnkhProjectFileName), []byte("namespaces: [a]\n"), 0644)
+	if _, err := FindAnkhProject(nested); err == nil {
+		t.Logf("expected unknown fields to be invalid")
+		t.Fail()
+	}
+}
+
+func TestApplyProject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "helm", "app"), 0755)
+
+	newContext := func(project AnkhProject) *ExecutionContext {
+		project.Path = filepath.Join(dir, AnkhProjectFileName)
+		ctx := &ExecutionContext{Logger: logrus.New(), Project: &project}
+		ctx.Logger.Out = ioutil.Discard
+		return ctx
+	}
+
+	t.Run("command line takes precedence", func(t *testing.T) {
+		ctx := newContext(AnkhProject{Namespace: "app", Environment: "staging"})
+		namespace := "other"
+		ctx.Namespace = &namespace
+		ctx.Context = "minikube"
+		ctx.ApplyProjectDefaults()
+		if *ctx.Namespace != "other" || ctx.Environment != "" {
+			t.Logf("expected the command line to be used but got namespace %v and environment %v", *ctx.Namespace, ctx.Environment)
+			t.Fail()
+		}
+	})
+
+	t.Run("fills in defaults", func(t *testing.T) {
+		ctx := newContext(AnkhProject{Namespace: "app", Context: "minikube", Chart: "helm/app"})
+		ctx.ApplyProjectDefaults()
+		ctx.ApplyProjectChart()
+		if ctx.Namespace == nil || *ctx.Namespace != "app" || ctx.Context != "minikube" {
+			t.Logf("expected the project's namespace and context but got %+v", ctx)
+			t.Fail()
+		}
+		if ctx.Chart != filepath.Join(dir, "helm", "app") || !ctx.LocalChart {
+			t.Logf("expected the project's chart directory but got %v", ctx.Chart)
+			t.Fail()
+		}
+	})
+
+	t.Run("chart names", func(t *testing.T) {
+		ctx := newContext(AnkhProject{Chart: "redis"})
+		ctx.ApplyProjectChart()
+		if ctx.Chart != "redis" || ctx.LocalChart || len(ctx.Charts) != 1 {
+			t.Logf("expected the chart name to be used but got %+v", ctx)
+			t.Fail()
+		}
+
+		ctx = newContext(AnkhProject{Chart: "redis"})
+		ctx.AnkhFilePath = "ankh.yaml"
+		ctx.ApplyProjectChart()
+		if ctx.Chart != "" {
+			t.Logf("expected an Ankh file to take precedence but got %v", ctx.Chart)
+			t.Fail()
+		}
+	})
+
+	t.Run("allowed environments", func(t *testing.T) {
+		ctx := newContext(AnkhProject{Environments: []string{"staging"}})
+		ctx.AnkhConfig.Environments = map[string]Environment{"staging": {Contexts: []string{"staging-east"}}}
+
+		ctx.Environment = "staging"
+		if err := ctx.CheckProjectEnvironments(); err != nil {
+			t.Log(err)
+			t.Fail()
+		}
+		ctx.Environment = "production"
+		if err := ctx.CheckProjectEnvironments(); err == nil {
+			t.Logf("expected environment production not to be allowed")
+			t.Fail()
+		}
+
+		ctx.Environment = ""
+		ctx.AnkhConfig.CurrentContextName = "staging-east"
+		if err := ctx.CheckProjectEnvironments(); err != nil {
+			t.Log(err)
+			t.Fail()
+		}
+		ctx.AnkhConfig.CurrentContextName = "production-east"
+		if err := ctx.CheckProjectEnvironments(); err == nil {
+			t.Logf("expected a context outside of the allowed environments not to be allowed")
+			t.Fail()
+		}
+	})
+}
@@ -0,0 +1,22 @@
+package ankh
+
+import "fmt"
+
+// IsReadOnly returns whether mutating commands are disabled, either by
+// `--read-only` or by `readOnly` in any of the merged ankh configs.
+func (ctx *ExecutionContext) IsReadOnly() bool {
+	return ctx.ReadOnly || ctx.AnkhConfig.ReadOnly
+}
+
+// CheckWritable returns an error describing `action` if Ankh is in read-only
+// mode. Dry runs change nothing, so callers only check real operations.
+func (ctx *ExecutionContext) CheckWritable(action string) error {
+	if !ctx.IsReadOnly() {
+		return nil
+	}
+	source := "`readOnly` in ankh config"
+	if ctx.ReadOnly {
+		source = "`--read-only`"
+	}
+	return fmt.Errorf("Refusing to %v: Ankh is in read-only mode, from %v", action, source)
+}
@@ -0,0 +1,26 @@
+package ankh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckWritable(t *testing.T) {
+	ctx := &ExecutionContext{}
+	if err := ctx.CheckWritable("apply"); err != nil {
+		t.Logf("expected no error outside of read-only mode but got %v", err)
+		t.Fail()
+	}
+
+	ctx.AnkhConfig.ReadOnly = true
+	if err := ctx.CheckWritable("apply"); err == nil || !strings.Contains(err.Error(), "`readOnly`") {
+		t.Logf("expected an error naming the config but got %v", err)
+		t.Fail()
+	}
+
+	ctx.ReadOnly = true
+	if err := ctx.CheckWritable("apply"); err == nil || !strings.Contains(err.Error(), "`--read-only`") {
+		t.Logf("expected an error naming the flag but got %v", err)
+		t.Fail()
+	}
+}
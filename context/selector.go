@@ -0,0 +1,119 @@
+package ankh
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A requirement of a LabelSelector on one label.
+type labelRequirement struct {
+	Key   string
+	Value string
+	// Whether the label must not have the value, ie: `key!=value`
+	NotEqual bool
+}
+
+// A LabelSelector selects contexts, the charts of an Ankh file, or rendered
+// objects by their `labels`, like a Kubernetes label selector, eg:
+// `region=us-east-1,tier!=canary`. Each is selected when it meets every
+// requirement.
+type LabelSelector []labelRequirement
+
+// ParseLabelSelector parses a comma separated list of `key=value` and
+// `key!=value` requirements.
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	requirements := LabelSelector{}
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		requirement := labelRequirement{}
+		parts := strings.SplitN(term, "!=", 2)
+		if len(parts) == 2 {
+			requirement.NotEqual = true
+		} else {
+			parts = strings.SplitN(strings.Replace(term, "==", "=", 1), "=", 2)
+		}
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("Malformed selector requirement '%v'. Requirements must be passed as 'key=value' or 'key!=value'", term)
+		}
+		requirement.Key = strings.TrimSpace(parts[0])
+		requirement.Value = strings.TrimSpace(parts[1])
+		requirements = append(requirements, requirement)
+	}
+	if len(requirements) == 0 {
+		return nil, fmt.Errorf("Empty selector. Pass requirements on labels, eg: `region=us-east-1,tier=edge`")
+	}
+	return requirements, nil
+}
+
+func (selector LabelSelector) String() string {
+	terms := []string{}
+	for _, requirement := range selector {
+		op := "="
+		if requirement.NotEqual {
+			op = "!="
+		}
+		terms = append(terms, requirement.Key+op+requirement.Value)
+	}
+	return strings.Join(terms, ",")
+}
+
+// Matches reports whether a context, chart or object with the given labels is selected.
+func (selector LabelSelector) Matches(labels map[string]string) bool {
+	for _, requirement := range selector {
+		value, ok := labels[requirement.Key]
+		if requirement.NotEqual == (ok && value == requirement.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// TargetContexts returns the contexts that an operation runs over: those of
+// the environment, narrowed down by the context selector, if any. Without an
+// environment, the selector chooses from every context. Empty when operating
+// on the current context alone.
+func (ctx *ExecutionContext) TargetContexts() []string {
+	contexts := []string{}
+	if environment, ok := ctx.AnkhConfig.Environments[ctx.Environment]; ok && ctx.Environment != "" {
+		contexts = environment.Contexts
+	} else if ctx.ContextSelector != nil {
+		for name := range ctx.AnkhConfig.Contexts {
+			contexts = append(contexts, name)
+		}
+		sort.Strings(contexts)
+	}
+	if ctx.ContextSelector == nil {
+		return contexts
+	}
+
+	selected := []string{}
+	for _, name := range contexts {
+		if ctx.ContextSelector.Matches(ctx.AnkhConfig.Contexts[name].Labels) {
+			selected = append(selected, name)
+		}
+	}
+	return selected
+}
+
+// SelectCharts narrows the charts of an Ankh file down to those selected by
+// `--selector`, if any.
+func SelectCharts(ctx *ExecutionContext, ankhFile *AnkhFile) {
+	if ctx.ChartSelector == nil {
+		return
+	}
+
+	selected := []Chart{}
+	for _, chart := range ankhFile.Charts {
+		if ctx.ChartSelector.Matches(chart.Labels) {
+			selected = append(selected, chart)
+		} else {
+			ctx.Logger.Debugf("Skipping chart %v, which does not match selector %v", chart.InstanceName(), ctx.ChartSelector)
+		}
+	}
+	ankhFile.Charts = selected
+}
@@ -0,0 +1,126 @@
+package ankh
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	selector, err := ParseLabelSelector("region=us-east-1, tier!=canary,zone==a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selector.String() != "region=us-east-1,tier!=canary,zone=a" {
+		t.Logf("got unexpected selector %v", selector)
+		t.Fail()
+	}
+
+	for _, malformed := range []string{"", "region", "=us-east-1", ","} {
+		if _, err := ParseLabelSelector(malformed); err == nil {
+			t.Logf("expected an error for selector %q", malformed)
+			t.Fail()
+		}
+	}
+}
+
+func TestTargetContexts(t *testing.T) {
+	ctx := &ExecutionContext{}
+	ctx.AnkhConfig.Contexts = map[string]Context{
+		"east-edge":   {Labels: map[string]string{"region": "us-east-1", "tier": "edge"}},
+		"east-core":   {Labels: map[string]string{"region": "us-east-1", "tier": "core"}},
+		"east-canary": {Labels: map[string]string{"region": "us-east-1", "tier": "canary"}},
+		"west-edge":   {Labels: map[string]string{"region": "us-west-2", "tier": "edge"}},
+		"unlabeled":   {},
+	}
+	ctx.AnkhConfig.Environments = map[string]Environment{
+		"production": {Contexts: []string{"west-edge", "east-edge", "east-core"}},
+	}
+
+	if contexts := ctx.TargetContexts(); len(contexts) != 0 {
+		t.Logf("expected no target contexts without an environment or a selector but got %v", contexts)
+		t.Fail()
+	}
+
+	ctx.Environment = "production"
+	if contexts := ctx.TargetContexts(); !reflect.DeepEqual(contexts, []string{"west-edge", "east-edge", "east-core"}) {
+		t.Logf("expected the contexts of the environment, in order, but got %v", contexts)
+		t.Fail()
+	}
+
+	// Within an environment, the selector narrows down its contexts.
+	ctx.ContextSelector, _ = ParseLabelSelector("tier=edge")
+	if contexts := ctx.TargetContexts(); !reflect.DeepEqual(contexts, []string{"west-edge", "east-edge"}) {
+		t.Logf("expected the edge contexts of the environment but got %v", contexts)
+		t.Fail()
+	}
+
+	// Without one, it selects from every context.
+	ctx.Environment = ""
+	ctx.ContextSelector, _ = ParseLabelSelector("region=us-east-1,tier!=canary")
+	if contexts := ctx.TargetContexts(); !reflect.DeepEqual(contexts, []string{"east-core", "east-edge"}) {
+		t.Logf("expected the east contexts other than the canary but got %v", contexts)
+		t.Fail()
+	}
+
+	ctx.ContextSelector, _ = ParseLabelSelector("region!=us-east-1")
+	if contexts := ctx.TargetContexts(); !reflect.DeepEqual(contexts, []string{"unlabeled", "west-edge"}) {
+		t.Logf("expected contexts without the label to match `!=` but got %v", contexts)
+		t.Fail()
+	}
+}
+
+const labeledChartsAnkhFileYAML string = `
+charts:
+  - name: api
+    version: 1.0.0
+    labels:
+      tier: backend
+  - name: worker
+    version: 1.0.0
+    labels:
+      tier: backend
+      team: data
+  - name: web
+    version: 1.0.0
+    labels:
+      tier: frontend
+`
+
+func TestSelectCharts(t *testing.T) {
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(labeledChartsAnkhFileYAML)
+	file.Close()
+
+	for _, tc := range []struct {
+		selector string
+		chart    string
+		expected []string
+	}{
+		{"tier=backend", "", []string{"api", "worker"}},
+		{"tier=backend,team!=data", "", []string{"api"}},
+		{"tier=backend", "web", []string{}},
+		{"team=platform", "", []string{}},
+	} {
+		ctx := &ExecutionContext{Logger: log, AnkhFilePath: file.Name(), Chart: tc.chart}
+		ctx.ChartSelector, _ = ParseLabelSelector(tc.selector)
+		ankhFile, err := GetAnkhFile(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names := []string{}
+		for _, chart := range ankhFile.Charts {
+			names = append(names, chart.Name)
+		}
+		if !reflect.DeepEqual(names, tc.expected) {
+			t.Logf("expected charts %v for selector %v and chart %q but got %v", tc.expected, tc.selector, tc.chart, names)
+			t.Fail()
+		}
+	}
+}
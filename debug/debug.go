@@ -0,0 +1,262 @@
+package debug
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	StagesDirName      = "stages"
+	StageIndexFileName = "index.yaml"
+
+	redacted = "<redacted>"
+)
+
+var documentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// A StageRecord describes one stage of a plan, as executed during a run. The
+// stage's input and output are saved next to the index, so that `ankh debug
+// last` can show exactly what each stage was given and produced.
+type StageRecord struct {
+	Sequence        int       `yaml:"sequence"`
+	Stage           string    `yaml:"stage"`
+	Context         string    `yaml:"context,omitempty"`
+	Namespace       string    `yaml:"namespace,omitempty"`
+	Start           time.Time `yaml:"start"`
+	DurationSeconds float64   `yaml:"durationSeconds"`
+	InputFile       string    `yaml:"inputFile,omitempty"`
+	OutputFile      string    `yaml:"outputFile,omitempty"`
+	Error           string    `yaml:"error,omitempty"`
+}
+
+// Redacts the values of Kubernetes Secrets in a stream of YAML documents, so
+// that templated secrets are never saved to the data dir. Anything that does
+// not parse as a YAML map, eg: kubectl's output, is kept as is.
+func redactSecrets(content string) string {
+	docs := documentSeparator.Split(content, -1)
+	changed := false
+	for i, doc := range docs {
+		obj := yaml.MapSlice{}
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			continue
+		}
+
+		isSecret := false
+		for _, item := range obj {
+			if item.Key == "kind" && item.Value == "Secret" {
+				isSecret = true
+			}
+		}
+		if !isSecret {
+			continue
+		}
+
+		for j, item := range obj {
+			values, ok := item.Value.(yaml.MapSlice)
+			if !ok || (item.Key != "data" && item.Key != "stringData") {
+				continue
+			}
+			for k := range values {
+				values[k].Value = redacted
+			}
+			obj[j].Value = values
+		}
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			continue
+		}
+		docs[i] = "\n" + string(out)
+		changed = true
+	}
+
+	if !changed {
+		return content
+	}
+	return strings.Join(docs, "---")
+}
+
+// Redacts the given secret values, and their base64 encodings, wherever they
+// appear in content, eg: when a chart templates a decrypted value into a
+// ConfigMap, an environment variable or an annotation. Longer values are
+// redacted first, so that a value containing another is redacted whole.
+func redactValues(content string, values []string) string {
+	candidates := []string{}
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		candidates = append(candidates, value, base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return len(candidates[i]) > len(candidates[j])
+	})
+	for _, candidate := range candidates {
+		content = strings.Replace(content, candidate, redacted, -1)
+	}
+	return content
+}
+
+// Redact removes the values of Kubernetes Secrets, and any of the given secret
+// values, from content before it is saved or uploaded anywhere.
+func Redact(content string, secretValues []string) string {
+	return redactValues(redactSecrets(content), secretValues)
+}
+
+func writeStageFile(dir string, name string, content string, secretValues []string) (string, error) {
+	if content == "" {
+		return "", nil
+	}
+	content = Redact(content, secretValues)
+	return name, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+}
+
+// RecordStage saves a stage's input and output under the run's data dir, and
+// appends the stage to the run's index. The values of Secrets, and any of the
+// given secret values, are redacted from everything saved.
+func RecordStage(dataDir string, record StageRecord, input string, output string, secretValues []string) error {
+	dir := filepath.Join(dataDir, StagesDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	records, err := LoadStages(dataDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	record.Sequence = len(records) + 1
+
+	record.Error = redactValues(record.Error, secretValues)
+
+	prefix := fmt.Sprintf("%03d-%v", record.Sequence, strings.ToLower(record.Stage))
+	if record.InputFile, err = writeStageFile(dir, prefix+"-input.yaml", input, secretValues); err != nil {
+		return err
+	}
+	if record.OutputFile, err = writeStageFile(dir, prefix+"-output.yaml", output, secretValues); err != nil {
+		return err
+	}
+
+	out, err := yaml.Marshal(append(records, record))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, StageIndexFileName), out, 0644)
+}
+
+// LoadStages reads the index of the stages recorded for a run, in the order
+// they were executed.
+func LoadStages(dataDir string) ([]StageRecord, error) {
+	body, err := ioutil.ReadFile(filepath.Join(dataDir, StagesDirName, StageIndexFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	records := []StageRecord{}
+	if err := yaml.Unmarshal(body, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// LastRun returns the data dir of the most recent run under the base data dir
+// that recorded any stages, other than the current run's data dir.
+func LastRun(baseDataDir string, currentDataDir string) (string, error) {
+	paths, err := filepath.Glob(filepath.Join(baseDataDir, "*", StagesDirName, StageIndexFileName))
+	if err != nil {
+		return "", err
+	}
+
+	last := ""
+	var lastModified time.Time
+	for _, path := range paths {
+		runDir := filepath.Dir(filepath.Dir(path))
+		if runDir == filepath.Clean(currentDataDir) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if last == "" || info.ModTime().After(lastModified) {
+			last, lastModified = runDir, info.ModTime()
+		}
+	}
+	if last == "" {
+		return "", fmt.Errorf("No runs with recorded stages found in %v", baseDataDir)
+	}
+	return last, nil
+}
+
+func countLines(dir string, name string) string {
+	body, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return fmt.Sprintf("unreadable: %v", err)
+	}
+	return fmt.Sprintf("%v lines", bytes.Count(body, []byte("\n")))
+}
+
+func indent(content string, prefix string) string {
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	return prefix + strings.Join(lines, "\n"+prefix) + "\n"
+}
+
+// Report pretty-prints the pipeline of a run: each stage, where it ran, how
+// long it took, and whether it failed. With full, the contents of each stage's
+// input and output are included, rather than only their paths.
+func Report(dataDir string, records []StageRecord, full bool) string {
+	if len(records) == 0 {
+		return fmt.Sprintf("No stages recorded for run %v\n", filepath.Base(dataDir))
+	}
+
+	var buf bytes.Buffer
+	dir := filepath.Join(dataDir, StagesDirName)
+	fmt.Fprintf(&buf, "Run %v, started %v\n\n", filepath.Base(dataDir), records[0].Start.Format(time.RFC3339))
+
+	sorted := append([]StageRecord{}, records...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Sequence < sorted[j].Sequence
+	})
+	for _, record := range sorted {
+		result := "ok"
+		if record.Error != "" {
+			result = "FAILED"
+		}
+		where := []string{}
+		if record.Context != "" {
+			where = append(where, "context "+record.Context)
+		}
+		if record.Namespace != "" {
+			where = append(where, "namespace "+record.Namespace)
+		}
+		duration := time.Duration(record.DurationSeconds * float64(time.Second)).Round(time.Millisecond)
+		fmt.Fprintf(&buf, "%v. %v [%v] %v, %v\n", record.Sequence, record.Stage, strings.Join(where, ", "), duration, result)
+
+		for _, file := range []struct{ label, name string }{{"input", record.InputFile}, {"output", record.OutputFile}} {
+			if file.name == "" {
+				fmt.Fprintf(&buf, "   %v: (empty)\n", file.label)
+				continue
+			}
+			path := filepath.Join(dir, file.name)
+			fmt.Fprintf(&buf, "   %v: %v (%v)\n", file.label, path, countLines(dir, file.name))
+			if full {
+				body, err := ioutil.ReadFile(path)
+				if err == nil {
+					buf.WriteString(indent(string(body), "     | "))
+				}
+			}
+		}
+		if record.Error != "" {
+			fmt.Fprintf(&buf, "   error:\n%v", indent(record.Error, "     "))
+		}
+	}
+	return buf.String()
+}
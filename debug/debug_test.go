@@ -0,0 +1,124 @@
+package debug
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const templated string = `---
+# Source: foo/templates/secret.yaml
+apiVersion: v1
+kind: Secret
+metadata:
+  name: foo
+data:
+  password: aHVudGVyMg==
+stringData:
+  token: hunter2
+---
+# Source: foo/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+data:
+  password: not-a-secret
+`
+
+func TestRedactSecrets(t *testing.T) {
+	out := redactSecrets(templated)
+	if strings.Contains(out, "aHVudGVyMg==") || strings.Contains(out, "hunter2") {
+		t.Logf("expected secret values to be redacted but got %v", out)
+		t.Fail()
+	}
+	if !strings.Contains(out, "password: not-a-secret") || !strings.Contains(out, "kind: ConfigMap") {
+		t.Logf("expected other objects to be kept but got %v", out)
+		t.Fail()
+	}
+
+	text := "deployment.apps/foo configured\n"
+	if out := redactSecrets(text); out != text {
+		t.Logf("expected %v to be kept but got %v", text, out)
+		t.Fail()
+	}
+}
+
+func TestRecordStageRedactsSecretValues(t *testing.T) {
+	runDir, err := ioutil.TempDir("", "ankh-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(runDir)
+
+	output := `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+  annotations:
+    token: czNjcjN0
+data:
+  DATABASE_URL: postgres://app:s3cr3t@db:5432/app
+`
+	record := StageRecord{Stage: "helm.TemplateStage", Error: "unable to parse s3cr3t"}
+	if err := RecordStage(runDir, record, "", output, []string{"s3cr3t", ""}); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := LoadStages(runDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := Report(runDir, records, true)
+	if strings.Contains(report, "s3cr3t") || strings.Contains(report, "czNjcjN0") {
+		t.Logf("expected secret values to be redacted everywhere but got %v", report)
+		t.Fail()
+	}
+	if !strings.Contains(report, "postgres://app:<redacted>@db:5432/app") {
+		t.Logf("expected the rest of the output to be kept but got %v", report)
+		t.Fail()
+	}
+}
+
+func TestRecordStage(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "ankh-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	runDir := filepath.Join(baseDir, "1-1")
+	if err := RecordStage(runDir, StageRecord{Stage: "helm.TemplateStage"}, "", templated, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordStage(runDir, StageRecord{Stage: "kubectl.ApplyStage", Error: "boom"}, templated, "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := LoadStages(runDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1].Sequence != 2 || records[0].InputFile != "" || records[1].InputFile == "" {
+		t.Logf("got unexpected records %+v", records)
+		t.Fail()
+	}
+
+	report := Report(runDir, records, true)
+	if !strings.Contains(report, "2. kubectl.ApplyStage") || !strings.Contains(report, "FAILED") ||
+		!strings.Contains(report, "kind: ConfigMap") {
+		t.Logf("got unexpected report %v", report)
+		t.Fail()
+	}
+
+	currentDir := filepath.Join(baseDir, "2-2")
+	RecordStage(currentDir, StageRecord{Stage: "helm.TemplateStage"}, "", "", nil)
+	last, err := LastRun(baseDir, currentDir)
+	if err != nil || last != runDir {
+		t.Logf("expected last run %v but got %v and error %v", runDir, last, err)
+		t.Fail()
+	}
+}
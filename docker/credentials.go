@@ -0,0 +1,178 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+)
+
+// The parts of the docker CLI config that hold registry credentials, as
+// written by `docker login`.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth string `json:"auth"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// ConfigPath returns the path of the docker CLI config, in $DOCKER_CONFIG or
+// else ~/.docker.
+func ConfigPath() string {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".docker")
+	}
+	return filepath.Join(dir, "config.json")
+}
+
+// Registries may be listed with or without a scheme.
+func configKeys(registryDomain string) []string {
+	host := strings.TrimPrefix(strings.TrimPrefix(registryDomain, "https://"), "http://")
+	host = strings.TrimRight(host, "/")
+	return []string{host, "https://" + host, "http://" + host}
+}
+
+// Runs a docker credential helper, eg: `docker-credential-ecr-login`.
+func credentialsFromHelper(helper string, host string) (string, string, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(host)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", "", fmt.Errorf("error running docker-credential-%v: %v %v", helper, err, strings.TrimSpace(stderr.String()))
+	}
+
+	creds := struct {
+		Username string
+		Secret   string
+	}{}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return "", "", fmt.Errorf("Could not parse the output of docker-credential-%v: %v", helper, err)
+	}
+	return creds.Username, creds.Secret, nil
+}
+
+func credentialsFromConfig(ctx *ankh.ExecutionContext, path string, registryDomain string) (string, string, error) {
+	body, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", "", nil
+	} else if err != nil {
+		return "", "", err
+	}
+
+	config := dockerConfig{}
+	if err := json.Unmarshal(body, &config); err != nil {
+		return "", "", fmt.Errorf("Could not parse docker config %v: %v", path, err)
+	}
+
+	for _, key := range configKeys(registryDomain) {
+		if helper, ok := config.CredHelpers[key]; ok {
+			ctx.Logger.Debugf("Using docker credential helper %v for registry %v", helper, key)
+			return credentialsFromHelper(helper, key)
+		}
+	}
+	for _, key := range configKeys(registryDomain) {
+		auth, ok := config.Auths[key]
+		if !ok {
+			continue
+		}
+		if auth.Auth == "" && config.CredsStore != "" {
+			ctx.Logger.Debugf("Using docker credential store %v for registry %v", config.CredsStore, key)
+			return credentialsFromHelper(config.CredsStore, key)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return "", "", fmt.Errorf("Could not decode credentials for registry %v in %v: %v", key, path, err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return "", "", fmt.Errorf("Malformed credentials for registry %v in %v", key, path)
+		}
+		ctx.Logger.Debugf("Using credentials for registry %v from %v", key, path)
+		return parts[0], parts[1], nil
+	}
+	return "", "", nil
+}
+
+// ECR registries are named like <account>.dkr.ecr.<region>.amazonaws.com
+var ecrRegistryPattern = regexp.MustCompile(`^[0-9]+\.dkr\.ecr(-fips)?\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?$`)
+
+// ECR tokens last for hours, so exchange them once per run and registry.
+var ecrPasswords = map[string]string{}
+
+// Exchanges AWS credentials for an ECR token, with the AWS CLI.
+func credentialsFromECR(ctx *ankh.ExecutionContext, host string, profile string) (string, string, error) {
+	if password, ok := ecrPasswords[host]; ok {
+		return "AWS", password, nil
+	}
+
+	region := ecrRegistryPattern.FindStringSubmatch(host)[2]
+	args := []string{"ecr", "get-login-password", "--region", region}
+	if profile != "" {
+		args = append(args, "--profile", profile)
+	}
+	ctx.Logger.Debugf("Exchanging AWS credentials for an ECR token for registry %v", host)
+	cmd := exec.Command("aws", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", "", fmt.Errorf("error running aws ecr get-login-password for registry %v: %v %v", host, err, strings.TrimSpace(stderr.String()))
+	}
+
+	password := strings.TrimSpace(stdout.String())
+	ecrPasswords[host] = password
+	return "AWS", password, nil
+}
+
+// Looks up the registry under `docker.auth`, by host with or without a scheme.
+func configuredAuth(ctx *ankh.ExecutionContext, registryDomain string) (ankh.DockerRegistryAuth, bool) {
+	for _, key := range configKeys(registryDomain) {
+		if auth, ok := ctx.AnkhConfig.Docker.Auth[key]; ok {
+			return auth, true
+		}
+	}
+	return ankh.DockerRegistryAuth{}, false
+}
+
+// Credentials returns the username and password for a registry, from
+// `ANKH_DOCKER_REGISTRY_USERNAME` and `ANKH_DOCKER_REGISTRY_PASSWORD`, from
+// `docker.auth`, or else from the docker CLI config. ECR registries that have
+// none of these exchange AWS credentials for a token. Both are empty when no
+// credentials are found, since read-only operations generally work anonymously.
+func Credentials(ctx *ankh.ExecutionContext, registryDomain string) (string, string, error) {
+	username := os.Getenv("ANKH_DOCKER_REGISTRY_USERNAME")
+	password := os.Getenv("ANKH_DOCKER_REGISTRY_PASSWORD")
+	if username != "" || password != "" {
+		return username, password, nil
+	}
+
+	auth, configured := configuredAuth(ctx, registryDomain)
+	if auth.Username != "" || auth.Password != "" {
+		ctx.Logger.Debugf("Using credentials for registry %v from `docker.auth`", registryDomain)
+		return auth.Username, auth.Password, nil
+	}
+
+	host := configKeys(registryDomain)[0]
+	if !configured || !ecrRegistryPattern.MatchString(host) {
+		username, password, err := credentialsFromConfig(ctx, ConfigPath(), registryDomain)
+		if err != nil || username != "" || password != "" {
+			return username, password, err
+		}
+	}
+	if ecrRegistryPattern.MatchString(host) {
+		return credentialsFromECR(ctx, host, auth.AWSProfile)
+	}
+	return "", "", nil
+}
@@ -0,0 +1,86 @@
+package docker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+func TestCredentialsFromConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-docker-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// "user:secret"
+	config := `{"auths": {"https://harbor.example.com": {"auth": "dXNlcjpzZWNyZXQ="}}}`
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &ankh.ExecutionContext{Logger: logrus.New()}
+	username, password, err := credentialsFromConfig(ctx, path, "harbor.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "user" || password != "secret" {
+		t.Logf("expected user and secret but got %v and %v", username, password)
+		t.Fail()
+	}
+
+	username, password, err = credentialsFromConfig(ctx, path, "quay.io")
+	if err != nil || username != "" || password != "" {
+		t.Logf("expected no credentials for another registry but got %v, %v, %v", username, password, err)
+		t.Fail()
+	}
+
+	username, _, err = credentialsFromConfig(ctx, filepath.Join(dir, "missing.json"), "harbor.example.com")
+	if err != nil || username != "" {
+		t.Logf("expected no credentials without a config but got %v, %v", username, err)
+		t.Fail()
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-docker-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("DOCKER_CONFIG", dir)
+	defer os.Unsetenv("DOCKER_CONFIG")
+
+	ctx := ankhtest.NewContext(t)
+	ctx.AnkhConfig.Docker.Auth = map[string]ankh.DockerRegistryAuth{
+		"harbor.example.com": {Username: "robot", Password: "secret"},
+		"123456789012.dkr.ecr.us-west-2.amazonaws.com": {AWSProfile: "prod"},
+	}
+
+	username, password, err := Credentials(ctx, "https://harbor.example.com")
+	if err != nil || username != "robot" || password != "secret" {
+		t.Logf("expected the credentials from `docker.auth` but got %v, %v, %v", username, password, err)
+		t.Fail()
+	}
+
+	tools := ankhtest.NewTools(t)
+	tools.Fake("aws", ankhtest.Rule{Args: "ecr get-login-password --region us-west-2 --profile prod", Stdout: "ecr-token\n"})
+	for i := 0; i < 2; i++ {
+		username, password, err = Credentials(ctx, "123456789012.dkr.ecr.us-west-2.amazonaws.com")
+		if err != nil || username != "AWS" || password != "ecr-token" {
+			t.Logf("expected an ECR token but got %v, %v, %v", username, password, err)
+			t.Fail()
+		}
+	}
+	if calls := tools.Calls("aws"); len(calls) != 1 {
+		t.Logf("expected the ECR token to be exchanged once but got %+v", calls)
+		t.Fail()
+	}
+}
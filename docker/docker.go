@@ -66,8 +66,15 @@ func newRegistry(ctx *ankh.ExecutionContext, registryDomain string) (*registry.R
 		registryDomain = strings.Replace(registryDomain, "https://docker.io", "https://registry-1.docker.io", 1)
 	}
 
+	// Read-only operations generally work anonymously, but deleting tags requires credentials.
+	username, password, err := Credentials(ctx, registryDomain)
+	if err != nil {
+		return nil, err
+	}
 	auth := types.AuthConfig{
 		ServerAddress: registryDomain,
+		Username:      username,
+		Password:      password,
 	}
 
 	return registry.New(auth, registry.Opt{
@@ -92,9 +99,75 @@ func ListTags(ctx *ankh.ExecutionContext, registryDomain string, image string, d
 		return "", err
 	}
 
+	if ctx.Output != "" {
+		listing := ImageListing{Name: image, Tags: append([]string{}, tags...)}
+		return util.FormatOutput(ctx.Output, listing)
+	}
 	return strings.Join(tags, "\n"), nil
 }
 
+// An ImageListing is an image and its tags, for `--output`. Error is set when
+// the tags of the image could not be listed.
+type ImageListing struct {
+	Name  string   `json:"name" yaml:"name"`
+	Tags  []string `json:"tags" yaml:"tags"`
+	Error string   `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// RepositoryTags returns every tag of a repository, eg: an image or an OCI chart, unsorted.
+func RepositoryTags(ctx *ankh.ExecutionContext, registryDomain string, repository string) ([]string, error) {
+	r, err := newRegistry(ctx, registryDomain)
+	if err != nil {
+		return []string{}, err
+	}
+	return r.Tags(repository)
+}
+
+// Repositories returns every repository in a registry's catalog.
+func Repositories(ctx *ankh.ExecutionContext, registryDomain string) ([]string, error) {
+	r, err := newRegistry(ctx, registryDomain)
+	if err != nil {
+		return []string{}, err
+	}
+	return r.Catalog("")
+}
+
+// TagExists checks whether the registry has a tag for an image.
+func TagExists(ctx *ankh.ExecutionContext, registryDomain string, image string, tag string) (bool, error) {
+	r, err := newRegistry(ctx, registryDomain)
+	if err != nil {
+		return false, err
+	}
+
+	tags, err := r.Tags(image)
+	if err != nil {
+		warnAboutDockerHub(ctx, r.Domain)
+		return false, err
+	}
+	for _, t := range tags {
+		if t == tag {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// TagDigest returns the digest of the manifest that an image's tag points to,
+// eg: `sha256:...`.
+func TagDigest(ctx *ankh.ExecutionContext, registryDomain string, image string, tag string) (string, error) {
+	r, err := newRegistry(ctx, registryDomain)
+	if err != nil {
+		return "", err
+	}
+
+	digest, err := r.Digest(registry.Image{Path: image, Tag: tag})
+	if err != nil {
+		warnAboutDockerHub(ctx, r.Domain)
+		return "", err
+	}
+	return digest.String(), nil
+}
+
 func listTags(ctx *ankh.ExecutionContext, r *registry.Registry,
 	image string, limit int, descending bool) ([]string, error) {
 	tags, err := r.Tags(image)
@@ -140,6 +213,9 @@ func ListImages(ctx *ankh.ExecutionContext, registry string, numToShow int) (str
 
 	if len(catalog) == 0 {
 		ctx.Logger.Warnf("No images in catalog for registry '%v'", r.Domain)
+		if ctx.Output != "" {
+			return util.FormatOutput(ctx.Output, []ImageListing{})
+		}
 		return "", nil
 	}
 	sort.Strings(catalog)
@@ -147,6 +223,7 @@ func ListImages(ctx *ankh.ExecutionContext, registry string, numToShow int) (str
 	type WorkItem struct {
 		Image string
 		Tags  []string
+		Error error
 	}
 
 	// Map image names to the list of tags that we fetch from the registry
@@ -168,6 +245,7 @@ func ListImages(ctx *ankh.ExecutionContext, registry string, numToShow int) (str
 				if err != nil {
 					ctx.Logger.Warnf("Could not list tags for image %v: %v", work.Image, err)
 					work.Tags = []string{"ErrorSentinel"}
+					work.Error = err
 					continue
 				}
 
@@ -186,13 +264,26 @@ func ListImages(ctx *ankh.ExecutionContext, registry string, numToShow int) (str
 		<-doneChannel
 	}
 
+	if ctx.Output != "" {
+		listings := []ImageListing{}
+		for _, work := range workItems {
+			listing := ImageListing{Name: work.Image, Tags: work.Tags}
+			if work.Error != nil {
+				listing.Tags, listing.Error = []string{}, work.Error.Error()
+			}
+			listings = append(listings, listing)
+		}
+		return util.FormatOutput(ctx.Output, listings)
+	}
+
+	colors := util.GetListingColors(ctx)
 	formatted := bytes.NewBufferString("")
 	w := tabwriter.NewWriter(formatted, 0, 8, 8, ' ', 0)
 	fmt.Fprintf(w, "NAME\tTAG(S)\n")
 	for _, work := range workItems {
-		fmt.Fprintf(w, "%v\t%v\n", work.Image, strings.Join(work.Tags, ", "))
+		fmt.Fprintf(w, "%v\t%v\n", work.Image, colors.HighlightNewest(work.Tags))
 	}
 	w.Flush()
 
-	return formatted.String(), nil
+	return colors.HighlightHeader(formatted.String()), nil
 }
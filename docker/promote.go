@@ -0,0 +1,110 @@
+package docker
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// The parts of the registry API needed to copy an image tag.
+type imageStore interface {
+	Manifest(repository, ref string) (distribution.Manifest, error)
+	PutManifest(repository, ref string, manifest distribution.Manifest) error
+	HasLayer(repository string, digest digest.Digest) (bool, error)
+	DownloadLayer(repository string, digest digest.Digest) (io.ReadCloser, error)
+	UploadLayer(repository string, digest reference.Reference, content io.Reader) error
+}
+
+// PromoteRegistries returns the registries to promote between, defaulting to
+// those configured under `docker.promote`, and the target to `docker.registry`.
+func PromoteRegistries(ctx *ankh.ExecutionContext, sourceRegistry string, targetRegistry string) (string, string, error) {
+	if sourceRegistry == "" {
+		sourceRegistry = ctx.AnkhConfig.Docker.Promote.SourceRegistry
+	}
+	if targetRegistry == "" {
+		targetRegistry = ctx.AnkhConfig.Docker.Promote.TargetRegistry
+	}
+	if targetRegistry == "" {
+		targetRegistry = ctx.AnkhConfig.Docker.Registry
+	}
+
+	if sourceRegistry == "" {
+		return "", "", fmt.Errorf("No source registry given, and none configured as `docker.promote.sourceRegistry`")
+	}
+	if targetRegistry == "" {
+		return "", "", fmt.Errorf("No target registry given, and none configured as `docker.promote.targetRegistry` or `docker.registry`")
+	}
+	if sourceRegistry == targetRegistry {
+		return "", "", fmt.Errorf("Cannot promote from registry \"%v\" to itself", sourceRegistry)
+	}
+	return sourceRegistry, targetRegistry, nil
+}
+
+// PromoteTag copies an image tag, with its manifest and every blob the
+// manifest refers to, from one registry to another. The manifest is copied as
+// is, so the tag has the same digest in both registries.
+func PromoteTag(ctx *ankh.ExecutionContext, sourceRegistry string, targetRegistry string, image string, tag string) error {
+	source, err := newRegistry(ctx, sourceRegistry)
+	if err != nil {
+		return err
+	}
+	target, err := newRegistry(ctx, targetRegistry)
+	if err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof("Promoting %v:%v from registry \"%v\" to \"%v\"", image, tag, sourceRegistry, targetRegistry)
+	if ctx.DryRun {
+		ctx.Logger.Infof("--dry-run set, not copying the image")
+		return nil
+	}
+	copied, err := promoteTag(source, target, image, tag)
+	if err != nil {
+		return fmt.Errorf("Unable to promote %v:%v: %v", image, tag, err)
+	}
+	ctx.Logger.Infof("Promoted %v:%v, copying %v blob(s)", image, tag, copied)
+	return nil
+}
+
+// Copies the blobs that the target does not have yet, then the manifest, and
+// returns how many blobs were copied.
+func promoteTag(source imageStore, target imageStore, image string, tag string) (int, error) {
+	manifest, err := source.Manifest(image, tag)
+	if err != nil {
+		return 0, err
+	}
+	// PutManifest only sends schema2 manifests, so anything else, eg: a
+	// manifest list, would be rejected or silently mangled.
+	if _, ok := manifest.(*schema2.DeserializedManifest); !ok {
+		return 0, fmt.Errorf("only single-platform (schema2) image manifests can be promoted")
+	}
+
+	copied := 0
+	for _, descriptor := range manifest.References() {
+		present, err := target.HasLayer(image, descriptor.Digest)
+		if err != nil {
+			return copied, err
+		}
+		if present {
+			continue
+		}
+
+		blob, err := source.DownloadLayer(image, descriptor.Digest)
+		if err != nil {
+			return copied, err
+		}
+		err = target.UploadLayer(image, descriptor.Digest, blob)
+		blob.Close()
+		if err != nil {
+			return copied, fmt.Errorf("unable to copy blob %v: %v", descriptor.Digest, err)
+		}
+		copied++
+	}
+
+	return copied, target.PutManifest(image, tag, manifest)
+}
@@ -0,0 +1,97 @@
+package docker
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+)
+
+type fakeImageStore struct {
+	manifests map[string]distribution.Manifest
+	blobs     map[digest.Digest][]byte
+	uploads   []digest.Digest
+}
+
+func newFakeImageStore() *fakeImageStore {
+	return &fakeImageStore{manifests: map[string]distribution.Manifest{}, blobs: map[digest.Digest][]byte{}}
+}
+
+func (store *fakeImageStore) Manifest(repository, ref string) (distribution.Manifest, error) {
+	return store.manifests[repository+":"+ref], nil
+}
+
+func (store *fakeImageStore) PutManifest(repository, ref string, manifest distribution.Manifest) error {
+	store.manifests[repository+":"+ref] = manifest
+	return nil
+}
+
+func (store *fakeImageStore) HasLayer(repository string, digest digest.Digest) (bool, error) {
+	_, ok := store.blobs[digest]
+	return ok, nil
+}
+
+func (store *fakeImageStore) DownloadLayer(repository string, digest digest.Digest) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(store.blobs[digest])), nil
+}
+
+func (store *fakeImageStore) UploadLayer(repository string, ref reference.Reference, content io.Reader) error {
+	body, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	store.blobs[digest.Digest(ref.String())] = body
+	store.uploads = append(store.uploads, digest.Digest(ref.String()))
+	return nil
+}
+
+func TestPromoteTag(t *testing.T) {
+	config, layer, sharedLayer := []byte("config"), []byte("layer"), []byte("base layer")
+	manifest, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    distribution.Descriptor{MediaType: schema2.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))},
+		Layers: []distribution.Descriptor{
+			{MediaType: schema2.MediaTypeLayer, Digest: digest.FromBytes(sharedLayer), Size: int64(len(sharedLayer))},
+			{MediaType: schema2.MediaTypeLayer, Digest: digest.FromBytes(layer), Size: int64(len(layer))},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := newFakeImageStore()
+	source.manifests["app:1.0.0"] = manifest
+	for _, blob := range [][]byte{config, layer, sharedLayer} {
+		source.blobs[digest.FromBytes(blob)] = blob
+	}
+	target := newFakeImageStore()
+	target.blobs[digest.FromBytes(sharedLayer)] = sharedLayer
+
+	copied, err := promoteTag(source, target, "app", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 2 || len(target.uploads) != 2 {
+		t.Logf("expected only the blobs missing from the target to be copied but got %v", target.uploads)
+		t.Fail()
+	}
+	if string(target.blobs[digest.FromBytes(layer)]) != "layer" {
+		t.Logf("expected the layer to be copied but got %v", target.blobs)
+		t.Fail()
+	}
+
+	_, sourcePayload, _ := manifest.Payload()
+	promoted, ok := target.manifests["app:1.0.0"]
+	if !ok {
+		t.Fatalf("expected the manifest to be pushed to the target")
+	}
+	if _, payload, _ := promoted.Payload(); !bytes.Equal(payload, sourcePayload) {
+		t.Logf("expected the manifest to be copied as is, keeping its digest")
+		t.Fail()
+	}
+}
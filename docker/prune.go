@@ -0,0 +1,196 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/util"
+	"github.com/genuinetools/reg/registry"
+	digest "github.com/opencontainers/go-digest"
+)
+
+type PruneOpts struct {
+	// Prune tags created more than this many days ago
+	OlderThanDays int
+	// Never prune the newest this many tags, by semantic version
+	Keep int
+
+	DryRun, Yes bool
+}
+
+type tagInfo struct {
+	Tag     string
+	Digest  digest.Digest
+	Created time.Time
+	Prune   bool
+	Reason  string
+}
+
+// Reads the creation time from the image config referenced by a tag's manifest.
+func tagCreated(r *registry.Registry, image string, tag string) (time.Time, error) {
+	manifest, err := r.ManifestV2(image, tag)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	layer, err := r.DownloadLayer(image, manifest.Config.Digest)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer layer.Close()
+
+	body, err := ioutil.ReadAll(layer)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	config := struct {
+		Created time.Time `json:"created"`
+	}{}
+	if err := json.Unmarshal(body, &config); err != nil {
+		return time.Time{}, err
+	}
+	return config.Created, nil
+}
+
+// Decides which tags to prune. Tags are in descending semantic version order.
+// When both the age and count limits are set, a tag must exceed both.
+func selectTagsToPrune(tags []*tagInfo, opts PruneOpts, now time.Time) {
+	cutoff := now.AddDate(0, 0, -opts.OlderThanDays)
+
+	keptDigests := map[digest.Digest]bool{}
+	for i, tag := range tags {
+		beyondKeep := opts.Keep > 0 && i >= opts.Keep
+		tooOld := opts.OlderThanDays > 0 && !tag.Created.IsZero() && tag.Created.Before(cutoff)
+
+		switch {
+		case opts.Keep > 0 && opts.OlderThanDays > 0:
+			tag.Prune = beyondKeep && tooOld
+			tag.Reason = fmt.Sprintf("beyond newest %v and older than %v days", opts.Keep, opts.OlderThanDays)
+		case opts.Keep > 0:
+			tag.Prune = beyondKeep
+			tag.Reason = fmt.Sprintf("beyond newest %v", opts.Keep)
+		default:
+			tag.Prune = tooOld
+			tag.Reason = fmt.Sprintf("older than %v days", opts.OlderThanDays)
+		}
+
+		if !tag.Prune {
+			keptDigests[tag.Digest] = true
+		}
+	}
+
+	// Deleting a manifest deletes every tag that points to it, so never delete
+	// one that a kept tag shares.
+	for _, tag := range tags {
+		if tag.Prune && keptDigests[tag.Digest] {
+			tag.Prune = false
+			tag.Reason = "shares its digest with a kept tag"
+		}
+	}
+}
+
+// PruneTags deletes stale tags of an image from the registry, after listing them
+// and asking for confirmation.
+func PruneTags(ctx *ankh.ExecutionContext, registryDomain string, image string, opts PruneOpts) error {
+	if opts.OlderThanDays <= 0 && opts.Keep <= 0 {
+		return fmt.Errorf("Must provide at least one of `--older-than` or `--keep`")
+	}
+
+	r, err := newRegistry(ctx, registryDomain)
+	if err != nil {
+		return err
+	}
+
+	names, err := listTags(ctx, r, image, 0, true)
+	if err != nil {
+		return err
+	}
+
+	tags := []*tagInfo{}
+	for _, name := range names {
+		tag := &tagInfo{Tag: name}
+		tag.Digest, err = r.Digest(registry.Image{Path: image, Tag: name})
+		if err != nil {
+			return fmt.Errorf("Could not get the digest of %v:%v: %v", image, name, err)
+		}
+		if opts.OlderThanDays > 0 {
+			tag.Created, err = tagCreated(r, image, name)
+			if err != nil {
+				ctx.Logger.Warnf("Could not determine when %v:%v was created, it will not be pruned by age: %v", image, name, err)
+			}
+		}
+		tags = append(tags, tag)
+	}
+
+	selectTagsToPrune(tags, opts, time.Now())
+
+	toPrune := []*tagInfo{}
+	formatted := bytes.NewBufferString("")
+	w := tabwriter.NewWriter(formatted, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "TAG\tCREATED\tACTION\n")
+	for _, tag := range tags {
+		created := "-"
+		if !tag.Created.IsZero() {
+			created = tag.Created.Format("2006-01-02")
+		}
+		action := "keep"
+		if tag.Prune {
+			action = "prune (" + tag.Reason + ")"
+			toPrune = append(toPrune, tag)
+		} else if strings.HasPrefix(tag.Reason, "shares") {
+			action = "keep (" + tag.Reason + ")"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\n", tag.Tag, created, action)
+	}
+	w.Flush()
+	fmt.Print(formatted.String())
+
+	if len(toPrune) == 0 {
+		ctx.Logger.Infof("Nothing to prune for image %v", image)
+		return nil
+	}
+
+	if opts.DryRun {
+		ctx.Logger.Infof("Would prune %v of %v tags for image %v (dry run)", len(toPrune), len(tags), image)
+		return nil
+	}
+
+	if !opts.Yes {
+		if ctx.NoPrompt {
+			return fmt.Errorf("Refusing to delete tags without confirmation. Pass `--yes` to delete them when running with `--no-prompt`")
+		}
+		selection, err := util.PromptForConfirmation([]string{"No", "Yes"},
+			fmt.Sprintf("Delete %v tags from image %v?", len(toPrune), image))
+		if err != nil {
+			return err
+		}
+		if selection != "Yes" {
+			ctx.Logger.Infof("Not pruning any tags")
+			return nil
+		}
+	}
+
+	// Several pruned tags may share a digest, which only needs deleting once.
+	deleted := map[digest.Digest]bool{}
+	for _, tag := range toPrune {
+		if deleted[tag.Digest] {
+			continue
+		}
+		ctx.Logger.Infof("Deleting %v:%v (%v)", image, tag.Tag, tag.Digest)
+		if err := r.Delete(image, tag.Digest); err != nil {
+			warnAboutDockerHub(ctx, r.Domain)
+			return fmt.Errorf("Failed to delete %v:%v: %v", image, tag.Tag, err)
+		}
+		deleted[tag.Digest] = true
+	}
+
+	ctx.Logger.Infof("Pruned %v tags for image %v", len(toPrune), image)
+	return nil
+}
@@ -0,0 +1,60 @@
+package docker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelectTagsToPrune(t *testing.T) {
+	now := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	newTags := func() []*tagInfo {
+		// Descending by semantic version, as listTags returns them.
+		return []*tagInfo{
+			&tagInfo{Tag: "1.3.0", Digest: "sha256:c", Created: now.AddDate(0, 0, -1)},
+			&tagInfo{Tag: "1.2.0", Digest: "sha256:b", Created: now.AddDate(0, 0, -40)},
+			&tagInfo{Tag: "1.1.0", Digest: "sha256:a", Created: now.AddDate(0, 0, -5)},
+			&tagInfo{Tag: "1.0.0", Digest: "sha256:a", Created: now.AddDate(0, 0, -50)},
+		}
+	}
+	pruned := func(tags []*tagInfo) []string {
+		names := []string{}
+		for _, tag := range tags {
+			if tag.Prune {
+				names = append(names, tag.Tag)
+			}
+		}
+		return names
+	}
+	check := func(t *testing.T, got []string, expected ...string) {
+		if len(got) != len(expected) {
+			t.Logf("expected %v to be pruned but got %v", expected, got)
+			t.Fail()
+			return
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Logf("expected %v to be pruned but got %v", expected, got)
+				t.Fail()
+			}
+		}
+	}
+
+	t.Run("keep", func(t *testing.T) {
+		tags := newTags()
+		selectTagsToPrune(tags, PruneOpts{Keep: 1}, now)
+		check(t, pruned(tags), "1.2.0", "1.1.0", "1.0.0")
+	})
+
+	t.Run("older than", func(t *testing.T) {
+		tags := newTags()
+		selectTagsToPrune(tags, PruneOpts{OlderThanDays: 30}, now)
+		// 1.0.0 is old, but shares a digest with the kept 1.1.0
+		check(t, pruned(tags), "1.2.0")
+	})
+
+	t.Run("keep and older than", func(t *testing.T) {
+		tags := newTags()
+		selectTagsToPrune(tags, PruneOpts{Keep: 2, OlderThanDays: 30}, now)
+		check(t, pruned(tags))
+	})
+}
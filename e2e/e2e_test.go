@@ -0,0 +1,169 @@
+//go:build e2e
+
+// Package e2e runs Ankh against a real cluster. The suite is opt-in, since it
+// needs kind, kubectl and helm, and takes minutes:
+//
+//	go test -tags e2e ./e2e
+//
+// It creates a kind cluster named by ANKH_E2E_CLUSTER (default `ankh-e2e`),
+// or uses it if it exists. Clusters it creates are deleted afterwards, unless
+// ANKH_E2E_KEEP_CLUSTER is set.
+package e2e
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const namespace = "ankh-e2e"
+
+type suite struct {
+	t          *testing.T
+	dir        string
+	ankh       string
+	config     string
+	chartPath  string
+	kubeConfig string
+}
+
+func requireTools(t *testing.T, tools ...string) {
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("The e2e suite needs %v on the PATH", tool)
+		}
+	}
+}
+
+func run(t *testing.T, name string, args ...string) (string, error) {
+	t.Logf("Running %v %v", name, strings.Join(args, " "))
+	out, err := exec.Command(name, args...).CombinedOutput()
+	return string(out), err
+}
+
+func mustRun(t *testing.T, name string, args ...string) string {
+	out, err := run(t, name, args...)
+	if err != nil {
+		t.Fatalf("%v %v failed: %v\n%v", name, strings.Join(args, " "), err, out)
+	}
+	return out
+}
+
+func setupCluster(t *testing.T, dir string) string {
+	cluster := os.Getenv("ANKH_E2E_CLUSTER")
+	if cluster == "" {
+		cluster = "ankh-e2e"
+	}
+	kubeConfig := filepath.Join(dir, "kubeconfig")
+
+	clusters := mustRun(t, "kind", "get", "clusters")
+	if !strings.Contains("\n"+clusters, "\n"+cluster+"\n") {
+		mustRun(t, "kind", "create", "cluster", "--name", cluster, "--wait", "2m")
+		if os.Getenv("ANKH_E2E_KEEP_CLUSTER") == "" {
+			t.Cleanup(func() { run(t, "kind", "delete", "cluster", "--name", cluster) })
+		}
+	}
+	kubeConfigBody := mustRun(t, "kind", "get", "kubeconfig", "--name", cluster)
+	if err := ioutil.WriteFile(kubeConfig, []byte(kubeConfigBody), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return kubeConfig
+}
+
+func newSuite(t *testing.T) *suite {
+	requireTools(t, "kind", "kubectl", "helm", "go")
+
+	dir, err := ioutil.TempDir("", "ankh-e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	s := &suite{t: t, dir: dir, ankh: filepath.Join(dir, "ankh"), config: filepath.Join(dir, "config")}
+	s.chartPath, err = filepath.Abs("testdata/charts/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustRun(t, "go", "build", "-o", s.ankh, "../ankh")
+
+	s.kubeConfig = setupCluster(t, dir)
+	kubeContext := strings.TrimSpace(mustRun(t, "kubectl", "--kubeconfig", s.kubeConfig, "config", "current-context"))
+	config := fmt.Sprintf(`contexts:
+  e2e:
+    kube-context: %v
+    environment-class: dev
+    resource-profile: small
+`, kubeContext)
+	if err := ioutil.WriteFile(s.config, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s.kubectl("create", "namespace", namespace)
+	t.Cleanup(func() { s.kubectl("delete", "namespace", namespace, "--wait=false") })
+	return s
+}
+
+// Runs ankh against the e2e context and namespace, without prompting.
+func (s *suite) ankhCommand(tag string, args ...string) (string, error) {
+	global := []string{"--ankhconfig", s.config, "--kubeconfig", s.kubeConfig, "--datadir", filepath.Join(s.dir, "data"),
+		"--context", "e2e", "--namespace", namespace, "--no-prompt", "--set", "image.tag=" + tag}
+	return run(s.t, s.ankh, append(global, args...)...)
+}
+
+func (s *suite) mustAnkh(tag string, args ...string) string {
+	out, err := s.ankhCommand(tag, args...)
+	if err != nil {
+		s.t.Fatalf("ankh %v failed: %v\n%v", strings.Join(args, " "), err, out)
+	}
+	return out
+}
+
+func (s *suite) kubectl(args ...string) string {
+	out, _ := run(s.t, "kubectl", append([]string{"--kubeconfig", s.kubeConfig}, args...)...)
+	return out
+}
+
+func (s *suite) deployedImage() string {
+	return s.kubectl("--namespace", namespace, "get", "deployment", "hello",
+		"-o", "jsonpath={.spec.template.spec.containers[0].image}")
+}
+
+func TestApplyDiffRollback(t *testing.T) {
+	s := newSuite(t)
+
+	s.mustAnkh("3.8", "apply", "--chart-path", s.chartPath, "--wait", "--timeout", "2m")
+	if image := s.deployedImage(); image != "registry.k8s.io/pause:3.8" {
+		t.Fatalf("expected pause:3.8 to be applied but got %v", image)
+	}
+
+	// Only the output matters, since earlier versions of Ankh exited
+	// non-zero when there were differences.
+	out, _ := s.ankhCommand("3.9", "diff", "--chart-path", s.chartPath)
+	if !strings.Contains(out, "pause:3.9") {
+		t.Fatalf("expected the diff to show the new tag but got:\n%v", out)
+	}
+
+	s.mustAnkh("3.9", "apply", "--chart-path", s.chartPath, "--wait", "--timeout", "2m")
+	if image := s.deployedImage(); image != "registry.k8s.io/pause:3.9" {
+		t.Fatalf("expected pause:3.9 to be applied but got %v", image)
+	}
+
+	out = s.mustAnkh("3.9", "history", "--chart-path", s.chartPath)
+	if !strings.Contains(out, "pause:3.8") || !strings.Contains(out, "pause:3.9") {
+		t.Fatalf("expected both revisions in the history but got:\n%v", out)
+	}
+
+	s.mustAnkh("3.9", "rollback", "--chart-path", s.chartPath, "--to-revision", "1")
+	deadline := time.Now().Add(time.Minute)
+	for s.deployedImage() != "registry.k8s.io/pause:3.8" {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the rollback to restore pause:3.8 but got %v", s.deployedImage())
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
@@ -1,11 +1,13 @@
 module github.com/appnexus/ankh
 
+go 1.14
+
 require (
 	github.com/alecthomas/gometalinter v2.0.12+incompatible // indirect
 	github.com/alecthomas/units v0.0.0-20151022065526-2efee857e7cf // indirect
 	github.com/andygrunwald/go-jira v1.6.0
 	github.com/coreos/go-semver v0.2.0
-	github.com/docker/distribution v2.7.0-rc.0+incompatible // indirect
+	github.com/docker/distribution v2.7.0-rc.0+incompatible
 	github.com/docker/docker v1.13.1
 	github.com/docker/go-connections v0.4.0 // indirect
 	github.com/fatih/structs v1.1.0 // indirect
@@ -18,7 +20,7 @@ require (
 	github.com/mattn/go-isatty v0.0.4
 	github.com/nicksnyder/go-i18n v1.10.0 // indirect
 	github.com/nlopes/slack v0.0.0-20190117134835-3b9e5d653ede
-	github.com/opencontainers/go-digest v1.0.0-rc1 // indirect
+	github.com/opencontainers/go-digest v1.0.0-rc1
 	github.com/pelletier/go-toml v1.2.0 // indirect
 	github.com/pkg/errors v0.8.1 // indirect
 	github.com/sirupsen/logrus v1.0.6
@@ -0,0 +1,153 @@
+// Package graph orders charts, and the Ankh files listed under `dependencies`,
+// by their `dependsOn`, so that each is operated on after what it depends on.
+package graph
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+)
+
+// A Node is anything that is named and may depend on other nodes by name.
+type Node struct {
+	Name      string
+	DependsOn []string
+}
+
+// Waves returns the names of the nodes in waves, where each node comes in the
+// wave after the last of its dependencies. Nodes in the same wave do not
+// depend on one another, and keep their given order. Returns an error if a
+// node depends on an unknown node, or on itself by way of others.
+func Waves(nodes []Node) ([][]string, error) {
+	known := map[string]bool{}
+	for _, node := range nodes {
+		if known[node.Name] {
+			return nil, fmt.Errorf("\"%v\" is listed more than once", node.Name)
+		}
+		known[node.Name] = true
+	}
+	for _, node := range nodes {
+		for _, dep := range node.DependsOn {
+			if !known[dep] {
+				return nil, fmt.Errorf("\"%v\" depends on \"%v\", which is not listed", node.Name, dep)
+			}
+		}
+	}
+
+	done := map[string]bool{}
+	waves := [][]string{}
+	for len(done) < len(nodes) {
+		wave := []string{}
+		for _, node := range nodes {
+			if done[node.Name] {
+				continue
+			}
+			ready := true
+			for _, dep := range node.DependsOn {
+				ready = ready && done[dep]
+			}
+			if ready {
+				wave = append(wave, node.Name)
+			}
+		}
+		if len(wave) == 0 {
+			return nil, fmt.Errorf("there is a dependency cycle between %v", strings.Join(remaining(nodes, done), ", "))
+		}
+		for _, name := range wave {
+			done[name] = true
+		}
+		waves = append(waves, wave)
+	}
+	return waves, nil
+}
+
+func remaining(nodes []Node, done map[string]bool) []string {
+	names := []string{}
+	for _, node := range nodes {
+		if !done[node.Name] {
+			names = append(names, fmt.Sprintf("\"%v\"", node.Name))
+		}
+	}
+	return names
+}
+
+// ChartWaves groups charts into waves by their `dependsOn`, which refers to
+// other charts of the same Ankh file by instance name. Dependencies on charts
+// that are not being operated on, eg: because of `--chart`, are left out.
+func ChartWaves(charts []ankh.Chart) ([][]ankh.Chart, error) {
+	byName := map[string]ankh.Chart{}
+	for _, chart := range charts {
+		byName[chart.InstanceName()] = chart
+	}
+	nodes := []Node{}
+	for _, chart := range charts {
+		node := Node{Name: chart.InstanceName()}
+		for _, dep := range chart.DependsOn {
+			if _, ok := byName[dep]; ok {
+				node.DependsOn = append(node.DependsOn, dep)
+			}
+		}
+		nodes = append(nodes, node)
+	}
+
+	waves, err := Waves(nodes)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to order charts by `dependsOn`: %v", err)
+	}
+	chartWaves := [][]ankh.Chart{}
+	for _, wave := range waves {
+		chartWave := []ankh.Chart{}
+		for _, name := range wave {
+			chartWave = append(chartWave, byName[name])
+		}
+		chartWaves = append(chartWaves, chartWave)
+	}
+	return chartWaves, nil
+}
+
+// ValidateChartDependencies returns an error if a chart depends on a chart
+// that is not in the Ankh file, or if there is a cycle.
+func ValidateChartDependencies(charts []ankh.Chart) error {
+	nodes := []Node{}
+	for _, chart := range charts {
+		nodes = append(nodes, Node{Name: chart.InstanceName(), DependsOn: chart.DependsOn})
+	}
+	if _, err := Waves(nodes); err != nil {
+		return fmt.Errorf("Unable to order charts by `dependsOn`: %v", err)
+	}
+	return nil
+}
+
+// DependencyName is how other dependencies refer to an Ankh file listed under
+// `dependencies`: by its `name`, if set, or else by its path as listed.
+func DependencyName(path string, ankhFile ankh.AnkhFile) string {
+	if ankhFile.Name != "" {
+		return ankhFile.Name
+	}
+	return path
+}
+
+// DependencyOrder orders the Ankh files listed under `dependencies`, which
+// are given by path, by their own `dependsOn`, and returns their paths in
+// waves.
+func DependencyOrder(paths []string, ankhFiles []ankh.AnkhFile) ([][]string, error) {
+	nodes := []Node{}
+	pathsByName := map[string]string{}
+	for i, path := range paths {
+		name := DependencyName(path, ankhFiles[i])
+		pathsByName[name] = path
+		nodes = append(nodes, Node{Name: name, DependsOn: ankhFiles[i].DependsOn})
+	}
+
+	waves, err := Waves(nodes)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to order `dependencies` by `dependsOn`: %v", err)
+	}
+	for _, wave := range waves {
+		for i, name := range wave {
+			wave[i] = pathsByName[name]
+		}
+	}
+	return waves, nil
+}
@@ -0,0 +1,68 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/context"
+)
+
+func TestWaves(t *testing.T) {
+	waves, err := Waves([]Node{
+		{Name: "api", DependsOn: []string{"db", "crds"}},
+		{Name: "crds"},
+		{Name: "db", DependsOn: []string{"crds"}},
+		{Name: "cache"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%v", waves) != "[[crds cache] [db] [api]]" {
+		t.Logf("expected each node after its dependencies but got %v", waves)
+		t.Fail()
+	}
+
+	for _, nodes := range [][]Node{
+		{{Name: "a", DependsOn: []string{"b"}}, {Name: "b", DependsOn: []string{"a"}}},
+		{{Name: "a", DependsOn: []string{"missing"}}},
+		{{Name: "a"}, {Name: "a"}},
+	} {
+		if _, err := Waves(nodes); err == nil {
+			t.Logf("expected an error for %+v", nodes)
+			t.Fail()
+		}
+	}
+	if _, err := Waves([]Node{{Name: "a", DependsOn: []string{"b"}}, {Name: "b", DependsOn: []string{"a"}}}); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Logf("expected the cycle to be reported but got %v", err)
+		t.Fail()
+	}
+}
+
+func TestChartWaves(t *testing.T) {
+	charts := []ankh.Chart{
+		{Name: "api", DependsOn: []string{"postgres", "redis"}},
+		{Name: "postgres"},
+	}
+	// redis is not being operated on, eg: because of `--chart`.
+	waves, err := ChartWaves(charts)
+	if err != nil || len(waves) != 2 || waves[0][0].Name != "postgres" || waves[1][0].Name != "api" {
+		t.Logf("expected postgres before api but got %+v and %v", waves, err)
+		t.Fail()
+	}
+	if err := ValidateChartDependencies(charts); err == nil {
+		t.Logf("expected a dependency on a chart that is not listed to be invalid")
+		t.Fail()
+	}
+}
+
+func TestDependencyOrder(t *testing.T) {
+	waves, err := DependencyOrder([]string{"deps/api.yaml", "deps/crds.yaml"}, []ankh.AnkhFile{
+		{DependsOn: []string{"crds"}},
+		{Name: "crds"},
+	})
+	if err != nil || fmt.Sprintf("%v", waves) != "[[deps/crds.yaml] [deps/api.yaml]]" {
+		t.Logf("expected the CRDs to come first but got %v and %v", waves, err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,144 @@
+package helm
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+)
+
+// Annotations stamped on every applied object, so that who applied what, and
+// when, can be read back from the cluster, eg: with `kubectl get -o yaml`.
+const (
+	ChartAnnotation        = "ankh.appnexus.com/chart"
+	ChartVersionAnnotation = "ankh.appnexus.com/chart-version"
+	ImageTagAnnotation     = "ankh.appnexus.com/image-tag"
+	AppliedByAnnotation    = "ankh.appnexus.com/applied-by"
+	AppliedAtAnnotation    = "ankh.appnexus.com/applied-at"
+	AnkhVersionAnnotation  = "ankh.appnexus.com/ankh-version"
+)
+
+// AnnotateStage stamps every templated object with the chart it came from,
+// and who applied it, when, and with which version of Ankh, before the
+// stages that apply it.
+type AnnotateStage struct {
+	charts []ankh.Chart
+}
+
+func NewAnnotateStage(charts []ankh.Chart) plan.Stage {
+	return AnnotateStage{charts: charts}
+}
+
+func (stage AnnotateStage) Execute(ctx *ankh.ExecutionContext, input *string, namespace string, wildCardLabels []string) (string, error) {
+	// Annotating happens within Ankh, so there is no command to explain.
+	if ctx.Mode == ankh.Explain {
+		return *input, nil
+	}
+	out, err := annotateObjects(ctx, stage.charts, *input, time.Now())
+	if err != nil {
+		return "", err
+	}
+	ctx.AppliedManifest = out
+	return out, nil
+}
+
+// Returns the chart that rendered an object, from helm's `# Source:`
+// comment, eg: `# Source: app/templates/deployment.yaml`. Output of a single
+// chart is all from that chart.
+func sourceChart(charts []ankh.Chart, body string) *ankh.Chart {
+	if len(charts) == 1 {
+		return &charts[0]
+	}
+	for _, comment := range leadingComments(body) {
+		source := strings.TrimSpace(strings.TrimPrefix(comment, "#"))
+		if !strings.HasPrefix(source, "Source:") {
+			continue
+		}
+		name := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(source, "Source:")), "/", 2)[0]
+		for i := range charts {
+			if charts[i].Name == name {
+				return &charts[i]
+			}
+		}
+	}
+	return nil
+}
+
+func objectAnnotations(ctx *ankh.ExecutionContext, chart *ankh.Chart, now time.Time) yaml.MapSlice {
+	annotations := yaml.MapSlice{}
+	if chart != nil {
+		annotations = append(annotations, yaml.MapItem{Key: ChartAnnotation, Value: chart.InstanceName()})
+		if chart.Version != "" {
+			annotations = append(annotations, yaml.MapItem{Key: ChartVersionAnnotation, Value: chart.Version})
+		}
+		if chart.Tag != nil && *chart.Tag != "" {
+			annotations = append(annotations, yaml.MapItem{Key: ImageTagAnnotation, Value: *chart.Tag})
+		}
+	}
+	annotations = append(annotations,
+		yaml.MapItem{Key: AppliedByAnnotation, Value: ctx.EffectiveDeployer()},
+		yaml.MapItem{Key: AppliedAtAnnotation, Value: now.UTC().Format(time.RFC3339)})
+	if ctx.AnkhVersion != "" {
+		annotations = append(annotations, yaml.MapItem{Key: AnkhVersionAnnotation, Value: ctx.AnkhVersion})
+	}
+	return annotations
+}
+
+func annotateObjects(ctx *ankh.ExecutionContext, charts []ankh.Chart, output string, now time.Time) (string, error) {
+	annotated := ""
+	for _, body := range splitObjects(output) {
+		obj := exportedObject{}
+		if err := yaml.Unmarshal([]byte(body), &obj); err != nil {
+			return "", fmt.Errorf("unable to parse a rendered object to annotate it: %v", err)
+		}
+		if obj.Kind == "" {
+			annotated += fmt.Sprintf("---\n%v\n", body)
+			continue
+		}
+
+		object := yaml.MapSlice{}
+		if err := yaml.Unmarshal([]byte(body), &object); err != nil {
+			return "", fmt.Errorf("unable to parse %v \"%v\" to annotate it: %v", obj.Kind, obj.Metadata.Name, err)
+		}
+		for _, annotation := range objectAnnotations(ctx, sourceChart(charts, body), now) {
+			object = setPath(object, []string{"metadata", "annotations", annotation.Key.(string)}, annotation.Value)
+		}
+		out, err := yaml.Marshal(object)
+		if err != nil {
+			return "", err
+		}
+
+		comments := append(leadingComments(body), strings.TrimRight(string(out), "\n"))
+		annotated += fmt.Sprintf("---\n%v\n", strings.Join(comments, "\n"))
+	}
+	return annotated, nil
+}
+
+// ManifestsByChart splits annotated output into the objects of each chart, by
+// instance name. Objects of no chart in particular go with the first chart.
+func ManifestsByChart(charts []ankh.Chart, output string) (map[string]string, error) {
+	manifests := map[string]string{}
+	if len(charts) == 0 {
+		return manifests, nil
+	}
+	for _, body := range splitObjects(output) {
+		obj := struct {
+			Metadata struct {
+				Annotations map[string]string
+			}
+		}{}
+		if err := yaml.Unmarshal([]byte(body), &obj); err != nil {
+			return nil, fmt.Errorf("unable to parse an annotated object: %v", err)
+		}
+		chart := obj.Metadata.Annotations[ChartAnnotation]
+		if chart == "" {
+			chart = charts[0].InstanceName()
+		}
+		manifests[chart] += fmt.Sprintf("---\n%v\n", body)
+	}
+	return manifests, nil
+}
@@ -0,0 +1,128 @@
+package helm
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+const annotateOutput = `---
+# Source: api/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  annotations:
+    team: web
+---
+# Source: worker/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: worker
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: unattributed
+`
+
+func annotationsOf(t *testing.T, output string, name string) map[string]string {
+	for _, body := range splitObjects(output) {
+		obj := struct {
+			Metadata struct {
+				Name        string
+				Annotations map[string]string
+			}
+		}{}
+		if err := yaml.Unmarshal([]byte(body), &obj); err != nil {
+			t.Fatal(err)
+		}
+		if obj.Metadata.Name == name {
+			return obj.Metadata.Annotations
+		}
+	}
+	t.Fatalf("no object named %v in %v", name, output)
+	return nil
+}
+
+func TestAnnotateStage(t *testing.T) {
+	ctx := ankhtest.NewContext(t)
+	ctx.Mode = ankh.Apply
+	ctx.Deployer = "jdoe"
+	ctx.AnkhVersion = "3.1.0"
+	tag := "20240112"
+	charts := []ankh.Chart{{Name: "api", Version: "1.4.3", Tag: &tag}, {Name: "worker", Alias: "jobs", Version: "2.0.0"}}
+	now := time.Date(2024, 1, 12, 15, 4, 5, 0, time.FixedZone("EST", -5*60*60))
+
+	output, err := annotateObjects(ctx, charts, annotateOutput, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := annotationsOf(t, output, "api")
+	expected := map[string]string{
+		"team":                 "web",
+		ChartAnnotation:        "api",
+		ChartVersionAnnotation: "1.4.3",
+		ImageTagAnnotation:     "20240112",
+		AppliedByAnnotation:    "jdoe",
+		AppliedAtAnnotation:    "2024-01-12T20:04:05Z",
+		AnkhVersionAnnotation:  "3.1.0",
+	}
+	for key, value := range expected {
+		if api[key] != value {
+			t.Logf("expected %v=%v on api but got %+v", key, value, api)
+			t.Fail()
+		}
+	}
+
+	if worker := annotationsOf(t, output, "worker"); worker[ChartAnnotation] != "jobs" || worker[ImageTagAnnotation] != "" {
+		t.Logf("expected the alias of worker, and no tag, but got %+v", worker)
+		t.Fail()
+	}
+	if unattributed := annotationsOf(t, output, "unattributed"); unattributed[ChartAnnotation] != "" || unattributed[AppliedByAnnotation] != "jdoe" {
+		t.Logf("expected only who applied an object without a source but got %+v", unattributed)
+		t.Fail()
+	}
+	if !strings.Contains(output, "# Source: api/templates/deployment.yaml\napiVersion: apps/v1") {
+		t.Logf("expected comments to be kept but got %v", output)
+		t.Fail()
+	}
+
+	// Explaining leaves the output alone.
+	ctx.Mode = ankh.Explain
+	input := "helm template ... && \\"
+	if out, err := NewAnnotateStage(charts).Execute(ctx, &input, "web", nil); err != nil || out != input {
+		t.Logf("expected the input to be explained as is but got %v and %v", out, err)
+		t.Fail()
+	}
+}
+
+func TestManifestsByChart(t *testing.T) {
+	charts := []ankh.Chart{{Name: "api"}, {Name: "worker", Alias: "jobs"}}
+	output := `---
+kind: Deployment
+metadata:
+  name: worker
+  annotations:
+    ankh.appnexus.com/chart: jobs
+---
+kind: ConfigMap
+metadata:
+  name: unattributed
+`
+	manifests, err := ManifestsByChart(charts, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(manifests["jobs"], "name: worker") || !strings.Contains(manifests["api"], "name: unattributed") || len(manifests) != 2 {
+		t.Logf("expected objects by chart, and unattributed objects with the first chart, but got %+v", manifests)
+		t.Fail()
+	}
+}
@@ -0,0 +1,62 @@
+package helm
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+)
+
+// CanaryStage scales the Deployments and StatefulSets of each chart with a
+// canary replica count down to that count, for the canary phase of `deploy`.
+type CanaryStage struct {
+	charts []ankh.Chart
+}
+
+func NewCanaryStage(charts []ankh.Chart) plan.Stage {
+	return CanaryStage{charts: charts}
+}
+
+func (stage CanaryStage) Execute(ctx *ankh.ExecutionContext, input *string, namespace string, wildCardLabels []string) (string, error) {
+	// Scaling happens within Ankh, so there is no command to explain.
+	if ctx.Mode == ankh.Explain {
+		return *input, nil
+	}
+	return scaleCanaries(ctx, stage.charts, *input)
+}
+
+func scaleCanaries(ctx *ankh.ExecutionContext, charts []ankh.Chart, output string) (string, error) {
+	scaled := ""
+	for _, body := range splitObjects(output) {
+		obj := exportedObject{}
+		if err := yaml.Unmarshal([]byte(body), &obj); err != nil {
+			return "", fmt.Errorf("unable to parse a rendered object to scale it: %v", err)
+		}
+		chart := sourceChart(charts, body)
+		if (obj.Kind != "Deployment" && obj.Kind != "StatefulSet") || chart == nil ||
+			chart.ChartMeta.Deploy.Canary == nil || chart.ChartMeta.Deploy.Canary.Replicas == nil {
+			scaled += fmt.Sprintf("---\n%v\n", body)
+			continue
+		}
+
+		replicas := *chart.ChartMeta.Deploy.Canary.Replicas
+		workload := yaml.MapSlice{}
+		if err := yaml.Unmarshal([]byte(body), &workload); err != nil {
+			return "", fmt.Errorf("unable to parse %v \"%v\" to scale it: %v", obj.Kind, obj.Metadata.Name, err)
+		}
+		workload = setPath(workload, []string{"spec", "replicas"}, replicas)
+		workload = setPath(workload, []string{"metadata", "annotations", overriddenAnnotation}, fmt.Sprintf("canary,replicas=%v", replicas))
+		out, err := yaml.Marshal(workload)
+		if err != nil {
+			return "", err
+		}
+		ctx.Logger.Infof("Scaling %v \"%v\" to %v canary replicas", obj.Kind, obj.Metadata.Name, replicas)
+
+		comments := append(leadingComments(body), strings.TrimRight(string(out), "\n"))
+		scaled += fmt.Sprintf("---\n%v\n", strings.Join(comments, "\n"))
+	}
+	return scaled, nil
+}
@@ -0,0 +1,52 @@
+package helm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+func TestCanaryStage(t *testing.T) {
+	ctx := ankhtest.NewContext(t)
+	replicas := 1
+	charts := []ankh.Chart{
+		{Name: "api", ChartMeta: ankh.ChartMeta{Deploy: ankh.DeployMeta{Canary: &ankh.CanaryConfig{Replicas: &replicas}}}},
+		{Name: "worker"},
+	}
+	output := `---
+# Source: api/templates/deployment.yaml
+kind: Deployment
+metadata:
+  name: api
+spec:
+  replicas: 10
+---
+# Source: api/templates/service.yaml
+kind: Service
+metadata:
+  name: api
+---
+# Source: worker/templates/deployment.yaml
+kind: Deployment
+metadata:
+  name: worker
+spec:
+  replicas: 3
+`
+
+	out, err := NewCanaryStage(charts).Execute(ctx, &output, "web", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := splitObjects(out)
+	if len(objects) != 3 || !strings.Contains(objects[0], "replicas: 1") || !strings.Contains(objects[0], overriddenAnnotation+": canary,replicas=1") {
+		t.Logf("expected the canary chart's Deployment to be scaled down but got %v", out)
+		t.Fail()
+	}
+	if !strings.Contains(objects[2], "replicas: 3") {
+		t.Logf("expected the Deployment of a chart without a canary to be left alone but got %v", objects[2])
+		t.Fail()
+	}
+}
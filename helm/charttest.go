@@ -0,0 +1,359 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/util"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// A chart test, read from a yaml file in the chart's `tests/` directory. The
+// chart is rendered once for every combination of environment class, resource
+// profile and release, and each render is compared against a golden file, and
+// checked against the assertions.
+type ChartTest struct {
+	// An empty list renders without any environment class
+	EnvironmentClasses []string `yaml:"environmentClasses"`
+	// An empty list renders without any resource profile
+	ResourceProfiles []string `yaml:"resourceProfiles"`
+	// An empty list renders without any release
+	Releases  []string `yaml:"releases"`
+	Namespace string   `yaml:"namespace"`
+	// Values passed to helm with `--set`
+	Set        map[string]string `yaml:"set"`
+	Assertions []ChartAssertion  `yaml:"assertions"`
+}
+
+// An assertion about one object in the rendered chart.
+type ChartAssertion struct {
+	Kind string `yaml:"kind"`
+	Name string `yaml:"name"`
+	// A dotted path into the object, eg: `spec.template.spec.containers[0].image`.
+	// List items may also be selected by a field, eg: `containers[name=app]`, and
+	// keys with dots in them by brackets, eg: `metadata.labels[app.kubernetes.io/name]`.
+	// Without a path, the assertion is about the object itself.
+	Path string `yaml:"path"`
+	// The expected value at the path. Without a value, the path only has to exist.
+	Value interface{} `yaml:"value"`
+	// The object, or the path, must not exist.
+	Absent bool `yaml:"absent"`
+}
+
+// The result of rendering one combination of a chart test.
+type chartTestCase struct {
+	Name     string
+	Golden   string
+	Updated  bool
+	Failures []string
+}
+
+func chartTestMatrix(values []string) []string {
+	if len(values) == 0 {
+		return []string{""}
+	}
+	return values
+}
+
+func chartTestCaseName(parts ...string) string {
+	names := []string{}
+	for _, part := range parts {
+		if part != "" {
+			names = append(names, part)
+		}
+	}
+	return strings.Join(names, "-")
+}
+
+// Splits a path like `spec.containers[name=app].image` into its segments. Bracketed
+// segments keep their brackets, so that they can be told apart from keys.
+func splitChartTestPath(path string) ([]string, error) {
+	segments := []string{}
+	current := ""
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '.':
+			if current != "" {
+				segments = append(segments, current)
+			}
+			current = ""
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated \"[\" in path \"%v\"", path)
+			}
+			if current != "" {
+				segments = append(segments, current)
+			}
+			segments = append(segments, path[i:i+end+1])
+			current = ""
+			i += end
+		default:
+			current += string(path[i])
+		}
+	}
+	if current != "" {
+		segments = append(segments, current)
+	}
+	return segments, nil
+}
+
+// Looks up a path, as described on ChartAssertion, in an object.
+func lookupChartTestPath(object interface{}, path string) (interface{}, bool, error) {
+	segments, err := splitChartTestPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	value := object
+	for _, segment := range segments {
+		key := segment
+		if strings.HasPrefix(segment, "[") {
+			key = strings.TrimSuffix(strings.TrimPrefix(segment, "["), "]")
+			if list, ok := value.([]interface{}); ok {
+				if index, err := strconv.Atoi(key); err == nil {
+					if index < 0 || index >= len(list) {
+						return nil, false, nil
+					}
+					value = list[index]
+					continue
+				}
+
+				field := strings.SplitN(key, "=", 2)
+				if len(field) != 2 {
+					return nil, false, fmt.Errorf("Expected an index or a `field=value` to select an item of a list, but got \"%v\" in path \"%v\"", segment, path)
+				}
+				found := false
+				for _, item := range list {
+					if m, ok := item.(map[interface{}]interface{}); ok && fmt.Sprintf("%v", m[field[0]]) == field[1] {
+						value = item
+						found = true
+						break
+					}
+				}
+				if !found {
+					return nil, false, nil
+				}
+				continue
+			}
+		}
+
+		m, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return nil, false, nil
+		}
+		if value, ok = m[key]; !ok {
+			return nil, false, nil
+		}
+	}
+	return value, true, nil
+}
+
+type chartTestObject struct {
+	Kind     string
+	Name     string
+	Contents map[interface{}]interface{}
+}
+
+func parseChartTestObjects(helmOutput string) []chartTestObject {
+	objects := []chartTestObject{}
+	for _, raw := range strings.Split("\n"+helmOutput, "\n---") {
+		contents := map[interface{}]interface{}{}
+		if err := yaml.Unmarshal([]byte(raw), &contents); err != nil || len(contents) == 0 {
+			continue
+		}
+		object := chartTestObject{Contents: contents}
+		object.Kind, _ = contents["kind"].(string)
+		if name, ok, _ := lookupChartTestPath(contents, "metadata.name"); ok {
+			object.Name = fmt.Sprintf("%v", name)
+		}
+		objects = append(objects, object)
+	}
+	return objects
+}
+
+// Checks an assertion against the objects of a render, returning a description
+// of the failure, if any.
+func checkChartAssertion(assertion ChartAssertion, objects []chartTestObject) (string, error) {
+	target := assertion.Kind + "/" + assertion.Name
+	var object *chartTestObject
+	for i := range objects {
+		if strings.EqualFold(objects[i].Kind, assertion.Kind) && objects[i].Name == assertion.Name {
+			object = &objects[i]
+			break
+		}
+	}
+
+	if object == nil {
+		if assertion.Absent && assertion.Path == "" {
+			return "", nil
+		}
+		return fmt.Sprintf("expected %v to be rendered, but it was not", target), nil
+	}
+	if assertion.Path == "" {
+		if assertion.Absent {
+			return fmt.Sprintf("expected %v not to be rendered, but it was", target), nil
+		}
+		return "", nil
+	}
+
+	value, found, err := lookupChartTestPath(object.Contents, assertion.Path)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case assertion.Absent && found:
+		return fmt.Sprintf("expected %v to have no %v, but found %v", target, assertion.Path, formatChartTestValue(value)), nil
+	case assertion.Absent:
+		return "", nil
+	case !found:
+		return fmt.Sprintf("expected %v to have %v, but it does not", target, assertion.Path), nil
+	case assertion.Value != nil && !reflect.DeepEqual(value, assertion.Value):
+		return fmt.Sprintf("expected %v %v to be %v, but found %v", target, assertion.Path,
+			formatChartTestValue(assertion.Value), formatChartTestValue(value)), nil
+	}
+	return "", nil
+}
+
+func formatChartTestValue(value interface{}) string {
+	out, err := yaml.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func readChartTest(path string) (ChartTest, error) {
+	test := ChartTest{}
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return test, err
+	}
+	if err := yaml.UnmarshalStrict(body, &test); err != nil {
+		return test, fmt.Errorf("Unable to parse chart test %v: %v", path, err)
+	}
+	return test, nil
+}
+
+// Renders one combination of a chart test, and checks it against its golden
+// file and assertions.
+func runChartTestCase(ctx *ankh.ExecutionContext, chart ankh.Chart, test ChartTest, testCase *chartTestCase, update bool) error {
+	helmOutput, err := templateChart(ctx, chart, test.Namespace)
+	if err != nil {
+		return err
+	}
+
+	if update {
+		if err := os.MkdirAll(filepath.Dir(testCase.Golden), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(testCase.Golden, []byte(helmOutput), 0644); err != nil {
+			return err
+		}
+		testCase.Updated = true
+	} else if golden, err := ioutil.ReadFile(testCase.Golden); err == nil {
+		if diff := util.LineDiff(string(golden), helmOutput); diff != "" {
+			testCase.Failures = append(testCase.Failures, fmt.Sprintf("output differs from %v: %v", testCase.Golden, strings.TrimSpace(diff)))
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	} else if len(test.Assertions) == 0 {
+		testCase.Failures = append(testCase.Failures, fmt.Sprintf("no golden file %v and no assertions. Run with `--update` to create the golden file", testCase.Golden))
+	}
+
+	objects := parseChartTestObjects(helmOutput)
+	for _, assertion := range test.Assertions {
+		failure, err := checkChartAssertion(assertion, objects)
+		if err != nil {
+			return err
+		}
+		if failure != "" {
+			testCase.Failures = append(testCase.Failures, failure)
+		}
+	}
+	return nil
+}
+
+// Runs the tests in the `tests/` directory of a local chart, returning a report
+// and the number of failed cases. With update, golden files are (re)written
+// instead of compared.
+func RunChartTests(ctx *ankh.ExecutionContext, repository string, chartPath string, update bool) (string, int, error) {
+	helmChart := ChartYaml{}
+	body, err := ioutil.ReadFile(filepath.Join(chartPath, "Chart.yaml"))
+	if err != nil {
+		return "", 0, fmt.Errorf("Could not use \"%v\" as a local chart directory: %v", chartPath, err)
+	}
+	if err := yaml.Unmarshal(body, &helmChart); err != nil || helmChart.Name == "" {
+		return "", 0, fmt.Errorf("Could not find the name of the chart in %v", filepath.Join(chartPath, "Chart.yaml"))
+	}
+
+	chart := ankh.Chart{Name: helmChart.Name, Path: chartPath, HelmRepository: repository}
+	meta, err := FetchChartMeta(ctx, repository, &chart)
+	if err != nil {
+		return "", 0, err
+	}
+	chart.ChartMeta = meta
+
+	testsDir := filepath.Join(chartPath, "tests")
+	testFiles, err := filepath.Glob(filepath.Join(testsDir, "*.yaml"))
+	if err != nil {
+		return "", 0, err
+	}
+	if len(testFiles) == 0 {
+		return "", 0, fmt.Errorf("No chart tests found in %v", testsDir)
+	}
+
+	savedContext, savedSetValues := ctx.AnkhConfig.CurrentContext, ctx.HelmSetValues
+	defer func() {
+		ctx.AnkhConfig.CurrentContext, ctx.HelmSetValues = savedContext, savedSetValues
+	}()
+
+	buf := bytes.NewBufferString("")
+	cases, failed := 0, 0
+	for _, testFile := range testFiles {
+		test, err := readChartTest(testFile)
+		if err != nil {
+			return "", 0, err
+		}
+		testName := strings.TrimSuffix(filepath.Base(testFile), ".yaml")
+
+		for _, class := range chartTestMatrix(test.EnvironmentClasses) {
+			for _, profile := range chartTestMatrix(test.ResourceProfiles) {
+				for _, release := range chartTestMatrix(test.Releases) {
+					name := chartTestCaseName(testName, class, profile, release)
+					testCase := chartTestCase{Name: name, Golden: filepath.Join(testsDir, "golden", name+".yaml")}
+
+					ctx.AnkhConfig.CurrentContext = ankh.Context{EnvironmentClass: class, ResourceProfile: profile, Release: release}
+					ctx.HelmSetValues = test.Set
+					ctx.Logger.Infof("Running chart test %v", name)
+					if err := runChartTestCase(ctx, chart, test, &testCase, update); err != nil {
+						testCase.Failures = append(testCase.Failures, err.Error())
+					}
+
+					cases++
+					switch {
+					case len(testCase.Failures) > 0:
+						failed++
+						fmt.Fprintf(buf, "FAIL %v\n", testCase.Name)
+						for _, failure := range testCase.Failures {
+							fmt.Fprintf(buf, "  - %v\n", failure)
+						}
+					case testCase.Updated:
+						fmt.Fprintf(buf, "UPDATED %v\n", testCase.Name)
+					default:
+						fmt.Fprintf(buf, "PASS %v\n", testCase.Name)
+					}
+				}
+			}
+		}
+	}
+	fmt.Fprintf(buf, "%v of %v chart test cases passed\n", cases-failed, cases)
+	return buf.String(), failed, nil
+}
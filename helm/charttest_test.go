@@ -0,0 +1,135 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+)
+
+const chartTestDeployment string = `---
+# Source: foo/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+  labels:
+    app.kubernetes.io/name: foo
+spec:
+  replicas: %v
+  template:
+    spec:
+      containers:
+      - name: app
+        image: example/foo:1.0.0
+`
+
+func TestLookupChartTestPath(t *testing.T) {
+	objects := parseChartTestObjects(strings.Replace(chartTestDeployment, "%v", "2", 1))
+	if len(objects) != 1 || objects[0].Kind != "Deployment" || objects[0].Name != "foo" {
+		t.Fatalf("expected a single Deployment named foo but got %+v", objects)
+	}
+
+	cases := map[string]interface{}{
+		"spec.replicas":                                 2,
+		"spec.template.spec.containers[0].image":        "example/foo:1.0.0",
+		"spec.template.spec.containers[name=app].image": "example/foo:1.0.0",
+		"metadata.labels[app.kubernetes.io/name]":       "foo",
+	}
+	for path, expected := range cases {
+		value, found, err := lookupChartTestPath(objects[0].Contents, path)
+		if err != nil || !found || value != expected {
+			t.Logf("expected %v at %v but got %v (found %v, err %v)", expected, path, value, found, err)
+			t.Fail()
+		}
+	}
+	for _, path := range []string{"spec.template.spec.containers[1]", "spec.template.spec.containers[name=sidecar]", "spec.missing"} {
+		if _, found, err := lookupChartTestPath(objects[0].Contents, path); err != nil || found {
+			t.Logf("expected nothing at %v but found something (err %v)", path, err)
+			t.Fail()
+		}
+	}
+	if _, _, err := lookupChartTestPath(objects[0].Contents, "spec.template.spec.containers[app"); err == nil {
+		t.Logf("expected an error for an unterminated bracket")
+		t.Fail()
+	}
+}
+
+func TestRunChartTests(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("helm",
+		ankhtest.Rule{Args: "template *blue*", Stdout: strings.Replace(chartTestDeployment, "%v", "3", 1)},
+		ankhtest.Rule{Args: "template *", Stdout: strings.Replace(chartTestDeployment, "%v", "2", 1)},
+	)
+
+	chartPath, err := ioutil.TempDir("", "ankh-charttest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(chartPath)
+	os.MkdirAll(filepath.Join(chartPath, "tests"), 0755)
+	ioutil.WriteFile(filepath.Join(chartPath, "Chart.yaml"), []byte("name: foo\nversion: 1.0.0\n"), 0644)
+	ioutil.WriteFile(filepath.Join(chartPath, "tests", "replicas.yaml"), []byte(`
+environmentClasses: [dev]
+releases: [green, blue]
+assertions:
+- kind: Deployment
+  name: foo
+  path: spec.replicas
+  value: 2
+- kind: Service
+  name: foo
+  absent: true
+`), 0644)
+	ioutil.WriteFile(filepath.Join(chartPath, "tests", "golden.yaml"), []byte("releases: [green]\n"), 0644)
+
+	ctx := ankhtest.NewContext(t)
+	report, failed, err := RunChartTests(ctx, "http://charts.example.com", chartPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without a golden file or assertions, the golden test cannot pass.
+	for _, expected := range []string{
+		"FAIL golden-green\n  - no golden file",
+		"PASS replicas-dev-green\n",
+		"FAIL replicas-dev-blue\n  - expected Deployment/foo spec.replicas to be 2, but found 3\n",
+		"1 of 3 chart test cases passed",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Logf("expected the report to contain %q but got:\n%v", expected, report)
+			t.Fail()
+		}
+	}
+	if failed != 2 {
+		t.Logf("expected 2 failed cases but got %v", failed)
+		t.Fail()
+	}
+	if ctx.AnkhConfig.CurrentContext.EnvironmentClass != "test" {
+		t.Logf("expected the current context to be restored but got %+v", ctx.AnkhConfig.CurrentContext)
+		t.Fail()
+	}
+
+	if _, _, err := RunChartTests(ctx, "http://charts.example.com", chartPath, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(chartPath, "tests", "golden", "golden-green.yaml")); err != nil {
+		t.Logf("expected --update to write a golden file: %v", err)
+		t.Fail()
+	}
+	os.Remove(filepath.Join(chartPath, "tests", "replicas.yaml"))
+	report, failed, err = RunChartTests(ctx, "http://charts.example.com", chartPath, false)
+	if err != nil || failed != 0 {
+		t.Logf("expected the golden file to match but got %v failures (err %v):\n%v", failed, err, report)
+		t.Fail()
+	}
+
+	ioutil.WriteFile(filepath.Join(chartPath, "tests", "golden", "golden-green.yaml"), []byte("kind: ConfigMap\n"), 0644)
+	report, failed, err = RunChartTests(ctx, "http://charts.example.com", chartPath, false)
+	if err != nil || failed != 1 || !strings.Contains(report, "output differs from") {
+		t.Logf("expected the golden file to differ but got %v failures (err %v):\n%v", failed, err, report)
+		t.Fail()
+	}
+}
@@ -0,0 +1,264 @@
+package helm
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+)
+
+// The pod template annotation that charts conventionally set to a checksum of
+// their config, so that changing the config rolls out new pods.
+const configChecksumAnnotation = "checksum/config"
+
+type configObject struct {
+	Kind     string
+	Metadata struct {
+		Name      string
+		Namespace string
+	}
+	Data       map[string]string `yaml:"data"`
+	BinaryData map[string]string `yaml:"binaryData"`
+	StringData map[string]string `yaml:"stringData"`
+}
+
+type objectRef struct {
+	Name string `yaml:"name"`
+}
+
+type podContainer struct {
+	EnvFrom []struct {
+		ConfigMapRef *objectRef `yaml:"configMapRef"`
+		SecretRef    *objectRef `yaml:"secretRef"`
+	} `yaml:"envFrom"`
+	Env []struct {
+		ValueFrom *struct {
+			ConfigMapKeyRef *objectRef `yaml:"configMapKeyRef"`
+			SecretKeyRef    *objectRef `yaml:"secretKeyRef"`
+		} `yaml:"valueFrom"`
+	} `yaml:"env"`
+}
+
+type podWorkload struct {
+	Kind     string
+	Metadata struct {
+		Name      string
+		Namespace string
+	}
+	Spec struct {
+		Template struct {
+			Metadata struct {
+				Annotations map[string]string `yaml:"annotations"`
+			} `yaml:"metadata"`
+			Spec struct {
+				Volumes []struct {
+					ConfigMap *objectRef `yaml:"configMap"`
+					Secret    *struct {
+						SecretName string `yaml:"secretName"`
+					} `yaml:"secret"`
+					Projected *struct {
+						Sources []struct {
+							ConfigMap *objectRef `yaml:"configMap"`
+							Secret    *objectRef `yaml:"secret"`
+						} `yaml:"sources"`
+					} `yaml:"projected"`
+				} `yaml:"volumes"`
+				Containers     []podContainer `yaml:"containers"`
+				InitContainers []podContainer `yaml:"initContainers"`
+			} `yaml:"spec"`
+		} `yaml:"template"`
+	} `yaml:"spec"`
+}
+
+// Returns the ConfigMaps and Secrets that a workload's pods refer to, eg:
+// `ConfigMap/foo`, without duplicates.
+func (workload *podWorkload) configRefs() []string {
+	refs := map[string]bool{}
+	add := func(kind string, name string) {
+		if name != "" {
+			refs[kind+"/"+name] = true
+		}
+	}
+
+	spec := workload.Spec.Template.Spec
+	for _, volume := range spec.Volumes {
+		if volume.ConfigMap != nil {
+			add("ConfigMap", volume.ConfigMap.Name)
+		}
+		if volume.Secret != nil {
+			add("Secret", volume.Secret.SecretName)
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					add("ConfigMap", source.ConfigMap.Name)
+				}
+				if source.Secret != nil {
+					add("Secret", source.Secret.Name)
+				}
+			}
+		}
+	}
+	for _, container := range append(spec.Containers, spec.InitContainers...) {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.ConfigMapRef != nil {
+				add("ConfigMap", envFrom.ConfigMapRef.Name)
+			}
+			if envFrom.SecretRef != nil {
+				add("Secret", envFrom.SecretRef.Name)
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if env.ValueFrom.ConfigMapKeyRef != nil {
+				add("ConfigMap", env.ValueFrom.ConfigMapKeyRef.Name)
+			}
+			if env.ValueFrom.SecretKeyRef != nil {
+				add("Secret", env.ValueFrom.SecretKeyRef.Name)
+			}
+		}
+	}
+
+	sorted := []string{}
+	for ref := range refs {
+		sorted = append(sorted, ref)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// Returns a checksum of the contents of a ConfigMap or Secret, which is the
+// same however its keys are ordered.
+func configChecksum(obj configObject) string {
+	hash := sha256.New()
+	for _, data := range []map[string]string{obj.Data, obj.BinaryData, obj.StringData} {
+		keys := []string{}
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(hash, "%q=%q\n", key, data[key])
+		}
+		fmt.Fprintf(hash, "\n")
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// Splits helm's output into the bodies of its objects, keeping comments, eg: `# Source:`.
+func splitObjects(helmOutput string) []string {
+	bodies := []string{}
+	for _, body := range strings.Split(helmOutput, "\n---") {
+		body = strings.Trim(strings.TrimPrefix(strings.TrimLeft(body, "\n"), "---"), "\n")
+		if body != "" {
+			bodies = append(bodies, body)
+		}
+	}
+	return bodies
+}
+
+// Returns the comment lines at the start of an object's body.
+func leadingComments(body string) []string {
+	comments := []string{}
+	for _, line := range strings.Split(body, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		comments = append(comments, line)
+	}
+	return comments
+}
+
+// Annotates the pod template of every Deployment, StatefulSet and DaemonSet
+// in helm's output with a checksum of the rendered ConfigMaps and Secrets it
+// refers to, so that changing them rolls out new pods. Workloads that already
+// have a `checksum/` annotation are left alone, since their chart does this
+// itself.
+func injectConfigChecksums(ctx *ankh.ExecutionContext, helmOutput string, namespace string) (string, error) {
+	bodies := splitObjects(helmOutput)
+
+	checksums := map[string]string{}
+	for _, body := range bodies {
+		obj := configObject{}
+		if err := yaml.Unmarshal([]byte(body), &obj); err != nil {
+			return "", fmt.Errorf("unable to parse a rendered object to checksum it: %v", err)
+		}
+		if obj.Kind != "ConfigMap" && obj.Kind != "Secret" {
+			continue
+		}
+		objectNamespace := obj.Metadata.Namespace
+		if objectNamespace == "" {
+			objectNamespace = namespace
+		}
+		checksums[objectNamespace+"/"+obj.Kind+"/"+obj.Metadata.Name] = configChecksum(obj)
+	}
+
+	output := ""
+	for _, body := range bodies {
+		workload := podWorkload{}
+		if err := yaml.Unmarshal([]byte(body), &workload); err != nil {
+			return "", fmt.Errorf("unable to parse a rendered object to checksum its config: %v", err)
+		}
+		checksum, refs := workloadChecksum(&workload, namespace, checksums)
+		if checksum == "" {
+			output += fmt.Sprintf("---\n%v\n", body)
+			continue
+		}
+
+		obj := yaml.MapSlice{}
+		if err := yaml.Unmarshal([]byte(body), &obj); err != nil {
+			return "", fmt.Errorf("unable to parse %v \"%v\" to checksum its config: %v", workload.Kind, workload.Metadata.Name, err)
+		}
+		obj = setPath(obj, []string{"spec", "template", "metadata", "annotations", configChecksumAnnotation}, checksum)
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			return "", err
+		}
+		ctx.Logger.Debugf("Annotating %v \"%v\" with %v of [ %v ]", workload.Kind, workload.Metadata.Name,
+			configChecksumAnnotation, strings.Join(refs, ", "))
+
+		comments := append(leadingComments(body), strings.TrimRight(string(out), "\n"))
+		output += fmt.Sprintf("---\n%v\n", strings.Join(comments, "\n"))
+	}
+	return output, nil
+}
+
+// Returns the checksum to annotate a workload with, and the rendered config it
+// covers, or an empty checksum if the workload should not be annotated.
+func workloadChecksum(workload *podWorkload, namespace string, checksums map[string]string) (string, []string) {
+	switch workload.Kind {
+	case "Deployment", "StatefulSet", "DaemonSet":
+	default:
+		return "", nil
+	}
+	for annotation := range workload.Spec.Template.Metadata.Annotations {
+		if strings.HasPrefix(annotation, "checksum/") {
+			return "", nil
+		}
+	}
+
+	workloadNamespace := workload.Metadata.Namespace
+	if workloadNamespace == "" {
+		workloadNamespace = namespace
+	}
+	hash := sha256.New()
+	refs := []string{}
+	for _, ref := range workload.configRefs() {
+		// Config that is not rendered along with the workload, eg: a Secret
+		// managed elsewhere, is not covered.
+		if checksum, ok := checksums[workloadNamespace+"/"+ref]; ok {
+			fmt.Fprintf(hash, "%v=%v\n", ref, checksum)
+			refs = append(refs, ref)
+		}
+	}
+	if len(refs) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), refs
+}
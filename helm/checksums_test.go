@@ -0,0 +1,137 @@
+package helm
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+)
+
+const checksumOutput = `---
+# Source: app/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config
+data:
+  port: "8080"
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: app-secret
+data:
+  password: c2VjcmV0
+---
+# Source: app/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      volumes:
+      - name: config
+        configMap:
+          name: app-config
+      containers:
+      - name: app
+        env:
+        - name: PASSWORD
+          valueFrom:
+            secretKeyRef:
+              name: app-secret
+              key: password
+        envFrom:
+        - secretRef:
+            name: managed-elsewhere
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: checksummed
+spec:
+  template:
+    metadata:
+      annotations:
+        checksum/config: abc
+    spec:
+      volumes:
+      - name: config
+        configMap:
+          name: app-config
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: unrelated
+spec:
+  template:
+    spec:
+      containers:
+      - name: unrelated
+`
+
+func renderedChecksum(t *testing.T, output string, name string) string {
+	for _, body := range splitObjects(output) {
+		workload := podWorkload{}
+		if err := yaml.Unmarshal([]byte(body), &workload); err != nil {
+			t.Fatal(err)
+		}
+		if workload.Metadata.Name == name {
+			return workload.Spec.Template.Metadata.Annotations[configChecksumAnnotation]
+		}
+	}
+	t.Fatalf("no object named %v in %v", name, output)
+	return ""
+}
+
+func TestInjectConfigChecksums(t *testing.T) {
+	ctx := &ankh.ExecutionContext{Logger: logrus.New()}
+	ctx.Logger.Out = ioutil.Discard
+
+	output, err := injectConfigChecksums(ctx, checksumOutput, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum := renderedChecksum(t, output, "app")
+	if len(checksum) != 64 {
+		t.Logf("expected a checksum of the config of app but got %q", checksum)
+		t.Fail()
+	}
+	if !strings.Contains(output, "# Source: app/templates/deployment.yaml\napiVersion: apps/v1") {
+		t.Logf("expected comments to be kept ahead of the annotated Deployment but got %v", output)
+		t.Fail()
+	}
+	if checksum := renderedChecksum(t, output, "checksummed"); checksum != "abc" {
+		t.Logf("expected the chart's own checksum to be kept but got %q", checksum)
+		t.Fail()
+	}
+	if checksum := renderedChecksum(t, output, "unrelated"); checksum != "" {
+		t.Logf("expected no checksum without config but got %q", checksum)
+		t.Fail()
+	}
+
+	// Rendering again is stable, and changing the config changes the checksum.
+	again, err := injectConfigChecksums(ctx, checksumOutput, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renderedChecksum(t, again, "app") != checksum {
+		t.Logf("expected the same checksum for the same config")
+		t.Fail()
+	}
+	changed, err := injectConfigChecksums(ctx, strings.Replace(checksumOutput, "c2VjcmV0", "c2VjcmV1", 1), "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renderedChecksum(t, changed, "app") == checksum {
+		t.Logf("expected a changed Secret to change the checksum")
+		t.Fail()
+	}
+}
@@ -0,0 +1,220 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+)
+
+const defaultLabelsKey = "labels"
+
+// Merges values as helm would for a chart: the chart's own values.yaml, then
+// each `-f` file, then each `--set`, in the order given.
+func mergedValues(chartDir string, helmArgs []string) (map[interface{}]interface{}, error) {
+	values := make(map[interface{}]interface{})
+	readValues := func(path string) error {
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fileValues := make(map[interface{}]interface{})
+		if err := yaml.Unmarshal(body, &fileValues); err != nil {
+			return fmt.Errorf("unable to parse values file %v: %v", filepath.Base(path), err)
+		}
+		mergeValues(values, fileValues)
+		return nil
+	}
+
+	if err := readValues(filepath.Join(chartDir, "values.yaml")); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for i := 0; i < len(helmArgs)-1; i++ {
+		switch helmArgs[i] {
+		case "-f":
+			i++
+			if err := readValues(helmArgs[i]); err != nil {
+				return nil, err
+			}
+		case "--set":
+			i++
+			// eg: `a.b=c,d=e`
+			for _, assignment := range strings.Split(helmArgs[i], ",") {
+				tokens := strings.SplitN(assignment, "=", 2)
+				if len(tokens) == 2 {
+					setNestedValue(values, tokens[0], tokens[1])
+				}
+			}
+		}
+	}
+	return values, nil
+}
+
+// Looks up a dotted key, eg: `labels.team`, in nested values.
+func lookupValue(values map[interface{}]interface{}, key string) (interface{}, bool) {
+	var value interface{} = values
+	for _, part := range strings.Split(key, ".") {
+		m, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+type valuesImage struct {
+	// The dotted key of the image, eg: `sidecar.image`
+	Key       string
+	Reference string
+}
+
+// Finds images in values, ie: the value of any `image` key, either as a
+// reference, or as a map with a `repository` and an optional `registry`.
+func findImages(value interface{}, path string) []valuesImage {
+	images := []valuesImage{}
+	m, ok := value.(map[interface{}]interface{})
+	if !ok {
+		if list, ok := value.([]interface{}); ok {
+			for i, item := range list {
+				images = append(images, findImages(item, fmt.Sprintf("%v[%v]", path, i))...)
+			}
+		}
+		return images
+	}
+
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, fmt.Sprintf("%v", key))
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		child := m[key]
+		childPath := key
+		if path != "" {
+			childPath = path + "." + key
+		}
+
+		if key == "image" {
+			switch image := child.(type) {
+			case string:
+				images = append(images, valuesImage{Key: childPath, Reference: image})
+				continue
+			case map[interface{}]interface{}:
+				if repository, ok := image["repository"].(string); ok && repository != "" {
+					if registry, ok := image["registry"].(string); ok && registry != "" {
+						repository = strings.TrimRight(registry, "/") + "/" + repository
+					}
+					images = append(images, valuesImage{Key: childPath, Reference: repository})
+					continue
+				}
+			}
+		}
+		images = append(images, findImages(child, childPath)...)
+	}
+	return images
+}
+
+// Returns the full name of an image's repository, eg: `docker.io/library/nginx`
+// for `nginx:1.15`, so that it can be compared against allowed registries.
+func imageRepository(reference string) string {
+	name := reference
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+
+	tokens := strings.SplitN(name, "/", 2)
+	if len(tokens) == 1 {
+		return "docker.io/library/" + name
+	}
+	if !strings.ContainsAny(tokens[0], ".:") && tokens[0] != "localhost" {
+		return "docker.io/" + name
+	}
+	return name
+}
+
+func isAllowedRegistry(reference string, allowed []string) bool {
+	repository := imageRepository(reference)
+	for _, registry := range allowed {
+		registry = strings.TrimRight(registry, "/")
+		if repository == registry || strings.HasPrefix(repository, registry+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks a chart's merged values against `valuesConventions`.
+func lintValues(chartName string, conventions ankh.ValuesConventions, values map[interface{}]interface{}) []error {
+	errors := []error{}
+
+	labelsKey := conventions.LabelsKey
+	if labelsKey == "" {
+		labelsKey = defaultLabelsKey
+	}
+	labels := []string{}
+	for label := range conventions.RequiredLabels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		key := labelsKey + "." + label
+		value, ok := lookupValue(values, key)
+		if !ok || fmt.Sprintf("%v", value) == "" {
+			errors = append(errors, fmt.Errorf("Chart '%v': values are missing the required label `%v`. "+
+				"Set it in the chart's values, eg: `default-values` in the Ankh file", chartName, key))
+			continue
+		}
+		if pattern := conventions.RequiredLabels[label]; pattern != "" {
+			if err := matchConvention(chartName, key, value, pattern); err != nil {
+				errors = append(errors, err)
+			}
+		}
+	}
+
+	keys := []string{}
+	for key := range conventions.NamingPatterns {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value, ok := lookupValue(values, key); ok {
+			if err := matchConvention(chartName, key, value, conventions.NamingPatterns[key]); err != nil {
+				errors = append(errors, err)
+			}
+		}
+	}
+
+	if len(conventions.AllowedRegistries) > 0 {
+		for _, image := range findImages(values, "") {
+			if !isAllowedRegistry(image.Reference, conventions.AllowedRegistries) {
+				errors = append(errors, fmt.Errorf("Chart '%v': image `%v` at `%v` is not from an allowed registry (%v)",
+					chartName, image.Reference, image.Key, strings.Join(conventions.AllowedRegistries, ", ")))
+			}
+		}
+	}
+	return errors
+}
+
+func matchConvention(chartName string, key string, value interface{}, pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("Invalid pattern `%v` for `%v` in `valuesConventions`: %v", pattern, key, err)
+	}
+	if s := fmt.Sprintf("%v", value); !re.MatchString(s) {
+		return fmt.Errorf("Chart '%v': value `%v` of `%v` does not match the convention `%v`", chartName, s, key, pattern)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+func TestMergedValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-conventions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "values.yaml"), []byte("labels:\n  team: core\nimage: nginx:1.15\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "prod.yaml"), []byte("labels:\n  tier: web\n"), 0644)
+
+	values, err := mergedValues(dir, []string{"--namespace", "web", "-f", filepath.Join(dir, "prod.yaml"),
+		"--set", "labels.team=platform,replicas=3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[string]string{"labels.team": "platform", "labels.tier": "web", "replicas": "3", "image": "nginx:1.15"} {
+		if value, ok := lookupValue(values, key); !ok || value != expected {
+			t.Logf("expected %v=%v but got %v", key, expected, value)
+			t.Fail()
+		}
+	}
+}
+
+func TestImageRepository(t *testing.T) {
+	cases := map[string]string{
+		"nginx":                                 "docker.io/library/nginx",
+		"nginx:1.15":                            "docker.io/library/nginx",
+		"bitnami/redis:5":                       "docker.io/bitnami/redis",
+		"registry.example.com/team/app:1.0":     "registry.example.com/team/app",
+		"localhost:5000/app@sha256:abcd":        "localhost:5000/app",
+		"registry.example.com:8443/app:1.0-rc1": "registry.example.com:8443/app",
+	}
+	for reference, expected := range cases {
+		if repository := imageRepository(reference); repository != expected {
+			t.Logf("expected %v for %v but got %v", expected, reference, repository)
+			t.Fail()
+		}
+	}
+}
+
+func TestLintValues(t *testing.T) {
+	conventions := ankh.ValuesConventions{
+		RequiredLabels:    map[string]string{"team": "", "cost-center": "^[0-9]+$"},
+		NamingPatterns:    map[string]string{"nameOverride": "^[a-z][a-z0-9-]*$"},
+		AllowedRegistries: []string{"registry.example.com", "docker.io/library"},
+	}
+	values := map[interface{}]interface{}{
+		"labels":       map[interface{}]interface{}{"cost-center": "abc"},
+		"nameOverride": "My_App",
+		"image":        map[interface{}]interface{}{"registry": "registry.example.com", "repository": "team/app", "tag": "1.0"},
+		"sidecars": []interface{}{
+			map[interface{}]interface{}{"image": "nginx:1.15"},
+			map[interface{}]interface{}{"image": "quay.io/prometheus/node-exporter"},
+		},
+	}
+
+	errors := lintValues("foo", conventions, values)
+	messages := []string{}
+	for _, err := range errors {
+		messages = append(messages, err.Error())
+	}
+	joined := strings.Join(messages, "\n")
+	for _, expected := range []string{
+		"value `abc` of `labels.cost-center` does not match the convention `^[0-9]+$`",
+		"missing the required label `labels.team`",
+		"value `My_App` of `nameOverride`",
+		"image `quay.io/prometheus/node-exporter` at `sidecars[1].image` is not from an allowed registry",
+	} {
+		if !strings.Contains(joined, expected) {
+			t.Logf("expected %q in errors:\n%v", expected, joined)
+			t.Fail()
+		}
+	}
+	if len(errors) != 4 {
+		t.Logf("expected 4 errors but got:\n%v", joined)
+		t.Fail()
+	}
+}
+
+func TestLintStageChecksConventions(t *testing.T) {
+	repository := ankhtest.NewChartRepository(t, ankhtest.Chart{Name: "foo", Version: "1.0.0",
+		Files: map[string]string{"values.yaml": "image: quay.io/foo/foo:1.0\n"}})
+	tools := ankhtest.NewTools(t)
+	tools.Fake("helm", ankhtest.Rule{Args: "template *", Stdout: "kind: ConfigMap\nmetadata:\n  name: foo\n"})
+
+	ctx := ankhtest.NewContext(t)
+	ctx.Mode = ankh.Lint
+	ctx.AnkhConfig.Helm.Repository = repository.URL
+	ctx.AnkhConfig.ValuesConventions.AllowedRegistries = []string{"registry.example.com"}
+
+	out, err := NewTemplateStage([]ankh.Chart{ankh.Chart{Name: "foo", Version: "1.0.0"}}).Execute(ctx, nil, "web", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewLintStage(nil).Execute(ctx, &out, "web", nil); err == nil || err.Error() != "Lint found 1 errors" {
+		t.Logf("expected the image to violate the allowed registries, but got %v", err)
+		t.Fail()
+	}
+	if len(ctx.LintErrors) != 0 {
+		t.Logf("expected the lint stage to consume the errors found while templating")
+		t.Fail()
+	}
+}
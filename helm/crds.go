@@ -0,0 +1,78 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+)
+
+// Lists the CRD files in a chart's `crds/` directory, which `helm template`
+// leaves out of its output.
+func findCrdFiles(crdsDir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(crdsDir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return []string{}, err
+	}
+
+	paths := []string{}
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".yaml", ".yml", ".json":
+			if !entry.IsDir() {
+				paths = append(paths, filepath.Join(crdsDir, entry.Name()))
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// GetCrdFiles returns the CRD files of every chart, in chart order, so that
+// they can be installed before the charts themselves. There are none when
+// kind filters leave out CustomResourceDefinition, or when filtering by name
+// or labels, since CRD files are installed whole.
+func GetCrdFiles(ctx *ankh.ExecutionContext, charts []ankh.Chart) ([]string, error) {
+	paths := []string{}
+	if ctx.FilterName != "" || ctx.FilterLabels != nil {
+		ctx.Logger.Debugf("Skipping CRDs, which are not filtered by name or labels")
+		return paths, nil
+	}
+	if len(ctx.Filters) > 0 {
+		included := false
+		for _, filter := range ctx.Filters {
+			if strings.EqualFold(filter, "CustomResourceDefinition") {
+				included = true
+			}
+		}
+		if !included {
+			return paths, nil
+		}
+	}
+
+	for _, chart := range charts {
+		repository, err := ctx.DetermineHelmRepository(&chart.HelmRepository)
+		if err != nil {
+			return []string{}, err
+		}
+		files, err := findChartFiles(ctx, repository, chart)
+		if err != nil {
+			return []string{}, err
+		}
+
+		chartPaths, err := findCrdFiles(files.CrdsDir)
+		if err != nil {
+			return []string{}, err
+		}
+		if len(chartPaths) > 0 {
+			ctx.Logger.Debugf("Found %v CRD file(s) in chart \"%v\"", len(chartPaths), chart.InstanceName())
+		}
+		paths = append(paths, chartPaths...)
+	}
+	return paths, nil
+}
@@ -0,0 +1,37 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindCrdFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-crds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	paths, err := findCrdFiles(filepath.Join(dir, "crds"))
+	if err != nil || len(paths) != 0 {
+		t.Logf("expected no CRD files without a crds/ directory but got %v and error %v", paths, err)
+		t.Fail()
+	}
+
+	crdsDir := filepath.Join(dir, "crds")
+	os.MkdirAll(crdsDir, 0755)
+	for _, name := range []string{"b.yaml", "a.json", "README.md"} {
+		ioutil.WriteFile(filepath.Join(crdsDir, name), []byte{}, 0644)
+	}
+
+	paths, err = findCrdFiles(crdsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 || filepath.Base(paths[0]) != "a.json" || filepath.Base(paths[1]) != "b.yaml" {
+		t.Logf("expected [a.json b.yaml] but got %v", paths)
+		t.Fail()
+	}
+}
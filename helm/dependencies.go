@@ -0,0 +1,140 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/util"
+)
+
+// The parts of a chart's Chart.yaml (or Helm 2 requirements.yaml) that
+// matter when fetching and rendering it.
+type chartDefinition struct {
+	Name         string
+	Version      string
+	Type         string
+	Dependencies []chartDependency
+}
+
+type chartDependency struct {
+	Name       string
+	Version    string
+	Repository string
+}
+
+const libraryChartType = "library"
+
+// Helm only fetches exact versions without consulting a repository's index.
+var exactChartVersion = regexp.MustCompile(`^v?[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+func readChartDefinition(chartDir string) (chartDefinition, error) {
+	definition := chartDefinition{}
+	body, err := ioutil.ReadFile(filepath.Join(chartDir, "Chart.yaml"))
+	if err != nil {
+		return definition, err
+	}
+	if err := yaml.Unmarshal(body, &definition); err != nil {
+		return definition, fmt.Errorf("unable to parse Chart.yaml: %v", err)
+	}
+
+	// Helm 2 charts declare their dependencies in requirements.yaml
+	body, err = ioutil.ReadFile(filepath.Join(chartDir, "requirements.yaml"))
+	if err == nil {
+		requirements := chartDefinition{}
+		if err := yaml.Unmarshal(body, &requirements); err != nil {
+			return definition, fmt.Errorf("unable to parse requirements.yaml: %v", err)
+		}
+		definition.Dependencies = append(definition.Dependencies, requirements.Dependencies...)
+	} else if !os.IsNotExist(err) {
+		return definition, err
+	}
+	return definition, nil
+}
+
+// Library charts only define templates for other charts to use, so helm
+// refuses to render them on their own.
+func isLibraryChart(chartDir string) bool {
+	definition, err := readChartDefinition(chartDir)
+	return err == nil && definition.Type == libraryChartType
+}
+
+func hasDependency(chartsDir string, dependency chartDependency) bool {
+	if _, err := os.Stat(filepath.Join(chartsDir, dependency.Name, "Chart.yaml")); err == nil {
+		return true
+	}
+	tarballs, _ := filepath.Glob(filepath.Join(chartsDir, dependency.Name+"-*.tgz"))
+	return len(tarballs) > 0
+}
+
+// Fetches each dependency of the chart in `chartDir` that is missing from its
+// `charts` directory, as `helm dependency build` would. Dependencies with a
+// `file://` repository are copied relative to `sourceDir`, the directory the
+// chart was copied from, if any. Others are fetched from the dependency's
+// repository, by URL or by the name of a configured helm repository, or else
+// from the chart's own repositories.
+func fetchDependencies(ctx *ankh.ExecutionContext, repository string, chartDir string, sourceDir string) error {
+	definition, err := readChartDefinition(chartDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	chartsDir := filepath.Join(chartDir, "charts")
+	for _, dependency := range definition.Dependencies {
+		if hasDependency(chartsDir, dependency) {
+			continue
+		}
+		if err := os.MkdirAll(chartsDir, 0755); err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(dependency.Repository, "file://") {
+			if sourceDir == "" {
+				return fmt.Errorf("dependency '%v' refers to %v, which is only available for charts in a local directory",
+					dependency.Name, dependency.Repository)
+			}
+			dependencyPath := strings.TrimPrefix(dependency.Repository, "file://")
+			if !filepath.IsAbs(dependencyPath) {
+				dependencyPath = filepath.Join(sourceDir, dependencyPath)
+			}
+			ctx.Logger.Debugf("Copying dependency '%v' from %v", dependency.Name, dependencyPath)
+			if err := util.CopyDir(dependencyPath, filepath.Join(chartsDir, dependency.Name)); err != nil {
+				return fmt.Errorf("unable to copy dependency '%v' from %v: %v", dependency.Name, dependencyPath, err)
+			}
+			if err := fetchDependencies(ctx, repository, filepath.Join(chartsDir, dependency.Name), dependencyPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !exactChartVersion.MatchString(dependency.Version) {
+			return fmt.Errorf("dependency '%v' has version '%v', but only exact versions can be fetched. "+
+				"Pin an exact version, or run `helm dependency build` and keep the result in the chart's `charts` directory",
+				dependency.Name, dependency.Version)
+		}
+
+		dependencyChart := ankh.Chart{Name: dependency.Name, Version: dependency.Version}
+		if dependency.Repository != "" {
+			// Helm refers to named repositories as `@name` or `alias:name`
+			dependencyChart.HelmRepository = strings.TrimPrefix(strings.TrimPrefix(dependency.Repository, "@"), "alias:")
+		}
+		repositories := chartRepositories(ctx, repository, dependencyChart)
+		if len(repositories) == 0 {
+			return fmt.Errorf("no helm repository configured to fetch dependency '%v' from", dependency.Name)
+		}
+		ctx.Logger.Debugf("Fetching dependency '%v' at version '%v'", dependency.Name, dependency.Version)
+		if err := fetchChartFromRepositories(ctx, repositories, dependencyChart, chartsDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+func writeChart(t *testing.T, dir string, files map[string]string) {
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFetchDependencies(t *testing.T) {
+	repository := ankhtest.NewChartRepository(t, ankhtest.Chart{Name: "redis", Version: "5.0.0"})
+	dir, err := ioutil.TempDir("", "ankh-dependencies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeChart(t, filepath.Join(dir, "common"), map[string]string{
+		"Chart.yaml":            "apiVersion: v2\nname: common\nversion: 1.0.0\ntype: library\n",
+		"templates/_labels.tpl": `{{- define "common.labels" -}}team: core{{- end -}}`,
+	})
+	writeChart(t, filepath.Join(dir, "app"), map[string]string{
+		"Chart.yaml": "apiVersion: v2\nname: app\nversion: 1.0.0\ndependencies:\n" +
+			"- name: common\n  version: 1.0.0\n  repository: file://../common\n" +
+			"- name: redis\n  version: 5.0.0\n  repository: \"@cache\"\n",
+	})
+
+	ctx := ankhtest.NewContext(t)
+	ctx.AnkhConfig.Helm.Repositories = []ankh.HelmRepositoryConfig{{Name: "cache", URL: repository.URL}}
+	files, err := findChartFiles(ctx, "", ankh.Chart{Name: "app", Path: filepath.Join(dir, "app")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"charts/common/templates/_labels.tpl", "charts/redis/Chart.yaml"} {
+		if _, err := os.Stat(filepath.Join(files.ChartDir, path)); err != nil {
+			t.Logf("expected the dependency at %v: %v", path, err)
+			t.Fail()
+		}
+	}
+	if !isLibraryChart(filepath.Join(files.ChartDir, "charts", "common")) || isLibraryChart(files.ChartDir) {
+		t.Logf("expected only the common chart to be a library chart")
+		t.Fail()
+	}
+
+	// Dependencies that are already present are not fetched again.
+	if _, err := findChartFiles(ctx, "", ankh.Chart{Name: "redis", Version: "5.0.0", HelmRepository: "cache"}); err != nil {
+		t.Fatal(err)
+	}
+	if requests := repository.Requests(); len(requests) != 2 {
+		t.Logf("expected the dependency to be fetched once but got requests %v", requests)
+		t.Fail()
+	}
+
+	writeChart(t, filepath.Join(dir, "ranged"), map[string]string{
+		"Chart.yaml": "apiVersion: v2\nname: ranged\nversion: 1.0.0\ndependencies:\n- name: redis\n  version: ^5.0.0\n",
+	})
+	_, err = findChartFiles(ctx, repository.URL, ankh.Chart{Name: "ranged", Path: filepath.Join(dir, "ranged")})
+	if err == nil || !strings.Contains(err.Error(), "only exact versions can be fetched") {
+		t.Logf("expected version ranges to be refused but got %v", err)
+		t.Fail()
+	}
+}
+
+func TestTemplateStageRefusesLibraryCharts(t *testing.T) {
+	repository := ankhtest.NewChartRepository(t, ankhtest.Chart{Name: "common", Version: "1.0.0",
+		Files: map[string]string{"Chart.yaml": "apiVersion: v2\nname: common\nversion: 1.0.0\ntype: library\n"}})
+	tools := ankhtest.NewTools(t)
+	tools.Fake("helm", ankhtest.Rule{Args: "template *", Stdout: "kind: ConfigMap\n"})
+
+	ctx := ankhtest.NewContext(t)
+	ctx.AnkhConfig.Helm.Repository = repository.URL
+	chart := ankh.Chart{Name: "common", Version: "1.0.0"}
+
+	meta, err := FetchChartMeta(ctx, repository.URL, &chart)
+	if err != nil || !meta.Library {
+		t.Logf("expected the chart meta to mark a library chart, but got %+v and %v", meta, err)
+		t.Fail()
+	}
+	_, err = NewTemplateStage([]ankh.Chart{chart}).Execute(ctx, nil, "web", nil)
+	if err == nil || !strings.Contains(err.Error(), "is a library chart") {
+		t.Logf("expected library charts not to be templated, but got %v", err)
+		t.Fail()
+	}
+	if calls := tools.Calls("helm"); len(calls) != 0 {
+		t.Logf("expected helm not to be called but got %+v", calls)
+		t.Fail()
+	}
+}
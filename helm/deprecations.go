@@ -0,0 +1,215 @@
+package helm
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+)
+
+// Chart repositories generally regenerate index.yaml on every upload, so
+// deprecations are kept in a sidecar file next to it.
+const deprecationsFileName = "ankh-deprecations.yaml"
+
+// Deprecation messages, by chart name and then by version
+type Deprecations map[string]map[string]string
+
+func (deprecations Deprecations) Message(chart string, version string) (string, bool) {
+	message, ok := deprecations[chart][version]
+	return message, ok
+}
+
+func deprecationsURL(repository string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimRight(repository, "/"), deprecationsFileName)
+}
+
+func newRepositoryClient(ctx *ankh.ExecutionContext) *http.Client {
+	return &http.Client{
+		Transport: ctx.Tracer.Transport(&http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}),
+		Timeout: time.Duration(5 * time.Second),
+	}
+}
+
+func getDeprecationsFile(ctx *ankh.ExecutionContext, repository string) (Deprecations, error) {
+	deprecations := Deprecations{}
+
+	url := deprecationsURL(repository)
+	ctx.Logger.Debugf("downloading %v from %s", deprecationsFileName, url)
+	resp, err := newRepositoryClient(ctx).Get(url)
+	if err != nil {
+		return deprecations, fmt.Errorf("got an error %v when trying to call %v", err, url)
+	}
+	defer resp.Body.Close()
+
+	// Most repositories have never had a version deprecated.
+	if resp.StatusCode == 404 {
+		return deprecations, nil
+	}
+	if resp.StatusCode != 200 {
+		return deprecations, fmt.Errorf("Received HTTP status '%v' (code %v) when trying to call %s", resp.Status, resp.StatusCode, url)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return deprecations, err
+	}
+
+	if err := yaml.Unmarshal(body, &deprecations); err != nil {
+		return deprecations, fmt.Errorf("Could not parse %v: %v", url, err)
+	}
+	return deprecations, nil
+}
+
+// GetDeprecations returns deprecated chart versions from the repository's
+// sidecar file, as well as any versions marked `deprecated` in index.yaml.
+func GetDeprecations(ctx *ankh.ExecutionContext, repository string) (Deprecations, error) {
+	if IsOCIRepository(repository) {
+		// OCI registries have neither an index nor a place for the sidecar file.
+		return Deprecations{}, nil
+	}
+
+	deprecations, err := getDeprecationsFile(ctx, repository)
+	if err != nil {
+		return deprecations, err
+	}
+
+	index, err := getIndex(ctx, repository)
+	if err != nil {
+		return deprecations, err
+	}
+
+	for name, entries := range index.Entries {
+		for _, entry := range entries {
+			if !entry.Deprecated {
+				continue
+			}
+			if _, ok := deprecations.Message(name, entry.Version); ok {
+				continue
+			}
+			if deprecations[name] == nil {
+				deprecations[name] = map[string]string{}
+			}
+			deprecations[name][entry.Version] = "deprecated in Chart.yaml"
+		}
+	}
+
+	return deprecations, nil
+}
+
+// FlagDeprecatedVersions annotates deprecated versions for display, eg: in a
+// prompt. The version is always the first field of each line.
+func FlagDeprecatedVersions(ctx *ankh.ExecutionContext, repository string, chart string, versions []string) []string {
+	deprecations, err := GetDeprecations(ctx, repository)
+	if err != nil {
+		ctx.Logger.Warnf("Unable to check for deprecated versions of chart \"%v\": %v", chart, err)
+		return versions
+	}
+
+	flagged := []string{}
+	for _, version := range versions {
+		if message, ok := deprecations.Message(chart, version); ok {
+			version = fmt.Sprintf("%v    [DEPRECATED: %v]", version, message)
+		}
+		flagged = append(flagged, version)
+	}
+	return flagged
+}
+
+// WarnIfDeprecated logs a warning if the chart version is deprecated.
+func WarnIfDeprecated(ctx *ankh.ExecutionContext, repository string, chart string, version string) {
+	deprecations, err := GetDeprecations(ctx, repository)
+	if err != nil {
+		ctx.Logger.Debugf("Unable to check whether chart \"%v\" at version \"%v\" is deprecated: %v", chart, version, err)
+		return
+	}
+
+	if message, ok := deprecations.Message(chart, version); ok {
+		ctx.Logger.Warnf("Chart \"%v\" at version \"%v\" is deprecated: %v", chart, version, message)
+	}
+}
+
+// Deprecate marks a chart version as deprecated in the repository's sidecar file,
+// or with undo, removes the mark.
+func Deprecate(ctx *ankh.ExecutionContext, repository string, chart string, version string, message string, undo bool) error {
+	if IsOCIRepository(repository) {
+		return fmt.Errorf("Deprecating chart versions is not supported for OCI registries")
+	}
+
+	versions, err := ListVersions(ctx, repository, chart, true)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, v := range strings.Split(versions, "\n") {
+		if v == version {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("Could not find chart \"%v\" at version \"%v\" in repository '%v'. "+
+			"Try `ankh chart versions %v` to see its versions.", chart, version, repository, chart)
+	}
+
+	deprecations, err := getDeprecationsFile(ctx, repository)
+	if err != nil {
+		return err
+	}
+
+	if undo {
+		delete(deprecations[chart], version)
+		if len(deprecations[chart]) == 0 {
+			delete(deprecations, chart)
+		}
+	} else {
+		if message == "" {
+			message = "no reason given"
+		}
+		if deprecations[chart] == nil {
+			deprecations[chart] = map[string]string{}
+		}
+		deprecations[chart][version] = message
+	}
+
+	body, err := yaml.Marshal(deprecations)
+	if err != nil {
+		return err
+	}
+
+	url := deprecationsURL(repository)
+	req, err := http.NewRequest("PUT", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if err := setRepositoryAuth(ctx, req, repository); err != nil {
+		return err
+	}
+
+	resp, err := newRepositoryClient(ctx).Do(req)
+	if err != nil {
+		return fmt.Errorf("got an error %v when trying to PUT %v", err, url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Received HTTP status '%v' (code %v) when trying to PUT %s",
+			resp.Status, resp.StatusCode, url)
+	}
+
+	if undo {
+		ctx.Logger.Infof("Chart \"%v\" at version \"%v\" is no longer deprecated", chart, version)
+	} else {
+		ctx.Logger.Infof("Deprecated chart \"%v\" at version \"%v\": %v", chart, version, message)
+	}
+	return nil
+}
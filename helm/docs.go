@@ -0,0 +1,208 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/appnexus/ankh/context"
+)
+
+const (
+	ansiBold      = "\x1b[1m"
+	ansiUnderline = "\x1b[4m"
+	ansiDim       = "\x1b[2m"
+	ansiReset     = "\x1b[0m"
+)
+
+// A documented value from values.yaml
+type valueDoc struct {
+	Key         string
+	Default     string
+	Description string
+}
+
+var valuesKeyRegexp = regexp.MustCompile(`^(\s*)(-\s+)?([A-Za-z0-9_.\-"']+):\s*(.*)$`)
+
+// Extracts each key in values.yaml along with the comment lines directly above
+// it. Nested keys are reported with their full, dotted path.
+func parseValuesDocs(values string) []valueDoc {
+	docs := []valueDoc{}
+	comments := []string{}
+
+	type level struct {
+		indent int
+		key    string
+	}
+	parents := []level{}
+
+	for _, line := range strings.Split(values, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			comments = []string{}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "#") {
+			comments = append(comments, strings.TrimSpace(strings.TrimLeft(trimmed, "#")))
+			continue
+		}
+
+		match := valuesKeyRegexp.FindStringSubmatch(line)
+		if match == nil || match[2] != "" {
+			// List items and continuation lines aren't documented individually.
+			comments = []string{}
+			continue
+		}
+
+		indent := len(match[1])
+		key := strings.Trim(match[3], `"'`)
+		for len(parents) > 0 && parents[len(parents)-1].indent >= indent {
+			parents = parents[:len(parents)-1]
+		}
+
+		path := []string{}
+		for _, parent := range parents {
+			path = append(path, parent.key)
+		}
+		path = append(path, key)
+
+		value := strings.TrimSpace(match[4])
+		if i := strings.Index(value, " #"); i >= 0 {
+			comments = append(comments, strings.TrimSpace(value[i+2:]))
+			value = strings.TrimSpace(value[:i])
+		}
+
+		if value == "" || value == "|" || value == ">" {
+			parents = append(parents, level{indent: indent, key: key})
+		}
+
+		if len(comments) > 0 {
+			docs = append(docs, valueDoc{
+				Key:         strings.Join(path, "."),
+				Default:     value,
+				Description: strings.Join(comments, " "),
+			})
+		}
+		comments = []string{}
+	}
+
+	return docs
+}
+
+var (
+	markdownHeading = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	markdownBullet  = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	markdownBold    = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	markdownCode    = regexp.MustCompile("`([^`]+)`")
+	markdownLink    = regexp.MustCompile(`\[([^\]]+)\]\(([^)]+)\)`)
+)
+
+// Renders markdown for a terminal: headings are emphasized, code blocks are
+// indented, and inline markup is replaced with terminal formatting.
+func renderMarkdown(markdown string) string {
+	var buf bytes.Buffer
+	inCodeBlock := false
+	for _, line := range strings.Split(markdown, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCodeBlock = !inCodeBlock
+			continue
+		}
+		if inCodeBlock {
+			fmt.Fprintf(&buf, "    %v%v%v\n", ansiDim, line, ansiReset)
+			continue
+		}
+
+		if match := markdownHeading.FindStringSubmatch(line); match != nil {
+			style := ansiBold
+			if len(match[1]) == 1 {
+				style += ansiUnderline
+			}
+			fmt.Fprintf(&buf, "%v%v%v\n", style, match[2], ansiReset)
+			continue
+		}
+
+		if match := markdownBullet.FindStringSubmatch(line); match != nil {
+			line = match[1] + "• " + match[2]
+		}
+		line = markdownBold.ReplaceAllString(line, ansiBold+"$1"+ansiReset)
+		line = markdownCode.ReplaceAllString(line, ansiDim+"$1"+ansiReset)
+		line = markdownLink.ReplaceAllString(line, ansiUnderline+"$1"+ansiReset+" ($2)")
+		fmt.Fprintf(&buf, "%v\n", line)
+	}
+	return buf.String()
+}
+
+func findReadme(chartDir string) string {
+	files, err := ioutil.ReadDir(chartDir)
+	if err != nil {
+		return ""
+	}
+	for _, f := range files {
+		if !f.IsDir() && strings.HasPrefix(strings.ToLower(f.Name()), "readme") {
+			return filepath.Join(chartDir, f.Name())
+		}
+	}
+	return ""
+}
+
+// Docs renders a chart's README and documented values.yaml entries.
+func Docs(ctx *ankh.ExecutionContext, repository string, singleChart string, formatMarkdown bool) (string, error) {
+	chartName, chartVersion, err := resolveChartVersion(ctx, repository, singleChart)
+	if err != nil {
+		return "", err
+	}
+
+	ctx.Logger.Infof("Fetching docs for chart \"%s\" at version \"%v\" from repository \"%v\"",
+		chartName, chartVersion, repository)
+
+	files, err := findChartFiles(ctx, repository, ankh.Chart{Name: chartName, Version: chartVersion})
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if readmePath := findReadme(files.ChartDir); readmePath != "" {
+		readme, err := ioutil.ReadFile(readmePath)
+		if err != nil {
+			return "", err
+		}
+		if formatMarkdown {
+			buf.WriteString(renderMarkdown(string(readme)))
+		} else {
+			buf.Write(readme)
+		}
+	} else {
+		fmt.Fprintf(&buf, "Chart \"%v\" at version \"%v\" has no README\n", chartName, chartVersion)
+	}
+
+	values, err := ioutil.ReadFile(files.ValuesPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	docs := parseValuesDocs(string(values))
+	if len(docs) == 0 {
+		fmt.Fprintf(&buf, "\nNo documented values found in values.yaml\n")
+		return buf.String(), nil
+	}
+
+	header := "Values"
+	if formatMarkdown {
+		header = ansiBold + ansiUnderline + header + ansiReset
+	}
+	fmt.Fprintf(&buf, "\n%v\n\n", header)
+
+	w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "KEY\tDEFAULT\tDESCRIPTION\n")
+	for _, doc := range docs {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", doc.Key, doc.Default, doc.Description)
+	}
+	w.Flush()
+
+	return buf.String(), nil
+}
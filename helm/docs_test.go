@@ -0,0 +1,59 @@
+package helm
+
+import (
+	"strings"
+	"testing"
+)
+
+const documentedValuesYAML string = `
+# The number of pods to run
+replicas: 2
+
+image:
+  # The image repository
+  repository: example/app
+  tag: latest # Overridden by the tag value
+
+undocumented: true
+
+resources:
+  # Requests for each pod
+  requests:
+    cpu: 100m
+`
+
+func TestParseValuesDocs(t *testing.T) {
+	docs := parseValuesDocs(documentedValuesYAML)
+
+	expected := []valueDoc{
+		{Key: "replicas", Default: "2", Description: "The number of pods to run"},
+		{Key: "image.repository", Default: "example/app", Description: "The image repository"},
+		{Key: "image.tag", Default: "latest", Description: "Overridden by the tag value"},
+		{Key: "resources.requests", Default: "", Description: "Requests for each pod"},
+	}
+
+	if len(docs) != len(expected) {
+		t.Logf("expected %+v but got %+v", expected, docs)
+		t.FailNow()
+	}
+	for i := range expected {
+		if docs[i] != expected[i] {
+			t.Logf("expected %+v but got %+v", expected[i], docs[i])
+			t.Fail()
+		}
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	out := renderMarkdown("# Title\n- item with `code`\n```\nliteral *text*\n```\n")
+	for _, expected := range []string{
+		ansiBold + ansiUnderline + "Title" + ansiReset,
+		"• item with " + ansiDim + "code" + ansiReset,
+		"    " + ansiDim + "literal *text*" + ansiReset,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Logf("expected rendered markdown to contain %q, got %q", expected, out)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+)
+
+// The directory that objects without any namespace are exported to, eg:
+// cluster-scoped objects when templating without a namespace.
+const clusterExportDir = "_cluster"
+
+const kustomizationFileName = "kustomization.yaml"
+
+type kustomization struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Kind       string   `yaml:"kind"`
+	Namespace  string   `yaml:"namespace,omitempty"`
+	Resources  []string `yaml:"resources"`
+	Components []string `yaml:"components,omitempty"`
+}
+
+type exportedObject struct {
+	Kind     string
+	Metadata struct {
+		Name      string
+		Namespace string
+	}
+}
+
+// Returns the path of an object's file, relative to the export dir, eg:
+// `web/deployment-foo.yaml`.
+func exportPath(kind string, name string, namespace string) string {
+	if namespace == "" {
+		namespace = clusterExportDir
+	}
+	return filepath.Join(namespace, fmt.Sprintf("%v-%v.yaml", strings.ToLower(kind), name))
+}
+
+// Writes each object in helm's output to a file of its own under
+// `ctx.ExportDir`, by namespace, and indexes each namespace directory, and
+// the export dir, with a kustomization.yaml. Files already in a namespace
+// directory are removed the first time it is exported to in a run, so that
+// objects which are no longer rendered are removed too.
+func exportObjects(ctx *ankh.ExecutionContext, helmOutput string, namespace string) (int, error) {
+	if ctx.ExportedObjects == nil {
+		ctx.ExportedObjects = make(map[string]bool)
+	}
+
+	// Split the "hard way", as filterOutput does, to keep comments, eg: `# Source:`
+	objects := map[string]string{}
+	paths := []string{}
+	for _, body := range strings.Split(helmOutput, "\n---") {
+		body = strings.Trim(strings.TrimPrefix(strings.TrimLeft(body, "\n"), "---"), "\n")
+		obj := exportedObject{}
+		if err := yaml.Unmarshal([]byte(body), &obj); err != nil {
+			return 0, fmt.Errorf("unable to parse a rendered object to export it: %v", err)
+		}
+		if obj.Kind == "" || obj.Metadata.Name == "" {
+			continue
+		}
+
+		objectNamespace := obj.Metadata.Namespace
+		if objectNamespace == "" {
+			objectNamespace = namespace
+		}
+		path := exportPath(obj.Kind, obj.Metadata.Name, objectNamespace)
+		if _, ok := objects[path]; ok || ctx.ExportedObjects[path] {
+			return 0, fmt.Errorf("%v \"%v\" was rendered more than once for namespace \"%v\". "+
+				"When exporting an environment, export each of its contexts to a directory of its own",
+				obj.Kind, obj.Metadata.Name, objectNamespace)
+		}
+		objects[path] = body
+		paths = append(paths, path)
+	}
+
+	for _, path := range paths {
+		dir := filepath.Join(ctx.ExportDir, filepath.Dir(path))
+		if !exportedTo(ctx, filepath.Dir(path)) {
+			if err := clearExportDir(dir); err != nil {
+				return 0, err
+			}
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return 0, err
+		}
+		if err := ioutil.WriteFile(filepath.Join(ctx.ExportDir, path), []byte(objects[path]+"\n"), 0644); err != nil {
+			return 0, err
+		}
+		ctx.ExportedObjects[path] = true
+	}
+
+	if err := os.MkdirAll(ctx.ExportDir, 0755); err != nil {
+		return 0, err
+	}
+	if err := writeKustomizations(ctx.ExportDir); err != nil {
+		return 0, err
+	}
+	return len(paths), nil
+}
+
+// Whether any object was exported to a namespace directory in this run.
+func exportedTo(ctx *ankh.ExecutionContext, dir string) bool {
+	for path := range ctx.ExportedObjects {
+		if filepath.Dir(path) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// Removes the objects and index from an export directory.
+func clearExportDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeKustomization(dir string, index kustomization) error {
+	index.APIVersion = "kustomize.config.k8s.io/v1beta1"
+	index.Kind = "Kustomization"
+	body, err := yaml.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, kustomizationFileName), body, 0644)
+}
+
+// Indexes every namespace directory under `exportDir`, and `exportDir` itself.
+func writeKustomizations(exportDir string) error {
+	entries, err := ioutil.ReadDir(exportDir)
+	if err != nil {
+		return err
+	}
+
+	namespaces := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		files, err := filepath.Glob(filepath.Join(exportDir, entry.Name(), "*.yaml"))
+		if err != nil {
+			return err
+		}
+		index := kustomization{Resources: []string{}}
+		if entry.Name() != clusterExportDir {
+			index.Namespace = entry.Name()
+		}
+		for _, file := range files {
+			if filepath.Base(file) != kustomizationFileName {
+				index.Resources = append(index.Resources, filepath.Base(file))
+			}
+		}
+		if len(index.Resources) == 0 {
+			continue
+		}
+		sort.Strings(index.Resources)
+		if err := writeKustomization(filepath.Join(exportDir, entry.Name()), index); err != nil {
+			return err
+		}
+		namespaces = append(namespaces, entry.Name())
+	}
+
+	sort.Strings(namespaces)
+	return writeKustomization(exportDir, kustomization{Resources: namespaces})
+}
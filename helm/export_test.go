@@ -0,0 +1,96 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/context"
+)
+
+const exportOutput = `---
+# Source: app/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+---
+# Source: app/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+  namespace: other
+---
+# Source: app/templates/clusterrole.yaml
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: app-reader
+`
+
+func TestExportObjects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A file left over from an earlier export is removed.
+	os.MkdirAll(filepath.Join(dir, "web"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "web", "configmap-stale.yaml"), []byte{}, 0644)
+
+	ctx := &ankh.ExecutionContext{ExportDir: dir}
+	count, err := exportObjects(ctx, exportOutput, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Logf("expected 3 objects to be exported but got %v", count)
+		t.Fail()
+	}
+
+	for _, path := range []string{"web/deployment-app.yaml", "other/service-app.yaml"} {
+		body, err := ioutil.ReadFile(filepath.Join(dir, path))
+		if err != nil || !strings.HasPrefix(string(body), "# Source: app/templates/") {
+			t.Logf("expected %v to be exported with its source comment but got %q and %v", path, body, err)
+			t.Fail()
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "web", "configmap-stale.yaml")); !os.IsNotExist(err) {
+		t.Logf("expected the stale object to be removed")
+		t.Fail()
+	}
+
+	// Objects without a namespace go to the cluster directory when templating without one.
+	if _, err := exportObjects(&ankh.ExecutionContext{ExportDir: dir}, exportOutput, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, clusterExportDir, "clusterrole-app-reader.yaml")); err != nil {
+		t.Logf("expected the cluster role in %v but got %v", clusterExportDir, err)
+		t.Fail()
+	}
+
+	index, _ := ioutil.ReadFile(filepath.Join(dir, "web", kustomizationFileName))
+	expected := "apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\nnamespace: web\n" +
+		"resources:\n- clusterrole-app-reader.yaml\n- deployment-app.yaml\n"
+	if string(index) != expected {
+		t.Logf("expected the namespace index\n%v\nbut got\n%v", expected, string(index))
+		t.Fail()
+	}
+	index, _ = ioutil.ReadFile(filepath.Join(dir, kustomizationFileName))
+	expected = "apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\n" +
+		"resources:\n- _cluster\n- other\n- web\n"
+	if string(index) != expected {
+		t.Logf("expected the root index\n%v\nbut got\n%v", expected, string(index))
+		t.Fail()
+	}
+
+	// Rendering the same object twice in one run would overwrite it.
+	if _, err := exportObjects(ctx, exportOutput, "web"); err == nil {
+		t.Logf("expected an error for objects that were already exported")
+		t.Fail()
+	}
+}
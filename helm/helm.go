@@ -2,7 +2,9 @@ package helm
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -10,6 +12,7 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"text/tabwriter"
@@ -46,7 +49,7 @@ func findChartFilesImpl(ctx *ankh.ExecutionContext, repository string, chart ank
 	chartPath := chart.Path
 	dirErr := os.ErrNotExist
 	if version == "" && chartPath != "" {
-		if ctx.WorkingPath != "" {
+		if ctx.WorkingPath != "" && !filepath.IsAbs(chart.Path) {
 			chartPath = filepath.Join(ctx.WorkingPath, chart.Path)
 			ctx.Logger.Debugf("Using chartPath %v since WorkingPath is %v",
 				chartPath, ctx.WorkingPath)
@@ -68,6 +71,7 @@ func findChartFilesImpl(ctx *ankh.ExecutionContext, repository string, chart ank
 	if err != nil {
 		return files, err
 	}
+	util.OnPromptInterrupt(func() { os.RemoveAll(tmpDir) })
 
 	// If we already have a dir, let's just copy it to a temp directory so we can
 	// make changes to the ankh specific yaml files before passing them as `-f`
@@ -77,11 +81,8 @@ func findChartFilesImpl(ctx *ankh.ExecutionContext, repository string, chart ank
 			return files, err
 		}
 	} else {
-		// Override the provided repository if it is specified at the Chart level
-		if chart.HelmRepository != "" {
-			repository = chart.HelmRepository
-		}
-		if repository == "" {
+		repositories := chartRepositories(ctx, repository, chart)
+		if len(repositories) == 0 {
 			return files, fmt.Errorf("No helm repository configured. Set `helm.repository` globally, or see README.md on where to specify a helm repository.")
 		}
 
@@ -90,45 +91,23 @@ func findChartFilesImpl(ctx *ankh.ExecutionContext, repository string, chart ank
 			return files, fmt.Errorf("Cannot template chart '%v' without a version", chart.Name)
 		}
 
-		tarballFileName := fmt.Sprintf("%s-%s.tgz", name, version)
-		tarballURL := fmt.Sprintf("%s/%s", strings.TrimRight(repository, "/"), tarballFileName)
-
-		ok := false
-		for attempt := 1; attempt <= 5; attempt++ {
-			ctx.Logger.Debugf("downloading chart from %s (attempt %v)", tarballURL, attempt)
-			tr := &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			}
-			client := &http.Client{
-				Transport: tr,
-				Timeout:   time.Duration(5 * time.Second),
-			}
-			resp, err := client.Get(tarballURL)
-			if err != nil {
-				ctx.Logger.Warningf("got an error %v when trying to call %v (attempt %v)",
-					err, tarballURL, attempt)
-				continue
-			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode == 200 {
-				ctx.Logger.Debugf("untarring chart to %s", tmpDir)
-				if err = util.Untar(tmpDir, resp.Body); err != nil {
-					return files, err
-				}
-
-				ok = true
-				break
-			} else {
-				ctx.Logger.Warningf("Received HTTP status '%v' (code %v) when trying to call %s (attempt %v)", resp.Status, resp.StatusCode, tarballURL, attempt)
-			}
-		}
-		if !ok {
-			return files, fmt.Errorf("failed to fetch helm chart from URL: %v", tarballURL)
+		if err := fetchChartFromRepositories(ctx, repositories, chart, tmpDir); err != nil {
+			return files, err
 		}
 	}
 
 	chartDir := filepath.Join(tmpDir, name)
+
+	// Fetch any dependencies that the chart declares but does not bundle,
+	// eg: library charts when using a local chart path.
+	sourceDir := ""
+	if dirErr == nil {
+		sourceDir = chartPath
+	}
+	if err := fetchDependencies(ctx, repository, chartDir, sourceDir); err != nil {
+		return files, fmt.Errorf("Unable to fetch the dependencies of chart '%v': %v", name, err)
+	}
+
 	files = ankh.ChartFiles{
 		TmpDir:                   tmpDir,
 		ChartDir:                 chartDir,
@@ -138,11 +117,105 @@ func findChartFilesImpl(ctx *ankh.ExecutionContext, repository string, chart ank
 		AnkhValuesPath:           filepath.Join(chartDir, "ankh-values.yaml"),
 		AnkhResourceProfilesPath: filepath.Join(chartDir, "ankh-resource-profiles.yaml"),
 		AnkhReleasesPath:         filepath.Join(chartDir, "ankh-releases.yaml"),
+		CrdsDir:                  filepath.Join(chartDir, "crds"),
 	}
 
 	return files, nil
 }
 
+// The repositories to search for a chart, in order. A chart's own
+// `helmRepository`, by name or URL, is the only one searched. Otherwise, the
+// given repository is searched first, followed by `helm.repositories`.
+func chartRepositories(ctx *ankh.ExecutionContext, repository string, chart ankh.Chart) []string {
+	if chart.HelmRepository != "" {
+		if config, ok := ctx.LookupHelmRepository(chart.HelmRepository); ok {
+			return []string{config.URL}
+		}
+		return []string{chart.HelmRepository}
+	}
+
+	repositories := []string{}
+	if repository != "" {
+		repositories = append(repositories, repository)
+	}
+	for _, config := range ctx.HelmRepositories() {
+		if strings.TrimRight(config.URL, "/") != strings.TrimRight(repository, "/") {
+			repositories = append(repositories, config.URL)
+		}
+	}
+	return repositories
+}
+
+// Downloads a chart from a repository and extracts it into `dir`.
+func fetchChart(ctx *ankh.ExecutionContext, repository string, chart ankh.Chart, dir string) error {
+	if IsOCIRepository(repository) {
+		if err := pullOCIChart(ctx, repository, chart, dir); err != nil {
+			return fmt.Errorf("failed to fetch helm chart '%v' at version '%v' from %v: %v", chart.Name, chart.Version, repository, err)
+		}
+		if ctx.AnkhConfig.Helm.Verify {
+			return unverifiedChart(ctx, chart, fmt.Errorf("charts from OCI registries have no provenance file to verify"))
+		}
+		return nil
+	}
+
+	tarballFileName := fmt.Sprintf("%s-%s.tgz", chart.Name, chart.Version)
+	tarballURL := fmt.Sprintf("%s/%s", strings.TrimRight(repository, "/"), tarballFileName)
+
+	for attempt := 1; attempt <= 5; attempt++ {
+		ctx.Logger.Debugf("downloading chart from %s (attempt %v)", tarballURL, attempt)
+		tr := &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+		client := &http.Client{
+			Transport: ctx.Tracer.Transport(tr),
+			Timeout:   time.Duration(5 * time.Second),
+		}
+		req, err := newRepositoryRequest(ctx, "GET", tarballURL, repository)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			ctx.Logger.Warningf("got an error %v when trying to call %v (attempt %v)",
+				err, tarballURL, attempt)
+			continue
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == 200 && ctx.AnkhConfig.Helm.Verify {
+			return untarVerifiedChart(ctx, client, repository, chart, tarballURL, resp.Body, dir)
+		}
+		if resp.StatusCode == 200 {
+			ctx.Logger.Debugf("untarring chart to %s", dir)
+			return util.Untar(dir, resp.Body)
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			// The chart is not in this repository, so there is no use retrying.
+			return fmt.Errorf("chart not found at URL: %v", tarballURL)
+		}
+		ctx.Logger.Warningf("Received HTTP status '%v' (code %v) when trying to call %s (attempt %v)", resp.Status, resp.StatusCode, tarballURL, attempt)
+	}
+	return fmt.Errorf("failed to fetch helm chart from URL: %v", tarballURL)
+}
+
+// Fetches a chart from the first of `repositories` that has it.
+func fetchChartFromRepositories(ctx *ankh.ExecutionContext, repositories []string, chart ankh.Chart, dir string) error {
+	fetchErrors := []string{}
+	for _, candidate := range repositories {
+		err := fetchChart(ctx, candidate, chart, dir)
+		if err == nil {
+			return nil
+		}
+		if len(repositories) == 1 {
+			return err
+		}
+		ctx.Logger.Debugf("Could not fetch chart '%v' at version '%v' from %v: %v", chart.Name, chart.Version, candidate, err)
+		fetchErrors = append(fetchErrors, fmt.Sprintf("- %v: %v", candidate, err))
+	}
+	return fmt.Errorf("failed to fetch helm chart '%v' at version '%v' from any helm repository:\n%v",
+		chart.Name, chart.Version, strings.Join(fetchErrors, "\n"))
+}
+
 var findChartFiles = findChartFilesImpl
 var execContext = exec.Command
 
@@ -171,11 +244,9 @@ func FetchChartMeta(ctx *ankh.ExecutionContext, repository string, chart *ankh.C
 		if err != nil {
 			return meta, fmt.Errorf("unable to unmarshal yaml of ankh.yaml file for chart '%s': %v", chart.Name, err)
 		}
-
-		return meta, nil
-
 	}
 
+	meta.Library = isLibraryChart(files.ChartDir)
 	return meta, nil
 }
 
@@ -193,9 +264,10 @@ type HelmReducedEntry struct {
 }
 
 type HelmIndexEntry struct {
-	Name    string
-	Version string
-	Created string
+	Name       string
+	Version    string
+	Created    string
+	Deprecated bool
 }
 
 type HelmIndex struct {
@@ -203,9 +275,10 @@ type HelmIndex struct {
 	Entries    map[string][]HelmIndexEntry
 }
 
-func listCharts(ctx *ankh.ExecutionContext, repository string, numToShow int, descending bool) (map[string][]string, error) {
+func getIndex(ctx *ankh.ExecutionContext, repository string) (HelmIndex, error) {
+	index := HelmIndex{}
 	if repository == "" {
-		return nil, fmt.Errorf("No helm repository configured. Set `helm.repository` globally, or `See README.md on where to specify a helm repository.")
+		return index, fmt.Errorf("No helm repository configured. Set `helm.repository` globally, or `See README.md on where to specify a helm repository.")
 	}
 
 	indexURL := fmt.Sprintf("%s/index.yaml", strings.TrimRight(repository, "/"))
@@ -214,26 +287,49 @@ func listCharts(ctx *ankh.ExecutionContext, repository string, numToShow int, de
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
 	client := &http.Client{
-		Transport: tr,
+		Transport: ctx.Tracer.Transport(tr),
 		Timeout:   time.Duration(5 * time.Second),
 	}
-	resp, err := client.Get(indexURL)
+	req, err := newRepositoryRequest(ctx, "GET", indexURL, repository)
 	if err != nil {
-		return nil, fmt.Errorf("got an error %v when trying to call %v", err, indexURL)
+		return index, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return index, fmt.Errorf("got an error %v when trying to call %v", err, indexURL)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("Received HTTP status '%v' (code %v) when trying to call %s", resp.Status, resp.StatusCode, indexURL)
+		return index, fmt.Errorf("Received HTTP status '%v' (code %v) when trying to call %s", resp.Status, resp.StatusCode, indexURL)
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return index, err
 	}
 
-	index := HelmIndex{}
 	err = yaml.Unmarshal(body, &index)
+	return index, err
+}
+
+func listCharts(ctx *ankh.ExecutionContext, repository string, numToShow int, descending bool) (map[string][]string, error) {
+	if IsOCIRepository(repository) {
+		// OCI registries have no creation dates to sort by, so sort by version.
+		charts, err := listOCICharts(ctx, repository)
+		if err != nil {
+			return nil, err
+		}
+		for name, versions := range charts {
+			sortVersions(versions, descending)
+			if numToShow > 0 && len(versions) > numToShow {
+				charts[name] = versions[:numToShow]
+			}
+		}
+		return charts, nil
+	}
+
+	index, err := getIndex(ctx, repository)
 	if err != nil {
 		return nil, err
 	}
@@ -260,6 +356,14 @@ func listCharts(ctx *ankh.ExecutionContext, repository string, numToShow int, de
 	return reduced, nil
 }
 
+// A ChartListing is a chart and its most recent versions, for `--output`.
+type ChartListing struct {
+	Name string `json:"name" yaml:"name"`
+	// The name of the repository in `helm.repositories`, when listing them all
+	Repository string   `json:"repository,omitempty" yaml:"repository,omitempty"`
+	Versions   []string `json:"versions" yaml:"versions"`
+}
+
 func ListCharts(ctx *ankh.ExecutionContext, repository string, numToShow int) (string, error) {
 	reduced, err := listCharts(ctx, repository, numToShow, true)
 	if err != nil {
@@ -273,15 +377,63 @@ func ListCharts(ctx *ankh.ExecutionContext, repository string, numToShow int) (s
 	}
 	sort.Strings(reducedKeys)
 
+	if ctx.Output != "" {
+		listings := []ChartListing{}
+		for _, k := range reducedKeys {
+			listings = append(listings, ChartListing{Name: k, Versions: reduced[k]})
+		}
+		return util.FormatOutput(ctx.Output, listings)
+	}
+
+	colors := util.GetListingColors(ctx)
 	formatted := bytes.NewBufferString("")
 	w := tabwriter.NewWriter(formatted, 0, 8, 8, ' ', 0)
 	fmt.Fprintf(w, "NAME\tVERSION(S)\n")
 	for _, k := range reducedKeys {
 		v := reduced[k]
-		fmt.Fprintf(w, "%v\t%v\n", k, strings.Join(v, ", "))
+		fmt.Fprintf(w, "%v\t%v\n", k, colors.HighlightNewest(v))
+	}
+	w.Flush()
+	return colors.HighlightHeader(formatted.String()), nil
+}
+
+// ListAllCharts lists charts across every repository in `helm.repositories`.
+// Charts found in more than one repository are listed once for each, in the
+// order that the repositories are searched.
+func ListAllCharts(ctx *ankh.ExecutionContext, numToShow int) (string, error) {
+	repositories := ctx.HelmRepositories()
+	if len(repositories) == 0 {
+		return "", fmt.Errorf("No helm repositories configured. Set `helm.repositories`, or see README.md")
+	}
+
+	listings := []ChartListing{}
+	for _, repository := range repositories {
+		reduced, err := listCharts(ctx, repository.URL, numToShow, true)
+		if err != nil {
+			ctx.Logger.Warnf("Unable to list charts in helm repository '%v': %v", repository.Name, err)
+			continue
+		}
+		for k, v := range reduced {
+			listings = append(listings, ChartListing{Name: k, Repository: repository.Name, Versions: v})
+		}
+	}
+	sort.SliceStable(listings, func(i, j int) bool {
+		return listings[i].Name < listings[j].Name
+	})
+
+	if ctx.Output != "" {
+		return util.FormatOutput(ctx.Output, listings)
+	}
+
+	colors := util.GetListingColors(ctx)
+	formatted := bytes.NewBufferString("")
+	w := tabwriter.NewWriter(formatted, 0, 8, 8, ' ', 0)
+	fmt.Fprintf(w, "NAME\tREPOSITORY\tVERSION(S)\n")
+	for _, listing := range listings {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", listing.Name, listing.Repository, colors.HighlightNewest(listing.Versions))
 	}
 	w.Flush()
-	return formatted.String(), nil
+	return colors.HighlightHeader(formatted.String()), nil
 }
 
 func GetChartNames(ctx *ankh.ExecutionContext, repository string) ([]string, error) {
@@ -300,23 +452,69 @@ func GetChartNames(ctx *ankh.ExecutionContext, repository string) ([]string, err
 	return reducedKeys, nil
 }
 
-func ListVersions(ctx *ankh.ExecutionContext, repository string, chart string, descending bool) (string, error) {
-	reduced, err := listCharts(ctx, repository, 0, descending)
-	if err != nil {
-		return "", err
+func listVersions(ctx *ankh.ExecutionContext, repository string, chart string, descending bool) ([]string, error) {
+	versions := []string{}
+	if IsOCIRepository(repository) {
+		// Versions of a single chart are available even without a catalog.
+		ociVersions, err := listOCIVersions(ctx, repository, chart)
+		if err != nil {
+			return versions, err
+		}
+		sortVersions(ociVersions, descending)
+		versions = ociVersions
+	} else {
+		reduced, err := listCharts(ctx, repository, 0, descending)
+		if err != nil {
+			return versions, err
+		}
+		versions = reduced[chart]
 	}
 
-	// Show charts in alphabetical order
-	versions, ok := reduced[chart]
-	if !ok || len(versions) == 0 {
-		return "", fmt.Errorf("Could not find chart '%v' in repository '%v'. "+
+	if len(versions) == 0 {
+		return versions, fmt.Errorf("Could not find chart '%v' in repository '%v'. "+
 			"Try `ankh chart ls` to see all charts and their versions.",
 			chart, repository)
 	}
+	return versions, nil
+}
 
+func ListVersions(ctx *ankh.ExecutionContext, repository string, chart string, descending bool) (string, error) {
+	versions, err := listVersions(ctx, repository, chart, descending)
+	if err != nil {
+		return "", err
+	}
 	return strings.Join(versions, "\n"), nil
 }
 
+// A ChartVersion is a version of a chart, and whether it is deprecated, for `--output`.
+type ChartVersion struct {
+	Version     string `json:"version" yaml:"version"`
+	Deprecated  bool   `json:"deprecated" yaml:"deprecated"`
+	Deprecation string `json:"deprecation,omitempty" yaml:"deprecation,omitempty"`
+}
+
+// ListVersionsOutput lists the versions of a chart in the format of `--output`.
+func ListVersionsOutput(ctx *ankh.ExecutionContext, repository string, chart string, descending bool) (string, error) {
+	versions, err := listVersions(ctx, repository, chart, descending)
+	if err != nil {
+		return "", err
+	}
+
+	deprecations, err := GetDeprecations(ctx, repository)
+	if err != nil {
+		ctx.Logger.Warnf("Unable to check for deprecated versions of chart \"%v\": %v", chart, err)
+	}
+	listings := []ChartVersion{}
+	for _, version := range versions {
+		listing := ChartVersion{Version: version}
+		if message, ok := deprecations.Message(chart, version); ok {
+			listing.Deprecated, listing.Deprecation = true, message
+		}
+		listings = append(listings, listing)
+	}
+	return util.FormatOutput(ctx.Output, listings)
+}
+
 type ChartYaml struct {
 	Name    string
 	Version string
@@ -396,7 +594,86 @@ func writeChartYaml(ctx *ankh.ExecutionContext, chartYaml map[string]interface{}
 	return nil
 }
 
-func Publish(ctx *ankh.ExecutionContext, repository string, versionOverride string) error {
+// Creates a request that reads from a helm repository, with credentials if the
+// repository is in `helm.repositories` and has an `authType`.
+func newRepositoryRequest(ctx *ankh.ExecutionContext, method string, url string, repository string) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if config, ok := ctx.LookupHelmRepository(repository); ok && config.AuthType != "" {
+		if err := setRepositoryAuth(ctx, req, repository); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// Sets credentials on a request to a helm repository, based on the `authType` of
+// the repository in `helm.repositories`, or otherwise `helm.authType`.
+func setRepositoryAuth(ctx *ankh.ExecutionContext, req *http.Request, repository string) error {
+	var err error
+	authType := ctx.AnkhConfig.Helm.AuthType
+	username := ""
+	usernameSource := "environment ANKH_HELM_REPOSITORY_USERNAME"
+	passwordEnv := "ANKH_HELM_REPOSITORY_PASSWORD"
+	if config, ok := ctx.LookupHelmRepository(repository); ok {
+		if config.AuthType != "" {
+			authType = config.AuthType
+		}
+		if config.Username != "" {
+			username = config.Username
+			usernameSource = fmt.Sprintf("`username` of helm repository '%v'", config.Name)
+		}
+		if config.PasswordEnv != "" {
+			passwordEnv = config.PasswordEnv
+		}
+	}
+
+	switch strings.ToLower(authType) {
+	case "basic":
+		// Get basic auth credentials
+		if username == "" {
+			username = os.Getenv("ANKH_HELM_REPOSITORY_USERNAME")
+		}
+		if username == "" {
+			if ctx.NoPrompt {
+				return fmt.Errorf("Must define ANKH_HELM_REPOSITORY_USERNAME for \"basic\" auth if run with `--no-prompt`")
+			}
+			username, err = util.PromptForUsernameWithLabel("Username: ")
+			if err != nil {
+				return fmt.Errorf("Failed to read credentials from stdin: %v", err)
+			}
+		} else {
+			ctx.Logger.Infof("Using %v=%v for 'basic' auth on helm repository '%v",
+				usernameSource, username, repository)
+		}
+
+		password := os.Getenv(passwordEnv)
+		if password == "" {
+			if ctx.NoPrompt {
+				return fmt.Errorf("Must define %v for \"basic\" if run with `--no-prompt`", passwordEnv)
+			}
+			password, err = util.PromptForPasswordWithLabel("Password: ")
+			if err != nil {
+				return fmt.Errorf("Failed to read credentials from stdin: %v", err)
+			}
+		} else {
+			ctx.Logger.Infof("Using environment %v=<redacted> for 'basic' auth on helm repository '%v",
+				passwordEnv, repository)
+		}
+
+		req.SetBasicAuth(username, password)
+	default:
+		if authType != "" {
+			return fmt.Errorf("Helm repository auth type '%v' is not supported - only 'basic' auth is supported.", authType)
+		}
+	}
+
+	return nil
+}
+
+func Publish(ctx *ankh.ExecutionContext, repository string, versionOverride string, dryRun bool) error {
 	_, chartYaml, err := readChartYaml(ctx, "Chart.yaml", true)
 	if err != nil {
 		return err
@@ -425,11 +702,34 @@ func Publish(ctx *ankh.ExecutionContext, repository string, versionOverride stri
 	removeTarball()
 	defer removeTarball()
 
+	// Record how the chart was built in its annotations. Package a copy of the
+	// chart, so that the Chart.yaml in the working directory is left untouched.
+	chartDir := wd
+	annotations := buildMetadataAnnotations(wd)
+	if len(annotations) > 0 {
+		tmpDir, err := ioutil.TempDir("", "ankh-publish")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpDir)
+
+		chartDir = filepath.Join(tmpDir, chartName)
+		if err := util.CopyDir(wd, chartDir); err != nil {
+			return fmt.Errorf("Unable to copy chart to '%v': %v", chartDir, err)
+		}
+		if err := injectAnnotations(filepath.Join(chartDir, "Chart.yaml"), annotations); err != nil {
+			return err
+		}
+		for key, value := range annotations {
+			ctx.Logger.Infof("Annotating chart with %v=%v", key, value)
+		}
+	}
+
 	helmArgs := []string{ctx.AnkhConfig.Helm.Command, "package"}
 	if versionOverride != "" {
 		helmArgs = append(helmArgs, []string{"--version", versionOverride}...)
 	}
-	helmArgs = append(helmArgs, wd)
+	helmArgs = append(helmArgs, chartDir)
 	helmCmd := execContext(helmArgs[0], helmArgs[1:]...)
 
 	var stderr bytes.Buffer
@@ -464,50 +764,36 @@ func Publish(ctx *ankh.ExecutionContext, repository string, versionOverride stri
 	}
 
 	upstreamTarballPath := fmt.Sprintf("%v/%v-%v.tgz", repository, chartName, chartVersion)
+	if IsOCIRepository(repository) {
+		upstreamTarballPath = fmt.Sprintf("%v/%v:%v", strings.TrimRight(repository, "/"), chartName,
+			strings.Replace(chartVersion, "+", "_", -1))
+	}
+	if dryRun {
+		digest := sha256.Sum256(body)
+		fmt.Printf("URL:     %v\nSize:    %v bytes\nDigest:  sha256:%v\n",
+			upstreamTarballPath, len(body), hex.EncodeToString(digest[:]))
+		ctx.Logger.Infof("Not publishing '%v' (dry run)", upstreamTarballPath)
+		return nil
+	}
 	ctx.Logger.Infof("Publishing '%v'", upstreamTarballPath)
 
+	if IsOCIRepository(repository) {
+		// Registries authenticate with the docker credentials, rather than `helm.authType`.
+		if err := pushOCIChart(ctx, repository, localTarballPath); err != nil {
+			return err
+		}
+		ctx.Logger.Infof("Finished publishing '%v'", upstreamTarballPath)
+		return nil
+	}
+
 	// Create a request with the chart on the PUT body
 	req, err := http.NewRequest("PUT", upstreamTarballPath, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
 
-	switch strings.ToLower(ctx.AnkhConfig.Helm.AuthType) {
-	case "basic":
-		// Get basic auth credentials
-		username := os.Getenv("ANKH_HELM_REPOSITORY_USERNAME")
-		if username == "" {
-			if ctx.NoPrompt {
-				return fmt.Errorf("Must define ANKH_HELM_REPOSITORY_USERNAME for \"basic\" auth if run with `--no-prompt`")
-			}
-			username, err = util.PromptForUsernameWithLabel("Username: ")
-			if err != nil {
-				return fmt.Errorf("Failed to read credentials from stdin: %v", err)
-			}
-		} else {
-			ctx.Logger.Infof("Using environment ANKH_HELM_REPOSITORY_USERNAME=%v for 'basic' auth on helm repository '%v",
-				username, repository)
-		}
-
-		password := os.Getenv("ANKH_HELM_REPOSITORY_PASSWORD")
-		if password == "" {
-			if ctx.NoPrompt {
-				return fmt.Errorf("Must define ANKH_HELM_REPOSITORY_PASSWORD for \"basic\" if run with `--no-prompt`")
-			}
-			password, err = util.PromptForPasswordWithLabel("Password: ")
-			if err != nil {
-				return fmt.Errorf("Failed to read credentials from stdin: %v", err)
-			}
-		} else {
-			ctx.Logger.Infof("Using environment ANKH_HELM_REPOSITORY_PASSWORD=<redacted> for 'basic' auth on helm repository '%v",
-				repository)
-		}
-
-		req.SetBasicAuth(username, password)
-	default:
-		if ctx.AnkhConfig.Helm.AuthType != "" {
-			ctx.Logger.Fatalf("Helm repository auth type '%v' is not supported - only 'basic' auth is supported.", ctx.AnkhConfig.Helm.AuthType)
-		}
+	if err := setRepositoryAuth(ctx, req, repository); err != nil {
+		return err
 	}
 
 	client := &http.Client{
@@ -565,12 +851,11 @@ func inspectDirectory(relativeDir string, dir string) (string, error) {
 	return result, nil
 }
 
-func Inspect(ctx *ankh.ExecutionContext, repository string, singleChart string) (string, error) {
-	var result string
-
+// Splits a `CHART[@VERSION]` argument, prompting for a version if there isn't one.
+func resolveChartVersion(ctx *ankh.ExecutionContext, repository string, singleChart string) (string, string, error) {
 	tokens := strings.Split(singleChart, "@")
 	if len(tokens) < 1 || len(tokens) > 2 {
-		ctx.Logger.Fatalf("Invalid chart '%v'.  Chart must be specified as `CHART[@VERSION]`.",
+		return "", "", fmt.Errorf("Invalid chart '%v'.  Chart must be specified as `CHART[@VERSION]`.",
 			singleChart)
 	}
 
@@ -581,20 +866,32 @@ func Inspect(ctx *ankh.ExecutionContext, repository string, singleChart string)
 	} else {
 		versions, err := ListVersions(ctx, repository, chartName, true)
 		if err != nil {
-			return "", err
+			return "", "", err
 		}
 
 		ctx.Logger.Infof("Found chart \"%v\" without a version", chartName)
-		selectedVersion, err := util.PromptForSelection(strings.Split(strings.Trim(versions, "\n "), "\n"),
+		versionsList := FlagDeprecatedVersions(ctx, repository, chartName, strings.Split(strings.Trim(versions, "\n "), "\n"))
+		selectedVersion, err := util.PromptForSelection(versionsList,
 			fmt.Sprintf("Select a version for chart '%v'", chartName), false)
 		if err != nil {
-			return "", err
+			return "", "", err
 		}
 
-		chartVersion = selectedVersion
+		chartVersion = strings.Fields(selectedVersion)[0]
 		ctx.Logger.Infof("Using %v@%v based on selection", chartName, chartVersion)
 	}
 
+	return chartName, chartVersion, nil
+}
+
+func Inspect(ctx *ankh.ExecutionContext, repository string, singleChart string) (string, error) {
+	var result string
+
+	chartName, chartVersion, err := resolveChartVersion(ctx, repository, singleChart)
+	if err != nil {
+		return "", err
+	}
+
 	ctx.Logger.Infof("Inspecting chart \"%s\" at version \"%v\" from repository \"%v\"",
 		chartName, chartVersion, repository)
 
@@ -641,38 +938,69 @@ func Bump(ctx *ankh.ExecutionContext, semVerType string) error {
 	return nil
 }
 
-func filterOutput(filters []string, helmOutput string) string {
+// The fields of a rendered object that filters match on.
+type filteredObject struct {
+	Kind     string
+	Metadata struct {
+		Name   string
+		Labels map[string]string
+	}
+}
+
+// Keeps the objects in helm's output that match every filter: one of the kinds
+// in `--filter`, the regular expression in `--filter-name`, and the labels in
+// `--filter-label`, when given.
+func filterOutput(ctx *ankh.ExecutionContext, helmOutput string) (string, error) {
+	var namePattern *regexp.Regexp
+	if ctx.FilterName != "" {
+		var err error
+		namePattern, err = regexp.Compile(ctx.FilterName)
+		if err != nil {
+			return "", fmt.Errorf("Invalid name filter \"%v\": %v", ctx.FilterName, err)
+		}
+	}
 
 	// The golang yaml library doesn't actually support whitespace/comment
-	// preserving round-trip parsing. So, we're going to filter the "hard way".
+	// preserving round-trip parsing. So, we're going to split the "hard way",
+	// and only parse each object to match it.
 	filtered := []string{}
 	objs := strings.Split(helmOutput, "\n---")
 	for _, obj := range objs {
-		lines := strings.Split(obj, "\n")
-		for _, line := range lines {
-			if !strings.HasPrefix(line, "kind:") {
-				continue
-			}
+		body := strings.TrimPrefix(strings.TrimLeft(obj, "\n"), "---")
+		parsed := filteredObject{}
+		if err := yaml.Unmarshal([]byte(body), &parsed); err != nil {
+			return "", fmt.Errorf("Unable to parse a rendered object to filter it: %v", err)
+		}
+		if parsed.Kind == "" {
+			continue
+		}
+
+		if len(ctx.Filters) > 0 {
 			matched := false
-			for _, s := range filters {
-				kind := strings.Trim(line[5:], " ")
-				if strings.EqualFold(kind, s) {
+			for _, s := range ctx.Filters {
+				if strings.EqualFold(strings.TrimSpace(parsed.Kind), s) {
 					matched = true
 					break
 				}
 			}
-			if matched {
-				filtered = append(filtered, obj)
-				break
+			if !matched {
+				continue
 			}
 		}
+		if namePattern != nil && !namePattern.MatchString(parsed.Metadata.Name) {
+			continue
+		}
+		if ctx.FilterLabels != nil && !ctx.FilterLabels.Matches(parsed.Metadata.Labels) {
+			continue
+		}
+		filtered = append(filtered, obj)
 	}
 
 	output := ""
 	for _, s := range filtered {
 		output += fmt.Sprintf("---\n%v\n", strings.Trim(s, "\n"))
 	}
-	return output
+	return output, nil
 }
 
 // CreateChart via helm create that is ankh compatible
@@ -684,7 +1012,10 @@ func CreateChart(ctx *ankh.ExecutionContext, chartPath string, appName string, t
 	appName = util.GenerateName(ctx, appName)
 	chartDir := fmt.Sprintf("%v/%v", chartRoot, appName)
 	helmArgs := []string{}
-	repository := ctx.DetermineHelmRepository(&repositoryArg)
+	repository, err := ctx.DetermineHelmRepository(&repositoryArg)
+	if err != nil {
+		return err
+	}
 
 	// Evaluate params passed in
 	if chartPath != "" {
@@ -735,19 +1066,20 @@ func CreateChart(ctx *ankh.ExecutionContext, chartPath string, appName string, t
 	if _, err := os.Stat(chartStarterPath); os.IsNotExist(err) {
 		tokens := strings.Split(ctx.Chart, "@")
 		if len(tokens) > 2 {
-			ctx.Logger.Fatalf("Invalid chart '%v'. Too many `@` characters found. Chart must either be a name with no `@`, or in the combined `name@version` format", ctx.Chart)
+			return fmt.Errorf("Invalid chart '%v'. Too many `@` characters found. Chart must either be a name with no `@`, or in the combined `name@version` format", ctx.Chart)
 		}
 		if len(tokens) == 1 {
 			versions, err := ListVersions(ctx, repository, ctx.Chart, true)
 			if err != nil {
 				return err
 			}
-			selectedVersion, err := util.PromptForSelection(strings.Split(strings.Trim(versions, "\n "), "\n"),
+			versionsList := FlagDeprecatedVersions(ctx, repository, ctx.Chart, strings.Split(strings.Trim(versions, "\n "), "\n"))
+			selectedVersion, err := util.PromptForSelection(versionsList,
 				fmt.Sprintf("Select a version for chart \"%v\"", ctx.Chart), false)
 			if err != nil {
 				return err
 			}
-			ctx.Chart = fmt.Sprintf("%v@%v", ctx.Chart, selectedVersion)
+			ctx.Chart = fmt.Sprintf("%v@%v", ctx.Chart, strings.Fields(selectedVersion)[0])
 		}
 
 		// Check existence again with version number
@@ -0,0 +1,152 @@
+package helm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+func TestFindChartFilesSearchesRepositories(t *testing.T) {
+	stable := ankhtest.NewChartRepository(t, ankhtest.Chart{Name: "foo", Version: "1.0.0"})
+	internal := ankhtest.NewChartRepository(t, ankhtest.Chart{Name: "bar", Version: "2.0.0"})
+
+	ctx := ankhtest.NewContext(t)
+	ctx.AnkhConfig.Helm.Repositories = []ankh.HelmRepositoryConfig{
+		{Name: "internal", URL: internal.URL},
+		{Name: "stable", URL: stable.URL, Priority: 10},
+	}
+
+	files, err := findChartFilesImpl(ctx, "", ankh.Chart{Name: "bar", Version: "2.0.0"})
+	if err != nil {
+		t.Fatalf("expected to find chart bar in a lower priority repository, but got %v", err)
+	}
+	defer os.RemoveAll(files.TmpDir)
+	if _, err := os.Stat(filepath.Join(files.ChartDir, "Chart.yaml")); err != nil {
+		t.Logf("expected chart bar to be extracted: %v", err)
+		t.Fail()
+	}
+
+	// A chart's own repository is the only one searched.
+	_, err = findChartFilesImpl(ctx, "", ankh.Chart{Name: "bar", Version: "2.0.0", HelmRepository: "stable"})
+	if err == nil || !strings.Contains(err.Error(), stable.URL) {
+		t.Logf("expected chart bar not to be found in repository stable, but got %v", err)
+		t.Fail()
+	}
+
+	_, err = findChartFilesImpl(ctx, "", ankh.Chart{Name: "baz", Version: "1.0.0"})
+	if err == nil || !strings.Contains(err.Error(), stable.URL) || !strings.Contains(err.Error(), internal.URL) {
+		t.Logf("expected an error naming every repository searched, but got %v", err)
+		t.Fail()
+	}
+}
+
+func TestListAllCharts(t *testing.T) {
+	stable := ankhtest.NewChartRepository(t, ankhtest.Chart{Name: "foo", Version: "1.0.0"}, ankhtest.Chart{Name: "bar", Version: "1.0.0"})
+	internal := ankhtest.NewChartRepository(t, ankhtest.Chart{Name: "bar", Version: "2.0.0"})
+
+	ctx := ankhtest.NewContext(t)
+	ctx.Output = "json"
+	ctx.AnkhConfig.Helm.Repositories = []ankh.HelmRepositoryConfig{
+		{Name: "stable", URL: stable.URL},
+		{Name: "internal", URL: internal.URL, Priority: 10},
+	}
+
+	out, err := ListAllCharts(ctx, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{`"name": "bar",
+    "repository": "internal"`, `"name": "bar",
+    "repository": "stable"`, `"name": "foo",
+    "repository": "stable"`}
+	last := -1
+	for _, e := range expected {
+		index := strings.Index(out, e)
+		if index <= last {
+			t.Logf("expected %q after the previous listing in:\n%v", e, out)
+			t.Fail()
+		}
+		last = index
+	}
+}
+
+func TestFilterOutput(t *testing.T) {
+	helmOutput := `---
+# Source: api/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  labels:
+    component: web
+---
+# Source: api/templates/worker.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api-worker
+  labels:
+    component: worker
+---
+# Source: api/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: api
+  labels:
+    component: web
+`
+	ctx := ankhtest.NewContext(t)
+	filter := func(kinds []string, name string, labels string) []string {
+		ctx.Filters = kinds
+		ctx.FilterName = name
+		ctx.FilterLabels = nil
+		if labels != "" {
+			selector, err := ankh.ParseLabelSelector(labels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx.FilterLabels = selector
+		}
+		out, err := filterOutput(ctx, helmOutput)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources := []string{}
+		for _, line := range strings.Split(out, "\n") {
+			if strings.HasPrefix(line, "# Source: ") {
+				sources = append(sources, filepath.Base(strings.TrimPrefix(line, "# Source: ")))
+			}
+		}
+		return sources
+	}
+
+	for _, test := range []struct {
+		kinds    []string
+		name     string
+		labels   string
+		expected string
+	}{
+		{kinds: []string{"deployment"}, expected: "deployment.yaml,worker.yaml"},
+		{name: "^api$", expected: "deployment.yaml,service.yaml"},
+		{labels: "component=worker", expected: "worker.yaml"},
+		{kinds: []string{"Deployment"}, labels: "component!=worker", expected: "deployment.yaml"},
+		{kinds: []string{"Service"}, name: "worker", expected: ""},
+	} {
+		if sources := strings.Join(filter(test.kinds, test.name, test.labels), ","); sources != test.expected {
+			t.Logf("expected %q for kinds %v, name %q and labels %q but got %q", test.expected, test.kinds, test.name, test.labels, sources)
+			t.Fail()
+		}
+	}
+
+	ctx.FilterName = "api("
+	ctx.FilterLabels = nil
+	if _, err := filterOutput(ctx, helmOutput); err == nil {
+		t.Logf("expected an error for an invalid name filter")
+		t.Fail()
+	}
+}
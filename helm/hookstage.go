@@ -0,0 +1,136 @@
+package helm
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+)
+
+// The points in a plan at which chart hooks run.
+const (
+	HookPreApply     = "preApply"
+	HookPostApply    = "postApply"
+	HookPostRollback = "postRollback"
+)
+
+// HookStage runs the hooks of each chart for one point in a plan, in the
+// order of the charts. Its input is passed on as its output.
+type HookStage struct {
+	charts []ankh.Chart
+	phase  string
+}
+
+func NewHookStage(charts []ankh.Chart, phase string) plan.Stage {
+	return HookStage{charts: charts, phase: phase}
+}
+
+// StageName names the stage after its phase, eg: `helm.HookStage (preApply)`.
+func (stage HookStage) StageName() string {
+	return fmt.Sprintf("helm.HookStage (%v)", stage.phase)
+}
+
+func chartHooks(chart ankh.Chart, phase string) []string {
+	switch phase {
+	case HookPreApply:
+		return chart.ChartMeta.Hooks.PreApply
+	case HookPostApply:
+		return chart.ChartMeta.Hooks.PostApply
+	case HookPostRollback:
+		return chart.ChartMeta.Hooks.PostRollback
+	}
+	return nil
+}
+
+// HasHooks reports whether any of the charts has hooks for a phase.
+func HasHooks(charts []ankh.Chart, phase string) bool {
+	for _, chart := range charts {
+		if len(chartHooks(chart, phase)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// The environment of a hook, on top of Ankh's own.
+func hookVars(ctx *ankh.ExecutionContext, chart ankh.Chart, namespace string, phase string) map[string]string {
+	vars := valueSourceVars(ctx, chart, namespace)
+	vars["ANKH_CHART_INSTANCE"] = chart.InstanceName()
+	vars["ANKH_CHART_VERSION"] = chart.Version
+	vars["ANKH_KUBE_CONTEXT"] = ctx.AnkhConfig.CurrentContext.KubeContext
+	vars["ANKH_HOOK"] = phase
+	vars["ANKH_TAG"] = ""
+	if chart.Tag != nil {
+		vars["ANKH_TAG"] = *chart.Tag
+	}
+	return vars
+}
+
+func explainHook(hook string, vars map[string]string) string {
+	names := []string{}
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	env := []string{}
+	for _, name := range names {
+		env = append(env, fmt.Sprintf("%v=%v", name, shellQuote(vars[name])))
+	}
+	// Hooks write to stderr, so that their output is not mistaken for objects to apply.
+	return fmt.Sprintf("env %v sh -c %v >&2", strings.Join(env, " "), shellQuote(hook))
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func runHook(ctx *ankh.ExecutionContext, hook string, vars map[string]string) error {
+	cmd := exec.Command("sh", "-c", hook)
+	cmd.Dir = ctx.WorkingPath
+	cmd.Env = os.Environ()
+	for name, value := range vars {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	span := ctx.Tracer.Start("hook", map[string]string{"hook": vars["ANKH_HOOK"], "chart": vars["ANKH_CHART_INSTANCE"]})
+	err := cmd.Run()
+	span.End(err)
+	return err
+}
+
+func (stage HookStage) Execute(ctx *ankh.ExecutionContext, input *string, namespace string, wildCardLabels []string) (string, error) {
+	explained := []string{}
+	for _, chart := range stage.charts {
+		for _, hook := range chartHooks(chart, stage.phase) {
+			vars := hookVars(ctx, chart, namespace, stage.phase)
+			switch {
+			case ctx.Mode == ankh.Explain:
+				explained = append(explained, explainHook(hook, vars))
+			case ctx.DryRun:
+				ctx.Logger.Infof("Not running %v hook `%v` of chart \"%v\" on a dry run", stage.phase, hook, chart.InstanceName())
+			default:
+				ctx.Logger.Infof("Running %v hook `%v` of chart \"%v\"", stage.phase, hook, chart.InstanceName())
+				if err := runHook(ctx, hook, vars); err != nil {
+					return "", fmt.Errorf("The %v hook `%v` of chart \"%v\" failed: %v", stage.phase, hook, chart.InstanceName(), err)
+				}
+			}
+		}
+	}
+
+	if input == nil {
+		return "", nil
+	}
+	if len(explained) == 0 {
+		return *input, nil
+	}
+	if stage.phase == HookPreApply {
+		return strings.Join(explained, " && \\\n") + " && \\\n" + *input, nil
+	}
+	return *input + " && \\\n" + strings.Join(explained, " && \\\n"), nil
+}
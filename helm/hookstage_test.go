@@ -0,0 +1,75 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+func TestHookStage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := ankhtest.NewContext(t)
+	ctx.WorkingPath = dir
+	tag := "1.2.3"
+	chart := ankh.Chart{Name: "foo", Alias: "foo-canary", Tag: &tag}
+	chart.ChartMeta.Hooks.PreApply = []string{`echo "$ANKH_HOOK $ANKH_CHART_INSTANCE $ANKH_TAG $ANKH_NAMESPACE" >> hooks.log`}
+	charts := []ankh.Chart{chart, ankh.Chart{Name: "bar"}}
+
+	if HasHooks(charts, HookPostApply) || !HasHooks(charts, HookPreApply) {
+		t.Fatalf("expected only preApply hooks")
+	}
+
+	input := "kind: Deployment\n"
+	out, err := NewHookStage(charts, HookPreApply).Execute(ctx, &input, "web", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != input {
+		t.Logf("expected the input to be passed on but got %q", out)
+		t.Fail()
+	}
+	log, err := ioutil.ReadFile(filepath.Join(dir, "hooks.log"))
+	if err != nil || string(log) != "preApply foo-canary 1.2.3 web\n" {
+		t.Logf("expected the hook to run with its variables, but got %q (err %v)", log, err)
+		t.Fail()
+	}
+
+	// Hooks do not run on a dry run.
+	ctx.DryRun = true
+	if _, err := NewHookStage(charts, HookPreApply).Execute(ctx, &input, "web", nil); err != nil {
+		t.Fatal(err)
+	}
+	if log, _ := ioutil.ReadFile(filepath.Join(dir, "hooks.log")); strings.Count(string(log), "\n") != 1 {
+		t.Logf("expected the hook not to run on a dry run, but got %q", log)
+		t.Fail()
+	}
+
+	ctx.DryRun = false
+	ctx.Mode = ankh.Explain
+	input = "helm template foo"
+	out, err = NewHookStage(charts, HookPreApply).Execute(ctx, &input, "web", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "env ANKH_CHART='foo' ") || !strings.HasSuffix(out, ">> hooks.log' >&2 && \\\nhelm template foo") {
+		t.Logf("expected the hook to be explained before the input, but got %q", out)
+		t.Fail()
+	}
+
+	ctx.Mode = ankh.Apply
+	chart.ChartMeta.Hooks.PostApply = []string{"exit 3"}
+	if _, err := NewHookStage([]ankh.Chart{chart}, HookPostApply).Execute(ctx, &input, "web", nil); err == nil || !strings.Contains(err.Error(), "The postApply hook `exit 3` of chart \"foo-canary\" failed") {
+		t.Logf("expected a failing hook to fail the stage, but got %v", err)
+		t.Fail()
+	}
+}
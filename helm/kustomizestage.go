@@ -0,0 +1,82 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+)
+
+// The file that rendered output is written to, next to the kustomization that
+// applies the overlay to it.
+const kustomizeInputFileName = "rendered.yaml"
+
+// KustomizeStage runs rendered output through the current context's
+// `kustomizeOverlay` with `kubectl kustomize`, eg: to add a nodeSelector that
+// only one cluster needs, before it is used by the stages after it.
+type KustomizeStage struct{}
+
+func NewKustomizeStage() plan.Stage {
+	return KustomizeStage{}
+}
+
+// HasKustomizeOverlay returns whether rendered output is run through a
+// kustomize overlay for the current context.
+func HasKustomizeOverlay(ctx *ankh.ExecutionContext) bool {
+	return ctx.AnkhConfig.CurrentContext.KustomizeOverlay != ""
+}
+
+// The overlay is a kustomize component, ie: `kind: Component`, so that it
+// patches whatever it is included in. Local overlays are made absolute, since
+// the kustomization is written elsewhere, while remote ones, eg:
+// `github.com/org/repo//overlays/gpu?ref=v1`, are passed to kustomize as is.
+func kustomizeOverlay(ctx *ankh.ExecutionContext) (string, error) {
+	overlay := ctx.AnkhConfig.CurrentContext.KustomizeOverlay
+	if _, err := os.Stat(overlay); err != nil {
+		return overlay, nil
+	}
+	return filepath.Abs(overlay)
+}
+
+func (stage KustomizeStage) Execute(ctx *ankh.ExecutionContext, input *string, namespace string, wildCardLabels []string) (string, error) {
+	overlay, err := kustomizeOverlay(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if ctx.Mode == ankh.Explain {
+		dir := filepath.Join(ctx.DataDir, "kustomize")
+		cmd := plan.NewCommand(ctx.AnkhConfig.Kubectl.Command)
+		cmd.AddArguments([]string{"kustomize", dir})
+		in := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(*input), "&& \\"))
+		return fmt.Sprintf("(%s) > %s && \\\n%s", in, filepath.Join(dir, kustomizeInputFileName), cmd.Explain()), nil
+	}
+
+	if err := os.MkdirAll(ctx.DataDir, 0755); err != nil {
+		return "", err
+	}
+	dir, err := ioutil.TempDir(ctx.DataDir, "kustomize-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, kustomizeInputFileName), []byte(*input), 0644); err != nil {
+		return "", err
+	}
+	if err := writeKustomization(dir, kustomization{Resources: []string{kustomizeInputFileName}, Components: []string{overlay}}); err != nil {
+		return "", fmt.Errorf("Unable to write a kustomization for overlay \"%v\": %v", overlay, err)
+	}
+
+	cmd := plan.NewCommand(ctx.AnkhConfig.Kubectl.Command)
+	cmd.AddArguments([]string{"kustomize", dir})
+	ctx.Logger.Debugf("Applying kustomize overlay \"%v\" of context \"%v\" with %v", overlay, ctx.AnkhConfig.CurrentContextName, cmd.Explain())
+	out, err := cmd.Run(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("Unable to apply kustomize overlay \"%v\" of context \"%v\": %v", overlay, ctx.AnkhConfig.CurrentContextName, err)
+	}
+	return finishRender(ctx, out, namespace)
+}
@@ -0,0 +1,67 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+)
+
+func TestKustomizeStage(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl", ankhtest.Rule{Args: "kustomize *", Stdout: "kind: Deployment\nmetadata:\n  name: foo\nspec:\n  nodeSelector:\n    gpu: \"true\"\n"})
+
+	overlay, err := ioutil.TempDir("", "ankh-overlay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(overlay)
+
+	ctx := ankhtest.NewContext(t)
+	if HasKustomizeOverlay(ctx) {
+		t.Fatalf("expected no kustomize overlay without `kustomizeOverlay`")
+	}
+	ctx.AnkhConfig.CurrentContext.KustomizeOverlay = overlay
+	input := "kind: Deployment\nmetadata:\n  name: foo\n"
+
+	out, err := NewKustomizeStage().Execute(ctx, &input, "web", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "gpu:") {
+		t.Logf("expected the output of kubectl kustomize but got %q", out)
+		t.Fail()
+	}
+
+	calls := tools.Calls("kubectl")
+	if len(calls) != 1 || len(calls[0].Args) != 2 || calls[0].Args[0] != "kustomize" {
+		t.Fatalf("expected a single call to kubectl kustomize but got %+v", calls)
+	}
+	dir := calls[0].Args[1]
+	if filepath.Dir(dir) != ctx.DataDir {
+		t.Logf("expected the kustomization to be written under the data dir but got %v", dir)
+		t.Fail()
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Logf("expected the kustomization to be removed afterwards, but got %v", err)
+		t.Fail()
+	}
+}
+
+func TestKustomizeOverlay(t *testing.T) {
+	ctx := ankhtest.NewContext(t)
+	ctx.AnkhConfig.CurrentContext.KustomizeOverlay = "github.com/example/overlays//gpu?ref=v1"
+	if overlay, err := kustomizeOverlay(ctx); err != nil || overlay != ctx.AnkhConfig.CurrentContext.KustomizeOverlay {
+		t.Logf("expected a remote overlay to be used as is but got %v and %v", overlay, err)
+		t.Fail()
+	}
+
+	ctx.AnkhConfig.CurrentContext.KustomizeOverlay = "."
+	if overlay, err := kustomizeOverlay(ctx); err != nil || !filepath.IsAbs(overlay) {
+		t.Logf("expected a local overlay to be made absolute but got %v and %v", overlay, err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,406 @@
+package helm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	lintError   = "error"
+	lintWarning = "warning"
+	lintOff     = "off"
+)
+
+// A rule of `ankh lint`, with the severity it has unless configured otherwise
+// under `lint.rules`.
+type lintRule struct {
+	Name     string
+	Severity string
+	Check    func(release string, documents []lintDocument) []lintFinding
+}
+
+var lintRules = []lintRule{
+	{Name: "release-suffix", Severity: lintError, Check: lintReleaseSuffix},
+	{Name: "release-labels", Severity: lintError, Check: lintReleaseLabels},
+	{Name: "pod-disruption-budget", Severity: lintWarning, Check: lintPodDisruptionBudgets},
+	{Name: "topology-spread", Severity: lintWarning, Check: lintTopologySpread},
+	{Name: "run-as-non-root", Severity: lintWarning, Check: lintRunAsNonRoot},
+}
+
+// Something a rule found wrong with an object.
+type lintFinding struct {
+	Rule     string
+	Severity string
+	Message  string
+	document *lintDocument
+}
+
+type lintSecurityContext struct {
+	RunAsNonRoot *bool  `yaml:"runAsNonRoot"`
+	RunAsUser    *int64 `yaml:"runAsUser"`
+}
+
+type lintContainer struct {
+	Name            string
+	SecurityContext *lintSecurityContext `yaml:"securityContext"`
+}
+
+type lintPodTemplate struct {
+	Metadata struct {
+		Labels map[string]string
+	}
+	Spec struct {
+		SecurityContext           *lintSecurityContext `yaml:"securityContext"`
+		Containers                []lintContainer
+		InitContainers            []lintContainer `yaml:"initContainers"`
+		TopologySpreadConstraints []interface{}   `yaml:"topologySpreadConstraints"`
+		Affinity                  struct {
+			PodAntiAffinity interface{} `yaml:"podAntiAffinity"`
+		}
+	}
+}
+
+// The fields of a templated object that the lint rules look at.
+type lintObject struct {
+	Kind     string
+	Metadata struct {
+		Name   string
+		Labels map[string]string
+	}
+	Spec struct {
+		Type     string
+		Replicas *int
+		// A Service's selector is a map of labels, and a workload's or a
+		// PodDisruptionBudget's has `matchLabels`.
+		Selector    map[string]interface{}
+		Template    lintPodTemplate
+		JobTemplate struct {
+			Spec struct {
+				Template lintPodTemplate
+			}
+		} `yaml:"jobTemplate"`
+		ScaleTargetRef struct {
+			Kind string
+			Name string
+		} `yaml:"scaleTargetRef"`
+		MaxReplicas int `yaml:"maxReplicas"`
+	}
+}
+
+// A templated object, with the chart that it came from, if known.
+type lintDocument struct {
+	Chart  string
+	Object lintObject
+}
+
+func (document lintDocument) describe() string {
+	return fmt.Sprintf("%v \"%v\"", document.Object.Kind, document.Object.Metadata.Name)
+}
+
+// The pod template of a workload, or nil for other kinds.
+func (object *lintObject) podTemplate() *lintPodTemplate {
+	switch strings.ToLower(object.Kind) {
+	case "deployment", "statefulset", "daemonset", "replicaset", "job":
+		return &object.Spec.Template
+	case "cronjob":
+		return &object.Spec.JobTemplate.Spec.Template
+	}
+	return nil
+}
+
+func (object *lintObject) selectorLabels() map[string]string {
+	labels := map[string]string{}
+	source := object.Spec.Selector
+	if matchLabels, ok := source["matchLabels"].(map[interface{}]interface{}); ok {
+		source = map[string]interface{}{}
+		for key, value := range matchLabels {
+			source[fmt.Sprintf("%v", key)] = value
+		}
+	}
+	for key, value := range source {
+		if value, ok := value.(string); ok {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// Splits the output of `helm template` into objects. Each object is
+// attributed to a chart by the `# Source: chart/templates/...` comment that
+// helm writes ahead of it.
+func splitLintDocuments(helmOutput string) []lintDocument {
+	documents := []lintDocument{}
+	for _, raw := range strings.Split("\n"+helmOutput, "\n---") {
+		document := lintDocument{}
+		for _, line := range strings.Split(raw, "\n") {
+			if strings.HasPrefix(line, "# Source: ") {
+				document.Chart = strings.SplitN(strings.TrimPrefix(line, "# Source: "), "/", 2)[0]
+				break
+			}
+		}
+		// Objects that are only partly understood are still linted.
+		yaml.Unmarshal([]byte(raw), &document.Object)
+		if document.Object.Kind == "" {
+			continue
+		}
+		documents = append(documents, document)
+	}
+	return documents
+}
+
+// Workloads that may run more than one pod, either by their own replicas or
+// by a HorizontalPodAutoscaler's.
+func multiReplicaWorkloads(documents []lintDocument) []lintDocument {
+	autoscaled := map[string]bool{}
+	for _, document := range documents {
+		if strings.EqualFold(document.Object.Kind, "horizontalpodautoscaler") && document.Object.Spec.MaxReplicas > 1 {
+			ref := document.Object.Spec.ScaleTargetRef
+			autoscaled[strings.ToLower(ref.Kind+"/"+ref.Name)] = true
+		}
+	}
+
+	workloads := []lintDocument{}
+	for _, document := range documents {
+		switch strings.ToLower(document.Object.Kind) {
+		case "deployment", "statefulset":
+			replicas := document.Object.Spec.Replicas
+			if (replicas != nil && *replicas > 1) || autoscaled[strings.ToLower(document.Object.Kind+"/"+document.Object.Metadata.Name)] {
+				workloads = append(workloads, document)
+			}
+		}
+	}
+	return workloads
+}
+
+func lintReleaseSuffix(release string, documents []lintDocument) []lintFinding {
+	findings := []lintFinding{}
+	if release == "" {
+		return findings
+	}
+	suffix := fmt.Sprintf("-%v", release)
+	for i, document := range documents {
+		obj := document.Object
+		if !strings.HasSuffix(obj.Metadata.Name, suffix) {
+			findings = append(findings, lintFinding{document: &documents[i], Message: fmt.Sprintf("Object with kind '%v' and name '%v': object name is missing a dashed release suffix (in this case, '%v'). Use .Release.Name in your template to ensure that all objects are named with the release as a suffix to aovid name collisions across releases.",
+				obj.Kind, obj.Metadata.Name, suffix)})
+		}
+	}
+	return findings
+}
+
+func lintReleaseLabels(release string, documents []lintDocument) []lintFinding {
+	findings := []lintFinding{}
+	if release == "" {
+		return findings
+	}
+	for i, document := range documents {
+		obj := document.Object
+		add := func(format string, args ...interface{}) {
+			findings = append(findings, lintFinding{document: &documents[i], Message: fmt.Sprintf(format, args...)})
+		}
+
+		// Every object is labeled with a key `release` and value equal to the current context's release
+		if obj.Metadata.Labels["release"] != release {
+			add("Object with kind '%v' and name '%v': object is missing a `release` label with the release name as a value (in this case, '%v'). Found these labels on the object: %+v", obj.Kind, obj.Metadata.Name, release, obj.Metadata.Labels)
+		}
+
+		switch strings.ToLower(obj.Kind) {
+		case "deployment":
+			// The Deployment should create pods with the `release` label
+			if obj.Spec.Template.Metadata.Labels["release"] != release {
+				add("Deployment with name '%v': object's spec.template.metadata.labels is missing a `release` label with the release name as a value (in this case, '%v'). Found these labels on spec.template.metadata: %+v", obj.Metadata.Name, release, obj.Spec.Template.Metadata.Labels)
+			}
+		case "service":
+			// If the Service is not targeting an ExternalName, it should target pods with a `release` label
+			if obj.Spec.Type != "ExternalName" && obj.selectorLabels()["release"] != release {
+				add("Service with type '%v' and name '%v': object's spec.selector is missing the `release` key with the release name as a value (in this case, '%v'). Found these keys on spec.selector: %+v", obj.Spec.Type, obj.Metadata.Name, release, obj.selectorLabels())
+			}
+		}
+	}
+	return findings
+}
+
+func lintPodDisruptionBudgets(release string, documents []lintDocument) []lintFinding {
+	budgets := []map[string]string{}
+	for i := range documents {
+		if strings.EqualFold(documents[i].Object.Kind, "poddisruptionbudget") {
+			budgets = append(budgets, documents[i].Object.selectorLabels())
+		}
+	}
+
+	findings := []lintFinding{}
+	for _, workload := range multiReplicaWorkloads(documents) {
+		covered := false
+		for _, budget := range budgets {
+			if len(budget) > 0 && labelsMatch(budget, workload.Object.Spec.Template.Metadata.Labels) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			workload := workload
+			findings = append(findings, lintFinding{document: &workload, Message: fmt.Sprintf(
+				"%v runs more than one replica, but no PodDisruptionBudget selects its pods, so node drains may take all of them down at once", workload.describe())})
+		}
+	}
+	return findings
+}
+
+func lintTopologySpread(release string, documents []lintDocument) []lintFinding {
+	findings := []lintFinding{}
+	for _, workload := range multiReplicaWorkloads(documents) {
+		spec := workload.Object.Spec.Template.Spec
+		if len(spec.TopologySpreadConstraints) == 0 && spec.Affinity.PodAntiAffinity == nil {
+			workload := workload
+			findings = append(findings, lintFinding{document: &workload, Message: fmt.Sprintf(
+				"%v runs more than one replica, but sets neither topologySpreadConstraints nor podAntiAffinity, so its pods may all be scheduled onto one node or zone", workload.describe())})
+		}
+	}
+	return findings
+}
+
+func lintRunAsNonRoot(release string, documents []lintDocument) []lintFinding {
+	findings := []lintFinding{}
+	for i := range documents {
+		template := documents[i].Object.podTemplate()
+		if template == nil {
+			continue
+		}
+		pod := template.Spec.SecurityContext
+		root := []string{}
+		for _, container := range append(append([]lintContainer{}, template.Spec.InitContainers...), template.Spec.Containers...) {
+			if mayRunAsRoot(pod, container.SecurityContext) {
+				root = append(root, container.Name)
+			}
+		}
+		if len(root) > 0 {
+			findings = append(findings, lintFinding{document: &documents[i], Message: fmt.Sprintf(
+				"%v has containers that may run as root: %v. Set runAsNonRoot: true, or a non-zero runAsUser, in the pod's or the container's securityContext",
+				documents[i].describe(), strings.Join(root, ", "))})
+		}
+	}
+	return findings
+}
+
+// Whether a container may run as root, given its own and its pod's security
+// contexts. The container's settings take precedence.
+func mayRunAsRoot(pod *lintSecurityContext, container *lintSecurityContext) bool {
+	var runAsNonRoot *bool
+	var runAsUser *int64
+	for _, context := range []*lintSecurityContext{pod, container} {
+		if context == nil {
+			continue
+		}
+		if context.RunAsNonRoot != nil {
+			runAsNonRoot = context.RunAsNonRoot
+		}
+		if context.RunAsUser != nil {
+			runAsUser = context.RunAsUser
+		}
+	}
+	if runAsUser != nil {
+		return *runAsUser == 0
+	}
+	return runAsNonRoot == nil || !*runAsNonRoot
+}
+
+func labelsMatch(selector map[string]string, labels map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks that a `lint` config only names known rules and severities.
+func validateLintConfig(config ankh.LintConfig, source string) error {
+	names := []string{}
+	for name := range config.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		known := false
+		for _, rule := range lintRules {
+			known = known || rule.Name == name
+		}
+		if !known {
+			return fmt.Errorf("Unknown lint rule \"%v\" in `lint.rules` of %v. Known rules are %v", name, source, strings.Join(lintRuleNames(), ", "))
+		}
+		switch config.Rules[name] {
+		case lintError, lintWarning, lintOff:
+		default:
+			return fmt.Errorf("Invalid severity \"%v\" for lint rule \"%v\" in %v, expected one of error, warning or off", config.Rules[name], name, source)
+		}
+	}
+	return nil
+}
+
+func lintRuleNames() []string {
+	names := []string{}
+	for _, rule := range lintRules {
+		names = append(names, rule.Name)
+	}
+	return names
+}
+
+// The severity of a rule for a chart: the chart's `lint.rules` take
+// precedence over the current context's, which take precedence over the
+// Ankh config's.
+func lintRuleSeverity(ctx *ankh.ExecutionContext, chart *ankh.Chart, rule lintRule) string {
+	configs := []ankh.LintConfig{ctx.AnkhConfig.Lint, ctx.AnkhConfig.CurrentContext.Lint}
+	if chart != nil {
+		configs = append(configs, chart.ChartMeta.Lint)
+	}
+
+	severity := rule.Severity
+	for _, config := range configs {
+		if configured, ok := config.Rules[rule.Name]; ok {
+			severity = configured
+		}
+	}
+	return severity
+}
+
+// Runs every rule that is not off over the templated objects of the charts.
+func lintDocuments(ctx *ankh.ExecutionContext, charts []ankh.Chart, helmOutput string) ([]lintFinding, error) {
+	if err := validateLintConfig(ctx.AnkhConfig.Lint, "the Ankh config"); err != nil {
+		return nil, err
+	}
+	if err := validateLintConfig(ctx.AnkhConfig.CurrentContext.Lint, fmt.Sprintf("context \"%v\"", ctx.AnkhConfig.CurrentContextName)); err != nil {
+		return nil, err
+	}
+	for _, chart := range charts {
+		if err := validateLintConfig(chart.ChartMeta.Lint, fmt.Sprintf("chart \"%v\"", chart.InstanceName())); err != nil {
+			return nil, err
+		}
+	}
+
+	chartFor := func(document *lintDocument) *ankh.Chart {
+		for i := range charts {
+			if charts[i].Name == document.Chart || charts[i].InstanceName() == document.Chart {
+				return &charts[i]
+			}
+		}
+		return nil
+	}
+
+	documents := splitLintDocuments(helmOutput)
+	findings := []lintFinding{}
+	for _, rule := range lintRules {
+		for _, finding := range rule.Check(ctx.AnkhConfig.CurrentContext.Release, documents) {
+			finding.Rule = rule.Name
+			finding.Severity = lintRuleSeverity(ctx, chartFor(finding.document), rule)
+			if finding.Severity != lintOff {
+				findings = append(findings, finding)
+			}
+		}
+	}
+	return findings, nil
+}
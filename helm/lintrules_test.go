@@ -0,0 +1,154 @@
+package helm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+const lintedObjects = `---
+# Source: api/templates/deployment.yaml
+kind: Deployment
+metadata:
+  name: api
+spec:
+  replicas: 3
+  template:
+    metadata:
+      labels:
+        app: api
+    spec:
+      securityContext:
+        runAsNonRoot: true
+      containers:
+      - name: api
+      - name: sidecar
+        securityContext:
+          runAsUser: 0
+---
+# Source: api/templates/pdb.yaml
+kind: PodDisruptionBudget
+metadata:
+  name: api
+spec:
+  selector:
+    matchLabels:
+      app: api
+---
+# Source: worker/templates/deployment.yaml
+kind: Deployment
+metadata:
+  name: worker
+spec:
+  template:
+    metadata:
+      labels:
+        app: worker
+    spec:
+      affinity:
+        podAntiAffinity: {}
+      containers:
+      - name: worker
+---
+# Source: worker/templates/hpa.yaml
+kind: HorizontalPodAutoscaler
+metadata:
+  name: worker
+spec:
+  scaleTargetRef:
+    kind: Deployment
+    name: worker
+  maxReplicas: 5
+`
+
+func lintSummary(findings []lintFinding) string {
+	summary := []string{}
+	for _, finding := range findings {
+		summary = append(summary, finding.Rule+" "+finding.Severity+" "+finding.document.describe())
+	}
+	return strings.Join(summary, "\n")
+}
+
+func TestLintDocuments(t *testing.T) {
+	ctx := ankhtest.NewContext(t)
+	charts := []ankh.Chart{{Name: "api"}, {Name: "worker"}}
+
+	findings, err := lintDocuments(ctx, charts, lintedObjects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		`pod-disruption-budget warning Deployment "worker"`,
+		`topology-spread warning Deployment "api"`,
+		`run-as-non-root warning Deployment "api"`,
+		`run-as-non-root warning Deployment "worker"`,
+	}, "\n")
+	if summary := lintSummary(findings); summary != expected {
+		t.Logf("expected:\n%v\ngot:\n%v", expected, summary)
+		t.Fail()
+	}
+
+	// Charts take precedence over contexts, which take precedence over the config.
+	ctx.AnkhConfig.Lint.Rules = map[string]string{"pod-disruption-budget": "error", "topology-spread": "off"}
+	ctx.AnkhConfig.CurrentContext.Lint.Rules = map[string]string{"run-as-non-root": "error"}
+	charts[1].ChartMeta.Lint.Rules = map[string]string{"run-as-non-root": "off", "pod-disruption-budget": "warning"}
+	findings, err = lintDocuments(ctx, charts, lintedObjects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = strings.Join([]string{
+		`pod-disruption-budget warning Deployment "worker"`,
+		`run-as-non-root error Deployment "api"`,
+	}, "\n")
+	if summary := lintSummary(findings); summary != expected {
+		t.Logf("expected:\n%v\ngot:\n%v", expected, summary)
+		t.Fail()
+	}
+
+	charts[0].ChartMeta.Lint.Rules = map[string]string{"no-such-rule": "error"}
+	if _, err := lintDocuments(ctx, charts, lintedObjects); err == nil || !strings.Contains(err.Error(), "no-such-rule") {
+		t.Logf("expected an unknown rule to be an error but got %v", err)
+		t.Fail()
+	}
+}
+
+func TestLintStageWarnings(t *testing.T) {
+	ctx := ankhtest.NewContext(t)
+	input := lintedObjects
+	if _, err := NewLintStage(nil).Execute(ctx, &input, "web", nil); err != nil {
+		t.Logf("expected warnings alone not to fail the stage but got %v", err)
+		t.Fail()
+	}
+	if ctx.LintWarnings != 4 {
+		t.Logf("expected 4 warnings to be counted but got %v", ctx.LintWarnings)
+		t.Fail()
+	}
+
+	ctx.AnkhConfig.CurrentContext.Release = "blue"
+	if _, err := NewLintStage(nil).Execute(ctx, &input, "web", nil); err == nil || !strings.HasPrefix(err.Error(), "Lint found 10 errors") {
+		t.Logf("expected objects without the release's suffix and labels to fail the stage but got %v", err)
+		t.Fail()
+	}
+}
+
+func TestMayRunAsRoot(t *testing.T) {
+	yes, no := true, false
+	root, user := int64(0), int64(1000)
+	for _, test := range []struct {
+		pod, container *lintSecurityContext
+		root           bool
+	}{
+		{nil, nil, true},
+		{&lintSecurityContext{RunAsNonRoot: &yes}, nil, false},
+		{&lintSecurityContext{RunAsNonRoot: &yes}, &lintSecurityContext{RunAsNonRoot: &no}, true},
+		{nil, &lintSecurityContext{RunAsUser: &user}, false},
+		{&lintSecurityContext{RunAsNonRoot: &yes}, &lintSecurityContext{RunAsUser: &root}, true},
+	} {
+		if mayRunAsRoot(test.pod, test.container) != test.root {
+			t.Logf("expected %v for pod %+v and container %+v", test.root, test.pod, test.container)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/docker"
+	"github.com/appnexus/ankh/util"
+)
+
+const ociScheme = "oci://"
+
+// IsOCIRepository reports whether a helm repository is an OCI registry, eg:
+// `oci://harbor.example.com/charts`, rather than an HTTP chart repository.
+func IsOCIRepository(repository string) bool {
+	return strings.HasPrefix(repository, ociScheme)
+}
+
+// Splits an OCI repository into the registry domain and the path of its
+// charts within the registry, eg: `harbor.example.com` and `charts`.
+func parseOCIRepository(repository string) (string, string) {
+	tokens := strings.SplitN(strings.Trim(strings.TrimPrefix(repository, ociScheme), "/"), "/", 2)
+	if len(tokens) == 1 {
+		return tokens[0], ""
+	}
+	return tokens[0], tokens[1]
+}
+
+func ociChartRepository(repository string, chart string) string {
+	_, path := parseOCIRepository(repository)
+	if path == "" {
+		return chart
+	}
+	return path + "/" + chart
+}
+
+// OCI tags cannot contain `+`, so helm pushes semver build metadata with `_`.
+func ociTagToVersion(tag string) string {
+	return strings.Replace(tag, "_", "+", -1)
+}
+
+func sortVersions(versions []string, descending bool) {
+	sort.Slice(versions, func(i, j int) bool {
+		lessThan := util.FuzzySemVerCompare(versions[i], versions[j])
+		if descending {
+			return !lessThan
+		}
+		return lessThan
+	})
+}
+
+// Runs a helm registry command, with the docker CLI config as the registry
+// config, so that credentials from `docker login` and credential helpers apply.
+func runHelmRegistryCommand(ctx *ankh.ExecutionContext, args []string) error {
+	if ctx.HelmV2 {
+		return fmt.Errorf("OCI chart registries require Helm 3.8 or later")
+	}
+
+	helmArgs := append([]string{ctx.AnkhConfig.Helm.Command}, args...)
+	if _, err := os.Stat(docker.ConfigPath()); err == nil {
+		helmArgs = append(helmArgs, "--registry-config", docker.ConfigPath())
+	}
+	helmCmd := execContext(helmArgs[0], helmArgs[1:]...)
+	// Helm before 3.8 only supports OCI registries as an experiment.
+	helmCmd.Env = append(os.Environ(), "HELM_EXPERIMENTAL_OCI=1")
+
+	var stderr bytes.Buffer
+	helmCmd.Stderr = &stderr
+
+	ctx.Logger.Debugf("Running command %v", helmCmd)
+	span := ctx.Tracer.Start("helm", map[string]string{"command": strings.Join(helmCmd.Args, " ")})
+	err := helmCmd.Run()
+	span.End(err)
+	if err != nil {
+		outputMsg := ""
+		if stderr.Len() > 0 {
+			outputMsg = fmt.Sprintf(" -- the helm process had the following output on stderr:\n%s", stderr.String())
+		}
+		return fmt.Errorf("error running helm command '%v': %v%v",
+			strings.Join(helmCmd.Args, " "), err, outputMsg)
+	}
+	return nil
+}
+
+// Pulls a chart from an OCI registry, and extracts it to tmpDir/<chart>.
+func pullOCIChart(ctx *ankh.ExecutionContext, repository string, chart ankh.Chart, tmpDir string) error {
+	ref := fmt.Sprintf("%s/%s", strings.TrimRight(repository, "/"), chart.Name)
+	ctx.Logger.Debugf("pulling chart %s at version %s", ref, chart.Version)
+	return runHelmRegistryCommand(ctx, []string{"pull", ref, "--version", chart.Version, "--untar", "--untardir", tmpDir})
+}
+
+// Pushes a packaged chart to an OCI registry.
+func pushOCIChart(ctx *ankh.ExecutionContext, repository string, tarballPath string) error {
+	return runHelmRegistryCommand(ctx, []string{"push", tarballPath, strings.TrimRight(repository, "/")})
+}
+
+func listOCIVersions(ctx *ankh.ExecutionContext, repository string, chart string) ([]string, error) {
+	domain, _ := parseOCIRepository(repository)
+	tags, err := docker.RepositoryTags(ctx, domain, ociChartRepository(repository, chart))
+	if err != nil {
+		return []string{}, fmt.Errorf("Could not list versions of chart '%v' in registry '%v': %v", chart, domain, err)
+	}
+
+	versions := []string{}
+	for _, tag := range tags {
+		versions = append(versions, ociTagToVersion(tag))
+	}
+	return versions, nil
+}
+
+// Lists the charts directly under the repository's path, using the registry
+// catalog. Some registries, eg: ECR, do not serve a catalog, in which case
+// charts can still be used by name.
+func listOCICharts(ctx *ankh.ExecutionContext, repository string) (map[string][]string, error) {
+	domain, path := parseOCIRepository(repository)
+	repositories, err := docker.Repositories(ctx, domain)
+	if err != nil {
+		return nil, fmt.Errorf("Could not list charts in registry '%v', which may not support listing its catalog. "+
+			"Charts can still be used by name, eg: `--chart name@version`: %v", domain, err)
+	}
+
+	prefix := ""
+	if path != "" {
+		prefix = path + "/"
+	}
+	charts := make(map[string][]string)
+	for _, repo := range repositories {
+		if !strings.HasPrefix(repo, prefix) || strings.Contains(strings.TrimPrefix(repo, prefix), "/") {
+			continue
+		}
+		name := strings.TrimPrefix(repo, prefix)
+		versions, err := listOCIVersions(ctx, repository, name)
+		if err != nil {
+			return nil, err
+		}
+		charts[name] = versions
+	}
+	return charts, nil
+}
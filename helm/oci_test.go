@@ -0,0 +1,50 @@
+package helm
+
+import (
+	"testing"
+)
+
+func TestParseOCIRepository(t *testing.T) {
+	cases := []struct {
+		repository, domain, path string
+	}{
+		{"oci://harbor.example.com/charts", "harbor.example.com", "charts"},
+		{"oci://harbor.example.com/team/charts/", "harbor.example.com", "team/charts"},
+		{"oci://registry.example.com", "registry.example.com", ""},
+	}
+	for _, c := range cases {
+		domain, path := parseOCIRepository(c.repository)
+		if domain != c.domain || path != c.path {
+			t.Logf("expected %v and %v for %v but got %v and %v", c.domain, c.path, c.repository, domain, path)
+			t.Fail()
+		}
+	}
+
+	if repo := ociChartRepository("oci://harbor.example.com/charts", "web"); repo != "charts/web" {
+		t.Logf("expected charts/web but got %v", repo)
+		t.Fail()
+	}
+	if repo := ociChartRepository("oci://registry.example.com", "web"); repo != "web" {
+		t.Logf("expected web but got %v", repo)
+		t.Fail()
+	}
+}
+
+func TestOCIVersions(t *testing.T) {
+	if !IsOCIRepository("oci://harbor.example.com/charts") || IsOCIRepository("https://charts.example.com") {
+		t.Logf("expected only oci:// repositories to be OCI registries")
+		t.Fail()
+	}
+
+	if version := ociTagToVersion("1.2.3_build.4"); version != "1.2.3+build.4" {
+		t.Logf("expected 1.2.3+build.4 but got %v", version)
+		t.Fail()
+	}
+
+	versions := []string{"1.2.0", "1.10.0", "1.9.1"}
+	sortVersions(versions, true)
+	if versions[0] != "1.10.0" || versions[2] != "1.2.0" {
+		t.Logf("expected versions in descending order but got %v", versions)
+		t.Fail()
+	}
+}
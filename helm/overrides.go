@@ -0,0 +1,135 @@
+package helm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+)
+
+// Stamped on every Deployment that Ankh changed before applying it, eg:
+// `replicas=0`, so that nobody mistakes it for what the chart renders.
+const overriddenAnnotation = "ankh/overridden"
+
+// ValidateOverrides returns an error unless the overrides given on the
+// command line are a replica count, and an absolute or percentage
+// maxUnavailable, eg: `2` or `25%`.
+func ValidateOverrides(replicas string, maxUnavailable string) error {
+	if replicas != "" {
+		if n, err := strconv.Atoi(replicas); err != nil || n < 0 {
+			return fmt.Errorf("Invalid replica count \"%v\", expected a number of zero or more", replicas)
+		}
+	}
+	if maxUnavailable != "" {
+		if _, err := maxUnavailableValue(maxUnavailable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func maxUnavailableValue(maxUnavailable string) (interface{}, error) {
+	if n, err := strconv.Atoi(maxUnavailable); err == nil && n >= 0 {
+		return n, nil
+	}
+	if percent, err := strconv.Atoi(strings.TrimSuffix(maxUnavailable, "%")); err == nil &&
+		strings.HasSuffix(maxUnavailable, "%") && percent >= 0 && percent <= 100 {
+		return maxUnavailable, nil
+	}
+	return nil, fmt.Errorf("Invalid maxUnavailable \"%v\", expected a number of pods, eg: 1, or a percentage, eg: 25%%", maxUnavailable)
+}
+
+// Returns the value under a key of a yaml map, if it is itself a map.
+func mapValue(obj yaml.MapSlice, key string) yaml.MapSlice {
+	for _, item := range obj {
+		if item.Key == key {
+			if value, ok := item.Value.(yaml.MapSlice); ok {
+				return value
+			}
+		}
+	}
+	return nil
+}
+
+// Sets the value at a path of keys in a yaml map, adding any maps that are
+// missing along the way.
+func setPath(obj yaml.MapSlice, path []string, value interface{}) yaml.MapSlice {
+	if len(path) > 1 {
+		value = setPath(mapValue(obj, path[0]), path[1:], value)
+	}
+	for i := range obj {
+		if obj[i].Key == path[0] {
+			obj[i].Value = value
+			return obj
+		}
+	}
+	return append(obj, yaml.MapItem{Key: path[0], Value: value})
+}
+
+// Applies the overrides to a Deployment, and returns a description of them.
+func overrideDeployment(obj yaml.MapSlice, replicas string, maxUnavailable string) (yaml.MapSlice, string, error) {
+	overrides := []string{}
+	if replicas != "" {
+		n, _ := strconv.Atoi(replicas)
+		obj = setPath(obj, []string{"spec", "replicas"}, n)
+		overrides = append(overrides, "replicas="+replicas)
+	}
+	if maxUnavailable != "" {
+		strategy := mapValue(mapValue(obj, "spec"), "strategy")
+		for _, item := range strategy {
+			if item.Key == "type" && item.Value == "Recreate" {
+				return nil, "", fmt.Errorf("it uses the Recreate strategy, which has no maxUnavailable")
+			}
+		}
+		value, err := maxUnavailableValue(maxUnavailable)
+		if err != nil {
+			return nil, "", err
+		}
+		obj = setPath(obj, []string{"spec", "strategy", "type"}, "RollingUpdate")
+		obj = setPath(obj, []string{"spec", "strategy", "rollingUpdate", "maxUnavailable"}, value)
+		overrides = append(overrides, "maxUnavailable="+maxUnavailable)
+	}
+
+	description := strings.Join(overrides, ",")
+	obj = setPath(obj, []string{"metadata", "annotations", overriddenAnnotation}, description)
+	return obj, description, nil
+}
+
+// Changes every Deployment in helm's output with the overrides given on the
+// command line, eg: `--force-replicas 0`, without touching other objects.
+func overrideWorkloads(ctx *ankh.ExecutionContext, helmOutput string) (string, error) {
+	output := ""
+	for _, body := range splitObjects(helmOutput) {
+		obj := exportedObject{}
+		if err := yaml.Unmarshal([]byte(body), &obj); err != nil {
+			return "", fmt.Errorf("unable to parse a rendered object to override it: %v", err)
+		}
+		if obj.Kind != "Deployment" {
+			output += fmt.Sprintf("---\n%v\n", body)
+			continue
+		}
+
+		deployment := yaml.MapSlice{}
+		if err := yaml.Unmarshal([]byte(body), &deployment); err != nil {
+			return "", fmt.Errorf("unable to parse Deployment \"%v\" to override it: %v", obj.Metadata.Name, err)
+		}
+		deployment, description, err := overrideDeployment(deployment, ctx.ForceReplicas, ctx.ForceMaxUnavailable)
+		if err != nil {
+			return "", fmt.Errorf("unable to override Deployment \"%v\": %v", obj.Metadata.Name, err)
+		}
+		out, err := yaml.Marshal(deployment)
+		if err != nil {
+			return "", err
+		}
+		ctx.Logger.Warnf("Overriding %v of Deployment \"%v\", which differs from its chart until it is applied again without overrides",
+			description, obj.Metadata.Name)
+
+		// Comments, eg: `# Source:`, are kept ahead of the changed object.
+		comments := append(leadingComments(body), strings.TrimRight(string(out), "\n"))
+		output += fmt.Sprintf("---\n%v\n", strings.Join(comments, "\n"))
+	}
+	return output, nil
+}
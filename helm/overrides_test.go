@@ -0,0 +1,87 @@
+package helm
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/appnexus/ankh/context"
+)
+
+const overrideOutput = `---
+# Source: app/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  annotations:
+    team: web
+spec:
+  replicas: 6
+  template:
+    spec:
+      containers:
+      - name: app
+---
+# Source: app/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+spec:
+  replicas: 6
+`
+
+func TestOverrideWorkloads(t *testing.T) {
+	ctx := &ankh.ExecutionContext{Logger: logrus.New(), ForceReplicas: "0", ForceMaxUnavailable: "25%"}
+	ctx.Logger.Out = ioutil.Discard
+
+	out, err := overrideWorkloads(ctx, overrideOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects := strings.Split(out, "---\n")
+	if len(objects) != 3 {
+		t.Fatalf("expected both objects but got %q", out)
+	}
+	deployment := objects[1]
+	for _, expected := range []string{
+		"# Source: app/templates/deployment.yaml\napiVersion: apps/v1\n",
+		"    team: web\n    ankh/overridden: replicas=0,maxUnavailable=25%\n",
+		"  replicas: 0\n",
+		"  strategy:\n    type: RollingUpdate\n    rollingUpdate:\n      maxUnavailable: 25%\n",
+	} {
+		if !strings.Contains(deployment, expected) {
+			t.Logf("expected the Deployment to contain %q but got:\n%v", expected, deployment)
+			t.Fail()
+		}
+	}
+	if !strings.Contains(objects[2], "replicas: 6") || strings.Contains(objects[2], "ankh/overridden") {
+		t.Logf("expected the Service to be left alone but got:\n%v", objects[2])
+		t.Fail()
+	}
+
+	recreate := strings.Replace(overrideOutput, "  replicas: 6\n  template:", "  strategy:\n    type: Recreate\n  template:", 1)
+	if _, err := overrideWorkloads(ctx, recreate); err == nil {
+		t.Logf("expected maxUnavailable not to be set on a Deployment using the Recreate strategy")
+		t.Fail()
+	}
+}
+
+func TestValidateOverrides(t *testing.T) {
+	for _, valid := range [][2]string{{"0", ""}, {"", "1"}, {"3", "100%"}} {
+		if err := ValidateOverrides(valid[0], valid[1]); err != nil {
+			t.Logf("expected %v to be valid but got %v", valid, err)
+			t.Fail()
+		}
+	}
+	for _, invalid := range [][2]string{{"-1", ""}, {"two", ""}, {"", "150%"}, {"", "1.5"}, {"", "%"}} {
+		if err := ValidateOverrides(invalid[0], invalid[1]); err == nil {
+			t.Logf("expected %v to be invalid", invalid)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package helm
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/appnexus/ankh/context"
+)
+
+func readPartial(ctx *ankh.ExecutionContext, partial string) ([]byte, error) {
+	if strings.HasPrefix(partial, "http://") || strings.HasPrefix(partial, "https://") {
+		tr := &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+		client := &http.Client{
+			Transport: ctx.Tracer.Transport(tr),
+			Timeout:   time.Duration(10 * time.Second),
+		}
+		resp, err := client.Get(partial)
+		if err != nil {
+			return nil, fmt.Errorf("got an error %v when trying to call %v", err, partial)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 200 {
+			return nil, fmt.Errorf("Received HTTP status '%v' (code %v) when trying to call %s", resp.Status, resp.StatusCode, partial)
+		}
+		return ioutil.ReadAll(resp.Body)
+	}
+
+	partialPath := partial
+	if ctx.WorkingPath != "" && !filepath.IsAbs(partialPath) {
+		partialPath = filepath.Join(ctx.WorkingPath, partialPath)
+	}
+	return ioutil.ReadFile(partialPath)
+}
+
+// Returns the name a partial is added to a chart's templates under. Helm only
+// treats templates that start with `_` as partials, and the `ankh-` prefix
+// keeps them apart from the chart's own templates.
+func partialFileName(partial string) string {
+	name := filepath.Base(partial)
+	if u, err := url.Parse(partial); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		name = path.Base(u.Path)
+	}
+	return "_ankh-" + strings.TrimLeft(name, "_")
+}
+
+// Adds the chart's partials to the templates in `chartDir`, so that its
+// templates may `include` the named templates that they define.
+func addPartials(ctx *ankh.ExecutionContext, chart ankh.Chart, chartDir string) error {
+	if len(chart.Partials) == 0 {
+		return nil
+	}
+
+	templatesDir := filepath.Join(chartDir, "templates")
+	if err := os.MkdirAll(templatesDir, 0755); err != nil {
+		return err
+	}
+	added := make(map[string]string)
+	for _, partial := range chart.Partials {
+		name := partialFileName(partial)
+		if other, ok := added[name]; ok {
+			return fmt.Errorf("partials %v and %v would both be added as templates/%v. Give them distinct file names", other, partial, name)
+		}
+		added[name] = partial
+
+		body, err := readPartial(ctx, partial)
+		if err != nil {
+			return fmt.Errorf("Unable to read partial %v: %v", partial, err)
+		}
+		ctx.Logger.Debugf("Adding partial %v to chart \"%v\" as templates/%v", partial, chart.InstanceName(), name)
+		if err := ioutil.WriteFile(filepath.Join(templatesDir, name), body, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
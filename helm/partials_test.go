@@ -0,0 +1,75 @@
+package helm
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+func TestPartialFileName(t *testing.T) {
+	cases := map[string]string{
+		"partials/_labels.tpl":                          "_ankh-labels.tpl",
+		"/etc/ankh/annotations.tpl":                     "_ankh-annotations.tpl",
+		"https://example.com/partials/_helpers.tpl?v=2": "_ankh-helpers.tpl",
+	}
+	for partial, expected := range cases {
+		if name := partialFileName(partial); name != expected {
+			t.Logf("expected %v for %v but got %v", expected, partial, name)
+			t.Fail()
+		}
+	}
+}
+
+func TestTemplateStageAddsPartials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{{- define "org.annotations" -}}owner: core{{- end -}}`))
+	}))
+	defer server.Close()
+	repository := ankhtest.NewChartRepository(t, ankhtest.Chart{Name: "foo", Version: "1.0.0"})
+	tools := ankhtest.NewTools(t)
+	tools.Fake("helm", ankhtest.Rule{Args: "template *", Stdout: "kind: ConfigMap\n"})
+
+	dir, err := ioutil.TempDir("", "ankh-partials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	labels := `{{- define "org.labels" -}}team: core{{- end -}}`
+	ioutil.WriteFile(filepath.Join(dir, "_labels.tpl"), []byte(labels), 0644)
+
+	ctx := ankhtest.NewContext(t)
+	ctx.WorkingPath = dir
+	ctx.AnkhConfig.Helm.Repository = repository.URL
+	chart := ankh.Chart{Name: "foo", Version: "1.0.0", Partials: []string{"_labels.tpl", server.URL + "/annotations.tpl"}}
+
+	if _, err := NewTemplateStage([]ankh.Chart{chart}).Execute(ctx, nil, "web", nil); err != nil {
+		t.Fatal(err)
+	}
+	calls := tools.Calls("helm")
+	if len(calls) != 1 {
+		t.Fatalf("expected a single call to helm template but got %+v", calls)
+	}
+	chartDir := calls[0].Args[len(calls[0].Args)-1]
+	for name, expected := range map[string]string{
+		"_ankh-labels.tpl":      labels,
+		"_ankh-annotations.tpl": `{{- define "org.annotations" -}}owner: core{{- end -}}`,
+	} {
+		body, err := ioutil.ReadFile(filepath.Join(chartDir, "templates", name))
+		if err != nil || string(body) != expected {
+			t.Logf("expected templates/%v to hold the partial, but got %q and %v", name, body, err)
+			t.Fail()
+		}
+	}
+
+	chart.Partials = []string{"_labels.tpl", "other/_labels.tpl"}
+	if _, err := NewTemplateStage([]ankh.Chart{chart}).Execute(ctx, nil, "web", nil); err == nil {
+		t.Logf("expected partials with the same file name to be refused")
+		t.Fail()
+	}
+}
@@ -0,0 +1,54 @@
+package helm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+)
+
+// PostRenderStage pipes templated output through an external executable,
+// eg: kustomize or a policy injector, as `helm template --post-renderer`
+// would, before it is used by the stages after it.
+type PostRenderStage struct{}
+
+func NewPostRenderStage() plan.Stage {
+	return PostRenderStage{}
+}
+
+// The post-renderer comes from the command line, and then ankh config. Args
+// from ankh config are only used with the post-renderer from ankh config.
+func getPostRenderer(ctx *ankh.ExecutionContext) (string, []string) {
+	if ctx.PostRenderer != "" {
+		return ctx.PostRenderer, []string{}
+	}
+	return ctx.AnkhConfig.Helm.PostRenderer, ctx.AnkhConfig.Helm.PostRendererArgs
+}
+
+// HasPostRenderer returns whether templated output is post-rendered.
+func HasPostRenderer(ctx *ankh.ExecutionContext) bool {
+	postRenderer, _ := getPostRenderer(ctx)
+	return postRenderer != ""
+}
+
+func (stage PostRenderStage) Execute(ctx *ankh.ExecutionContext, input *string, namespace string, wildCardLabels []string) (string, error) {
+	postRenderer, args := getPostRenderer(ctx)
+	cmd := plan.NewCommand(postRenderer)
+	cmd.AddArguments(args)
+
+	if ctx.Mode == ankh.Explain {
+		in := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(*input), "&& \\"))
+		return fmt.Sprintf("(%s) | \\\n%s", in, cmd.Explain()), nil
+	}
+
+	ctx.Logger.Debugf("Post-rendering with %v", cmd.Explain())
+	out, err := cmd.Run(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("Unable to post-render the templated output: %v", err)
+	}
+	if HasKustomizeOverlay(ctx) {
+		return out, nil
+	}
+	return finishRender(ctx, out, namespace)
+}
@@ -0,0 +1,52 @@
+package helm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+func TestPostRenderStage(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("inject-sidecars", ankhtest.Rule{Stdout: "kind: Deployment\nmetadata:\n  name: foo-injected\n"})
+	tools.Fake("broken-renderer", ankhtest.Rule{Stderr: "no kustomization.yaml", ExitCode: 1})
+
+	ctx := ankhtest.NewContext(t)
+	ctx.AnkhConfig.Helm.PostRenderer = "inject-sidecars"
+	ctx.AnkhConfig.Helm.PostRendererArgs = []string{"--mesh", "istio"}
+	input := "kind: Deployment\nmetadata:\n  name: foo\n"
+
+	out, err := NewPostRenderStage().Execute(ctx, &input, "web", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "name: foo-injected") {
+		t.Logf("expected the output of the post-renderer but got %q", out)
+		t.Fail()
+	}
+	calls := tools.Calls("inject-sidecars")
+	if len(calls) != 1 || calls[0].Stdin != input || strings.Join(calls[0].Args, " ") != "--mesh istio" {
+		t.Fatalf("expected the templated output to be piped to the post-renderer with its args but got %+v", calls)
+	}
+
+	// The command line takes precedence, without the args from ankh config.
+	ctx.PostRenderer = "broken-renderer"
+	if _, err := NewPostRenderStage().Execute(ctx, &input, "web", nil); err == nil || !strings.Contains(err.Error(), "no kustomization.yaml") {
+		t.Logf("expected the post-renderer's failure to be reported but got %v", err)
+		t.Fail()
+	}
+	if calls := tools.Calls("broken-renderer"); len(calls) != 1 || len(calls[0].Args) != 0 {
+		t.Logf("expected the post-renderer from the command line without args but got %+v", calls)
+		t.Fail()
+	}
+
+	ctx.Mode = ankh.Explain
+	explained := "helm template foo && \\\n"
+	out, err = NewPostRenderStage().Execute(ctx, &explained, "web", nil)
+	if err != nil || out != "(helm template foo) | \\\nbroken-renderer" {
+		t.Logf("expected the post-renderer to be part of the explained pipeline but got %q and %v", out, err)
+		t.Fail()
+	}
+}
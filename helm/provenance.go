@@ -0,0 +1,104 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Chart.yaml annotations recording how a published chart was built.
+const (
+	gitCommitAnnotation = "ankh/git-commit"
+	ciJobURLAnnotation  = "ankh/ci-job-url"
+)
+
+// Finds the URL of the current CI job, if any, from the variables set by common CI systems.
+func ciJobURL(getenv func(string) string) string {
+	for _, name := range []string{"CI_JOB_URL", "BUILD_URL", "TRAVIS_JOB_WEB_URL", "CIRCLE_BUILD_URL"} {
+		if url := getenv(name); url != "" {
+			return url
+		}
+	}
+	// GitHub Actions
+	if server, repo, run := getenv("GITHUB_SERVER_URL"), getenv("GITHUB_REPOSITORY"), getenv("GITHUB_RUN_ID"); server != "" && repo != "" && run != "" {
+		return fmt.Sprintf("%v/%v/actions/runs/%v", server, repo, run)
+	}
+	return ""
+}
+
+// Gathers build metadata for a chart in dir: the git commit it was built from,
+// and the CI job that built it.
+func buildMetadataAnnotations(dir string) map[string]string {
+	annotations := map[string]string{}
+
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	if out, err := cmd.Output(); err == nil {
+		annotations[gitCommitAnnotation] = strings.TrimSpace(string(out))
+	}
+
+	if url := ciJobURL(os.Getenv); url != "" {
+		annotations[ciJobURLAnnotation] = url
+	}
+	return annotations
+}
+
+// Adds annotations to a Chart.yaml, keeping everything else as it was.
+func injectAnnotations(chartYamlPath string, annotations map[string]string) error {
+	body, err := ioutil.ReadFile(chartYamlPath)
+	if err != nil {
+		return err
+	}
+
+	chartYaml := yaml.MapSlice{}
+	if err := yaml.Unmarshal(body, &chartYaml); err != nil {
+		return fmt.Errorf("Unable to parse %v: %v", chartYamlPath, err)
+	}
+
+	keys := []string{}
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	existing := yaml.MapSlice{}
+	index := -1
+	for i, item := range chartYaml {
+		if item.Key == "annotations" {
+			index = i
+			if m, ok := item.Value.(yaml.MapSlice); ok {
+				existing = m
+			}
+		}
+	}
+
+	for _, key := range keys {
+		replaced := false
+		for i := range existing {
+			if existing[i].Key == key {
+				existing[i].Value = annotations[key]
+				replaced = true
+			}
+		}
+		if !replaced {
+			existing = append(existing, yaml.MapItem{Key: key, Value: annotations[key]})
+		}
+	}
+
+	if index >= 0 {
+		chartYaml[index].Value = existing
+	} else {
+		chartYaml = append(chartYaml, yaml.MapItem{Key: "annotations", Value: existing})
+	}
+
+	out, err := yaml.Marshal(chartYaml)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(chartYamlPath, out, 0644)
+}
@@ -0,0 +1,78 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestCIJobURL(t *testing.T) {
+	env := map[string]string{
+		"GITHUB_SERVER_URL": "https://github.com",
+		"GITHUB_REPOSITORY": "appnexus/ankh",
+		"GITHUB_RUN_ID":     "42",
+	}
+	getenv := func(name string) string { return env[name] }
+
+	if url := ciJobURL(getenv); url != "https://github.com/appnexus/ankh/actions/runs/42" {
+		t.Logf("got unexpected url %v", url)
+		t.Fail()
+	}
+
+	env["BUILD_URL"] = "https://jenkins.example.com/job/ankh/7/"
+	if url := ciJobURL(getenv); url != env["BUILD_URL"] {
+		t.Logf("got unexpected url %v", url)
+		t.Fail()
+	}
+}
+
+func TestInjectAnnotations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-provenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	chartYamlPath := filepath.Join(dir, "Chart.yaml")
+	err = ioutil.WriteFile(chartYamlPath, []byte("name: foo\nversion: 1.0.0\nannotations:\n  owner: team-a\n  ankh/git-commit: stale\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = injectAnnotations(chartYamlPath, map[string]string{
+		gitCommitAnnotation: "abc123",
+		ciJobURLAnnotation:  "https://ci.example.com/1",
+	})
+	if err != nil {
+		t.Logf("got error %v", err)
+		t.FailNow()
+	}
+
+	body, _ := ioutil.ReadFile(chartYamlPath)
+	chartYaml := struct {
+		Name        string
+		Version     string
+		Annotations map[string]string
+	}{}
+	if err := yaml.Unmarshal(body, &chartYaml); err != nil {
+		t.Fatal(err)
+	}
+
+	if chartYaml.Name != "foo" || chartYaml.Version != "1.0.0" {
+		t.Logf("expected name and version to be unchanged, got %+v", chartYaml)
+		t.Fail()
+	}
+	for key, expected := range map[string]string{
+		"owner":             "team-a",
+		gitCommitAnnotation: "abc123",
+		ciJobURLAnnotation:  "https://ci.example.com/1",
+	} {
+		if chartYaml.Annotations[key] != expected {
+			t.Logf("expected annotation %v=%v but got %v", key, expected, chartYaml.Annotations[key])
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+)
+
+const redacted = "<redacted>"
+
+var (
+	// Values under keys like these are redacted from render records.
+	secretKeyRegexp = regexp.MustCompile(`(?i)(password|passwd|secret|token|credential|private.?key|api.?key|access.?key)`)
+
+	invalidNameChars    = regexp.MustCompile(`[^a-z0-9.-]+`)
+	invalidDataKeyChars = regexp.MustCompile(`[^-._a-zA-Z0-9]+`)
+)
+
+func redactValues(value interface{}) interface{} {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		out := yaml.MapSlice{}
+		for _, item := range v {
+			if secretKeyRegexp.MatchString(fmt.Sprintf("%v", item.Key)) {
+				out = append(out, yaml.MapItem{Key: item.Key, Value: redacted})
+			} else {
+				out = append(out, yaml.MapItem{Key: item.Key, Value: redactValues(item.Value)})
+			}
+		}
+		return out
+	case []interface{}:
+		out := []interface{}{}
+		for _, item := range v {
+			out = append(out, redactValues(item))
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// Redacts a `--set key=value` argument if its key looks secret.
+func redactSetArg(arg string) string {
+	kv := strings.SplitN(arg, "=", 2)
+	if len(kv) == 2 && secretKeyRegexp.MatchString(kv[0]) {
+		return kv[0] + "=" + redacted
+	}
+	return arg
+}
+
+func renderRecordName(release string, chartName string) string {
+	name := "ankh-render-" + chartName
+	if release != "" {
+		name = "ankh-render-" + release + "-" + chartName
+	}
+	name = strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(name), "-"), "-.")
+	if len(name) > 253 {
+		name = strings.TrimRight(name[:253], "-.")
+	}
+	return name
+}
+
+type renderRecordConfigMap struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name   string            `yaml:"name"`
+		Labels map[string]string `yaml:"labels"`
+	} `yaml:"metadata"`
+	Data yaml.MapSlice `yaml:"data"`
+}
+
+// Builds a ConfigMap that records how a chart was rendered: the arguments to
+// `helm template`, and the contents of every values file passed to it, with
+// anything that looks secret redacted.
+func renderRecord(ctx *ankh.ExecutionContext, chart ankh.Chart, repository string, helmArgs []string) (string, error) {
+	currentContext := ctx.AnkhConfig.CurrentContext
+
+	source := chart.Name + "@" + chart.Version
+	if chart.Path != "" {
+		source = chart.Path
+	}
+
+	data := yaml.MapSlice{
+		{Key: "chart", Value: source},
+		{Key: "repository", Value: repository},
+		{Key: "context", Value: ctx.AnkhConfig.CurrentContextName},
+		{Key: "release", Value: currentContext.Release},
+		{Key: "environment-class", Value: currentContext.EnvironmentClass},
+		{Key: "resource-profile", Value: currentContext.ResourceProfile},
+	}
+	if chart.Alias != "" {
+		data = append(data, yaml.MapItem{Key: "alias", Value: chart.Alias})
+	}
+	if len(chart.Secrets) > 0 {
+		// Only where secrets came from is recorded, never their values.
+		sources := []string{}
+		for _, source := range chart.Secrets {
+			sources = append(sources, describeSecretSource(source))
+		}
+		data = append(data, yaml.MapItem{Key: "secrets", Value: strings.Join(sources, "\n") + "\n"})
+	}
+
+	// Values files are referred to by their data key, so that the arguments
+	// can be replayed against the files saved alongside them.
+	args := []string{}
+	valuesFiles := yaml.MapSlice{}
+	for i := 0; i < len(helmArgs); i++ {
+		arg := helmArgs[i]
+		switch {
+		case arg == "-f" && i+1 < len(helmArgs):
+			i++
+			key := fmt.Sprintf("values-%02d-%v", len(valuesFiles)+1,
+				invalidDataKeyChars.ReplaceAllString(filepath.Base(helmArgs[i]), "-"))
+
+			body, err := ioutil.ReadFile(helmArgs[i])
+			if err != nil {
+				return "", err
+			}
+			values := yaml.MapSlice{}
+			contents := ""
+			if err := yaml.Unmarshal(body, &values); err != nil {
+				contents = fmt.Sprintf("# unable to parse %v, so its contents are omitted: %v\n", filepath.Base(helmArgs[i]), err)
+			} else {
+				out, err := yaml.Marshal(redactValues(values))
+				if err != nil {
+					return "", err
+				}
+				contents = string(out)
+			}
+
+			args = append(args, "-f", key)
+			valuesFiles = append(valuesFiles, yaml.MapItem{Key: key, Value: contents})
+		case arg == "--set" && i+1 < len(helmArgs):
+			i++
+			args = append(args, "--set", redactSetArg(helmArgs[i]))
+		default:
+			args = append(args, arg)
+		}
+	}
+	data = append(data, yaml.MapItem{Key: "helm-args", Value: strings.Join(args, "\n") + "\n"})
+	data = append(data, valuesFiles...)
+
+	configMap := renderRecordConfigMap{APIVersion: "v1", Kind: "ConfigMap", Data: data}
+	release := chart.ReleaseName(currentContext.Release)
+	configMap.Metadata.Name = renderRecordName(currentContext.Release, chart.InstanceName())
+	configMap.Metadata.Labels = map[string]string{
+		"app.kubernetes.io/managed-by": "ankh",
+	}
+	if release != "" {
+		configMap.Metadata.Labels["app.kubernetes.io/instance"] = release
+	}
+
+	out, err := yaml.Marshal(configMap)
+	if err != nil {
+		return "", err
+	}
+	return "---\n# Source: ankh render record\n" + string(out), nil
+}
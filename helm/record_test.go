@@ -0,0 +1,79 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+)
+
+func TestRenderRecordName(t *testing.T) {
+	if name := renderRecordName("Prod_1", "my-chart"); name != "ankh-render-prod-1-my-chart" {
+		t.Logf("got unexpected name %v", name)
+		t.Fail()
+	}
+	if name := renderRecordName("", "my-chart"); name != "ankh-render-my-chart" {
+		t.Logf("got unexpected name %v", name)
+		t.Fail()
+	}
+}
+
+func TestRenderRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valuesPath := filepath.Join(dir, "ankh-values.yaml")
+	err = ioutil.WriteFile(valuesPath, []byte("replicas: 2\ndb:\n  host: db.example.com\n  password: hunter2\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &ankh.ExecutionContext{}
+	ctx.AnkhConfig.CurrentContextName = "dev"
+	ctx.AnkhConfig.CurrentContext = ankh.Context{Release: "dev", EnvironmentClass: "dev", ResourceProfile: "constrained"}
+
+	out, err := renderRecord(ctx, ankh.Chart{Name: "foo", Version: "1.0.0"}, "https://charts.example.com",
+		[]string{"template", "--namespace", "bar", "dev", "--set", "tag=1.2.3", "--set", "apiToken=abc", "-f", valuesPath})
+	if err != nil {
+		t.Logf("got error %v", err)
+		t.FailNow()
+	}
+
+	configMap := struct {
+		Kind     string
+		Metadata struct{ Name string }
+		Data     map[string]string
+	}{}
+	if err := yaml.Unmarshal([]byte(out), &configMap); err != nil {
+		t.Fatal(err)
+	}
+
+	if configMap.Kind != "ConfigMap" || configMap.Metadata.Name != "ankh-render-dev-foo" {
+		t.Logf("got unexpected ConfigMap %+v", configMap)
+		t.Fail()
+	}
+	if configMap.Data["chart"] != "foo@1.0.0" {
+		t.Logf("got unexpected chart %v", configMap.Data["chart"])
+		t.Fail()
+	}
+
+	expectedArgs := "template\n--namespace\nbar\ndev\n--set\ntag=1.2.3\n--set\napiToken=<redacted>\n-f\nvalues-01-ankh-values.yaml\n"
+	if configMap.Data["helm-args"] != expectedArgs {
+		t.Logf("expected args %q but got %q", expectedArgs, configMap.Data["helm-args"])
+		t.Fail()
+	}
+
+	values := configMap.Data["values-01-ankh-values.yaml"]
+	if !strings.Contains(values, "host: db.example.com") || !strings.Contains(values, "password: <redacted>") || strings.Contains(values, "hunter2") {
+		t.Logf("expected the password to be redacted from values, got %v", values)
+		t.Fail()
+	}
+}
@@ -0,0 +1,221 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+)
+
+func describeSecretSource(source ankh.SecretSource) string {
+	if source.Sops != "" {
+		return "sops " + source.Sops
+	}
+	return "vault " + source.Vault
+}
+
+// Checks a secret source, and expands variables in its path, as for value sources.
+func expandSecretSource(ctx *ankh.ExecutionContext, source ankh.SecretSource, vars map[string]string) (ankh.SecretSource, error) {
+	if (source.Sops == "") == (source.Vault == "") {
+		return source, fmt.Errorf("secret sources must set exactly one of `sops` or `vault`")
+	}
+
+	source.Vault = expandValueSourceVars(source.Vault, vars)
+	if source.Sops != "" {
+		source.Sops = expandValueSourceVars(source.Sops, vars)
+		if ctx.WorkingPath != "" && !filepath.IsAbs(source.Sops) {
+			source.Sops = filepath.Join(ctx.WorkingPath, source.Sops)
+		}
+	}
+	return source, nil
+}
+
+func runSecretCommand(ctx *ankh.ExecutionContext, args []string) ([]byte, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	span := ctx.Tracer.Start("secret source", map[string]string{"command": strings.Join(args, " ")})
+	err := cmd.Run()
+	span.End(err)
+	if err != nil {
+		outputMsg := ""
+		if stderr.Len() > 0 {
+			outputMsg = fmt.Sprintf(" -- the command had the following output on stderr:\n%s", stderr.String())
+		}
+		return nil, fmt.Errorf("error running `%v`: %v%v", strings.Join(args, " "), err, outputMsg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// Reads a Vault KV secret from the output of `vault kv get -format=json`. KV
+// version 2 nests the secret's data, next to its metadata.
+func parseVaultSecret(out []byte) (map[interface{}]interface{}, error) {
+	secret := struct {
+		Data map[interface{}]interface{}
+	}{}
+	// JSON is YAML, and the YAML decoder gives the map types helm values use.
+	if err := yaml.Unmarshal(out, &secret); err != nil {
+		return nil, err
+	}
+	if data, ok := secret.Data["data"].(map[interface{}]interface{}); ok {
+		if _, ok := secret.Data["metadata"]; ok {
+			return data, nil
+		}
+	}
+	if secret.Data == nil {
+		return map[interface{}]interface{}{}, nil
+	}
+	return secret.Data, nil
+}
+
+func decryptSecretSource(ctx *ankh.ExecutionContext, source ankh.SecretSource) (map[interface{}]interface{}, error) {
+	values := make(map[interface{}]interface{})
+	if source.Sops != "" {
+		out, err := runSecretCommand(ctx, []string{"sops", "--decrypt", "--output-type", "yaml", source.Sops})
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(out, &values); err != nil {
+			return nil, fmt.Errorf("Unable to parse the decrypted contents of %v as YAML: %v", source.Sops, err)
+		}
+		return values, nil
+	}
+
+	out, err := runSecretCommand(ctx, []string{"vault", "kv", "get", "-format=json", source.Vault})
+	if err != nil {
+		return nil, err
+	}
+	values, err = parseVaultSecret(out)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse Vault secret %v: %v", source.Vault, err)
+	}
+	return values, nil
+}
+
+// DecryptSecrets decrypts every secret source of a chart, and merges their
+// values. Later sources take precedence over earlier ones.
+func DecryptSecrets(ctx *ankh.ExecutionContext, chart ankh.Chart, namespace string) (map[interface{}]interface{}, error) {
+	vars := valueSourceVars(ctx, chart, namespace)
+	values := make(map[interface{}]interface{})
+	for i, source := range chart.Secrets {
+		source, err := expandSecretSource(ctx, source, vars)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid secret source %v of chart \"%v\": %v", i, chart.InstanceName(), err)
+		}
+
+		ctx.Logger.Debugf("Decrypting values from %v", describeSecretSource(source))
+		sourceValues, err := decryptSecretSource(ctx, source)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decrypt values for chart \"%v\" from %v: %v", chart.InstanceName(), describeSecretSource(source), err)
+		}
+
+		if source.Key != "" {
+			nested := make(map[interface{}]interface{})
+			setNestedValue(nested, source.Key, sourceValues)
+			sourceValues = nested
+		}
+		mergeValues(values, sourceValues)
+	}
+	return values, nil
+}
+
+// Returns every string among decrypted values, however deeply nested, so that
+// they can be kept out of what is saved to the data dir.
+func secretStrings(value interface{}) []string {
+	strs := []string{}
+	switch value := value.(type) {
+	case string:
+		strs = append(strs, value)
+	case map[interface{}]interface{}:
+		for _, v := range value {
+			strs = append(strs, secretStrings(v)...)
+		}
+	case []interface{}:
+		for _, v := range value {
+			strs = append(strs, secretStrings(v)...)
+		}
+	}
+	return strs
+}
+
+// SopsFiles returns the sops-encrypted files of a chart, eg: for editing.
+func SopsFiles(ctx *ankh.ExecutionContext, chart ankh.Chart) ([]string, error) {
+	vars := valueSourceVars(ctx, chart, "")
+	files := []string{}
+	for i, source := range chart.Secrets {
+		source, err := expandSecretSource(ctx, source, vars)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid secret source %v of chart \"%v\": %v", i, chart.InstanceName(), err)
+		}
+		if source.Sops != "" {
+			files = append(files, source.Sops)
+		}
+	}
+	return files, nil
+}
+
+// In explain mode, secrets are not decrypted. Instead, the explanation shows
+// how to decrypt them with `ankh secrets view`.
+func explainSecrets(ctx *ankh.ExecutionContext, chart ankh.Chart) []string {
+	command := "ankh"
+	if ctx.AnkhConfig.CurrentContextName != "" {
+		command += fmt.Sprintf(" --context '%v'", ctx.AnkhConfig.CurrentContextName)
+	}
+	command += " secrets view"
+	if ctx.AnkhFilePath != "" {
+		command += fmt.Sprintf(" --ankhfile '%v'", ctx.AnkhFilePath)
+	}
+	command += fmt.Sprintf(" '%v'", chart.InstanceName())
+	return []string{"-f", fmt.Sprintf("<(%v)", command)}
+}
+
+// Decrypts every secret source of a chart to a single values file. The file is
+// written outside of the data dir, readable only by the current user, and the
+// returned cleanup function removes it once helm has read it.
+func getValuesFromSecrets(ctx *ankh.ExecutionContext, chart ankh.Chart, namespace string) ([]string, func(), error) {
+	cleanup := func() {}
+	if len(chart.Secrets) == 0 {
+		return []string{}, cleanup, nil
+	}
+
+	if ctx.Mode == ankh.Explain {
+		return explainSecrets(ctx, chart), cleanup, nil
+	}
+
+	values, err := DecryptSecrets(ctx, chart, namespace)
+	if err != nil {
+		return []string{}, cleanup, err
+	}
+	ctx.SecretValues = append(ctx.SecretValues, secretStrings(values)...)
+	out, err := yaml.Marshal(values)
+	if err != nil {
+		return []string{}, cleanup, err
+	}
+
+	// TempDir creates the directory with mode 0700.
+	dir, err := ioutil.TempDir("", "ankh-secrets-")
+	if err != nil {
+		return []string{}, cleanup, err
+	}
+	cleanup = func() {
+		if err := os.RemoveAll(dir); err != nil {
+			ctx.Logger.Warnf("Unable to remove decrypted secrets in %v: %v", dir, err)
+		}
+	}
+
+	valuesPath := filepath.Join(dir, "secrets.yaml")
+	if err := ioutil.WriteFile(valuesPath, out, 0600); err != nil {
+		cleanup()
+		return []string{}, func() {}, err
+	}
+	return []string{"-f", valuesPath}, cleanup, nil
+}
@@ -0,0 +1,134 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+)
+
+func TestExpandSecretSource(t *testing.T) {
+	ctx := &ankh.ExecutionContext{Logger: logrus.New(), WorkingPath: "charts"}
+	vars := map[string]string{"ANKH_CONTEXT": "prod"}
+
+	source, err := expandSecretSource(ctx, ankh.SecretSource{Sops: "secrets/${ANKH_CONTEXT}.yaml"}, vars)
+	if err != nil || source.Sops != "charts/secrets/prod.yaml" {
+		t.Logf("got unexpected source %+v and error %v", source, err)
+		t.Fail()
+	}
+
+	source, err = expandSecretSource(ctx, ankh.SecretSource{Vault: "secret/${ANKH_CONTEXT}/foo"}, vars)
+	if err != nil || source.Vault != "secret/prod/foo" {
+		t.Logf("got unexpected source %+v and error %v", source, err)
+		t.Fail()
+	}
+
+	invalid := []ankh.SecretSource{
+		ankh.SecretSource{},
+		ankh.SecretSource{Key: "a"},
+		ankh.SecretSource{Sops: "a.yaml", Vault: "secret/a"},
+	}
+	for _, source := range invalid {
+		if _, err := expandSecretSource(ctx, source, vars); err == nil {
+			t.Logf("expected an error for source %+v", source)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseVaultSecret(t *testing.T) {
+	kv1 := `{"data": {"password": "hunter2"}}`
+	kv2 := `{"data": {"data": {"password": "hunter2"}, "metadata": {"version": 3}}}`
+	for _, out := range []string{kv1, kv2} {
+		values, err := parseVaultSecret([]byte(out))
+		if err != nil || values["password"] != "hunter2" {
+			t.Logf("got unexpected values %+v and error %v for %v", values, err, out)
+			t.Fail()
+		}
+	}
+}
+
+func TestGetValuesFromSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-secrets-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A fake sops that "decrypts" a file by printing it.
+	sops := "#!/bin/sh\nfor last; do :; done\ncat \"$last\"\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "sops"), []byte(sops), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	ioutil.WriteFile(filepath.Join(dir, "a.yaml"), []byte("db:\n  user: foo\n  password: a\n"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "b.yaml"), []byte("password: b\n"), 0600)
+
+	ctx := &ankh.ExecutionContext{Logger: logrus.New(), WorkingPath: dir, DataDir: dir}
+	chart := ankh.Chart{
+		Name: "foo",
+		Secrets: []ankh.SecretSource{
+			ankh.SecretSource{Sops: "a.yaml"},
+			ankh.SecretSource{Sops: "b.yaml", Key: "db"},
+		},
+	}
+
+	args, cleanup, err := getValuesFromSecrets(ctx, chart, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 2 || args[0] != "-f" || strings.HasPrefix(args[1], dir) {
+		t.Logf("expected a values file outside of the data dir but got %v", args)
+		t.Fail()
+	}
+
+	body, err := ioutil.ReadFile(args[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[interface{}]interface{}{}
+	yaml.Unmarshal(body, &values)
+	expected := map[interface{}]interface{}{
+		"db": map[interface{}]interface{}{"user": "foo", "password": "b"},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Logf("expected %v but got %v", expected, values)
+		t.Fail()
+	}
+
+	secretValues := append([]string{}, ctx.SecretValues...)
+	sort.Strings(secretValues)
+	if !reflect.DeepEqual(secretValues, []string{"b", "foo"}) {
+		t.Logf("expected the decrypted values to be kept for redaction but got %v", secretValues)
+		t.Fail()
+	}
+
+	cleanup()
+	if _, err := os.Stat(args[1]); !os.IsNotExist(err) {
+		t.Logf("expected decrypted values to be removed, but got %v", err)
+		t.Fail()
+	}
+}
+
+func TestWithoutArgs(t *testing.T) {
+	args := []string{"-f", "a.yaml", "-f", "secrets.yaml", "-f", "b.yaml"}
+	got := withoutArgs(args, []string{"-f", "secrets.yaml"})
+	if strings.Join(got, " ") != "-f a.yaml -f b.yaml" {
+		t.Logf("got unexpected args %v", got)
+		t.Fail()
+	}
+	if got := withoutArgs(args, []string{}); len(got) != len(args) {
+		t.Logf("got unexpected args %v", got)
+		t.Fail()
+	}
+}
@@ -0,0 +1,152 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// eg: `template: foo/templates/deployment.yaml:12:20: executing "foo/templates/deployment.yaml" at <.Values.image.tag>: nil pointer evaluating interface {}.tag`
+	executingErrorRegexp = regexp.MustCompile(`template: ([^:\s]+):(\d+):(\d+): executing "[^"]*" at <([^>]*)>: (.*)`)
+	// eg: `parse error at (foo/templates/deployment.yaml:12): unexpected "}" in operand`,
+	// or `execution error at (foo/templates/deployment.yaml:10:14): image.tag is required`
+	locationErrorRegexp = regexp.MustCompile(`(?:parse|execution) error at \(([^:\s)]+):(\d+)(?::(\d+))?\): (.*)`)
+	// eg: `YAML parse error on foo/templates/deployment.yaml: error converting YAML to JSON: yaml: line 12: did not find expected key`
+	yamlErrorRegexp = regexp.MustCompile(`YAML parse error on ([^:\s]+): .*`)
+
+	valuesRefRegexp  = regexp.MustCompile(`\.Values((?:\.[A-Za-z0-9_]+)+)`)
+	nilPointerRegexp = regexp.MustCompile(`nil pointer evaluating [^.]*\.([A-Za-z0-9_]+)`)
+)
+
+const templateSnippetContext = 2
+
+// The location of an error in a chart's templates, as reported by helm.
+type templateErrorLocation struct {
+	// The path of the template within the chart, eg: `templates/deployment.yaml`
+	File   string
+	Line   int
+	Column int
+	// The template expression being evaluated, eg: `.Values.image.tag`
+	Expression string
+	Message    string
+	// Whether Line is a line of the rendered template rather than its source
+	Rendered bool
+}
+
+func parseTemplateError(stderr string) (templateErrorLocation, bool) {
+	location := templateErrorLocation{}
+	if match := executingErrorRegexp.FindStringSubmatch(stderr); match != nil {
+		location.File = match[1]
+		location.Line, _ = strconv.Atoi(match[2])
+		location.Column, _ = strconv.Atoi(match[3])
+		location.Expression, location.Message = match[4], match[5]
+	} else if match := locationErrorRegexp.FindStringSubmatch(stderr); match != nil {
+		location.File = match[1]
+		location.Line, _ = strconv.Atoi(match[2])
+		location.Column, _ = strconv.Atoi(match[3])
+		location.Message = match[4]
+	} else if match := yamlErrorRegexp.FindStringSubmatch(stderr); match != nil {
+		location.File = match[1]
+		location.Message = match[0]
+		location.Rendered = true
+	} else {
+		return location, false
+	}
+
+	// Helm reports paths from the top-level chart's directory, eg: `foo/templates/...`.
+	if tokens := strings.SplitN(location.File, "/", 2); len(tokens) == 2 {
+		location.File = tokens[1]
+	}
+	return location, true
+}
+
+// Shows the lines around an error, with the failing line marked.
+func templateSnippet(path string, line int) string {
+	body, err := ioutil.ReadFile(path)
+	if err != nil || line < 1 {
+		return ""
+	}
+	lines := strings.Split(strings.TrimRight(string(body), "\n"), "\n")
+	if line > len(lines) {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	first, last := line-templateSnippetContext, line+templateSnippetContext
+	if first < 1 {
+		first = 1
+	}
+	if last > len(lines) {
+		last = len(lines)
+	}
+	width := len(strconv.Itoa(last))
+	for i := first; i <= last; i++ {
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+		fmt.Fprintf(&buf, "  %v %*d | %v\n", marker, width, i, lines[i-1])
+	}
+	return buf.String()
+}
+
+// Suggests which values key an error involves, eg: for `.Values.image.tag`
+// evaluated on a nil `image`.
+func valuesKeyHint(location templateErrorLocation) string {
+	match := valuesRefRegexp.FindStringSubmatch(location.Expression)
+	if match == nil {
+		match = valuesRefRegexp.FindStringSubmatch(location.Message)
+	}
+	if match == nil {
+		return ""
+	}
+	key := strings.TrimPrefix(match[1], ".")
+
+	missing := key
+	if nilPointer := nilPointerRegexp.FindStringSubmatch(location.Message); nilPointer != nil {
+		// Evaluating `.tag` on nil means its parent, eg: `image`, is not set.
+		if tokens := strings.Split(key, "."); len(tokens) > 1 && tokens[len(tokens)-1] == nilPointer[1] {
+			missing = strings.Join(tokens[:len(tokens)-1], ".")
+		}
+	}
+
+	return fmt.Sprintf("The template uses `.Values.%v`, so check that `%v` is set, eg: in the chart's "+
+		"`default-values` in the Ankh file, or with `--set %v=...`", key, missing, key)
+}
+
+// Explains a failed `helm template` from its stderr: where in the chart it
+// failed, the template around that line, and which value may be missing.
+// Returns an empty string when the error is not a template error.
+func explainTemplateError(chartName string, chartDir string, stderr string) string {
+	location, ok := parseTemplateError(stderr)
+	if !ok {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	where := location.File
+	if location.Line > 0 && !location.Rendered {
+		where += fmt.Sprintf(":%v", location.Line)
+		if location.Column > 0 {
+			where += fmt.Sprintf(":%v", location.Column)
+		}
+	}
+	fmt.Fprintf(&buf, "Chart \"%v\" failed to template at %v\n", chartName, where)
+
+	if location.Line > 0 && !location.Rendered {
+		buf.WriteString(templateSnippet(filepath.Join(chartDir, location.File), location.Line))
+	}
+	if location.Rendered {
+		buf.WriteString("The template rendered invalid YAML, so its line numbers refer to the rendered output. " +
+			"Check the indentation of values inserted there, eg: with `nindent`\n")
+	}
+	if hint := valuesKeyHint(location); hint != "" {
+		buf.WriteString(hint + "\n")
+	}
+	return buf.String()
+}
@@ -0,0 +1,83 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const deploymentTemplate string = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}
+spec:
+  template:
+    spec:
+      containers:
+        - image: "repo:{{ .Values.image.tag }}"
+`
+
+func TestParseTemplateError(t *testing.T) {
+	cases := []struct {
+		stderr   string
+		expected templateErrorLocation
+	}{
+		{
+			`Error: template: foo/templates/deployment.yaml:9:29: executing "foo/templates/deployment.yaml" at <.Values.image.tag>: nil pointer evaluating interface {}.tag`,
+			templateErrorLocation{File: "templates/deployment.yaml", Line: 9, Column: 29, Expression: ".Values.image.tag",
+				Message: "nil pointer evaluating interface {}.tag"},
+		},
+		{
+			`Error: parse error at (foo/templates/service.yaml:4): function "lower2" not defined`,
+			templateErrorLocation{File: "templates/service.yaml", Line: 4, Message: `function "lower2" not defined`},
+		},
+		{
+			`Error: execution error at (foo/charts/bar/templates/secret.yaml:3:12): password is required`,
+			templateErrorLocation{File: "charts/bar/templates/secret.yaml", Line: 3, Column: 12, Message: "password is required"},
+		},
+		{
+			`Error: YAML parse error on foo/templates/configmap.yaml: error converting YAML to JSON: yaml: line 7: did not find expected key`,
+			templateErrorLocation{File: "templates/configmap.yaml", Rendered: true,
+				Message: "YAML parse error on foo/templates/configmap.yaml: error converting YAML to JSON: yaml: line 7: did not find expected key"},
+		},
+	}
+	for _, c := range cases {
+		location, ok := parseTemplateError(c.stderr)
+		if !ok || location != c.expected {
+			t.Logf("expected %+v but got %+v for %v", c.expected, location, c.stderr)
+			t.Fail()
+		}
+	}
+
+	if _, ok := parseTemplateError("Error: chart \"foo\" not found"); ok {
+		t.Logf("expected no location for an error that is not a template error")
+		t.Fail()
+	}
+}
+
+func TestExplainTemplateError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-template-error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "templates"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "templates", "deployment.yaml"), []byte(deploymentTemplate), 0644)
+
+	stderr := `Error: template: foo/templates/deployment.yaml:9:29: executing "foo/templates/deployment.yaml" at <.Values.image.tag>: nil pointer evaluating interface {}.tag`
+	explanation := explainTemplateError("foo", dir, stderr)
+	for _, expected := range []string{
+		`Chart "foo" failed to template at templates/deployment.yaml:9:29`,
+		"   7 |     spec:",
+		`  > 9 |         - image: "repo:{{ .Values.image.tag }}"`,
+		"check that `image` is set",
+		"`--set image.tag=...`",
+	} {
+		if !strings.Contains(explanation, expected) {
+			t.Logf("expected %q in explanation:\n%v", expected, explanation)
+			t.Fail()
+		}
+	}
+}
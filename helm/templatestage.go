@@ -2,7 +2,10 @@ package helm
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -30,11 +33,51 @@ func (stage TemplateStage) Execute(ctx *ankh.ExecutionContext, input *string, na
 		return "", err
 	}
 
-	if len(ctx.Filters) > 0 {
-		ctx.Logger.Debugf("Filtering with inclusive list `%v`", ctx.Filters)
-		helmOutput = filterOutput(ctx.Filters, helmOutput)
+	if ctx.HasObjectFilters() {
+		ctx.Logger.Debugf("Filtering with kinds `%v`, name `%v` and labels `%v`", ctx.Filters, ctx.FilterName, ctx.FilterLabels)
+		helmOutput, err = filterOutput(ctx, helmOutput)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// With a post-renderer or a kustomize overlay, the output is finished once
+	// it has been through them.
+	if HasPostRenderer(ctx) || HasKustomizeOverlay(ctx) {
+		return helmOutput, nil
+	}
+	return finishRender(ctx, helmOutput, namespace)
+}
+
+// Annotates workloads with checksums of their config, when enabled, applies
+// the overrides given on the command line to the rendered output, and then
+// exports it, for `ankh template --export-dir`.
+func finishRender(ctx *ankh.ExecutionContext, output string, namespace string) (string, error) {
+	if ctx.AnkhConfig.Helm.ConfigChecksums && ctx.Mode != ankh.Explain {
+		var err error
+		output, err = injectConfigChecksums(ctx, output, namespace)
+		if err != nil {
+			return "", err
+		}
+	}
+	if (ctx.ForceReplicas != "" || ctx.ForceMaxUnavailable != "") && ctx.Mode != ankh.Explain {
+		var err error
+		output, err = overrideWorkloads(ctx, output)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// For `ankh template --export-dir`, write objects to files instead of stdout.
+	if ctx.Mode == ankh.Template && ctx.ExportDir != "" {
+		count, err := exportObjects(ctx, output, namespace)
+		if err != nil {
+			return "", fmt.Errorf("Unable to export objects to %v: %v", ctx.ExportDir, err)
+		}
+		ctx.Logger.Infof("Exported %v objects to %v", count, ctx.ExportDir)
+		return "", nil
 	}
-	return helmOutput, nil
+	return output, nil
 }
 
 func getDirectoryFile(ctx *ankh.ExecutionContext, chart ankh.Chart, files ankh.ChartFiles, kind string, match string) string {
@@ -57,6 +100,49 @@ func getDirectoryFile(ctx *ankh.ExecutionContext, chart ankh.Chart, files ankh.C
 
 }
 
+// getDirectoryResourceProfile returns the path of the resource profile's file,
+// when using a directory of files. A profile that `extends` another is
+// resolved into a file of its own, since helm only sees the one file.
+func getDirectoryResourceProfile(ctx *ankh.ExecutionContext, chart ankh.Chart, files ankh.ChartFiles, profile string) (string, error) {
+	path := getDirectoryFile(ctx, chart, files, "resource-profiles", profile)
+	if path == "" {
+		return "", nil
+	}
+
+	extended := false
+	values, err := util.ResolveProfile(profile, func(name string) (interface{}, error) {
+		path := getDirectoryFile(ctx, chart, files, "resource-profiles", name)
+		if path == "" {
+			return nil, nil
+		}
+		in, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		values := yaml.MapSlice{}
+		if err := yaml.Unmarshal(in, &values); err != nil {
+			return nil, err
+		}
+		if name != profile {
+			extended = true
+		}
+		return values, nil
+	})
+	if err != nil || !extended {
+		return path, err
+	}
+
+	resolvedPath := filepath.Join(files.TmpDir, "resource-profile.yaml")
+	out, err := yaml.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(resolvedPath, out, 0644); err != nil {
+		return "", err
+	}
+	return resolvedPath, nil
+}
+
 func getValuesFromChartFiles(ctx *ankh.ExecutionContext, chart ankh.Chart, files ankh.ChartFiles) ([]string, error) {
 	currentContext := ctx.AnkhConfig.CurrentContext
 	helmArgs := []string{}
@@ -82,14 +168,17 @@ func getValuesFromChartFiles(ctx *ankh.ExecutionContext, chart ankh.Chart, files
 
 	// Load `resource-profiles` from ankh-resource-profiles.yaml
 	if useDirectory {
-		path := getDirectoryFile(ctx, chart, files, "resource-profiles", currentContext.ResourceProfile)
+		path, err := getDirectoryResourceProfile(ctx, chart, files, currentContext.ResourceProfile)
+		if err != nil {
+			return []string{}, fmt.Errorf("unable to process resource profile '%s' for chart '%s': %v", currentContext.ResourceProfile, chart.Name, err)
+		}
 		if path != "" {
 			helmArgs = append(helmArgs, "-f", path)
 		}
 	} else {
 		_, resourceProfilesError := os.Stat(files.AnkhResourceProfilesPath)
 		if resourceProfilesError == nil {
-			if _, err := util.CreateReducedYAMLFile(files.AnkhResourceProfilesPath, currentContext.ResourceProfile, true); err != nil {
+			if _, err := util.CreateReducedProfileYAMLFile(files.AnkhResourceProfilesPath, currentContext.ResourceProfile, true); err != nil {
 				return []string{}, fmt.Errorf("unable to process ankh-resource-profiles.yaml file for chart '%s': %v", chart.Name, err)
 			}
 			helmArgs = append(helmArgs, "-f", files.AnkhResourceProfilesPath)
@@ -161,7 +250,9 @@ func getValuesFromChartObject(currentContext ankh.Context, chart ankh.Chart, out
 
 	// Load `resource-profiles`
 	if chart.ResourceProfiles != nil {
-		values, err := util.MapSliceRegexMatch(chart.ResourceProfiles, currentContext.ResourceProfile)
+		values, err := util.ResolveProfile(currentContext.ResourceProfile, func(name string) (interface{}, error) {
+			return util.MapSliceRegexMatch(chart.ResourceProfiles, name)
+		})
 		if err != nil {
 			return []string{}, fmt.Errorf("Failed to load `resource-profiles` for chart %v: %v", chart.Name, err)
 		}
@@ -228,6 +319,76 @@ func getValuesFromGlobal(currentContext ankh.Context, files ankh.ChartFiles) ([]
 	return helmArgs, nil
 }
 
+// DescribeValues lists where the values for templating a chart come from, in
+// order of increasing precedence, without fetching the chart. Files that live
+// in the chart are only used when the chart has them.
+func DescribeValues(ctx *ankh.ExecutionContext, chart ankh.Chart) []string {
+	currentContext := ctx.AnkhConfig.CurrentContext
+	sources := []string{}
+
+	chartFile := func(kind string, file string, selector string, match string) string {
+		if chart.ChartMeta.ConfigMeta.Type != "directory" {
+			return fmt.Sprintf("%v for %v \"%v\", if the chart has it", file, selector, match)
+		}
+		directory := chart.ChartMeta.ConfigMeta.Paths[kind]
+		if directory == "" {
+			directory = fmt.Sprintf("ankh/%v", kind)
+		}
+		return fmt.Sprintf("%v/%v.yaml, if the chart has it", directory, match)
+	}
+	sources = append(sources, chartFile("values", "ankh-values.yaml", "environment class", currentContext.EnvironmentClass))
+	if currentContext.ResourceProfile != "" {
+		sources = append(sources, chartFile("resource-profiles", "ankh-resource-profiles.yaml", "resource profile", currentContext.ResourceProfile))
+	}
+	if currentContext.Release != "" {
+		sources = append(sources, chartFile("releases", "ankh-releases.yaml", "release", currentContext.Release))
+	}
+
+	if chart.DefaultValues != nil {
+		sources = append(sources, "`default-values` of the chart")
+	}
+	if chart.Values != nil {
+		sources = append(sources, fmt.Sprintf("`values` of the chart for environment class \"%v\"", currentContext.EnvironmentClass))
+	}
+	if chart.ResourceProfiles != nil && currentContext.ResourceProfile != "" {
+		sources = append(sources, fmt.Sprintf("`resource-profiles` of the chart for resource profile \"%v\"", currentContext.ResourceProfile))
+	}
+	if chart.Releases != nil && currentContext.Release != "" {
+		sources = append(sources, fmt.Sprintf("`releases` of the chart for release \"%v\"", currentContext.Release))
+	}
+	for _, source := range chart.ValueSources {
+		sources = append(sources, "value source: "+describeValueSource(source))
+	}
+	for _, secret := range chart.Secrets {
+		if secret.Sops != "" {
+			sources = append(sources, "secret: sops "+secret.Sops)
+		} else {
+			sources = append(sources, "secret: vault "+secret.Vault)
+		}
+	}
+	if currentContext.Global != nil {
+		sources = append(sources, fmt.Sprintf("`global` of context \"%v\"", ctx.AnkhConfig.CurrentContextName))
+	}
+	if canary := chart.ChartMeta.Deploy.Canary; ctx.Mode == ankh.Deploy && canary != nil && canary.Values != "" {
+		sources = append(sources, fmt.Sprintf("%v, for the canary only", canary.Values))
+	}
+
+	// helm gives `--set` and friends precedence over every values file.
+	for _, key := range util.SortedKeys(ctx.HelmSetValues) {
+		sources = append(sources, "--set "+key)
+	}
+	for _, key := range util.SortedKeys(ctx.HelmSetStringValues) {
+		sources = append(sources, "--set-string "+key)
+	}
+	for _, key := range util.SortedKeys(ctx.HelmSetFiles) {
+		sources = append(sources, "--set-file "+key+"="+ctx.HelmSetFiles[key])
+	}
+	if chart.ChartMeta.TagKey != "" && chart.Tag != nil {
+		sources = append(sources, fmt.Sprintf("--set %v=%v", chart.ChartMeta.TagKey, chart.TagValue()))
+	}
+	return sources
+}
+
 func templateChart(ctx *ankh.ExecutionContext, chart ankh.Chart, namespace string) (string, error) {
 	currentContext := ctx.AnkhConfig.CurrentContext
 	helmArgs := []string{ctx.AnkhConfig.Helm.Command, "template"}
@@ -236,34 +397,52 @@ func templateChart(ctx *ankh.ExecutionContext, chart ankh.Chart, namespace strin
 		helmArgs = append(helmArgs, []string{"--namespace", namespace}...)
 	}
 
-	if currentContext.Release != "" {
+	if release := chart.ReleaseName(currentContext.Release); release != "" {
 		// Helm 2 used `--name` to set release name. Starting in Helm 3, this is a _positional_ argument.
 		// TODO: Remove HelmV2 logic when support fully dropped
 		if ctx.HelmV2 {
-			helmArgs = append(helmArgs, []string{"--name", currentContext.Release}...)
+			helmArgs = append(helmArgs, []string{"--name", release}...)
 		} else {
-			helmArgs = append(helmArgs, []string{currentContext.Release}...)
+			helmArgs = append(helmArgs, []string{release}...)
 		}
 	}
 
-	for key, val := range ctx.HelmSetValues {
-		helmArgs = append(helmArgs, "--set", key+"="+val)
+	for _, key := range util.SortedKeys(ctx.HelmSetValues) {
+		helmArgs = append(helmArgs, "--set", key+"="+ctx.HelmSetValues[key])
+	}
+	for _, key := range util.SortedKeys(ctx.HelmSetStringValues) {
+		helmArgs = append(helmArgs, "--set-string", key+"="+ctx.HelmSetStringValues[key])
+	}
+	for _, key := range util.SortedKeys(ctx.HelmSetFiles) {
+		helmArgs = append(helmArgs, "--set-file", key+"="+ctx.HelmSetFiles[key])
 	}
 
 	// Set tagKey=tagValue, if configured and present
 	if chart.ChartMeta.TagKey != "" && chart.Tag != nil {
 		ctx.Logger.Debugf("Setting helm value %v=%v since chart.ChartMeta.TagKey and chart.Tag are set",
-			chart.ChartMeta.TagKey, *chart.Tag)
-		helmArgs = append(helmArgs, "--set", chart.ChartMeta.TagKey+"="+*chart.Tag)
+			chart.ChartMeta.TagKey, chart.TagValue())
+		helmArgs = append(helmArgs, "--set", chart.ChartMeta.TagKey+"="+chart.TagValue())
 	}
 
-	repository := ctx.DetermineHelmRepository(&chart.HelmRepository)
+	repository, err := ctx.DetermineHelmRepository(&chart.HelmRepository)
+	if err != nil {
+		return "", err
+	}
 	files, err := findChartFiles(ctx, repository, chart)
 
 	if err != nil {
 		return "", err
 	}
 
+	if isLibraryChart(files.ChartDir) {
+		return "", fmt.Errorf("Chart \"%v\" is a library chart, which can only be used as a dependency of other charts", chart.InstanceName())
+	}
+
+	// Shared partials must be in place before helm reads the chart's templates.
+	if err := addPartials(ctx, chart, files.ChartDir); err != nil {
+		return "", fmt.Errorf("Unable to add partials to chart \"%v\": %v", chart.InstanceName(), err)
+	}
+
 	// Chart files first...
 	chartFileArgs, err := getValuesFromChartFiles(ctx, chart, files)
 	if err != nil {
@@ -278,6 +457,21 @@ func templateChart(ctx *ankh.ExecutionContext, chart ankh.Chart, namespace strin
 	}
 	helmArgs = append(helmArgs, chartObjectArgs...)
 
+	// ...then values fetched from the chart's value sources...
+	sourceArgs, err := getValuesFromSources(ctx, chart, namespace, files.TmpDir)
+	if err != nil {
+		return "", err
+	}
+	helmArgs = append(helmArgs, sourceArgs...)
+
+	// ...then decrypted secrets, which are removed once helm has run...
+	secretArgs, cleanupSecrets, err := getValuesFromSecrets(ctx, chart, namespace)
+	if err != nil {
+		return "", err
+	}
+	defer cleanupSecrets()
+	helmArgs = append(helmArgs, secretArgs...)
+
 	// ...and finally from global sources. These have the highest precedence.
 	globalArgs, err := getValuesFromGlobal(currentContext, files)
 	if err != nil {
@@ -285,6 +479,24 @@ func templateChart(ctx *ankh.ExecutionContext, chart ankh.Chart, namespace strin
 	}
 	helmArgs = append(helmArgs, globalArgs...)
 
+	// The canary phase of `deploy` uses the chart's canary values on top of all others.
+	if canary := chart.ChartMeta.Deploy.Canary; ctx.Canary && canary != nil && canary.Values != "" {
+		canaryValuesPath := filepath.Join(files.ChartDir, canary.Values)
+		if _, err := os.Stat(canaryValuesPath); err != nil {
+			return "", fmt.Errorf("Unable to use canary values \"%v\" of chart \"%v\": %v", canary.Values, chart.InstanceName(), err)
+		}
+		helmArgs = append(helmArgs, "-f", canaryValuesPath)
+	}
+
+	// For `ankh lint`, check the values that helm will use against the conventions.
+	if ctx.Mode == ankh.Lint && ctx.AnkhConfig.ValuesConventions.IsSet() {
+		values, err := mergedValues(files.ChartDir, helmArgs[2:])
+		if err != nil {
+			return "", fmt.Errorf("Unable to merge the values of chart \"%v\" to check them: %v", chart.InstanceName(), err)
+		}
+		ctx.LintErrors = append(ctx.LintErrors, lintValues(chart.InstanceName(), ctx.AnkhConfig.ValuesConventions, values)...)
+	}
+
 	// Construct the final helm command and run it
 	helmArgs = append(helmArgs, files.ChartDir)
 
@@ -299,23 +511,136 @@ func templateChart(ctx *ankh.ExecutionContext, chart ankh.Chart, namespace strin
 		return out, nil
 	}
 
+	// Contexts that share the same values render identical output, so only template once per run.
+	cacheKey, err := templateCacheKey(chart, repository, files.ChartDir, helmArgs[:len(helmArgs)-1])
+	if err != nil {
+		return "", err
+	}
+	var record string
+	if ctx.AnkhConfig.Helm.RecordRenders && (ctx.Mode == ankh.Apply || ctx.Mode == ankh.Deploy) {
+		// Decrypted secrets are never recorded, not even redacted.
+		recordArgs := withoutArgs(helmArgs[1:len(helmArgs)-1], secretArgs)
+		record, err = renderRecord(ctx, chart, repository, recordArgs)
+		if err != nil {
+			return "", fmt.Errorf("Unable to record how chart \"%v\" was rendered: %v", chart.Name, err)
+		}
+	}
+
+	if cached, ok := ctx.TemplateCache[cacheKey]; ok {
+		ctx.Logger.Debugf("Reusing previously templated output for chart \"%v\" since its values are unchanged", chart.Name)
+		return cached + record, nil
+	}
+
 	var stdout, stderr bytes.Buffer
 	helmCmd.Stdout = &stdout
 	helmCmd.Stderr = &stderr
 
+	span := ctx.Tracer.Start("helm template", map[string]string{"chart": chart.InstanceName(), "namespace": namespace})
 	err = helmCmd.Run()
+	span.End(err)
 	var helmOutput, helmError = string(stdout.Bytes()), string(stderr.Bytes())
 	if err != nil {
 		outputMsg := ""
 		if len(helmError) > 0 {
 			outputMsg = fmt.Sprintf(" -- the helm process had the following output on stderr:\n%s", helmError)
 		}
+		if explanation := explainTemplateError(chart.InstanceName(), files.ChartDir, helmError); explanation != "" {
+			outputMsg += "\n" + explanation
+		}
 		return "", fmt.Errorf("error running the helm command: %v%v", err, outputMsg)
 	}
 
-	return string(helmOutput), nil
+	if ctx.TemplateCache == nil {
+		ctx.TemplateCache = make(map[string]string)
+	}
+	ctx.TemplateCache[cacheKey] = helmOutput
+
+	return string(helmOutput) + record, nil
+}
+
+// Returns args without the first occurrence of the sequence remove.
+func withoutArgs(args []string, remove []string) []string {
+	if len(remove) == 0 {
+		return args
+	}
+	for i := 0; i+len(remove) <= len(args); i++ {
+		if strings.Join(args[i:i+len(remove)], "\x00") == strings.Join(remove, "\x00") {
+			return append(append([]string{}, args[:i]...), args[i+len(remove):]...)
+		}
+	}
+	return args
+}
+
+// Builds a key identifying the rendered output of a chart. The chart's files,
+// values files and `--set-file` files are hashed by content, since each
+// context writes them to its own directory, and local files may change while
+// a client or `ankh serve` keeps its cache.
+func templateCacheKey(chart ankh.Chart, repository string, chartDir string, helmArgs []string) (string, error) {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%v\x00%v\x00%v\x00", chart.Name, chart.Version, repository)
+	if err := hashDir(hash, chartDir); err != nil {
+		return "", err
+	}
+	for i, arg := range helmArgs {
+		switch {
+		case i > 0 && helmArgs[i-1] == "-f":
+			body, err := ioutil.ReadFile(arg)
+			if err != nil {
+				return "", err
+			}
+			hash.Write(body)
+		case i > 0 && helmArgs[i-1] == "--set-file":
+			key, path := arg, ""
+			if i := strings.Index(arg, "="); i >= 0 {
+				key, path = arg[:i], arg[i+1:]
+			}
+			body, err := ioutil.ReadFile(path)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(hash, "%v\x00", key)
+			hash.Write(body)
+		default:
+			hash.Write([]byte(arg))
+		}
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
+// Writes the relative path and contents of every file in a directory to a
+// hash, in lexical order.
+func hashDir(hash io.Writer, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hash, "%v\x00", filepath.ToSlash(rel))
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			// Only symlinks to files are followed, by their contents.
+			target, err := os.Stat(path)
+			if err != nil || !target.Mode().IsRegular() {
+				link, _ := os.Readlink(path)
+				fmt.Fprintf(hash, "%v\x00", link)
+				return nil
+			}
+			fallthrough
+		case info.Mode().IsRegular():
+			body, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			hash.Write(body)
+			hash.Write([]byte{0})
+		}
+		return nil
+	})
+}
 
 func helmTemplate(ctx *ankh.ExecutionContext, charts []ankh.Chart, namespace string) (string, error) {
 	finalOutput := ""
@@ -327,6 +652,9 @@ func helmTemplate(ctx *ankh.ExecutionContext, charts []ankh.Chart, namespace str
 			} else if chart.Path != "" {
 				extraString = fmt.Sprintf(" from path \"%v\"", chart.Path)
 			}
+			if chart.Alias != "" {
+				extraString += fmt.Sprintf(" as \"%v\"", chart.Alias)
+			}
 			ctx.Logger.Infof("Templating chart \"%s\"%s", chart.Name, extraString)
 			chartOutput, err := templateChart(ctx, chart, namespace)
 			if err != nil {
@@ -0,0 +1,168 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+func TestTemplateStage(t *testing.T) {
+	repository := ankhtest.NewChartRepository(t, ankhtest.Chart{Name: "foo", Version: "1.0.0",
+		Files: map[string]string{"templates/deployment.yaml": deploymentTemplate}})
+	tools := ankhtest.NewTools(t)
+	tools.Fake("helm",
+		ankhtest.Rule{Args: "template *--set broken=true*", ExitCode: 1,
+			Stderr: `Error: template: foo/templates/deployment.yaml:9:29: executing "foo/templates/deployment.yaml" at <.Values.image.tag>: nil pointer evaluating interface {}.tag`},
+		ankhtest.Rule{Args: "template *", Stdout: "kind: Deployment\nmetadata:\n  name: foo\n"},
+	)
+
+	ctx := ankhtest.NewContext(t)
+	ctx.AnkhConfig.Helm.Repository = repository.URL
+	charts := []ankh.Chart{ankh.Chart{Name: "foo", Version: "1.0.0"}}
+
+	out, err := NewTemplateStage(charts).Execute(ctx, nil, "web", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "name: foo") {
+		t.Logf("expected the output of helm template but got %q", out)
+		t.Fail()
+	}
+
+	calls := tools.Calls("helm")
+	if len(calls) != 1 || strings.Join(calls[0].Args[:3], " ") != "template --namespace web" {
+		t.Fatalf("expected a single call to helm template but got %+v", calls)
+	}
+	if chartDir := calls[0].Args[len(calls[0].Args)-1]; !strings.HasSuffix(chartDir, "/foo") {
+		t.Logf("expected the chart fetched from the repository to be templated, but got %v", chartDir)
+		t.Fail()
+	}
+	if requests := repository.Requests(); len(requests) != 1 || requests[0] != "/foo-1.0.0.tgz" {
+		t.Logf("expected a single request for the chart tarball but got %v", requests)
+		t.Fail()
+	}
+
+	// String and file values are passed through to helm as is.
+	ctx.HelmSetStringValues = map[string]string{"zip": "01234", "build": "1e3"}
+	configPath := filepath.Join(ctx.DataDir, "config.json")
+	if err := ioutil.WriteFile(configPath, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx.HelmSetFiles = map[string]string{"config": configPath}
+	if _, err := NewTemplateStage(charts).Execute(ctx, nil, "web", nil); err != nil {
+		t.Fatal(err)
+	}
+	calls = tools.Calls("helm")
+	if args := strings.Join(calls[len(calls)-1].Args, " "); !strings.Contains(args, "--set-string build=1e3 --set-string zip=01234 --set-file config="+configPath) {
+		t.Logf("expected --set-string and --set-file to be passed to helm but got %v", args)
+		t.Fail()
+	}
+	ctx.HelmSetStringValues = nil
+	ctx.HelmSetFiles = nil
+
+	// A failed template is explained from the fetched chart's files.
+	ctx.HelmSetValues = map[string]string{"broken": "true"}
+	_, err = NewTemplateStage(charts).Execute(ctx, nil, "web", nil)
+	if err == nil || !strings.Contains(err.Error(), `  > 9 |         - image: "repo:{{ .Values.image.tag }}"`) {
+		t.Logf("expected an explanation of the failed template but got %v", err)
+		t.Fail()
+	}
+}
+
+func TestTemplateCache(t *testing.T) {
+	repository := ankhtest.NewChartRepository(t, ankhtest.Chart{Name: "foo", Version: "1.0.0",
+		Files: map[string]string{"templates/deployment.yaml": deploymentTemplate}})
+	tools := ankhtest.NewTools(t)
+	tools.Fake("helm", ankhtest.Rule{Args: "template *", Stdout: "kind: Deployment\nmetadata:\n  name: foo\n"})
+
+	ctx := ankhtest.NewContext(t)
+	ctx.AnkhConfig.Helm.Repository = repository.URL
+	charts := []ankh.Chart{ankh.Chart{Name: "foo", Version: "1.0.0"}}
+	template := func() int {
+		if _, err := NewTemplateStage(charts).Execute(ctx, nil, "web", nil); err != nil {
+			t.Fatal(err)
+		}
+		return len(tools.Calls("helm"))
+	}
+
+	// --set values are passed in order, so the same values give the same key.
+	ctx.HelmSetValues = map[string]string{"e": "5", "a": "1", "d": "4", "b": "2", "c": "3"}
+	template()
+	for i := 0; i < 5; i++ {
+		if calls := template(); calls != 1 {
+			t.Fatalf("expected the same --set values to reuse the templated output, but helm ran %v times", calls)
+		}
+	}
+	if args := strings.Join(tools.Calls("helm")[0].Args, " "); !strings.Contains(args, "--set a=1 --set b=2 --set c=3 --set d=4 --set e=5") {
+		t.Logf("expected --set values to be passed in order but got %v", args)
+		t.Fail()
+	}
+
+	// Contexts that render with the same values share the templated output.
+	ctx.AnkhConfig.CurrentContextName = "other"
+	ctx.AnkhConfig.CurrentContext.KubeContext = "other"
+	if calls := template(); calls != 1 {
+		t.Logf("expected another context with the same values to reuse the templated output, but helm ran %v times", calls)
+		t.Fail()
+	}
+
+	// Files given with --set-file are keyed by their contents.
+	configPath := filepath.Join(ctx.DataDir, "config.json")
+	ioutil.WriteFile(configPath, []byte(`{"replicas": 1}`), 0644)
+	ctx.HelmSetFiles = map[string]string{"config": configPath}
+	before := template()
+	if calls := template(); calls != before {
+		t.Logf("expected an unchanged --set-file to reuse the templated output, but helm ran again")
+		t.Fail()
+	}
+	ioutil.WriteFile(configPath, []byte(`{"replicas": 2}`), 0644)
+	if calls := template(); calls != before+1 {
+		t.Logf("expected a changed --set-file to be templated again, but helm ran %v times", calls-before)
+		t.Fail()
+	}
+
+	// So are the files of charts from a local path.
+	chartDir := filepath.Join(ctx.DataDir, "local", "foo")
+	os.MkdirAll(filepath.Join(chartDir, "templates"), 0755)
+	ioutil.WriteFile(filepath.Join(chartDir, "Chart.yaml"), []byte("name: foo\nversion: 0.1.0\n"), 0644)
+	ioutil.WriteFile(filepath.Join(chartDir, "templates", "deployment.yaml"), []byte(deploymentTemplate), 0644)
+	charts = []ankh.Chart{ankh.Chart{Name: "foo", Path: chartDir}}
+	before = template()
+	if calls := template(); calls != before {
+		t.Logf("expected an unchanged local chart to reuse the templated output, but helm ran again")
+		t.Fail()
+	}
+	ioutil.WriteFile(filepath.Join(chartDir, "templates", "service.yaml"), []byte("kind: Service\n"), 0644)
+	if calls := template(); calls != before+1 {
+		t.Logf("expected a changed local chart to be templated again, but helm ran %v times", calls-before)
+		t.Fail()
+	}
+}
+
+func TestDescribeValues(t *testing.T) {
+	ctx := ankhtest.NewContext(t)
+	ctx.AnkhConfig.CurrentContext.Release = "blue"
+	ctx.HelmSetValues = map[string]string{"replicas": "2"}
+	chart := ankh.Chart{
+		Name:         "foo",
+		ValueSources: []ankh.ValueSource{{HTTP: "http://config/foo"}},
+	}
+	chart.ChartMeta.ConfigMeta.Type = "directory"
+
+	expected := []string{
+		"ankh/values/test.yaml, if the chart has it",
+		"ankh/resource-profiles/test.yaml, if the chart has it",
+		"ankh/releases/blue.yaml, if the chart has it",
+		"value source: http http://config/foo",
+		"--set replicas",
+	}
+	if sources := DescribeValues(ctx, chart); strings.Join(sources, "\n") != strings.Join(expected, "\n") {
+		t.Logf("expected values from:\n%v\nbut got:\n%v", strings.Join(expected, "\n"), strings.Join(sources, "\n"))
+		t.Fail()
+	}
+}
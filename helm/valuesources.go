@@ -0,0 +1,261 @@
+package helm
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+)
+
+const (
+	valueSourceFormatString = "string"
+	valueSourceFormatYaml   = "yaml"
+)
+
+// Variables available to value sources, as `${NAME}` in an HTTP URL or exec
+// argument, and as environment variables of exec'd commands.
+func valueSourceVars(ctx *ankh.ExecutionContext, chart ankh.Chart, namespace string) map[string]string {
+	currentContext := ctx.AnkhConfig.CurrentContext
+	return map[string]string{
+		"ANKH_CHART":             chart.Name,
+		"ANKH_CONTEXT":           ctx.AnkhConfig.CurrentContextName,
+		"ANKH_ENVIRONMENT_CLASS": currentContext.EnvironmentClass,
+		"ANKH_RESOURCE_PROFILE":  currentContext.ResourceProfile,
+		"ANKH_RELEASE":           currentContext.Release,
+		"ANKH_NAMESPACE":         namespace,
+	}
+}
+
+func expandValueSourceVars(s string, vars map[string]string) string {
+	for name, value := range vars {
+		s = strings.Replace(s, "${"+name+"}", value, -1)
+	}
+	return s
+}
+
+// Checks a value source, and expands variables in its URL or command.
+func expandValueSource(source ankh.ValueSource, vars map[string]string) (ankh.ValueSource, error) {
+	if (source.HTTP == "") == (len(source.Exec) == 0) {
+		return source, fmt.Errorf("value sources must set exactly one of `http` or `exec`")
+	}
+
+	switch source.Format {
+	case "", valueSourceFormatString:
+		source.Format = valueSourceFormatString
+		if source.Key == "" {
+			return source, fmt.Errorf("value sources with the `string` format must set `key`")
+		}
+	case valueSourceFormatYaml:
+		if source.Key != "" {
+			return source, fmt.Errorf("value sources with the `yaml` format are merged at the root, so they cannot set `key`")
+		}
+	default:
+		return source, fmt.Errorf("unknown value source format \"%v\", expected `string` or `yaml`", source.Format)
+	}
+
+	source.HTTP = expandValueSourceVars(source.HTTP, vars)
+	exec := []string{}
+	for _, arg := range source.Exec {
+		exec = append(exec, expandValueSourceVars(arg, vars))
+	}
+	source.Exec = exec
+	return source, nil
+}
+
+func describeValueSource(source ankh.ValueSource) string {
+	if source.HTTP != "" {
+		return "http " + source.HTTP
+	}
+	return "exec " + strings.Join(source.Exec, " ")
+}
+
+func fetchValueSource(ctx *ankh.ExecutionContext, source ankh.ValueSource, vars map[string]string) (string, error) {
+	if source.HTTP != "" {
+		tr := &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: source.InsecureSkipVerify},
+		}
+		client := &http.Client{
+			Transport: ctx.Tracer.Transport(tr),
+			Timeout:   time.Duration(10 * time.Second),
+		}
+		resp, err := client.Get(source.HTTP)
+		if err != nil {
+			return "", fmt.Errorf("got an error %v when trying to call %v", err, source.HTTP)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 200 {
+			return "", fmt.Errorf("Received HTTP status '%v' (code %v) when trying to call %s", resp.Status, resp.StatusCode, source.HTTP)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	cmd := exec.Command(source.Exec[0], source.Exec[1:]...)
+	cmd.Dir = ctx.WorkingPath
+	cmd.Env = os.Environ()
+	for name, value := range vars {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	span := ctx.Tracer.Start("value source", map[string]string{"command": strings.Join(source.Exec, " ")})
+	err := cmd.Run()
+	span.End(err)
+	if err != nil {
+		outputMsg := ""
+		if stderr.Len() > 0 {
+			outputMsg = fmt.Sprintf(" -- the command had the following output on stderr:\n%s", stderr.String())
+		}
+		return "", fmt.Errorf("error running `%v`: %v%v", strings.Join(source.Exec, " "), err, outputMsg)
+	}
+	return stdout.String(), nil
+}
+
+// Fetches a value source once per run. Sources that refer to variables are
+// fetched again for each context or namespace that changes them.
+func fetchValueSourceCached(ctx *ankh.ExecutionContext, source ankh.ValueSource, vars map[string]string) (string, error) {
+	key := describeValueSource(source)
+	if len(source.Exec) > 0 {
+		// Commands may depend on the variables in their environment.
+		names := []string{}
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			key += "\x00" + vars[name]
+		}
+	} else if source.InsecureSkipVerify {
+		// A verified fetch must not reuse the output of an unverified one.
+		key += "\x00insecure"
+	}
+
+	if out, ok := ctx.ValueSourceCache[key]; ok {
+		return out, nil
+	}
+
+	ctx.Logger.Debugf("Fetching values from %v", describeValueSource(source))
+	out, err := fetchValueSource(ctx, source, vars)
+	if err != nil {
+		return "", err
+	}
+
+	if ctx.ValueSourceCache == nil {
+		ctx.ValueSourceCache = make(map[string]string)
+	}
+	ctx.ValueSourceCache[key] = out
+	return out, nil
+}
+
+// Sets a dotted key, eg: `ingress.certArn`, in nested values.
+func setNestedValue(values map[interface{}]interface{}, key string, value interface{}) {
+	parts := strings.Split(key, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := values[part].(map[interface{}]interface{})
+		if !ok {
+			next = make(map[interface{}]interface{})
+			values[part] = next
+		}
+		values = next
+	}
+	values[parts[len(parts)-1]] = value
+}
+
+// Merges src into dst, recursively, with src taking precedence.
+func mergeValues(dst map[interface{}]interface{}, src map[interface{}]interface{}) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[interface{}]interface{})
+		dstMap, dstIsMap := dst[key].(map[interface{}]interface{})
+		if srcIsMap && dstIsMap {
+			mergeValues(dstMap, srcMap)
+		} else {
+			dst[key] = value
+		}
+	}
+}
+
+// In explain mode, sources are not fetched. Instead, the explanation shows how
+// to fetch them, which also keeps their values out of the output.
+func explainValueSource(source ankh.ValueSource) []string {
+	command := ""
+	if source.HTTP != "" {
+		command = fmt.Sprintf("curl -sSL '%v'", source.HTTP)
+	} else {
+		quoted := []string{}
+		for _, arg := range source.Exec {
+			quoted = append(quoted, "'"+strings.Replace(arg, "'", `'\''`, -1)+"'")
+		}
+		command = strings.Join(quoted, " ")
+	}
+
+	if source.Format == valueSourceFormatYaml {
+		return []string{"-f", fmt.Sprintf("<(%v)", command)}
+	}
+	return []string{"--set-string", fmt.Sprintf("%v=\"$(%v)\"", source.Key, command)}
+}
+
+// Fetches every value source of a chart, and writes their values to a single
+// values file. Later sources take precedence over earlier ones.
+func getValuesFromSources(ctx *ankh.ExecutionContext, chart ankh.Chart, namespace string, tmpDir string) ([]string, error) {
+	if len(chart.ValueSources) == 0 {
+		return []string{}, nil
+	}
+
+	vars := valueSourceVars(ctx, chart, namespace)
+	helmArgs := []string{}
+	values := make(map[interface{}]interface{})
+	for i, source := range chart.ValueSources {
+		source, err := expandValueSource(source, vars)
+		if err != nil {
+			return []string{}, fmt.Errorf("Invalid value source %v of chart \"%v\": %v", i, chart.Name, err)
+		}
+
+		if ctx.Mode == ankh.Explain {
+			helmArgs = append(helmArgs, explainValueSource(source)...)
+			continue
+		}
+
+		out, err := fetchValueSourceCached(ctx, source, vars)
+		if err != nil {
+			return []string{}, fmt.Errorf("Unable to get values for chart \"%v\" from %v: %v", chart.Name, describeValueSource(source), err)
+		}
+
+		if source.Format == valueSourceFormatYaml {
+			sourceValues := make(map[interface{}]interface{})
+			if err := yaml.Unmarshal([]byte(out), &sourceValues); err != nil {
+				return []string{}, fmt.Errorf("Unable to parse values for chart \"%v\" from %v as YAML: %v", chart.Name, describeValueSource(source), err)
+			}
+			mergeValues(values, sourceValues)
+		} else {
+			setNestedValue(values, source.Key, strings.TrimSpace(out))
+		}
+	}
+
+	if ctx.Mode == ankh.Explain {
+		return helmArgs, nil
+	}
+
+	out, err := yaml.Marshal(values)
+	if err != nil {
+		return []string{}, err
+	}
+	valuesPath := filepath.Join(tmpDir, "value-sources.yaml")
+	if err := ioutil.WriteFile(valuesPath, out, 0644); err != nil {
+		return []string{}, err
+	}
+	return []string{"-f", valuesPath}, nil
+}
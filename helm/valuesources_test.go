@@ -0,0 +1,136 @@
+package helm
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+)
+
+func TestExpandValueSource(t *testing.T) {
+	vars := map[string]string{"ANKH_CONTEXT": "prod"}
+
+	source, err := expandValueSource(ankh.ValueSource{Key: "certArn", HTTP: "https://certs/${ANKH_CONTEXT}"}, vars)
+	if err != nil || source.HTTP != "https://certs/prod" || source.Format != "string" {
+		t.Logf("got unexpected source %+v and error %v", source, err)
+		t.Fail()
+	}
+
+	invalid := []ankh.ValueSource{
+		ankh.ValueSource{Key: "a"},
+		ankh.ValueSource{Key: "a", HTTP: "https://a", Exec: []string{"echo"}},
+		ankh.ValueSource{HTTP: "https://a"},
+		ankh.ValueSource{Key: "a", HTTP: "https://a", Format: "yaml"},
+		ankh.ValueSource{Key: "a", HTTP: "https://a", Format: "toml"},
+	}
+	for _, source := range invalid {
+		if _, err := expandValueSource(source, vars); err == nil {
+			t.Logf("expected an error for source %+v", source)
+			t.Fail()
+		}
+	}
+}
+
+func TestGetValuesFromSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-value-sources")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte("features:\n  search: true\n  checkout: false\n"))
+	}))
+	defer server.Close()
+
+	ctx := &ankh.ExecutionContext{Logger: logrus.New()}
+	ctx.AnkhConfig.CurrentContextName = "prod"
+	chart := ankh.Chart{
+		Name: "foo",
+		ValueSources: []ankh.ValueSource{
+			ankh.ValueSource{HTTP: server.URL, Format: "yaml"},
+			ankh.ValueSource{Key: "ingress.certArn", Exec: []string{"sh", "-c", "echo arn-for-$ANKH_CONTEXT-${ANKH_NAMESPACE}"}},
+			ankh.ValueSource{Key: "features.checkout", Exec: []string{"echo", "${ANKH_NAMESPACE}"}},
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		args, err := getValuesFromSources(ctx, chart, "bar", dir)
+		if err != nil {
+			t.Logf("got error %v", err)
+			t.FailNow()
+		}
+		if len(args) != 2 || args[0] != "-f" {
+			t.Logf("got unexpected args %v", args)
+			t.FailNow()
+		}
+
+		body, _ := ioutil.ReadFile(args[1])
+		values := struct {
+			Features map[string]interface{}
+			Ingress  map[string]string
+		}{}
+		if err := yaml.Unmarshal(body, &values); err != nil {
+			t.Fatal(err)
+		}
+		if values.Ingress["certArn"] != "arn-for-prod-bar" || values.Features["search"] != true || values.Features["checkout"] != "bar" {
+			t.Logf("got unexpected values %v", string(body))
+			t.Fail()
+		}
+	}
+
+	if requests != 1 {
+		t.Logf("expected the HTTP source to be fetched once, but it was fetched %v times", requests)
+		t.Fail()
+	}
+
+	t.Run("explain", func(t *testing.T) {
+		ctx.Mode = ankh.Explain
+		defer func() { ctx.Mode = "" }()
+
+		args, err := getValuesFromSources(ctx, chart, "bar", dir)
+		if err != nil {
+			t.Logf("got error %v", err)
+			t.FailNow()
+		}
+		explained := strings.Join(args, " ")
+		if strings.Contains(explained, "arn-for-prod") {
+			t.Logf("expected values to be left out of the explanation, but got %v", explained)
+			t.Fail()
+		}
+		if !strings.Contains(explained, `--set-string features.checkout="$('echo' 'bar')"`) {
+			t.Logf("got unexpected explanation %v", explained)
+			t.Fail()
+		}
+	})
+}
+
+func TestFetchValueSourceVerifiesTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("arn"))
+	}))
+	defer server.Close()
+
+	ctx := &ankh.ExecutionContext{Logger: logrus.New()}
+	source := ankh.ValueSource{Key: "ingress.certArn", HTTP: server.URL}
+	if _, err := fetchValueSource(ctx, source, map[string]string{}); err == nil {
+		t.Logf("expected an error fetching from a server with an untrusted certificate")
+		t.Fail()
+	}
+
+	source.InsecureSkipVerify = true
+	value, err := fetchValueSource(ctx, source, map[string]string{})
+	if err != nil || value != "arn" {
+		t.Logf("expected `arn` with insecureSkipVerify but got %q, %v", value, err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,104 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/util"
+)
+
+// The environment class in which charts that fail verification are refused,
+// rather than used with a warning.
+const verifiedEnvironmentClass = "production"
+
+// Whether charts fetched for the current context must be verified, and not
+// merely checked.
+func requiresVerifiedCharts(ctx *ankh.ExecutionContext) bool {
+	return ctx.AnkhConfig.Helm.Verify && ctx.AnkhConfig.CurrentContext.EnvironmentClass == verifiedEnvironmentClass
+}
+
+// Refuses an unverified chart in production contexts, and warns about it
+// everywhere else.
+func unverifiedChart(ctx *ankh.ExecutionContext, chart ankh.Chart, reason error) error {
+	if requiresVerifiedCharts(ctx) {
+		return fmt.Errorf("Refusing to use chart '%v' at version '%v' in a context with environment-class '%v', since it could not be verified: %v",
+			chart.Name, chart.Version, verifiedEnvironmentClass, reason)
+	}
+	ctx.Logger.Warnf("Using chart '%v' at version '%v', which could not be verified: %v", chart.Name, chart.Version, reason)
+	return nil
+}
+
+func fetchProvenance(ctx *ankh.ExecutionContext, client *http.Client, repository string, provURL string, path string) error {
+	req, err := newRepositoryRequest(ctx, "GET", provURL, repository)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("no provenance file at URL %v (HTTP status '%v')", provURL, resp.Status)
+	}
+
+	prov, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, prov, 0644)
+}
+
+// Runs `helm verify` on a tarball, with its provenance file next to it.
+func verifyTarball(ctx *ankh.ExecutionContext, tarballPath string) error {
+	args := []string{"verify", tarballPath}
+	if ctx.AnkhConfig.Helm.Keyring != "" {
+		args = append(args, "--keyring", ctx.AnkhConfig.Helm.Keyring)
+	}
+	helmCmd := execContext(ctx.AnkhConfig.Helm.Command, args...)
+	var stderr bytes.Buffer
+	helmCmd.Stderr = &stderr
+
+	ctx.Logger.Debugf("Running command %v", helmCmd)
+	if err := helmCmd.Run(); err != nil {
+		return fmt.Errorf("helm verify failed: %v", strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// Downloads the provenance file of a fetched chart tarball, and verifies the
+// tarball against it before extracting it to dir.
+func untarVerifiedChart(ctx *ankh.ExecutionContext, client *http.Client, repository string, chart ankh.Chart, tarballURL string, tarball io.Reader, dir string) error {
+	tarballPath := filepath.Join(dir, filepath.Base(tarballURL))
+	body, err := ioutil.ReadAll(tarball)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(tarballPath, body, 0644); err != nil {
+		return err
+	}
+	defer os.Remove(tarballPath)
+	defer os.Remove(tarballPath + ".prov")
+
+	err = fetchProvenance(ctx, client, repository, tarballURL+".prov", tarballPath+".prov")
+	if err == nil {
+		err = verifyTarball(ctx, tarballPath)
+	}
+	if err != nil {
+		if err := unverifiedChart(ctx, chart, err); err != nil {
+			return err
+		}
+	} else {
+		ctx.Logger.Debugf("Verified chart '%v' at version '%v'", chart.Name, chart.Version)
+	}
+
+	ctx.Logger.Debugf("untarring chart to %s", dir)
+	return util.Untar(dir, bytes.NewReader(body))
+}
@@ -0,0 +1,66 @@
+package helm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+func TestFetchVerifiedChart(t *testing.T) {
+	repository := ankhtest.NewChartRepository(t,
+		ankhtest.Chart{Name: "signed", Version: "1.0.0", Provenance: "-----BEGIN PGP SIGNED MESSAGE-----\n"},
+		ankhtest.Chart{Name: "unsigned", Version: "1.0.0"},
+	)
+	tools := ankhtest.NewTools(t)
+	tools.Fake("helm",
+		ankhtest.Rule{Args: "verify */signed-1.0.0.tgz --keyring /keys/pubring.gpg", Stdout: "Signed by: Release Team\n"},
+		ankhtest.Rule{Args: "verify *", Stderr: "Error: openpgp: signature made by unknown entity\n", ExitCode: 1},
+	)
+	ctx := ankhtest.NewContext(t)
+	ctx.AnkhConfig.Helm.Verify = true
+	ctx.AnkhConfig.Helm.Keyring = "/keys/pubring.gpg"
+
+	files, err := findChartFiles(ctx, repository.URL, ankh.Chart{Name: "signed", Version: "1.0.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(files.ChartDir, "Chart.yaml")); err != nil {
+		t.Logf("expected the verified chart to be extracted: %v", err)
+		t.Fail()
+	}
+	if calls := tools.Calls("helm"); len(calls) != 1 {
+		t.Logf("expected the chart to be verified once but got %+v", calls)
+		t.Fail()
+	}
+
+	// Outside of production, unverified charts are used with a warning.
+	if _, err := findChartFiles(ctx, repository.URL, ankh.Chart{Name: "unsigned", Version: "1.0.0"}); err != nil {
+		t.Logf("expected an unverified chart to be used outside of production, but got %v", err)
+		t.Fail()
+	}
+
+	ctx.AnkhConfig.CurrentContext.EnvironmentClass = "production"
+	if _, err := findChartFiles(ctx, repository.URL, ankh.Chart{Name: "signed", Version: "1.0.0"}); err != nil {
+		t.Logf("expected a verified chart to be used in production, but got %v", err)
+		t.Fail()
+	}
+	_, err = findChartFiles(ctx, repository.URL, ankh.Chart{Name: "unsigned", Version: "1.0.0"})
+	if err == nil || !strings.Contains(err.Error(), "could not be verified") {
+		t.Logf("expected an unverified chart to be refused in production, but got %v", err)
+		t.Fail()
+	}
+
+	ctx.AnkhConfig.Helm.Verify = false
+	if _, err := findChartFiles(ctx, repository.URL, ankh.Chart{Name: "unsigned", Version: "1.0.0"}); err != nil {
+		t.Logf("expected charts not to be verified without helm.verify, but got %v", err)
+		t.Fail()
+	}
+	if calls := tools.Calls("helm"); len(calls) != 2 {
+		t.Logf("expected helm verify to run only for charts with a provenance file but got %+v", calls)
+		t.Fail()
+	}
+}
@@ -2,7 +2,6 @@ package jira
 
 import (
 	"fmt"
-	"log"
 	"strings"
 
 	jira "github.com/andygrunwald/go-jira"
@@ -38,13 +37,13 @@ func CreateJiraTicket(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) error
 		chart := &ankhFile.Charts[i]
 		summary, err := getSummary(ctx, chart, envOrContext)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		} else {
 			summaries = append(summaries, summary)
 		}
 		description, err := getDescription(ctx, chart, envOrContext)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		} else {
 			descriptions = append(descriptions, description)
 		}
@@ -54,7 +53,7 @@ func CreateJiraTicket(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) error
 
 	jiraClient, err := jira.NewClient(tp.Client(), base)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	i := jira.Issue{
 		Fields: &jira.IssueFields{
@@ -150,7 +149,7 @@ func getSummary(ctx *ankh.ExecutionContext, chart *ankh.Chart, envOrContext stri
 	}
 
 	if format != "" {
-		message, err := util.NotificationString(format, chart, envOrContext)
+		message, err := util.NotificationString(format, chart, envOrContext, ctx.EffectiveRelease(), ctx.EffectiveDeployer())
 		if err != nil {
 			ctx.Logger.Infof("Unable to use format: '%v'. Will prompt for subject", format)
 		} else {
@@ -180,7 +179,7 @@ func getDescription(ctx *ankh.ExecutionContext, chart *ankh.Chart, envOrContext
 	}
 
 	if format != "" {
-		message, err := util.NotificationString(format, chart, envOrContext)
+		message, err := util.NotificationString(format, chart, envOrContext, ctx.EffectiveRelease(), ctx.EffectiveDeployer())
 		if err != nil {
 			ctx.Logger.Infof("Unable to use format: '%v'. Will prompt for description", format)
 		} else {
@@ -203,9 +202,10 @@ func promptForSummary(ctx *ankh.ExecutionContext, chart *ankh.Chart, envOrContex
 		version = *chart.Tag
 	}
 
-	defaultSummary := fmt.Sprintf("Deployment of %s chart %s verson %s to *%s*", chart.Name, chart.Version, version, envOrContext)
+	target := util.TargetWithRelease(envOrContext, ctx.EffectiveRelease())
+	defaultSummary := fmt.Sprintf("Deployment of %s chart %s verson %s to *%s*", chart.Name, chart.Version, version, target)
 	if ctx.Mode == ankh.Rollback {
-		defaultSummary = fmt.Sprintf("Rollback of %s in *%s*", chart.Name, envOrContext)
+		defaultSummary = fmt.Sprintf("Rollback of %s in *%s*", chart.Name, target)
 	}
 
 	message, err := util.PromptForInput(defaultSummary, "Jira Summary")
@@ -222,9 +222,21 @@ func promptForDescription(ctx *ankh.ExecutionContext, chart *ankh.Chart, envOrCo
 		version = *chart.Tag
 	}
 
-	defaultSubject := fmt.Sprintf("Ticket to track the deployment of %s chart %s verson %s to *%s*", chart.Name, chart.Version, version, envOrContext)
+	target := util.TargetWithRelease(envOrContext, ctx.EffectiveRelease())
+	defaultSubject := fmt.Sprintf("Ticket to track the deployment of %s chart %s verson %s to *%s*", chart.Name, chart.Version, version, target)
 	if ctx.Mode == ankh.Rollback {
-		defaultSubject = fmt.Sprintf("Ticket to track the rollback of %s in *%s*", chart.Name, envOrContext)
+		defaultSubject = fmt.Sprintf("Ticket to track the rollback of %s in *%s*", chart.Name, target)
+	}
+	if chart.ImageDigest != "" {
+		defaultSubject += fmt.Sprintf("\nImage digest: %s", chart.ImageDigest)
+	}
+	if entry := chart.CatalogEntry; entry != nil {
+		if entry.Owner != "" {
+			defaultSubject += fmt.Sprintf("\nOwner: %s", entry.Owner)
+		}
+		if entry.Description != "" {
+			defaultSubject += fmt.Sprintf("\nService: %s", entry.Description)
+		}
 	}
 
 	message, err := util.PromptForInput(defaultSubject, "Jira Description")
@@ -0,0 +1,198 @@
+package kubectl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+)
+
+// AdmissionPreviewStage submits each object to the API server with a server-side
+// dry-run, so that admission webhooks (eg: OPA Gatekeeper, Kyverno) evaluate
+// every object. Rejections are collected into a single report rather than
+// stopping at the first one.
+type AdmissionPreviewStage struct{}
+
+func NewAdmissionPreviewStage() plan.Stage {
+	return &AdmissionPreviewStage{}
+}
+
+type admissionObject struct {
+	Name string
+	Key  string
+	YAML string
+}
+
+type AdmissionRejection struct {
+	Object  string
+	Webhook string
+	Message string
+}
+
+var (
+	admissionWebhookRegexp = regexp.MustCompile(`(?s)admission webhook "([^"]+)" denied the request:\s*(.*)`)
+	serverErrorRegexp      = regexp.MustCompile(`(?s)^Error from server(?: \([^)]*\))?:\s*(?:error when [a-z]+ "[^"]*":\s*)?(.*)`)
+)
+
+// Splits templated yaml into one document per Kubernetes object.
+func splitKubeObjects(input string) ([]admissionObject, error) {
+	objects := []admissionObject{}
+	decoder := yaml.NewDecoder(strings.NewReader(input))
+	for {
+		doc := yaml.MapSlice{}
+		err := decoder.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		out, err := yaml.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		obj := KubeObject{}
+		if err := yaml.Unmarshal(out, &obj); err != nil {
+			return nil, err
+		}
+		if obj.Kind == "" {
+			continue
+		}
+
+		objects = append(objects, admissionObject{
+			Name: fmt.Sprintf("%v/%v", strings.ToLower(obj.Kind), obj.Metadata.Name),
+			Key:  objectKey(obj.Kind, obj.Metadata.Name),
+			YAML: string(out),
+		})
+	}
+	return objects, nil
+}
+
+// Extracts the webhook and reason from kubectl's error output. Errors that did
+// not come from an admission webhook are reported with an empty webhook.
+func parseAdmissionError(stderr string) (string, string) {
+	stderr = strings.TrimSpace(stderr)
+	if match := admissionWebhookRegexp.FindStringSubmatch(stderr); match != nil {
+		return match[1], strings.TrimSpace(match[2])
+	}
+	if match := serverErrorRegexp.FindStringSubmatch(stderr); match != nil {
+		return "", strings.TrimSpace(match[1])
+	}
+	return "", stderr
+}
+
+func formatAdmissionReport(rejections []AdmissionRejection, total int) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%v of %v objects would be rejected:\n", len(rejections), total)
+
+	counts := map[string]int{}
+	webhooks := []string{}
+	for _, rejection := range rejections {
+		webhook := rejection.Webhook
+		if webhook == "" {
+			webhook = "(api server)"
+		}
+		if counts[webhook] == 0 {
+			webhooks = append(webhooks, webhook)
+		}
+		counts[webhook]++
+
+		fmt.Fprintf(&buf, "\n%v\n", rejection.Object)
+		if rejection.Webhook != "" {
+			fmt.Fprintf(&buf, "  denied by admission webhook \"%v\":\n", rejection.Webhook)
+		} else {
+			fmt.Fprintf(&buf, "  rejected by the api server:\n")
+		}
+		for _, line := range strings.Split(rejection.Message, "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			fmt.Fprintf(&buf, "    %v\n", strings.TrimRight(line, " "))
+		}
+	}
+
+	totals := []string{}
+	for _, webhook := range webhooks {
+		totals = append(totals, fmt.Sprintf("%d by %v", counts[webhook], webhook))
+	}
+	fmt.Fprintf(&buf, "\nRejected %v\n", strings.Join(totals, ", "))
+	return buf.String()
+}
+
+func newAdmissionPreviewCommand(ctx *ankh.ExecutionContext, namespace string) plan.Command {
+	cmd := newKubectlCommand(ctx, namespace)
+	cmd.AddArguments([]string{"apply", "--dry-run=server", "-f", "-"})
+	cmd.AddArguments(applyArgs(ctx))
+	cmd.AddArguments(ctx.ExtraArgs)
+	return cmd
+}
+
+func (stage *AdmissionPreviewStage) Execute(ctx *ankh.ExecutionContext, input *string, namespace string, wildCardLabels []string) (string, error) {
+	if input == nil {
+		panic("kubectl cannot execute on nil input")
+	}
+
+	objects, err := splitKubeObjects(*input)
+	if err != nil {
+		return "", fmt.Errorf("Unable to parse templated objects: %v", err)
+	}
+
+	// Submit every object at once. kubectl carries on past objects that are
+	// rejected, so only those missing from its output need to be submitted again,
+	// individually, to attribute each rejection to an object.
+	items := []BatchItem{}
+	for _, obj := range objects {
+		items = append(items, BatchItem{Name: obj.Name, Manifest: obj.YAML})
+	}
+	cmd := newAdmissionPreviewCommand(ctx, namespace)
+	combined := combineManifests(items)
+	_, batchErr := cmd.Run(ctx, &combined)
+	if batchErr == nil {
+		ctx.Logger.Infof("All %v objects passed admission", len(objects))
+		return "", nil
+	}
+
+	admitted := make(map[string]bool)
+	for _, result := range parseApplyOutput(cmd.Stdout(), "").Results {
+		admitted[objectKeyFromOutput(result.Object)] = true
+	}
+
+	rejections := []AdmissionRejection{}
+	for _, obj := range objects {
+		if admitted[obj.Key] {
+			continue
+		}
+
+		ctx.Logger.Debugf("Previewing admission of %v", obj.Name)
+		cmd := newAdmissionPreviewCommand(ctx, namespace)
+		objYAML := obj.YAML
+		if _, err := cmd.Run(ctx, &objYAML); err != nil {
+			stderr := cmd.Stderr()
+			if stderr == "" {
+				stderr = err.Error()
+			}
+			webhook, message := parseAdmissionError(stderr)
+			rejections = append(rejections, AdmissionRejection{
+				Object:  obj.Name,
+				Webhook: webhook,
+				Message: message,
+			})
+		}
+	}
+
+	if len(rejections) == 0 {
+		// Nothing was rejected individually, so the combined submission failed for another reason.
+		return "", batchErr
+	}
+
+	fmt.Print(formatAdmissionReport(rejections, len(objects)))
+	return "", fmt.Errorf("%v of %v objects would be rejected at admission", len(rejections), len(objects))
+}
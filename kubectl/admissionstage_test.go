@@ -0,0 +1,55 @@
+package kubectl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitKubeObjects(t *testing.T) {
+	objects, err := splitKubeObjects(`---
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+---
+# Source: empty.yaml
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+spec:
+  replicas: 1
+`)
+	if err != nil {
+		t.Logf("got error %v", err)
+		t.FailNow()
+	}
+	if len(objects) != 2 || objects[0].Name != "service/foo" || objects[1].Name != "deployment/foo" {
+		t.Logf("expected service/foo and deployment/foo but got %+v", objects)
+		t.FailNow()
+	}
+	if !strings.Contains(objects[1].YAML, "replicas: 1") || strings.Contains(objects[1].YAML, "kind: Service") {
+		t.Logf("unexpected yaml for deployment/foo: %v", objects[1].YAML)
+		t.Fail()
+	}
+}
+
+func TestParseAdmissionError(t *testing.T) {
+	t.Run("webhook", func(t *testing.T) {
+		webhook, message := parseAdmissionError(`Error from server (Forbidden): error when creating "STDIN": admission webhook "validation.gatekeeper.sh" denied the request: [psp-privileged] Privileged container is not allowed: nginx
+`)
+		if webhook != "validation.gatekeeper.sh" || message != "[psp-privileged] Privileged container is not allowed: nginx" {
+			t.Logf("got webhook %q and message %q", webhook, message)
+			t.Fail()
+		}
+	})
+
+	t.Run("api server", func(t *testing.T) {
+		webhook, message := parseAdmissionError(`Error from server (Invalid): error when creating "STDIN": Service "foo" is invalid: spec.ports: Required value`)
+		if webhook != "" || message != `Service "foo" is invalid: spec.ports: Required value` {
+			t.Logf("got webhook %q and message %q", webhook, message)
+			t.Fail()
+		}
+	})
+}
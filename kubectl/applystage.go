@@ -1,6 +1,15 @@
 package kubectl
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
 	"github.com/appnexus/ankh/context"
 	"github.com/appnexus/ankh/plan"
 )
@@ -13,11 +22,15 @@ func NewApplyStage() plan.Stage {
 	return &KubectlRunner{kubectl: &ApplyStage{}}
 }
 
+func (stage *ApplyStage) Idempotent() bool {
+	return true
+}
+
 func (stage *ApplyStage) GetCommand(ctx *ankh.ExecutionContext, namespace string) plan.Command {
 	cmd := newKubectlCommand(ctx, namespace)
 	cmd.AddArguments([]string{"apply"})
-	// Send apply results to stdout
-	cmd.PipeStdoutAndStderr = plan.PIPE_TYPE_STD
+	// Capture apply results so that we can summarize them in HandleOutput
+	cmd.PipeStdoutAndStderr = plan.PIPE_TYPE_PIPE
 	return cmd
 }
 
@@ -31,8 +44,112 @@ func (stage *ApplyStage) GetFinalArgs(ctx *ankh.ExecutionContext) []string {
 	if len(ctx.PassThroughArgs) > 0 {
 		args = append(args, append([]string{"--"}, ctx.PassThroughArgs...)...)
 	}
+	args = append(args, applyArgs(ctx)...)
 	if ctx.DryRun {
-		args = append(args, []string{"--dry-run"}...)
+		args = append(args, dryRunArg(ctx))
 	}
 	return args
 }
+
+func (stage *ApplyStage) HandleError(ctx *ankh.ExecutionContext, namespace string, stdout string, stderr string, err error) error {
+	// kubectl may have applied some objects before failing, so summarize
+	// what it did before explaining the failure.
+	summarizeApply(ctx, namespace, stdout, stderr)
+	return explainApplyConflicts(ctx, stderr, err)
+}
+
+type ApplyResult struct {
+	Object string
+	Action string
+}
+
+type ApplySummary struct {
+	Results  []ApplyResult
+	Warnings []string
+}
+
+// Parses `kubectl apply` output. Each line on stdout looks like
+// `deployment.apps/foo configured`, optionally suffixed with `(dry run)`,
+// and warnings on stderr are prefixed with `Warning:`.
+func parseApplyOutput(stdout string, stderr string) ApplySummary {
+	summary := ApplySummary{}
+	for _, line := range strings.Split(stdout, "\n") {
+		line = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line), "(dry run)"))
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		summary.Results = append(summary.Results, ApplyResult{
+			Object: fields[0],
+			Action: strings.Join(fields[1:], " "),
+		})
+	}
+	for _, line := range strings.Split(stderr, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Warning:") {
+			summary.Warnings = append(summary.Warnings, strings.TrimSpace(strings.TrimPrefix(line, "Warning:")))
+		}
+	}
+	return summary
+}
+
+func (summary *ApplySummary) Table() string {
+	counts := make(map[string]int)
+	formatted := bytes.NewBufferString("")
+	w := tabwriter.NewWriter(formatted, 0, 8, 8, ' ', 0)
+	fmt.Fprintf(w, "OBJECT\tRESULT\n")
+	for _, result := range summary.Results {
+		fmt.Fprintf(w, "%v\t%v\n", result.Object, result.Action)
+		counts[result.Action]++
+	}
+	w.Flush()
+
+	actions := []string{}
+	for action, _ := range counts {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	totals := []string{}
+	for _, action := range actions {
+		totals = append(totals, fmt.Sprintf("%d %v", counts[action], action))
+	}
+	if len(totals) > 0 {
+		fmt.Fprintf(formatted, "\n%v\n", strings.Join(totals, ", "))
+	}
+	return formatted.String()
+}
+
+func (stage *ApplyStage) HandleOutput(ctx *ankh.ExecutionContext, namespace string, stdout string, stderr string) (string, error) {
+	summarizeApply(ctx, namespace, stdout, stderr)
+	return "", nil
+}
+
+// Names the file that keeps the full output of applying to a namespace. The
+// same namespace may be applied to in several contexts during one run.
+func applyLogName(ctx *ankh.ExecutionContext, namespace string) string {
+	context := strings.Replace(ctx.AnkhConfig.CurrentContextName, string(filepath.Separator), "_", -1)
+	return fmt.Sprintf("kubectl-apply-%v-%v.log", context, namespace)
+}
+
+func summarizeApply(ctx *ankh.ExecutionContext, namespace string, stdout string, stderr string) {
+	// Keep the full kubectl output around in the run's data dir.
+	if err := os.MkdirAll(ctx.DataDir, 0755); err == nil {
+		logPath := filepath.Join(ctx.DataDir, applyLogName(ctx, namespace))
+		if err := ioutil.WriteFile(logPath, []byte(stdout+stderr), 0644); err != nil {
+			ctx.Logger.Warnf("Could not save kubectl output to %v: %v", logPath, err)
+		} else {
+			ctx.Logger.Infof("Full kubectl output saved to %v", logPath)
+		}
+	}
+
+	summary := parseApplyOutput(stdout, stderr)
+	for _, warning := range summary.Warnings {
+		ctx.Logger.Warnf("kubectl: %v", warning)
+	}
+
+	// Print here rather than returning output, since multi-stage plans
+	// (eg: deploy) pass input through the apply stage.
+	if len(summary.Results) > 0 {
+		fmt.Print(summary.Table())
+	}
+}
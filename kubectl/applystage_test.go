@@ -0,0 +1,57 @@
+package kubectl
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+)
+
+func TestApplyOutputIsSavedPerContext(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl", ankhtest.Rule{Args: "* apply *", Stdout: "deployment.apps/app configured\n"})
+	ctx := ankhtest.NewContext(t)
+
+	input := "kind: Deployment\nmetadata:\n  name: app\n"
+	for _, context := range []string{"east", "west"} {
+		ctx.AnkhConfig.CurrentContextName = context
+		if _, err := NewApplyStage().Execute(ctx, &input, "web", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"kubectl-apply-east-web.log", "kubectl-apply-west-web.log"} {
+		content, err := ioutil.ReadFile(filepath.Join(ctx.DataDir, name))
+		if err != nil || !strings.Contains(string(content), "deployment.apps/app configured") {
+			t.Logf("expected %v to hold the apply output but got %q (%v)", name, content, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestApplyOutputIsSavedOnError(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl", ankhtest.Rule{
+		Args:     "* apply *",
+		Stdout:   "deployment.apps/app configured\n",
+		Stderr:   "Warning: extensions/v1beta1 Ingress is deprecated\nerror: unable to recognize \"STDIN\"\n",
+		ExitCode: 1,
+	})
+	ctx := ankhtest.NewContext(t)
+
+	input := "kind: Deployment\nmetadata:\n  name: app\n"
+	if _, err := NewApplyStage().Execute(ctx, &input, "web", nil); err == nil {
+		t.Fatal("expected the apply to fail")
+	}
+	content, err := ioutil.ReadFile(filepath.Join(ctx.DataDir, "kubectl-apply-test-web.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"deployment.apps/app configured", "unable to recognize"} {
+		if !strings.Contains(string(content), expected) {
+			t.Logf("expected the saved output to contain %q but got %q", expected, content)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package kubectl
+
+import (
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+)
+
+// CanI runs `kubectl auth can-i <verb> <resource>` against the current context
+// with verbose logging, eg: to check that its credentials work. It returns
+// kubectl's answer, `yes` or `no`, and its log, which shows the requests made
+// and any error from a credential plugin. The error is only set when kubectl
+// could not answer, eg: when authentication failed.
+func CanI(ctx *ankh.ExecutionContext, namespace string, verb string, resource string) (string, string, error) {
+	cmd := newKubectlCommand(ctx, namespace)
+	cmd.AddArguments([]string{"auth", "can-i", verb, resource, "-v", "6"})
+	_, err := cmd.Run(ctx, nil)
+
+	// kubectl exits non-zero when the answer is `no`.
+	answer := strings.TrimSpace(cmd.Stdout())
+	if answer == "yes" || answer == "no" {
+		return answer, cmd.Stderr(), nil
+	}
+	return answer, cmd.Stderr(), err
+}
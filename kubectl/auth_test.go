@@ -0,0 +1,43 @@
+package kubectl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+)
+
+func TestCanI(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl", ankhtest.Rule{
+		Args:     "* auth can-i get pods *",
+		Stdout:   "no\n",
+		Stderr:   "I1017 round_trippers.go:553] GET https://kube.example.com/apis 200 OK\n",
+		ExitCode: 1,
+	})
+	ctx := ankhtest.NewContext(t)
+
+	answer, log, err := CanI(ctx, "web", "get", "pods")
+	if err != nil || answer != "no" {
+		t.Logf("expected kubectl's answer `no` without an error but got %q, %v", answer, err)
+		t.Fail()
+	}
+	if !strings.Contains(log, "GET https://kube.example.com/apis 200 OK") {
+		t.Logf("expected kubectl's verbose log but got %q", log)
+		t.Fail()
+	}
+	if args := strings.Join(tools.Calls("kubectl")[0].Args, " "); !strings.HasSuffix(args, "--namespace web auth can-i get pods -v 6") {
+		t.Logf("expected a verbose `auth can-i` in namespace web but got %v", args)
+		t.Fail()
+	}
+
+	tools.Fake("kubectl", ankhtest.Rule{
+		Args:     "* auth can-i *",
+		Stderr:   "getting credentials: exec: executable kubelogin not found\n",
+		ExitCode: 1,
+	})
+	if _, log, err := CanI(ctx, "", "get", "pods"); err == nil || !strings.Contains(log, "kubelogin not found") {
+		t.Logf("expected an error when kubectl cannot answer but got %v, with log %q", err, log)
+		t.Fail()
+	}
+}
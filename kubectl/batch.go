@@ -0,0 +1,52 @@
+package kubectl
+
+import (
+	"strings"
+)
+
+// A BatchItem is a unit of work, eg: a single chart, whose templated objects are
+// combined with those of other items in the same namespace so that kubectl runs
+// once for all of them, rather than once per item.
+type BatchItem struct {
+	Name     string
+	Manifest string
+}
+
+func combineManifests(items []BatchItem) string {
+	manifests := []string{}
+	for _, item := range items {
+		manifests = append(manifests, strings.TrimSpace(item.Manifest))
+	}
+	return strings.Join(manifests, "\n---\n") + "\n"
+}
+
+// Identifies an object the same way whether it comes from templated yaml
+// (`Deployment` and `foo`) or from kubectl output (`deployment.apps/foo`).
+func objectKey(kind string, name string) string {
+	kind = strings.ToLower(kind)
+	if i := strings.Index(kind, "."); i >= 0 {
+		kind = kind[:i]
+	}
+	return kind + "/" + name
+}
+
+// Parses kubectl's `kind.group/name` object references.
+func objectKeyFromOutput(object string) string {
+	parts := strings.SplitN(object, "/", 2)
+	if len(parts) != 2 {
+		return strings.ToLower(object)
+	}
+	return objectKey(parts[0], parts[1])
+}
+
+// Maps each object in the batch to the name of the item that templated it.
+func batchOwners(items []BatchItem) map[string]string {
+	owners := make(map[string]string)
+	for _, item := range items {
+		forEachKubeObject(item.Manifest, func(obj *KubeObject) bool {
+			owners[objectKey(obj.Kind, obj.Metadata.Name)] = item.Name
+			return true
+		})
+	}
+	return owners
+}
@@ -0,0 +1,24 @@
+package kubectl
+
+import (
+	"testing"
+)
+
+func TestBatchOwners(t *testing.T) {
+	owners := batchOwners([]BatchItem{
+		{Name: "foo", Manifest: "kind: Deployment\nmetadata:\n  name: foo\n---\nkind: Service\nmetadata:\n  name: foo\n"},
+		{Name: "bar", Manifest: "kind: StatefulSet\nmetadata:\n  name: bar\n"},
+	})
+
+	for object, expected := range map[string]string{
+		"deployment.apps/foo":  "foo",
+		"service/foo":          "foo",
+		"statefulset.apps/bar": "bar",
+		"deployment.apps/bar":  "",
+	} {
+		if owner := owners[objectKeyFromOutput(object)]; owner != expected {
+			t.Logf("expected %v to be owned by %q but got %q", object, expected, owner)
+			t.Fail()
+		}
+	}
+}
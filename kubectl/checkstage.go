@@ -13,6 +13,10 @@ func NewCheckStage() plan.Stage {
 	return &KubectlRunner{kubectl: &CheckStage{}}
 }
 
+func (stage *CheckStage) Idempotent() bool {
+	return true
+}
+
 func (stage *CheckStage) GetCommand(ctx *ankh.ExecutionContext, namespace string) plan.Command {
 	cmd := newKubectlCommand(ctx, namespace)
 	cmd.AddArguments([]string{"get"})
@@ -0,0 +1,78 @@
+package kubectl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+)
+
+const crdEstablishedTimeout = "60s"
+
+// Names of the CustomResourceDefinitions in a manifest.
+func crdNames(manifest string) []string {
+	names := []string{}
+	forEachKubeObject(manifest, func(obj *KubeObject) bool {
+		if obj.Kind == "CustomResourceDefinition" && obj.Metadata.Name != "" {
+			names = append(names, obj.Metadata.Name)
+		}
+		return true
+	})
+	return names
+}
+
+// ApplyCrds applies CRD files, and then waits for each CRD to be established,
+// so that the custom resources applied next are accepted by the API server.
+// In explain mode, it returns the commands instead of running them.
+func ApplyCrds(ctx *ankh.ExecutionContext, paths []string) (string, error) {
+	names := []string{}
+	for _, path := range paths {
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		names = append(names, crdNames(string(body))...)
+	}
+
+	apply := newKubectlCommand(ctx, "")
+	apply.AddArguments([]string{"apply"})
+	for _, path := range paths {
+		apply.AddArguments([]string{"-f", path})
+	}
+	apply.AddArguments(applyArgs(ctx))
+	if ctx.DryRun {
+		apply.AddArguments([]string{dryRunArg(ctx)})
+	}
+
+	wait := newKubectlCommand(ctx, "")
+	wait.AddArguments([]string{"wait", "--for", "condition=established", "--timeout", crdEstablishedTimeout})
+	for _, name := range names {
+		wait.AddArguments([]string{"customresourcedefinition/" + name})
+	}
+
+	if ctx.Mode == ankh.Explain {
+		if len(names) == 0 {
+			return apply.Explain(), nil
+		}
+		return fmt.Sprintf("%s && \\\n%s", apply.Explain(), wait.Explain()), nil
+	}
+
+	ctx.Logger.Infof("Applying %v CRD(s) from the charts' crds/ directories: %v", len(names), strings.Join(names, ", "))
+	out, err := runWithRetry(ctx, &apply, nil)
+	if err != nil {
+		return "", explainApplyConflicts(ctx, apply.Stderr(), err)
+	}
+	ctx.Logger.Debugf("kubectl apply of CRDs: %v", strings.TrimSpace(out))
+
+	// Nothing was created during a dry run, so there is nothing to wait for.
+	if ctx.DryRun || len(names) == 0 {
+		return "", nil
+	}
+
+	ctx.Logger.Infof("Waiting up to %v for CRD(s) to be established", crdEstablishedTimeout)
+	if _, err := runWithRetry(ctx, &wait, nil); err != nil {
+		return "", err
+	}
+	return "", nil
+}
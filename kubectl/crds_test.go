@@ -0,0 +1,61 @@
+package kubectl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/appnexus/ankh/context"
+)
+
+const crdManifest = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: not-a-crd
+`
+
+func TestCrdNames(t *testing.T) {
+	names := crdNames(crdManifest)
+	if len(names) != 1 || names[0] != "widgets.example.com" {
+		t.Logf("expected [widgets.example.com] but got %v", names)
+		t.Fail()
+	}
+}
+
+func TestApplyCrdsExplain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-crds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "widgets.yaml")
+	if err := ioutil.WriteFile(path, []byte(crdManifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &ankh.ExecutionContext{Logger: logrus.New(), Mode: ankh.Explain}
+	ctx.AnkhConfig.Kubectl.Command = "kubectl"
+	out, err := ApplyCrds(ctx, []string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out, "apply -f "+path) {
+		t.Logf("expected the CRD file to be applied but got %v", out)
+		t.Fail()
+	}
+	if !strings.Contains(out, "wait --for condition=established --timeout 60s customresourcedefinition/widgets.example.com") {
+		t.Logf("expected to wait for the CRD to be established but got %v", out)
+		t.Fail()
+	}
+}
@@ -0,0 +1,96 @@
+package kubectl
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+	"github.com/appnexus/ankh/util"
+)
+
+// How long `delete --wait` waits for objects to be removed, unless configured.
+const defaultDeleteTimeout = "5m"
+
+// DeleteStage removes every templated object from the cluster, after listing
+// them and asking for confirmation.
+type DeleteStage struct{}
+
+func NewDeleteStage() plan.Stage {
+	return &DeleteStage{}
+}
+
+func newDeleteCommand(ctx *ankh.ExecutionContext, namespace string) plan.Command {
+	cmd := newKubectlCommand(ctx, namespace)
+	// Objects that are already gone, eg: after an interrupted delete, are not an error.
+	cmd.AddArguments([]string{"delete", "-f", "-", "--ignore-not-found"})
+	if ctx.Wait && !ctx.DryRun {
+		// Wait for finalizers, eg: for load balancers or volumes to be released.
+		timeout := ctx.WaitTimeout
+		if timeout == "" {
+			timeout = defaultDeleteTimeout
+		}
+		cmd.AddArguments([]string{"--wait=true", "--timeout=" + timeout})
+	} else {
+		cmd.AddArguments([]string{"--wait=false"})
+	}
+	cmd.AddArguments(ctx.ExtraArgs)
+	if ctx.DryRun {
+		cmd.AddArguments([]string{"--dry-run"})
+	}
+	return cmd
+}
+
+// Lists the objects that will be deleted, eg: for the confirmation prompt.
+func formatDeleteObjects(objects []KubeObject) string {
+	buf := bytes.NewBufferString("")
+	w := tabwriter.NewWriter(buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "KIND\tNAME\n")
+	for _, obj := range objects {
+		fmt.Fprintf(w, "%v\t%v\n", obj.Kind, obj.Metadata.Name)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+func (stage *DeleteStage) Execute(ctx *ankh.ExecutionContext, input *string, namespace string, wildCardLabels []string) (string, error) {
+	if input == nil {
+		panic("kubectl cannot execute on nil input")
+	}
+
+	cmd := newDeleteCommand(ctx, namespace)
+	if ctx.Mode == ankh.Explain {
+		in := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(*input), "&& \\"))
+		return fmt.Sprintf("(%s) | \\\n%s", in, cmd.Explain()), nil
+	}
+
+	objects := []KubeObject{}
+	forEachKubeObject(*input, func(obj *KubeObject) bool {
+		objects = append(objects, *obj)
+		return true
+	})
+	if len(objects) == 0 {
+		ctx.Logger.Infof("No objects to delete")
+		return "", nil
+	}
+
+	if !ctx.NoPrompt && !ctx.DryRun {
+		fmt.Printf("\n%v\n", formatDeleteObjects(objects))
+		selection, err := util.PromptForConfirmation([]string{"Abort", "Delete"},
+			fmt.Sprintf("Delete the %v objects above from namespace \"%v\" in context \"%v\"?",
+				len(objects), namespace, ctx.AnkhConfig.CurrentContextName))
+		if err != nil {
+			return "", err
+		}
+		if selection != "Delete" {
+			return "", fmt.Errorf("Aborted, nothing was deleted")
+		}
+	}
+
+	if ctx.Wait && !ctx.DryRun {
+		ctx.Logger.Infof("Deleting %v objects and waiting for them to be removed...", len(objects))
+	}
+	return runWithRetry(ctx, &cmd, input)
+}
@@ -0,0 +1,62 @@
+package kubectl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+)
+
+func TestDeleteStage(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl", ankhtest.Rule{Args: "* delete *", Stdout: "deployment.apps \"app\" deleted\nservice \"app\" deleted\n"})
+	ctx := ankhtest.NewContext(t)
+
+	input := "kind: Deployment\nmetadata:\n  name: app\n---\nkind: Service\nmetadata:\n  name: app\n"
+	out, err := NewDeleteStage().Execute(ctx, &input, "web", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "deployment.apps \"app\" deleted") {
+		t.Logf("expected kubectl's output but got %q", out)
+		t.Fail()
+	}
+
+	calls := tools.Calls("kubectl")
+	if len(calls) != 1 || calls[0].Stdin != input {
+		t.Fatalf("expected the templated objects to be deleted with a single call but got %+v", calls)
+	}
+	if args := strings.Join(calls[0].Args, " "); !strings.HasSuffix(args, "--namespace web delete -f - --ignore-not-found --wait=false") {
+		t.Logf("expected a delete that does not wait but got %v", args)
+		t.Fail()
+	}
+
+	ctx.Wait = true
+	ctx.WaitTimeout = "10m"
+	if _, err := NewDeleteStage().Execute(ctx, &input, "web", nil); err != nil {
+		t.Fatal(err)
+	}
+	if args := strings.Join(tools.Calls("kubectl")[1].Args, " "); !strings.HasSuffix(args, "--wait=true --timeout=10m") {
+		t.Logf("expected the delete to wait for finalizers but got %v", args)
+		t.Fail()
+	}
+
+	// Nothing is deleted when every object was filtered out.
+	empty := ""
+	if _, err := NewDeleteStage().Execute(ctx, &empty, "web", nil); err != nil || len(tools.Calls("kubectl")) != 2 {
+		t.Logf("expected no kubectl call without objects but got %v", err)
+		t.Fail()
+	}
+}
+
+func TestFormatDeleteObjects(t *testing.T) {
+	objects := []KubeObject{{Kind: "Deployment"}, {Kind: "Service"}}
+	objects[0].Metadata.Name = "app"
+	objects[1].Metadata.Name = "app-internal"
+
+	expected := "KIND        NAME\nDeployment  app\nService     app-internal\n"
+	if out := formatDeleteObjects(objects); out != expected {
+		t.Logf("expected:\n%v\ngot:\n%v", expected, out)
+		t.Fail()
+	}
+}
@@ -0,0 +1,270 @@
+package kubectl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+)
+
+// How `ankh diff-filter` gets its rules and the diff tool to run, from the
+// DiffStage, by way of `kubectl diff`.
+const (
+	DiffIgnoreEnv     = "ANKH_DIFF_IGNORE"
+	DiffFilterToolEnv = "ANKH_DIFF_FILTER_TOOL"
+)
+
+// Used when no diff tool is configured.
+const defaultDiffTool = "diff -u -N"
+
+// Fields that Ankh itself changes on every apply, ignored along with any
+// configured rules.
+var defaultDiffIgnoreRules = []ankh.DiffIgnoreRule{
+	{Paths: []string{"metadata.generation", "metadata.annotations[ankh.appnexus.com/*]"}},
+}
+
+// Returns the ignore rules that apply to the current context, or nil if there
+// are none configured.
+func diffIgnoreRules(ctx *ankh.ExecutionContext) []ankh.DiffIgnoreRule {
+	if len(ctx.AnkhConfig.Diff.Ignore) == 0 {
+		return nil
+	}
+	rules := append([]ankh.DiffIgnoreRule{}, defaultDiffIgnoreRules...)
+	for _, rule := range ctx.AnkhConfig.Diff.Ignore {
+		if len(rule.Contexts) > 0 && !containsString(rule.Contexts, ctx.AnkhConfig.CurrentContextName) {
+			continue
+		}
+		rules = append(rules, ankh.DiffIgnoreRule{Paths: rule.Paths, Kinds: rule.Kinds})
+	}
+	return rules
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateDiffIgnoreRules returns an error if a path of any rule cannot be parsed.
+func ValidateDiffIgnoreRules(rules []ankh.DiffIgnoreRule) error {
+	for _, rule := range rules {
+		for _, path := range rule.Paths {
+			if _, err := parseIgnorePath(path); err != nil {
+				return fmt.Errorf("Invalid path \"%v\" in `diff.ignore`: %v", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// A step along an ignore path: a map key, which may end with `*`, or a
+// selector of list items, eg: `name=istio-proxy`.
+type ignoreStep struct {
+	key         string
+	selectField string
+	selectValue string
+}
+
+// Parses a path like `spec.template.spec.containers[name=istio-proxy]`.
+// Keys with dots in them go in brackets, eg: `annotations[example.com/foo]`.
+func parseIgnorePath(path string) ([]ignoreStep, error) {
+	steps := []ignoreStep{}
+	for path != "" {
+		var token string
+		bracketed := strings.HasPrefix(path, "[")
+		if bracketed {
+			end := strings.Index(path, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated `[` in ignore path")
+			}
+			token, path = path[1:end], path[end+1:]
+		} else {
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			token, path = path[:end], path[end:]
+		}
+		path = strings.TrimPrefix(path, ".")
+		if token == "" {
+			return nil, fmt.Errorf("empty key in ignore path")
+		}
+
+		if kv := strings.SplitN(token, "=", 2); bracketed && len(kv) == 2 {
+			steps = append(steps, ignoreStep{selectField: kv[0], selectValue: kv[1]})
+		} else {
+			steps = append(steps, ignoreStep{key: token})
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("empty ignore path")
+	}
+	return steps, nil
+}
+
+// Matches a value against a pattern that may end with `*`.
+func matchesPattern(pattern string, value string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(value, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == value
+}
+
+func (step ignoreStep) matchesItem(item interface{}) bool {
+	obj, ok := item.(yaml.MapSlice)
+	if !ok {
+		return false
+	}
+	for _, field := range obj {
+		if fmt.Sprintf("%v", field.Key) == step.selectField {
+			return matchesPattern(step.selectValue, fmt.Sprintf("%v", field.Value))
+		}
+	}
+	return false
+}
+
+// Removes the values at a path from a value, returning the changed value.
+func removePath(value interface{}, steps []ignoreStep) interface{} {
+	step, last := steps[0], len(steps) == 1
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		if step.key == "" {
+			return v
+		}
+		out := yaml.MapSlice{}
+		for _, item := range v {
+			if !matchesPattern(step.key, fmt.Sprintf("%v", item.Key)) {
+				out = append(out, item)
+			} else if !last {
+				out = append(out, yaml.MapItem{Key: item.Key, Value: removePath(item.Value, steps[1:])})
+			}
+		}
+		return out
+	case []interface{}:
+		if step.selectField == "" {
+			return v
+		}
+		out := []interface{}{}
+		for _, item := range v {
+			if !step.matchesItem(item) {
+				out = append(out, item)
+			} else if !last {
+				out = append(out, removePath(item, steps[1:]))
+			}
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// Removes the ignored fields from an object.
+func filterObject(obj yaml.MapSlice, rules []ankh.DiffIgnoreRule) (yaml.MapSlice, error) {
+	kind := ""
+	for _, item := range obj {
+		if item.Key == "kind" {
+			kind = fmt.Sprintf("%v", item.Value)
+		}
+	}
+
+	var filtered interface{} = obj
+	for _, rule := range rules {
+		if len(rule.Kinds) > 0 && !containsFold(rule.Kinds, kind) {
+			continue
+		}
+		for _, path := range rule.Paths {
+			steps, err := parseIgnorePath(path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ignore path \"%v\": %v", path, err)
+			}
+			filtered = removePath(filtered, steps)
+		}
+	}
+	return filtered.(yaml.MapSlice), nil
+}
+
+// Removes the ignored fields from a manifest that `kubectl diff` wrote.
+func filterManifest(body []byte, rules []ankh.DiffIgnoreRule) ([]byte, error) {
+	obj := yaml.MapSlice{}
+	if err := yaml.Unmarshal(body, &obj); err != nil {
+		return nil, err
+	}
+	filtered, err := filterObject(obj, rules)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(filtered)
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func filterManifestDir(dir string, rules []ankh.DiffIgnoreRule) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		filtered, err := filterManifest(body, rules)
+		if err != nil {
+			return fmt.Errorf("unable to filter %v: %v", path, err)
+		}
+		if err := ioutil.WriteFile(path, filtered, file.Mode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DiffFilter removes ignored fields from the live and merged manifests that
+// `kubectl diff` wrote to `from` and `to`, and then diffs them with the diff
+// tool, returning its exit code. It is run by kubectl, as its external diff
+// program, with the rules and the tool in the environment.
+func DiffFilter(from string, to string) (int, error) {
+	rules := []ankh.DiffIgnoreRule{}
+	if err := yaml.Unmarshal([]byte(os.Getenv(DiffIgnoreEnv)), &rules); err != nil {
+		return 0, fmt.Errorf("unable to parse %v: %v", DiffIgnoreEnv, err)
+	}
+	for _, dir := range []string{from, to} {
+		if err := filterManifestDir(dir, rules); err != nil {
+			return 0, err
+		}
+	}
+
+	tool := strings.Fields(os.Getenv(DiffFilterToolEnv))
+	if len(tool) == 0 {
+		tool = strings.Fields(defaultDiffTool)
+	}
+	cmd := exec.Command(tool[0], append(tool[1:], from, to)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), nil
+		}
+		return 0, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,123 @@
+package kubectl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+const liveDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  generation: 7
+  annotations:
+    ankh.appnexus.com/applied-at: "2024-01-12T20:04:05Z"
+    sidecar.istio.io/status: injected
+    team: web
+spec:
+  replicas: 5
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:2
+      - name: istio-proxy
+        image: proxyv2
+`
+
+func TestFilterManifest(t *testing.T) {
+	rules := append(defaultDiffIgnoreRules,
+		ankh.DiffIgnoreRule{Paths: []string{"metadata.annotations[sidecar.istio.io/status]", "spec.template.spec.containers[name=istio-*]"}},
+		ankh.DiffIgnoreRule{Kinds: []string{"deployment"}, Paths: []string{"spec.replicas"}},
+		ankh.DiffIgnoreRule{Kinds: []string{"StatefulSet"}, Paths: []string{"spec.template"}})
+
+	out, err := filterManifest([]byte(liveDeployment), rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, removed := range []string{"generation", "ankh.appnexus.com", "sidecar.istio.io", "istio-proxy", "replicas"} {
+		if strings.Contains(string(out), removed) {
+			t.Logf("expected %v to be ignored but got %v", removed, string(out))
+			t.Fail()
+		}
+	}
+	for _, kept := range []string{"team: web", "image: app:2"} {
+		if !strings.Contains(string(out), kept) {
+			t.Logf("expected %v to be kept but got %v", kept, string(out))
+			t.Fail()
+		}
+	}
+
+	for _, path := range []string{"", "metadata..name", "metadata.annotations[foo"} {
+		if err := ValidateDiffIgnoreRules([]ankh.DiffIgnoreRule{{Paths: []string{path}}}); err == nil {
+			t.Logf("expected path %q to be invalid", path)
+			t.Fail()
+		}
+	}
+}
+
+func TestDiffIgnoreRules(t *testing.T) {
+	ctx := ankhtest.NewContext(t)
+	if rules := diffIgnoreRules(ctx); rules != nil {
+		t.Logf("expected no rules without configured rules but got %+v", rules)
+		t.Fail()
+	}
+
+	ctx.AnkhConfig.Diff.Ignore = []ankh.DiffIgnoreRule{
+		{Paths: []string{"spec.replicas"}, Contexts: []string{"production"}},
+		{Paths: []string{"metadata.labels"}, Contexts: []string{"test"}},
+	}
+	rules := diffIgnoreRules(ctx)
+	if len(rules) != len(defaultDiffIgnoreRules)+1 || rules[len(rules)-1].Paths[0] != "metadata.labels" {
+		t.Logf("expected only the rules for context test but got %+v", rules)
+		t.Fail()
+	}
+
+	cmd := (&DiffStage{}).GetCommand(ctx, "web")
+	env := strings.Join(cmd.Env, "\n")
+	if !strings.Contains(env, "KUBECTL_EXTERNAL_DIFF=") || !strings.Contains(env, DiffFilterToolEnv+"="+defaultDiffTool) {
+		t.Logf("expected kubectl diff to run the diff filter but got %v", cmd.Env)
+		t.Fail()
+	}
+}
+
+func TestDiffFilter(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("mydiff", ankhtest.Rule{ExitCode: 1})
+
+	dir, err := ioutil.TempDir("", "ankh-diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	from, to := filepath.Join(dir, "LIVE"), filepath.Join(dir, "MERGED")
+	for _, d := range []string{from, to} {
+		os.MkdirAll(d, 0755)
+		ioutil.WriteFile(filepath.Join(d, "apps.v1.Deployment.web.app"), []byte(liveDeployment), 0644)
+	}
+
+	defer os.Unsetenv(DiffIgnoreEnv)
+	defer os.Unsetenv(DiffFilterToolEnv)
+	os.Setenv(DiffIgnoreEnv, "- paths: [spec.replicas]\n")
+	os.Setenv(DiffFilterToolEnv, "mydiff -u")
+	exitCode, err := DiffFilter(from, to)
+	if err != nil || exitCode != 1 {
+		t.Logf("expected the exit code of the diff tool but got %v and %v", exitCode, err)
+		t.Fail()
+	}
+	calls := tools.Calls("mydiff")
+	if len(calls) != 1 || strings.Join(calls[0].Args, " ") != "-u "+from+" "+to {
+		t.Logf("expected the diff tool to be run over both directories but got %+v", calls)
+		t.Fail()
+	}
+	if body, _ := ioutil.ReadFile(filepath.Join(to, "apps.v1.Deployment.web.app")); strings.Contains(string(body), "replicas") {
+		t.Logf("expected the manifests to be filtered but got %v", string(body))
+		t.Fail()
+	}
+}
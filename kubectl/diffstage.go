@@ -4,8 +4,11 @@ import (
 	"os"
 	"strings"
 
+	"gopkg.in/yaml.v2"
+
 	"github.com/appnexus/ankh/context"
 	"github.com/appnexus/ankh/plan"
+	"github.com/appnexus/ankh/util"
 )
 
 type DiffStage struct {
@@ -16,17 +19,56 @@ func NewDiffStage() plan.Stage {
 	return &KubectlRunner{kubectl: &DiffStage{}}
 }
 
+func (stage *DiffStage) Idempotent() bool {
+	return true
+}
+
 func (stage *DiffStage) GetCommand(ctx *ankh.ExecutionContext, namespace string) plan.Command {
 	cmd := newKubectlCommand(ctx, namespace)
 	diffCommand := os.Getenv("ANKH_DIFF_COMMAND")
+	diffTool := getDiffTool(ctx)
 	if diffCommand != "" {
 		cmd.AddArguments(strings.Fields(diffCommand))
+	} else if rules := diffIgnoreRules(ctx); rules != nil {
+		// Ankh filters the manifests that kubectl writes, and then runs the diff tool itself.
+		body, _ := yaml.Marshal(rules)
+		tool := diffTool
+		if tool == "" {
+			tool = defaultDiffTool
+		}
+		ctx.Logger.Debugf("Ignoring fields in diffs with rules %+v", rules)
+		cmd.AddArguments([]string{"diff"})
+		cmd.Env = append(cmd.Env, "KUBECTL_EXTERNAL_DIFF="+util.AnkhExecutable()+" diff-filter",
+			DiffIgnoreEnv+"="+string(body), DiffFilterToolEnv+"="+tool)
+		if diffTool != "" {
+			cmd.PipeStdoutAndStderr = plan.PIPE_TYPE_STD
+		}
+	} else if diffTool != "" {
+		// `kubectl diff` renders the live and generated manifests into temporary
+		// directories and invokes KUBECTL_EXTERNAL_DIFF over them.
+		ctx.Logger.Debugf("Using external diff tool \"%v\"", diffTool)
+		cmd.AddArguments([]string{"diff"})
+		cmd.Env = append(cmd.Env, "KUBECTL_EXTERNAL_DIFF="+diffTool)
+		// Tools like meld and icdiff want the terminal, so don't capture their output.
+		cmd.PipeStdoutAndStderr = plan.PIPE_TYPE_STD
 	} else {
-		cmd.AddArguments([]string{"alpha", "diff", "LAST", "LOCAL"})
+		cmd.AddArguments([]string{"diff"})
 	}
 	return cmd
 }
 
+// The diff tool comes from the command line, then ankh config, and finally
+// any KUBECTL_EXTERNAL_DIFF already present in the environment.
+func getDiffTool(ctx *ankh.ExecutionContext) string {
+	if ctx.DiffTool != "" {
+		return ctx.DiffTool
+	}
+	if ctx.AnkhConfig.Diff.Tool != "" {
+		return ctx.AnkhConfig.Diff.Tool
+	}
+	return os.Getenv("KUBECTL_EXTERNAL_DIFF")
+}
+
 func (stage *DiffStage) GetArgsFromInput(ctx *ankh.ExecutionContext, input string, wildCardLabels []string) ([]string, error) {
 	// The apply stage takes yaml from stdin, so there are no additional args beyond `-f -`
 	return []string{"-f", "-"}, nil
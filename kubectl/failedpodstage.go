@@ -0,0 +1,199 @@
+package kubectl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+)
+
+// FailedPodStage shows why containers of a chart's pods are failing, along with
+// their most recent logs, eg: after watching pods during a deploy.
+type FailedPodStage struct{}
+
+func NewFailedPodStage() plan.Stage {
+	return &FailedPodStage{}
+}
+
+type containerState struct {
+	Waiting *struct {
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	} `json:"waiting"`
+	Terminated *struct {
+		Reason   string `json:"reason"`
+		Message  string `json:"message"`
+		ExitCode int    `json:"exitCode"`
+	} `json:"terminated"`
+}
+
+type containerStatus struct {
+	Name         string         `json:"name"`
+	Ready        bool           `json:"ready"`
+	RestartCount int            `json:"restartCount"`
+	State        containerState `json:"state"`
+	LastState    containerState `json:"lastState"`
+}
+
+type podList struct {
+	Items []struct {
+		Metadata struct {
+			Name              string            `json:"name"`
+			Labels            map[string]string `json:"labels"`
+			CreationTimestamp time.Time         `json:"creationTimestamp"`
+			DeletionTimestamp *time.Time        `json:"deletionTimestamp"`
+		} `json:"metadata"`
+		Spec struct {
+			Containers []struct {
+				Name  string `json:"name"`
+				Image string `json:"image"`
+			} `json:"containers"`
+		} `json:"spec"`
+		Status struct {
+			Phase                 string            `json:"phase"`
+			Reason                string            `json:"reason"`
+			InitContainerStatuses []containerStatus `json:"initContainerStatuses"`
+			ContainerStatuses     []containerStatus `json:"containerStatuses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+type failingContainer struct {
+	Pod       string
+	Container string
+	Restarts  int
+	Reason    string
+	Message   string
+	// Logs from the previous, terminated instance of the container explain a
+	// crash loop better than those of the current, waiting instance.
+	Previous bool
+}
+
+// Waiting reasons for containers that are still starting normally.
+var startingReasons = map[string]bool{
+	"ContainerCreating": true,
+	"PodInitializing":   true,
+}
+
+func describeTermination(state containerState) string {
+	if state.Terminated == nil {
+		return ""
+	}
+	return fmt.Sprintf("exit code %v: %v", state.Terminated.ExitCode, state.Terminated.Reason)
+}
+
+func findFailingContainers(pods podList) []failingContainer {
+	failing := []failingContainer{}
+	for _, pod := range pods.Items {
+		statuses := append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			f := failingContainer{
+				Pod:       pod.Metadata.Name,
+				Container: status.Name,
+				Restarts:  status.RestartCount,
+			}
+
+			switch {
+			case status.State.Terminated != nil && status.State.Terminated.ExitCode != 0:
+				f.Reason = describeTermination(status.State)
+				f.Message = status.State.Terminated.Message
+			case status.State.Waiting != nil && !startingReasons[status.State.Waiting.Reason]:
+				f.Reason = status.State.Waiting.Reason
+				f.Message = status.State.Waiting.Message
+				if last := describeTermination(status.LastState); last != "" {
+					f.Reason += fmt.Sprintf(" (last %v)", last)
+					f.Previous = true
+				}
+			default:
+				continue
+			}
+			failing = append(failing, f)
+		}
+	}
+	return failing
+}
+
+// FailingContainers describes each failing container in the output of one or
+// more `kubectl get pods -o json` commands, eg: one per context of an
+// environment, as `pod/container: reason, N restarts`.
+func FailingContainers(output string) ([]string, error) {
+	descriptions := []string{}
+	decoder := json.NewDecoder(strings.NewReader(output))
+	for {
+		pods := podList{}
+		if err := decoder.Decode(&pods); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Could not parse kubectl output as JSON: %v", err)
+		}
+		for _, f := range findFailingContainers(pods) {
+			descriptions = append(descriptions, fmt.Sprintf("%v/%v: %v, %v restarts", f.Pod, f.Container, f.Reason, f.Restarts))
+		}
+	}
+	return descriptions, nil
+}
+
+func (stage *FailedPodStage) Execute(ctx *ankh.ExecutionContext, input *string, namespace string, wildCardLabels []string) (string, error) {
+	if input == nil {
+		panic("kubectl cannot execute on nil input")
+	}
+
+	selectorArgs, err := getPodSelectorArgsFromInput(ctx, *input)
+	if err != nil {
+		// Nothing to check for charts without Deployments or StatefulSets.
+		return "", nil
+	}
+
+	cmd := newKubectlCommand(ctx, namespace)
+	cmd.AddArguments([]string{"get", "pods", "-o", "json"})
+	cmd.AddArguments(selectorArgs)
+	out, err := cmd.Run(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	pods := podList{}
+	if err := json.Unmarshal([]byte(out), &pods); err != nil {
+		return "", fmt.Errorf("Could not parse kubectl output as JSON: %v", err)
+	}
+
+	failing := findFailingContainers(pods)
+	if len(failing) == 0 {
+		ctx.Logger.Infof("No failing containers found")
+		return "", nil
+	}
+
+	ctx.Logger.Warnf("Found %v failing containers", len(failing))
+	for _, f := range failing {
+		fmt.Printf("\n%v/%v: %v, %v restarts\n", f.Pod, f.Container, f.Reason, f.Restarts)
+		if message := strings.TrimSpace(f.Message); message != "" {
+			fmt.Printf("  %v\n", message)
+		}
+
+		if ctx.FailedPodLogLines <= 0 {
+			continue
+		}
+
+		logs := newKubectlCommand(ctx, namespace)
+		logs.AddArguments([]string{"logs", f.Pod, "-c", f.Container, "--tail", strconv.Itoa(ctx.FailedPodLogLines)})
+		if f.Previous {
+			logs.AddArguments([]string{"--previous"})
+		}
+		logsOut, err := logs.Run(ctx, nil)
+		if err != nil {
+			ctx.Logger.Warnf("Could not get logs for %v/%v: %v", f.Pod, f.Container, err)
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimRight(logsOut, "\n"), "\n") {
+			fmt.Printf("  | %v\n", line)
+		}
+	}
+	fmt.Println()
+
+	return "", nil
+}
@@ -0,0 +1,89 @@
+package kubectl
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const podListJSON string = `{
+  "items": [
+    {
+      "metadata": {"name": "foo-1"},
+      "status": {
+        "containerStatuses": [
+          {
+            "name": "app",
+            "restartCount": 4,
+            "state": {"waiting": {"reason": "CrashLoopBackOff", "message": "back-off 1m20s restarting failed container"}},
+            "lastState": {"terminated": {"reason": "Error", "exitCode": 1}}
+          },
+          {
+            "name": "sidecar",
+            "restartCount": 0,
+            "state": {"running": {}}
+          }
+        ]
+      }
+    },
+    {
+      "metadata": {"name": "foo-2"},
+      "status": {
+        "initContainerStatuses": [
+          {"name": "migrate", "restartCount": 0, "state": {"terminated": {"reason": "Error", "exitCode": 2}}}
+        ],
+        "containerStatuses": [
+          {"name": "app", "restartCount": 0, "state": {"waiting": {"reason": "PodInitializing"}}}
+        ]
+      }
+    }
+  ]
+}`
+
+func TestFindFailingContainers(t *testing.T) {
+	pods := podList{}
+	if err := json.Unmarshal([]byte(podListJSON), &pods); err != nil {
+		t.Logf("got error %v", err)
+		t.FailNow()
+	}
+
+	failing := findFailingContainers(pods)
+	expected := []failingContainer{
+		{Pod: "foo-1", Container: "app", Restarts: 4, Reason: "CrashLoopBackOff (last exit code 1: Error)",
+			Message: "back-off 1m20s restarting failed container", Previous: true},
+		{Pod: "foo-2", Container: "migrate", Reason: "exit code 2: Error"},
+	}
+	if len(failing) != len(expected) {
+		t.Logf("expected %+v but got %+v", expected, failing)
+		t.FailNow()
+	}
+	for i := range expected {
+		if failing[i] != expected[i] {
+			t.Logf("expected %+v but got %+v", expected[i], failing[i])
+			t.Fail()
+		}
+	}
+}
+
+func TestFailingContainers(t *testing.T) {
+	// One pod list per context, as printed by `ankh pods -- -o json` for an environment.
+	output := podListJSON + "\n" + `{"items": []}` + "\n"
+	failing, err := FailingContainers(output)
+	if err != nil {
+		t.Logf("got error %v", err)
+		t.FailNow()
+	}
+	expected := []string{
+		"foo-1/app: CrashLoopBackOff (last exit code 1: Error), 4 restarts",
+		"foo-2/migrate: exit code 2: Error, 0 restarts",
+	}
+	if !reflect.DeepEqual(failing, expected) {
+		t.Logf("expected %+v but got %+v", expected, failing)
+		t.Fail()
+	}
+
+	if _, err := FailingContainers("not json"); err == nil {
+		t.Logf("expected an error for output that is not JSON")
+		t.Fail()
+	}
+}
@@ -15,6 +15,10 @@ func NewGetStage() plan.Stage {
 	return &KubectlRunner{kubectl: &GetStage{}}
 }
 
+func (stage *GetStage) Idempotent() bool {
+	return true
+}
+
 func getWildCardLabels(ctx *ankh.ExecutionContext, wildCardLabels []string) []string {
 	args := []string{}
 
@@ -0,0 +1,184 @@
+package kubectl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+	"github.com/appnexus/ankh/util"
+)
+
+const deploymentRevisionAnnotation = "deployment.kubernetes.io/revision"
+
+// HistoryStage lists the revisions of each Deployment and StatefulSet in a
+// chart, from their ReplicaSets and ControllerRevisions, so that one can be
+// chosen for `ankh rollback --to-revision`.
+type HistoryStage struct{}
+
+func NewHistoryStage() plan.Stage {
+	return &HistoryStage{}
+}
+
+// A WorkloadRevision is one revision of a Deployment or StatefulSet.
+type WorkloadRevision struct {
+	// eg: `deployment/foo`
+	Workload string   `json:"workload" yaml:"workload"`
+	Revision int      `json:"revision" yaml:"revision"`
+	Images   []string `json:"images" yaml:"images"`
+	Created  string   `json:"created" yaml:"created"`
+	// Whether this is the revision the workload is running, ie: its latest
+	Current bool `json:"current" yaml:"current"`
+}
+
+type revisionList struct {
+	Items []struct {
+		Metadata struct {
+			Name              string            `json:"name"`
+			CreationTimestamp string            `json:"creationTimestamp"`
+			Annotations       map[string]string `json:"annotations"`
+			OwnerReferences   []struct {
+				Kind string `json:"kind"`
+				Name string `json:"name"`
+			} `json:"ownerReferences"`
+		} `json:"metadata"`
+		// For ReplicaSets
+		Spec struct {
+			Template podTemplateSpec `json:"template"`
+		} `json:"spec"`
+		// For ControllerRevisions
+		Revision int `json:"revision"`
+		Data     struct {
+			Spec struct {
+				Template podTemplateSpec `json:"template"`
+			} `json:"spec"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+// Parses `kubectl get replicasets -o json` or `kubectl get controllerrevisions -o json`
+// output into revisions of the given workloads, keyed by `kind/name`.
+func parseRevisions(stdout string, workloads map[string]bool) ([]WorkloadRevision, error) {
+	revisions := []WorkloadRevision{}
+	if strings.TrimSpace(stdout) == "" {
+		return revisions, nil
+	}
+
+	list := revisionList{}
+	if err := json.Unmarshal([]byte(stdout), &list); err != nil {
+		return nil, fmt.Errorf("Could not parse kubectl output as JSON: %v", err)
+	}
+
+	for _, item := range list.Items {
+		for _, owner := range item.Metadata.OwnerReferences {
+			workload := fmt.Sprintf("%v/%v", strings.ToLower(owner.Kind), owner.Name)
+			if !workloads[workload] {
+				continue
+			}
+
+			revision := WorkloadRevision{Workload: workload, Revision: item.Revision, Created: item.Metadata.CreationTimestamp}
+			template := item.Data.Spec.Template
+			if owner.Kind == "Deployment" {
+				revision.Revision, _ = strconv.Atoi(item.Metadata.Annotations[deploymentRevisionAnnotation])
+				template = item.Spec.Template
+			}
+			for _, c := range append(template.Spec.InitContainers, template.Spec.Containers...) {
+				revision.Images = append(revision.Images, c.Image)
+			}
+			revisions = append(revisions, revision)
+		}
+	}
+
+	// Newest first, for each workload
+	sort.SliceStable(revisions, func(i, j int) bool {
+		if revisions[i].Workload != revisions[j].Workload {
+			return revisions[i].Workload < revisions[j].Workload
+		}
+		return revisions[i].Revision > revisions[j].Revision
+	})
+	for i := range revisions {
+		revisions[i].Current = i == 0 || revisions[i-1].Workload != revisions[i].Workload
+	}
+	return revisions, nil
+}
+
+func formatRevisions(revisions []WorkloadRevision) string {
+	formatted := bytes.NewBufferString("")
+	w := tabwriter.NewWriter(formatted, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "WORKLOAD\tREVISION\tCREATED\tIMAGES\n")
+	for _, revision := range revisions {
+		number := strconv.Itoa(revision.Revision)
+		if revision.Current {
+			number += " (current)"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", revision.Workload, number, revision.Created, strings.Join(revision.Images, ","))
+	}
+	w.Flush()
+	return formatted.String()
+}
+
+func (stage *HistoryStage) Execute(ctx *ankh.ExecutionContext, input *string, namespace string, wildCardLabels []string) (string, error) {
+	if input == nil {
+		panic("kubectl cannot execute on nil input")
+	}
+
+	workloads := map[string]bool{}
+	kinds := map[string]bool{}
+	forEachKubeObject(*input, func(obj *KubeObject) bool {
+		if strings.EqualFold(obj.Kind, "deployment") || strings.EqualFold(obj.Kind, "statefulset") {
+			workloads[fmt.Sprintf("%v/%v", strings.ToLower(obj.Kind), obj.Metadata.Name)] = true
+			kinds[strings.ToLower(obj.Kind)] = true
+		}
+		return true
+	})
+	if len(workloads) == 0 {
+		ctx.Logger.Infof("No Deployments or StatefulSets to show the history of")
+		return "", nil
+	}
+
+	// Deployments keep their history in ReplicaSets, and StatefulSets in
+	// ControllerRevisions.
+	revisions := []WorkloadRevision{}
+	for _, kind := range []string{"deployment", "statefulset"} {
+		if !kinds[kind] {
+			continue
+		}
+		resource := "replicasets"
+		if kind == "statefulset" {
+			resource = "controllerrevisions"
+		}
+
+		cmd := newKubectlCommand(ctx, namespace)
+		cmd.AddArguments([]string{"get", resource, "-o", "json"})
+		out, err := runWithRetry(ctx, &cmd, nil)
+		if err != nil {
+			return "", err
+		}
+		parsed, err := parseRevisions(out, workloads)
+		if err != nil {
+			return "", err
+		}
+		revisions = append(revisions, parsed...)
+	}
+
+	if ctx.Output != "" {
+		out, err := util.FormatOutput(ctx.Output, revisions)
+		if err != nil {
+			return "", err
+		}
+		fmt.Print(out)
+		return "", nil
+	}
+
+	if len(revisions) == 0 {
+		ctx.Logger.Infof("No revisions found. The chart may not be applied to namespace %v", namespace)
+		return "", nil
+	}
+	fmt.Print(formatRevisions(revisions))
+	return "", nil
+}
@@ -0,0 +1,139 @@
+package kubectl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+)
+
+const replicaSetsJSON string = `{
+  "kind": "List",
+  "items": [
+    {
+      "metadata": {
+        "name": "web-5d4f8",
+        "creationTimestamp": "2024-03-01T10:00:00Z",
+        "annotations": {"deployment.kubernetes.io/revision": "3"},
+        "ownerReferences": [{"kind": "Deployment", "name": "web"}]
+      },
+      "spec": {"template": {"spec": {"containers": [{"image": "web:1.2.0"}]}}}
+    },
+    {
+      "metadata": {
+        "name": "web-7c9b2",
+        "creationTimestamp": "2024-02-01T10:00:00Z",
+        "annotations": {"deployment.kubernetes.io/revision": "2"},
+        "ownerReferences": [{"kind": "Deployment", "name": "web"}]
+      },
+      "spec": {"template": {"spec": {"containers": [{"image": "web:1.1.0"}]}}}
+    },
+    {
+      "metadata": {
+        "name": "other-1a2b3",
+        "annotations": {"deployment.kubernetes.io/revision": "9"},
+        "ownerReferences": [{"kind": "Deployment", "name": "other"}]
+      }
+    }
+  ]
+}`
+
+const controllerRevisionsJSON string = `{
+  "kind": "List",
+  "items": [
+    {
+      "metadata": {
+        "name": "db-6f7d",
+        "creationTimestamp": "2024-01-01T10:00:00Z",
+        "ownerReferences": [{"kind": "StatefulSet", "name": "db"}]
+      },
+      "revision": 1,
+      "data": {"spec": {"template": {"spec": {"containers": [{"image": "db:5.7"}]}}}}
+    }
+  ]
+}`
+
+func TestParseRevisions(t *testing.T) {
+	workloads := map[string]bool{"deployment/web": true, "statefulset/db": true}
+
+	revisions, err := parseRevisions(replicaSetsJSON, workloads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("expected 2 revisions of deployment/web but got %+v", revisions)
+	}
+	if revisions[0].Revision != 3 || !revisions[0].Current || revisions[0].Images[0] != "web:1.2.0" {
+		t.Logf("expected revision 3 to be current but got %+v", revisions[0])
+		t.Fail()
+	}
+	if revisions[1].Revision != 2 || revisions[1].Current || revisions[1].Created != "2024-02-01T10:00:00Z" {
+		t.Logf("got unexpected revision %+v", revisions[1])
+		t.Fail()
+	}
+
+	revisions, err = parseRevisions(controllerRevisionsJSON, workloads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 || revisions[0].Workload != "statefulset/db" || revisions[0].Revision != 1 || revisions[0].Images[0] != "db:5.7" {
+		t.Logf("got unexpected revisions %+v", revisions)
+		t.Fail()
+	}
+
+	formatted := formatRevisions(revisions)
+	if !strings.Contains(formatted, "statefulset/db  1 (current)  2024-01-01T10:00:00Z  db:5.7") {
+		t.Logf("got unexpected table:\n%v", formatted)
+		t.Fail()
+	}
+}
+
+func TestHistoryStage(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl",
+		ankhtest.Rule{Args: "* get replicasets *", Stdout: replicaSetsJSON},
+		ankhtest.Rule{Args: "* get controllerrevisions *", Stdout: controllerRevisionsJSON},
+	)
+	ctx := ankhtest.NewContext(t)
+	ctx.Output = "yaml"
+	input := rolloutManifest
+
+	out, err := ankhtest.CaptureStdout(t, func() error {
+		_, err := NewHistoryStage().Execute(ctx, &input, "web", nil)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"workload: deployment/web\n  revision: 3", "workload: statefulset/db\n  revision: 1"} {
+		if !strings.Contains(out, expected) {
+			t.Logf("expected %q in history:\n%v", expected, out)
+			t.Fail()
+		}
+	}
+	if calls := tools.Calls("kubectl"); len(calls) != 2 {
+		t.Logf("expected one call for ReplicaSets and one for ControllerRevisions but got %+v", calls)
+		t.Fail()
+	}
+}
+
+func TestRollbackStageToRevision(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl", ankhtest.Rule{Args: "* rollout undo *"})
+	ctx := ankhtest.NewContext(t)
+	input := rolloutManifest
+
+	if _, err := NewRollbackStage(2).Execute(ctx, &input, "web", nil); err != nil {
+		t.Fatal(err)
+	}
+	calls := tools.Calls("kubectl")
+	expected := "--context test --namespace web rollout undo --to-revision=2 Deployment/web StatefulSet/db"
+	if len(calls) != 1 || strings.Join(calls[0].Args, " ") != expected {
+		t.Logf("expected `kubectl %v` but got %+v", expected, calls)
+		t.Fail()
+	}
+	if !strings.Contains(calls[0].Stdin, "name: web") {
+		t.Logf("expected the manifest on stdin but got %q", calls[0].Stdin)
+		t.Fail()
+	}
+}
@@ -0,0 +1,223 @@
+package kubectl
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+)
+
+type containerSpec struct {
+	Image string `json:"image"`
+}
+
+type podTemplateSpec struct {
+	Spec struct {
+		Containers     []containerSpec `json:"containers"`
+		InitContainers []containerSpec `json:"initContainers"`
+	} `json:"spec"`
+}
+
+type liveObject struct {
+	Kind     string       `json:"kind"`
+	Items    []liveObject `json:"items"`
+	Metadata struct {
+		Name      string            `json:"name"`
+		Namespace string            `json:"namespace"`
+		Labels    map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Spec struct {
+		Template podTemplateSpec `json:"template"`
+	} `json:"spec"`
+}
+
+func getWorkloadArgsFromInput(ctx *ankh.ExecutionContext, input string) []string {
+	args := []string{}
+
+	forEachKubeObject(input, func(obj *KubeObject) bool {
+		if strings.EqualFold(obj.Kind, "deployment") ||
+			strings.EqualFold(obj.Kind, "statefulset") ||
+			strings.EqualFold(obj.Kind, "daemonset") {
+			args = append(args, fmt.Sprintf("%v/%v", obj.Kind, obj.Metadata.Name))
+		}
+
+		return true
+	})
+
+	ctx.Logger.Debugf("Decided to use args %+v", args)
+	return args
+}
+
+// Parses `kubectl get -o json` output for one or more objects.
+func parseLiveObjects(stdout string) ([]liveObject, error) {
+	if strings.TrimSpace(stdout) == "" {
+		return []liveObject{}, nil
+	}
+
+	obj := liveObject{}
+	if err := json.Unmarshal([]byte(stdout), &obj); err != nil {
+		return nil, fmt.Errorf("Could not parse kubectl output as JSON: %v", err)
+	}
+
+	if obj.Kind == "List" {
+		return obj.Items, nil
+	}
+	return []liveObject{obj}, nil
+}
+
+// Fetches the live workloads of every item with a single kubectl call, and
+// groups them by the item that templated them.
+func getLiveWorkloads(ctx *ankh.ExecutionContext, namespace string, items []BatchItem) (map[string][]liveObject, error) {
+	workloads := make(map[string][]liveObject)
+	args := getWorkloadArgsFromInput(ctx, combineManifests(items))
+	if len(args) == 0 {
+		return workloads, nil
+	}
+
+	cmd := newKubectlCommand(ctx, namespace)
+	cmd.AddArguments([]string{"get", "-o", "json", "--ignore-not-found"})
+	cmd.AddArguments(args)
+	out, err := runWithRetry(ctx, &cmd, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	objs, err := parseLiveObjects(out)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := batchOwners(items)
+	for _, o := range objs {
+		if owner, ok := owners[objectKey(o.Kind, o.Metadata.Name)]; ok {
+			workloads[owner] = append(workloads[owner], o)
+		}
+	}
+	return workloads, nil
+}
+
+// Returns the sorted, unique images of a set of workloads.
+func workloadImages(objs []liveObject) []string {
+	seen := make(map[string]bool)
+	list := []string{}
+	for _, o := range objs {
+		containers := append(o.Spec.Template.Spec.InitContainers, o.Spec.Template.Spec.Containers...)
+		for _, c := range containers {
+			if !seen[c.Image] {
+				seen[c.Image] = true
+				list = append(list, c.Image)
+			}
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// GetImages returns, for each item, a sorted, comma separated list of the unique
+// images its live workloads are running. The workloads of every item are fetched
+// with a single kubectl call.
+func GetImages(ctx *ankh.ExecutionContext, namespace string, items []BatchItem) (map[string]string, error) {
+	workloads, err := getLiveWorkloads(ctx, namespace, items)
+	if err != nil {
+		return nil, err
+	}
+
+	images := make(map[string]string)
+	for owner, objs := range workloads {
+		images[owner] = strings.Join(workloadImages(objs), ",")
+	}
+	return images, nil
+}
+
+// A LiveRelease is what the live workloads of an item are running.
+type LiveRelease struct {
+	// The `helm.sh/chart` label of the workloads, or the older `chart` label,
+	// eg: `api-1.4.2`
+	ChartLabel string
+	// The sorted, unique images of the workloads
+	Images []string
+}
+
+// Returns the `helm.sh/chart` label of a workload, or else its older `chart` label.
+func chartLabel(o liveObject) string {
+	if label := o.Metadata.Labels["helm.sh/chart"]; label != "" {
+		return label
+	}
+	return o.Metadata.Labels["chart"]
+}
+
+// GetLiveReleases returns, for each item with live workloads, the chart label
+// and images they are running. The workloads of every item are fetched with a
+// single kubectl call.
+func GetLiveReleases(ctx *ankh.ExecutionContext, namespace string, items []BatchItem) (map[string]LiveRelease, error) {
+	workloads, err := getLiveWorkloads(ctx, namespace, items)
+	if err != nil {
+		return nil, err
+	}
+
+	releases := make(map[string]LiveRelease)
+	for owner, objs := range workloads {
+		release := LiveRelease{Images: workloadImages(objs)}
+		for _, o := range objs {
+			if label := chartLabel(o); label != "" {
+				release.ChartLabel = label
+				break
+			}
+		}
+		releases[owner] = release
+	}
+	return releases, nil
+}
+
+// A LiveChart is a chart version found running in a namespace, by the chart
+// label of its workloads.
+type LiveChart struct {
+	Namespace string
+	LiveRelease
+}
+
+// GetLiveCharts returns every chart version whose workloads are running in
+// `namespace`, or in any namespace if it is empty, sorted by namespace and
+// chart label.
+func GetLiveCharts(ctx *ankh.ExecutionContext, namespace string) ([]LiveChart, error) {
+	cmd := newKubectlCommand(ctx, namespace)
+	cmd.AddArguments([]string{"get", "deployments,statefulsets,daemonsets", "-o", "json"})
+	if namespace == "" {
+		cmd.AddArguments([]string{"--all-namespaces"})
+	}
+	out, err := runWithRetry(ctx, &cmd, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	objs, err := parseLiveObjects(out)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct{ namespace, label string }
+	workloads := make(map[key][]liveObject)
+	for _, o := range objs {
+		if label := chartLabel(o); label != "" {
+			k := key{o.Metadata.Namespace, label}
+			workloads[k] = append(workloads[k], o)
+		}
+	}
+
+	charts := []LiveChart{}
+	for k, objs := range workloads {
+		charts = append(charts, LiveChart{
+			Namespace:   k.namespace,
+			LiveRelease: LiveRelease{ChartLabel: k.label, Images: workloadImages(objs)},
+		})
+	}
+	sort.Slice(charts, func(i, j int) bool {
+		if charts[i].Namespace != charts[j].Namespace {
+			return charts[i].Namespace < charts[j].Namespace
+		}
+		return charts[i].ChartLabel < charts[j].ChartLabel
+	})
+	return charts, nil
+}
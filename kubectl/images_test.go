@@ -0,0 +1,59 @@
+package kubectl
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+)
+
+const liveWorkloadsJSON = `{
+  "kind": "List",
+  "items": [
+    {
+      "kind": "Deployment",
+      "metadata": {"name": "api", "labels": {"helm.sh/chart": "api-1.4.2", "chart": "ignored"}},
+      "spec": {"template": {"spec": {
+        "initContainers": [{"image": "registry/migrate:20240105"}],
+        "containers": [{"image": "registry/api:20240105"}, {"image": "registry/proxy:2.1"}]
+      }}}
+    },
+    {
+      "kind": "Deployment",
+      "metadata": {"name": "worker", "labels": {"chart": "worker-0.9.0"}},
+      "spec": {"template": {"spec": {"containers": [{"image": "registry/worker:abc123"}]}}}
+    }
+  ]
+}`
+
+func TestGetLiveReleases(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl", ankhtest.Rule{Args: "* get -o json *", Stdout: liveWorkloadsJSON})
+	ctx := ankhtest.NewContext(t)
+
+	items := []BatchItem{
+		{Name: "api", Manifest: "kind: Deployment\nmetadata:\n  name: api\n"},
+		{Name: "worker", Manifest: "kind: Deployment\nmetadata:\n  name: worker\n"},
+		{Name: "config", Manifest: "kind: ConfigMap\nmetadata:\n  name: config\n"},
+	}
+	releases, err := GetLiveReleases(ctx, "web", items)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]LiveRelease{
+		"api": {
+			ChartLabel: "api-1.4.2",
+			Images:     []string{"registry/api:20240105", "registry/migrate:20240105", "registry/proxy:2.1"},
+		},
+		"worker": {ChartLabel: "worker-0.9.0", Images: []string{"registry/worker:abc123"}},
+	}
+	if !reflect.DeepEqual(releases, expected) {
+		t.Logf("expected %+v but got %+v", expected, releases)
+		t.Fail()
+	}
+	if calls := tools.Calls("kubectl"); len(calls) != 1 {
+		t.Logf("expected a single kubectl call for every item but got %+v", calls)
+		t.Fail()
+	}
+}
@@ -14,10 +14,34 @@ type KubectlStage interface {
 	GetFinalArgs(ctx *ankh.ExecutionContext) []string
 }
 
+// Stages that want to inspect or reformat kubectl's output after a successful
+// run may implement this in addition to KubectlStage.
+type KubectlOutputHandler interface {
+	HandleOutput(ctx *ankh.ExecutionContext, namespace string, stdout string, stderr string) (string, error)
+}
+
+// Stages that want to explain why kubectl failed may implement this in
+// addition to KubectlStage.
+type KubectlErrorHandler interface {
+	HandleError(ctx *ankh.ExecutionContext, namespace string, stdout string, stderr string, err error) error
+}
+
+// Stages whose kubectl command may safely be run again, eg: `apply` or `get`,
+// implement this in addition to KubectlStage, so that they are retried after
+// transient failures. Other stages, eg: `rollout undo`, are only ever run once.
+type KubectlIdempotentStage interface {
+	Idempotent() bool
+}
+
 type KubectlRunner struct {
 	kubectl KubectlStage
 }
 
+// StageName names the runner after the stage it runs, eg: `kubectl.ApplyStage`.
+func (runner *KubectlRunner) StageName() string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", runner.kubectl), "*")
+}
+
 type GenericStage struct {}
 
 func (stage *GenericStage) GetCommand(ctx *ankh.ExecutionContext, namespace string) plan.Command {
@@ -56,11 +80,23 @@ func (stage *KubectlRunner) Execute(ctx *ankh.ExecutionContext, input *string, n
 	}
 
 	ctx.Logger.Debugf("Running stage %+v with cmd: %+v", stage, cmd)
-	out, err := cmd.Run(ctx, input)
+	var out string
+	if idempotent, ok := stage.kubectl.(KubectlIdempotentStage); ok && idempotent.Idempotent() {
+		out, err = runWithRetry(ctx, &cmd, input)
+	} else {
+		out, err = cmd.Run(ctx, input)
+	}
 	if err != nil {
+		if handler, ok := stage.kubectl.(KubectlErrorHandler); ok {
+			return out, handler.HandleError(ctx, namespace, cmd.Stdout(), cmd.Stderr(), err)
+		}
 		return out, err
 	}
 
+	if handler, ok := stage.kubectl.(KubectlOutputHandler); ok {
+		return handler.HandleOutput(ctx, namespace, out, cmd.Stderr())
+	}
+
 	return out, err
 }
 
@@ -84,5 +120,11 @@ func newKubectlCommand(ctx *ankh.ExecutionContext, namespace string) plan.Comman
 		cmd.AddArguments([]string{"--kubeconfig", ctx.KubeConfigPath})
 	}
 
+	env, err := proxyEnv(ctx)
+	if err != nil {
+		cmd.Err = fmt.Errorf("Unable to reach context \"%v\" through its proxy: %v", ctx.AnkhConfig.CurrentContextName, err)
+	}
+	cmd.Env = env
+
 	return cmd
 }
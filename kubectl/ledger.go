@@ -0,0 +1,27 @@
+package kubectl
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+)
+
+// RecordRelease adds a release, as JSON under the key `id`, to a ConfigMap in
+// `namespace`, creating the ConfigMap if it does not exist yet.
+func RecordRelease(ctx *ankh.ExecutionContext, namespace string, configMap string, id string, release string) error {
+	patch, err := json.Marshal(map[string]map[string]string{"data": {id: release}})
+	if err != nil {
+		return err
+	}
+
+	cmd := newKubectlCommand(ctx, namespace)
+	cmd.AddArguments([]string{"patch", "configmap", configMap, "--type", "merge", "-p", string(patch)})
+	_, err = runWithRetry(ctx, &cmd, nil)
+	if err != nil && strings.Contains(cmd.Stderr(), "NotFound") {
+		cmd = newKubectlCommand(ctx, namespace)
+		cmd.AddArguments([]string{"create", "configmap", configMap, "--from-literal=" + id + "=" + release})
+		_, err = runWithRetry(ctx, &cmd, nil)
+	}
+	return err
+}
@@ -0,0 +1,35 @@
+package kubectl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+)
+
+func TestRecordRelease(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl",
+		ankhtest.Rule{Args: "* patch configmap *", Stderr: `Error from server (NotFound): configmaps "ankh-releases" not found`, ExitCode: 1},
+		ankhtest.Rule{Args: "* create configmap *"})
+	ctx := ankhtest.NewContext(t)
+
+	if err := RecordRelease(ctx, "web", "ankh-releases", "0a1b2c3d", `{"chart":"api"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := tools.Calls("kubectl")
+	if len(calls) != 2 {
+		t.Fatalf("expected a patch and then a create but got %+v", calls)
+	}
+	patch := strings.Join(calls[0].Args, " ")
+	if !strings.Contains(patch, `{"data":{"0a1b2c3d":"{\"chart\":\"api\"}"}}`) {
+		t.Logf("expected the release to be patched in under its ID but got %v", patch)
+		t.Fail()
+	}
+	create := strings.Join(calls[1].Args, " ")
+	if !strings.Contains(create, `--from-literal=0a1b2c3d={"chart":"api"}`) || !strings.Contains(create, "web") {
+		t.Logf("expected the ConfigMap to be created in the namespace but got %v", create)
+		t.Fail()
+	}
+}
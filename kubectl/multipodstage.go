@@ -0,0 +1,191 @@
+package kubectl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+	"github.com/appnexus/ankh/util"
+)
+
+// Writes output to `out` line by line, with a prefix on each line, so that
+// output of several commands can be interleaved on the same writer.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.writeLine(w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// Writes out anything after the last newline.
+func (w *prefixWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.writeLine(append(w.buf, '\n'))
+		w.buf = nil
+	}
+}
+
+func (w *prefixWriter) writeLine(line []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fmt.Fprint(w.out, w.prefix)
+	w.out.Write(line)
+}
+
+// Returns a prefix for each pod, padded to the longest pod name and colored
+// distinctly, if color is enabled.
+func podPrefixes(ctx *ankh.ExecutionContext, pods []string) map[string]string {
+	width := 0
+	for _, pod := range pods {
+		if len(pod) > width {
+			width = len(pod)
+		}
+	}
+	colors := util.GetListingColors(ctx)
+	prefixes := make(map[string]string)
+	for i, pod := range pods {
+		prefixes[pod] = fmt.Sprintf("%v%-*v%v | ", colors.Color(i), width, pod, colors.Reset)
+	}
+	return prefixes
+}
+
+type multiPodStage struct {
+	// The kubectl subcommand run on each pod, eg: `logs`
+	subcommand string
+	// Whether pods are run on concurrently, rather than one after another
+	concurrent bool
+}
+
+// NewMultiPodLogStage streams logs from every pod selected by the pod
+// selection stage at once, prefixing each line with its pod's name.
+func NewMultiPodLogStage() plan.Stage {
+	return &multiPodStage{subcommand: "logs", concurrent: true}
+}
+
+// NewMultiPodExecStage runs a command on every pod selected by the pod
+// selection stage, one after another, and reports each pod's exit code.
+func NewMultiPodExecStage() plan.Stage {
+	return &multiPodStage{subcommand: "exec"}
+}
+
+func (stage *multiPodStage) podCommand(ctx *ankh.ExecutionContext, namespace string, pod string, container string) plan.Command {
+	cmd := newKubectlCommand(ctx, namespace)
+	cmd.AddArguments([]string{stage.subcommand, pod, "-c", container})
+	cmd.AddArguments(ctx.ExtraArgs)
+	if len(ctx.PassThroughArgs) > 0 {
+		cmd.AddArguments(append([]string{"--"}, ctx.PassThroughArgs...))
+	}
+	cmd.PipeStdoutAndStderr = plan.PIPE_TYPE_STD
+	return cmd
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+func (stage *multiPodStage) Execute(ctx *ankh.ExecutionContext, input *string, namespace string, wildCardLabels []string) (string, error) {
+	if input == nil {
+		panic("kubectl cannot execute on nil input")
+	}
+
+	if ctx.Mode == ankh.Explain {
+		cmd := stage.podCommand(ctx, namespace, "<pod>", "<container>")
+		in := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(*input), "&& \\"))
+		return fmt.Sprintf("(%s) | \\\nfor each pod: %s", in, cmd.Explain()), nil
+	}
+
+	pods, container, err := getAllPodsAndContainerSelection(ctx, *input)
+	if err != nil {
+		return "", err
+	}
+	ctx.Logger.Infof("Running `kubectl %v` on %v pods", stage.subcommand, len(pods))
+
+	// Catch signals for the whole group, rather than for each command, so
+	// that an interrupt stops every command but not Ankh.
+	shouldCatchSignals := ctx.ShouldCatchSignals
+	ctx.ShouldCatchSignals = false
+	ctx.CatchSignals = shouldCatchSignals
+	defer func() {
+		ctx.ShouldCatchSignals = shouldCatchSignals
+		ctx.CatchSignals = false
+	}()
+
+	prefixes := podPrefixes(ctx, pods)
+	mu := &sync.Mutex{}
+	errs := make([]error, len(pods))
+	run := func(i int) {
+		pod := pods[i]
+		out := &prefixWriter{mu: mu, out: os.Stdout, prefix: prefixes[pod]}
+		cmd := stage.podCommand(ctx, namespace, pod, container)
+		cmd.Output = out
+		_, errs[i] = cmd.Run(ctx, nil)
+		out.Flush()
+	}
+
+	if stage.concurrent {
+		var wg sync.WaitGroup
+		for i := range pods {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				run(i)
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		for i := range pods {
+			run(i)
+		}
+	}
+
+	failed := 0
+	for i, pod := range pods {
+		if errs[i] != nil {
+			failed++
+			ctx.Logger.Debugf("kubectl %v on pod %v failed: %v", stage.subcommand, pod, errs[i])
+		}
+	}
+
+	// Output of commands that ran one after another is followed by each pod's exit code.
+	if !stage.concurrent {
+		var summary bytes.Buffer
+		w := tabwriter.NewWriter(&summary, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "POD\tEXIT CODE\n")
+		for i, pod := range pods {
+			fmt.Fprintf(w, "%v\t%v\n", pod, exitCode(errs[i]))
+		}
+		w.Flush()
+		fmt.Print("\n" + util.GetListingColors(ctx).HighlightHeader(summary.String()))
+	}
+	if failed > 0 {
+		return "", fmt.Errorf("`kubectl %v` failed on %v of %v pods", stage.subcommand, failed, len(pods))
+	}
+	return "", nil
+}
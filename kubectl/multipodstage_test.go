@@ -0,0 +1,96 @@
+package kubectl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+const podSelectionOutput string = `NAME       STATUS    CREATED                CONTAINERS
+web-b2c3   Running   2024-03-01T10:00:00Z   web,proxy
+web-a1b2   Running   2024-03-01T09:00:00Z   web,proxy
+`
+
+func TestMultiPodLogStage(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl",
+		ankhtest.Rule{Args: "* logs web-a1b2 *", Stdout: "started\nready"},
+		ankhtest.Rule{Args: "* logs web-b2c3 *", Stdout: "started\n"},
+	)
+	ctx := ankhtest.NewContext(t)
+	ctx.Mode = ankh.Logs
+	ctx.ExtraArgs = []string{"-c", "web"}
+
+	input := podSelectionOutput
+	out, err := ankhtest.CaptureStdout(t, func() error {
+		_, err := NewMultiPodLogStage().Execute(ctx, &input, "web", nil)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	for _, expected := range []string{"web-a1b2 | started", "web-a1b2 | ready", "web-b2c3 | started"} {
+		found := false
+		for _, line := range lines {
+			found = found || line == expected
+		}
+		if !found {
+			t.Logf("expected the line %q in output:\n%v", expected, out)
+			t.Fail()
+		}
+	}
+	if calls := tools.Calls("kubectl"); len(calls) != 2 {
+		t.Logf("expected logs from each pod but got %+v", calls)
+		t.Fail()
+	}
+	if ctx.CatchSignals {
+		t.Logf("expected signals to no longer be caught once the logs ended")
+		t.Fail()
+	}
+}
+
+func TestMultiPodExecStage(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl",
+		ankhtest.Rule{Args: "* exec web-a1b2 -c web -- env", Stdout: "HOME=/\n"},
+		ankhtest.Rule{Args: "* exec web-b2c3 -c web -- env", Stderr: "container not running\n", ExitCode: 2},
+	)
+	ctx := ankhtest.NewContext(t)
+	ctx.Mode = ankh.Exec
+	ctx.PassThroughArgs = []string{"env"}
+
+	// Without a container, the first of each pod's containers can't be chosen for `--no-prompt`.
+	input := podSelectionOutput
+	if _, err := NewMultiPodExecStage().Execute(ctx, &input, "web", nil); err == nil {
+		t.Logf("expected a container to be required for pods with several containers")
+		t.Fail()
+	}
+
+	input = strings.Replace(podSelectionOutput, "web,proxy", "web", -1)
+	out, err := ankhtest.CaptureStdout(t, func() error {
+		_, err := NewMultiPodExecStage().Execute(ctx, &input, "web", nil)
+		return err
+	})
+	if err == nil || err.Error() != "`kubectl exec` failed on 1 of 2 pods" {
+		t.Logf("expected the failure on one pod to be reported but got %v", err)
+		t.Fail()
+	}
+	for _, expected := range []string{"web-a1b2 | HOME=/\n", "web-b2c3 | container not running\n",
+		"POD       EXIT CODE\nweb-a1b2  0\nweb-b2c3  2\n"} {
+		if !strings.Contains(out, expected) {
+			t.Logf("expected %q in output:\n%v", expected, out)
+			t.Fail()
+		}
+	}
+
+	// Pods are run on one after another, in order of their names.
+	calls := tools.Calls("kubectl")
+	if len(calls) != 2 || calls[0].Args[5] != "web-a1b2" || calls[1].Args[5] != "web-b2c3" {
+		t.Logf("expected exec on each pod in turn but got %+v", calls)
+		t.Fail()
+	}
+}
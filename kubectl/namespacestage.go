@@ -0,0 +1,90 @@
+package kubectl
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+)
+
+// NamespaceStage creates the namespace that the charts are applied to, with
+// the labels and annotations of the current context, when it does not exist.
+// Its input, the templated objects, is passed on as its output.
+type NamespaceStage struct{}
+
+func NewNamespaceStage() plan.Stage {
+	return &NamespaceStage{}
+}
+
+// CreatesNamespaces reports whether apply and deploy should create missing
+// namespaces, by `--create-namespace` or the context's `autoCreateNamespaces`.
+func CreatesNamespaces(ctx *ankh.ExecutionContext) bool {
+	return ctx.CreateNamespace || ctx.AnkhConfig.CurrentContext.AutoCreateNamespaces
+}
+
+// The manifest of a namespace, as json, so that it can be given to kubectl on
+// stdin, or on a single line when explained.
+func namespaceManifest(ctx *ankh.ExecutionContext, namespace string) (string, error) {
+	metadata := map[string]interface{}{"name": namespace}
+	if labels := ctx.AnkhConfig.CurrentContext.NamespaceMetadata.Labels; len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+	if annotations := ctx.AnkhConfig.CurrentContext.NamespaceMetadata.Annotations; len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+	manifest, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata":   metadata,
+	})
+	return string(manifest), err
+}
+
+func (stage *NamespaceStage) Execute(ctx *ankh.ExecutionContext, input *string, namespace string, wildCardLabels []string) (string, error) {
+	if input == nil {
+		panic("kubectl cannot execute on nil input")
+	}
+	if namespace == "" {
+		return *input, nil
+	}
+
+	manifest, err := namespaceManifest(ctx, namespace)
+	if err != nil {
+		return "", err
+	}
+	create := newKubectlCommand(ctx, "")
+	create.AddArguments([]string{"apply", "-f", "-"})
+	create.AddArguments(applyArgs(ctx))
+	if ctx.DryRun {
+		create.AddArguments([]string{dryRunArg(ctx)})
+	}
+
+	if ctx.Mode == ankh.Explain {
+		// Only the templated objects may reach the apply that follows.
+		quoted := "'" + strings.Replace(manifest, "'", `'\''`, -1) + "'"
+		return fmt.Sprintf("(echo %v | %v > /dev/null) && \\\n%v", quoted, create.Explain(), *input), nil
+	}
+
+	get := newKubectlCommand(ctx, "")
+	get.AddArguments([]string{"get", "namespace", namespace, "--ignore-not-found", "-o", "name"})
+	out, err := runWithRetry(ctx, &get, nil)
+	if err != nil {
+		return "", fmt.Errorf("Unable to check whether namespace \"%v\" exists: %v", namespace, err)
+	}
+	if strings.TrimSpace(out) != "" {
+		ctx.Logger.Debugf("Namespace \"%v\" already exists", namespace)
+		return *input, nil
+	}
+
+	dryLog := ""
+	if ctx.DryRun {
+		dryLog = " (dry run)"
+	}
+	ctx.Logger.Infof("Creating namespace \"%v\"%v", namespace, dryLog)
+	if _, err := runWithRetry(ctx, &create, &manifest); err != nil {
+		return "", fmt.Errorf("Unable to create namespace \"%v\": %v", namespace, err)
+	}
+	return *input, nil
+}
@@ -0,0 +1,49 @@
+package kubectl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+)
+
+func TestNamespaceStage(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl",
+		ankhtest.Rule{Args: "* get namespace existing *", Stdout: "namespace/existing\n"},
+		ankhtest.Rule{Args: "* get namespace *", Stdout: ""},
+		ankhtest.Rule{Args: "* apply -f -*", Stdout: "namespace/web created\n"},
+	)
+	ctx := ankhtest.NewContext(t)
+	ctx.AnkhConfig.CurrentContext.NamespaceMetadata.Labels = map[string]string{"pod-security.kubernetes.io/enforce": "restricted"}
+
+	input := "kind: Deployment\n"
+	out, err := NewNamespaceStage().Execute(ctx, &input, "existing", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != input {
+		t.Logf("expected the templated objects to be passed on but got %q", out)
+		t.Fail()
+	}
+	if calls := tools.Calls("kubectl"); len(calls) != 1 {
+		t.Fatalf("expected an existing namespace to only be checked but got %+v", calls)
+	}
+
+	if _, err := NewNamespaceStage().Execute(ctx, &input, "web", nil); err != nil {
+		t.Fatal(err)
+	}
+	calls := tools.Calls("kubectl")
+	if len(calls) != 3 || !strings.HasSuffix(strings.Join(calls[2].Args, " "), "apply -f -") {
+		t.Fatalf("expected a missing namespace to be created but got %+v", calls)
+	}
+	expected := `{"apiVersion":"v1","kind":"Namespace","metadata":{"labels":{"pod-security.kubernetes.io/enforce":"restricted"},"name":"web"}}`
+	if calls[2].Stdin != expected {
+		t.Logf("expected the namespace manifest %v but got %v", expected, calls[2].Stdin)
+		t.Fail()
+	}
+	if strings.Contains(strings.Join(calls[2].Args, " "), "--namespace") {
+		t.Logf("expected the namespace to be created without a --namespace but got %v", calls[2].Args)
+		t.Fail()
+	}
+}
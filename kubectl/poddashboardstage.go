@@ -0,0 +1,501 @@
+package kubectl
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+	"github.com/appnexus/ankh/util"
+	"github.com/mattn/go-isatty"
+)
+
+// PodDashboardStage shows a live dashboard of a chart's pods, grouped by the
+// Deployment or StatefulSet they belong to, with their readiness, restarts and
+// images and their most recent events, for `ankh pods --watch --ui`. Pods can
+// be selected to view their logs or to describe them.
+type PodDashboardStage struct{}
+
+func NewPodDashboardStage() plan.Stage {
+	return &PodDashboardStage{}
+}
+
+// How often the dashboard fetches pods and events
+var podDashboardInterval = 2 * time.Second
+
+// The number of recent events the dashboard shows
+const podDashboardEvents = 8
+
+// The number of log lines to fetch when viewing a pod's logs
+const podDashboardLogLines = 500
+
+type dashboardPod struct {
+	Name     string
+	Ready    string
+	Status   string
+	Restarts int
+	Age      time.Duration
+	Images   []string
+}
+
+type dashboardGroup struct {
+	// eg: `Deployment api`, or empty for pods that belong to no workload of the chart
+	Workload string
+	Pods     []dashboardPod
+}
+
+type dashboardEvent struct {
+	Time    time.Time
+	Pod     string
+	Type    string
+	Reason  string
+	Message string
+}
+
+type podDashboard struct {
+	Namespace string
+	Updated   time.Time
+	Groups    []dashboardGroup
+	Events    []dashboardEvent
+	// The index of the selected pod, counting pods across groups
+	Selected int
+	// The error of the last refresh, if any. The last pods fetched are kept.
+	Err error
+}
+
+type dashboardWorkload struct {
+	Name        string
+	Kind        string
+	MatchLabels map[string]string
+}
+
+type eventList struct {
+	Items []struct {
+		InvolvedObject struct {
+			Kind string `json:"kind"`
+			Name string `json:"name"`
+		} `json:"involvedObject"`
+		Type          string     `json:"type"`
+		Reason        string     `json:"reason"`
+		Message       string     `json:"message"`
+		LastTimestamp *time.Time `json:"lastTimestamp"`
+		EventTime     *time.Time `json:"eventTime"`
+	} `json:"items"`
+}
+
+func dashboardWorkloads(input string) []dashboardWorkload {
+	workloads := []dashboardWorkload{}
+	forEachKubeObject(input, func(obj *KubeObject) bool {
+		if (strings.EqualFold(obj.Kind, "deployment") || strings.EqualFold(obj.Kind, "statefulset")) &&
+			len(obj.Spec.Selector.MatchLabels) > 0 {
+			workloads = append(workloads, dashboardWorkload{
+				Name:        obj.Metadata.Name,
+				Kind:        obj.Kind,
+				MatchLabels: obj.Spec.Selector.MatchLabels,
+			})
+		}
+		return true
+	})
+	return workloads
+}
+
+func (workload dashboardWorkload) matches(labels map[string]string) bool {
+	for k, v := range workload.MatchLabels {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Summarizes a pod's status like the STATUS column of `kubectl get pods`.
+func podStatus(phase string, reason string, terminating bool, statuses []containerStatus) string {
+	if terminating {
+		return "Terminating"
+	}
+	for _, status := range statuses {
+		switch {
+		case status.State.Waiting != nil && status.State.Waiting.Reason != "":
+			return status.State.Waiting.Reason
+		case status.State.Terminated != nil && status.State.Terminated.Reason != "":
+			return status.State.Terminated.Reason
+		}
+	}
+	if reason != "" {
+		return reason
+	}
+	return phase
+}
+
+func buildPodDashboard(workloads []dashboardWorkload, pods podList, events eventList, now time.Time) ([]dashboardGroup, []dashboardEvent) {
+	groups := make([]dashboardGroup, len(workloads)+1)
+	for i, workload := range workloads {
+		groups[i].Workload = workload.Kind + " " + workload.Name
+	}
+
+	names := map[string]bool{}
+	for _, pod := range pods.Items {
+		ready := 0
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Ready {
+				ready++
+			}
+		}
+		images := []string{}
+		for _, container := range pod.Spec.Containers {
+			images = append(images, container.Image)
+		}
+		p := dashboardPod{
+			Name:   pod.Metadata.Name,
+			Ready:  fmt.Sprintf("%v/%v", ready, len(pod.Spec.Containers)),
+			Status: podStatus(pod.Status.Phase, pod.Status.Reason, pod.Metadata.DeletionTimestamp != nil, pod.Status.ContainerStatuses),
+			Age:    now.Sub(pod.Metadata.CreationTimestamp),
+			Images: images,
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			p.Restarts += status.RestartCount
+		}
+
+		i := len(workloads)
+		for j, workload := range workloads {
+			if workload.matches(pod.Metadata.Labels) {
+				i = j
+				break
+			}
+		}
+		groups[i].Pods = append(groups[i].Pods, p)
+		names[p.Name] = true
+	}
+
+	nonEmpty := []dashboardGroup{}
+	for _, group := range groups {
+		if len(group.Pods) > 0 {
+			sort.Slice(group.Pods, func(i, j int) bool { return group.Pods[i].Name < group.Pods[j].Name })
+			nonEmpty = append(nonEmpty, group)
+		}
+	}
+
+	recent := []dashboardEvent{}
+	for _, event := range events.Items {
+		if event.InvolvedObject.Kind != "Pod" || !names[event.InvolvedObject.Name] {
+			continue
+		}
+		e := dashboardEvent{
+			Pod:     event.InvolvedObject.Name,
+			Type:    event.Type,
+			Reason:  event.Reason,
+			Message: strings.TrimSpace(event.Message),
+		}
+		if event.LastTimestamp != nil {
+			e.Time = *event.LastTimestamp
+		} else if event.EventTime != nil {
+			e.Time = *event.EventTime
+		}
+		recent = append(recent, e)
+	}
+	sort.SliceStable(recent, func(i, j int) bool { return recent[i].Time.Before(recent[j].Time) })
+	if len(recent) > podDashboardEvents {
+		recent = recent[len(recent)-podDashboardEvents:]
+	}
+	return nonEmpty, recent
+}
+
+// Formats an age like `kubectl get`, eg: `45s`, `12m` or `3h`.
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return fmt.Sprintf("%vs", int(age.Seconds()))
+	case age < time.Hour:
+		return fmt.Sprintf("%vm", int(age.Minutes()))
+	case age < 48*time.Hour:
+		return fmt.Sprintf("%vh", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%vd", int(age.Hours()/24))
+	}
+}
+
+func (dashboard *podDashboard) pods() []dashboardPod {
+	pods := []dashboardPod{}
+	for _, group := range dashboard.Groups {
+		pods = append(pods, group.Pods...)
+	}
+	return pods
+}
+
+// The selected pod, if there are any pods.
+func (dashboard *podDashboard) selectedPod() (dashboardPod, bool) {
+	pods := dashboard.pods()
+	if len(pods) == 0 {
+		return dashboardPod{}, false
+	}
+	if dashboard.Selected >= len(pods) {
+		dashboard.Selected = len(pods) - 1
+	}
+	return pods[dashboard.Selected], true
+}
+
+func (dashboard *podDashboard) render(colors util.ListingColors) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%vPods in namespace %v%v, updated %v\n", colors.Header, dashboard.Namespace, colors.Reset,
+		dashboard.Updated.Format("15:04:05"))
+	fmt.Fprintf(&b, "up/down or j/k: select, l: logs, d: describe, q: quit\n")
+	if dashboard.Err != nil {
+		fmt.Fprintf(&b, "\n%vUnable to refresh: %v%v\n", colors.Removed, dashboard.Err, colors.Reset)
+	}
+
+	i := 0
+	for _, group := range dashboard.Groups {
+		workload := group.Workload
+		if workload == "" {
+			workload = "Other pods"
+		}
+		fmt.Fprintf(&b, "\n%v%v%v\n", colors.Header, workload, colors.Reset)
+
+		w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "  \tNAME\tREADY\tSTATUS\tRESTARTS\tAGE\tIMAGES\n")
+		for _, pod := range group.Pods {
+			marker := ""
+			if i == dashboard.Selected {
+				marker = ">"
+			}
+			fmt.Fprintf(w, "%v \t%v\t%v\t%v\t%v\t%v\t%v\n", marker, pod.Name, pod.Ready, pod.Status, pod.Restarts,
+				formatAge(pod.Age), strings.Join(pod.Images, ","))
+			i++
+		}
+		w.Flush()
+	}
+	if i == 0 {
+		fmt.Fprintf(&b, "\nNo pods found\n")
+	}
+
+	if len(dashboard.Events) > 0 {
+		fmt.Fprintf(&b, "\n%vRecent events%v\n", colors.Header, colors.Reset)
+		w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+		for _, event := range dashboard.Events {
+			color, reset := "", ""
+			if event.Type == "Warning" {
+				color, reset = colors.Changed, colors.Reset
+			}
+			fmt.Fprintf(w, "  %v\t%v\t%v%v%v\t%v\n", event.Time.Local().Format("15:04:05"), event.Pod,
+				color, event.Reason, reset, event.Message)
+		}
+		w.Flush()
+	}
+	return b.String()
+}
+
+func (stage *PodDashboardStage) refresh(ctx *ankh.ExecutionContext, dashboard *podDashboard, workloads []dashboardWorkload,
+	selectorArgs []string) {
+	pods := podList{}
+	cmd := newKubectlCommand(ctx, dashboard.Namespace)
+	cmd.AddArguments([]string{"get", "pods", "-o", "json"})
+	cmd.AddArguments(selectorArgs)
+	out, err := cmd.Run(ctx, nil)
+	if err == nil {
+		err = json.Unmarshal([]byte(out), &pods)
+	}
+	if err != nil {
+		dashboard.Err = err
+		return
+	}
+
+	// Events only add context, so the pods are still shown without them.
+	events := eventList{}
+	cmd = newKubectlCommand(ctx, dashboard.Namespace)
+	cmd.AddArguments([]string{"get", "events", "-o", "json"})
+	if out, err := cmd.Run(ctx, nil); err == nil {
+		json.Unmarshal([]byte(out), &events)
+	}
+
+	dashboard.Updated = time.Now()
+	dashboard.Groups, dashboard.Events = buildPodDashboard(workloads, pods, events, dashboard.Updated)
+	dashboard.Err = nil
+}
+
+// Runs stty on the terminal, eg: to stop it from echoing keys.
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// Sends each key read from the terminal. Escape sequences, eg: for the arrow
+// keys, usually arrive in a single read, and are sent as one key.
+func readKeys(tty *os.File, keys chan<- string) {
+	buf := make([]byte, 16)
+	for {
+		n, err := tty.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+		keys <- string(buf[:n])
+	}
+}
+
+// Shows the output of a command, eg: a pod's logs, in place of the dashboard,
+// scrolled to its end, until escape or q is pressed.
+func viewOutput(title string, output string, keys <-chan string, sigs <-chan os.Signal) bool {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	rows, _ := util.TerminalSize()
+	height := rows - 2
+	if height <= 0 {
+		height = 20
+	}
+	offset := len(lines) - height
+	for {
+		if offset > len(lines)-height {
+			offset = len(lines) - height
+		}
+		if offset < 0 {
+			offset = 0
+		}
+		end := offset + height
+		if end > len(lines) {
+			end = len(lines)
+		}
+		fmt.Print("\x1B[H\x1B[2J")
+		fmt.Printf("%v (up/down or j/k: scroll, q: back)\r\n", title)
+		fmt.Print(strings.Join(lines[offset:end], "\r\n"))
+
+		select {
+		case <-sigs:
+			return false
+		case key, ok := <-keys:
+			if !ok {
+				return false
+			}
+			switch key {
+			case "q", "\x1B":
+				return true
+			case "\x03":
+				return false
+			case "j", "\x1B[B":
+				offset++
+			case "k", "\x1B[A":
+				offset--
+			case " ", "\x1B[6~":
+				offset += height
+			case "b", "\x1B[5~":
+				offset -= height
+			}
+		}
+	}
+}
+
+func (stage *PodDashboardStage) Execute(ctx *ankh.ExecutionContext, input *string, namespace string, wildCardLabels []string) (string, error) {
+	if input == nil {
+		panic("kubectl cannot execute on nil input")
+	}
+
+	if !isatty.IsTerminal(os.Stdout.Fd()) {
+		return "", fmt.Errorf("The pod dashboard needs a terminal. Use `ankh pods --watch` without `--ui` instead")
+	}
+
+	selectorArgs, err := getPodSelectorArgsFromInput(ctx, *input)
+	if err != nil {
+		return "", err
+	}
+	selectorArgs = append(selectorArgs, getWildCardLabels(ctx, wildCardLabels)...)
+	workloads := dashboardWorkloads(*input)
+
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return "", fmt.Errorf("Unable to open the terminal: %v", err)
+	}
+	defer tty.Close()
+
+	// Read single keys without echoing them, and restore the terminal on the way out.
+	saved, err := stty(tty, "-g")
+	if err != nil {
+		return "", fmt.Errorf("Unable to read the terminal settings: %v", err)
+	}
+	if _, err := stty(tty, "-icanon", "-echo", "-isig", "min", "1"); err != nil {
+		return "", fmt.Errorf("Unable to change the terminal settings: %v", err)
+	}
+	fmt.Print("\x1B[?1049h\x1B[?25l")
+	defer func() {
+		fmt.Print("\x1B[?25h\x1B[?1049l")
+		stty(tty, saved)
+	}()
+
+	// Interrupts end the dashboard, rather than Ankh. Catch signals for the
+	// whole dashboard, rather than for each command it runs.
+	shouldCatchSignals := ctx.ShouldCatchSignals
+	ctx.ShouldCatchSignals = false
+	ctx.CatchSignals = true
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer func() {
+		signal.Stop(sigs)
+		ctx.ShouldCatchSignals = shouldCatchSignals
+		ctx.CatchSignals = false
+	}()
+
+	keys := make(chan string)
+	go readKeys(tty, keys)
+
+	colors := util.GetListingColors(ctx)
+	dashboard := &podDashboard{Namespace: namespace}
+	ticker := time.NewTicker(podDashboardInterval)
+	defer ticker.Stop()
+
+	stage.refresh(ctx, dashboard, workloads, selectorArgs)
+	for {
+		fmt.Print("\x1B[H\x1B[2J")
+		fmt.Print(strings.Replace(dashboard.render(colors), "\n", "\r\n", -1))
+
+		select {
+		case <-sigs:
+			return "", nil
+		case <-ticker.C:
+			stage.refresh(ctx, dashboard, workloads, selectorArgs)
+		case key, ok := <-keys:
+			if !ok {
+				return "", nil
+			}
+			switch key {
+			case "q", "\x03":
+				return "", nil
+			case "j", "\x1B[B":
+				if dashboard.Selected < len(dashboard.pods())-1 {
+					dashboard.Selected++
+				}
+			case "k", "\x1B[A":
+				if dashboard.Selected > 0 {
+					dashboard.Selected--
+				}
+			case "l", "d":
+				pod, ok := dashboard.selectedPod()
+				if !ok {
+					continue
+				}
+				cmd := newKubectlCommand(ctx, namespace)
+				title := "Logs of " + pod.Name
+				if key == "l" {
+					cmd.AddArguments([]string{"logs", pod.Name, "--all-containers", "--tail", strconv.Itoa(podDashboardLogLines)})
+				} else {
+					cmd.AddArguments([]string{"describe", "pod", pod.Name})
+					title = "Description of " + pod.Name
+				}
+				out, err := cmd.Run(ctx, nil)
+				if err != nil {
+					out = err.Error()
+				}
+				if !viewOutput(title, out, keys, sigs) {
+					return "", nil
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package kubectl
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appnexus/ankh/util"
+)
+
+const dashboardInput = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+spec:
+  selector:
+    matchLabels:
+      app: api
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+spec:
+  selector:
+    matchLabels:
+      app: db
+`
+
+const dashboardPodsJSON = `{
+  "items": [
+    {
+      "metadata": {"name": "api-2", "labels": {"app": "api"}, "creationTimestamp": "2019-03-01T11:57:00Z"},
+      "spec": {"containers": [{"name": "app", "image": "api:1.2"}, {"name": "proxy", "image": "envoy:1.9"}]},
+      "status": {
+        "phase": "Running",
+        "containerStatuses": [
+          {"name": "app", "ready": false, "restartCount": 3, "state": {"waiting": {"reason": "CrashLoopBackOff"}}},
+          {"name": "proxy", "ready": true, "restartCount": 1, "state": {"running": {}}}
+        ]
+      }
+    },
+    {
+      "metadata": {"name": "api-1", "labels": {"app": "api"}, "creationTimestamp": "2019-03-01T09:00:00Z",
+        "deletionTimestamp": "2019-03-01T12:00:00Z"},
+      "spec": {"containers": [{"name": "app", "image": "api:1.1"}]},
+      "status": {"phase": "Running", "containerStatuses": [{"name": "app", "ready": true, "state": {"running": {}}}]}
+    },
+    {
+      "metadata": {"name": "db-0", "labels": {"app": "db"}, "creationTimestamp": "2019-02-20T12:00:00Z"},
+      "spec": {"containers": [{"name": "db", "image": "postgres:11"}]},
+      "status": {"phase": "Pending", "reason": "Unschedulable"}
+    }
+  ]
+}`
+
+const dashboardEventsJSON = `{
+  "items": [
+    {"involvedObject": {"kind": "Pod", "name": "api-2"}, "type": "Warning", "reason": "BackOff",
+      "message": "Back-off restarting failed container", "lastTimestamp": "2019-03-01T11:59:00Z"},
+    {"involvedObject": {"kind": "Pod", "name": "api-2"}, "type": "Normal", "reason": "Pulled",
+      "message": "Container image \"api:1.2\" already present", "lastTimestamp": "2019-03-01T11:58:00Z"},
+    {"involvedObject": {"kind": "Pod", "name": "web-1"}, "type": "Normal", "reason": "Pulled",
+      "message": "Not one of the chart's pods", "lastTimestamp": "2019-03-01T11:58:30Z"},
+    {"involvedObject": {"kind": "Deployment", "name": "api"}, "type": "Normal", "reason": "ScalingReplicaSet",
+      "message": "Scaled up", "lastTimestamp": "2019-03-01T11:57:00Z"}
+  ]
+}`
+
+func TestBuildPodDashboard(t *testing.T) {
+	pods := podList{}
+	events := eventList{}
+	if err := json.Unmarshal([]byte(dashboardPodsJSON), &pods); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(dashboardEventsJSON), &events); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	groups, recent := buildPodDashboard(dashboardWorkloads(dashboardInput), pods, events, now)
+
+	expected := []dashboardGroup{
+		{
+			Workload: "Deployment api",
+			Pods: []dashboardPod{
+				{Name: "api-1", Ready: "1/1", Status: "Terminating", Age: 3 * time.Hour, Images: []string{"api:1.1"}},
+				{Name: "api-2", Ready: "1/2", Status: "CrashLoopBackOff", Restarts: 4, Age: 3 * time.Minute,
+					Images: []string{"api:1.2", "envoy:1.9"}},
+			},
+		},
+		{
+			Workload: "StatefulSet db",
+			Pods: []dashboardPod{
+				{Name: "db-0", Ready: "0/1", Status: "Unschedulable", Age: 9 * 24 * time.Hour, Images: []string{"postgres:11"}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Logf("expected groups %+v but got %+v", expected, groups)
+		t.Fail()
+	}
+
+	if len(recent) != 2 || recent[0].Reason != "Pulled" || recent[1].Reason != "BackOff" {
+		t.Logf("expected the two events of api-2, oldest first, but got %+v", recent)
+		t.Fail()
+	}
+
+	dashboard := &podDashboard{Namespace: "api", Updated: now, Groups: groups, Events: recent, Selected: 1}
+	out := dashboard.render(util.ListingColors{})
+	for _, expected := range []string{"Deployment api", "StatefulSet db", "api:1.2,envoy:1.9", "9d", "BackOff"} {
+		if !strings.Contains(out, expected) {
+			t.Logf("expected %q in the dashboard but got:\n%v", expected, out)
+			t.Fail()
+		}
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, ">") && !strings.Contains(line, "api-2") {
+			t.Logf("expected api-2 to be selected but got %q", line)
+			t.Fail()
+		}
+	}
+	if pod, ok := dashboard.selectedPod(); !ok || pod.Name != "api-2" {
+		t.Logf("expected api-2 to be selected but got %+v", pod)
+		t.Fail()
+	}
+}
@@ -18,8 +18,13 @@ func NewPodSelectionStage() plan.Stage {
 	return &KubectlRunner{kubectl: &PodSelectionStage{}}
 }
 
-// This function is suitable for parsing the data that comes out of the pod selection phase.
-func getPodAndContainerSelection(ctx *ankh.ExecutionContext, kubectlOut string) ([]string, error) {
+func (stage *PodSelectionStage) Idempotent() bool {
+	return true
+}
+
+// Selects one of the pods listed by the pod selection phase, returning the
+// fields of its line.
+func selectPod(ctx *ankh.ExecutionContext, kubectlOut string) ([]string, error) {
 	if len(kubectlOut) <= 1 {
 		return []string{}, fmt.Errorf("No pods found for input chart")
 	}
@@ -60,13 +65,26 @@ func getPodAndContainerSelection(ctx *ankh.ExecutionContext, kubectlOut string)
 		lineSelection = lines[1]
 	}
 
-	fields := strings.Fields(lineSelection)
+	return strings.Fields(lineSelection), nil
+}
+
+// This function is suitable for parsing the data that comes out of the pod selection phase.
+func getPodAndContainerSelection(ctx *ankh.ExecutionContext, kubectlOut string) ([]string, error) {
+	fields, err := selectPod(ctx, kubectlOut)
+	if err != nil {
+		return []string{}, err
+	}
 	podSelection := fields[0]
-	containers := strings.Split(fields[3], ",")
+	containerSelection, err := selectContainer(ctx, strings.Split(fields[3], ","))
+	if err != nil {
+		return []string{}, err
+	}
+
+	return []string{podSelection, "-c", containerSelection}, nil
+}
 
+func selectContainer(ctx *ankh.ExecutionContext, containers []string) (string, error) {
 	// It's possible that container was already specified via `-c` as extra args.
-	var err error
-	containerSelection := ""
 	containerSelected := false
 	for _, extra := range ctx.ExtraArgs {
 		if extra == "-c" {
@@ -76,17 +94,45 @@ func getPodAndContainerSelection(ctx *ankh.ExecutionContext, kubectlOut string)
 	}
 	if !containerSelected && len(containers) > 1 {
 		if ctx.NoPrompt {
-			return []string{}, fmt.Errorf("Must pass a container via `-c` when using `--no-prompt`")
+			return "", fmt.Errorf("Must pass a container via `-c` when using `--no-prompt`")
 		}
-		containerSelection, err = util.PromptForSelection(containers, "Select a container", false)
-		if err != nil {
-			return []string{}, err
+		return util.PromptForSelection(containers, "Select a container", false)
+	}
+	return containers[0], nil
+}
+
+// Like getPodAndContainerSelection, but selects every pod, for `--all`. Pods
+// of a chart are expected to share containers, so the container is selected
+// once, from the first pod.
+func getAllPodsAndContainerSelection(ctx *ankh.ExecutionContext, kubectlOut string) ([]string, string, error) {
+	lines := strings.Split(strings.Trim(kubectlOut, "\n "), "\n")
+	if len(lines) <= 1 {
+		return []string{}, "", fmt.Errorf("No pods found for input chart")
+	}
+
+	// lines[0] is the header line.
+	pods := []string{}
+	var containers []string
+	for _, line := range lines[1:] {
+		fields := strings.Fields(strings.Trim(line, ", "))
+		if len(fields) < 4 {
+			continue
 		}
-	} else {
-		containerSelection = containers[0]
+		pods = append(pods, fields[0])
+		if containers == nil {
+			containers = strings.Split(fields[3], ",")
+		}
+	}
+	if len(pods) == 0 {
+		return []string{}, "", fmt.Errorf("No pods found for input chart")
 	}
+	sort.Strings(pods)
 
-	return []string{podSelection, "-c", containerSelection}, nil
+	containerSelection, err := selectContainer(ctx, containers)
+	if err != nil {
+		return []string{}, "", err
+	}
+	return pods, containerSelection, nil
 }
 
 func (stage *PodSelectionStage) GetCommand(ctx *ankh.ExecutionContext, namespace string) plan.Command {
@@ -16,6 +16,10 @@ func NewPodStage() plan.Stage {
 	return &KubectlRunner{kubectl: &PodStage{}}
 }
 
+func (stage *PodStage) Idempotent() bool {
+	return true
+}
+
 func getPodSelectorArgsFromInput(ctx *ankh.ExecutionContext, input string) ([]string, error) {
 	args := []string{}
 	matchLabels := make(map[string][]string)
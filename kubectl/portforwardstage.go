@@ -0,0 +1,250 @@
+package kubectl
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+	"github.com/appnexus/ankh/util"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// PortForwardStage runs `kubectl port-forward` to one of a chart's Services,
+// or, for charts without Services, to one of its pods, reconnecting whenever
+// kubectl exits until it is interrupted.
+type PortForwardStage struct{}
+
+func NewPortForwardStage() plan.Stage {
+	return &PortForwardStage{}
+}
+
+// How long to wait before reconnecting, and how many times in a row kubectl
+// may fail within portForwardMinUptime of starting before Ankh gives up, eg:
+// for a local port that is already in use.
+var (
+	portForwardReconnectDelay = 2 * time.Second
+	portForwardMinUptime      = 10 * time.Second
+	portForwardMaxQuickFails  = 5
+)
+
+// A Service, or the ports of a pod, to forward to.
+type portForwardTarget struct {
+	// As given to kubectl, eg: `service/web`
+	Name  string
+	Ports []portForwardPort
+}
+
+type portForwardPort struct {
+	Name string
+	Port int
+}
+
+func (port portForwardPort) String() string {
+	if port.Name == "" {
+		return strconv.Itoa(port.Port)
+	}
+	return fmt.Sprintf("%v (%v)", port.Port, port.Name)
+}
+
+type forwardableObject struct {
+	Kind     string
+	Metadata struct {
+		Name string
+	}
+	Spec struct {
+		Ports    []portForwardPort
+		Template struct {
+			Spec struct {
+				Containers []struct {
+					Ports []struct {
+						Name          string
+						ContainerPort int `yaml:"containerPort"`
+					}
+				}
+			}
+		}
+	}
+}
+
+// Finds the Services of the templated objects, and the container ports of
+// its Deployments and StatefulSets, for when there are no Services.
+func portForwardTargets(input string) ([]portForwardTarget, []portForwardPort, error) {
+	services := []portForwardTarget{}
+	podPorts := []portForwardPort{}
+	decoder := yaml.NewDecoder(strings.NewReader(input))
+	for {
+		obj := forwardableObject{}
+		err := decoder.Decode(&obj)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		switch {
+		case strings.EqualFold(obj.Kind, "service"):
+			services = append(services, portForwardTarget{Name: "service/" + obj.Metadata.Name, Ports: obj.Spec.Ports})
+		case strings.EqualFold(obj.Kind, "deployment") || strings.EqualFold(obj.Kind, "statefulset"):
+			for _, container := range obj.Spec.Template.Spec.Containers {
+				for _, port := range container.Ports {
+					podPorts = append(podPorts, portForwardPort{Name: port.Name, Port: port.ContainerPort})
+				}
+			}
+		}
+	}
+	return services, podPorts, nil
+}
+
+func selectPortForwardTarget(ctx *ankh.ExecutionContext, services []portForwardTarget) (portForwardTarget, error) {
+	if len(services) == 1 {
+		return services[0], nil
+	}
+	if ctx.NoPrompt {
+		ctx.Logger.Warnf("Selecting first service (of %d) \"%v\" due to `--no-prompt`", len(services), services[0].Name)
+		return services[0], nil
+	}
+
+	names := []string{}
+	for _, service := range services {
+		names = append(names, service.Name)
+	}
+	selection, err := util.PromptForSelection(names, "Select a service", false)
+	if err != nil {
+		return portForwardTarget{}, err
+	}
+	for _, service := range services {
+		if service.Name == selection {
+			return service, nil
+		}
+	}
+	return portForwardTarget{}, fmt.Errorf("Unknown service \"%v\"", selection)
+}
+
+// Selects the port to forward to, from `--remote-port`, or from the ports of
+// the target.
+func selectRemotePort(ctx *ankh.ExecutionContext, target portForwardTarget) (int, error) {
+	if ctx.RemotePort != 0 {
+		return ctx.RemotePort, nil
+	}
+	switch {
+	case len(target.Ports) == 0:
+		return 0, fmt.Errorf("No ports found for %v. Pass the port to forward to with `--remote-port`", target.Name)
+	case len(target.Ports) == 1:
+		return target.Ports[0].Port, nil
+	case ctx.NoPrompt:
+		ctx.Logger.Warnf("Selecting first port (of %d) %v due to `--no-prompt`", len(target.Ports), target.Ports[0])
+		return target.Ports[0].Port, nil
+	}
+
+	choices := []string{}
+	for _, port := range target.Ports {
+		choices = append(choices, port.String())
+	}
+	selection, err := util.PromptForSelection(choices, "Select a port", false)
+	if err != nil {
+		return 0, err
+	}
+	for _, port := range target.Ports {
+		if port.String() == selection {
+			return port.Port, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown port \"%v\"", selection)
+}
+
+// Selects one of the chart's pods, as `exec` and `logs` do.
+func selectPortForwardPod(ctx *ankh.ExecutionContext, input string, namespace string, wildCardLabels []string) (string, error) {
+	args, err := (&PodSelectionStage{}).GetArgsFromInput(ctx, input, wildCardLabels)
+	if err != nil {
+		return "", err
+	}
+	cmd := newKubectlCommand(ctx, namespace)
+	cmd.AddArguments([]string{"get", "pods"})
+	cmd.AddArguments(args)
+	out, err := runWithRetry(ctx, &cmd, nil)
+	if err != nil {
+		return "", err
+	}
+	fields, err := selectPod(ctx, out)
+	if err != nil {
+		return "", err
+	}
+	return "pod/" + fields[0], nil
+}
+
+// Runs kubectl until it is interrupted, reconnecting when it exits, eg: when
+// the pod it forwards to is replaced.
+func runPortForward(ctx *ankh.ExecutionContext, cmd plan.Command) error {
+	// Catch signals while kubectl runs, so that an interrupt stops the port
+	// forward, and Ankh along with it, rather than causing a reconnect.
+	shouldCatchSignals := ctx.ShouldCatchSignals
+	ctx.ShouldCatchSignals = true
+	defer func() {
+		ctx.ShouldCatchSignals = shouldCatchSignals
+	}()
+
+	quickFails := 0
+	for {
+		started := time.Now()
+		_, err := cmd.Run(ctx, nil)
+		if err == nil || ctx.NoReconnect {
+			return err
+		}
+
+		if time.Since(started) < portForwardMinUptime {
+			quickFails++
+		} else {
+			quickFails = 0
+		}
+		if quickFails >= portForwardMaxQuickFails {
+			return fmt.Errorf("Giving up after kubectl port-forward failed %v times in a row: %v", quickFails, err)
+		}
+
+		ctx.Logger.Warnf("kubectl port-forward exited (%v), reconnecting in %v", err, portForwardReconnectDelay)
+		sleep(portForwardReconnectDelay)
+	}
+}
+
+func (stage *PortForwardStage) Execute(ctx *ankh.ExecutionContext, input *string, namespace string, wildCardLabels []string) (string, error) {
+	if input == nil {
+		panic("kubectl cannot execute on nil input")
+	}
+
+	services, podPorts, err := portForwardTargets(*input)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read the templated objects: %v", err)
+	}
+
+	var target portForwardTarget
+	if len(services) > 0 {
+		target, err = selectPortForwardTarget(ctx, services)
+	} else {
+		ctx.Logger.Infof("No Services found for input chart, forwarding to a pod instead")
+		target.Ports = podPorts
+		target.Name, err = selectPortForwardPod(ctx, *input, namespace, wildCardLabels)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	remotePort, err := selectRemotePort(ctx, target)
+	if err != nil {
+		return "", err
+	}
+	localPort := ctx.LocalPort
+	if localPort == 0 {
+		localPort = remotePort
+	}
+
+	cmd := newKubectlCommand(ctx, namespace)
+	cmd.AddArguments([]string{"port-forward", target.Name, fmt.Sprintf("%v:%v", localPort, remotePort)})
+	cmd.AddArguments(ctx.ExtraArgs)
+	cmd.PipeStdoutAndStderr = plan.PIPE_TYPE_STD
+
+	ctx.Logger.Infof("Forwarding localhost:%v to %v port %v in namespace \"%v\"", localPort, target.Name, remotePort, namespace)
+	return "", runPortForward(ctx, cmd)
+}
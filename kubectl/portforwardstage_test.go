@@ -0,0 +1,124 @@
+package kubectl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appnexus/ankh/ankhtest"
+)
+
+const portForwardInput string = `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+  - name: http
+    port: 80
+    targetPort: 8080
+  - name: metrics
+    port: 9090
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web-admin
+spec:
+  ports:
+  - port: 8081
+`
+
+const portForwardPodInput string = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+spec:
+  selector:
+    matchLabels:
+      app: worker
+  template:
+    spec:
+      containers:
+      - name: worker
+        ports:
+        - name: debug
+          containerPort: 6060
+`
+
+func TestPortForwardStage(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl", ankhtest.Rule{})
+	ctx := ankhtest.NewContext(t)
+
+	// The first service, and its first port, are selected under `--no-prompt`.
+	input := portForwardInput
+	if _, err := NewPortForwardStage().Execute(ctx, &input, "web", nil); err != nil {
+		t.Fatal(err)
+	}
+	calls := tools.Calls("kubectl")
+	if len(calls) != 1 || !strings.HasSuffix(strings.Join(calls[0].Args, " "), "port-forward service/web 80:80") {
+		t.Logf("expected a port-forward to service/web but got %+v", calls)
+		t.Fail()
+	}
+
+	ctx.LocalPort, ctx.RemotePort = 18080, 9090
+	if _, err := NewPortForwardStage().Execute(ctx, &input, "web", nil); err != nil {
+		t.Fatal(err)
+	}
+	calls = tools.Calls("kubectl")
+	if len(calls) != 2 || !strings.HasSuffix(strings.Join(calls[1].Args, " "), "port-forward service/web 18080:9090") {
+		t.Logf("expected the given ports to be forwarded but got %+v", calls)
+		t.Fail()
+	}
+}
+
+func TestPortForwardStageWithoutServices(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl",
+		ankhtest.Rule{Args: "* get pods *", Stdout: "NAME STATUS CREATED CONTAINERS\nworker-a1b2 Running 2024-03-01T09:00:00Z worker\n"},
+		ankhtest.Rule{Args: "* port-forward *"},
+	)
+	ctx := ankhtest.NewContext(t)
+
+	input := portForwardPodInput
+	if _, err := NewPortForwardStage().Execute(ctx, &input, "worker", nil); err != nil {
+		t.Fatal(err)
+	}
+	calls := tools.Calls("kubectl")
+	if len(calls) != 2 || !strings.HasSuffix(strings.Join(calls[1].Args, " "), "port-forward pod/worker-a1b2 6060:6060") {
+		t.Logf("expected a port-forward to the pod's container port but got %+v", calls)
+		t.Fail()
+	}
+}
+
+func TestPortForwardStageReconnects(t *testing.T) {
+	slept := []time.Duration{}
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	defer func() { sleep = time.Sleep }()
+
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl", ankhtest.Rule{Stderr: "lost connection to pod\n", ExitCode: 1})
+	ctx := ankhtest.NewContext(t)
+
+	// kubectl failing right away every time is given up on.
+	input := portForwardInput
+	if _, err := NewPortForwardStage().Execute(ctx, &input, "web", nil); err == nil {
+		t.Logf("expected an error once kubectl kept failing")
+		t.Fail()
+	}
+	if calls := tools.Calls("kubectl"); len(calls) != portForwardMaxQuickFails || len(slept) != portForwardMaxQuickFails-1 {
+		t.Logf("expected %v attempts but got %v, after sleeping %v", portForwardMaxQuickFails, len(calls), slept)
+		t.Fail()
+	}
+
+	ctx.NoReconnect = true
+	if _, err := NewPortForwardStage().Execute(ctx, &input, "web", nil); err == nil {
+		t.Logf("expected an error without reconnecting")
+		t.Fail()
+	}
+	if calls := tools.Calls("kubectl"); len(calls) != portForwardMaxQuickFails+1 {
+		t.Logf("expected a single attempt with NoReconnect but got %v", len(calls)-portForwardMaxQuickFails)
+		t.Fail()
+	}
+}
@@ -0,0 +1,108 @@
+package kubectl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appnexus/ankh/context"
+)
+
+// Runs a tunnel command in the background, and stops it once its stdin is
+// closed, which happens when Ankh exits, however it exits. The tunnel itself
+// gets no stdin, so it can't read from Ankh's pipe. The shell exits when the
+// tunnel does.
+const tunnelScript = `exec 3<&0
+%v </dev/null &
+pid=$!
+(read _ <&3; kill $pid) >/dev/null 2>&1 &
+watcher=$!
+wait $pid
+status=$?
+kill $watcher 2>/dev/null
+exit $status
+`
+
+var tunnelsMu sync.Mutex
+
+// The stdin of each tunnel that is open, by command. These are never closed
+// explicitly, but they must be kept, since pipes are closed once collected.
+var tunnels = map[string]io.WriteCloser{}
+
+// Returns the environment kubectl needs to reach the current context through
+// its proxy, opening the context's tunnel first, if it has one that isn't open.
+func proxyEnv(ctx *ankh.ExecutionContext) ([]string, error) {
+	proxy := ctx.AnkhConfig.CurrentContext.Proxy
+	if proxy == nil {
+		return nil, nil
+	}
+	if proxy.Command != "" {
+		if err := openTunnel(ctx, proxy); err != nil {
+			return nil, err
+		}
+	}
+	if proxy.HTTPSProxy == "" {
+		return nil, nil
+	}
+	return []string{"HTTPS_PROXY=" + proxy.HTTPSProxy}, nil
+}
+
+func openTunnel(ctx *ankh.ExecutionContext, proxy *ankh.ProxyConfig) error {
+	tunnelsMu.Lock()
+	defer tunnelsMu.Unlock()
+	if _, ok := tunnels[proxy.Command]; ok {
+		return nil
+	}
+
+	address, err := proxy.ReadyAddress()
+	if err != nil {
+		return err
+	}
+	timeout, err := proxy.WaitTimeout()
+	if err != nil {
+		return err
+	}
+
+	ctx.Logger.Infof("Opening a tunnel for context \"%v\" with `%v`", ctx.AnkhConfig.CurrentContextName, proxy.Command)
+	cmd := exec.Command("sh", "-c", fmt.Sprintf(tunnelScript, proxy.Command))
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Unable to open a tunnel with `%v`: %v", proxy.Command, err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", address, time.Second)
+		if err == nil {
+			conn.Close()
+			break
+		}
+
+		select {
+		case err := <-exited:
+			return fmt.Errorf("The tunnel `%v` exited before accepting connections on %v: %v %v",
+				proxy.Command, address, err, strings.TrimSpace(stderr.String()))
+		case <-time.After(100 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			stdin.Close()
+			return fmt.Errorf("The tunnel `%v` did not accept connections on %v within %v", proxy.Command, address, timeout)
+		}
+	}
+
+	ctx.Logger.Debugf("Tunnel `%v` is accepting connections on %v", proxy.Command, address)
+	tunnels[proxy.Command] = stdin
+	return nil
+}
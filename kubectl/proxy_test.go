@@ -0,0 +1,76 @@
+package kubectl
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+func TestProxyEnv(t *testing.T) {
+	ctx := ankhtest.NewContext(t)
+	if env, err := proxyEnv(ctx); err != nil || env != nil {
+		t.Logf("expected no environment without a proxy but got %v and %v", env, err)
+		t.Fail()
+	}
+
+	// Stands in for the port a tunnel listens on.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	ctx.AnkhConfig.CurrentContext.Proxy = &ankh.ProxyConfig{
+		HTTPSProxy: "socks5://" + listener.Addr().String(),
+		Command:    "sleep 30",
+	}
+	env, err := proxyEnv(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(env) != 1 || env[0] != "HTTPS_PROXY=socks5://"+listener.Addr().String() {
+		t.Logf("expected HTTPS_PROXY to be set but got %v", env)
+		t.Fail()
+	}
+	if _, ok := tunnels["sleep 30"]; !ok {
+		t.Logf("expected the tunnel to stay open")
+		t.Fail()
+	}
+	tunnels["sleep 30"].Close()
+	delete(tunnels, "sleep 30")
+
+	cmd := newKubectlCommand(ctx, "web")
+	if len(cmd.Env) != 1 {
+		t.Logf("expected kubectl to use the proxy but got %v", cmd.Env)
+		t.Fail()
+	}
+	tunnels["sleep 30"].Close()
+	delete(tunnels, "sleep 30")
+}
+
+func TestProxyTunnelFails(t *testing.T) {
+	ctx := ankhtest.NewContext(t)
+	ctx.AnkhConfig.CurrentContext.Proxy = &ankh.ProxyConfig{
+		Command: "echo 'bastion: connection refused' >&2; exit 255",
+		Address: "127.0.0.1:1",
+	}
+	_, err := proxyEnv(ctx)
+	if err == nil || !strings.Contains(err.Error(), "bastion: connection refused") {
+		t.Logf("expected the tunnel's error but got %v", err)
+		t.Fail()
+	}
+	if _, ok := tunnels[ctx.AnkhConfig.CurrentContext.Proxy.Command]; ok {
+		t.Logf("expected a failed tunnel not to be kept")
+		t.Fail()
+	}
+
+	// kubectl is not run without its proxy, and the error is returned rather than exiting.
+	cmd := newKubectlCommand(ctx, "web")
+	if _, err := cmd.Run(ctx, nil); err == nil || !strings.Contains(err.Error(), "through its proxy") {
+		t.Logf("expected the tunnel's error from running kubectl but got %v", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,112 @@
+package kubectl
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+)
+
+const (
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = 1 * time.Second
+	defaultRetryMaxBackoff     = 30 * time.Second
+)
+
+// Classes of kubectl failures that are worth retrying, because the API server
+// is likely to succeed on its own shortly. Anything else, eg: an invalid
+// object, or a forbidden request, fails straight away.
+var transientErrors = []struct {
+	class   string
+	pattern *regexp.Regexp
+}{
+	{"etcd leader change", regexp.MustCompile(`(?i)etcdserver: (leader changed|no leader|request timed out)`)},
+	{"throttled", regexp.MustCompile(`(?i)(too many requests|\b429\b|\(TooManyRequests\))`)},
+	{"timeout", regexp.MustCompile(`(?i)(i/o timeout|TLS handshake timeout|context deadline exceeded|Client\.Timeout exceeded|unable to return a response in the time allotted|request timed out|\(Timeout\))`)},
+	{"server unavailable", regexp.MustCompile(`(?i)(service unavailable|\(ServiceUnavailable\)|\b503\b)`)},
+	{"connection reset", regexp.MustCompile(`(?i)(connection reset by peer|unexpected EOF|http2: server sent GOAWAY)`)},
+}
+
+// Returns the class of a transient kubectl failure, or "" if the failure
+// should not be retried.
+func transientErrorClass(err error, stderr string) string {
+	if err == nil {
+		return ""
+	}
+	for _, transient := range transientErrors {
+		if transient.pattern.MatchString(err.Error()) || transient.pattern.MatchString(stderr) {
+			return transient.class
+		}
+	}
+	return ""
+}
+
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+func parseBackoff(ctx *ankh.ExecutionContext, name string, value string, defaultValue time.Duration) time.Duration {
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		ctx.Logger.Warnf("Ignoring invalid `kubectl.retry.%v` value \"%v\", using %v", name, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+func getRetryPolicy(ctx *ankh.ExecutionContext) retryPolicy {
+	config := ctx.AnkhConfig.Kubectl.Retry
+	policy := retryPolicy{
+		maxAttempts:    config.MaxAttempts,
+		initialBackoff: parseBackoff(ctx, "initialBackoff", config.InitialBackoff, defaultRetryInitialBackoff),
+		maxBackoff:     parseBackoff(ctx, "maxBackoff", config.MaxBackoff, defaultRetryMaxBackoff),
+	}
+	if policy.maxAttempts <= 0 {
+		policy.maxAttempts = defaultRetryMaxAttempts
+	}
+	return policy
+}
+
+// The backoff doubles after every attempt, up to the maximum.
+func (policy retryPolicy) backoff(attempt int) time.Duration {
+	d := policy.initialBackoff
+	for i := 1; i < attempt && d < policy.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > policy.maxBackoff {
+		d = policy.maxBackoff
+	}
+	return d
+}
+
+// Replaced in tests.
+var sleep = time.Sleep
+
+// Runs a kubectl command, retrying it when it fails in a way that is likely
+// transient. Only commands whose stderr is piped can be classified, so
+// interactive commands are never retried.
+func runWithRetry(ctx *ankh.ExecutionContext, cmd *plan.Command, input *string) (string, error) {
+	policy := getRetryPolicy(ctx)
+	for attempt := 1; ; attempt++ {
+		out, err := cmd.Run(ctx, input)
+		class := transientErrorClass(err, cmd.Stderr())
+		if class == "" || attempt >= policy.maxAttempts {
+			if class != "" {
+				err = fmt.Errorf("%v (giving up after %v attempts)", err, attempt)
+			}
+			return out, err
+		}
+
+		backoff := policy.backoff(attempt)
+		ctx.Logger.Warnf("kubectl failed with a transient error (%v), retrying in %v (attempt %v of %v)",
+			class, backoff, attempt+1, policy.maxAttempts)
+		sleep(backoff)
+	}
+}
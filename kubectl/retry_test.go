@@ -0,0 +1,113 @@
+package kubectl
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+)
+
+func TestTransientErrorClass(t *testing.T) {
+	cases := map[string]string{
+		"Error from server: etcdserver: leader changed":                                                "etcd leader change",
+		"Error from server (TooManyRequests): the server has received too many requests":               "throttled",
+		"Unable to connect to the server: net/http: TLS handshake timeout":                             "timeout",
+		"Error from server (ServiceUnavailable): the server is currently unable to handle the request": "server unavailable",
+		"read tcp 10.0.0.1:443: read: connection reset by peer":                                        "connection reset",
+		"Error from server (NotFound): deployments.apps \"foo\" not found":                             "",
+		"The Deployment \"foo\" is invalid: spec.replicas: Invalid value: -1":                          "",
+	}
+	for stderr, expected := range cases {
+		if class := transientErrorClass(fmt.Errorf("exit status 1"), stderr); class != expected {
+			t.Logf("expected class '%v' for '%v' but got '%v'", expected, stderr, class)
+			t.Fail()
+		}
+	}
+
+	if class := transientErrorClass(nil, "etcdserver: leader changed"); class != "" {
+		t.Logf("expected no class without an error, but got '%v'", class)
+		t.Fail()
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := retryPolicy{maxAttempts: 10, initialBackoff: time.Second, maxBackoff: 5 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, d := range expected {
+		if backoff := policy.backoff(i + 1); backoff != d {
+			t.Logf("expected backoff %v after attempt %v but got %v", d, i+1, backoff)
+			t.Fail()
+		}
+	}
+}
+
+func TestRunWithRetry(t *testing.T) {
+	slept := []time.Duration{}
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	defer func() { sleep = time.Sleep }()
+
+	ctx := &ankh.ExecutionContext{Logger: logrus.New()}
+	ctx.AnkhConfig.Kubectl.Retry = ankh.KubectlRetryConfig{MaxAttempts: 3, InitialBackoff: "10ms"}
+
+	t.Run("transient errors are retried", func(t *testing.T) {
+		slept = slept[:0]
+		cmd := plan.NewCommand("sh")
+		cmd.AddArguments([]string{"-c", "echo 'etcdserver: leader changed' >&2; exit 1"})
+		_, err := runWithRetry(ctx, &cmd, nil)
+		if err == nil {
+			t.Log("expected an error after running out of attempts")
+			t.Fail()
+		}
+		if len(slept) != 2 || slept[0] != 10*time.Millisecond || slept[1] != 20*time.Millisecond {
+			t.Logf("expected two backoffs of 10ms and 20ms but got %v", slept)
+			t.Fail()
+		}
+	})
+
+	t.Run("other errors are not retried", func(t *testing.T) {
+		slept = slept[:0]
+		cmd := plan.NewCommand("sh")
+		cmd.AddArguments([]string{"-c", "echo 'Error from server (Forbidden)' >&2; exit 1"})
+		if _, err := runWithRetry(ctx, &cmd, nil); err == nil {
+			t.Log("expected an error")
+			t.Fail()
+		}
+		if len(slept) != 0 {
+			t.Logf("expected no retries but got %v", slept)
+			t.Fail()
+		}
+	})
+}
+
+func TestOnlyIdempotentStagesAreRetried(t *testing.T) {
+	sleep = func(d time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl", ankhtest.Rule{Stderr: "unexpected EOF\n", ExitCode: 1})
+	ctx := ankhtest.NewContext(t)
+	ctx.AnkhConfig.Kubectl.Retry = ankh.KubectlRetryConfig{MaxAttempts: 3}
+
+	input := "kind: Deployment\nmetadata:\n  name: app\n"
+	if _, err := NewApplyStage().Execute(ctx, &input, "web", nil); err == nil {
+		t.Fatal("expected the apply to fail")
+	}
+	if calls := tools.Calls("kubectl"); len(calls) != 3 {
+		t.Logf("expected apply to be attempted 3 times but got %v", len(calls))
+		t.Fail()
+	}
+
+	// Undoing a rollout again would undo a workload that was already undone.
+	if _, err := NewRollbackStage(0).Execute(ctx, &input, "web", nil); err == nil {
+		t.Fatal("expected the rollback to fail")
+	}
+	if calls := tools.Calls("kubectl"); len(calls) != 4 {
+		t.Logf("expected rollout undo to be attempted once but got %v", len(calls)-3)
+		t.Fail()
+	}
+}
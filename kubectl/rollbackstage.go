@@ -10,10 +10,12 @@ import (
 
 type RollbackStage struct {
 	GenericStage
+	// The revision to roll back to, or zero for the previous revision. See `ankh history`.
+	toRevision int
 }
 
-func NewRollbackStage() plan.Stage {
-	return &KubectlRunner{kubectl: &RollbackStage{}}
+func NewRollbackStage(toRevision int) plan.Stage {
+	return &KubectlRunner{kubectl: &RollbackStage{toRevision: toRevision}}
 }
 
 func getDeploymentArgsFromInput(ctx *ankh.ExecutionContext, input string) ([]string, error) {
@@ -35,6 +37,9 @@ func getDeploymentArgsFromInput(ctx *ankh.ExecutionContext, input string) ([]str
 func (stage *RollbackStage) GetCommand(ctx *ankh.ExecutionContext, namespace string) plan.Command {
 	cmd := newKubectlCommand(ctx, namespace)
 	cmd.AddArguments([]string{"rollout", "undo"})
+	if stage.toRevision > 0 {
+		cmd.AddArguments([]string{fmt.Sprintf("--to-revision=%v", stage.toRevision)})
+	}
 	return cmd
 }
 
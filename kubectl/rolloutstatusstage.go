@@ -0,0 +1,70 @@
+package kubectl
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+)
+
+const defaultRolloutTimeout = "5m"
+
+// Waits for every workload in the input to finish rolling out, eg: after an
+// `ankh apply --wait`.
+type RolloutStatusStage struct{}
+
+func NewRolloutStatusStage() plan.Stage {
+	return &RolloutStatusStage{}
+}
+
+// Returns `kind/name` for each object that `kubectl rollout status` can watch.
+func getRolloutObjectsFromInput(input string) []string {
+	objects := []string{}
+	forEachKubeObject(input, func(obj *KubeObject) bool {
+		if strings.EqualFold(obj.Kind, "deployment") ||
+			strings.EqualFold(obj.Kind, "statefulset") ||
+			strings.EqualFold(obj.Kind, "daemonset") {
+			objects = append(objects, fmt.Sprintf("%v/%v", strings.ToLower(obj.Kind), obj.Metadata.Name))
+		}
+		return true
+	})
+	return objects
+}
+
+func (stage *RolloutStatusStage) Execute(ctx *ankh.ExecutionContext, input *string, namespace string, wildCardLabels []string) (string, error) {
+	if input == nil {
+		panic("kubectl cannot execute on nil input")
+	}
+
+	objects := getRolloutObjectsFromInput(*input)
+	if len(objects) == 0 {
+		ctx.Logger.Infof("No Deployments, StatefulSets or DaemonSets to wait for")
+		return "", nil
+	}
+
+	timeout := ctx.WaitTimeout
+	if timeout == "" {
+		timeout = defaultRolloutTimeout
+	}
+
+	// `kubectl rollout status` watches a single object, so wait on each in
+	// turn, and report every failure rather than only the first.
+	failed := []string{}
+	for _, object := range objects {
+		cmd := newKubectlCommand(ctx, namespace)
+		cmd.AddArguments([]string{"rollout", "status", object, "--timeout", timeout})
+		cmd.PipeStdoutAndStderr = plan.PIPE_TYPE_STD
+		if _, err := cmd.Run(ctx, nil); err != nil {
+			ctx.Logger.Errorf("Rollout of %v did not complete: %v", object, err)
+			failed = append(failed, object)
+		}
+	}
+
+	if len(failed) > 0 {
+		return "", fmt.Errorf("%v of %v rollouts did not complete within %v: %v",
+			len(failed), len(objects), timeout, strings.Join(failed, ", "))
+	}
+	ctx.Logger.Infof("All %v rollouts completed", len(objects))
+	return "", nil
+}
@@ -0,0 +1,41 @@
+package kubectl
+
+import (
+	"strings"
+	"testing"
+)
+
+const rolloutManifest string = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+`
+
+func TestGetRolloutObjectsFromInput(t *testing.T) {
+	objects := getRolloutObjectsFromInput(rolloutManifest)
+	expected := "deployment/web statefulset/db daemonset/agent"
+	if strings.Join(objects, " ") != expected {
+		t.Logf("expected %v but got %v", expected, objects)
+		t.Fail()
+	}
+
+	if objects := getRolloutObjectsFromInput("kind: ConfigMap\nmetadata:\n  name: config\n"); len(objects) != 0 {
+		t.Logf("expected no objects but got %v", objects)
+		t.Fail()
+	}
+}
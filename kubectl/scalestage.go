@@ -0,0 +1,209 @@
+package kubectl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+	"github.com/appnexus/ankh/util"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ScaleStage scales the Deployments and StatefulSets of a chart with
+// `kubectl scale`, and patches the replica bounds of its
+// HorizontalPodAutoscalers, after listing them and asking for confirmation.
+type ScaleStage struct{}
+
+func NewScaleStage() plan.Stage {
+	return &ScaleStage{}
+}
+
+// A scalable object of a chart, with what `ankh scale` would change.
+type scaleTarget struct {
+	Kind   string
+	Name   string
+	Change string
+	// For HorizontalPodAutoscalers, the object that they scale, as kind/name
+	ScaleTargetRef string
+}
+
+type scalableObject struct {
+	Kind     string
+	Metadata struct {
+		Name string
+	}
+	Spec struct {
+		ScaleTargetRef struct {
+			Kind string
+			Name string
+		} `yaml:"scaleTargetRef"`
+	}
+}
+
+// Finds the objects to scale: Deployments and StatefulSets with
+// `--replicas`, and HorizontalPodAutoscalers with `--min` or `--max`.
+func scaleTargets(ctx *ankh.ExecutionContext, input string) ([]scaleTarget, error) {
+	targets := []scaleTarget{}
+	decoder := yaml.NewDecoder(strings.NewReader(input))
+	for {
+		obj := scalableObject{}
+		err := decoder.Decode(&obj)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case (strings.EqualFold(obj.Kind, "deployment") || strings.EqualFold(obj.Kind, "statefulset")) && ctx.ScaleReplicas != nil:
+			targets = append(targets, scaleTarget{
+				Kind:   obj.Kind,
+				Name:   obj.Metadata.Name,
+				Change: fmt.Sprintf("replicas=%v", *ctx.ScaleReplicas),
+			})
+		case strings.EqualFold(obj.Kind, "horizontalpodautoscaler") && (ctx.ScaleMinReplicas != nil || ctx.ScaleMaxReplicas != nil):
+			targets = append(targets, scaleTarget{
+				Kind:           obj.Kind,
+				Name:           obj.Metadata.Name,
+				Change:         strings.Join(hpaChanges(ctx), ", "),
+				ScaleTargetRef: fmt.Sprintf("%v/%v", obj.Spec.ScaleTargetRef.Kind, obj.Spec.ScaleTargetRef.Name),
+			})
+		case strings.EqualFold(obj.Kind, "horizontalpodautoscaler"):
+			targets = append(targets, scaleTarget{
+				Kind:           obj.Kind,
+				Name:           obj.Metadata.Name,
+				ScaleTargetRef: fmt.Sprintf("%v/%v", obj.Spec.ScaleTargetRef.Kind, obj.Spec.ScaleTargetRef.Name),
+			})
+		}
+	}
+	return targets, nil
+}
+
+func hpaChanges(ctx *ankh.ExecutionContext) []string {
+	changes := []string{}
+	if ctx.ScaleMinReplicas != nil {
+		changes = append(changes, fmt.Sprintf("minReplicas=%v", *ctx.ScaleMinReplicas))
+	}
+	if ctx.ScaleMaxReplicas != nil {
+		changes = append(changes, fmt.Sprintf("maxReplicas=%v", *ctx.ScaleMaxReplicas))
+	}
+	return changes
+}
+
+func hpaPatch(ctx *ankh.ExecutionContext) string {
+	fields := []string{}
+	if ctx.ScaleMinReplicas != nil {
+		fields = append(fields, fmt.Sprintf(`"minReplicas":%v`, *ctx.ScaleMinReplicas))
+	}
+	if ctx.ScaleMaxReplicas != nil {
+		fields = append(fields, fmt.Sprintf(`"maxReplicas":%v`, *ctx.ScaleMaxReplicas))
+	}
+	return fmt.Sprintf(`{"spec":{%v}}`, strings.Join(fields, ","))
+}
+
+// Lists the objects that will be scaled, eg: for the confirmation prompt.
+func formatScaleTargets(targets []scaleTarget) string {
+	buf := bytes.NewBufferString("")
+	w := tabwriter.NewWriter(buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "KIND\tNAME\tCHANGE\n")
+	for _, target := range targets {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", target.Kind, target.Name, target.Change)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// Returns the scale and patch commands to run, in order.
+func newScaleCommands(ctx *ankh.ExecutionContext, namespace string, targets []scaleTarget) []plan.Command {
+	cmds := []plan.Command{}
+	workloads := []string{}
+	for _, target := range targets {
+		if target.ScaleTargetRef == "" {
+			workloads = append(workloads, fmt.Sprintf("%v/%v", target.Kind, target.Name))
+		}
+	}
+	if len(workloads) > 0 {
+		cmd := newKubectlCommand(ctx, namespace)
+		cmd.AddArguments([]string{"scale", fmt.Sprintf("--replicas=%v", *ctx.ScaleReplicas)})
+		cmd.AddArguments(workloads)
+		cmd.AddArguments(ctx.ExtraArgs)
+		if ctx.DryRun {
+			cmd.AddArguments([]string{"--dry-run"})
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	for _, target := range targets {
+		if target.ScaleTargetRef == "" {
+			continue
+		}
+		cmd := newKubectlCommand(ctx, namespace)
+		cmd.AddArguments([]string{"patch", fmt.Sprintf("%v/%v", target.Kind, target.Name), "--type", "merge", "-p", hpaPatch(ctx)})
+		cmd.AddArguments(ctx.ExtraArgs)
+		if ctx.DryRun {
+			cmd.AddArguments([]string{"--dry-run"})
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds
+}
+
+func (stage *ScaleStage) Execute(ctx *ankh.ExecutionContext, input *string, namespace string, wildCardLabels []string) (string, error) {
+	if input == nil {
+		panic("kubectl cannot execute on nil input")
+	}
+
+	all, err := scaleTargets(ctx, *input)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read the templated objects: %v", err)
+	}
+
+	// An autoscaler scales its target back within its own bounds, so scaling
+	// the target alone does not last.
+	targets := []scaleTarget{}
+	for _, target := range all {
+		if target.ScaleTargetRef != "" && target.Change == "" {
+			for _, scaled := range all {
+				if scaled.ScaleTargetRef == "" && strings.EqualFold(target.ScaleTargetRef, scaled.Kind+"/"+scaled.Name) {
+					ctx.Logger.Warnf("%v \"%v\" is scaled by %v \"%v\", which may scale it back. Pass `--min` or `--max` to change the autoscaler instead.",
+						scaled.Kind, scaled.Name, target.Kind, target.Name)
+				}
+			}
+			continue
+		}
+		targets = append(targets, target)
+	}
+
+	if len(targets) == 0 {
+		ctx.Logger.Infof("No objects to scale")
+		return "", nil
+	}
+
+	if !ctx.NoPrompt && !ctx.DryRun {
+		fmt.Printf("\n%v\n", formatScaleTargets(targets))
+		selection, err := util.PromptForConfirmation([]string{"Abort", "Scale"},
+			fmt.Sprintf("Scale the %v objects above in namespace \"%v\" in context \"%v\"?",
+				len(targets), namespace, ctx.AnkhConfig.CurrentContextName))
+		if err != nil {
+			return "", err
+		}
+		if selection != "Scale" {
+			return "", fmt.Errorf("Aborted, nothing was scaled")
+		}
+	}
+
+	cmds := newScaleCommands(ctx, namespace, targets)
+	out := ""
+	for i := range cmds {
+		cmdOut, err := runWithRetry(ctx, &cmds[i], nil)
+		out += cmdOut
+		if err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
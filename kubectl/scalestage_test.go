@@ -0,0 +1,73 @@
+package kubectl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+)
+
+const scalableObjects = `---
+kind: Deployment
+metadata:
+  name: app
+---
+kind: StatefulSet
+metadata:
+  name: cache
+---
+kind: Service
+metadata:
+  name: app
+---
+kind: HorizontalPodAutoscaler
+metadata:
+  name: app
+spec:
+  scaleTargetRef:
+    kind: Deployment
+    name: app
+`
+
+func TestScaleStage(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl", ankhtest.Rule{Stdout: "scaled\n"})
+	ctx := ankhtest.NewContext(t)
+
+	replicas, min, max := 5, 2, 10
+	ctx.ScaleReplicas = &replicas
+	input := scalableObjects
+	if _, err := NewScaleStage().Execute(ctx, &input, "web", nil); err != nil {
+		t.Fatal(err)
+	}
+	calls := tools.Calls("kubectl")
+	if len(calls) != 1 || !strings.HasSuffix(strings.Join(calls[0].Args, " "), "--namespace web scale --replicas=5 Deployment/app StatefulSet/cache") {
+		t.Fatalf("expected the Deployment and StatefulSet to be scaled together but got %+v", calls)
+	}
+
+	ctx.ScaleReplicas = nil
+	ctx.ScaleMinReplicas, ctx.ScaleMaxReplicas = &min, &max
+	if _, err := NewScaleStage().Execute(ctx, &input, "web", nil); err != nil {
+		t.Fatal(err)
+	}
+	calls = tools.Calls("kubectl")
+	expected := `--namespace web patch HorizontalPodAutoscaler/app --type merge -p {"spec":{"minReplicas":2,"maxReplicas":10}}`
+	if len(calls) != 2 || !strings.HasSuffix(strings.Join(calls[1].Args, " "), expected) {
+		t.Logf("expected only the autoscaler to be patched but got %+v", calls)
+		t.Fail()
+	}
+}
+
+func TestFormatScaleTargets(t *testing.T) {
+	targets := []scaleTarget{
+		{Kind: "Deployment", Name: "app", Change: "replicas=5"},
+		{Kind: "HorizontalPodAutoscaler", Name: "app", Change: "minReplicas=2, maxReplicas=10"},
+	}
+	expected := "KIND                     NAME  CHANGE\n" +
+		"Deployment               app   replicas=5\n" +
+		"HorizontalPodAutoscaler  app   minReplicas=2, maxReplicas=10\n"
+	if out := formatScaleTargets(targets); out != expected {
+		t.Logf("expected:\n%v\ngot:\n%v", expected, out)
+		t.Fail()
+	}
+}
@@ -0,0 +1,384 @@
+package kubectl
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+	"github.com/appnexus/ankh/util"
+)
+
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// Fields that the API server sets on live objects, which charts never render.
+var serverManagedDiffIgnoreRules = []ankh.DiffIgnoreRule{
+	{Paths: []string{"status", "metadata.namespace", "metadata.uid", "metadata.resourceVersion", "metadata.creationTimestamp",
+		"metadata.managedFields", "metadata.selfLink", "metadata.annotations[" + lastAppliedAnnotation + "]",
+		"metadata.annotations[" + deploymentRevisionAnnotation + "]"}},
+}
+
+// The kinds of changes to a path of an object.
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+// A DiffChange is a change to a single path of an object, eg: `spec.replicas`.
+type DiffChange struct {
+	Path string
+	Type string
+	From interface{}
+	To   interface{}
+}
+
+// An ObjectDiff is every change that applying an object would make.
+type ObjectDiff struct {
+	Kind    string
+	Name    string
+	New     bool
+	Changes []DiffChange
+}
+
+// SemanticDiffStage compares the templated objects with the live objects
+// they would replace, path by path, without relying on `kubectl diff`.
+type SemanticDiffStage struct{}
+
+func NewSemanticDiffStage() plan.Stage {
+	return &SemanticDiffStage{}
+}
+
+// UsesExternalDiff returns whether `ankh diff` should run `kubectl diff` with
+// an external diff program, rather than diffing objects itself.
+func UsesExternalDiff(ctx *ankh.ExecutionContext) bool {
+	return os.Getenv("ANKH_DIFF_COMMAND") != "" || getDiffTool(ctx) != ""
+}
+
+// The rules for fields left out of the diff: those managed by the API
+// server, those Ankh changes on every apply, and any configured.
+func semanticDiffIgnoreRules(ctx *ankh.ExecutionContext) []ankh.DiffIgnoreRule {
+	rules := append([]ankh.DiffIgnoreRule{}, serverManagedDiffIgnoreRules...)
+	if configured := diffIgnoreRules(ctx); configured != nil {
+		return append(rules, configured...)
+	}
+	return append(rules, defaultDiffIgnoreRules...)
+}
+
+func (stage *SemanticDiffStage) Execute(ctx *ankh.ExecutionContext, input *string, namespace string, wildCardLabels []string) (string, error) {
+	if input == nil {
+		panic("kubectl cannot execute on nil input")
+	}
+	if strings.TrimSpace(*input) == "" {
+		return "", nil
+	}
+
+	cmd := newKubectlCommand(ctx, namespace)
+	cmd.AddArguments([]string{"get", "-f", "-", "-o", "yaml", "--ignore-not-found"})
+	out, err := runWithRetry(ctx, &cmd, input)
+	if err != nil {
+		return "", err
+	}
+
+	diffs, err := diffObjects(*input, out, semanticDiffIgnoreRules(ctx))
+	if err != nil {
+		return "", err
+	}
+	return formatObjectDiffs(diffs, ctx.DiffSummary, util.GetListingColors(ctx)), nil
+}
+
+// Decodes every object in a stream of YAML documents, including the items of
+// a `kind: List`, as `kubectl get` returns for several objects.
+func decodeObjects(input string) ([]yaml.MapSlice, error) {
+	objects := []yaml.MapSlice{}
+	decoder := yaml.NewDecoder(strings.NewReader(input))
+	for {
+		obj := yaml.MapSlice{}
+		err := decoder.Decode(&obj)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if fmt.Sprintf("%v", lookupKey(obj, "kind")) != "List" {
+			if lookupKey(obj, "kind") != nil {
+				objects = append(objects, obj)
+			}
+			continue
+		}
+		items, _ := lookupKey(obj, "items").([]interface{})
+		for _, item := range items {
+			if itemObj, ok := item.(yaml.MapSlice); ok {
+				objects = append(objects, itemObj)
+			}
+		}
+	}
+	return objects, nil
+}
+
+func lookupKey(obj yaml.MapSlice, key string) interface{} {
+	value, _ := lookup(obj, key)
+	return value
+}
+
+func lookup(obj yaml.MapSlice, key string) (interface{}, bool) {
+	for _, item := range obj {
+		if fmt.Sprintf("%v", item.Key) == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+func diffObjectKey(obj yaml.MapSlice) (string, string) {
+	metadata, _ := lookupKey(obj, "metadata").(yaml.MapSlice)
+	return fmt.Sprintf("%v", lookupKey(obj, "kind")), fmt.Sprintf("%v", lookupKey(metadata, "name"))
+}
+
+// The configuration that was last applied to a live object, which tells
+// fields removed from the chart apart from fields set by the cluster.
+func lastApplied(live yaml.MapSlice) yaml.MapSlice {
+	metadata, _ := lookupKey(live, "metadata").(yaml.MapSlice)
+	annotations, _ := lookupKey(metadata, "annotations").(yaml.MapSlice)
+	body, ok := lookupKey(annotations, lastAppliedAnnotation).(string)
+	if !ok {
+		return nil
+	}
+	obj := yaml.MapSlice{}
+	if err := yaml.Unmarshal([]byte(body), &obj); err != nil {
+		return nil
+	}
+	return obj
+}
+
+// Compares the templated objects with the live objects, both with ignored
+// fields left out, in the order they were templated.
+func diffObjects(templated string, live string, rules []ankh.DiffIgnoreRule) ([]ObjectDiff, error) {
+	desiredObjects, err := decodeObjects(templated)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the templated objects: %v", err)
+	}
+	liveObjects, err := decodeObjects(live)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the live objects from kubectl: %v", err)
+	}
+
+	liveByKey := map[string]yaml.MapSlice{}
+	for _, obj := range liveObjects {
+		kind, name := diffObjectKey(obj)
+		liveByKey[kind+"/"+name] = obj
+	}
+
+	diffs := []ObjectDiff{}
+	for _, desired := range desiredObjects {
+		kind, name := diffObjectKey(desired)
+		diff := ObjectDiff{Kind: kind, Name: name}
+		liveObj, ok := liveByKey[kind+"/"+name]
+		if !ok {
+			diff.New = true
+			diffs = append(diffs, diff)
+			continue
+		}
+
+		base := lastApplied(liveObj)
+		for _, obj := range []*yaml.MapSlice{&desired, &liveObj, &base} {
+			if *obj == nil {
+				continue
+			}
+			if *obj, err = filterObject(*obj, rules); err != nil {
+				return nil, err
+			}
+		}
+		diffValues("", desired, liveObj, base, &diff.Changes)
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
+
+// Joins a path and a key, putting keys with dots in brackets, so that paths
+// can be used as they are in `diff.ignore`.
+func joinDiffPath(path string, key string) string {
+	if strings.ContainsAny(key, ".[]") {
+		return path + "[" + key + "]"
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// Returns the value of `name` of each item in a list, if every item has one,
+// so that items can be matched by name, rather than by position.
+func itemNames(list []interface{}) ([]string, bool) {
+	names := []string{}
+	for _, item := range list {
+		obj, ok := item.(yaml.MapSlice)
+		if !ok {
+			return nil, false
+		}
+		name, ok := lookup(obj, "name")
+		if !ok {
+			return nil, false
+		}
+		names = append(names, fmt.Sprintf("%v", name))
+	}
+	return names, true
+}
+
+func itemsByName(list []interface{}) map[string]interface{} {
+	items := map[string]interface{}{}
+	names, _ := itemNames(list)
+	for i, name := range names {
+		items[name] = list[i]
+	}
+	return items
+}
+
+// Converts yaml maps to go maps, whose keys are marshaled in order, since
+// the API server may order keys differently than a chart does.
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		normalized := map[string]interface{}{}
+		for _, item := range v {
+			normalized[fmt.Sprintf("%v", item.Key)] = normalizeValue(item.Value)
+		}
+		return normalized
+	case []interface{}:
+		normalized := []interface{}{}
+		for _, item := range v {
+			normalized = append(normalized, normalizeValue(item))
+		}
+		return normalized
+	default:
+		return v
+	}
+}
+
+func sameValue(a interface{}, b interface{}) bool {
+	aBody, _ := yaml.Marshal(normalizeValue(a))
+	bBody, _ := yaml.Marshal(normalizeValue(b))
+	return string(aBody) == string(bBody)
+}
+
+// Records the changes that applying desired over live would make. A field
+// that is only in the live object is removed only if it was last applied,
+// since apply leaves fields that were set by the cluster, eg: defaults.
+func diffValues(path string, desired interface{}, live interface{}, base interface{}, changes *[]DiffChange) {
+	switch d := desired.(type) {
+	case yaml.MapSlice:
+		l, ok := live.(yaml.MapSlice)
+		if !ok {
+			break
+		}
+		b, _ := base.(yaml.MapSlice)
+		for _, item := range d {
+			key := fmt.Sprintf("%v", item.Key)
+			liveValue, inLive := lookup(l, key)
+			if !inLive {
+				*changes = append(*changes, DiffChange{Path: joinDiffPath(path, key), Type: DiffAdded, To: item.Value})
+				continue
+			}
+			diffValues(joinDiffPath(path, key), item.Value, liveValue, lookupKey(b, key), changes)
+		}
+		for _, item := range l {
+			key := fmt.Sprintf("%v", item.Key)
+			if _, inDesired := lookup(d, key); inDesired {
+				continue
+			}
+			if _, inBase := lookup(b, key); inBase {
+				*changes = append(*changes, DiffChange{Path: joinDiffPath(path, key), Type: DiffRemoved, From: item.Value})
+			}
+		}
+		return
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok {
+			break
+		}
+		desiredNames, dNamed := itemNames(d)
+		liveNames, lNamed := itemNames(l)
+		if !dNamed || !lNamed {
+			break
+		}
+		liveItems := itemsByName(l)
+		baseList, _ := base.([]interface{})
+		baseItems := itemsByName(baseList)
+		for i, name := range desiredNames {
+			itemPath := path + "[name=" + name + "]"
+			liveItem, inLive := liveItems[name]
+			if !inLive {
+				*changes = append(*changes, DiffChange{Path: itemPath, Type: DiffAdded, To: d[i]})
+				continue
+			}
+			diffValues(itemPath, d[i], liveItem, baseItems[name], changes)
+		}
+		desiredItems := itemsByName(d)
+		for i, name := range liveNames {
+			if _, inDesired := desiredItems[name]; inDesired {
+				continue
+			}
+			if _, inBase := baseItems[name]; inBase {
+				*changes = append(*changes, DiffChange{Path: path + "[name=" + name + "]", Type: DiffRemoved, From: l[i]})
+			}
+		}
+		return
+	}
+
+	if !sameValue(desired, live) {
+		*changes = append(*changes, DiffChange{Path: path, Type: DiffChanged, From: live, To: desired})
+	}
+}
+
+// Formats a value on one line if it is a scalar, and otherwise as indented
+// YAML on the lines that follow.
+func formatDiffValue(value interface{}) string {
+	body, _ := yaml.Marshal(value)
+	formatted := strings.TrimRight(string(body), "\n")
+	switch value.(type) {
+	case yaml.MapSlice, []interface{}:
+	default:
+		return formatted
+	}
+	return "\n      " + strings.Replace(formatted, "\n", "\n      ", -1)
+}
+
+func formatObjectDiffs(diffs []ObjectDiff, summary bool, colors util.ListingColors) string {
+	out := strings.Builder{}
+	newObjects, changed, unchanged := 0, 0, 0
+	for _, diff := range diffs {
+		header := fmt.Sprintf("%v \"%v\"", diff.Kind, diff.Name)
+		switch {
+		case diff.New:
+			newObjects++
+			fmt.Fprintf(&out, "%v%v (new)%v\n", colors.Added, header, colors.Reset)
+			continue
+		case len(diff.Changes) == 0:
+			unchanged++
+			continue
+		}
+		changed++
+		fmt.Fprintf(&out, "%v%v (changed)%v\n", colors.Header, header, colors.Reset)
+		if summary {
+			continue
+		}
+		for _, change := range diff.Changes {
+			switch change.Type {
+			case DiffAdded:
+				fmt.Fprintf(&out, "  %v+ %v: %v%v\n", colors.Added, change.Path, formatDiffValue(change.To), colors.Reset)
+			case DiffRemoved:
+				fmt.Fprintf(&out, "  %v- %v: %v%v\n", colors.Removed, change.Path, formatDiffValue(change.From), colors.Reset)
+			default:
+				fmt.Fprintf(&out, "  %v~ %v: %v -> %v%v\n", colors.Changed, change.Path,
+					formatDiffValue(change.From), formatDiffValue(change.To), colors.Reset)
+			}
+		}
+	}
+	fmt.Fprintf(&out, "%v new, %v changed, %v unchanged", newObjects, changed, unchanged)
+	return out.String()
+}
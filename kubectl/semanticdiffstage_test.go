@@ -0,0 +1,150 @@
+package kubectl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/util"
+)
+
+const templatedObjects = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  labels:
+    team: web
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:2
+        env:
+        - name: LEVEL
+          value: info
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config
+data:
+  level: info
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+spec:
+  ports:
+  - port: 80
+    protocol: TCP
+`
+
+const liveObjects = `apiVersion: v1
+kind: List
+items:
+- apiVersion: apps/v1
+  kind: Deployment
+  metadata:
+    name: app
+    namespace: web
+    uid: 1234
+    resourceVersion: "99"
+    generation: 4
+    annotations:
+      deployment.kubernetes.io/revision: "4"
+      kubectl.kubernetes.io/last-applied-configuration: |
+        {"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"app","annotations":{"owner":"ops"}},"spec":{"replicas":3,"template":{"spec":{"containers":[{"name":"app","image":"app:1","env":[{"name":"LEVEL","value":"info"},{"name":"DEBUG","value":"1"}]}]}}}}
+      owner: ops
+  spec:
+    replicas: 3
+    progressDeadlineSeconds: 600
+    template:
+      spec:
+        containers:
+        - name: app
+          image: app:1
+          imagePullPolicy: IfNotPresent
+          env:
+          - name: LEVEL
+            value: info
+          - name: DEBUG
+            value: "1"
+  status:
+    readyReplicas: 3
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: app
+    namespace: web
+  spec:
+    ports:
+    - protocol: TCP
+      port: 80
+`
+
+func TestDiffObjects(t *testing.T) {
+	ctx := ankhtest.NewContext(t)
+	diffs, err := diffObjects(templatedObjects, liveObjects, semanticDiffIgnoreRules(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 3 || diffs[1].Name != "app-config" || !diffs[1].New || len(diffs[2].Changes) != 0 {
+		t.Fatalf("expected a new ConfigMap and an unchanged Service but got %+v", diffs)
+	}
+
+	changes := map[string]DiffChange{}
+	for _, change := range diffs[0].Changes {
+		changes[change.Path] = change
+	}
+	expected := map[string]string{
+		"metadata.labels":                                         DiffAdded,
+		"metadata.annotations":                                    DiffRemoved,
+		"spec.template.spec.containers[name=app].image":           DiffChanged,
+		"spec.template.spec.containers[name=app].env[name=DEBUG]": DiffRemoved,
+	}
+	for path, changeType := range expected {
+		if changes[path].Type != changeType {
+			t.Logf("expected %v to be %v but got %+v", path, changeType, changes)
+			t.Fail()
+		}
+	}
+	// Fields set by the cluster, and not in the chart, are left alone.
+	if len(changes) != len(expected) {
+		t.Logf("expected only %v changes but got %+v", len(expected), changes)
+		t.Fail()
+	}
+
+	out := formatObjectDiffs(diffs, false, util.ListingColors{})
+	for _, line := range []string{"Deployment \"app\" (changed)", "  ~ spec.template.spec.containers[name=app].image: app:1 -> app:2",
+		"ConfigMap \"app-config\" (new)", "1 new, 1 changed, 1 unchanged"} {
+		if !strings.Contains(out, line) {
+			t.Logf("expected %q in the diff but got %v", line, out)
+			t.Fail()
+		}
+	}
+	if out := formatObjectDiffs(diffs, true, util.ListingColors{}); strings.Contains(out, "image") {
+		t.Logf("expected only the objects that would change with --summary but got %v", out)
+		t.Fail()
+	}
+}
+
+func TestSemanticDiffStage(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl", ankhtest.Rule{Args: "* get -f - -o yaml --ignore-not-found", Stdout: liveObjects})
+	ctx := ankhtest.NewContext(t)
+
+	input := templatedObjects
+	out, err := NewSemanticDiffStage().Execute(ctx, &input, "web", nil)
+	if err != nil || !strings.Contains(out, "1 new, 1 changed, 1 unchanged") {
+		t.Logf("expected a diff against the live objects but got %v and %v", out, err)
+		t.Fail()
+	}
+	if calls := tools.Calls("kubectl"); len(calls) != 1 || calls[0].Stdin != templatedObjects {
+		t.Logf("expected the templated objects to be fetched from kubectl but got %+v", calls)
+		t.Fail()
+	}
+}
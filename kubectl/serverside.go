@@ -0,0 +1,122 @@
+package kubectl
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/appnexus/ankh/context"
+)
+
+// The field manager that server-side applies are made as, unless configured.
+const defaultFieldManager = "ankh"
+
+var (
+	applyConflictRegexp = regexp.MustCompile(`conflicts? with "([^"]+)"(?: using [^:]+)?:(.*)`)
+	conflictFieldRegexp = regexp.MustCompile(`^-\s*(\.\S+)`)
+)
+
+// Server-side apply is used when asked for on the command line, or in ankh config.
+func serverSideApply(ctx *ankh.ExecutionContext) bool {
+	return ctx.ServerSide || ctx.AnkhConfig.Kubectl.ServerSide
+}
+
+// Returns the field manager to apply as, if any. Server-side applies always
+// have one, while client-side applies keep kubectl's own unless configured.
+func fieldManager(ctx *ankh.ExecutionContext) string {
+	if ctx.FieldManager != "" {
+		return ctx.FieldManager
+	}
+	if ctx.AnkhConfig.Kubectl.FieldManager != "" {
+		return ctx.AnkhConfig.Kubectl.FieldManager
+	}
+	if serverSideApply(ctx) {
+		return defaultFieldManager
+	}
+	return ""
+}
+
+// Returns the `kubectl apply` args for server-side apply and the field
+// manager, if configured.
+func applyArgs(ctx *ankh.ExecutionContext) []string {
+	args := []string{}
+	if serverSideApply(ctx) {
+		args = append(args, "--server-side")
+		if ctx.ForceConflicts || ctx.AnkhConfig.Kubectl.ForceConflicts {
+			args = append(args, "--force-conflicts")
+		}
+	}
+	if manager := fieldManager(ctx); manager != "" {
+		args = append(args, "--field-manager="+manager)
+	}
+	return args
+}
+
+// Client-side dry runs can't be combined with server-side apply, so the API
+// server does the dry run instead.
+func dryRunArg(ctx *ankh.ExecutionContext) string {
+	if serverSideApply(ctx) {
+		return "--dry-run=server"
+	}
+	return "--dry-run"
+}
+
+type applyConflict struct {
+	Manager string
+	Fields  []string
+}
+
+// Parses the conflicts reported by a server-side apply, eg:
+// `Apply failed with 1 conflict: conflict with "kubectl-client-side-apply" using apps/v1: .spec.replicas`,
+// where several fields are listed on the lines that follow, prefixed with `- `.
+func parseApplyConflicts(stderr string) []applyConflict {
+	conflicts := []applyConflict{}
+	var current *applyConflict
+	for _, line := range strings.Split(stderr, "\n") {
+		line = strings.TrimSpace(line)
+		if match := applyConflictRegexp.FindStringSubmatch(line); match != nil {
+			conflicts = append(conflicts, applyConflict{Manager: match[1]})
+			current = &conflicts[len(conflicts)-1]
+			if field := strings.TrimSpace(match[2]); field != "" {
+				current.Fields = append(current.Fields, field)
+			}
+			continue
+		}
+		if match := conflictFieldRegexp.FindStringSubmatch(line); match != nil && current != nil {
+			current.Fields = append(current.Fields, match[1])
+			continue
+		}
+		current = nil
+	}
+	return conflicts
+}
+
+// Explains a failed server-side apply, if it failed because other field
+// managers own some of the fields, and returns err otherwise.
+func explainApplyConflicts(ctx *ankh.ExecutionContext, stderr string, err error) error {
+	conflicts := parseApplyConflicts(stderr)
+	if len(conflicts) == 0 {
+		return err
+	}
+
+	managers := map[string][]string{}
+	for _, conflict := range conflicts {
+		managers[conflict.Manager] = append(managers[conflict.Manager], conflict.Fields...)
+	}
+	names := []string{}
+	for name := range managers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "Server-side apply as field manager \"%v\" conflicts with fields owned by other managers:\n", fieldManager(ctx))
+	for _, name := range names {
+		fmt.Fprintf(&buf, "  %v: %v\n", name, strings.Join(managers[name], ", "))
+	}
+	buf.WriteString("Apply again with --force-conflicts to take ownership of these fields, " +
+		"or remove them from the chart to leave them to their current managers, eg: replicas managed by an autoscaler. " +
+		"Fields owned by \"kubectl-client-side-apply\" were last applied without --server-side, and are safe to take over.")
+	return fmt.Errorf("%v", buf.String())
+}
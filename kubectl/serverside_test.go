@@ -0,0 +1,81 @@
+package kubectl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+)
+
+const applyConflictStderr = `error: Apply failed with 2 conflicts: conflicts with "hpa-controller" using apps/v1:
+- .spec.replicas
+- .spec.template.spec.containers[name="app"].resources
+Please review the fields above--they currently have other managers. Here
+are the ways you can resolve this warning:
+`
+
+func TestApplyArgs(t *testing.T) {
+	ctx := ankhtest.NewContext(t)
+	if args := applyArgs(ctx); len(args) != 0 || dryRunArg(ctx) != "--dry-run" {
+		t.Logf("expected a client-side apply to be unchanged but got %v and %v", args, dryRunArg(ctx))
+		t.Fail()
+	}
+
+	ctx.AnkhConfig.Kubectl.ServerSide = true
+	if args := applyArgs(ctx); !reflect.DeepEqual(args, []string{"--server-side", "--field-manager=ankh"}) {
+		t.Logf("expected a server-side apply as ankh but got %v", args)
+		t.Fail()
+	}
+	if dryRunArg(ctx) != "--dry-run=server" {
+		t.Logf("expected a server-side dry run but got %v", dryRunArg(ctx))
+		t.Fail()
+	}
+
+	ctx.AnkhConfig.Kubectl.FieldManager = "platform"
+	ctx.FieldManager = "ci"
+	ctx.ForceConflicts = true
+	if args := applyArgs(ctx); !reflect.DeepEqual(args, []string{"--server-side", "--force-conflicts", "--field-manager=ci"}) {
+		t.Logf("expected the command line to take precedence but got %v", args)
+		t.Fail()
+	}
+}
+
+func TestParseApplyConflicts(t *testing.T) {
+	conflicts := parseApplyConflicts(`error: Apply failed with 1 conflict: conflict with "kubectl-client-side-apply" using apps/v1: .spec.replicas`)
+	expected := []applyConflict{{Manager: "kubectl-client-side-apply", Fields: []string{".spec.replicas"}}}
+	if !reflect.DeepEqual(conflicts, expected) {
+		t.Logf("expected %+v but got %+v", expected, conflicts)
+		t.Fail()
+	}
+
+	conflicts = parseApplyConflicts(applyConflictStderr)
+	expected = []applyConflict{{Manager: "hpa-controller", Fields: []string{".spec.replicas", `.spec.template.spec.containers[name="app"].resources`}}}
+	if !reflect.DeepEqual(conflicts, expected) {
+		t.Logf("expected %+v but got %+v", expected, conflicts)
+		t.Fail()
+	}
+}
+
+func TestApplyConflictError(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("kubectl", ankhtest.Rule{Args: "* apply *", Stderr: applyConflictStderr, ExitCode: 1})
+	ctx := ankhtest.NewContext(t)
+	ctx.ServerSide = true
+
+	input := "kind: Deployment\nmetadata:\n  name: app\n"
+	_, err := NewApplyStage().Execute(ctx, &input, "web", nil)
+	if err == nil {
+		t.Fatal("expected the conflicting apply to fail")
+	}
+	for _, expected := range []string{`as field manager "ankh"`, "hpa-controller: .spec.replicas, ", "--force-conflicts"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Logf("expected the error to contain %q but got %v", expected, err)
+			t.Fail()
+		}
+	}
+	if args := strings.Join(tools.Calls("kubectl")[0].Args, " "); !strings.Contains(args, "apply -f - --server-side --field-manager=ankh") {
+		t.Logf("expected a server-side apply but got %v", args)
+		t.Fail()
+	}
+}
@@ -0,0 +1,182 @@
+package ledger
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	FileName = "releases.jsonl"
+
+	ActionApply    = "apply"
+	ActionDeploy   = "deploy"
+	ActionRollback = "rollback"
+)
+
+// An Entry records a chart that was applied, deployed or rolled back in a
+// context and namespace.
+type Entry struct {
+	ID          string    `json:"id" yaml:"id"`
+	Time        time.Time `json:"time" yaml:"time"`
+	User        string    `json:"user" yaml:"user"`
+	Action      string    `json:"action" yaml:"action"`
+	Environment string    `json:"environment,omitempty" yaml:"environment,omitempty"`
+	Context     string    `json:"context" yaml:"context"`
+	Cluster     string    `json:"cluster,omitempty" yaml:"cluster,omitempty"`
+	Namespace   string    `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Chart       string    `json:"chart" yaml:"chart"`
+	Alias       string    `json:"alias,omitempty" yaml:"alias,omitempty"`
+	Version     string    `json:"version,omitempty" yaml:"version,omitempty"`
+	Path        string    `json:"path,omitempty" yaml:"path,omitempty"`
+	Tag         string    `json:"tag,omitempty" yaml:"tag,omitempty"`
+	Revision    int       `json:"revision,omitempty" yaml:"revision,omitempty"`
+	Release     string    `json:"release,omitempty" yaml:"release,omitempty"`
+	// The run that made the release, as used by `ankh replay`
+	RunID string `json:"runId" yaml:"runId"`
+}
+
+// InstanceName is the chart's alias, if it has one, or else its name.
+func (entry *Entry) InstanceName() string {
+	if entry.Alias != "" {
+		return entry.Alias
+	}
+	return entry.Chart
+}
+
+// NewID returns a random ID for an entry.
+func NewID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Append adds entries to the ledger under the base data dir. Entries are
+// only ever appended, one JSON object per line.
+func Append(baseDataDir string, entries []Entry) error {
+	if err := os.MkdirAll(baseDataDir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(baseDataDir, FileName), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Start on a line of its own after an interrupted write.
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			if _, err := f.Write([]byte{'\n'}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load reads every entry in the ledger under the base data dir, oldest first.
+// Lines that can't be parsed, eg: from an interrupted write, are skipped.
+func Load(baseDataDir string) ([]Entry, error) {
+	f, err := os.Open(filepath.Join(baseDataDir, FileName))
+	if os.IsNotExist(err) {
+		return []Entry{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.ID == "" {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// ForChart returns the entries for a chart, by name or alias.
+func ForChart(entries []Entry, chart string) []Entry {
+	matching := []Entry{}
+	for _, entry := range entries {
+		if entry.Chart == chart || entry.Alias == chart {
+			matching = append(matching, entry)
+		}
+	}
+	return matching
+}
+
+// Find returns the entry with the given ID, and the apply or deploy of the
+// same chart, in the same context and namespace, that came before it. The
+// earlier entry is nil if there is none.
+func Find(entries []Entry, id string) (*Entry, *Entry, error) {
+	for i := range entries {
+		if entries[i].ID != id {
+			continue
+		}
+		entry := &entries[i]
+		for j := i - 1; j >= 0; j-- {
+			earlier := &entries[j]
+			if earlier.Context == entry.Context && earlier.Namespace == entry.Namespace &&
+				earlier.InstanceName() == entry.InstanceName() && earlier.Action != ActionRollback {
+				return entry, earlier, nil
+			}
+		}
+		return entry, nil, nil
+	}
+	return nil, nil, fmt.Errorf("No release \"%v\" found in the ledger. Run `ankh releases` to list them", id)
+}
+
+func formatVersion(entry Entry) string {
+	if entry.Path != "" {
+		return entry.Path + " (local)"
+	} else if entry.Action == ActionRollback {
+		if entry.Revision > 0 {
+			return fmt.Sprintf("revision %v", entry.Revision)
+		}
+		return "previous revision"
+	}
+	return entry.Version
+}
+
+// Format returns a table of entries, newest first.
+func Format(entries []Entry) string {
+	buf := bytes.NewBufferString("")
+	w := tabwriter.NewWriter(buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tTIME\tUSER\tACTION\tCONTEXT\tNAMESPACE\tCHART\tVERSION\tTAG\n")
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		context := entry.Context
+		if entry.Cluster != "" {
+			context += "/" + entry.Cluster
+		}
+		tag := entry.Tag
+		if tag == "" {
+			tag = "-"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", entry.ID, entry.Time.Local().Format("2006-01-02 15:04:05"),
+			entry.User, entry.Action, context, entry.Namespace, entry.InstanceName(), formatVersion(entry), tag)
+	}
+	w.Flush()
+	return buf.String()
+}
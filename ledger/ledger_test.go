@@ -0,0 +1,90 @@
+package ledger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if entries, err := Load(dir); err != nil || len(entries) != 0 {
+		t.Fatalf("expected an empty ledger but got %+v and %v", entries, err)
+	}
+
+	if err := Append(dir, []Entry{{ID: "a", Chart: "api"}, {ID: "b", Chart: "worker"}}); err != nil {
+		t.Fatal(err)
+	}
+	// An interrupted write leaves a partial line behind, which is skipped.
+	f, _ := os.OpenFile(filepath.Join(dir, FileName), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"id": "trunc`)
+	f.Close()
+	if err := Append(dir, []Entry{{ID: "c", Chart: "api"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+	if strings.Join(ids, ",") != "a,b,c" {
+		t.Logf("expected the entries in order, without the partial line, but got %v", ids)
+		t.Fail()
+	}
+	if matching := ForChart(entries, "worker"); len(matching) != 1 || matching[0].ID != "b" {
+		t.Logf("expected only the worker entry but got %+v", matching)
+		t.Fail()
+	}
+}
+
+func TestFind(t *testing.T) {
+	entries := []Entry{
+		{ID: "1", Action: ActionApply, Context: "prod", Namespace: "web", Chart: "api", Version: "1.0.0"},
+		{ID: "2", Action: ActionApply, Context: "staging", Namespace: "web", Chart: "api", Version: "1.1.0"},
+		{ID: "3", Action: ActionApply, Context: "prod", Namespace: "web", Chart: "api", Alias: "api-canary", Version: "1.1.0"},
+		{ID: "4", Action: ActionRollback, Context: "prod", Namespace: "web", Chart: "api"},
+		{ID: "5", Action: ActionDeploy, Context: "prod", Namespace: "web", Chart: "api", Version: "1.2.0"},
+	}
+
+	entry, earlier, err := Find(entries, "5")
+	if err != nil || entry.ID != "5" || earlier == nil || earlier.ID != "1" {
+		t.Logf("expected release 1 to come before release 5 but got %+v and %v", earlier, err)
+		t.Fail()
+	}
+	if _, earlier, err := Find(entries, "3"); err != nil || earlier != nil {
+		t.Logf("expected nothing to come before the first release of an alias but got %+v and %v", earlier, err)
+		t.Fail()
+	}
+	if _, _, err := Find(entries, "6"); err == nil {
+		t.Logf("expected an unknown release to be an error")
+		t.Fail()
+	}
+}
+
+func TestFormat(t *testing.T) {
+	at := time.Date(2024, 1, 5, 10, 0, 0, 0, time.Local)
+	out := Format([]Entry{
+		{ID: "1", Time: at, User: "ops", Action: ActionApply, Context: "prod", Namespace: "web", Chart: "api", Version: "1.0.0", Tag: "abc"},
+		{ID: "2", Time: at, User: "ops", Action: ActionRollback, Context: "prod", Cluster: "east", Namespace: "web", Chart: "api", Revision: 3},
+	})
+
+	expected := "ID  TIME                 USER  ACTION    CONTEXT    NAMESPACE  CHART  VERSION     TAG\n" +
+		"2   2024-01-05 10:00:00  ops   rollback  prod/east  web        api    revision 3  -\n" +
+		"1   2024-01-05 10:00:00  ops   apply     prod       web        api    1.0.0       abc\n"
+	if out != expected {
+		t.Logf("expected:\n%v\ngot:\n%v", expected, out)
+		t.Fail()
+	}
+}
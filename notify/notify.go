@@ -0,0 +1,197 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	ankh "github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/util"
+)
+
+const (
+	defaultFormat         = "%USER% is releasing %CHART% (tag %VERSION%) to %TARGET%"
+	defaultRollbackFormat = "%USER% is rolling back %CHART_NAME% in %TARGET%"
+	defaultPayload        = `{"text": "%MESSAGE%"}`
+	defaultSubject        = "Ankh release to %TARGET%"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Replaced in tests.
+var sendMail = smtp.SendMail
+
+// Notify sends a release message for the charts of `ankhFile` to each of the
+// notification sinks in ankh config. Sinks that fail are logged, and do not
+// stop the others.
+func Notify(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) {
+	names := []string{}
+	for name := range ctx.AnkhConfig.Notifications {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := notify(ctx, ctx.AnkhConfig.Notifications[name], ankhFile.Charts); err != nil {
+			ctx.Logger.Errorf("Notification \"%v\" failed with error: %v", name, err)
+		}
+	}
+}
+
+func notify(ctx *ankh.ExecutionContext, sink ankh.NotificationConfig, charts []ankh.Chart) error {
+	envOrContext := util.GetEnvironmentOrContext(ctx.Environment, ctx.Context)
+	variables := []map[string]string{}
+	messages := []string{}
+	for i := range charts {
+		chartVariables := util.NotificationVariables(&charts[i], envOrContext, ctx.EffectiveRelease(), ctx.EffectiveDeployer())
+		chartVariables["%MESSAGE%"] = util.ReplaceNotificationVariables(messageFormat(ctx, sink), chartVariables)
+		variables = append(variables, chartVariables)
+		messages = append(messages, chartVariables["%MESSAGE%"])
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	switch sink.Type {
+	case "webhook":
+		if sink.URL == "" {
+			return fmt.Errorf("no `url` set for webhook")
+		}
+		for _, chartVariables := range variables {
+			body := webhookPayload(sink.Payload, chartVariables)
+			if ctx.DryRun {
+				ctx.Logger.Infof("--dry-run set so not posting '%v' to %v", body, sink.URL)
+				continue
+			}
+			if err := post(sink.URL, sink.Headers, body); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "teams":
+		if sink.URL == "" {
+			return fmt.Errorf("no `url` set for teams")
+		}
+		// Teams renders the text as markdown, which needs blank lines between paragraphs.
+		text := strings.Join(messages, "\n\n")
+		if ctx.DryRun {
+			ctx.Logger.Infof("--dry-run set so not sending message '%v' to teams", text)
+			return nil
+		}
+		body, err := teamsPayload(text)
+		if err != nil {
+			return err
+		}
+		return post(sink.URL, nil, body)
+	case "email":
+		if sink.SMTPServer == "" || sink.From == "" || len(sink.To) == 0 {
+			return fmt.Errorf("`smtpServer`, `from` and `to` must be set for email")
+		}
+		subject := sink.Subject
+		if subject == "" {
+			subject = defaultSubject
+		}
+		// Chart variables in the subject are those of the first chart.
+		subject = util.ReplaceNotificationVariables(subject, variables[0])
+		text := strings.Join(messages, "\n")
+		if ctx.DryRun {
+			ctx.Logger.Infof("--dry-run set so not emailing '%v' to %v", text, strings.Join(sink.To, ", "))
+			return nil
+		}
+		return sendEmail(sink, subject, text)
+	default:
+		return fmt.Errorf("unknown notification type \"%v\", expected one of `webhook`, `teams` or `email`", sink.Type)
+	}
+}
+
+func messageFormat(ctx *ankh.ExecutionContext, sink ankh.NotificationConfig) string {
+	if ctx.Mode == ankh.Rollback {
+		if sink.RollbackFormat != "" {
+			return sink.RollbackFormat
+		}
+		return defaultRollbackFormat
+	}
+	if sink.Format != "" {
+		return sink.Format
+	}
+	return defaultFormat
+}
+
+// Substitutes the variables of `payload`, escaped for use inside JSON strings.
+func webhookPayload(payload string, variables map[string]string) string {
+	if payload == "" {
+		payload = defaultPayload
+	}
+	escaped := map[string]string{}
+	for name, value := range variables {
+		escaped[name] = escapeJSONString(value)
+	}
+	return strings.Replace(util.ReplaceNotificationVariables(payload, escaped), "%MESSAGE%", escaped["%MESSAGE%"], -1)
+}
+
+func escapeJSONString(value string) string {
+	quoted, _ := json.Marshal(value)
+	return string(quoted[1 : len(quoted)-1])
+}
+
+// A legacy actionable message card, which Teams incoming webhooks accept.
+func teamsPayload(text string) (string, error) {
+	body, err := json.Marshal(map[string]string{
+		"@type":    "MessageCard",
+		"@context": "http://schema.org/extensions",
+		"summary":  "Ankh release",
+		"text":     text,
+	})
+	return string(body), err
+}
+
+func post(url string, headers map[string]string, body string) error {
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, os.ExpandEnv(value))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%v returned %v: %v", url, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+func sendEmail(sink ankh.NotificationConfig, subject string, text string) error {
+	var auth smtp.Auth
+	if sink.Username != "" {
+		host, _, err := net.SplitHostPort(sink.SMTPServer)
+		if err != nil {
+			return fmt.Errorf("invalid `smtpServer` \"%v\", expected host:port: %v", sink.SMTPServer, err)
+		}
+		auth = smtp.PlainAuth("", sink.Username, os.Getenv(sink.PasswordEnv), host)
+	}
+
+	message := bytes.Buffer{}
+	fmt.Fprintf(&message, "From: %v\r\n", sink.From)
+	fmt.Fprintf(&message, "To: %v\r\n", strings.Join(sink.To, ", "))
+	fmt.Fprintf(&message, "Subject: %v\r\n", subject)
+	fmt.Fprintf(&message, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	message.WriteString(strings.Replace(text, "\n", "\r\n", -1))
+	message.WriteString("\r\n")
+	return sendMail(sink.SMTPServer, auth, sink.From, sink.To, message.Bytes())
+}
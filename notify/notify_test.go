@@ -0,0 +1,105 @@
+package notify
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	ankh "github.com/appnexus/ankh/context"
+)
+
+func TestNotify(t *testing.T) {
+	bodies := map[string][]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies[r.URL.Path] = append(bodies[r.URL.Path], string(body))
+		if r.URL.Path == "/hook" && r.Header.Get("Authorization") != "Bearer secret" {
+			t.Logf("expected the configured header with its variable expanded but got %q", r.Header.Get("Authorization"))
+			t.Fail()
+		}
+	}))
+	defer server.Close()
+	defer os.Unsetenv("HOOK_TOKEN")
+	os.Setenv("HOOK_TOKEN", "secret")
+
+	emails := []string{}
+	sendMail = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		emails = append(emails, string(msg))
+		return nil
+	}
+	defer func() { sendMail = smtp.SendMail }()
+
+	tag := "1.0.0"
+	ctx := ankhtest.NewContext(t)
+	ctx.Mode = ankh.Apply
+	ctx.Context = "production"
+	ctx.Deployer = "jdoe"
+	ctx.AnkhConfig.Notifications = map[string]ankh.NotificationConfig{
+		"hook": {
+			Type:    "webhook",
+			URL:     server.URL + "/hook",
+			Headers: map[string]string{"Authorization": "Bearer ${HOOK_TOKEN}"},
+			Format:  `"%CHART_NAME%" to %TARGET%`,
+			Payload: `{"chart": "%CHART_NAME%", "message": "%MESSAGE%"}`,
+		},
+		"teams": {Type: "teams", URL: server.URL + "/teams"},
+		"mail":  {Type: "email", SMTPServer: "localhost:25", From: "ankh@example.com", To: []string{"ops@example.com"}},
+	}
+	Notify(ctx, &ankh.AnkhFile{Charts: []ankh.Chart{{Name: "api", Version: "1.2.3", Tag: &tag}, {Name: "worker", Version: "2.0.0", Tag: &tag}}})
+
+	hooks := bodies["/hook"]
+	if len(hooks) != 2 {
+		t.Fatalf("expected a webhook per chart but got %v", hooks)
+	}
+	payload := map[string]string{}
+	if err := json.Unmarshal([]byte(hooks[0]), &payload); err != nil {
+		t.Fatalf("expected a valid JSON payload but got %v: %v", hooks[0], err)
+	}
+	if payload["chart"] != "api" || payload["message"] != `"api" to production` {
+		t.Logf("got unexpected payload %+v", payload)
+		t.Fail()
+	}
+
+	if len(bodies["/teams"]) != 1 || !strings.Contains(bodies["/teams"][0], `jdoe is releasing api@1.2.3 (tag 1.0.0) to production\n\njdoe is releasing worker@2.0.0`) {
+		t.Logf("expected one Teams message for both charts but got %v", bodies["/teams"])
+		t.Fail()
+	}
+
+	if len(emails) != 1 || !strings.Contains(emails[0], "Subject: Ankh release to production\r\n") || !strings.Contains(emails[0], "worker@2.0.0") {
+		t.Logf("expected one email for both charts but got %v", emails)
+		t.Fail()
+	}
+}
+
+func TestNotifyErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	ctx := ankhtest.NewContext(t)
+	ctx.Mode = ankh.Rollback
+	charts := []ankh.Chart{{Name: "api", Version: "1.2.3"}}
+
+	if err := notify(ctx, ankh.NotificationConfig{Type: "webhook", URL: server.URL}, charts); err == nil || !strings.Contains(err.Error(), "invalid payload") {
+		t.Logf("expected the response to be reported but got %v", err)
+		t.Fail()
+	}
+	if err := notify(ctx, ankh.NotificationConfig{Type: "pager"}, charts); err == nil {
+		t.Logf("expected an unknown type to be an error")
+		t.Fail()
+	}
+
+	// Dry runs send nothing.
+	ctx.DryRun = true
+	if err := notify(ctx, ankh.NotificationConfig{Type: "webhook", URL: server.URL}, charts); err != nil {
+		t.Logf("expected nothing to be posted on a dry run but got %v", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,238 @@
+// Package ankh is Ankh as a library, for tools and operators that template,
+// apply or diff charts the way the `ankh` command does, without running it.
+//
+// Unlike the command, the library never prompts, and returns errors instead of
+// exiting. It leaves out what the command adds around operations, eg: the
+// confirmation summary, notifications and run records.
+//
+//	client, err := ankh.New(ankh.Options{ConfigPath: "ankhconfig.yaml", Context: "production"})
+//	if err != nil {
+//		return err
+//	}
+//	defer client.Close()
+//	ankhFile, err := client.LoadAnkhFile("ankh.yaml")
+//	...
+//	charts, err := client.ResolveCharts(ankhFile, ankh.Diff)
+//	...
+//	out, err := client.Diff(charts, "web")
+package ankh
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/plan"
+)
+
+// The types of the library's API, so that callers need not import the
+// packages that define them.
+type (
+	ExecutionContext = ankh.ExecutionContext
+	AnkhConfig       = ankh.AnkhConfig
+	AnkhFile         = ankh.AnkhFile
+	Chart            = ankh.Chart
+	Mode             = ankh.Mode
+)
+
+// The modes of the library's operations, for ResolveCharts.
+const (
+	Template = ankh.Template
+	Diff     = ankh.Diff
+	Apply    = ankh.Apply
+	Rollback = ankh.Rollback
+)
+
+// Version is the version of Ankh that the library is built into, which the
+// `minimumAnkhVersion` of an Ankh config is checked against before changing a
+// cluster. The `ankh` command sets it to its own version.
+var Version = "DEVELOPMENT"
+
+// Options configure a Client.
+type Options struct {
+	// Comma separated paths or URLs of Ankh configs, as with `--ankhconfig`
+	ConfigPath string
+	// The context to operate on
+	Context string
+	// Overrides the release of the context, as with `--release`
+	Release        string
+	KubeConfigPath string
+	// Where fetched charts and rendered files are kept. Defaults to a
+	// temporary directory, which Close removes.
+	DataDir string
+	// Where to log to. Nothing is logged when unset.
+	Logger *logrus.Logger
+	// Warn about invalid configs, instead of failing
+	IgnoreConfigErrors bool
+	// Refuse to apply, as with `--read-only`
+	ReadOnly bool
+}
+
+// RollbackOptions are the options of Client.Rollback.
+type RollbackOptions struct {
+	DryRun bool
+	// The revision to roll back to, as listed by `ankh history`. Defaults to
+	// the previous revision.
+	Revision int
+}
+
+// ApplyOptions are the options of Client.Apply.
+type ApplyOptions struct {
+	DryRun bool
+	// Wait for every rollout to complete
+	Wait bool
+	// Do not install the CRDs in each chart's crds/ directory
+	SkipCrds bool
+	// Create the namespace if it does not exist
+	CreateNamespace bool
+}
+
+// A Client operates on one context of an Ankh config. Its operations are not
+// safe to use concurrently.
+type Client struct {
+	ctx        *ankh.ExecutionContext
+	ownDataDir bool
+}
+
+// New loads the config and selects the context to operate on.
+func New(opts Options) (*Client, error) {
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	if opts.Logger != nil {
+		logger.Out = opts.Logger.Out
+		logger.Formatter = opts.Logger.Formatter
+		logger.Level = opts.Logger.Level
+	}
+
+	client := &Client{}
+	dataDir := opts.DataDir
+	if dataDir == "" {
+		var err error
+		if dataDir, err = ioutil.TempDir("", "ankh"); err != nil {
+			return nil, err
+		}
+		client.ownDataDir = true
+	}
+
+	client.ctx = &ankh.ExecutionContext{
+		AnkhConfigPath:     opts.ConfigPath,
+		KubeConfigPath:     opts.KubeConfigPath,
+		Context:            opts.Context,
+		Release:            opts.Release,
+		DataDir:            dataDir,
+		Logger:             logger,
+		IgnoreConfigErrors: opts.IgnoreConfigErrors,
+		ReadOnly:           opts.ReadOnly,
+		NoPrompt:           true,
+	}
+
+	ankhConfig, err := LoadConfig(client.ctx, opts.ConfigPath)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	if err := UseContext(client.ctx, &ankhConfig, opts.Context); err != nil {
+		client.Close()
+		return nil, err
+	}
+	client.ctx.AnkhConfig = ankhConfig
+	return client, nil
+}
+
+// Close removes the data dir, unless it was given in the Options.
+func (client *Client) Close() error {
+	if client.ownDataDir {
+		return os.RemoveAll(client.ctx.DataDir)
+	}
+	return nil
+}
+
+// Context returns the context that the client operates with, eg: to set helm
+// values, or filters, before an operation.
+func (client *Client) Context() *ankh.ExecutionContext {
+	return client.ctx
+}
+
+// LoadAnkhFile reads an Ankh file, and any Ankh files it includes.
+func (client *Client) LoadAnkhFile(path string) (ankh.AnkhFile, error) {
+	client.ctx.AnkhFilePath = path
+	return ankh.GetAnkhFile(client.ctx)
+}
+
+// ResolveCharts resolves the charts of an Ankh file for an operation in
+// `mode`, as the `ankh` command does: see ResolveAnkhFile. Every chart needs a
+// version or a path, a namespace, and a tag if its `tagKey` is set, since the
+// library does not prompt for them. Library charts are left out.
+func (client *Client) ResolveCharts(ankhFile ankh.AnkhFile, mode ankh.Mode) ([]ankh.Chart, error) {
+	client.ctx.Mode = mode
+	ankhFile.Charts = append([]ankh.Chart{}, ankhFile.Charts...)
+	if err := ResolveAnkhFile(client.ctx, &ankhFile); err != nil {
+		return nil, err
+	}
+	return ankhFile.Charts, nil
+}
+
+func (client *Client) execute(mode ankh.Mode, namespace string, p *plan.Plan) (string, error) {
+	client.ctx.Mode = mode
+	return ExecutePlan(client.ctx, namespace, nil, p)
+}
+
+// Template renders charts for a namespace, as `ankh template` does.
+func (client *Client) Template(charts []ankh.Chart, namespace string) (string, error) {
+	if err := CheckChartVersions(client.ctx, charts); err != nil {
+		return "", err
+	}
+	return client.execute(ankh.Template, namespace, TemplatePlan(charts))
+}
+
+// Diff compares charts rendered for a namespace against the live objects, as
+// `ankh diff` does.
+func (client *Client) Diff(charts []ankh.Chart, namespace string) (string, error) {
+	client.ctx.Mode = ankh.Diff
+	if err := CheckChartVersions(client.ctx, charts); err != nil {
+		return "", err
+	}
+	return client.execute(ankh.Diff, namespace, DiffPlan(client.ctx, charts))
+}
+
+// Apply renders and applies charts to a namespace, as `ankh apply` does,
+// returning kubectl's output.
+func (client *Client) Apply(charts []ankh.Chart, namespace string, opts ApplyOptions) (string, error) {
+	ctx := client.ctx
+	ctx.Mode = ankh.Apply
+	ctx.DryRun, ctx.Wait, ctx.SkipCrds, ctx.CreateNamespace = opts.DryRun, opts.Wait, opts.SkipCrds, opts.CreateNamespace
+
+	if !opts.DryRun {
+		if err := CheckWritable(ctx, "apply"); err != nil {
+			return "", err
+		}
+	}
+	if err := CheckChartVersions(ctx, charts); err != nil {
+		return "", err
+	}
+	if _, err := InstallCrds(ctx, charts); err != nil {
+		return "", err
+	}
+	return client.execute(ankh.Apply, namespace, ApplyPlan(ctx, charts))
+}
+
+// Rollback rolls back the workloads of charts in a namespace, as `ankh
+// rollback` does, returning kubectl's output.
+func (client *Client) Rollback(charts []ankh.Chart, namespace string, opts RollbackOptions) (string, error) {
+	ctx := client.ctx
+	ctx.Mode = ankh.Rollback
+	ctx.DryRun, ctx.RollbackRevision = opts.DryRun, opts.Revision
+
+	if !opts.DryRun {
+		if err := CheckWritable(ctx, "rollback"); err != nil {
+			return "", err
+		}
+	}
+	if err := CheckChartVersions(ctx, charts); err != nil {
+		return "", err
+	}
+	return client.execute(ankh.Rollback, namespace, RollbackPlan(ctx, charts))
+}
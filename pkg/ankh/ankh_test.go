@@ -0,0 +1,284 @@
+package ankh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/trace"
+)
+
+func writeConfig(t *testing.T, config string) string {
+	dir, err := ioutil.TempDir("", "ankh-lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "ankhconfig.yaml")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestClient(t *testing.T) {
+	repository := ankhtest.NewChartRepository(t, ankhtest.Chart{Name: "foo", Version: "1.0.0",
+		Files: map[string]string{"ankh.yaml": "namespace: web\n"}})
+	tools := ankhtest.NewTools(t)
+	tools.Fake("helm", ankhtest.Rule{Args: "template *", Stdout: "---\n# Source: foo/templates/deployment.yaml\nkind: Deployment\nmetadata:\n  name: foo\n"})
+	tools.Fake("kubectl", ankhtest.Rule{Stdout: "deployment.apps/foo configured\n"})
+
+	configPath := writeConfig(t, `
+helm:
+  repository: `+repository.URL+`
+contexts:
+  test:
+    kube-context: test
+    environment-class: test
+    resource-profile: test
+`)
+
+	if _, err := New(Options{ConfigPath: configPath, Context: "missing"}); err == nil || !strings.Contains(err.Error(), "Context 'missing' not found") {
+		t.Fatalf("expected an unknown context to be an error, but got %v", err)
+	}
+
+	client, err := New(Options{ConfigPath: configPath, Context: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	charts, err := client.ResolveCharts(ankh.AnkhFile{Charts: []ankh.Chart{{Name: "foo", Version: "1.0.0"}}}, Template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(charts) != 1 || charts[0].ChartMeta.Namespace == nil || *charts[0].ChartMeta.Namespace != "web" {
+		t.Fatalf("expected the chart's namespace from its ankh.yaml but got %+v", charts)
+	}
+
+	out, err := client.Template(charts, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "kind: Deployment") {
+		t.Logf("expected the templated chart but got %q", out)
+		t.Fail()
+	}
+
+	if _, err := client.Apply(charts, "web", ApplyOptions{DryRun: true}); err != nil {
+		t.Fatal(err)
+	}
+	calls := tools.Calls("kubectl")
+	if len(calls) != 1 || !strings.Contains(strings.Join(calls[0].Args, " "), "apply") || !strings.Contains(strings.Join(calls[0].Args, " "), "--dry-run") {
+		t.Logf("expected a single dry run of kubectl apply but got %+v", calls)
+		t.Fail()
+	}
+
+	client.Context().ReadOnly = true
+	if _, err := client.Apply(charts, "web", ApplyOptions{}); err == nil {
+		t.Logf("expected a read-only client to refuse to apply")
+		t.Fail()
+	}
+}
+
+func TestClientReturnsErrors(t *testing.T) {
+	configPath := writeConfig(t, `
+contexts:
+  test:
+    kube-context: test
+    environment-class: test
+    resource-profile: test
+`)
+	client, err := New(Options{ConfigPath: configPath, Context: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Without a helm repository, the command would exit.
+	_, err = client.ResolveCharts(ankh.AnkhFile{Charts: []ankh.Chart{{Name: "foo", Version: "1.0.0"}}}, Template)
+	if err == nil || !strings.Contains(err.Error(), "No helm repository configured") {
+		t.Logf("expected the missing repository to be an error, but got %v", err)
+		t.Fail()
+	}
+}
+
+func TestClientResolvesTags(t *testing.T) {
+	repository := ankhtest.NewChartRepository(t, ankhtest.Chart{Name: "foo", Version: "1.0.0",
+		Files: map[string]string{"ankh.yaml": "namespace: web\ntagKey: image.tag\n"}})
+	configPath := writeConfig(t, `
+helm:
+  repository: `+repository.URL+`
+contexts:
+  test:
+    kube-context: test
+    environment-class: test
+    resource-profile: test
+`)
+	client, err := New(Options{ConfigPath: configPath, Context: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// As with the command, a tag is needed to template the chart, but not to
+	// roll it back.
+	if _, err := client.ResolveCharts(ankh.AnkhFile{Charts: []ankh.Chart{{Name: "foo", Version: "1.0.0"}}}, Template); err == nil || !strings.Contains(err.Error(), "missing value for `tagKey`") {
+		t.Logf("expected a missing tag to be an error, but got %v", err)
+		t.Fail()
+	}
+	if _, err := client.ResolveCharts(ankh.AnkhFile{Charts: []ankh.Chart{{Name: "foo", Version: "1.0.0"}}}, Rollback); err != nil {
+		t.Logf("expected no tag to be needed to roll back, but got %v", err)
+		t.Fail()
+	}
+
+	client.Context().HelmSetValues = map[string]string{"image.tag": "20240112"}
+	charts, err := client.ResolveCharts(ankh.AnkhFile{Charts: []ankh.Chart{{Name: "foo", Version: "1.0.0"}}}, Template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if charts[0].Tag == nil || *charts[0].Tag != "20240112" {
+		t.Logf("expected the tag from --set but got %+v", charts[0].Tag)
+		t.Fail()
+	}
+}
+
+func TestClientChecksChartVersions(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("helm", ankhtest.Rule{})
+	tools.Fake("kubectl", ankhtest.Rule{})
+	configPath := writeConfig(t, `
+contexts:
+  test:
+    kube-context: test
+    environment-class: test
+    resource-profile: test
+    chartVersions:
+      stable: true
+`)
+	client, err := New(Options{ConfigPath: configPath, Context: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Charts need not come from ResolveCharts, so every operation checks them.
+	namespace := "web"
+	charts := []ankh.Chart{{Name: "foo", Version: "1.0.0-rc.1", ChartMeta: ankh.ChartMeta{Namespace: &namespace}}}
+	if _, err := client.Template(charts, namespace); err == nil || !strings.Contains(err.Error(), "chartVersions") {
+		t.Logf("expected a prerelease to be refused by `chartVersions` but got %v", err)
+		t.Fail()
+	}
+	if _, err := client.Apply(charts, namespace, ApplyOptions{}); err == nil || !strings.Contains(err.Error(), "chartVersions") {
+		t.Logf("expected a prerelease to be refused by `chartVersions` but got %v", err)
+		t.Fail()
+	}
+	if calls := tools.Calls("helm"); len(calls) != 0 {
+		t.Logf("expected nothing to be templated but got %+v", calls)
+		t.Fail()
+	}
+}
+
+func TestClientChecksMinimumVersion(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("helm", ankhtest.Rule{})
+	tools.Fake("kubectl", ankhtest.Rule{})
+	configPath := writeConfig(t, `
+minimumAnkhVersion: 2.1.0
+contexts:
+  test:
+    kube-context: test
+    environment-class: test
+    resource-profile: test
+`)
+	client, err := New(Options{ConfigPath: configPath, Context: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	defer func(version string) { Version = version }(Version)
+	Version = "2.0.0"
+	namespace := "web"
+	charts := []ankh.Chart{{Name: "foo", Version: "1.0.0", ChartMeta: ankh.ChartMeta{Namespace: &namespace}}}
+	if _, err := client.Apply(charts, namespace, ApplyOptions{}); err == nil || !strings.Contains(err.Error(), "requires Ankh version 2.1.0") {
+		t.Logf("expected an apply from an older version to be refused but got %v", err)
+		t.Fail()
+	}
+	if _, err := client.Rollback(charts, namespace, RollbackOptions{}); err == nil || !strings.Contains(err.Error(), "requires Ankh version 2.1.0") {
+		t.Logf("expected a rollback from an older version to be refused but got %v", err)
+		t.Fail()
+	}
+	if calls := tools.Calls("kubectl"); len(calls) != 0 {
+		t.Logf("expected nothing to be applied but got %+v", calls)
+		t.Fail()
+	}
+}
+
+func TestClientRunsKubectlOncePerNamespace(t *testing.T) {
+	repository := ankhtest.NewChartRepository(t,
+		ankhtest.Chart{Name: "foo", Version: "1.0.0", Files: map[string]string{"ankh.yaml": "namespace: web\n"}},
+		ankhtest.Chart{Name: "bar", Version: "1.0.0", Files: map[string]string{"ankh.yaml": "namespace: web\n"}})
+	tools := ankhtest.NewTools(t)
+	tools.Fake("helm",
+		ankhtest.Rule{Args: "template */foo", Stdout: "---\nkind: Deployment\nmetadata:\n  name: foo\n"},
+		ankhtest.Rule{Args: "template */bar", Stdout: "---\nkind: Deployment\nmetadata:\n  name: bar\n"})
+	tools.Fake("kubectl", ankhtest.Rule{})
+
+	configPath := writeConfig(t, `
+helm:
+  repository: `+repository.URL+`
+contexts:
+  test:
+    kube-context: test
+    environment-class: test
+    resource-profile: test
+`)
+	client, err := New(Options{ConfigPath: configPath, Context: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	charts, err := client.ResolveCharts(ankh.AnkhFile{Charts: []ankh.Chart{{Name: "foo", Version: "1.0.0"}, {Name: "bar", Version: "1.0.0"}}}, Apply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The charts of a namespace are combined into one manifest, which kubectl
+	// diffs and applies in one call each.
+	client.Context().Tracer = trace.NewTracer("ankh")
+	if _, err := client.Diff(charts, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Apply(charts, "web", ApplyOptions{DryRun: true}); err != nil {
+		t.Fatal(err)
+	}
+	calls := tools.Calls("kubectl")
+	if len(calls) != 2 {
+		t.Fatalf("expected one kubectl call to diff and one to apply but got %+v", calls)
+	}
+	for _, call := range calls {
+		if !strings.Contains(call.Stdin, "name: foo") || !strings.Contains(call.Stdin, "name: bar") {
+			t.Logf("expected kubectl %v to be given the objects of both charts but got %q", call.Args, call.Stdin)
+			t.Fail()
+		}
+	}
+
+	// Each stage's span is named after the stage, rather than the kubectl runner.
+	tracePath := filepath.Join(client.Context().DataDir, "trace.json")
+	if err := client.Context().Tracer.Export("", tracePath); err != nil {
+		t.Fatal(err)
+	}
+	traces, _ := ioutil.ReadFile(tracePath)
+	for _, name := range []string{"stage kubectl.ApplyStage", "stage helm.TemplateStage"} {
+		if !strings.Contains(string(traces), `"`+name+`"`) {
+			t.Logf("expected a span named %q but got %s", name, traces)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package ankh
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/util"
+)
+
+// Returns an error if a chart's version is not allowed by the `chartVersions`
+// of the current context, or of an environment that includes it.
+func checkChartVersion(ctx *ankh.ExecutionContext, chart ankh.Chart) error {
+	policies := ctx.ChartVersionPolicies()
+	sources := []string{}
+	for source := range policies {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	for _, source := range sources {
+		policy := policies[source]
+		if err := util.CheckChartVersion(chart.Version, policy.Constraint, policy.Stable); err != nil {
+			return fmt.Errorf("Chart \"%v\" is not allowed in context \"%v\" by `chartVersions` of %v: %v",
+				chart.InstanceName(), ctx.AnkhConfig.CurrentContextName, source, err)
+		}
+	}
+	return nil
+}
+
+// CheckChartVersions returns an error if the version of any chart is not
+// allowed in the current context, or logs it with `--ignore-config-errors`.
+// Charts from local paths have no version to check.
+func CheckChartVersions(ctx *ankh.ExecutionContext, charts []ankh.Chart) error {
+	for _, chart := range charts {
+		if chart.Path != "" {
+			continue
+		}
+		if err := checkChartVersion(ctx, chart); err != nil {
+			if !ctx.IgnoreConfigErrors {
+				return err
+			}
+			ctx.Logger.Warnf("%v. Continuing, since --ignore-config-errors is set", err)
+		}
+	}
+	return nil
+}
+
+// Leaves out the versions of a chart that are not allowed in the current
+// context, eg: before prompting for one.
+func allowedChartVersions(ctx *ankh.ExecutionContext, chart ankh.Chart, versions []string) []string {
+	allowed := []string{}
+	for _, version := range versions {
+		chart.Version = version
+		if err := checkChartVersion(ctx, chart); err != nil {
+			ctx.Logger.Debugf("Leaving out version %v: %v", version, err)
+			continue
+		}
+		allowed = append(allowed, version)
+	}
+	return allowed
+}
@@ -0,0 +1,34 @@
+package ankh
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+	"github.com/appnexus/ankh/context"
+)
+
+func TestChartVersionPolicies(t *testing.T) {
+	ctx := ankhtest.NewContext(t)
+	chart := ankh.Chart{Name: "api", Version: "1.3.0-rc.1"}
+	if err := checkChartVersion(ctx, chart); err != nil {
+		t.Logf("expected any version to be allowed without a policy but got %v", err)
+		t.Fail()
+	}
+
+	ctx.AnkhConfig.Environments = map[string]ankh.Environment{
+		"production": {Contexts: []string{"test"}, ChartVersions: &ankh.ChartVersionPolicy{Stable: true}},
+		"staging":    {Contexts: []string{"staging"}, ChartVersions: &ankh.ChartVersionPolicy{Constraint: "<1.0"}},
+	}
+	if err := checkChartVersion(ctx, chart); err == nil {
+		t.Logf("expected a prerelease to be refused by an environment that includes the context")
+		t.Fail()
+	}
+
+	ctx.AnkhConfig.CurrentContext.ChartVersions = &ankh.ChartVersionPolicy{Constraint: ">=1.2"}
+	versions := []string{"1.3.0", "1.3.0-rc.1", "1.2.1", "1.1.0"}
+	if allowed := allowedChartVersions(ctx, chart, versions); fmt.Sprint(allowed) != "[1.3.0 1.2.1]" {
+		t.Logf("expected only stable versions from 1.2 on but got %v", allowed)
+		t.Fail()
+	}
+}
@@ -0,0 +1,108 @@
+package ankh
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/imdario/mergo"
+
+	"github.com/appnexus/ankh/config"
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/update"
+	"github.com/appnexus/ankh/util"
+)
+
+// LoadConfig reads and merges Ankh configs, given as a comma separated list of
+// paths or URLs, along with every config that they include. Configs are merged
+// in order, so earlier configs take precedence over the ones they include.
+//
+// Invalid configs, and contexts or environments defined by more than one
+// config, are errors unless ctx.IgnoreConfigErrors is set, in which case they
+// are logged as warnings.
+func LoadConfig(ctx *ankh.ExecutionContext, configPaths string) (ankh.AnkhConfig, error) {
+	mergedAnkhConfig := ankh.AnkhConfig{}
+	parsedConfigs := make(map[string]bool)
+	paths := strings.Split(configPaths, ",")
+	for len(paths) > 0 {
+		configPath := paths[0]
+		paths = paths[1:]
+
+		if parsedConfigs[configPath] {
+			ctx.Logger.Debugf("Already parsed %v", configPath)
+			continue
+		}
+
+		ctx.Logger.Debugf("Using config from path %v", configPath)
+
+		ankhConfig, err := config.GetAnkhConfigWithDefaults(ctx, configPath)
+		if err != nil {
+			// TODO: this is a mess
+			if !ctx.IgnoreContextAndEnv && !ctx.IgnoreConfigErrors {
+				// The config validation errors are not recoverable.
+				return mergedAnkhConfig, err
+			}
+			ctx.Logger.Warnf("%v", err)
+		}
+
+		// Conflicting contexts and environments are almost certainly unintentional.
+		for name, _ := range ankhConfig.Contexts {
+			if context, ok := mergedAnkhConfig.Contexts[name]; ok {
+				complaint := fmt.Sprintf("Context `%v` already defined from config source `%v`, would have been overriden by config source `%v`.",
+					name, context.Source, configPath)
+				if !ctx.IgnoreConfigErrors {
+					return mergedAnkhConfig, errors.New(complaint)
+				}
+				ctx.Logger.Warnf("%v", complaint)
+			}
+		}
+		for name, _ := range ankhConfig.Environments {
+			if environment, ok := mergedAnkhConfig.Environments[name]; ok {
+				complaint := fmt.Sprintf("Environment `%v` already defined from config source `%v`, would have been overriden by config source `%v`.",
+					name, environment.Source, configPath)
+				if !ctx.IgnoreConfigErrors {
+					return mergedAnkhConfig, errors.New(complaint)
+				}
+				ctx.Logger.Warnf("%v", complaint)
+			}
+		}
+
+		// Merge it in. We'll need to dedup arrays later.
+		minimumAnkhVersion := update.HigherVersion(mergedAnkhConfig.MinimumAnkhVersion, ankhConfig.MinimumAnkhVersion)
+		mergo.Merge(&mergedAnkhConfig, ankhConfig)
+		mergedAnkhConfig.MinimumAnkhVersion = minimumAnkhVersion
+
+		// Follow includes, mark this one as visited.
+		paths = append(paths, ankhConfig.Include...)
+		parsedConfigs[configPath] = true
+	}
+
+	// Don't accidentally wind up in an include cycle.
+	mergedAnkhConfig.Include = util.ArrayDedup(mergedAnkhConfig.Include)
+	return mergedAnkhConfig, nil
+}
+
+// UseContext makes a context of the config the current one, and validates it.
+// Validation errors are ignored when ctx.IgnoreContextAndEnv is set.
+func UseContext(ctx *ankh.ExecutionContext, ankhConfig *ankh.AnkhConfig, context string) error {
+	if context == "" {
+		return fmt.Errorf("No context or environment provided")
+	}
+	if _, ok := ankhConfig.Contexts[context]; !ok {
+		return fmt.Errorf("Context '%v' not found in `contexts`", context)
+	}
+	errs := ankhConfig.ValidateAndInit(ctx, context)
+	if len(errs) > 0 && !ctx.IgnoreContextAndEnv {
+		return errors.New(util.MultiErrorFormat(errs))
+	}
+	return nil
+}
+
+// CheckWritable returns an error if the context is read-only, or if the Ankh
+// config requires a newer version of Ankh than Version to change clusters.
+func CheckWritable(ctx *ankh.ExecutionContext, action string) error {
+	if err := ctx.CheckWritable(action); err != nil {
+		return err
+	}
+	return update.CheckMinimumVersion(ctx, Version)
+}
@@ -0,0 +1,64 @@
+package ankh
+
+import (
+	"fmt"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/docker"
+	"github.com/appnexus/ankh/util"
+)
+
+// Whether a chart's tag comes from the git commit of the working directory,
+// either because of `--tag-from-git`, or the chart's `tagPolicy`.
+func usesGitShaTag(ctx *ankh.ExecutionContext, chart *ankh.Chart) (bool, error) {
+	switch chart.ChartMeta.TagPolicy {
+	case "":
+		return ctx.TagFromGit, nil
+	case ankh.TagPolicyGitSha:
+		return true, nil
+	default:
+		return false, fmt.Errorf("Unknown `tagPolicy` \"%v\" for chart \"%v\", expected \"%v\"",
+			chart.ChartMeta.TagPolicy, chart.Name, ankh.TagPolicyGitSha)
+	}
+}
+
+// Resolves a chart's tag from the short sha of HEAD. Before anything is
+// applied, the tag must exist in the registry, since CI may not have pushed
+// the image for this commit yet.
+func gitShaTag(ctx *ankh.ExecutionContext, chart *ankh.Chart) (string, error) {
+	tag, dirty, err := util.GitShaTag(".", ctx.AnkhConfig.Docker.GitDirtySuffix)
+	if err != nil {
+		return "", fmt.Errorf("Unable to get a tag for chart \"%v\" from git: %v", chart.Name, err)
+	}
+	if dirty && ctx.AnkhConfig.Docker.GitDirtySuffix == "" {
+		ctx.Logger.Warnf("The git working tree has uncommitted changes, which the image tagged \"%v\" does not include", tag)
+	}
+
+	switch ctx.Mode {
+	case ankh.Apply, ankh.Deploy:
+	default:
+		return tag, nil
+	}
+
+	registryDomain, image := ctx.AnkhConfig.Docker.Registry, chart.Name
+	if chart.ChartMeta.TagImage != "" {
+		registryDomain, image, err = docker.ParseImage(ctx, chart.ChartMeta.TagImage)
+		if err != nil {
+			return "", err
+		}
+	}
+	if registryDomain == "" {
+		ctx.Logger.Warnf("Not checking that tag \"%v\" exists for chart \"%v\", since neither `tagImage` nor `docker.registry` is configured", tag, chart.Name)
+		return tag, nil
+	}
+
+	exists, err := docker.TagExists(ctx, registryDomain, image, tag)
+	if err != nil {
+		return "", fmt.Errorf("Unable to check that tag \"%v\" exists for image \"%v\" in registry \"%v\": %v", tag, image, registryDomain, err)
+	}
+	if !exists {
+		return "", fmt.Errorf("Image \"%v\" has no tag \"%v\" in registry \"%v\". Has the image for this commit been pushed yet?", image, tag, registryDomain)
+	}
+	ctx.Logger.Infof("Found tag \"%v\" for image \"%v\" in registry \"%v\"", tag, image, registryDomain)
+	return tag, nil
+}
@@ -0,0 +1,173 @@
+package ankh
+
+import (
+	"fmt"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/helm"
+	"github.com/appnexus/ankh/kubectl"
+	"github.com/appnexus/ankh/plan"
+)
+
+// InstallCrds installs the CRDs in the charts' crds/ directories, which `helm
+// template` leaves out, ahead of the custom resources that depend on them.
+// Returns the commands that would do so in explain mode.
+func InstallCrds(ctx *ankh.ExecutionContext, charts []ankh.Chart) (string, error) {
+	if ctx.SkipCrds {
+		return "", nil
+	}
+	paths, err := helm.GetCrdFiles(ctx, charts)
+	if err != nil || len(paths) == 0 {
+		return "", err
+	}
+	return kubectl.ApplyCrds(ctx, paths)
+}
+
+// ExecutePlan executes a plan for a namespace, piping templated output
+// through the post-renderer and the context's kustomize overlay, if any, before
+// the stages that use it.
+func ExecutePlan(ctx *ankh.ExecutionContext, namespace string, wildCardLabels []string, p *plan.Plan) (string, error) {
+	if helm.HasPostRenderer(ctx) {
+		p = WithPostRenderer(p)
+	}
+	if helm.HasKustomizeOverlay(ctx) {
+		p = WithKustomizeOverlay(p)
+	}
+	return plan.Execute(ctx, namespace, wildCardLabels, p)
+}
+
+// WithPostRenderer adds the post-render stage after each template stage of a plan.
+func WithPostRenderer(p *plan.Plan) *plan.Plan {
+	stages := []plan.PlanStage{}
+	for _, ps := range p.PlanStages {
+		stages = append(stages, ps)
+		if _, ok := ps.Stage.(helm.TemplateStage); ok {
+			stages = append(stages, plan.PlanStage{Stage: helm.NewPostRenderStage()})
+		}
+	}
+	return &plan.Plan{PlanStages: stages}
+}
+
+// WithKustomizeOverlay adds the kustomize stage after each template stage of a
+// plan, or after its post-render stage, if any.
+func WithKustomizeOverlay(p *plan.Plan) *plan.Plan {
+	stages := []plan.PlanStage{}
+	for i, ps := range p.PlanStages {
+		stages = append(stages, ps)
+		switch ps.Stage.(type) {
+		case helm.TemplateStage:
+			if i+1 < len(p.PlanStages) {
+				if _, ok := p.PlanStages[i+1].Stage.(helm.PostRenderStage); ok {
+					continue
+				}
+			}
+		case helm.PostRenderStage:
+		default:
+			continue
+		}
+		stages = append(stages, plan.PlanStage{Stage: helm.NewKustomizeStage(), Opts: plan.StageOpts{
+			Description: "applies the context's kustomize overlay to the rendered objects",
+		}})
+	}
+	return &plan.Plan{PlanStages: stages}
+}
+
+// NamespacePlanStage creates the namespace, if needed, before anything is applied to it.
+func NamespacePlanStage() plan.PlanStage {
+	return plan.PlanStage{Stage: kubectl.NewNamespaceStage(), Opts: plan.StageOpts{
+		Description: "creates the namespace, with the context's namespaceMetadata, if it does not exist",
+	}}
+}
+
+// HookPlanStage runs the charts' hooks for a phase, eg: `preApply`.
+func HookPlanStage(charts []ankh.Chart, phase string) plan.PlanStage {
+	return plan.PlanStage{Stage: helm.NewHookStage(charts, phase), Opts: plan.StageOpts{
+		Description: fmt.Sprintf("runs the %v hooks of the charts, except on a dry run", phase),
+	}}
+}
+
+// TemplatePlan renders the charts.
+func TemplatePlan(charts []ankh.Chart) *plan.Plan {
+	return &plan.Plan{
+		PlanStages: []plan.PlanStage{
+			plan.PlanStage{Stage: helm.NewTemplateStage(charts)},
+		},
+	}
+}
+
+// DiffPlan compares the rendered charts against the live objects, with the
+// diff tool of the config, if any, or else semantically.
+func DiffPlan(ctx *ankh.ExecutionContext, charts []ankh.Chart) *plan.Plan {
+	diffStage := kubectl.NewSemanticDiffStage()
+	if kubectl.UsesExternalDiff(ctx) {
+		if ctx.DiffSummary {
+			ctx.Logger.Warnf("Ignoring --summary, since an external diff tool is in use")
+		}
+		diffStage = kubectl.NewDiffStage()
+	}
+	return &plan.Plan{
+		PlanStages: []plan.PlanStage{
+			plan.PlanStage{Stage: helm.NewTemplateStage(charts)},
+			plan.PlanStage{Stage: diffStage},
+		},
+	}
+}
+
+// ApplyPlan renders and applies the charts, for apply and explain. With
+// ctx.Wait, it then waits for the rollouts of an apply to complete.
+func ApplyPlan(ctx *ankh.ExecutionContext, charts []ankh.Chart) *plan.Plan {
+	applyStage := kubectl.NewApplyStage()
+	if ctx.AdmissionPreview {
+		applyStage = kubectl.NewAdmissionPreviewStage()
+	}
+	stages := []plan.PlanStage{
+		plan.PlanStage{Stage: helm.NewTemplateStage(charts)},
+		plan.PlanStage{Stage: helm.NewAnnotateStage(charts)},
+	}
+	// An admission preview only asks the API server, so it creates nothing.
+	if kubectl.CreatesNamespaces(ctx) && !ctx.AdmissionPreview {
+		stages = append(stages, NamespacePlanStage())
+	}
+	// Nor does it run hooks, since nothing is applied.
+	hooks := !ctx.AdmissionPreview
+	if hooks && helm.HasHooks(charts, helm.HookPreApply) {
+		stages = append(stages, HookPlanStage(charts, helm.HookPreApply))
+	}
+	stages = append(stages, plan.PlanStage{Stage: applyStage})
+	if ctx.Mode == ankh.Apply && ctx.Wait {
+		if ctx.DryRun {
+			ctx.Logger.Infof("Not waiting for rollouts, since nothing is applied on a dry run")
+		} else {
+			// The rollout stage needs the templated objects, not kubectl's output.
+			stages[len(stages)-1].Opts.PassThroughInput = true
+			stages = append(stages, plan.PlanStage{Stage: kubectl.NewRolloutStatusStage(), Opts: plan.StageOpts{
+				PreExecute: func() bool {
+					ctx.Logger.Infof("Waiting for rollouts to complete...")
+					return true
+				},
+				Description: "waits for every rollout to complete",
+			}})
+		}
+	}
+	if hooks && helm.HasHooks(charts, helm.HookPostApply) {
+		stages = append(stages, HookPlanStage(charts, helm.HookPostApply))
+	}
+	return &plan.Plan{
+		PlanStages: stages,
+	}
+}
+
+// RollbackPlan renders the charts to find their workloads, and rolls them back
+// to ctx.RollbackRevision, or else to their previous revision.
+func RollbackPlan(ctx *ankh.ExecutionContext, charts []ankh.Chart) *plan.Plan {
+	p := &plan.Plan{
+		PlanStages: []plan.PlanStage{
+			plan.PlanStage{Stage: helm.NewTemplateStage(charts)},
+			plan.PlanStage{Stage: kubectl.NewRollbackStage(ctx.RollbackRevision)},
+		},
+	}
+	if helm.HasHooks(charts, helm.HookPostRollback) {
+		p.PlanStages = append(p.PlanStages, HookPlanStage(charts, helm.HookPostRollback))
+	}
+	return p
+}
@@ -0,0 +1,371 @@
+package ankh
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/imdario/mergo"
+
+	"github.com/appnexus/ankh/catalog"
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/docker"
+	"github.com/appnexus/ankh/helm"
+	"github.com/appnexus/ankh/util"
+)
+
+// ResolveAnkhFile fills in what the charts of an Ankh file leave out, the way
+// the `ankh` command does before every operation: each chart's version, meta
+// from its `ankh.yaml`, namespace and tag. Versions are checked against the
+// `chartVersions` of the current context. Anything that cannot be decided is
+// prompted for, unless `ctx.NoPrompt` is set, in which case it is an error.
+// Library charts are removed from the Ankh file.
+func ResolveAnkhFile(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) error {
+	// Make sure that we don't use the tag argument for more than one Chart.
+	// When this happens, it is almost always an error, because a tag value
+	// is typically only valid/intended for a single chart.
+	tagArgumentUsedForChart := ""
+
+	// Ankh files may also be assembled from chart arguments and dependencies,
+	// so check for duplicates again before resolving anything per chart.
+	if err := ankh.ValidateChartInstances(*ankhFile); err != nil {
+		return err
+	}
+
+	// Catalog metadata provides default namespaces, and enriches notifications.
+	catalog.Annotate(ctx, ankhFile.Charts)
+
+	// Prompt for chart versions if any are missing
+	for i := 0; i < len(ankhFile.Charts); i++ {
+		chart := &ankhFile.Charts[i]
+
+		if chart.Path == "" && chart.Version == "" {
+			ctx.Logger.Infof("Found chart \"%v\" without a version", chart.InstanceName())
+			if ctx.NoPrompt {
+				return fmt.Errorf("Chart \"%v\" missing version (and no 'path' set either, not prompting due to --no-prompt)",
+					chart.InstanceName())
+			}
+
+			repository, err := ctx.DetermineHelmRepository(&chart.HelmRepository)
+			if err != nil {
+				return err
+			}
+			versions, err := helm.ListVersions(ctx, repository, chart.Name, true)
+			if err != nil {
+				return err
+			}
+
+			versionsList := util.FilterStringsContaining(strings.Split(strings.Trim(versions, "\n "), "\n"), ctx.ChartVersionFilter)
+			if !ctx.IgnoreConfigErrors {
+				versionsList = allowedChartVersions(ctx, *chart, versionsList)
+				if len(versionsList) == 0 {
+					return fmt.Errorf("No versions of chart \"%v\" are allowed by `chartVersions` in context \"%v\"",
+						chart.InstanceName(), ctx.AnkhConfig.CurrentContextName)
+				}
+			}
+			versionsList = helm.FlagDeprecatedVersions(ctx, repository, chart.Name, versionsList)
+
+			selectedVersion, err := util.PromptForSelection(versionsList,
+				fmt.Sprintf("Select a version for chart \"%v\"", chart.InstanceName()), false)
+			if err != nil {
+				return err
+			}
+
+			chart.Version = strings.Fields(selectedVersion)[0]
+			ctx.Logger.Infof("Using chart \"%v\" at version \"%v\" based on prompt selection", chart.InstanceName(), chart.Version)
+		} else if chart.Path != "" {
+			ctx.Logger.Infof("Using chart \"%v\" from local path \"%v\"", chart.InstanceName(), chart.Path)
+		}
+
+		if err := CheckChartVersions(ctx, []ankh.Chart{*chart}); err != nil {
+			return err
+		}
+
+		repository, err := ctx.DetermineHelmRepository(&chart.HelmRepository)
+		if err != nil {
+			return err
+		}
+		if chart.Path == "" && (ctx.Mode == ankh.Apply || ctx.Mode == ankh.Deploy) {
+			helm.WarnIfDeprecated(ctx, repository, chart.Name, chart.Version)
+		}
+
+		// Now that we have either a version or a local path, fetch the chart metadata and merge it.
+		meta, err := helm.FetchChartMeta(ctx, repository, chart)
+		if err != nil {
+			return fmt.Errorf("Error fetching chart \"%v\": %v", chart.InstanceName(), err)
+		}
+		mergo.Merge(&chart.ChartMeta, meta)
+
+		// Library charts are only rendered as dependencies of other charts.
+		if chart.ChartMeta.Library {
+			ctx.Logger.Warnf("Skipping chart \"%v\", since it is a library chart that only provides templates to other charts",
+				chart.InstanceName())
+			ankhFile.Charts = append(ankhFile.Charts[:i], ankhFile.Charts[i+1:]...)
+			i--
+			continue
+		}
+
+		// If namespace is set on the command line, for all charts or just
+		// this one, we'll use that as an override later during
+		// executeChartsOnNamespace, so don't check for anything here.
+		// - command line override, ankh file, chart meta.
+		if ctx.ChartNamespaceOverride(*chart) == nil {
+			if ankhFile.Namespace != nil {
+				extraLog := ""
+				if chart.ChartMeta.Namespace != nil && *ankhFile.Namespace != *chart.ChartMeta.Namespace {
+					extraLog = fmt.Sprintf(" (overriding namespace \"%v\" from ankh.yaml present in the chart)",
+						*chart.ChartMeta.Namespace)
+				}
+				ctx.Logger.Warnf("Using namespace \"%v\" from Ankh file for chart \"%v\"%v. This feature will be removed in Ankh 2.0",
+					*ankhFile.Namespace, chart.InstanceName(), extraLog)
+				chart.ChartMeta.Namespace = ankhFile.Namespace
+			} else if chart.ChartMeta.Namespace == nil && chart.CatalogEntry != nil && chart.CatalogEntry.Namespace != "" {
+				namespace := chart.CatalogEntry.Namespace
+				chart.ChartMeta.Namespace = &namespace
+				ctx.Logger.Infof("Using namespace \"%v\" for chart \"%v\" based on the service catalog",
+					namespace, chart.InstanceName())
+			} else if chart.ChartMeta.Namespace == nil {
+				ctx.Logger.Infof("Found chart \"%v\" without a namespace", chart.InstanceName())
+				if ctx.NoPrompt {
+					return fmt.Errorf("Chart \"%v\" missing namespace (not prompting due to --no-prompt)", chart.InstanceName())
+				}
+				if len(ctx.AnkhConfig.Namespaces) > 0 {
+					selectedNamespace, err := util.PromptForSelection(ctx.AnkhConfig.Namespaces,
+						fmt.Sprintf("Select a namespace for chart '%v' (or re-run with -n/--namespace to provide your own)",
+							chart.InstanceName()), false)
+					if err != nil {
+						return err
+					}
+					chart.ChartMeta.Namespace = &selectedNamespace
+				} else {
+					providedNamespace, err := util.PromptForInput("",
+						fmt.Sprintf("Provide a namespace for chart '%v' (or enter nothing to denote no explicit namespace) > ",
+							chart.InstanceName()))
+					if err != nil {
+						return err
+					}
+					chart.ChartMeta.Namespace = &providedNamespace
+
+				}
+				ctx.Logger.Infof("Using namespace \"%v\" for chart \"%v\" based on prompt selection",
+					*chart.ChartMeta.Namespace, chart.InstanceName())
+			} else {
+				ctx.Logger.Infof("Using namespace \"%v\" for chart \"%v\" based on ankh.yaml present in the chart",
+					*chart.ChartMeta.Namespace, chart.InstanceName())
+			}
+		}
+
+		// tagKey comes directly from the chart's metadata
+		tagKey := chart.ChartMeta.TagKey
+
+		// Do nothing if tagKey is not configured - the user does not want this behavior.
+		if tagKey == "" {
+			if ctx.Tag != nil {
+				return fmt.Errorf("Tag has been provided but `tagKey` is not configured on either the `chart` in an AnkhFile, nor in an `ankh.yaml` inside the helm chart. " +
+					"This means you passed a tag value, but have not told Ankh which helm value corresponds " +
+					"to the tag value/variable in your helm chart. Tag is shorthand for `--set $tagKey=$tag`, " +
+					"so you can use that instead, or you can ensure that `chart.tagKey` is configured")
+			}
+			continue
+		} else {
+			ctx.Logger.Infof("Using tagKey \"%v\" for chart \"%v\" based on ankh.yaml present in the chart", chart.ChartMeta.TagKey, chart.InstanceName())
+		}
+
+		if ctx.Tag != nil {
+			// Aliases of the same chart share its images, so they share the tag too.
+			if tagArgumentUsedForChart != "" && tagArgumentUsedForChart != chart.Name {
+				complaint := fmt.Sprintf("Cannot use tag value for chart \"%v\" because it was already used for chart \"%v\". "+
+					"A tag value is almost always intended for use with a single chart. To ignore this error and "+
+					"use tag value \"%v\" for _all_ charts, re-un using `ankh --ignore-config-errors ...` ",
+					chart.InstanceName(), tagArgumentUsedForChart, *ctx.Tag)
+				if ctx.IgnoreConfigErrors {
+					ctx.Logger.Warnf("%v", complaint)
+				} else {
+					return fmt.Errorf("%v", complaint)
+				}
+			}
+
+			ctx.Logger.Infof("Using tag value \"%v=%s\" based on --tag argument", tagKey, *ctx.Tag)
+			chart.Tag = ctx.Tag
+			tagArgumentUsedForChart = chart.Name
+			continue
+		}
+
+		fromGit, err := usesGitShaTag(ctx, chart)
+		if err != nil {
+			return err
+		}
+		if fromGit {
+			tag, err := gitShaTag(ctx, chart)
+			if err != nil {
+				return err
+			}
+			ctx.Logger.Infof("Using tag value \"%v=%s\" based on the git commit", tagKey, tag)
+			chart.Tag = &tag
+			ctx.DeploymentTag = tag
+			continue
+		}
+
+		// Treat any existing --set tagKey=$tag argument as authoritative
+		for k, v := range ctx.HelmSetValues {
+			if k == tagKey {
+				ctx.Logger.Infof("Using tag value \"%v=%s\" based on --set argument", tagKey, v)
+				t := v
+				chart.Tag = &t
+				break
+			}
+		}
+		if v, ok := ctx.HelmSetStringValues[tagKey]; ok {
+			ctx.Logger.Infof("Using tag value \"%v=%s\" based on --set-string argument", tagKey, v)
+			t := v
+			chart.Tag = &t
+		}
+
+		// Treat any existing `tag` in `default-values` for this chart as the next-most authoritative
+		for k, v := range chart.DefaultValues {
+			if k == tagKey {
+				ctx.Logger.Infof("Using tag value \"%v=%s\" based on default-values present in the Ankh file", tagKey, v)
+				t, ok := v.(string)
+				if !ok {
+					return fmt.Errorf("Could not use value '%+v' from default-values in chart %v "+
+						"as a string value for tagKey '%v'", v, chart.InstanceName(), tagKey)
+				}
+				chart.Tag = &t
+				break
+			}
+		}
+
+		// For certain operations, we can assume a safe `unset` value for tagKey
+		// for the sole purpose of templating the Helm chart. The value won't be used
+		// meaningfully (like it would be with apply), so we choose this method instead
+		// of prompting the user for a value that isn't meaningful.
+		switch ctx.Mode {
+		case ankh.Explain:
+			fallthrough
+		case ankh.Rollback:
+			fallthrough
+		case ankh.Get:
+			fallthrough
+		case ankh.Pods:
+			fallthrough
+		case ankh.Exec:
+			fallthrough
+		case ankh.Report:
+			fallthrough
+		case ankh.History:
+			fallthrough
+		case ankh.Delete:
+			fallthrough
+		case ankh.Scale:
+			fallthrough
+		case ankh.PortForward:
+			fallthrough
+		case ankh.Logs:
+			if chart.Tag != nil {
+				break
+			}
+
+			_, ok := ctx.HelmSetValues[tagKey]
+			if !ok {
+				// It's unset, so set it for the purpose of this execution
+				tag := "__ankh_tag_value_unset___"
+				ctx.Logger.Debugf("Setting configured tagKey %v=%v for a safe operation",
+					tagKey, tag)
+				chart.Tag = &tag
+			}
+		}
+
+		// If we stil don't have a chart.Tag value, prompt.
+		if chart.Tag == nil {
+			if ctx.NoPrompt {
+				return fmt.Errorf("Chart \"%v\" missing value for `tagKey` (configured to be '%v',  not prompting due to --no-prompt)",
+					chart.InstanceName(), tagKey)
+			}
+
+			registryDomain := ctx.AnkhConfig.Docker.Registry
+			image := ""
+			if chart.ChartMeta.TagImage != "" {
+				// No need to prompt for an image name if we already have one in the chart metdata
+				registryDomain, image, err = docker.ParseImage(ctx, chart.ChartMeta.TagImage)
+				if err != nil {
+					return err
+				}
+
+				ctx.Logger.Infof("Using tagImage \"%v\" for chart \"%v\" based on ankh.yaml present in the chart", chart.ChartMeta.TagImage, chart.InstanceName())
+				ctx.Logger.Debugf("Parsed tagImage into registryDomain '%v' and image '%v'", registryDomain, image)
+			} else {
+				ctx.Logger.Infof("Found chart \"%v\" without a value for \"%v\" ", chart.InstanceName(), tagKey)
+				if ctx.AnkhConfig.Docker.Registry == "" {
+					return fmt.Errorf("Cannot prompt for an image tag, no Docker registry configured.")
+				}
+				defaultValue := chart.Name
+				image, err = util.PromptForInput(defaultValue,
+					fmt.Sprintf("No tag specified for chart '%v'. Provide the name of an image in registry '%v' to select a tag for, "+
+						"or nothing to skip this step > ", ctx.AnkhConfig.Docker.Registry, chart.InstanceName()))
+				if err != nil {
+					return err
+				}
+			}
+
+			if image == "" {
+				ctx.Logger.Infof("Skipping tag prompt since no image name was provided")
+				continue
+			}
+
+			if ctx.AnkhConfig.Docker.Registry == "" {
+				return fmt.Errorf("Cannot prompt for an image tag, no Docker registry configured.")
+			}
+
+			// With `docker.promote.beforeApply`, tags are selected from the
+			// source registry, and promoted before they are applied.
+			promote := ctx.AnkhConfig.Docker.Promote.BeforeApply && (ctx.Mode == ankh.Apply || ctx.Mode == ankh.Deploy) && !ctx.PlanOnly
+			sourceRegistry, targetRegistry := registryDomain, registryDomain
+			if promote {
+				sourceRegistry, targetRegistry, err = docker.PromoteRegistries(ctx, "", registryDomain)
+				if err != nil {
+					return err
+				}
+			}
+
+			output, err := docker.ListTags(ctx, sourceRegistry, image, true)
+			if err != nil {
+				return err
+			}
+
+			trimmedOutput := strings.Trim(output, "\n ")
+			if trimmedOutput != "" {
+				tags := strings.Split(trimmedOutput, "\n")
+				tag, err := util.PromptForSelection(tags, fmt.Sprintf("Select a value for \"%v\"", tagKey), false)
+				if err != nil {
+					return err
+				}
+
+				if promote {
+					if err := docker.PromoteTag(ctx, sourceRegistry, targetRegistry, image, tag); err != nil {
+						return err
+					}
+				}
+
+				ctx.Logger.Infof("Using implicit \"--set tag %v=%s\" based on prompt selection", tagKey, tag)
+				chart.Tag = &tag
+			} else if image != "" {
+				complaint := fmt.Sprintf("Chart \"%v\" missing value for `tagKey` (configured to be `%v`). "+
+					"You may want to try passing a tag value explicitly using `ankh --set %v=... `, or simply ignore "+
+					"this error entirely using `ankh --ignore-config-errors ...` (not recommended)",
+					chart.InstanceName(), tagKey, tagKey)
+				if ctx.IgnoreConfigErrors {
+					ctx.Logger.Warnf("%v", complaint)
+				} else {
+					return fmt.Errorf("%v", complaint)
+				}
+			}
+		}
+
+		// we should finally have a tag value
+		if chart.Tag != nil {
+			ctx.DeploymentTag = *chart.Tag
+		}
+
+	}
+
+	return nil
+}
@@ -31,6 +31,20 @@ type Command struct {
 	command                        string
 	args                           []string
 	PipeStdin, PipeStdoutAndStderr PipeType
+
+	// Extra environment variables, in `KEY=value` form, added on top of
+	// the environment inherited from the current process.
+	Env []string
+
+	// When stdout and stderr are PIPE_TYPE_STD, they are written here
+	// instead, if set, eg: to prefix each line.
+	Output io.Writer
+
+	// An error from setting up the command, eg: opening a proxy tunnel, which
+	// Run returns instead of running anything.
+	Err error
+
+	stdout, stderr string
 }
 
 func NewCommand(command string) Command {
@@ -42,7 +56,22 @@ func (cmd *Command) Explain() string {
 }
 
 func (cmd *Command) Run(ctx *ankh.ExecutionContext, input *string) (string, error) {
+	span := ctx.Tracer.Start(cmd.command, map[string]string{"command": cmd.Explain()})
+	out, err := cmd.run(ctx, input)
+	span.End(err)
+	return out, err
+}
+
+func (cmd *Command) run(ctx *ankh.ExecutionContext, input *string) (string, error) {
+	cmd.stdout, cmd.stderr = "", ""
+	if cmd.Err != nil {
+		return "", cmd.Err
+	}
+
 	execCommand := exec.Command(cmd.command, cmd.args...)
+	if len(cmd.Env) > 0 {
+		execCommand.Env = append(os.Environ(), cmd.Env...)
+	}
 
 	// Set up pipes if necessary, or use stdin/out/err.
 	var stdoutPipe io.ReadCloser
@@ -65,6 +94,10 @@ func (cmd *Command) Run(ctx *ankh.ExecutionContext, input *string) (string, erro
 	case PIPE_TYPE_STD:
 		execCommand.Stdout = os.Stdout
 		execCommand.Stderr = os.Stderr
+		if cmd.Output != nil {
+			execCommand.Stdout = cmd.Output
+			execCommand.Stderr = cmd.Output
+		}
 	}
 
 	err := execCommand.Start()
@@ -95,6 +128,8 @@ func (cmd *Command) Run(ctx *ankh.ExecutionContext, input *string) (string, erro
 	}
 
 	wg.Wait()
+	cmd.stdout = string(stdout)
+	cmd.stderr = string(stderr)
 
 	// Catch signals while running the command, if our context demands it.
 	if ctx.ShouldCatchSignals {
@@ -122,17 +157,34 @@ func (cmd *Command) Run(ctx *ankh.ExecutionContext, input *string) (string, erro
 					"(this is benign when interrupting a watch via -w)\n", cmd.command)
 				return "", nil
 			}
+			if waitStatus == 256 && ctx.Mode == ankh.Diff {
+				// `kubectl diff` exits with status 1 when differences were found.
+				ctx.Logger.Debugf("Got exit code 1 from %v diff, which means differences were found", cmd.command)
+				return string(stdout), nil
+			}
 		}
 		outputMsg := ""
 		if len(stderr) > 0 {
 			outputMsg = fmt.Sprintf(" -- the %v process had the following output on stderr:\n%s", cmd.command, stderr)
 		}
-		return "", fmt.Errorf("error running the %v command: %v%v", cmd.command, err, outputMsg)
+		return "", fmt.Errorf("error running the %v command: %w%v", cmd.command, err, outputMsg)
 	}
 
 	return string(stdout), nil
 }
 
+// Stdout returns anything the last Run captured on stdout, even if the command
+// failed. Only populated when stdout is piped.
+func (cmd *Command) Stdout() string {
+	return cmd.stdout
+}
+
+// Stderr returns anything the last Run captured on stderr. Only populated
+// when stderr is piped.
+func (cmd *Command) Stderr() string {
+	return cmd.stderr
+}
+
 func (cmd *Command) AddArguments(args []string) {
 	cmd.args = append(cmd.args, args...)
 }
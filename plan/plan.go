@@ -1,7 +1,12 @@
 package plan
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/debug"
 )
 
 type PlanStage struct {
@@ -20,6 +25,23 @@ type StageOpts struct {
 	PreExecute func() bool
 	OnFailure func() bool
 	PassThroughInput bool
+	// What the stage does beyond what its name says, eg: that it prompts.
+	// Only used to describe plans, for `ankh plan`.
+	Description string
+}
+
+// Stages that run another stage, eg: kubectl's runner, may implement this to
+// be named after the stage they run.
+type NamedStage interface {
+	StageName() string
+}
+
+// StageName returns the name of a stage's type, eg: `kubectl.ApplyStage`.
+func StageName(stage Stage) string {
+	if named, ok := stage.(NamedStage); ok {
+		return named.StageName()
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", stage), "*")
 }
 
 func Execute(ctx *ankh.ExecutionContext, namespace string, wildCardLabels []string, plan *Plan) (string, error) {
@@ -36,7 +58,12 @@ func Execute(ctx *ankh.ExecutionContext, namespace string, wildCardLabels []stri
 			}
 		}
 
+		span := ctx.Tracer.Start("stage "+StageName(ps.Stage), map[string]string{"namespace": namespace})
+		stageInput := input
+		start := time.Now()
 		out, err := ps.Stage.Execute(ctx, &input, namespace, wildCardLabels)
+		span.End(err)
+		recordStage(ctx, ps.Stage, namespace, start, stageInput, out, err)
 		if err != nil {
 			if ps.Opts.OnFailure != nil {
 				ok := ps.Opts.OnFailure()
@@ -55,3 +82,25 @@ func Execute(ctx *ankh.ExecutionContext, namespace string, wildCardLabels []stri
 
 	return input, nil
 }
+
+// Saves a stage's input and output to the data dir, for `ankh debug last`.
+// Failing to do so is not worth failing the run over.
+func recordStage(ctx *ankh.ExecutionContext, stage Stage, namespace string, start time.Time, input string, output string, err error) {
+	if ctx.DataDir == "" {
+		return
+	}
+
+	record := debug.StageRecord{
+		Stage:           StageName(stage),
+		Context:         ctx.AnkhConfig.CurrentContextName,
+		Namespace:       namespace,
+		Start:           start,
+		DurationSeconds: time.Since(start).Seconds(),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if recordErr := debug.RecordStage(ctx.DataDir, record, input, output, ctx.SecretValues); recordErr != nil {
+		ctx.Logger.Debugf("Unable to record stage %v in %v: %v", record.Stage, ctx.DataDir, recordErr)
+	}
+}
@@ -28,3 +28,6 @@ function release() {
 release linux amd64
 
 release darwin amd64
+
+# Checksums are required by `ankh self-update`.
+(cd $release_dir && sha256sum ankh-*.tar.gz > sha256sums.txt && cat sha256sums.txt) || exit 1
@@ -0,0 +1,208 @@
+package replay
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+)
+
+const ManifestFileName = "run-manifest.yaml"
+
+// A dependency Ankh file, as resolved by a run.
+type Dependency struct {
+	// The dependency as listed under `dependencies` in the root Ankh file
+	Source   string        `yaml:"source"`
+	AnkhFile ankh.AnkhFile `yaml:"ankhFile"`
+}
+
+// A Manifest is written to the data dir of every apply, deploy and rollback,
+// with everything needed to repeat the run exactly: its target, and every
+// Ankh file as resolved by the run, ie: with chart versions, tags and
+// namespaces that may have come from prompts.
+type Manifest struct {
+	Command         string            `yaml:"command"`
+	Start           time.Time         `yaml:"start"`
+	AnkhFilePath    string            `yaml:"ankhFilePath,omitempty"`
+	AnkhFileBody    string            `yaml:"ankhFileBody,omitempty"` // as read from stdin, when AnkhFilePath is "-"
+	Environment     string            `yaml:"environment,omitempty"`
+	Context         string            `yaml:"context,omitempty"`
+	Release         string            `yaml:"release,omitempty"`
+	Namespace       *string           `yaml:"namespace,omitempty"`
+	ChartNamespaces map[string]string `yaml:"chartNamespaces,omitempty"`
+	DryRun          bool              `yaml:"dryRun,omitempty"`
+	SkipCrds        bool              `yaml:"skipCrds,omitempty"`
+	Wait            bool              `yaml:"wait,omitempty"`
+	WaitTimeout     string            `yaml:"waitTimeout,omitempty"`
+	ToRevision      int               `yaml:"toRevision,omitempty"`
+	Filters         []string          `yaml:"filters,omitempty"`
+	FilterName      string            `yaml:"filterName,omitempty"`
+	FilterLabels    string            `yaml:"filterLabels,omitempty"`
+	Set             map[string]string `yaml:"set,omitempty"`
+	SetString       map[string]string `yaml:"setString,omitempty"`
+	SetFile         map[string]string `yaml:"setFile,omitempty"`
+	AnkhFile        *ankh.AnkhFile    `yaml:"ankhFile,omitempty"`
+	Dependencies    []Dependency      `yaml:"dependencies,omitempty"`
+}
+
+// The ID of a run is the name of its data dir.
+func RunID(dataDir string) string {
+	return filepath.Base(dataDir)
+}
+
+func NewManifest(ctx *ankh.ExecutionContext) *Manifest {
+	manifest := &Manifest{
+		Command:         string(ctx.Mode),
+		Start:           time.Now(),
+		AnkhFilePath:    ctx.AnkhFilePath,
+		Environment:     ctx.Environment,
+		Context:         ctx.AnkhConfig.CurrentContextName,
+		Release:         ctx.Release,
+		Namespace:       ctx.Namespace,
+		ChartNamespaces: ctx.ChartNamespaces,
+		DryRun:          ctx.DryRun,
+		SkipCrds:        ctx.SkipCrds,
+		Wait:            ctx.Wait,
+		WaitTimeout:     ctx.WaitTimeout,
+		ToRevision:      ctx.RollbackRevision,
+		Filters:         ctx.Filters,
+		FilterName:      ctx.FilterName,
+		Set:             ctx.HelmSetValues,
+		SetString:       ctx.HelmSetStringValues,
+		SetFile:         ctx.HelmSetFiles,
+	}
+	if ctx.FilterLabels != nil {
+		manifest.FilterLabels = ctx.FilterLabels.String()
+	}
+	return manifest
+}
+
+// Local chart paths are made absolute, since a replay may run from anywhere.
+func resolvedAnkhFile(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) (ankh.AnkhFile, error) {
+	resolved := *ankhFile
+	resolved.Charts = []ankh.Chart{}
+	for _, chart := range ankhFile.Charts {
+		if chart.Path != "" && !filepath.IsAbs(chart.Path) {
+			path, err := filepath.Abs(filepath.Join(ctx.WorkingPath, chart.Path))
+			if err != nil {
+				return resolved, err
+			}
+			chart.Path = path
+		}
+		resolved.Charts = append(resolved.Charts, chart)
+	}
+	return resolved, nil
+}
+
+// Record adds an Ankh file, once it is resolved, to the manifest. The root Ankh
+// file has no dependency source. Each Ankh file is only recorded the first
+// time, since every context of an environment uses the same resolution.
+func (manifest *Manifest) Record(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile, dependency string) (bool, error) {
+	if dependency == "" && manifest.AnkhFile != nil {
+		return false, nil
+	}
+	for _, dep := range manifest.Dependencies {
+		if dependency != "" && dep.Source == dependency {
+			return false, nil
+		}
+	}
+
+	resolved, err := resolvedAnkhFile(ctx, ankhFile)
+	if err != nil {
+		return false, err
+	}
+	if dependency == "" {
+		manifest.AnkhFile = &resolved
+		if manifest.AnkhFilePath == ankh.StdinAnkhFilePath {
+			manifest.AnkhFileBody = string(ankh.StdinAnkhFileBody())
+		}
+	} else {
+		manifest.Dependencies = append(manifest.Dependencies, Dependency{Source: dependency, AnkhFile: resolved})
+	}
+	return true, nil
+}
+
+func Write(dataDir string, manifest *Manifest) error {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return err
+	}
+
+	out, err := yaml.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dataDir, ManifestFileName), out, 0644)
+}
+
+// Load reads the manifest of a run found under the base data dir.
+func Load(baseDataDir string, runID string) (*Manifest, error) {
+	if runID == "" || strings.ContainsAny(runID, `/\`) || runID == "." || runID == ".." {
+		return nil, fmt.Errorf("Invalid run ID \"%v\"", runID)
+	}
+
+	path := filepath.Join(baseDataDir, runID, ManifestFileName)
+	body, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("No recorded run \"%v\" found in %v. Only apply, deploy and rollback runs can be replayed", runID, baseDataDir)
+	} else if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{}
+	if err := yaml.Unmarshal(body, manifest); err != nil {
+		return nil, fmt.Errorf("Unable to parse %v: %v", path, err)
+	}
+	if manifest.AnkhFile == nil && len(manifest.Dependencies) == 0 {
+		return nil, fmt.Errorf("Run \"%v\" did not get as far as resolving its charts, so there is nothing to replay", runID)
+	}
+	return manifest, nil
+}
+
+// WriteAnkhFiles writes the recorded Ankh files to dir, and returns the path
+// of the root Ankh file. Its dependencies are replaced by the recorded ones,
+// so that nothing is resolved again.
+func (manifest *Manifest) WriteAnkhFiles(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	// An Ankh file with only dependencies has nothing of its own to record.
+	root := ankh.AnkhFile{}
+	if manifest.AnkhFile != nil {
+		root = *manifest.AnkhFile
+	}
+	// Includes were already resolved into the recorded charts.
+	root.Include = nil
+	root.Dependencies = []string{}
+	for i, dep := range manifest.Dependencies {
+		// Dependencies were recorded in the order they ran, which already
+		// satisfies their `dependsOn`.
+		dep.AnkhFile.DependsOn = nil
+		dep.AnkhFile.Include = nil
+		path := filepath.Join(dir, fmt.Sprintf("replay-dependency-%02d.yaml", i+1))
+		if err := writeAnkhFile(path, dep.AnkhFile); err != nil {
+			return "", err
+		}
+		root.Dependencies = append(root.Dependencies, path)
+	}
+
+	path := filepath.Join(dir, "replay-ankh.yaml")
+	if err := writeAnkhFile(path, root); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func writeAnkhFile(path string, ankhFile ankh.AnkhFile) error {
+	out, err := yaml.Marshal(ankhFile)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out, 0644)
+}
@@ -0,0 +1,130 @@
+package replay
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/appnexus/ankh/context"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	baseDataDir, err := ioutil.TempDir("", "ankh-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(baseDataDir)
+
+	ctx := &ankh.ExecutionContext{
+		Logger:        logrus.New(),
+		Mode:          ankh.Apply,
+		DataDir:       filepath.Join(baseDataDir, "1500000000-42"),
+		Environment:   "production",
+		HelmSetValues: map[string]string{"replicas": "3"},
+	}
+	manifest := NewManifest(ctx)
+
+	tag := "1.2.3"
+	namespace := "team"
+	dependency := ankh.AnkhFile{Charts: []ankh.Chart{ankh.Chart{Name: "db", Version: "0.1.0"}}}
+	root := ankh.AnkhFile{
+		Charts: []ankh.Chart{
+			ankh.Chart{Name: "api", Version: "2.0.0", Tag: &tag, ChartMeta: ankh.ChartMeta{Namespace: &namespace}},
+			ankh.Chart{Name: "local", Path: "charts/local"},
+		},
+		Dependencies: []string{"deps/db.yaml"},
+	}
+
+	if recorded, err := manifest.Record(ctx, &dependency, "deps/db.yaml"); err != nil || !recorded {
+		t.Fatalf("expected to record the dependency, got %v and error %v", recorded, err)
+	}
+	if recorded, err := manifest.Record(ctx, &root, ""); err != nil || !recorded {
+		t.Fatalf("expected to record the root Ankh file, got %v and error %v", recorded, err)
+	}
+	// Later contexts of the environment don't record again.
+	if recorded, _ := manifest.Record(ctx, &root, ""); recorded {
+		t.Logf("expected the root Ankh file to be recorded only once")
+		t.Fail()
+	}
+
+	if err := Write(ctx.DataDir, manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(baseDataDir, RunID(ctx.DataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Command != "apply" || loaded.Environment != "production" || loaded.Set["replicas"] != "3" {
+		t.Logf("got unexpected manifest %+v", loaded)
+		t.Fail()
+	}
+
+	replayDir := filepath.Join(baseDataDir, "1500000100-7")
+	ankhFilePath, err := loaded.WriteAnkhFiles(replayDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, err := ankh.ParseAnkhFile(ankhFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := replayed.Charts[0]
+	if api.Version != "2.0.0" || api.Tag == nil || *api.Tag != "1.2.3" || api.ChartMeta.Namespace == nil || *api.ChartMeta.Namespace != "team" {
+		t.Logf("expected the resolved version, tag and namespace but got %+v", api)
+		t.Fail()
+	}
+	if !filepath.IsAbs(replayed.Charts[1].Path) {
+		t.Logf("expected an absolute chart path but got %v", replayed.Charts[1].Path)
+		t.Fail()
+	}
+	if len(replayed.Dependencies) != 1 || filepath.Dir(replayed.Dependencies[0]) != replayDir {
+		t.Logf("expected the recorded dependency but got %v", replayed.Dependencies)
+		t.Fail()
+	}
+}
+
+func TestRecordStdinAnkhFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	body := "charts:\n  - name: api\n    version: 2.0.0\n"
+	file.WriteString(body)
+	file.Seek(0, 0)
+
+	stdin := os.Stdin
+	os.Stdin = file
+	defer func() { os.Stdin = stdin }()
+
+	ankhFile, err := ankh.ParseAnkhFile(ankh.StdinAnkhFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &ankh.ExecutionContext{Logger: logrus.New(), Mode: ankh.Apply, AnkhFilePath: ankh.StdinAnkhFilePath}
+	manifest := NewManifest(ctx)
+	if _, err := manifest.Record(ctx, &ankhFile, ""); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.AnkhFileBody != body {
+		t.Logf("expected the Ankh file read from stdin to be recorded but got %q", manifest.AnkhFileBody)
+		t.Fail()
+	}
+}
+
+func TestLoadInvalidRunID(t *testing.T) {
+	for _, runID := range []string{"", "..", "../etc"} {
+		if _, err := Load("/tmp", runID); err == nil {
+			t.Logf("expected an error for run ID '%v'", runID)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package rollout
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The state of the latest multi-context rollout is kept in the base data dir,
+// next to the data dirs of each run.
+const StateFileName = "rollout-state.yaml"
+
+type Status string
+
+const (
+	Pending   Status = "pending"
+	Started   Status = "started"
+	Succeeded Status = "succeeded"
+)
+
+type ContextState struct {
+	Name   string `yaml:"name"`
+	Status Status `yaml:"status"`
+}
+
+// State records how far a rollout over several contexts got, so that a failed
+// rollout can be resumed from the first context that did not succeed. A
+// rollout can only be resumed by the same command, in the same directory.
+type State struct {
+	Command  []string       `yaml:"command"`
+	Dir      string         `yaml:"dir"`
+	Start    time.Time      `yaml:"start"`
+	Contexts []ContextState `yaml:"contexts"`
+
+	path string
+}
+
+// New starts the state of a rollout over contexts, replacing that of any
+// earlier rollout.
+func New(baseDataDir string, command []string, dir string, contexts []string) *State {
+	state := &State{
+		Command: command,
+		Dir:     dir,
+		Start:   time.Now(),
+		path:    filepath.Join(baseDataDir, StateFileName),
+	}
+	for _, context := range contexts {
+		state.Contexts = append(state.Contexts, ContextState{Name: context, Status: Pending})
+	}
+	return state
+}
+
+// Load returns the state of an unfinished rollout by the same command, in the
+// same directory, if any.
+func Load(baseDataDir string, command []string, dir string) (*State, error) {
+	path := filepath.Join(baseDataDir, StateFileName)
+	body, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	state := &State{path: path}
+	if err := yaml.Unmarshal(body, state); err != nil {
+		return nil, fmt.Errorf("Unable to parse %v: %v", path, err)
+	}
+	if !reflect.DeepEqual(state.Command, command) || state.Dir != dir {
+		return nil, nil
+	}
+	return state, nil
+}
+
+// Succeeded reports whether the rollout already succeeded on a context.
+func (state *State) Succeeded(context string) bool {
+	for _, c := range state.Contexts {
+		if c.Name == context {
+			return c.Status == Succeeded
+		}
+	}
+	return false
+}
+
+// Set records the status of a context, adding the context if the rollout did
+// not know it, eg: when contexts were added to an environment since.
+func (state *State) Set(context string, status Status) error {
+	found := false
+	for i := range state.Contexts {
+		if state.Contexts[i].Name == context {
+			state.Contexts[i].Status = status
+			found = true
+		}
+	}
+	if !found {
+		state.Contexts = append(state.Contexts, ContextState{Name: context, Status: status})
+	}
+	return state.Write()
+}
+
+func (state *State) Write() error {
+	if err := os.MkdirAll(filepath.Dir(state.path), 0755); err != nil {
+		return err
+	}
+	out, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(state.path, out, 0644)
+}
+
+// Finish removes the state of a rollout that succeeded on every context.
+func (state *State) Finish() error {
+	err := os.Remove(state.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package rollout
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResumeRollout(t *testing.T) {
+	baseDataDir, err := ioutil.TempDir("", "ankh-rollout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(baseDataDir)
+
+	command := []string{"-e", "production", "apply", "--chart", "api"}
+	state := New(baseDataDir, command, "/src/api", []string{"nym", "ams", "lax"})
+	if err := state.Set("nym", Succeeded); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Set("ams", Started); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another command, or the same command elsewhere, can't resume it.
+	if other, err := Load(baseDataDir, []string{"-e", "staging", "apply", "--chart", "api"}, "/src/api"); err != nil || other != nil {
+		t.Logf("expected no rollout to resume for another command but got %+v and %v", other, err)
+		t.Fail()
+	}
+	if other, err := Load(baseDataDir, command, "/src/web"); err != nil || other != nil {
+		t.Logf("expected no rollout to resume in another directory but got %+v and %v", other, err)
+		t.Fail()
+	}
+
+	loaded, err := Load(baseDataDir, command, "/src/api")
+	if err != nil || loaded == nil {
+		t.Fatalf("expected the rollout to resume but got %v", err)
+	}
+	if !loaded.Succeeded("nym") || loaded.Succeeded("ams") || loaded.Succeeded("lax") {
+		t.Logf("expected only nym to have succeeded but got %+v", loaded.Contexts)
+		t.Fail()
+	}
+
+	if err := loaded.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(baseDataDir, StateFileName)); !os.IsNotExist(err) {
+		t.Logf("expected the state of a finished rollout to be removed")
+		t.Fail()
+	}
+	if loaded, err := Load(baseDataDir, command, "/src/api"); err != nil || loaded != nil {
+		t.Logf("expected nothing to resume after the rollout finished but got %+v and %v", loaded, err)
+		t.Fail()
+	}
+}
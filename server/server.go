@@ -0,0 +1,473 @@
+// Package server serves Ankh's operations over HTTP, for `ankh serve`, so that
+// CI systems and other tools can template, diff, apply and roll back charts
+// without running the command for every operation.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/ledger"
+	ankhlib "github.com/appnexus/ankh/pkg/ankh"
+)
+
+// Requests larger than this are refused, since they only name charts.
+const maxRequestBytes = 1 << 20
+
+// How long clients have to send a request. There is no write timeout, since
+// an apply may wait for its rollouts for much longer.
+const (
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = time.Minute
+)
+
+// What the names, versions and tags of charts in a request may be. They are
+// passed to helm, so they may not contain paths, or separate `--set` values.
+var (
+	chartNamePattern    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	chartVersionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.+-]*$`)
+	chartTagPattern     = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+)
+
+// Options configure a Server.
+type Options struct {
+	// Comma separated paths or URLs of Ankh configs, as with `--ankhconfig`.
+	// They are loaded for every request, so that changes are picked up.
+	ConfigPath     string
+	KubeConfigPath string
+	// The data dir whose ledger releases are recorded in, and listed from
+	BaseDataDir string
+	// Bearer tokens, by the name of who uses them, eg: `ci: s3cr3t`
+	Tokens map[string]string
+	// Where every request is recorded, one JSON object per line
+	AuditLog io.Writer
+	Logger   *logrus.Logger
+	// Refuse to apply or roll back, as with `--read-only`
+	ReadOnly           bool
+	IgnoreConfigErrors bool
+}
+
+// A Server handles the requests of the HTTP API.
+type Server struct {
+	opts Options
+	// Operations run one at a time, since each one may run helm and kubectl
+	// with the config it was given, and the ledger is only ever appended to.
+	operations sync.Mutex
+	audit      sync.Mutex
+}
+
+// A Request is the body of a request to template, diff, apply or roll back.
+type Request struct {
+	// The context to operate on. Required, since the server has no current context.
+	Context string `json:"context,omitempty"`
+	// Overrides the release of the context, as with `--release`
+	Release string `json:"release,omitempty"`
+	// Overrides the namespace of every chart, as with `--namespace`
+	Namespace string `json:"namespace,omitempty"`
+	// The charts to operate on, from the helm repository of the context
+	Charts []ChartReference `json:"charts"`
+	// Values passed to helm, as with `--set`
+	Set    map[string]string `json:"set,omitempty"`
+	DryRun bool              `json:"dryRun,omitempty"`
+	// Wait for every rollout of an apply to complete
+	Wait bool `json:"wait,omitempty"`
+	// The revision to roll back to. Defaults to the previous revision.
+	Revision int `json:"revision,omitempty"`
+}
+
+// A ChartReference names a version of a chart in the helm repository of the
+// context. Requests cannot give Ankh files, since their local paths, value
+// sources, secrets, partials and hooks would read files and run commands on
+// the server.
+type ChartReference struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// The value of the chart's `tagKey`, if it has one
+	Tag string `json:"tag,omitempty"`
+}
+
+// Returns an error if the request's charts are missing, or are not plain
+// names, versions and tags.
+func (req Request) validate() error {
+	if len(req.Charts) == 0 {
+		return fmt.Errorf("Missing `charts`")
+	}
+	for _, chart := range req.Charts {
+		if !chartNamePattern.MatchString(chart.Name) || strings.Contains(chart.Name, "..") {
+			return fmt.Errorf("Invalid chart name \"%v\"", chart.Name)
+		}
+		if !chartVersionPattern.MatchString(chart.Version) {
+			return fmt.Errorf("Invalid version \"%v\" of chart \"%v\"", chart.Version, chart.Name)
+		}
+		if chart.Tag != "" && !chartTagPattern.MatchString(chart.Tag) {
+			return fmt.Errorf("Invalid tag \"%v\" of chart \"%v\"", chart.Tag, chart.Name)
+		}
+	}
+	if req.Context == "" {
+		return fmt.Errorf("Missing `context`")
+	}
+	return nil
+}
+
+// Returns the Ankh file of the request's charts.
+func (req Request) ankhFile() ankh.AnkhFile {
+	ankhFile := ankh.AnkhFile{}
+	for _, reference := range req.Charts {
+		chart := ankh.Chart{Name: reference.Name, Version: reference.Version}
+		if reference.Tag != "" {
+			tag := reference.Tag
+			chart.Tag = &tag
+		}
+		ankhFile.Charts = append(ankhFile.Charts, chart)
+	}
+	return ankhFile
+}
+
+// A Response is the body of every response but the health check.
+type Response struct {
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// The releases that an apply or rollback recorded, or that were listed
+	Releases []ledger.Entry `json:"releases,omitempty"`
+}
+
+// An auditEntry records a request, and how it was answered.
+type auditEntry struct {
+	Time            time.Time `json:"time"`
+	User            string    `json:"user,omitempty"`
+	RemoteAddr      string    `json:"remoteAddr"`
+	Method          string    `json:"method"`
+	Path            string    `json:"path"`
+	Context         string    `json:"context,omitempty"`
+	Namespace       string    `json:"namespace,omitempty"`
+	Charts          []string  `json:"charts,omitempty"`
+	DryRun          bool      `json:"dryRun,omitempty"`
+	Status          int       `json:"status"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	Error           string    `json:"error,omitempty"`
+}
+
+func New(opts Options) *Server {
+	if opts.AuditLog == nil {
+		opts.AuditLog = ioutil.Discard
+	}
+	if opts.Logger == nil {
+		opts.Logger = logrus.New()
+		opts.Logger.Out = ioutil.Discard
+	}
+	return &Server{opts: opts}
+}
+
+// LoadTokens reads bearer tokens from a YAML file that maps the name of who
+// uses each token to the token, eg: `ci: s3cr3t`.
+func LoadTokens(path string) (map[string]string, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tokens := map[string]string{}
+	if err := yaml.Unmarshal(body, &tokens); err != nil {
+		return nil, fmt.Errorf("Unable to parse tokens file \"%v\": %v", path, err)
+	}
+	for name, token := range tokens {
+		if token == "" {
+			return nil, fmt.Errorf("Empty token for \"%v\" in tokens file \"%v\"", name, path)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("No tokens in tokens file \"%v\". Map the name of each user to a token, eg: `ci: <token>`", path)
+	}
+	return tokens, nil
+}
+
+// Handler returns the handler of the API: `GET /healthz`, `GET /v1/releases`,
+// and `POST` to `/v1/template`, `/v1/diff`, `/v1/apply` and `/v1/rollback`.
+func (server *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/v1/releases", server.handle(http.MethodGet, server.releases))
+	for _, mode := range []ankh.Mode{ankh.Template, ankh.Diff, ankh.Apply, ankh.Rollback} {
+		mux.HandleFunc("/v1/"+string(mode), server.handle(http.MethodPost, server.operation(mode)))
+	}
+	return mux
+}
+
+// ListenAndServe serves the API on an address until a signal is received on
+// stop, and then waits for the requests in flight to finish.
+func (server *Server) ListenAndServe(addr string, stop <-chan os.Signal) error {
+	return server.serve(addr, stop, func(httpServer *http.Server) error {
+		return httpServer.ListenAndServe()
+	})
+}
+
+// ListenAndServeTLS is ListenAndServe over HTTPS, with a certificate and key
+// from PEM files.
+func (server *Server) ListenAndServeTLS(addr string, certFile string, keyFile string, stop <-chan os.Signal) error {
+	return server.serve(addr, stop, func(httpServer *http.Server) error {
+		return httpServer.ListenAndServeTLS(certFile, keyFile)
+	})
+}
+
+func (server *Server) serve(addr string, stop <-chan os.Signal, listen func(*http.Server) error) error {
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           server.Handler(),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+	}
+	done := make(chan struct{})
+	go func() {
+		<-stop
+		server.opts.Logger.Infof("Shutting down, once the requests in flight finish")
+		httpServer.Shutdown(context.Background())
+		close(done)
+	}()
+	if err := listen(httpServer); err != http.ErrServerClosed {
+		return err
+	}
+	<-done
+	return nil
+}
+
+// IsLoopback returns whether an address to listen on only accepts connections
+// from the same host, eg: `127.0.0.1:8080` or `localhost:8080`.
+func IsLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Who a request is from, by its bearer token, if it has a known one.
+func (server *Server) authenticate(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	token := []byte(strings.TrimPrefix(header, "Bearer "))
+	for user, expected := range server.opts.Tokens {
+		if subtle.ConstantTimeCompare(token, []byte(expected)) == 1 {
+			return user, true
+		}
+	}
+	return "", false
+}
+
+type handlerFunc func(user string, r *http.Request, entry *auditEntry) (Response, int)
+
+// Authenticates, checks the method of, and audits every request.
+func (server *Server) handle(method string, f handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &auditEntry{Time: start.UTC(), RemoteAddr: r.RemoteAddr, Method: r.Method, Path: r.URL.Path}
+
+		response, status := Response{}, http.StatusOK
+		if user, ok := server.authenticate(r); !ok {
+			response, status = Response{Error: "Missing or unknown bearer token"}, http.StatusUnauthorized
+		} else if r.Method != method {
+			entry.User = user
+			response, status = Response{Error: fmt.Sprintf("Expected %v", method)}, http.StatusMethodNotAllowed
+		} else {
+			entry.User = user
+			response, status = f(user, r, entry)
+		}
+
+		entry.Status = status
+		entry.Error = response.Error
+		entry.DurationSeconds = time.Since(start).Seconds()
+		server.record(entry)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func (server *Server) record(entry *auditEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		server.opts.Logger.Warnf("Unable to record a request in the audit log: %v", err)
+		return
+	}
+	server.audit.Lock()
+	defer server.audit.Unlock()
+	if _, err := fmt.Fprintf(server.opts.AuditLog, "%s\n", line); err != nil {
+		server.opts.Logger.Warnf("Unable to record a request in the audit log: %v", err)
+	}
+}
+
+// Lists releases from the ledger, newest first, optionally only those of a
+// `chart` or in a `context`.
+func (server *Server) releases(user string, r *http.Request, entry *auditEntry) (Response, int) {
+	entries, err := ledger.Load(server.opts.BaseDataDir)
+	if err != nil {
+		return Response{Error: err.Error()}, http.StatusInternalServerError
+	}
+	if chart := r.URL.Query().Get("chart"); chart != "" {
+		entries = ledger.ForChart(entries, chart)
+	}
+	if contextName := r.URL.Query().Get("context"); contextName != "" {
+		entry.Context = contextName
+		inContext := []ledger.Entry{}
+		for _, e := range entries {
+			if e.Context == contextName {
+				inContext = append(inContext, e)
+			}
+		}
+		entries = inContext
+	}
+	return Response{Releases: entries}, http.StatusOK
+}
+
+// Returns the handler of an operation, which runs it for the charts of the
+// request in each of their namespaces.
+func (server *Server) operation(mode ankh.Mode) handlerFunc {
+	return func(user string, r *http.Request, entry *auditEntry) (Response, int) {
+		req := Request{}
+		decoder := json.NewDecoder(io.LimitReader(r.Body, maxRequestBytes))
+		// Refuse fields the API does not have, eg: the `ankhFile` of older clients.
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			return Response{Error: fmt.Sprintf("Unable to parse the request: %v", err)}, http.StatusBadRequest
+		}
+		entry.Context, entry.Namespace, entry.DryRun = req.Context, req.Namespace, req.DryRun
+		if err := req.validate(); err != nil {
+			return Response{Error: err.Error()}, http.StatusBadRequest
+		}
+
+		server.operations.Lock()
+		defer server.operations.Unlock()
+		return server.operate(mode, user, req, entry)
+	}
+}
+
+func (server *Server) operate(mode ankh.Mode, user string, req Request, entry *auditEntry) (Response, int) {
+	client, err := ankhlib.New(ankhlib.Options{
+		ConfigPath:         server.opts.ConfigPath,
+		KubeConfigPath:     server.opts.KubeConfigPath,
+		Context:            req.Context,
+		Release:            req.Release,
+		Logger:             server.opts.Logger,
+		IgnoreConfigErrors: server.opts.IgnoreConfigErrors,
+		ReadOnly:           server.opts.ReadOnly,
+	})
+	if err != nil {
+		return Response{Error: err.Error()}, http.StatusBadRequest
+	}
+	defer client.Close()
+
+	ctx := client.Context()
+	entry.Context = ctx.AnkhConfig.CurrentContextName
+	ctx.Deployer = user
+	ctx.HelmSetValues = req.Set
+	if req.Namespace != "" {
+		ctx.Namespace = &req.Namespace
+	}
+
+	charts, err := client.ResolveCharts(req.ankhFile(), mode)
+	if err != nil {
+		return Response{Error: err.Error()}, http.StatusBadRequest
+	}
+	for _, chart := range charts {
+		entry.Charts = append(entry.Charts, chart.InstanceName())
+	}
+
+	// Charts are operated on by namespace, as the command does.
+	byNamespace := map[string][]ankh.Chart{}
+	for _, chart := range charts {
+		namespace := req.Namespace
+		if namespace == "" {
+			namespace = *chart.ChartMeta.Namespace
+		}
+		byNamespace[namespace] = append(byNamespace[namespace], chart)
+	}
+	namespaces := []string{}
+	for namespace := range byNamespace {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	response := Response{}
+	for _, namespace := range namespaces {
+		server.opts.Logger.Infof("%v of %v in context \"%v\" and namespace \"%v\", requested by %v",
+			mode, strings.Join(entry.Charts, ", "), entry.Context, namespace, user)
+
+		var out string
+		switch mode {
+		case ankh.Template:
+			out, err = client.Template(byNamespace[namespace], namespace)
+		case ankh.Diff:
+			out, err = client.Diff(byNamespace[namespace], namespace)
+		case ankh.Apply:
+			out, err = client.Apply(byNamespace[namespace], namespace, ankhlib.ApplyOptions{DryRun: req.DryRun, Wait: req.Wait})
+		case ankh.Rollback:
+			out, err = client.Rollback(byNamespace[namespace], namespace, ankhlib.RollbackOptions{DryRun: req.DryRun, Revision: req.Revision})
+		}
+		response.Output += out
+		if err != nil {
+			response.Error = err.Error()
+			return response, http.StatusInternalServerError
+		}
+
+		if (mode == ankh.Apply || mode == ankh.Rollback) && !req.DryRun {
+			entries := releaseEntries(ctx, mode, byNamespace[namespace], namespace, req.Revision)
+			if err := ledger.Append(server.opts.BaseDataDir, entries); err != nil {
+				server.opts.Logger.Warnf("Unable to record releases in the ledger: %v", err)
+			}
+			response.Releases = append(response.Releases, entries...)
+		}
+	}
+	return response, http.StatusOK
+}
+
+// Returns the ledger entries of charts that were just applied or rolled back.
+func releaseEntries(ctx *ankh.ExecutionContext, mode ankh.Mode, charts []ankh.Chart, namespace string, revision int) []ledger.Entry {
+	entries := []ledger.Entry{}
+	for _, chart := range charts {
+		entry := ledger.Entry{
+			ID:        ledger.NewID(),
+			Time:      time.Now().UTC(),
+			User:      ctx.Deployer,
+			Action:    string(mode),
+			Context:   ctx.AnkhConfig.CurrentContextName,
+			Namespace: namespace,
+			Chart:     chart.Name,
+			Alias:     chart.Alias,
+			Release:   ctx.AnkhConfig.CurrentContext.Release,
+		}
+		if mode == ankh.Rollback {
+			entry.Revision = revision
+		} else {
+			entry.Version = chart.Version
+			entry.Path = chart.Path
+			if chart.Tag != nil {
+				entry.Tag = *chart.Tag
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appnexus/ankh/ankhtest"
+)
+
+func TestServer(t *testing.T) {
+	repository := ankhtest.NewChartRepository(t, ankhtest.Chart{Name: "foo", Version: "1.0.0",
+		Files: map[string]string{"ankh.yaml": "namespace: web\n"}})
+	tools := ankhtest.NewTools(t)
+	tools.Fake("helm", ankhtest.Rule{Args: "template *", Stdout: "---\n# Source: foo/templates/deployment.yaml\nkind: Deployment\nmetadata:\n  name: foo\n"})
+	tools.Fake("kubectl", ankhtest.Rule{Stdout: "deployment.apps/foo configured\n"})
+
+	dir, err := ioutil.TempDir("", "ankh-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "ankhconfig.yaml")
+	config := `
+helm:
+  repository: ` + repository.URL + `
+contexts:
+  test:
+    kube-context: test
+    environment-class: test
+    resource-profile: test
+`
+	if err := ioutil.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	audit := &bytes.Buffer{}
+	srv := httptest.NewServer(New(Options{
+		ConfigPath:  configPath,
+		BaseDataDir: dir,
+		Tokens:      map[string]string{"ci": "s3cr3t"},
+		AuditLog:    audit,
+	}).Handler())
+	defer srv.Close()
+
+	call := func(method string, path string, token string, body interface{}) (int, Response) {
+		encoded, _ := json.Marshal(body)
+		req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		response := Response{}
+		json.NewDecoder(resp.Body).Decode(&response)
+		return resp.StatusCode, response
+	}
+
+	request := Request{Context: "test", Charts: []ChartReference{{Name: "foo", Version: "1.0.0"}}}
+	if status, _ := call("POST", "/v1/template", "wrong", request); status != http.StatusUnauthorized {
+		t.Logf("expected an unknown token to be refused but got %v", status)
+		t.Fail()
+	}
+	if status, _ := call("GET", "/v1/apply", "s3cr3t", nil); status != http.StatusMethodNotAllowed {
+		t.Logf("expected only POST to apply but got %v", status)
+		t.Fail()
+	}
+	if status, response := call("POST", "/v1/template", "s3cr3t", Request{Context: "test"}); status != http.StatusBadRequest || !strings.Contains(response.Error, "charts") {
+		t.Logf("expected a request without charts to be refused but got %v: %+v", status, response)
+		t.Fail()
+	}
+	if status, response := call("POST", "/v1/template", "s3cr3t", Request{Charts: request.Charts}); status != http.StatusBadRequest || !strings.Contains(response.Error, "context") {
+		t.Logf("expected a request without a context to be refused but got %v: %+v", status, response)
+		t.Fail()
+	}
+
+	status, response := call("POST", "/v1/template", "s3cr3t", request)
+	if status != http.StatusOK || !strings.Contains(response.Output, "kind: Deployment") {
+		t.Logf("expected the templated chart but got %v: %+v", status, response)
+		t.Fail()
+	}
+
+	status, response = call("POST", "/v1/apply", "s3cr3t", request)
+	if status != http.StatusOK || len(response.Releases) != 1 || response.Releases[0].User != "ci" || response.Releases[0].Namespace != "web" {
+		t.Fatalf("expected the apply to be recorded as a release by ci but got %v: %+v", status, response)
+	}
+	status, response = call("GET", "/v1/releases?context=test", "s3cr3t", nil)
+	if status != http.StatusOK || len(response.Releases) != 1 || response.Releases[0].Version != "1.0.0" {
+		t.Logf("expected the release of the apply to be listed but got %v: %+v", status, response)
+		t.Fail()
+	}
+
+	lines := strings.Split(strings.TrimSpace(audit.String()), "\n")
+	if len(lines) != 7 {
+		t.Fatalf("expected every request to be audited but got %v", lines)
+	}
+	entry := auditEntry{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil || entry.Status != http.StatusUnauthorized || entry.User != "" {
+		t.Logf("expected the refused request to be audited without a user but got %+v and %v", entry, err)
+		t.Fail()
+	}
+	if err := json.Unmarshal([]byte(lines[5]), &entry); err != nil || entry.User != "ci" || entry.Path != "/v1/apply" ||
+		entry.Context != "test" || strings.Join(entry.Charts, ",") != "foo" {
+		t.Logf("expected the apply to be audited with its user, context and charts but got %+v and %v", entry, err)
+		t.Fail()
+	}
+}
+
+func TestServerOnlyAcceptsChartReferences(t *testing.T) {
+	tools := ankhtest.NewTools(t)
+	tools.Fake("helm", ankhtest.Rule{})
+	tools.Fake("kubectl", ankhtest.Rule{})
+
+	dir, err := ioutil.TempDir("", "ankh-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	handler := New(Options{BaseDataDir: dir, Tokens: map[string]string{"ci": "s3cr3t"}}).Handler()
+
+	marker := filepath.Join(dir, "ran")
+	bodies := map[string]string{
+		"exec value source": `{"ankhFile": "charts:\n- name: foo\n  version: 1.0.0\n  valueSources:\n  - exec: [touch, ` + marker + `]\n"}`,
+		"hook":              `{"ankhFile": "charts:\n- name: foo\n  version: 1.0.0\n  meta:\n    hooks:\n      preApply: touch ` + marker + `\n"}`,
+		"secrets":           `{"ankhFile": "charts:\n- name: foo\n  version: 1.0.0\n  secrets:\n  - path: /etc/passwd\n"}`,
+		"local path":        `{"charts": [{"name": "/etc", "version": "1.0.0"}]}`,
+		"relative path":     `{"charts": [{"name": "..", "version": "1.0.0"}]}`,
+		"partials":          `{"charts": [{"name": "foo", "version": "1.0.0", "partials": ["/etc/passwd"]}]}`,
+		"version":           `{"charts": [{"name": "foo", "version": "1.0.0 --post-renderer=sh"}]}`,
+		"extra set values":  `{"charts": [{"name": "foo", "version": "1.0.0", "tag": "1,image.repository=evil"}]}`,
+	}
+	for name, body := range bodies {
+		req := httptest.NewRequest("POST", "/v1/template", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cr3t")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusBadRequest {
+			t.Logf("expected a request with a %v to be refused but got %v: %v", name, recorder.Code, recorder.Body.String())
+			t.Fail()
+		}
+	}
+
+	if _, err := os.Stat(marker); err == nil {
+		t.Logf("expected nothing to be run for a refused request")
+		t.Fail()
+	}
+	if calls := tools.Calls("helm"); len(calls) != 0 {
+		t.Logf("expected helm not to be run for a refused request but got %+v", calls)
+		t.Fail()
+	}
+}
+
+func TestLoadTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tokens.yaml")
+	ioutil.WriteFile(path, []byte("ci: s3cr3t\nui: t0k3n\n"), 0600)
+	if tokens, err := LoadTokens(path); err != nil || tokens["ci"] != "s3cr3t" || len(tokens) != 2 {
+		t.Logf("expected a token for each user but got %v and %v", tokens, err)
+		t.Fail()
+	}
+
+	ioutil.WriteFile(path, []byte("ci: \"\"\n"), 0600)
+	if _, err := LoadTokens(path); err == nil {
+		t.Logf("expected an empty token to be an error")
+		t.Fail()
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	for addr, expected := range map[string]bool{
+		"127.0.0.1:8080": true,
+		"localhost:8080": true,
+		"[::1]:8080":     true,
+		":8080":          false,
+		"0.0.0.0:8080":   false,
+		"10.0.0.1:8080":  false,
+		"8080":           false,
+	} {
+		if IsLoopback(addr) != expected {
+			t.Logf("expected IsLoopback(%q) to be %v", addr, expected)
+			t.Fail()
+		}
+	}
+}
@@ -2,7 +2,6 @@ package slack
 
 import (
 	"fmt"
-	"os/user"
 	"strings"
 
 	ankh "github.com/appnexus/ankh/context"
@@ -46,6 +45,33 @@ func PingSlackChannel(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) error
 		Text:    messageText,
 	}
 
+	if !ctx.DryRun {
+		return postAttachment(ctx, api, ctx.SlackChannel, attachment)
+	} else {
+		ctx.Logger.Infof("--dry-run set so not sending message '%v' to slack channel %v", messageText, ctx.SlackChannel)
+	}
+
+	return nil
+}
+
+// PostMessage sends `text` to `channel`, eg: to ask for approval before a
+// pipeline stage runs.
+func PostMessage(ctx *ankh.ExecutionContext, channel string, pretext string, text string) error {
+	api := slack.New(ctx.AnkhConfig.Slack.Token)
+	attachment := slack.Attachment{
+		Color:   "warning",
+		Pretext: pretext,
+		Text:    text,
+	}
+
+	if ctx.DryRun {
+		ctx.Logger.Infof("--dry-run set so not sending message '%v' to slack channel %v", text, channel)
+		return nil
+	}
+	return postAttachment(ctx, api, channel, attachment)
+}
+
+func postAttachment(ctx *ankh.ExecutionContext, api *slack.Client, channel string, attachment slack.Attachment) error {
 	icon := DEFAULT_ICON_URL
 	if ctx.AnkhConfig.Slack.Icon != "" {
 		icon = ctx.AnkhConfig.Slack.Icon
@@ -61,19 +87,13 @@ func PingSlackChannel(ctx *ankh.ExecutionContext, ankhFile *ankh.AnkhFile) error
 		Username: username,
 	}
 
-	if !ctx.DryRun {
-		channelId, err := getSlackChannelIDByName(api, ctx.SlackChannel)
-		if err != nil {
-			return err
-		}
-
-		_, _, err = api.PostMessage(channelId, slack.MsgOptionAttachments(attachment), slack.MsgOptionPostMessageParameters(messageParams))
+	channelId, err := getSlackChannelIDByName(api, channel)
+	if err != nil {
 		return err
-	} else {
-		ctx.Logger.Infof("--dry-run set so not sending message '%v' to slack channel %v", messageText, ctx.SlackChannel)
 	}
 
-	return nil
+	_, _, err = api.PostMessage(channelId, slack.MsgOptionAttachments(attachment), slack.MsgOptionPostMessageParameters(messageParams))
+	return err
 }
 
 func getSlackChannelIDByName(api *slack.Client, channelName string) (string, error) {
@@ -125,7 +145,7 @@ func getMessageText(ctx *ankh.ExecutionContext, chart *ankh.Chart, envOrContext
 	}
 
 	if format != "" {
-		message, err := util.NotificationString(format, chart, envOrContext)
+		message, err := util.NotificationString(format, chart, envOrContext, ctx.EffectiveRelease(), ctx.EffectiveDeployer())
 		if err != nil {
 			ctx.Logger.Infof("Unable to use format: '%v'. Will prompt for message", format)
 		} else {
@@ -143,19 +163,15 @@ func getMessageText(ctx *ankh.ExecutionContext, chart *ankh.Chart, envOrContext
 }
 
 func promptForMessageText(ctx *ankh.ExecutionContext, chart *ankh.Chart, envOrContext string) (string, error) {
-	currentUser, err := user.Current()
-	if err != nil {
-		return "", err
-	}
-
-	version := ""
-	if chart.Tag != nil {
-		version = *chart.Tag
-	}
-
-	defaultMessage := fmt.Sprintf("%s is releasing %s chart %s version %s to *%s*", currentUser.Username, chart.Name, chart.Version, version, envOrContext)
+	deployer := ctx.EffectiveDeployer()
+	target := util.TargetWithRelease(envOrContext, ctx.EffectiveRelease())
+	change := releaseChange(chart, ctx.PreviousReleases[chart.InstanceName()])
+	defaultMessage := fmt.Sprintf("%s is releasing %s to *%s*", deployer, change, target)
 	if ctx.Mode == ankh.Rollback {
-		defaultMessage = fmt.Sprintf("%s is rolling back %s in *%v*", currentUser, chart.Name, envOrContext)
+		defaultMessage = fmt.Sprintf("%s is rolling back %s in *%v*", deployer, chart.Name, target)
+	}
+	if chart.CatalogEntry != nil && chart.CatalogEntry.Owner != "" {
+		defaultMessage += fmt.Sprintf(" (owned by %s)", chart.CatalogEntry.Owner)
 	}
 
 	message, err := util.PromptForInput(defaultMessage, "Slack Message")
@@ -165,3 +181,26 @@ func promptForMessageText(ctx *ankh.ExecutionContext, chart *ankh.Chart, envOrCo
 
 	return message, nil
 }
+
+// Describes what a release changes, eg: `api: 1.4.2→1.4.3 (tag 20240105→20240112)`.
+// Versions and tags that are unchanged, or were not running before, are shown once.
+func releaseChange(chart *ankh.Chart, previous ankh.PreviousRelease) string {
+	version := chart.Version
+	if chart.Path != "" {
+		version = fmt.Sprintf("%s (local)", chart.Path)
+	}
+	change := fmt.Sprintf("%s: %s", chart.InstanceName(), withPrevious(previous.ChartVersion, version))
+	if chart.Tag != nil && chart.ImageDigest != "" {
+		change += fmt.Sprintf(" (tag %s, digest %s)", withPrevious(previous.Tag, *chart.Tag), chart.ImageDigest)
+	} else if chart.Tag != nil {
+		change += fmt.Sprintf(" (tag %s)", withPrevious(previous.Tag, *chart.Tag))
+	}
+	return change
+}
+
+func withPrevious(previous string, current string) string {
+	if previous == "" || previous == current {
+		return current
+	}
+	return fmt.Sprintf("%s→%s", previous, current)
+}
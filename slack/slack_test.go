@@ -0,0 +1,32 @@
+package slack
+
+import (
+	"testing"
+
+	ankh "github.com/appnexus/ankh/context"
+)
+
+func TestReleaseChange(t *testing.T) {
+	tag := "20240112"
+	chart := &ankh.Chart{Name: "api", Version: "1.4.3", Tag: &tag}
+
+	for _, test := range []struct {
+		previous ankh.PreviousRelease
+		expected string
+	}{
+		{ankh.PreviousRelease{ChartVersion: "1.4.2", Tag: "20240105"}, "api: 1.4.2→1.4.3 (tag 20240105→20240112)"},
+		{ankh.PreviousRelease{ChartVersion: "1.4.3", Tag: "20240105"}, "api: 1.4.3 (tag 20240105→20240112)"},
+		{ankh.PreviousRelease{}, "api: 1.4.3 (tag 20240112)"},
+	} {
+		if change := releaseChange(chart, test.previous); change != test.expected {
+			t.Logf("expected %q but got %q", test.expected, change)
+			t.Fail()
+		}
+	}
+
+	chart = &ankh.Chart{Name: "redis", Alias: "cache", Version: "2.0.0"}
+	if change := releaseChange(chart, ankh.PreviousRelease{ChartVersion: "1.0.0"}); change != "cache: 1.0.0→2.0.0" {
+		t.Logf("expected the alias without a tag but got %q", change)
+		t.Fail()
+	}
+}
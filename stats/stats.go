@@ -0,0 +1,183 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	RunSummaryFileName = "run-summary.yaml"
+
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// A RunSummary is written to the data dir of every run, so that `ankh stats`
+// can report on local history without any external telemetry.
+type RunSummary struct {
+	Command         string    `yaml:"command"`
+	Start           time.Time `yaml:"start"`
+	DurationSeconds float64   `yaml:"durationSeconds"`
+	Charts          []string  `yaml:"charts,omitempty"`
+	Environment     string    `yaml:"environment,omitempty"`
+	Contexts        []string  `yaml:"contexts,omitempty"`
+	Release         string    `yaml:"release,omitempty"`
+	Deployer        string    `yaml:"deployer,omitempty"`
+	DryRun          bool      `yaml:"dryRun,omitempty"`
+	Result          string    `yaml:"result"`
+	Error           string    `yaml:"error,omitempty"`
+}
+
+func WriteRunSummary(dataDir string, summary RunSummary) error {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return err
+	}
+
+	out, err := yaml.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dataDir, RunSummaryFileName), out, 0644)
+}
+
+// LoadRunSummaries reads the summary of every run found under the base data dir,
+// oldest first. Runs without a readable summary are skipped.
+func LoadRunSummaries(baseDataDir string) ([]RunSummary, error) {
+	paths, err := filepath.Glob(filepath.Join(baseDataDir, "*", RunSummaryFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := []RunSummary{}
+	for _, path := range paths {
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		summary := RunSummary{}
+		if err := yaml.Unmarshal(body, &summary); err != nil {
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].Start.Before(summaries[j].Start)
+	})
+	return summaries, nil
+}
+
+type counts struct {
+	runs, failures int
+	totalSeconds   float64
+}
+
+func (c *counts) add(summary RunSummary) {
+	c.runs++
+	if summary.Result != ResultSuccess {
+		c.failures++
+	}
+	c.totalSeconds += summary.DurationSeconds
+}
+
+func (c *counts) failureRate() string {
+	return fmt.Sprintf("%.0f%%", 100*float64(c.failures)/float64(c.runs))
+}
+
+func (c *counts) averageDuration() string {
+	seconds := c.totalSeconds / float64(c.runs)
+	return (time.Duration(seconds * float64(time.Second))).Round(100 * time.Millisecond).String()
+}
+
+func isDeploy(summary RunSummary) bool {
+	if summary.DryRun {
+		return false
+	}
+	switch summary.Command {
+	case "apply", "deploy", "rollback":
+		return true
+	}
+	return false
+}
+
+// Where a run was targeted, for grouping. Environments are preferred over contexts.
+func target(summary RunSummary) string {
+	target := summary.Environment
+	if target == "" {
+		target = strings.Join(summary.Contexts, ",")
+	}
+	// Releases sharing a target, eg: blue and green, are reported separately.
+	if summary.Release != "" {
+		target += fmt.Sprintf(" (release %v)", summary.Release)
+	}
+	return target
+}
+
+func sortedKeys(m map[string]*counts) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Report aggregates run summaries into a deploys per chart and environment
+// table, followed by a per command table.
+func Report(summaries []RunSummary) string {
+	if len(summaries) == 0 {
+		return "No runs recorded yet\n"
+	}
+
+	byCommand := map[string]*counts{}
+	byChartAndTarget := map[string]*counts{}
+	for _, summary := range summaries {
+		if byCommand[summary.Command] == nil {
+			byCommand[summary.Command] = &counts{}
+		}
+		byCommand[summary.Command].add(summary)
+
+		if !isDeploy(summary) {
+			continue
+		}
+		for _, chart := range summary.Charts {
+			key := fmt.Sprintf("%v\t%v\t%v", chart, target(summary), summary.Command)
+			if byChartAndTarget[key] == nil {
+				byChartAndTarget[key] = &counts{}
+			}
+			byChartAndTarget[key].add(summary)
+		}
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(w, "CHART\tENVIRONMENT\tCOMMAND\tRUNS\tFAILURE RATE\tAVG DURATION\n")
+	for _, key := range sortedKeys(byChartAndTarget) {
+		c := byChartAndTarget[key]
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", key, c.runs, c.failureRate(), c.averageDuration())
+	}
+	fmt.Fprintf(w, "\n")
+
+	fmt.Fprintf(w, "COMMAND\tRUNS\tFAILURE RATE\tAVG DURATION\n")
+	for _, command := range sortedKeys(byCommand) {
+		c := byCommand[command]
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", command, c.runs, c.failureRate(), c.averageDuration())
+	}
+	w.Flush()
+
+	first, last := summaries[0].Start, summaries[len(summaries)-1].Start
+	fmt.Fprintf(&buf, "\n%v runs between %v and %v\n", len(summaries),
+		first.Format("2006-01-02"), last.Format("2006-01-02"))
+	return buf.String()
+}
@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunSummaries(t *testing.T) {
+	baseDataDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(baseDataDir)
+
+	start := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	runs := []RunSummary{
+		RunSummary{Command: "apply", Start: start, DurationSeconds: 10, Charts: []string{"foo"}, Environment: "prod", Result: ResultSuccess},
+		RunSummary{Command: "apply", Start: start.Add(time.Hour), DurationSeconds: 20, Charts: []string{"foo"}, Environment: "prod", Result: ResultFailure, Error: "boom"},
+		RunSummary{Command: "apply", Start: start.Add(2 * time.Hour), DurationSeconds: 1, Charts: []string{"foo"}, Environment: "prod", DryRun: true, Result: ResultSuccess},
+		RunSummary{Command: "get", Start: start.Add(3 * time.Hour), DurationSeconds: 2, Contexts: []string{"dev"}, Result: ResultSuccess},
+	}
+	for i, run := range runs {
+		if err := WriteRunSummary(filepath.Join(baseDataDir, fmt.Sprintf("run-%v", i)), run); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+	}
+
+	summaries, err := LoadRunSummaries(baseDataDir)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if len(summaries) != len(runs) {
+		t.Logf("expected %v summaries but got %v", len(runs), len(summaries))
+		t.FailNow()
+	}
+
+	report := Report(summaries)
+	t.Log(report)
+
+	// Dry runs are not deploys, so only two of the three applies count per chart.
+	for _, expected := range []string{
+		"foo    prod         apply    2     50%           15s",
+		"apply    3     33%",
+		"4 runs between 2019-01-02 and 2019-01-02",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Logf("expected report to contain '%v'", expected)
+			t.Fail()
+		}
+	}
+}
+
+func TestTarget(t *testing.T) {
+	if target := target(RunSummary{Environment: "prod", Release: "blue"}); target != "prod (release blue)" {
+		t.Logf("got unexpected target '%v'", target)
+		t.Fail()
+	}
+	if target := target(RunSummary{Contexts: []string{"a", "b"}}); target != "a,b" {
+		t.Logf("got unexpected target '%v'", target)
+		t.Fail()
+	}
+}
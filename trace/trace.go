@@ -0,0 +1,290 @@
+package trace
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Tracer records the spans of a single Ankh run, and exports them using the
+// OTLP/HTTP JSON encoding so that any OpenTelemetry collector can ingest them.
+//
+// A nil *Tracer is valid, and records nothing. Callers never need to check
+// whether tracing is enabled.
+type Tracer struct {
+	mu          sync.Mutex
+	serviceName string
+	traceID     string
+	spans       []*Span
+	active      []*Span
+}
+
+type Span struct {
+	tracer       *Tracer
+	name         string
+	spanID       string
+	parentSpanID string
+	start, end   time.Time
+	attributes   map[string]string
+	err          error
+}
+
+func randomID(size int) string {
+	b := make([]byte, size)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func NewTracer(serviceName string) *Tracer {
+	return &Tracer{
+		serviceName: serviceName,
+		traceID:     randomID(16),
+	}
+}
+
+func (t *Tracer) startSpan(name string, attributes map[string]string, nest bool) *Span {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	span := &Span{
+		tracer:     t,
+		name:       name,
+		spanID:     randomID(8),
+		start:      time.Now(),
+		attributes: attributes,
+	}
+	if len(t.active) > 0 {
+		span.parentSpanID = t.active[len(t.active)-1].spanID
+	}
+	t.spans = append(t.spans, span)
+	if nest {
+		t.active = append(t.active, span)
+	}
+	return span
+}
+
+// Start begins a span as a child of the innermost span that has not yet ended.
+// Spans started after this one, and before it ends, are its children.
+func (t *Tracer) Start(name string, attributes map[string]string) *Span {
+	return t.startSpan(name, attributes, true)
+}
+
+// End completes the span, recording err (if any) as its status.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+
+	t := s.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !s.end.IsZero() {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+
+	for i := len(t.active) - 1; i >= 0; i-- {
+		if t.active[i] == s {
+			t.active = t.active[:i]
+			break
+		}
+	}
+}
+
+// SetAttribute adds an attribute to the span after it has started.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+
+	if s.attributes == nil {
+		s.attributes = map[string]string{}
+	}
+	s.attributes[key] = value
+}
+
+type transport struct {
+	tracer *Tracer
+	base   http.RoundTripper
+}
+
+func (rt transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	span := rt.tracer.startSpan(fmt.Sprintf("HTTP %v", req.Method), map[string]string{
+		"http.method": req.Method,
+		"http.url":    req.URL.String(),
+	}, false)
+
+	resp, err := rt.base.RoundTrip(req)
+	if err == nil {
+		span.SetAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+		if resp.StatusCode >= 500 {
+			err = fmt.Errorf("%v", resp.Status)
+			span.End(err)
+			return resp, nil
+		}
+	}
+	span.End(err)
+	return resp, err
+}
+
+// Transport wraps base so that every HTTP request made through it is recorded as a span.
+func (t *Tracer) Transport(base http.RoundTripper) http.RoundTripper {
+	if t == nil {
+		return base
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return transport{tracer: t, base: base}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func toAttributes(attributes map[string]string) []otlpAttribute {
+	out := []otlpAttribute{}
+	for key, value := range attributes {
+		out = append(out, otlpAttribute{Key: key, Value: otlpValue{StringValue: value}})
+	}
+	return out
+}
+
+// Encodes all spans recorded so far. Spans that have not ended are ended now.
+func (t *Tracer) encode() ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	scopeSpans := otlpScopeSpans{}
+	scopeSpans.Scope.Name = "ankh"
+	for _, span := range t.spans {
+		end := span.end
+		if end.IsZero() {
+			end = now
+		}
+
+		// Status codes are UNSET (0), OK (1), and ERROR (2), and the span kind
+		// is INTERNAL (1) or CLIENT (3).
+		status := otlpStatus{Code: 1}
+		if span.err != nil {
+			status = otlpStatus{Code: 2, Message: span.err.Error()}
+		}
+		kind := 1
+		if _, ok := span.attributes["http.url"]; ok {
+			kind = 3
+		}
+
+		scopeSpans.Spans = append(scopeSpans.Spans, otlpSpan{
+			TraceID:           t.traceID,
+			SpanID:            span.spanID,
+			ParentSpanID:      span.parentSpanID,
+			Name:              span.name,
+			Kind:              kind,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+			Attributes:        toAttributes(span.attributes),
+			Status:            status,
+		})
+	}
+
+	resourceSpans := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scopeSpans}}
+	resourceSpans.Resource.Attributes = toAttributes(map[string]string{"service.name": t.serviceName})
+
+	return json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{resourceSpans}})
+}
+
+// Export sends the recorded spans to an OTLP/HTTP endpoint, writes them to a
+// local file, or both. Empty values are skipped.
+func (t *Tracer) Export(endpoint, file string) error {
+	if t == nil || (endpoint == "" && file == "") {
+		return nil
+	}
+
+	body, err := t.encode()
+	if err != nil {
+		return err
+	}
+
+	if file != "" {
+		if err := ioutil.WriteFile(file, append(body, '\n'), 0644); err != nil {
+			return fmt.Errorf("Unable to write traces to file '%v': %v", file, err)
+		}
+	}
+
+	if endpoint != "" {
+		url := endpoint
+		if !strings.HasSuffix(url, "/v1/traces") {
+			url = strings.TrimRight(url, "/") + "/v1/traces"
+		}
+
+		client := &http.Client{Timeout: time.Duration(5 * time.Second)}
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("Unable to export traces to '%v': %v", url, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("Received HTTP status '%v' when exporting traces to '%v'", resp.Status, url)
+		}
+	}
+
+	return nil
+}
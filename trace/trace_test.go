@@ -0,0 +1,74 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	t.Run("nil tracer records nothing", func(t *testing.T) {
+		var tracer *Tracer
+		span := tracer.Start("noop", nil)
+		span.SetAttribute("key", "value")
+		span.End(nil)
+
+		if err := tracer.Export("", "/does/not/matter"); err != nil {
+			t.Log(err)
+			t.Fail()
+		}
+	})
+
+	t.Run("exports nested spans to a file", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "")
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+		}
+		file.Close()
+		defer os.Remove(file.Name())
+
+		tracer := NewTracer("ankh")
+		root := tracer.Start("root", nil)
+		child := tracer.Start("child", map[string]string{"chart": "foo"})
+		child.End(fmt.Errorf("failed"))
+		sibling := tracer.Start("sibling", nil)
+		sibling.End(nil)
+		root.End(nil)
+
+		if err := tracer.Export("", file.Name()); err != nil {
+			t.Log(err)
+			t.Fail()
+		}
+
+		body, err := ioutil.ReadFile(file.Name())
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+		}
+
+		traces := otlpTraces{}
+		if err := json.Unmarshal(body, &traces); err != nil {
+			t.Log(err)
+			t.Fail()
+		}
+
+		spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+		if len(spans) != 3 {
+			t.Logf("expected 3 spans but got %v", len(spans))
+			t.FailNow()
+		}
+
+		if spans[1].ParentSpanID != spans[0].SpanID || spans[2].ParentSpanID != spans[0].SpanID {
+			t.Logf("expected child and sibling to be parented by root, got %+v", spans)
+			t.Fail()
+		}
+
+		if spans[1].Status.Code != 2 || spans[1].Status.Message != "failed" {
+			t.Logf("expected an error status on child but got %+v", spans[1].Status)
+			t.Fail()
+		}
+	})
+}
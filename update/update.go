@@ -0,0 +1,318 @@
+package update
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-semver/semver"
+
+	"github.com/appnexus/ankh/context"
+	"github.com/appnexus/ankh/util"
+)
+
+const (
+	DefaultURL    = "https://api.github.com/repos/appnexus/ankh/releases"
+	StableChannel = "stable"
+	BetaChannel   = "beta"
+
+	checksumsAssetName = "sha256sums.txt"
+)
+
+type GitHubAsset struct {
+	Name               string `json:"name"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+}
+
+// The shape of a release from the GitHub releases API. Orgs that distribute
+// their own builds should publish a list of releases in this same shape.
+type GitHubRelease struct {
+	TagName    string        `json:"tag_name"`
+	Draft      bool          `json:"draft"`
+	Prerelease bool          `json:"prerelease"`
+	Assets     []GitHubAsset `json:"assets"`
+}
+
+func (release *GitHubRelease) assetURL(name string) string {
+	for _, asset := range release.Assets {
+		if asset.Name == name {
+			return asset.BrowserDownloadURL
+		}
+	}
+	return ""
+}
+
+func parseVersion(version string) (*semver.Version, error) {
+	return semver.NewVersion(strings.TrimPrefix(version, "v"))
+}
+
+func tarballName() string {
+	return fmt.Sprintf("ankh-%v-%v.tar.gz", runtime.GOOS, runtime.GOARCH)
+}
+
+func getURL(url string) ([]byte, error) {
+	client := &http.Client{Timeout: time.Duration(60 * time.Second)}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("got an error %v when trying to call %v", err, url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Received HTTP status '%v' (code %v) when trying to call %s", resp.Status, resp.StatusCode, url)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Selects the newest release on a channel. Beta includes prereleases, stable does not.
+func selectRelease(releases []GitHubRelease, channel string) (*GitHubRelease, error) {
+	if channel != StableChannel && channel != BetaChannel {
+		return nil, fmt.Errorf("Unknown channel '%v'. Must be one of '%v' or '%v'", channel, StableChannel, BetaChannel)
+	}
+
+	var latest *GitHubRelease
+	var latestVersion *semver.Version
+	for i := range releases {
+		release := &releases[i]
+		if release.Draft || (release.Prerelease && channel != BetaChannel) {
+			continue
+		}
+
+		version, err := parseVersion(release.TagName)
+		if err != nil {
+			continue
+		}
+		if latestVersion == nil || latestVersion.LessThan(*version) {
+			latest, latestVersion = release, version
+		}
+	}
+
+	if latest == nil {
+		return nil, fmt.Errorf("No releases found on the '%v' channel", channel)
+	}
+	return latest, nil
+}
+
+func LatestRelease(ctx *ankh.ExecutionContext, channel string) (*GitHubRelease, error) {
+	url := ctx.AnkhConfig.Update.URL
+	if url == "" {
+		url = DefaultURL
+	}
+
+	ctx.Logger.Debugf("Fetching releases from %v", url)
+	body, err := getURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	releases := []GitHubRelease{}
+	if err := json.Unmarshal(body, &releases); err != nil {
+		return nil, fmt.Errorf("Could not parse releases from %v: %v", url, err)
+	}
+
+	return selectRelease(releases, channel)
+}
+
+// Finds the checksum for a file in `sha256sum` formatted output.
+func findChecksum(checksums []byte, name string) string {
+	for _, line := range strings.Split(string(checksums), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return fields[0]
+		}
+	}
+	return ""
+}
+
+// Downloads the release tarball for this platform and verifies it against the
+// release checksums, and against the checksums' signature when a public key is configured.
+func downloadVerifiedTarball(ctx *ankh.ExecutionContext, release *GitHubRelease) ([]byte, error) {
+	name := tarballName()
+	tarballURL := release.assetURL(name)
+	if tarballURL == "" {
+		return nil, fmt.Errorf("Release %v has no build for this platform (%v)", release.TagName, name)
+	}
+
+	checksumsURL := release.assetURL(checksumsAssetName)
+	if checksumsURL == "" {
+		return nil, fmt.Errorf("Release %v has no %v, refusing to install an unverified build", release.TagName, checksumsAssetName)
+	}
+	checksums, err := getURL(checksumsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if publicKey := ctx.AnkhConfig.Update.PublicKey; publicKey != "" {
+		key, err := base64.StdEncoding.DecodeString(publicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("`update.publicKey` must be a base64 encoded ed25519 public key")
+		}
+
+		signatureURL := release.assetURL(checksumsAssetName + ".sig")
+		if signatureURL == "" {
+			return nil, fmt.Errorf("Release %v has no %v.sig, but `update.publicKey` requires one", release.TagName, checksumsAssetName)
+		}
+		body, err := getURL(signatureURL)
+		if err != nil {
+			return nil, err
+		}
+		signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(body)))
+		if err != nil {
+			return nil, fmt.Errorf("Could not decode signature %v: %v", signatureURL, err)
+		}
+		if !ed25519.Verify(ed25519.PublicKey(key), checksums, signature) {
+			return nil, fmt.Errorf("Signature verification failed for %v", checksumsURL)
+		}
+		ctx.Logger.Infof("Verified signature of %v", checksumsAssetName)
+	}
+
+	expected := findChecksum(checksums, name)
+	if expected == "" {
+		return nil, fmt.Errorf("No checksum for %v found in %v", name, checksumsURL)
+	}
+
+	ctx.Logger.Infof("Downloading %v", tarballURL)
+	tarball, err := getURL(tarballURL)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(tarball)
+	if actual := hex.EncodeToString(sum[:]); actual != strings.ToLower(expected) {
+		return nil, fmt.Errorf("Checksum mismatch for %v: expected %v but got %v", name, expected, actual)
+	}
+	ctx.Logger.Infof("Verified checksum of %v", name)
+
+	return tarball, nil
+}
+
+// Replaces the running binary with the `ankh` binary from tarball. The new binary is
+// written next to the current one and renamed over it, so a failure never leaves
+// a partially written binary behind.
+func replaceExecutable(tarball []byte) (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	executable, err = filepath.EvalSymlinks(executable)
+	if err != nil {
+		return "", err
+	}
+
+	tmpDir, err := ioutil.TempDir(filepath.Dir(executable), ".ankh-update-")
+	if err != nil {
+		return "", fmt.Errorf("Unable to write to %v: %v", filepath.Dir(executable), err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := util.Untar(tmpDir, bytes.NewReader(tarball)); err != nil {
+		return "", err
+	}
+
+	newExecutable := filepath.Join(tmpDir, "ankh")
+	if _, err := os.Stat(newExecutable); err != nil {
+		return "", fmt.Errorf("Release tarball does not contain an `ankh` binary")
+	}
+	if err := os.Chmod(newExecutable, 0755); err != nil {
+		return "", err
+	}
+
+	return executable, os.Rename(newExecutable, executable)
+}
+
+// SelfUpdate replaces the running binary with the newest release on channel, if it
+// is newer than currentVersion. With checkOnly, it only reports what it would do.
+func SelfUpdate(ctx *ankh.ExecutionContext, currentVersion string, channel string, checkOnly bool) error {
+	if ctx.AnkhConfig.Update.Disabled {
+		return fmt.Errorf("Self-update is disabled by `update.disabled` in your Ankh config. Update Ankh the way your organization distributes it.")
+	}
+
+	if channel == "" {
+		channel = ctx.AnkhConfig.Update.Channel
+	}
+	if channel == "" {
+		channel = StableChannel
+	}
+
+	release, err := LatestRelease(ctx, channel)
+	if err != nil {
+		return err
+	}
+
+	current, err := parseVersion(currentVersion)
+	if err != nil {
+		ctx.Logger.Infof("Current version \"%v\" is not a release version, will install %v", currentVersion, release.TagName)
+	} else if latest, _ := parseVersion(release.TagName); !current.LessThan(*latest) {
+		ctx.Logger.Infof("Already up to date: version %v is the latest on the '%v' channel", currentVersion, channel)
+		return nil
+	}
+
+	if checkOnly {
+		ctx.Logger.Infof("Version %v is available on the '%v' channel (current version %v)", release.TagName, channel, currentVersion)
+		return nil
+	}
+
+	tarball, err := downloadVerifiedTarball(ctx, release)
+	if err != nil {
+		return err
+	}
+
+	executable, err := replaceExecutable(tarball)
+	if err != nil {
+		return fmt.Errorf("Unable to replace the ankh binary: %v", err)
+	}
+	ctx.Logger.Infof("Updated %v from version %v to %v", executable, currentVersion, release.TagName)
+	return nil
+}
+
+// HigherVersion returns whichever of a and b is the higher version. Values that
+// are not versions lose to those that are.
+func HigherVersion(a, b string) string {
+	aVersion, aErr := parseVersion(a)
+	bVersion, bErr := parseVersion(b)
+	if aErr != nil {
+		return b
+	}
+	if bErr != nil || !aVersion.LessThan(*bVersion) {
+		return a
+	}
+	return b
+}
+
+// CheckMinimumVersion returns an error if currentVersion is older than the
+// `minimumAnkhVersion` from the Ankh config. Builds without a release version
+// are assumed to be new enough.
+func CheckMinimumVersion(ctx *ankh.ExecutionContext, currentVersion string) error {
+	minimum := ctx.AnkhConfig.MinimumAnkhVersion
+	if minimum == "" {
+		return nil
+	}
+
+	minimumVersion, err := parseVersion(minimum)
+	if err != nil {
+		return fmt.Errorf("Invalid `minimumAnkhVersion` '%v' in Ankh config: %v", minimum, err)
+	}
+
+	current, err := parseVersion(currentVersion)
+	if err != nil {
+		ctx.Logger.Debugf("Not enforcing `minimumAnkhVersion` %v since the current version \"%v\" is not a release version", minimum, currentVersion)
+		return nil
+	}
+
+	if current.LessThan(*minimumVersion) {
+		return fmt.Errorf("Your Ankh config requires Ankh version %v or newer, but this is version %v. "+
+			"Run `ankh self-update` to update.", minimum, currentVersion)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package update
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/appnexus/ankh/context"
+)
+
+func TestSelectRelease(t *testing.T) {
+	releases := []GitHubRelease{
+		GitHubRelease{TagName: "v1.2.0"},
+		GitHubRelease{TagName: "v1.3.0-rc1", Prerelease: true},
+		GitHubRelease{TagName: "v1.10.0"},
+		GitHubRelease{TagName: "v2.0.0", Draft: true},
+		GitHubRelease{TagName: "not-a-version"},
+	}
+
+	t.Run("stable skips prereleases and drafts", func(t *testing.T) {
+		release, err := selectRelease(releases, StableChannel)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if release.TagName != "v1.10.0" {
+			t.Logf("expected v1.10.0 but got %v", release.TagName)
+			t.Fail()
+		}
+	})
+
+	t.Run("beta includes prereleases", func(t *testing.T) {
+		release, err := selectRelease([]GitHubRelease{releases[0], releases[1]}, BetaChannel)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if release.TagName != "v1.3.0-rc1" {
+			t.Logf("expected v1.3.0-rc1 but got %v", release.TagName)
+			t.Fail()
+		}
+	})
+
+	t.Run("unknown channel", func(t *testing.T) {
+		_, err := selectRelease(releases, "nightly")
+		if err == nil {
+			t.Log("expected an error for an unknown channel")
+			t.Fail()
+		}
+	})
+}
+
+func TestFindChecksum(t *testing.T) {
+	checksums := []byte("abc123  ankh-linux-amd64.tar.gz\ndef456 *ankh-darwin-amd64.tar.gz\n")
+
+	if sum := findChecksum(checksums, "ankh-darwin-amd64.tar.gz"); sum != "def456" {
+		t.Logf("expected def456 but got '%v'", sum)
+		t.Fail()
+	}
+
+	if sum := findChecksum(checksums, "ankh-windows-amd64.tar.gz"); sum != "" {
+		t.Logf("expected no checksum but got '%v'", sum)
+		t.Fail()
+	}
+}
+
+func TestHigherVersion(t *testing.T) {
+	cases := [][]string{
+		{"", "v1.2.0", "v1.2.0"},
+		{"v1.2.0", "", "v1.2.0"},
+		{"v1.10.0", "v1.9.0", "v1.10.0"},
+		{"1.2.0", "v1.3.0", "v1.3.0"},
+	}
+	for _, c := range cases {
+		if higher := HigherVersion(c[0], c[1]); higher != c[2] {
+			t.Logf("expected HigherVersion(%v, %v) to be %v but got %v", c[0], c[1], c[2], higher)
+			t.Fail()
+		}
+	}
+}
+
+func TestCheckMinimumVersion(t *testing.T) {
+	ctx := &ankh.ExecutionContext{Logger: logrus.New()}
+	ctx.AnkhConfig.MinimumAnkhVersion = "v1.5.0"
+
+	if err := CheckMinimumVersion(ctx, "v1.4.9"); err == nil {
+		t.Log("expected an error for an older version")
+		t.Fail()
+	}
+
+	for _, version := range []string{"v1.5.0", "v2.0.0", "DEVELOPMENT"} {
+		if err := CheckMinimumVersion(ctx, version); err != nil {
+			t.Logf("expected version %v to be allowed, got %v", version, err)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package util
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok && len(exitError.Stderr) > 0 {
+			return "", fmt.Errorf("`git %v` failed: %v", strings.Join(args, " "), strings.TrimSpace(string(exitError.Stderr)))
+		}
+		return "", fmt.Errorf("`git %v` failed: %v", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// GitShaTag returns the short sha of HEAD in the git repository containing
+// dir, and whether the working tree has uncommitted changes. When it does, and
+// dirtySuffix is not empty, the suffix is appended to the tag.
+func GitShaTag(dir string, dirtySuffix string) (string, bool, error) {
+	sha, err := git(dir, "rev-parse", "--short", "HEAD")
+	if err != nil {
+		return "", false, err
+	}
+
+	status, err := git(dir, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return "", false, err
+	}
+	dirty := status != ""
+	if dirty {
+		sha += dirtySuffix
+	}
+	return sha, dirty, nil
+}
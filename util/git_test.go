@@ -0,0 +1,56 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestGitShaTag(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+
+	dir, err := ioutil.TempDir("", "ankh-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	run := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=ankh", "-c", "user.email=ankh@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	run("init", "-q")
+	ioutil.WriteFile(filepath.Join(dir, "README"), []byte("hello\n"), 0644)
+	run("add", "README")
+	run("commit", "-q", "-m", "first")
+
+	sha, err := git(dir, "rev-parse", "--short", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tag, dirty, err := GitShaTag(dir, "-dirty")
+	if err != nil || dirty || tag != sha {
+		t.Logf("expected clean tag '%v' but got '%v' (dirty: %v) and error %v", sha, tag, dirty, err)
+		t.Fail()
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "README"), []byte("changed\n"), 0644)
+	tag, dirty, err = GitShaTag(dir, "-dirty")
+	if err != nil || !dirty || tag != sha+"-dirty" {
+		t.Logf("expected dirty tag '%v-dirty' but got '%v' (dirty: %v) and error %v", sha, tag, dirty, err)
+		t.Fail()
+	}
+
+	if _, _, err := GitShaTag(os.TempDir(), ""); err == nil {
+		t.Logf("expected an error outside of a git repository")
+		t.Fail()
+	}
+}
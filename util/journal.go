@@ -0,0 +1,210 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/manifoldco/promptui"
+	"gopkg.in/yaml.v2"
+)
+
+// fzf exits with this code when interrupted.
+const fzfInterruptedExitCode = 130
+
+// An answer given to a prompt.
+type PromptAnswer struct {
+	Label  string `yaml:"label"`
+	Answer string `yaml:"answer"`
+}
+
+// A PromptJournal records the answers given to prompts during a run. If the
+// run is interrupted at a prompt, the journal is saved, so that running the
+// same command again can resume with the same answers, and the run's
+// interrupt handlers clean up after it.
+type PromptJournal struct {
+	path string
+
+	Command     []string       `yaml:"command"`
+	Dir         string         `yaml:"dir"`
+	Interrupted time.Time      `yaml:"interrupted"`
+	Answers     []PromptAnswer `yaml:"answers"`
+
+	mu          sync.Mutex
+	resume      []PromptAnswer
+	onInterrupt []func()
+}
+
+var promptJournal *PromptJournal
+
+// NewPromptJournal returns a journal for a run of `command` in `dir`, saved
+// to `path` when the run is interrupted.
+func NewPromptJournal(path string, command []string, dir string) *PromptJournal {
+	return &PromptJournal{path: path, Command: command, Dir: dir}
+}
+
+// SetPromptJournal records the answers to prompts in `journal`, from now on.
+func SetPromptJournal(journal *PromptJournal) {
+	promptJournal = journal
+}
+
+// FindInterrupted returns the journal of an earlier run of the same command,
+// in the same directory, that was interrupted at a prompt, if any.
+func (journal *PromptJournal) FindInterrupted() (*PromptJournal, error) {
+	body, err := ioutil.ReadFile(journal.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	interrupted := &PromptJournal{path: journal.path}
+	if err := yaml.Unmarshal(body, interrupted); err != nil {
+		return nil, err
+	}
+	if !reflect.DeepEqual(interrupted.Command, journal.Command) || interrupted.Dir != journal.Dir || len(interrupted.Answers) == 0 {
+		return nil, nil
+	}
+	return interrupted, nil
+}
+
+// Resume answers prompts with the answers from `interrupted`, in order, for
+// as long as the prompts are the same as they were.
+func (journal *PromptJournal) Resume(interrupted *PromptJournal) {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	journal.resume = append([]PromptAnswer{}, interrupted.Answers...)
+}
+
+// Discard removes the saved journal, eg: once it has been resumed.
+func (journal *PromptJournal) Discard() error {
+	if err := os.Remove(journal.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// OnInterrupt registers a function that cleans up after the run if it is
+// interrupted at a prompt, eg: by removing files it has partially written.
+// Functions run in the reverse order that they were registered.
+func (journal *PromptJournal) OnInterrupt(f func()) {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	journal.onInterrupt = append(journal.onInterrupt, f)
+}
+
+// OnPromptInterrupt registers `f` with the current prompt journal, if any.
+func OnPromptInterrupt(f func()) {
+	if promptJournal != nil {
+		promptJournal.OnInterrupt(f)
+	}
+}
+
+// Returns the recorded answer for a prompt when resuming, if the prompt is
+// the next one that was answered, and the answer is still one of `choices`.
+// Once a prompt differs, the rest of the answers are no longer used.
+func (journal *PromptJournal) answer(label string, choices []string) (string, bool) {
+	if journal == nil {
+		return "", false
+	}
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	if len(journal.resume) == 0 {
+		return "", false
+	}
+
+	next := journal.resume[0]
+	journal.resume = journal.resume[1:]
+	if next.Label == label && (choices == nil || contains(choices, next.Answer)) {
+		// Answers are recorded again, in case this run is interrupted, too.
+		journal.Answers = append(journal.Answers, next)
+		fmt.Printf("%v: %v (answered in the interrupted run)\n", label, next.Answer)
+		return next.Answer, true
+	}
+	journal.resume = nil
+	return "", false
+}
+
+func (journal *PromptJournal) record(label string, answer string) {
+	if journal == nil {
+		return
+	}
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	journal.Answers = append(journal.Answers, PromptAnswer{Label: label, Answer: answer})
+}
+
+// Saves the journal and runs the interrupt handlers, if `err` is from an
+// interrupted prompt.
+func (journal *PromptJournal) checkInterrupted(err error) {
+	if journal == nil || !isPromptInterrupted(err) {
+		return
+	}
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	for i := len(journal.onInterrupt) - 1; i >= 0; i-- {
+		journal.onInterrupt[i]()
+	}
+	journal.onInterrupt = nil
+
+	if len(journal.Answers) == 0 {
+		return
+	}
+	journal.Interrupted = time.Now()
+	body, err := yaml.Marshal(journal)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(journal.path), 0755); err != nil {
+		return
+	}
+	if err := WriteFileAtomic(journal.path, body, 0644); err == nil {
+		fmt.Fprintf(os.Stderr, "\nInterrupted. Run the same command again to resume with the answers given so far.\n")
+	}
+}
+
+func isPromptInterrupted(err error) bool {
+	if err == promptui.ErrInterrupt {
+		return true
+	}
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr) && exitErr.ExitCode() == fzfInterruptedExitCode
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteFileAtomic writes a file by way of a temporary file in the same
+// directory, so that an interrupted write never leaves it partially written.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
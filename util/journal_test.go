@@ -0,0 +1,104 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/manifoldco/promptui"
+)
+
+func TestPromptJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "prompt-journal.yaml")
+	command := []string{"apply", "--chart", "foo"}
+
+	journal := NewPromptJournal(path, command, dir)
+	cleanedUp := []string{}
+	journal.OnInterrupt(func() { cleanedUp = append(cleanedUp, "first") })
+	journal.OnInterrupt(func() { cleanedUp = append(cleanedUp, "second") })
+	journal.record("Select a version for chart \"foo\"", "1.0.0")
+	journal.record("Select a namespace", "web")
+
+	// Errors other than interrupts leave nothing behind.
+	journal.checkInterrupted(os.ErrNotExist)
+	if _, err := os.Stat(path); !os.IsNotExist(err) || len(cleanedUp) != 0 {
+		t.Fatalf("expected no journal to be saved without an interrupt")
+	}
+
+	journal.checkInterrupted(promptui.ErrInterrupt)
+	if len(cleanedUp) != 2 || cleanedUp[0] != "second" {
+		t.Logf("expected the interrupt handlers to run in reverse order but got %v", cleanedUp)
+		t.Fail()
+	}
+
+	if interrupted, err := NewPromptJournal(path, []string{"apply"}, dir).FindInterrupted(); err != nil || interrupted != nil {
+		t.Logf("expected the journal of another command not to be resumed, but got %+v and %v", interrupted, err)
+		t.Fail()
+	}
+
+	resumed := NewPromptJournal(path, command, dir)
+	interrupted, err := resumed.FindInterrupted()
+	if err != nil || interrupted == nil || len(interrupted.Answers) != 2 {
+		t.Fatalf("expected the interrupted journal but got %+v and %v", interrupted, err)
+	}
+	resumed.Resume(interrupted)
+
+	if answer, ok := resumed.answer("Select a version for chart \"foo\"", []string{"1.1.0", "1.0.0"}); !ok || answer != "1.0.0" {
+		t.Logf("expected the recorded version but got %v", answer)
+		t.Fail()
+	}
+	// Once the prompts differ, the remaining answers are not used.
+	if _, ok := resumed.answer("Select a context", []string{"web"}); ok {
+		t.Logf("expected a different prompt not to be answered")
+		t.Fail()
+	}
+	if _, ok := resumed.answer("Select a namespace", []string{"web"}); ok {
+		t.Logf("expected no answers after the prompts differed")
+		t.Fail()
+	}
+	if len(resumed.Answers) != 1 {
+		t.Logf("expected the resumed answer to be recorded again but got %+v", resumed.Answers)
+		t.Fail()
+	}
+
+	if err := resumed.Discard(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Logf("expected the journal to be removed")
+		t.Fail()
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-atomic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config")
+
+	for _, content := range []string{"first", "second"} {
+		if err := WriteFileAtomic(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if body, err := ioutil.ReadFile(path); err != nil || string(body) != content {
+			t.Logf("expected %q but got %q and %v", content, body, err)
+			t.Fail()
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Logf("expected the file mode to be set, but got %v", info.Mode())
+		t.Fail()
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Logf("expected no temporary files to be left behind but got %v", len(files))
+		t.Fail()
+	}
+}
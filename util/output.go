@@ -0,0 +1,43 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Machine-readable output formats, for `--output`. The empty format is the
+// default, human-readable table.
+const (
+	OutputJSON = "json"
+	OutputYAML = "yaml"
+)
+
+func ValidateOutputFormat(format string) error {
+	switch format {
+	case "", OutputJSON, OutputYAML:
+		return nil
+	}
+	return fmt.Errorf("Invalid output format '%v'. Must be one of `json` or `yaml`", format)
+}
+
+// FormatOutput encodes a listing as JSON or YAML. Listings should tag their
+// fields for both, so that field names are the same in either format.
+func FormatOutput(format string, v interface{}) (string, error) {
+	switch format {
+	case OutputJSON:
+		out, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(out) + "\n", nil
+	case OutputYAML:
+		out, err := yaml.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(out), nil
+	}
+	return "", ValidateOutputFormat(format)
+}
@@ -0,0 +1,99 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// A profile that sets `extends` starts from the values of the profile it
+// names, and merges its own `overrides` on top, eg:
+//
+//	large:
+//	  extends: medium
+//	  overrides:
+//	    replicas: 5
+const (
+	profileExtendsKey   = "extends"
+	profileOverridesKey = "overrides"
+)
+
+// ResolveProfile returns the values of the named profile, as found by lookup,
+// following `extends` through any number of base profiles. Values of a profile
+// that does not set `extends` are returned as is. Lookup returns nil for a
+// profile that does not exist.
+func ResolveProfile(name string, lookup func(name string) (interface{}, error)) (interface{}, error) {
+	return resolveProfile(name, lookup, []string{})
+}
+
+func resolveProfile(name string, lookup func(name string) (interface{}, error), chain []string) (interface{}, error) {
+	for _, seen := range chain {
+		if seen == name {
+			return nil, fmt.Errorf("resource profile `%v` extends itself: %v", name, strings.Join(append(chain, name), " -> "))
+		}
+	}
+
+	values, err := lookup(name)
+	if err != nil || values == nil {
+		return values, err
+	}
+	profile, ok := values.(yaml.MapSlice)
+	if !ok {
+		return values, nil
+	}
+
+	var overrides interface{}
+	extends := ""
+	for _, item := range profile {
+		switch item.Key {
+		case profileExtendsKey:
+			if extends, ok = item.Value.(string); !ok || extends == "" {
+				return nil, fmt.Errorf("`extends` of resource profile `%v` must be the name of another profile", name)
+			}
+		case profileOverridesKey:
+			overrides = item.Value
+		}
+	}
+	if extends == "" {
+		return values, nil
+	}
+
+	base, err := resolveProfile(extends, lookup, append(chain, name))
+	if err != nil {
+		return nil, err
+	}
+	if base == nil {
+		return nil, fmt.Errorf("resource profile `%v` extends `%v`, which does not exist", name, extends)
+	}
+	return MergeValues(base, overrides), nil
+}
+
+// MergeValues deep merges overrides into base, as helm does with successive
+// values files: maps are merged key by key, and anything else is replaced.
+func MergeValues(base interface{}, overrides interface{}) interface{} {
+	if overrides == nil {
+		return base
+	}
+	baseMap, baseOk := base.(yaml.MapSlice)
+	overridesMap, overridesOk := overrides.(yaml.MapSlice)
+	if !baseOk || !overridesOk {
+		return overrides
+	}
+
+	merged := append(yaml.MapSlice{}, baseMap...)
+	for _, item := range overridesMap {
+		found := false
+		for i := range merged {
+			if merged[i].Key == item.Key {
+				merged[i].Value = MergeValues(merged[i].Value, item.Value)
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
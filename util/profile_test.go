@@ -0,0 +1,70 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const resourceProfilesYAML = `
+small:
+  replicas: 1
+  resources:
+    cpu: 100m
+    memory: 128Mi
+medium:
+  extends: small
+  overrides:
+    resources:
+      cpu: 500m
+large|xlarge:
+  extends: medium
+  overrides:
+    replicas: 5
+loop:
+  extends: loop
+`
+
+func TestResolveProfile(t *testing.T) {
+	profiles := yaml.MapSlice{}
+	if err := yaml.Unmarshal([]byte(resourceProfilesYAML), &profiles); err != nil {
+		t.Fatal(err)
+	}
+	lookup := func(name string) (interface{}, error) {
+		return MapSliceRegexMatch(profiles, name)
+	}
+
+	t.Run("merges overrides over each base profile", func(t *testing.T) {
+		values, err := ResolveProfile("xlarge", lookup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := yaml.Marshal(values)
+		expected := "replicas: 5\nresources:\n  cpu: 500m\n  memory: 128Mi\n"
+		if string(out) != expected {
+			t.Logf("expected %q but got %q", expected, string(out))
+			t.Fail()
+		}
+	})
+
+	t.Run("leaves profiles without extends as is", func(t *testing.T) {
+		values, err := ResolveProfile("small", lookup)
+		if err != nil || len(values.(yaml.MapSlice)) != 2 {
+			t.Logf("expected the small profile but got %v and %v", values, err)
+			t.Fail()
+		}
+		if values, err := ResolveProfile("missing", lookup); values != nil || err != nil {
+			t.Logf("expected nothing for a missing profile but got %v and %v", values, err)
+			t.Fail()
+		}
+	})
+
+	t.Run("fails on cycles", func(t *testing.T) {
+		_, err := ResolveProfile("loop", lookup)
+		if err == nil || !strings.Contains(err.Error(), "extends itself") {
+			t.Logf("expected a cycle error but got %v", err)
+			t.Fail()
+		}
+	})
+}
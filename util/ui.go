@@ -0,0 +1,135 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	ankh "github.com/appnexus/ankh/context"
+	"github.com/mattn/go-isatty"
+)
+
+const defaultPager = "less -R"
+
+// Colors for listings. Every color is empty when color is disabled.
+type ListingColors struct {
+	Header, Newest, Reset string
+
+	// For changes, eg: in `ankh diff`
+	Added, Removed, Changed string
+
+	// Distinct colors, eg: to tell apart output from several pods
+	Palette []string
+}
+
+// GetListingColors returns colors for listings written to stdout, if it is a
+// terminal and color is not disabled by `ui.noColor` or `NO_COLOR`.
+func GetListingColors(ctx *ankh.ExecutionContext) ListingColors {
+	if ctx.AnkhConfig.UI.NoColor || os.Getenv("NO_COLOR") != "" || !isatty.IsTerminal(os.Stdout.Fd()) {
+		return ListingColors{}
+	}
+	return ListingColors{
+		Header:  "\x1B[1m",
+		Newest:  "\x1B[32m",
+		Added:   "\x1B[32m",
+		Removed: "\x1B[31m",
+		Changed: "\x1B[33m",
+		Reset:   "\x1B[0m",
+		Palette: []string{"\x1B[36m", "\x1B[33m", "\x1B[35m", "\x1B[32m", "\x1B[34m", "\x1B[31m",
+			"\x1B[96m", "\x1B[93m", "\x1B[95m", "\x1B[92m", "\x1B[94m", "\x1B[91m"},
+	}
+}
+
+// Color returns the i-th color of the palette, cycling through it.
+func (colors ListingColors) Color(i int) string {
+	if len(colors.Palette) == 0 {
+		return ""
+	}
+	return colors.Palette[i%len(colors.Palette)]
+}
+
+// HighlightNewest joins versions, newest first, highlighting the newest.
+func (colors ListingColors) HighlightNewest(versions []string) string {
+	if len(versions) == 0 {
+		return ""
+	}
+	highlighted := append([]string{colors.Newest + versions[0] + colors.Reset}, versions[1:]...)
+	return strings.Join(highlighted, ", ")
+}
+
+// HighlightHeader highlights the first line of a listing. Apply it after
+// aligning columns, since color codes would otherwise count towards widths.
+func (colors ListingColors) HighlightHeader(listing string) string {
+	if colors.Header == "" {
+		return listing
+	}
+	lines := strings.SplitN(listing, "\n", 2)
+	lines[0] = colors.Header + lines[0] + colors.Reset
+	return strings.Join(lines, "\n")
+}
+
+// Returns the height of the terminal, or 0 if it is unknown.
+func terminalHeight() int {
+	if lines, err := strconv.Atoi(os.Getenv("LINES")); err == nil && lines > 0 {
+		return lines
+	}
+	rows, _ := TerminalSize()
+	return rows
+}
+
+// TerminalSize returns the rows and columns of the controlling terminal, or
+// zeroes if they are unknown.
+func TerminalSize() (int, int) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return 0, 0
+	}
+	defer tty.Close()
+
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, 0
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return 0, 0
+	}
+	rows, _ := strconv.Atoi(fields[0])
+	cols, _ := strconv.Atoi(fields[1])
+	return rows, cols
+}
+
+func shouldPage(output string, isTerminal bool, height int) bool {
+	return isTerminal && height > 0 && strings.Count(output, "\n") >= height
+}
+
+// Page writes output to stdout, through a pager if stdout is a terminal and the
+// output does not fit on the screen, unless disabled by `ui.noPager`.
+func Page(ctx *ankh.ExecutionContext, output string) {
+	if ctx.AnkhConfig.UI.NoPager || !shouldPage(output, isatty.IsTerminal(os.Stdout.Fd()), terminalHeight()) {
+		fmt.Print(output)
+		return
+	}
+
+	pager := ctx.AnkhConfig.UI.Pager
+	if pager == "" {
+		pager = defaultPager
+	}
+	args := strings.Fields(pager)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(output)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		ctx.Logger.Debugf("Unable to start pager `%v`: %v", pager, err)
+		fmt.Print(output)
+		return
+	}
+	if err := cmd.Wait(); err != nil {
+		ctx.Logger.Debugf("Pager `%v` exited with %v", pager, err)
+	}
+}
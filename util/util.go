@@ -13,6 +13,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"sort"
 	"strings"
 	"unicode"
 
@@ -351,6 +352,20 @@ func MapSliceRegexMatch(mapSlice yaml.MapSlice, key string) (interface{}, error)
 }
 
 func CreateReducedYAMLFile(filename, key string, required bool) ([]byte, error) {
+	return createReducedYAMLFile(filename, key, required, MapSliceRegexMatch)
+}
+
+// CreateReducedProfileYAMLFile is like CreateReducedYAMLFile, but resolves
+// profiles that `extends` others. See ResolveProfile.
+func CreateReducedProfileYAMLFile(filename, key string, required bool) ([]byte, error) {
+	return createReducedYAMLFile(filename, key, required, func(in yaml.MapSlice, key string) (interface{}, error) {
+		return ResolveProfile(key, func(name string) (interface{}, error) {
+			return MapSliceRegexMatch(in, name)
+		})
+	})
+}
+
+func createReducedYAMLFile(filename, key string, required bool, match func(yaml.MapSlice, string) (interface{}, error)) ([]byte, error) {
 	in := yaml.MapSlice{}
 	var result []byte
 	inBytes, err := ioutil.ReadFile(filename)
@@ -362,7 +377,7 @@ func CreateReducedYAMLFile(filename, key string, required bool) ([]byte, error)
 		return result, err
 	}
 
-	out, err := MapSliceRegexMatch(in, key)
+	out, err := match(in, key)
 	if err != nil {
 		return result, err
 	}
@@ -398,6 +413,81 @@ func ArrayDedup(a []string) []string {
 	return keys
 }
 
+// Returns the keys of a map of values, sorted, eg: to pass them to helm in a stable order.
+func SortedKeys(values map[string]string) []string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Flattens nested YAML into helm `--set` style keys, eg: `image.tag` or `hosts[0]`.
+func flattenSetValues(prefix string, value interface{}, values map[string]string) {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		for _, item := range v {
+			key := fmt.Sprintf("%v", item.Key)
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flattenSetValues(key, item.Value, values)
+		}
+	case []interface{}:
+		for i, item := range v {
+			flattenSetValues(fmt.Sprintf("%v[%d]", prefix, i), item, values)
+		}
+	case nil:
+		values[prefix] = "null"
+	default:
+		// helm splits `--set` arguments on commas
+		values[prefix] = strings.Replace(fmt.Sprintf("%v", v), ",", "\\,", -1)
+	}
+}
+
+// ReadSetValuesFile reads helm `--set` values from a file, eg: one written by a
+// previous CI step. The file may hold a YAML map, whose nested keys are flattened,
+// or `key=value` lines. Given `key=path`, the file's entire contents are used as
+// the value for key instead.
+func ReadSetValuesFile(arg string) (map[string]string, error) {
+	values := map[string]string{}
+
+	key, filename := "", arg
+	if kv := strings.SplitN(arg, "=", 2); len(kv) == 2 {
+		key, filename = kv[0], kv[1]
+	}
+
+	body, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return values, fmt.Errorf("Unable to read set values file '%v': %v", filename, err)
+	}
+
+	if key != "" {
+		values[key] = strings.TrimSpace(string(body))
+		return values, nil
+	}
+
+	mapSlice := yaml.MapSlice{}
+	if err := yaml.Unmarshal(body, &mapSlice); err == nil && len(mapSlice) > 0 {
+		flattenSetValues("", mapSlice, values)
+		return values, nil
+	}
+
+	for i, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(line, "export "), "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return values, fmt.Errorf("Malformed line %v in set values file '%v'. Expected a YAML map or 'key=value' lines", i+1, filename)
+		}
+		values[strings.TrimSpace(kv[0])] = strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+	}
+	return values, nil
+}
+
 func compareTokens(t1, t2 string) int {
 	// split on most things are are not a numeric. this will
 	// allow us to mostly compare by parsed numbers, and
@@ -471,15 +561,21 @@ func PromptForUsernameWithLabel(label string) (string, error) {
 		return "", err
 	}
 
+	if answer, ok := promptJournal.answer(label, nil); ok {
+		return answer, nil
+	}
 	user_prompt := promptui.Prompt{
 		Label:   label,
 		Default: current_user.Username,
 	}
 	username, err := user_prompt.Run()
 	if err != nil {
+		promptJournal.checkInterrupted(err)
 		return "", err
 	}
-	return strings.Trim(username, " "), nil
+	username = strings.Trim(username, " ")
+	promptJournal.record(label, username)
+	return username, nil
 }
 
 func PromptForPasswordWithLabel(label string) (string, error) {
@@ -487,8 +583,10 @@ func PromptForPasswordWithLabel(label string) (string, error) {
 		Label: label,
 		Mask:  '*',
 	}
+	// Passwords are never recorded in the prompt journal.
 	password, err := passwordPrompt.Run()
 	if err != nil {
+		promptJournal.checkInterrupted(err)
 		return "", err
 	}
 
@@ -496,6 +594,9 @@ func PromptForPasswordWithLabel(label string) (string, error) {
 }
 
 func PromptForInput(defaultValue string, label string) (string, error) {
+	if answer, ok := promptJournal.answer(label, nil); ok {
+		return answer, nil
+	}
 	prompt := promptui.Prompt{
 		Label:   label,
 		Default: defaultValue,
@@ -503,8 +604,10 @@ func PromptForInput(defaultValue string, label string) (string, error) {
 
 	input, err := prompt.Run()
 	if err != nil {
+		promptJournal.checkInterrupted(err)
 		return "", err
 	}
+	promptJournal.record(label, input)
 	return input, nil
 }
 
@@ -563,7 +666,7 @@ func promptForSelection(choices []string, label string, firstRowHeader bool) (st
 	return choice, nil
 }
 
-func hasFzf() bool {
+func HasFzf() bool {
 	cmd := exec.Command("fzf", "-h")
 	if err := cmd.Run(); err != nil {
 		return false
@@ -572,15 +675,41 @@ func hasFzf() bool {
 	return true
 }
 
-func PromptForSelection(choices []string, label string, firstRowHeader bool) (string, error) {
-	if hasFzf() {
-		return promptForSelectionFzf(choices, label, firstRowHeader)
+func promptForSelectionAnyway(choices []string, label string, firstRowHeader bool) (string, error) {
+	var selection string
+	var err error
+	if HasFzf() {
+		selection, err = promptForSelectionFzf(choices, label, firstRowHeader)
 	} else {
-		return promptForSelection(choices, label, firstRowHeader)
+		selection, err = promptForSelection(choices, label, firstRowHeader)
+	}
+	promptJournal.checkInterrupted(err)
+	return selection, err
+}
+
+func PromptForSelection(choices []string, label string, firstRowHeader bool) (string, error) {
+	if answer, ok := promptJournal.answer(label, choices); ok {
+		return answer, nil
 	}
+	selection, err := promptForSelectionAnyway(choices, label, firstRowHeader)
+	if err != nil {
+		return "", err
+	}
+	promptJournal.record(label, selection)
+	return selection, nil
+}
+
+// PromptForConfirmation is like PromptForSelection, for confirmations, eg:
+// OK or Abort. Confirmations are always asked again when resuming a run that
+// was interrupted.
+func PromptForConfirmation(choices []string, label string) (string, error) {
+	return promptForSelectionAnyway(choices, label, false)
 }
 
 func PromptForSelectionWithAdd(choices []string, label string, addLabel string) (string, error) {
+	if answer, ok := promptJournal.answer(label, nil); ok {
+		return answer, nil
+	}
 	prompt := promptui.SelectWithAdd{
 		Label:    label,
 		Items:    choices,
@@ -589,8 +718,10 @@ func PromptForSelectionWithAdd(choices []string, label string, addLabel string)
 
 	_, choice, err := prompt.Run()
 	if err != nil {
+		promptJournal.checkInterrupted(err)
 		return "", err
 	}
+	promptJournal.record(label, choice)
 	return choice, nil
 }
 
@@ -626,20 +757,30 @@ func GetEnvironmentOrContext(environment string, context string) string {
 	return ""
 }
 
-func NotificationString(notificationFormat string, chart *ankh.Chart, envOrContext string) (string, error) {
-
-	currentUser, err := user.Current()
-	if err != nil {
-		return "", err
+// TargetWithRelease describes a target environment or context for default
+// notification messages, along with the release, if any.
+func TargetWithRelease(envOrContext string, release string) string {
+	if release == "" {
+		return envOrContext
 	}
+	return fmt.Sprintf("%s (release %s)", envOrContext, release)
+}
+
+// The variables of notification formats, in the order that they are substituted.
+var notificationVariableNames = []string{
+	"%USER%", "%CHART_NAME%", "%CHART_VERSION%", "%CHART%", "%VERSION%", "%TARGET%", "%RELEASE%", "%OWNER%", "%DESCRIPTION%", "%DIGEST%",
+}
 
+// NotificationVariables returns the value of each variable that notification
+// formats may use, by name, eg: `%CHART%`.
+func NotificationVariables(chart *ankh.Chart, envOrContext string, release string, deployer string) map[string]string {
 	chartName := chart.Name
 	chartVersion := ""
 	chartString := ""
 	if chart.Path != "" {
 		absChartPath, err := filepath.Abs(chart.Path)
 		if err != nil {
-			return "", nil
+			absChartPath = chart.Path
 		}
 		chartVersion = fmt.Sprintf("%s (local)", absChartPath)
 		chartString = chartVersion
@@ -653,15 +794,39 @@ func NotificationString(notificationFormat string, chart *ankh.Chart, envOrConte
 		version = *chart.Tag
 	}
 
-	result := notificationFormat
-	result = strings.Replace(result, "%USER%", currentUser.Username, -1)
-	result = strings.Replace(result, "%CHART_NAME%", chartName, -1)
-	result = strings.Replace(result, "%CHART_VERSION%", chartVersion, -1)
-	result = strings.Replace(result, "%CHART%", chartString, -1)
-	result = strings.Replace(result, "%VERSION%", version, -1)
-	result = strings.Replace(result, "%TARGET%", envOrContext, -1)
+	owner := ""
+	description := ""
+	if chart.CatalogEntry != nil {
+		owner = chart.CatalogEntry.Owner
+		description = chart.CatalogEntry.Description
+	}
 
-	return result, nil
+	return map[string]string{
+		"%USER%":          deployer,
+		"%CHART_NAME%":    chartName,
+		"%CHART_VERSION%": chartVersion,
+		"%CHART%":         chartString,
+		"%VERSION%":       version,
+		"%TARGET%":        envOrContext,
+		"%RELEASE%":       release,
+		"%OWNER%":         owner,
+		"%DESCRIPTION%":   description,
+		"%DIGEST%":        chart.ImageDigest,
+	}
+}
+
+// ReplaceNotificationVariables substitutes each of `variables` in `format`.
+func ReplaceNotificationVariables(format string, variables map[string]string) string {
+	result := format
+	for _, name := range notificationVariableNames {
+		result = strings.Replace(result, name, variables[name], -1)
+	}
+	return result
+}
+
+func NotificationString(notificationFormat string, chart *ankh.Chart, envOrContext string, release string, deployer string) (string, error) {
+	variables := NotificationVariables(chart, envOrContext, release, deployer)
+	return ReplaceNotificationVariables(notificationFormat, variables), nil
 }
 
 // GenerateName generates a name based on the current working directory or a random name.
@@ -710,3 +875,12 @@ func FilterStrings(strings []string, matcher func(string) bool) (ret []string) {
 	}
 	return
 }
+
+// AnkhExecutable returns the path of the running ankh binary, for running ankh processes.
+func AnkhExecutable() string {
+	executable, err := os.Executable()
+	if err != nil {
+		return os.Args[0]
+	}
+	return executable
+}
@@ -2,7 +2,10 @@ package util
 
 import (
 	"fmt"
-	"os/user"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	ankh "github.com/appnexus/ankh/context"
@@ -124,14 +127,9 @@ func TestNotificationString(t *testing.T) {
 	}
 	envOrContext := "production"
 
-	currentUser, err := user.Current()
-	if err != nil {
-		t.Logf("Unable to get currnet user for test. Error: %v", err)
-		t.Fail()
-	}
-
-	expectedResult := fmt.Sprintf("%v is doing a release of best app ever@1.2.3 version 1.33.7 to production", currentUser.Username)
-	result, err := NotificationString(notificationFormat, chart, envOrContext)
+	deployer := "jdoe"
+	expectedResult := "jdoe is doing a release of best app ever@1.2.3 version 1.33.7 to production"
+	result, err := NotificationString(notificationFormat, chart, envOrContext, "", deployer)
 	if err != nil {
 		t.Logf("Failed to replace message text. Error: %v", err)
 		t.Fail()
@@ -156,7 +154,7 @@ func TestNotificationString(t *testing.T) {
 	envOrContext = "production"
 
 	expectedResult = "Releasing /home/someone/app/helm/app (local) version 1.33.7 to production"
-	result, err = NotificationString(notificationFormat, chart, envOrContext)
+	result, err = NotificationString(notificationFormat, chart, envOrContext, "", deployer)
 	if err != nil {
 		t.Logf("Failed to replace message text. Error: %v", err)
 		t.Fail()
@@ -181,7 +179,7 @@ func TestNotificationString(t *testing.T) {
 	envOrContext = "production"
 
 	expectedResult = "Releasing best app ever chart 1.2.3 version 1.33.7 to production"
-	result, err = NotificationString(notificationFormat, chart, envOrContext)
+	result, err = NotificationString(notificationFormat, chart, envOrContext, "", deployer)
 	if err != nil {
 		t.Logf("Failed to replace message text. Error: %v", err)
 		t.Fail()
@@ -206,7 +204,7 @@ func TestNotificationString(t *testing.T) {
 	envOrContext = "production"
 
 	expectedResult = "Releasing best app ever chart /home/someone/app/helm/app (local) version 1.33.7 to production"
-	result, err = NotificationString(notificationFormat, chart, envOrContext)
+	result, err = NotificationString(notificationFormat, chart, envOrContext, "", deployer)
 	if err != nil {
 		t.Logf("Failed to replace message text. Error: %v", err)
 		t.Fail()
@@ -232,7 +230,29 @@ func TestNotificationString(t *testing.T) {
 	envOrContext = "production"
 
 	expectedResult = "Releasing %CHAT% version 1.33.7 to production"
-	result, err = NotificationString(notificationFormat, chart, envOrContext)
+	result, err = NotificationString(notificationFormat, chart, envOrContext, "", deployer)
+	if err != nil {
+		t.Logf("Failed to replace message text. Error: %v", err)
+		t.Fail()
+	}
+	if result != expectedResult {
+		t.Logf("got %s but was expecting '%s'", result, expectedResult)
+		t.Fail()
+	}
+
+	// -----------------------------------------------------------------
+
+	// replace %OWNER%, %DESCRIPTION% from the service catalog
+
+	notificationFormat = "Releasing %CHART_NAME% (%DESCRIPTION%, owned by %OWNER%)"
+	chart = &ankh.Chart{
+		Name:         "best-app-ever",
+		Version:      "1.2.3",
+		CatalogEntry: &ankh.CatalogEntry{Name: "best-app-ever", Owner: "team-awesome", Description: "The best app"},
+	}
+
+	expectedResult = "Releasing best-app-ever (The best app, owned by team-awesome)"
+	result, err = NotificationString(notificationFormat, chart, envOrContext, "", deployer)
 	if err != nil {
 		t.Logf("Failed to replace message text. Error: %v", err)
 		t.Fail()
@@ -242,4 +262,141 @@ func TestNotificationString(t *testing.T) {
 		t.Fail()
 	}
 
+	// -----------------------------------------------------------------
+
+	// replace %RELEASE%
+
+	notificationFormat = "Releasing %CHART_NAME% to %TARGET% as release %RELEASE%"
+	expectedResult = "Releasing best-app-ever to production as release blue"
+	result, err = NotificationString(notificationFormat, chart, envOrContext, "blue", deployer)
+	if err != nil {
+		t.Logf("Failed to replace message text. Error: %v", err)
+		t.Fail()
+	}
+	if result != expectedResult {
+		t.Logf("got %s but was expecting '%s'", result, expectedResult)
+		t.Fail()
+	}
+
+}
+
+func TestReadSetValuesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ankh-set-values")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name string, body string) string {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	tests := []struct {
+		name     string
+		arg      string
+		expected map[string]string
+	}{
+		{
+			name:     "key=value lines",
+			arg:      write("build.env", "# from the build\ntag=1.2.3\nexport image.repository=\"example/app\"\n\n"),
+			expected: map[string]string{"tag": "1.2.3", "image.repository": "example/app"},
+		},
+		{
+			name:     "yaml map",
+			arg:      write("build-metadata.yaml", "tag: 1.2.3\nimage:\n  repository: example/app\nhosts:\n- a.example.com\nnote: a,b\n"),
+			expected: map[string]string{"tag": "1.2.3", "image.repository": "example/app", "hosts[0]": "a.example.com", "note": "a\\,b"},
+		},
+		{
+			name:     "key=path",
+			arg:      "tag=" + write("image-tag.txt", "1.2.3\n"),
+			expected: map[string]string{"tag": "1.2.3"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := ReadSetValuesFile(test.arg)
+			if err != nil {
+				t.Logf("got error %v", err)
+				t.FailNow()
+			}
+			if len(values) != len(test.expected) {
+				t.Logf("expected %v but got %v", test.expected, values)
+				t.FailNow()
+			}
+			for k, v := range test.expected {
+				if values[k] != v {
+					t.Logf("expected %v=%v but got %v", k, v, values[k])
+					t.Fail()
+				}
+			}
+		})
+	}
+
+	if _, err := ReadSetValuesFile(write("bad.txt", "just a tag\n")); err == nil {
+		t.Logf("expected an error for a malformed file")
+		t.Fail()
+	}
+}
+
+func TestListingColors(t *testing.T) {
+	colors := ListingColors{Header: "<b>", Newest: "<g>", Reset: "</>"}
+	if out := colors.HighlightNewest([]string{"1.2.0", "1.1.0"}); out != "<g>1.2.0</>, 1.1.0" {
+		t.Logf("got unexpected versions '%v'", out)
+		t.Fail()
+	}
+	if out := colors.HighlightHeader("NAME    VERSION(S)\nfoo     1.2.0\n"); out != "<b>NAME    VERSION(S)</>\nfoo     1.2.0\n" {
+		t.Logf("got unexpected listing '%v'", out)
+		t.Fail()
+	}
+
+	// Without color, listings are left as they are.
+	if out := (ListingColors{}).HighlightNewest([]string{"1.2.0", "1.1.0"}); out != "1.2.0, 1.1.0" {
+		t.Logf("got unexpected versions '%v'", out)
+		t.Fail()
+	}
+}
+
+func TestShouldPage(t *testing.T) {
+	output := strings.Repeat("line\n", 30)
+	if !shouldPage(output, true, 24) {
+		t.Log("expected output taller than the terminal to be paged")
+		t.Fail()
+	}
+	if shouldPage(output, true, 50) {
+		t.Log("expected output that fits on the terminal not to be paged")
+		t.Fail()
+	}
+	if shouldPage(output, false, 24) || shouldPage(output, true, 0) {
+		t.Log("expected no paging without a terminal of known height")
+		t.Fail()
+	}
+}
+
+func TestFormatOutput(t *testing.T) {
+	listing := []struct {
+		Name     string   `json:"name" yaml:"name"`
+		Versions []string `json:"versions" yaml:"versions"`
+	}{{Name: "foo", Versions: []string{"1.0.0"}}}
+
+	expected := map[string]string{
+		OutputJSON: "[\n  {\n    \"name\": \"foo\",\n    \"versions\": [\n      \"1.0.0\"\n    ]\n  }\n]\n",
+		OutputYAML: "- name: foo\n  versions:\n  - 1.0.0\n",
+	}
+	for format, want := range expected {
+		out, err := FormatOutput(format, listing)
+		if err != nil || out != want {
+			t.Logf("expected %q for %v but got %q and error %v", want, format, out, err)
+			t.Fail()
+		}
+	}
+
+	if _, err := FormatOutput("xml", listing); err == nil {
+		t.Logf("expected an error for an invalid output format")
+		t.Fail()
+	}
 }
@@ -0,0 +1,128 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+)
+
+// Finds the first version in the output of eg: `kubectl version --client`,
+// which is `Client Version: v1.27.3`, or `version.Info{...GitVersion:"v1.21.0"...}`
+// for older releases, or `helm version --short`, which is `v3.12.0+g4d1f3a9`.
+var toolVersionPattern = regexp.MustCompile(`v?([0-9]+)\.([0-9]+)\.([0-9]+)`)
+
+// ToolVersion returns the version in the output of a tool's version command.
+func ToolVersion(output string) (*semver.Version, error) {
+	match := toolVersionPattern.FindStringSubmatch(output)
+	if match == nil {
+		return nil, fmt.Errorf("no version found in %q", strings.TrimSpace(output))
+	}
+	return semver.NewVersion(strings.Join(match[1:], "."))
+}
+
+type versionBound struct {
+	op      string
+	version semver.Version
+}
+
+func (bound versionBound) satisfiedBy(version semver.Version) bool {
+	switch bound.op {
+	case ">=":
+		return !version.LessThan(bound.version)
+	case ">":
+		return bound.version.LessThan(version)
+	case "<=":
+		return !bound.version.LessThan(version)
+	case "<":
+		return version.LessThan(bound.version)
+	default:
+		return version.Equal(bound.version)
+	}
+}
+
+// Parses a comma separated list of bounds, eg: `>=1.21.0, <1.22.0`. Each bound
+// is one of >=, >, <=, < or = (the default), and a version, where missing
+// minor and patch versions are zero.
+func parseVersionConstraint(constraint string) ([]versionBound, error) {
+	bounds := []versionBound{}
+	for _, part := range strings.Split(constraint, ",") {
+		part = strings.TrimSpace(part)
+		op := ""
+		for _, candidate := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(part, candidate) {
+				op = candidate
+				break
+			}
+		}
+		raw := strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(part, op)), "v")
+		for strings.Count(raw, ".") < 2 && raw != "" {
+			raw += ".0"
+		}
+		version, err := semver.NewVersion(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version constraint '%v': %v", constraint, err)
+		}
+		bounds = append(bounds, versionBound{op: op, version: *version})
+	}
+	return bounds, nil
+}
+
+// ValidateVersionConstraint returns an error if a version constraint, eg:
+// `>=1.21.0, <1.22.0`, cannot be parsed.
+func ValidateVersionConstraint(constraint string) error {
+	_, err := parseVersionConstraint(constraint)
+	return err
+}
+
+// CheckChartVersion returns an error if a chart version, eg: `1.2.0-rc.1`, is
+// a prerelease when only stable versions are allowed, or does not satisfy a
+// version constraint. An empty constraint allows any version.
+func CheckChartVersion(version string, constraint string, stable bool) error {
+	parsed, err := semver.NewVersion(strings.TrimPrefix(version, "v"))
+	if err != nil {
+		return fmt.Errorf("version \"%v\" is not a semantic version: %v", version, err)
+	}
+	if stable && parsed.PreRelease != "" {
+		return fmt.Errorf("version \"%v\" is a prerelease, but only stable versions are allowed", version)
+	}
+	if constraint == "" {
+		return nil
+	}
+	bounds, err := parseVersionConstraint(constraint)
+	if err != nil {
+		return err
+	}
+	for _, bound := range bounds {
+		if !bound.satisfiedBy(*parsed) {
+			return fmt.Errorf("version \"%v\" does not satisfy '%v'", version, constraint)
+		}
+	}
+	return nil
+}
+
+// CheckToolVersion returns an error if the version in the output of a tool's
+// version command does not satisfy the constraint. An empty constraint allows
+// any version.
+func CheckToolVersion(tool string, command string, constraint string, output string) error {
+	if constraint == "" {
+		return nil
+	}
+	bounds, err := parseVersionConstraint(constraint)
+	if err != nil {
+		return err
+	}
+	version, err := ToolVersion(output)
+	if err != nil {
+		return fmt.Errorf("Unable to check that %v (`%v`) satisfies version '%v': %v", tool, command, constraint, err)
+	}
+	for _, bound := range bounds {
+		if !bound.satisfiedBy(*version) {
+			return fmt.Errorf("%v (`%v`) is version %v, but the current context requires '%v'. "+
+				"Install a matching %v, or point `%v.command` at one, globally or for the context.",
+				tool, command, version, constraint, tool, tool)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckToolVersion(t *testing.T) {
+	kubectl121 := `Client Version: version.Info{Major:"1", Minor:"21", GitVersion:"v1.21.14", GitCommit:"0f77da5"}`
+	kubectl127 := "Client Version: v1.27.3\nKustomize Version: v5.0.1\n"
+	helm3 := "v3.12.0+gc9f554d\n"
+
+	for _, c := range []struct {
+		constraint, output string
+		ok                 bool
+	}{
+		{"", "no version here", true},
+		{">=1.21.0, <1.22.0", kubectl121, true},
+		{">=1.21, <1.22", kubectl127, false},
+		{"1.27.3", kubectl127, true},
+		{">1.27.3", kubectl127, false},
+		{"<=3.12", helm3, true},
+		{">=3.8", "v2.16.1", false},
+	} {
+		err := CheckToolVersion("kubectl", "kubectl", c.constraint, c.output)
+		if (err == nil) != c.ok {
+			t.Logf("expected '%v' to be satisfied: %v, by %q, but got %v", c.constraint, c.ok, c.output, err)
+			t.Fail()
+		}
+	}
+
+	if err := CheckToolVersion("kubectl", "kubectl", ">=1.21", "unknown"); err == nil || !strings.Contains(err.Error(), "no version found") {
+		t.Logf("expected an error for output without a version but got %v", err)
+		t.Fail()
+	}
+	if err := ValidateVersionConstraint(">=one"); err == nil {
+		t.Logf("expected an error for an invalid constraint")
+		t.Fail()
+	}
+}
+
+func TestCheckChartVersion(t *testing.T) {
+	for _, c := range []struct {
+		version, constraint string
+		stable, ok          bool
+	}{
+		{"1.2.0", "", false, true},
+		{"1.2.0-rc.1", "", false, true},
+		{"1.2.0-rc.1", "", true, false},
+		{"1.2.0", ">=1.0, <2.0", true, true},
+		{"2.0.0", ">=1.0, <2.0", false, false},
+		{"v1.3.1", ">=1.3", true, true},
+		{"latest", "", true, false},
+	} {
+		err := CheckChartVersion(c.version, c.constraint, c.stable)
+		if (err == nil) != c.ok {
+			t.Logf("expected %v to be allowed by '%v' (stable: %v): %v, but got %v", c.version, c.constraint, c.stable, c.ok, err)
+			t.Fail()
+		}
+	}
+}